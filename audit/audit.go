@@ -0,0 +1,185 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit write audit events of client connections and statements to pluggable sinks.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/XiaoMi/Gaea/logging"
+)
+
+var log = logging.GetSampledLogger("audit")
+
+// types of audit event
+const (
+	EventConnect    = "connect"
+	EventDisconnect = "disconnect"
+	EventQuery      = "query"
+)
+
+const defaultBufferSize = 4096
+
+// Event audit event of client connection or statement
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Namespace  string    `json:"namespace"`
+	User       string    `json:"user"`
+	ClientAddr string    `json:"client_addr"`
+	ConnID     uint32    `json:"conn_id"`
+
+	// only set in query event
+	DB       string   `json:"db,omitempty"`
+	TraceID  string   `json:"trace_id,omitempty"`
+	StmtType string   `json:"stmt_type,omitempty"`
+	SQL      string   `json:"sql,omitempty"`
+	Tables   []string `json:"tables,omitempty"` // tables in statement, db.table if db is specified
+	Shards   []string `json:"shards,omitempty"` // slice:db the statement is routed to
+	Rows     int64    `json:"rows"`
+	CostMs   int64    `json:"cost_ms"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Encode encode event to json
+func (e *Event) Encode() []byte {
+	b, _ := json.Marshal(e)
+	return b
+}
+
+// Sink is the destination of audit events, Write is called in one goroutine
+type Sink interface {
+	Write(e *Event) error
+	Close() error
+}
+
+// SinkFactory create sink with params, e.g. path=./logs/audit.log&max_size=104857600
+type SinkFactory func(params url.Values) (Sink, error)
+
+var (
+	sinksLock sync.RWMutex
+	sinks     = make(map[string]SinkFactory)
+)
+
+// RegisterSink register sink factory with name, sinks of the same name are replaced
+func RegisterSink(name string, factory SinkFactory) {
+	sinksLock.Lock()
+	defer sinksLock.Unlock()
+	sinks[name] = factory
+}
+
+// GetSinks return sorted names of registered sinks
+func GetSinks() []string {
+	sinksLock.RLock()
+	defer sinksLock.RUnlock()
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSink create registered sink, params is in url query format
+func NewSink(name, params string) (Sink, error) {
+	sinksLock.RLock()
+	factory, ok := sinks[name]
+	sinksLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("audit sink %s not found, supported: %v", name, GetSinks())
+	}
+	values, err := url.ParseQuery(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid params of audit sink %s: %v", name, err)
+	}
+	return factory(values)
+}
+
+// Logger write events to sink asynchronously, events are dropped if the buffer is full,
+// so that slow sinks never block client requests.
+type Logger struct {
+	sink    Sink
+	events  chan *Event
+	dropped int64
+
+	lock   sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewLogger create logger and start writing events to sink, bufferSize is max number of events not written yet
+func NewLogger(sink Sink, bufferSize int) *Logger {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	l := &Logger{sink: sink, events: make(chan *Event, bufferSize)}
+	l.wg.Add(1)
+	go l.run()
+	return l
+}
+
+func (l *Logger) run() {
+	defer l.wg.Done()
+	for e := range l.events {
+		if err := l.sink.Write(e); err != nil {
+			log.Warnf("write audit event error, namespace: %s, type: %s, err: %v", e.Namespace, e.Type, err)
+		}
+	}
+}
+
+// Log add event to buffer, event time is set if not set
+func (l *Logger) Log(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.events <- e:
+	default:
+		atomic.AddInt64(&l.dropped, 1)
+		log.Warnf("audit event dropped, buffer is full, namespace: %s, type: %s", e.Namespace, e.Type)
+	}
+}
+
+// Dropped return number of events dropped because the buffer is full
+func (l *Logger) Dropped() int64 {
+	return atomic.LoadInt64(&l.dropped)
+}
+
+// Close write events in buffer and close sink
+func (l *Logger) Close() error {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.events)
+	l.lock.Unlock()
+
+	l.wg.Wait()
+	return l.sink.Close()
+}
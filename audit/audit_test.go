@@ -0,0 +1,130 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type memorySink struct {
+	events []*Event
+	closed bool
+}
+
+func (s *memorySink) Write(e *Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	sink := &memorySink{}
+	RegisterSink("memory", func(url.Values) (Sink, error) { return sink, nil })
+	s, err := NewSink("memory", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSink("unknown", ""); err == nil {
+		t.Errorf("unknown sink should return error")
+	}
+
+	l := NewLogger(s, 10)
+	for i := 0; i < 3; i++ {
+		l.Log(&Event{Type: EventQuery, Namespace: "ns", Rows: int64(i)})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// events logged after close are ignored
+	l.Log(&Event{Type: EventQuery})
+
+	if len(sink.events) != 3 || !sink.closed {
+		t.Fatalf("events in buffer should be written before close, got: %d, closed: %v", len(sink.events), sink.closed)
+	}
+	for i, e := range sink.events {
+		if e.Rows != int64(i) || e.Time.IsZero() {
+			t.Errorf("invalid event: %+v", e)
+		}
+	}
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	e := &Event{Type: EventConnect, Namespace: "ns", User: "u"}
+	lineSize := len(e.Encode()) + 1
+	params := url.Values{}
+	params.Set("path", path)
+	params.Set("max_size", "1")
+	params.Set("max_backups", "2")
+	// every event exceeds max_size, so the file is rotated before each write except the first one
+	s, err := newFileSink(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := s.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(lineSize) {
+			t.Errorf("size of %s should be %d, got: %d", p, lineSize, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("backups more than max_backups should be removed")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("audit file is empty")
+	}
+	got := &Event{}
+	if err := json.Unmarshal(scanner.Bytes(), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != EventConnect || got.User != "u" {
+		t.Errorf("invalid event in file: %+v", got)
+	}
+}
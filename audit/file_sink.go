@@ -0,0 +1,132 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// SinkFile write events as json lines to file
+const SinkFile = "file"
+
+const (
+	defaultFilePath       = "./logs/audit.log"
+	defaultFileMaxSize    = 100 * 1024 * 1024
+	defaultFileMaxBackups = 7
+)
+
+func init() {
+	RegisterSink(SinkFile, newFileSink)
+}
+
+// fileSink rotate the file when its size exceeds maxSize, path is renamed to path.1,
+// path.1 to path.2 and so on, at most maxBackups rotated files are kept.
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+// newFileSink params: path, max_size (bytes, 0 means no rotation), max_backups
+func newFileSink(params url.Values) (Sink, error) {
+	s := &fileSink{path: defaultFilePath, maxSize: defaultFileMaxSize, maxBackups: defaultFileMaxBackups}
+	if v := params.Get("path"); v != "" {
+		s.path = v
+	}
+	if v := params.Get("max_size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid max_size of audit file: %s", v)
+		}
+		s.maxSize = size
+	}
+	if v := params.Get("max_backups"); v != "" {
+		backups, err := strconv.Atoi(v)
+		if err != nil || backups < 0 {
+			return nil, fmt.Errorf("invalid max_backups of audit file: %s", v)
+		}
+		s.maxBackups = backups
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open audit file error: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stat audit file error: %v", err)
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+
+	os.Remove(s.backupPath(s.maxBackups))
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(s.backupPath(i), s.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.backupPath(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) backupPath(i int) string {
+	return s.path + "." + strconv.Itoa(i)
+}
+
+// Write implement Sink
+func (s *fileSink) Write(e *Event) error {
+	b := append(e.Encode(), '\n')
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotate audit file error: %v", err)
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// Close implement Sink
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
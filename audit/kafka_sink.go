@@ -0,0 +1,81 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// SinkKafka send events as json to kafka topic, keyed by namespace
+const SinkKafka = "kafka"
+
+func init() {
+	RegisterSink(SinkKafka, newKafkaSink)
+}
+
+type kafkaSink struct {
+	topic    string
+	producer sarama.AsyncProducer
+	wg       sync.WaitGroup
+}
+
+// newKafkaSink params: brokers (separated by comma), topic
+func newKafkaSink(params url.Values) (Sink, error) {
+	brokers := params.Get("brokers")
+	topic := params.Get("topic")
+	if brokers == "" || topic == "" {
+		return nil, fmt.Errorf("brokers and topic of audit kafka sink must be set")
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.WaitForLocal
+	cfg.Producer.Return.Errors = true
+	producer, err := sarama.NewAsyncProducer(strings.Split(brokers, ","), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create kafka producer error: %v", err)
+	}
+
+	s := &kafkaSink{topic: topic, producer: producer}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for err := range producer.Errors() {
+			log.Warnf("send audit event to kafka error, topic: %s, err: %v", topic, err.Err)
+		}
+	}()
+	return s, nil
+}
+
+// Write implement Sink
+func (s *kafkaSink) Write(e *Event) error {
+	s.producer.Input() <- &sarama.ProducerMessage{
+		Topic: s.topic,
+		Key:   sarama.StringEncoder(e.Namespace),
+		Value: sarama.ByteEncoder(e.Encode()),
+	}
+	return nil
+}
+
+// Close implement Sink, messages not sent yet are flushed
+func (s *kafkaSink) Close() error {
+	s.producer.AsyncClose()
+	s.wg.Wait()
+	return nil
+}
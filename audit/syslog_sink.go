@@ -0,0 +1,59 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+)
+
+// SinkSyslog write events as json to syslog with facility local0
+const SinkSyslog = "syslog"
+
+const defaultSyslogTag = "gaea-audit"
+
+func init() {
+	RegisterSink(SinkSyslog, newSyslogSink)
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// newSyslogSink params: network (tcp/udp, empty means local syslog), addr, tag
+func newSyslogSink(params url.Values) (Sink, error) {
+	tag := params.Get("tag")
+	if tag == "" {
+		tag = defaultSyslogTag
+	}
+	w, err := syslog.Dial(params.Get("network"), params.Get("addr"), syslog.LOG_INFO|syslog.LOG_LOCAL0, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog error: %v", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+// Write implement Sink
+func (s *syslogSink) Write(e *Event) error {
+	return s.w.Info(string(e.Encode()))
+}
+
+// Close implement Sink
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
@@ -123,14 +123,23 @@ func (s *Slice) getNextSlave() (ConnectionPool, error) {
 		return s.Slave[index], nil
 	}
 
-	s.LastSlaveIndex = s.LastSlaveIndex % queueLen
-	index = s.RoundRobinQ[s.LastSlaveIndex]
-	if len(s.Slave) <= index {
-		return nil, errors.ErrNoDatabase
+	// skip slaves isolated by circuit breaker, return the next one if all slaves are isolated
+	var cp ConnectionPool
+	for i := 0; i < queueLen; i++ {
+		s.LastSlaveIndex = s.LastSlaveIndex % queueLen
+		index = s.RoundRobinQ[s.LastSlaveIndex]
+		if len(s.Slave) <= index {
+			return nil, errors.ErrNoDatabase
+		}
+		if cp == nil || isPoolAvailable(s.Slave[index]) {
+			cp = s.Slave[index]
+		}
+		s.LastSlaveIndex++
+		s.LastSlaveIndex = s.LastSlaveIndex % queueLen
+		if isPoolAvailable(cp) {
+			break
+		}
 	}
-	cp := s.Slave[index]
-	s.LastSlaveIndex++
-	s.LastSlaveIndex = s.LastSlaveIndex % queueLen
 	return cp, nil
 }
 
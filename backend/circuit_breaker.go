@@ -0,0 +1,221 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+const (
+	defaultProbeInterval = 5 * time.Second
+	maxCircuitEvents     = 100
+)
+
+// ErrCircuitOpen means backend is isolated by circuit breaker
+var ErrCircuitOpen = errors.New("backend is isolated by circuit breaker")
+
+// CircuitState state of circuit breaker
+type CircuitState int32
+
+const (
+	// CircuitClosed backend is healthy, requests are sent to it
+	CircuitClosed CircuitState = iota
+	// CircuitOpen backend is isolated, requests fail fast until a probe succeeds
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	if s == CircuitOpen {
+		return "open"
+	}
+	return "closed"
+}
+
+// CircuitEvent trip or reset event of circuit breaker
+type CircuitEvent struct {
+	Time     time.Time `json:"time"`
+	Addr     string    `json:"addr"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	Error    string    `json:"error,omitempty"` // last error before trip
+}
+
+var circuitEvents struct {
+	sync.Mutex
+	events []*CircuitEvent
+}
+
+func addCircuitEvent(e *CircuitEvent) {
+	circuitEvents.Lock()
+	defer circuitEvents.Unlock()
+	if len(circuitEvents.events) >= maxCircuitEvents {
+		circuitEvents.events = circuitEvents.events[1:]
+	}
+	circuitEvents.events = append(circuitEvents.events, e)
+}
+
+// GetCircuitEvents return recent trip and reset events of circuit breakers, the earliest first
+func GetCircuitEvents() []*CircuitEvent {
+	circuitEvents.Lock()
+	defer circuitEvents.Unlock()
+	ret := make([]*CircuitEvent, len(circuitEvents.events))
+	copy(ret, circuitEvents.events)
+	return ret
+}
+
+// CircuitBreakerStats state of circuit breaker
+type CircuitBreakerStats struct {
+	Addr     string `json:"addr"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	TripTime int64  `json:"trip_time,omitempty"` // unix time when the breaker trips
+}
+
+// CircuitBreaker trips after consecutive failures of backend.
+// When it's open, requests to the backend fail fast and the backend is probed periodically,
+// the breaker resets after a probe succeeds.
+type CircuitBreaker struct {
+	mu sync.Mutex
+
+	addr          string
+	threshold     int
+	probeInterval time.Duration
+	probe         func() error
+
+	state    CircuitState
+	failures int
+	lastErr  error
+	tripTime time.Time
+
+	closeC chan struct{}
+	closed bool
+}
+
+// NewCircuitBreaker create circuit breaker of backend, probe is called periodically when the breaker is open
+func NewCircuitBreaker(addr string, threshold int, probeInterval time.Duration, probe func() error) *CircuitBreaker {
+	if probeInterval <= 0 {
+		probeInterval = defaultProbeInterval
+	}
+	return &CircuitBreaker{
+		addr:          addr,
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		probe:         probe,
+		closeC:        make(chan struct{}),
+	}
+}
+
+// State return state of circuit breaker
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Allow return false if requests to the backend should fail fast
+func (cb *CircuitBreaker) Allow() bool {
+	return cb.State() == CircuitClosed
+}
+
+// Stats return state of circuit breaker
+func (cb *CircuitBreaker) Stats() *CircuitBreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	stats := &CircuitBreakerStats{Addr: cb.addr, State: cb.state.String(), Failures: cb.failures}
+	if cb.state == CircuitOpen {
+		stats.TripTime = cb.tripTime.Unix()
+	}
+	return stats
+}
+
+// Record record result of request to the backend.
+// Errors returned by mysql server mean the backend is alive, so they are not failures.
+func (cb *CircuitBreaker) Record(err error) {
+	if _, ok := err.(*mysql.SQLError); ok || err == nil {
+		cb.onSuccess()
+	} else {
+		cb.onFailure(err)
+	}
+}
+
+func (cb *CircuitBreaker) onSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	// only probe can reset the open breaker
+	if cb.state == CircuitClosed {
+		cb.failures = 0
+	}
+}
+
+func (cb *CircuitBreaker) onFailure(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitOpen || cb.closed {
+		return
+	}
+	cb.failures++
+	cb.lastErr = err
+	if cb.failures < cb.threshold {
+		return
+	}
+
+	cb.state = CircuitOpen
+	cb.tripTime = time.Now()
+	addCircuitEvent(&CircuitEvent{Time: cb.tripTime, Addr: cb.addr, State: cb.state.String(), Failures: cb.failures, Error: err.Error()})
+	log.Warnf("circuit breaker of backend %s trips after %d consecutive failures, last error: %v", cb.addr, cb.failures, err)
+	go cb.probeLoop()
+}
+
+func (cb *CircuitBreaker) probeLoop() {
+	ticker := time.NewTicker(cb.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := cb.probe(); err != nil {
+				log.Debugf("probe backend %s failed, err: %v", cb.addr, err)
+				continue
+			}
+			cb.reset()
+			return
+		case <-cb.closeC:
+			return
+		}
+	}
+}
+
+func (cb *CircuitBreaker) reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.state = CircuitClosed
+	cb.failures = 0
+	cb.lastErr = nil
+	addCircuitEvent(&CircuitEvent{Time: time.Now(), Addr: cb.addr, State: cb.state.String()})
+	log.Infof("circuit breaker of backend %s resets, isolated for %v", cb.addr, time.Since(cb.tripTime))
+}
+
+// Close stop probing backend
+func (cb *CircuitBreaker) Close() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !cb.closed {
+		cb.closed = true
+		close(cb.closeC)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy int32
+	probe := func() error {
+		if atomic.LoadInt32(&healthy) == 0 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	cb := NewCircuitBreaker("127.0.0.1:3306", 3, 10*time.Millisecond, probe)
+	defer cb.Close()
+
+	netErr := errors.New("i/o timeout")
+	cb.Record(netErr)
+	cb.Record(netErr)
+	// errors returned by mysql reset the consecutive failures
+	cb.Record(mysql.NewDefaultError(mysql.ErrNoSuchTable, "db", "tbl"))
+	cb.Record(netErr)
+	cb.Record(netErr)
+	if !cb.Allow() {
+		t.Fatalf("circuit breaker should not trip before 3 consecutive failures")
+	}
+	cb.Record(netErr)
+	if cb.Allow() || cb.Stats().State != "open" {
+		t.Fatalf("circuit breaker should trip after 3 consecutive failures, stats: %+v", cb.Stats())
+	}
+
+	// only probe can reset the breaker
+	cb.Record(nil)
+	time.Sleep(50 * time.Millisecond)
+	if cb.Allow() {
+		t.Fatalf("circuit breaker should be open while probe fails")
+	}
+	atomic.StoreInt32(&healthy, 1)
+	for i := 0; i < 100 && !cb.Allow(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !cb.Allow() || cb.Stats().Failures != 0 {
+		t.Fatalf("circuit breaker should reset after probe succeeds, stats: %+v", cb.Stats())
+	}
+
+	events := GetCircuitEvents()
+	if len(events) != 2 || events[0].State != "open" || events[0].Failures != 3 || events[1].State != "closed" {
+		t.Errorf("invalid circuit events: %+v", events)
+	}
+}
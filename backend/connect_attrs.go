@@ -0,0 +1,116 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// ProgramName is the program_name connection attribute of backend connections
+const ProgramName = "go-sharding"
+
+// connection attributes of the proxy process, which are sent in all backend connections
+var processConnectAttrs = func() map[string]string {
+	attrs := map[string]string{
+		"_client_name": ProgramName,
+		"program_name": ProgramName,
+		"_pid":         strconv.Itoa(os.Getpid()),
+		"_os":          runtime.GOOS,
+		"_platform":    runtime.GOARCH,
+	}
+	if host, err := os.Hostname(); err == nil {
+		attrs["proxy_host"] = host
+	}
+	return attrs
+}()
+
+// getConnectAttrs return connection attributes of process merged with attributes of the pool
+func getConnectAttrs(attrs map[string]string) map[string]string {
+	ret := make(map[string]string, len(processConnectAttrs)+len(attrs))
+	for k, v := range processConnectAttrs {
+		ret[k] = v
+	}
+	for k, v := range attrs {
+		ret[k] = v
+	}
+	return ret
+}
+
+// appendConnectAttrs append connection attributes of handshake response, sorted by key
+// See: https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::HandshakeResponse41
+func appendConnectAttrs(data []byte, attrs map[string]string) []byte {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var kvs []byte
+	for _, k := range keys {
+		kvs = mysql.AppendLenEncStringBytes(kvs, []byte(k))
+		kvs = mysql.AppendLenEncStringBytes(kvs, []byte(attrs[k]))
+	}
+	data = mysql.AppendLenEncInt(data, uint64(len(kvs)))
+	return append(data, kvs...)
+}
+
+// signature of PROXY protocol v2 header
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	proxyProtocolV2Local = 0x20 // version 2, command LOCAL
+	proxyProtocolV2Proxy = 0x21 // version 2, command PROXY
+
+	proxyProtocolV2Unspec = 0x00
+	proxyProtocolV2TCP4   = 0x11
+	proxyProtocolV2TCP6   = 0x21
+)
+
+// writeProxyProtocolV2 write PROXY protocol v2 header with source and destination address of tcp connection,
+// header of LOCAL command is written if the address is not tcp
+// See: https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+func writeProxyProtocolV2(w io.Writer, src, dst net.Addr) error {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	srcAddr, ok1 := src.(*net.TCPAddr)
+	dstAddr, ok2 := dst.(*net.TCPAddr)
+	if !ok1 || !ok2 {
+		header = append(header, proxyProtocolV2Local, proxyProtocolV2Unspec, 0, 0)
+		_, err := w.Write(header)
+		return err
+	}
+
+	var addrs []byte
+	family := byte(proxyProtocolV2TCP6)
+	if src4, dst4 := srcAddr.IP.To4(), dstAddr.IP.To4(); src4 != nil && dst4 != nil {
+		family = proxyProtocolV2TCP4
+		addrs = append(append(addrs, src4...), dst4...)
+	} else {
+		addrs = append(append(addrs, srcAddr.IP.To16()...), dstAddr.IP.To16()...)
+	}
+	addrs = append(addrs, byte(srcAddr.Port>>8), byte(srcAddr.Port), byte(dstAddr.Port>>8), byte(dstAddr.Port))
+
+	header = append(header, proxyProtocolV2Proxy, family, 0, 0)
+	binary.BigEndian.PutUint16(header[len(header)-2:], uint16(len(addrs)))
+	_, err := w.Write(append(header, addrs...))
+	return err
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestAppendConnectAttrs(t *testing.T) {
+	data := appendConnectAttrs(nil, map[string]string{"slice": "slice-0", "_os": "linux"})
+	expect := []byte("\x18\x03_os\x05linux\x05slice\x07slice-0")
+	if !bytes.Equal(data, expect) {
+		t.Errorf("connect attrs not equal, expect: %q, actual: %q", expect, data)
+	}
+
+	attrs := getConnectAttrs(map[string]string{"slice": "slice-0", "program_name": "test"})
+	if attrs["program_name"] != "test" || attrs["_client_name"] != ProgramName || attrs["slice"] != "slice-0" {
+		t.Errorf("attrs of pool not merged with attrs of process: %v", attrs)
+	}
+	if processConnectAttrs["program_name"] != ProgramName {
+		t.Errorf("attrs of process modified: %v", processConnectAttrs)
+	}
+}
+
+func TestWriteProxyProtocolV2(t *testing.T) {
+	tests := []struct {
+		src    net.Addr
+		dst    net.Addr
+		expect []byte
+	}{
+		{
+			&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50000},
+			&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 3306},
+			[]byte{0x21, 0x11, 0, 12, 10, 0, 0, 1, 10, 0, 0, 2, 0xC3, 0x50, 0x0C, 0xEA},
+		},
+		{
+			&net.TCPAddr{IP: net.ParseIP("::1"), Port: 1},
+			&net.TCPAddr{IP: net.ParseIP("::1"), Port: 2},
+			[]byte{0x21, 0x21, 0, 36, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 1, 0, 2},
+		},
+		{
+			&net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"},
+			&net.UnixAddr{Name: "/tmp/b.sock", Net: "unix"},
+			[]byte{0x20, 0x00, 0, 0},
+		},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := writeProxyProtocolV2(&buf, test.src, test.dst); err != nil {
+			t.Fatalf("write proxy protocol header error: %v", err)
+		}
+		expect := append(append([]byte{}, proxyProtocolV2Signature...), test.expect...)
+		if !bytes.Equal(buf.Bytes(), expect) {
+			t.Errorf("proxy protocol header of %v not equal, expect: %v, actual: %v", test.src, expect, buf.Bytes())
+		}
+	}
+}
@@ -17,11 +17,13 @@ package backend
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/XiaoMi/Gaea/mysql"
 	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/sync2"
 )
 
 const (
@@ -35,6 +37,20 @@ var (
 	DefaultCapacity = 64
 )
 
+// PoolConfig connection management options of connection pool
+type PoolConfig struct {
+	MinIdle      int           // connections created in background after the pool is opened
+	MaxLifetime  time.Duration // connection is reconnected when borrowed if it's older than MaxLifetime, 0 means no limit
+	PingOnBorrow bool          // ping connection when borrowed, and reconnect if ping fails
+	Compress     bool          // use compressed protocol
+
+	ConnectAttrs  map[string]string // connection attributes sent to backend besides attributes of the proxy process
+	ProxyProtocol bool              // send PROXY protocol v2 header before handshake
+
+	BreakerFailures      int           // isolate backend after consecutive failures, 0 means disabled
+	BreakerProbeInterval time.Duration // interval of probing isolated backend
+}
+
 // connectionPoolImpl means connection pool with specific addr
 type connectionPoolImpl struct {
 	mu          sync.RWMutex
@@ -48,17 +64,54 @@ type connectionPoolImpl struct {
 	charset     string
 	collationID mysql.CollationID
 
+	cfg PoolConfig
+
 	capacity    int // capacity of pool
 	maxCapacity int // max capacity of pool
 	idleTimeout time.Duration
+
+	// stats of health check
+	lifetimeClosed sync2.AtomicInt64
+	pingFailed     sync2.AtomicInt64
+
+	breaker *CircuitBreaker // nil means circuit breaker is disabled
 }
 
 // NewConnectionPool create connection pool
-func NewConnectionPool(addr, user, password, db string, capacity, maxCapacity int, idleTimeout time.Duration, charset string, collationID mysql.CollationID) ConnectionPool {
-	cp := &connectionPoolImpl{addr: addr, user: user, password: password, db: db, capacity: capacity, maxCapacity: maxCapacity, idleTimeout: idleTimeout, charset: charset, collationID: collationID}
+func NewConnectionPool(addr, user, password, db string, capacity, maxCapacity int, idleTimeout time.Duration, charset string, collationID mysql.CollationID, cfg PoolConfig) ConnectionPool {
+	cp := &connectionPoolImpl{addr: addr, user: user, password: password, db: db, capacity: capacity, maxCapacity: maxCapacity, idleTimeout: idleTimeout, charset: charset, collationID: collationID, cfg: cfg}
+	if cfg.BreakerFailures > 0 {
+		cp.breaker = NewCircuitBreaker(addr, cfg.BreakerFailures, cfg.BreakerProbeInterval, cp.probe)
+	}
 	return cp
 }
 
+// probe check if the isolated backend recovers by connecting and ping
+func (cp *connectionPoolImpl) probe() error {
+	dc, err := cp.newDirectConnection("")
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+	return dc.Ping()
+}
+
+// newDirectConnection return connection to backend of pool with config of pool
+func (cp *connectionPoolImpl) newDirectConnection(db string) (*DirectConnection, error) {
+	dc := newDirectConnection(cp.addr, cp.user, cp.password, db, cp.charset, cp.collationID, cp.cfg.Compress)
+	dc.connectAttrs = cp.cfg.ConnectAttrs
+	dc.proxyProtocol = cp.cfg.ProxyProtocol
+	err := dc.connect()
+	return dc, err
+}
+
+// recordResult record result of request to backend in circuit breaker
+func (cp *connectionPoolImpl) recordResult(err error) {
+	if cp.breaker != nil {
+		cp.breaker.Record(err)
+	}
+}
+
 func (cp *connectionPoolImpl) pool() (p *util.ResourcePool) {
 	cp.mu.Lock()
 	p = cp.connections
@@ -78,16 +131,42 @@ func (cp *connectionPoolImpl) Open() {
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
 	cp.connections = util.NewResourcePool(cp.connect, cp.capacity, cp.maxCapacity, cp.idleTimeout)
+	if cp.cfg.MinIdle > 0 {
+		go cp.warmUp(cp.connections)
+	}
 	return
 }
 
+// warmUp create MinIdle connections in background, so that the first queries needn't wait for connecting
+func (cp *connectionPoolImpl) warmUp(p *util.ResourcePool) {
+	n := cp.cfg.MinIdle
+	if n > cp.capacity {
+		n = cp.capacity
+	}
+
+	resources := make([]util.Resource, 0, n)
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), getConnTimeout)
+		r, err := p.Get(ctx)
+		cancel()
+		if err != nil {
+			log.Warnf("warm up connection pool error, addr: %s, created: %d, err: %v", cp.addr, len(resources), err)
+			break
+		}
+		resources = append(resources, r)
+	}
+	for _, r := range resources {
+		p.Put(r)
+	}
+}
+
 // connect is used by the resource pool to create new resource.It's factory method
 func (cp *connectionPoolImpl) connect() (util.Resource, error) {
-	c, err := NewDirectConnection(cp.addr, cp.user, cp.password, cp.db, cp.charset, cp.collationID)
+	c, err := cp.newDirectConnection(cp.db)
 	if err != nil {
 		return nil, err
 	}
-	return &pooledConnectImpl{directConnection: c, pool: cp}, nil
+	return &pooledConnectImpl{directConnection: c, pool: cp, createTime: time.Now()}, nil
 }
 
 // Addr return addr of connection pool
@@ -97,6 +176,9 @@ func (cp *connectionPoolImpl) Addr() string {
 
 // Close close connection pool
 func (cp *connectionPoolImpl) Close() {
+	if cp.breaker != nil {
+		cp.breaker.Close()
+	}
 	p := cp.pool()
 	if p == nil {
 		return
@@ -119,14 +201,43 @@ func (cp *connectionPoolImpl) Get(ctx context.Context) (PooledConnect, error) {
 	if p == nil {
 		return nil, ErrConnectionPoolClosed
 	}
+	if cp.breaker != nil && !cp.breaker.Allow() {
+		return nil, fmt.Errorf("%v, addr: %s", ErrCircuitOpen, cp.addr)
+	}
 
 	getCtx, cancel := context.WithTimeout(ctx, getConnTimeout)
 	defer cancel()
 	r, err := p.Get(getCtx)
 	if err != nil {
+		// waiting for idle connection timeout is not failure of backend
+		if err != util.ErrTimeout && err != util.ErrClosed {
+			cp.recordResult(err)
+		}
 		return nil, err
 	}
-	return r.(*pooledConnectImpl), nil
+	pc := r.(*pooledConnectImpl)
+	if err := cp.checkHealth(pc); err != nil {
+		cp.recordResult(err)
+		p.Put(nil)
+		return nil, err
+	}
+	return pc, nil
+}
+
+// checkHealth reconnect the connection if it's too old or ping fails
+func (cp *connectionPoolImpl) checkHealth(pc *pooledConnectImpl) error {
+	if cp.cfg.MaxLifetime > 0 && time.Since(pc.createTime) > cp.cfg.MaxLifetime {
+		cp.lifetimeClosed.Add(1)
+		return pc.Reconnect()
+	}
+	if cp.cfg.PingOnBorrow {
+		if err := pc.directConnection.Ping(); err != nil {
+			log.Warnf("ping backend connection failed, reconnect, addr: %s, err: %v", cp.addr, err)
+			cp.pingFailed.Add(1)
+			return pc.Reconnect()
+		}
+	}
+	return nil
 }
 
 // Put recycle a connection into the pool
@@ -259,3 +370,18 @@ func (cp *connectionPoolImpl) IdleClosed() int64 {
 	}
 	return p.IdleClosed()
 }
+
+// LifetimeClosed returns the count of connections reconnected due to max lifetime
+func (cp *connectionPoolImpl) LifetimeClosed() int64 {
+	return cp.lifetimeClosed.Get()
+}
+
+// PingFailed returns the count of connections reconnected due to ping failure
+func (cp *connectionPoolImpl) PingFailed() int64 {
+	return cp.pingFailed.Get()
+}
+
+// Breaker returns circuit breaker of the pool, nil means circuit breaker is disabled
+func (cp *connectionPoolImpl) Breaker() *CircuitBreaker {
+	return cp.breaker
+}
@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util/failpoint"
 	"github.com/XiaoMi/Gaea/util/sync2"
 )
 
@@ -38,7 +39,8 @@ type DirectConnection struct {
 	password string
 	db       string
 
-	capability uint32
+	capability   uint32
+	connectionID uint32
 
 	sessionVariables *mysql.SessionVariables
 
@@ -55,11 +57,25 @@ type DirectConnection struct {
 	closed sync2.AtomicBool
 
 	authPluginName string
+
+	compress bool // use compressed protocol if backend mysql supports
+
+	connectAttrs  map[string]string // connection attributes sent in handshake besides attributes of the proxy process
+	proxyProtocol bool              // send PROXY protocol v2 header before handshake of tcp connection
+
+	gtids string // GTID set of the last committed transaction reported by session state information
 }
 
 // NewDirectConnection return direct and authorised connection to mysql with real net connection
-func NewDirectConnection(addr string, user string, password string, db string, charset string, collationID mysql.CollationID) (*DirectConnection, error) {
-	dc := &DirectConnection{
+func NewDirectConnection(addr string, user string, password string, db string, charset string, collationID mysql.CollationID, compress bool) (*DirectConnection, error) {
+	dc := newDirectConnection(addr, user, password, db, charset, collationID, compress)
+	err := dc.connect()
+	return dc, err
+}
+
+// newDirectConnection return direct connection which is not connected yet
+func newDirectConnection(addr string, user string, password string, db string, charset string, collationID mysql.CollationID, compress bool) *DirectConnection {
+	return &DirectConnection{
 		addr:             addr,
 		user:             user,
 		password:         password,
@@ -70,9 +86,8 @@ func NewDirectConnection(addr string, user string, password string, db string, c
 		defaultCollation: collationID,
 		closed:           sync2.NewAtomicBool(false),
 		sessionVariables: mysql.NewSessionVariables(),
+		compress:         compress,
 	}
-	err := dc.connect()
-	return dc, err
 }
 
 // connect means real connection to backend mysql after authorization
@@ -81,6 +96,10 @@ func (dc *DirectConnection) connect() error {
 		dc.conn.Close()
 	}
 
+	if err := failpoint.InjectError(failpoint.BackendConnectError); err != nil {
+		return err
+	}
+
 	typ := "tcp"
 	if strings.Contains(dc.addr, "/") {
 		typ = "unix"
@@ -91,6 +110,13 @@ func (dc *DirectConnection) connect() error {
 		return err
 	}
 
+	if dc.proxyProtocol && typ == "tcp" {
+		if err := writeProxyProtocolV2(netConn, netConn.LocalAddr(), netConn.RemoteAddr()); err != nil {
+			netConn.Close()
+			return err
+		}
+	}
+
 	tcpConn := netConn.(*net.TCPConn)
 	// SetNoDelay controls whether the operating system should delay packet transmission
 	// in hopes of sending fewer packets (Nagle's algorithm).
@@ -119,6 +145,10 @@ func (dc *DirectConnection) connect() error {
 		return err
 	}
 
+	if dc.compress && dc.capability&mysql.ClientCompress != 0 {
+		dc.conn.EnableCompression()
+	}
+
 	// we must always use autocommit
 	if !dc.IsAutoCommit() {
 		if _, err := dc.exec("set autocommit = 1"); err != nil {
@@ -153,6 +183,11 @@ func (dc *DirectConnection) IsClosed() bool {
 // readPacket doesn't use EphemeralBuffer
 func (dc *DirectConnection) readPacket() ([]byte, error) {
 	data, err := dc.conn.ReadPacket()
+	if err == nil {
+		if _, ok := failpoint.Eval(failpoint.BackendPacketCorruption); ok {
+			err = mysql.ErrMalformPacket
+		}
+	}
 	dc.pkgErr = err
 	return data, err
 }
@@ -208,6 +243,7 @@ func (dc *DirectConnection) readInitialHandshake() error {
 	//mysql version end with 0x00
 	//connection id length is 4
 	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1 + 4
+	dc.connectionID = binary.LittleEndian.Uint32(data[pos-4 : pos])
 
 	dc.salt = append(dc.salt, data[pos:pos+8]...)
 
@@ -288,7 +324,11 @@ func (dc *DirectConnection) CalcPassword(authData []byte) ([]byte, error) {
 func (dc *DirectConnection) writeHandshakeResponse41() error {
 	// Adjust client capability flags based on server support
 	capability := mysql.ClientProtocol41 | mysql.ClientSecureConnection |
-		mysql.ClientLongPassword | mysql.ClientTransactions | mysql.ClientPluginAuth | mysql.ClientLongFlag
+		mysql.ClientLongPassword | mysql.ClientTransactions | mysql.ClientPluginAuth | mysql.ClientLongFlag |
+		mysql.ClientSessionTrack | mysql.ClientMultiResults | mysql.ClientConnectAtts
+	if dc.compress {
+		capability |= mysql.ClientCompress
+	}
 	capability &= dc.capability
 
 	//capability := CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION |
@@ -319,7 +359,7 @@ func (dc *DirectConnection) writeHandshakeResponse41() error {
 	//username
 	//auth
 	//mysql_native_password + null-terminated
-	length := 4 + 4 + 1 + 23 + len(dc.user) + 1 + len(authRespLEI) + len(auth) + 21 + 1
+	length := 4 + 4 + 1 + 23 + len(dc.user) + 1 + len(authRespLEI) + len(auth) + len(dc.authPluginName) + 1
 	//if addNull {
 	//	length++
 	//}
@@ -328,6 +368,12 @@ func (dc *DirectConnection) writeHandshakeResponse41() error {
 		capability |= mysql.ClientConnectWithDB
 		length += len(dc.db) + 1
 	}
+	// connection attributes
+	var attrs []byte
+	if capability&mysql.ClientConnectAtts != 0 {
+		attrs = appendConnectAttrs(nil, getConnectAttrs(dc.connectAttrs))
+		length += len(attrs)
+	}
 
 	data := make([]byte, length)
 
@@ -397,6 +443,10 @@ func (dc *DirectConnection) writeHandshakeResponse41() error {
 	// Assume native client during response
 	pos += copy(data[pos:], dc.authPluginName)
 	data[pos] = 0x00
+	pos++
+
+	// connection attributes [length encoded key-value pairs]
+	copy(data[pos:], attrs)
 
 	if err := dc.writePacket(data); err != nil {
 		return err
@@ -516,11 +566,77 @@ func (dc *DirectConnection) GetAddr() string {
 	return dc.addr
 }
 
+// GetConnectionID return thread id of the connection in backend mysql
+func (dc *DirectConnection) GetConnectionID() uint32 {
+	return dc.connectionID
+}
+
 // Execute send ComQuery or ComStmtPrepare/ComStmtExecute/ComStmtClose to backend mysql
 func (dc *DirectConnection) Execute(sql string) (*mysql.Result, error) {
 	return dc.exec(sql)
 }
 
+// ExecuteStream send ComQuery to backend mysql, column definitions and rows of resultset are passed to
+// the callbacks as they arrive instead of being kept in the result, row data is owned by the callback.
+// If a callback returns error, the connection is closed because the remaining rows can not be skipped.
+func (dc *DirectConnection) ExecuteStream(sql string, onFields func([]*mysql.Field) error, onRow func(mysql.RowData) error) (*mysql.Result, error) {
+	if err := dc.writeComQuery(sql); err != nil {
+		return nil, err
+	}
+
+	data, err := dc.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	switch data[0] {
+	case mysql.OKHeader:
+		return dc.handleOKPacket(data)
+	case mysql.ErrHeader:
+		return nil, dc.handleErrorPacket(data)
+	case mysql.LocalInFileHeader:
+		return nil, mysql.ErrMalformPacket
+	}
+
+	count, pos, _, _ := mysql.ReadLenEncInt(data, 0)
+	if pos != len(data) {
+		return nil, mysql.ErrMalformPacket
+	}
+	result := &mysql.Result{
+		Resultset: &mysql.Resultset{
+			Fields:     make([]*mysql.Field, count),
+			FieldNames: make(map[string]int, count),
+		},
+	}
+	if err := dc.readResultColumns(result); err != nil {
+		return nil, err
+	}
+	if err := onFields(result.Fields); err != nil {
+		dc.Close()
+		return nil, err
+	}
+
+	for {
+		data, err = dc.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if dc.isEOFPacket(data) {
+			if dc.capability&mysql.ClientProtocol41 > 0 {
+				result.Status = binary.LittleEndian.Uint16(data[3:])
+				dc.status = result.Status
+			}
+			return result, nil
+		}
+		if data[0] == mysql.ErrHeader {
+			return nil, dc.handleErrorPacket(data)
+		}
+		if err := onRow(data); err != nil {
+			dc.Close()
+			return nil, err
+		}
+	}
+}
+
 // Begin send ComQuery with 'begin' to backend mysql to start transaction
 func (dc *DirectConnection) Begin() error {
 	_, err := dc.exec("begin")
@@ -672,11 +788,94 @@ func (dc *DirectConnection) FieldList(table string, wildcard string) ([]*mysql.F
 
 // execute ComQuery command
 func (dc *DirectConnection) exec(query string) (*mysql.Result, error) {
+	failpoint.InjectDelay(failpoint.BackendSlowResponse)
+
 	if err := dc.writeComQuery(query); err != nil {
 		return nil, err
 	}
 
-	return dc.readResult(false)
+	return dc.readResults()
+}
+
+// readResults read results of ComQuery
+func (dc *DirectConnection) readResults() (*mysql.Result, error) {
+	r, err := dc.readResult(false)
+	if err != nil {
+		return nil, err
+	}
+	// CALL returns resultsets of the procedure followed by an OK packet, chain them in order
+	for last := r; last.Status&mysql.ServerMoreResultsExists != 0; last = last.Next {
+		if last.Next, err = dc.readResult(false); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// ExecutePipeline send ComQuery of all sqls before reading any result, so backend mysql executes them one by one
+// within a single round trip. Results and errors are returned in the order of sqls, error of a statement doesn't
+// stop the following ones. If the connection is broken, it's closed and statements without result fail with
+// the error. Count of sqls should be limited by caller, since results are not read until all sqls are sent.
+func (dc *DirectConnection) ExecutePipeline(sqls []string) ([]*mysql.Result, []error) {
+	failpoint.InjectDelay(failpoint.BackendSlowResponse)
+
+	rs := make([]*mysql.Result, len(sqls))
+	errs := make([]error, len(sqls))
+	fail := func(from int, err error) ([]*mysql.Result, []error) {
+		dc.Close()
+		for i := from; i < len(sqls); i++ {
+			errs[i] = err
+		}
+		return rs, errs
+	}
+
+	// sequence of compressed packets of responses depends on the command, statements are executed one by one
+	if dc.conn.IsCompressionEnabled() {
+		for i, sql := range sqls {
+			r, err := dc.exec(sql)
+			if err != nil {
+				if _, ok := err.(*mysql.SQLError); !ok {
+					return fail(i, err)
+				}
+				errs[i] = err
+				continue
+			}
+			rs[i] = r
+		}
+		return rs, errs
+	}
+
+	// sequence of the first packet of each response follows the packets of its command
+	seqs := make([]uint8, len(sqls))
+	dc.conn.StartWriterBuffering()
+	for i, sql := range sqls {
+		dc.conn.SetSequence(0)
+		data := make([]byte, len(sql)+1)
+		data[0] = mysql.ComQuery
+		copy(data[1:], sql)
+		if err := dc.conn.WritePacket(data); err != nil {
+			dc.conn.Flush()
+			return fail(0, err)
+		}
+		seqs[i] = dc.conn.GetSequence()
+	}
+	if err := dc.conn.Flush(); err != nil {
+		return fail(0, err)
+	}
+
+	for i := range sqls {
+		dc.conn.SetSequence(seqs[i])
+		r, err := dc.readResults()
+		if err != nil {
+			if _, ok := err.(*mysql.SQLError); !ok {
+				return fail(i, err)
+			}
+			errs[i] = err
+			continue
+		}
+		rs[i] = r
+	}
+	return rs, errs
 }
 
 // read resultset from mysql
@@ -779,6 +978,11 @@ func (dc *DirectConnection) readResultRows(result *mysql.Result, isBinary bool)
 			return dc.handleErrorPacket(data)
 		}
 
+		if _, ok := failpoint.Eval(failpoint.BackendResultDisconnect); ok {
+			dc.Close()
+			return mysql.ErrBadConn
+		}
+
 		result.RowDatas = append(result.RowDatas, data)
 	}
 
@@ -814,17 +1018,34 @@ func (dc *DirectConnection) handleOKPacket(data []byte) (*mysql.Result, error) {
 
 		// TODO strict_mode, check warnings as error
 		// Warnings := binary.LittleEndian.Uint16(data[pos:])
-		// pos += 2
+		pos += 2
 	} else if dc.capability&mysql.ClientTransactions > 0 {
 		r.Status = binary.LittleEndian.Uint16(data[pos:])
 		dc.status = r.Status
 		pos += 2
 	}
 
-	//info
+	// info and session state information, GTID set of committed transaction is reported if session_track_gtids is set
+	if dc.capability&mysql.ClientSessionTrack > 0 && r.Status&mysql.ServerSessionStateChanged > 0 {
+		if _, next, _, ok := mysql.ReadLenEncStringAsBytes(data, pos); ok {
+			if state, _, _, ok := mysql.ReadLenEncStringAsBytes(data, next); ok {
+				if gtids := mysql.ParseSessionTrackGTIDs(state); gtids != "" {
+					dc.gtids = gtids
+				}
+			}
+		}
+	}
 	return r, nil
 }
 
+// TakeGTIDs return GTID set of the last transaction committed in the connection and clear it,
+// empty if session_track_gtids is not set
+func (dc *DirectConnection) TakeGTIDs() string {
+	gtids := dc.gtids
+	dc.gtids = ""
+	return gtids
+}
+
 func (dc *DirectConnection) handleErrorPacket(data []byte) error {
 	e := new(mysql.SQLError)
 
@@ -902,11 +1123,13 @@ func appendSetVariable(buf *bytes.Buffer, key string, value interface{}) {
 			buf.WriteString(v)
 		} else {
 			buf.WriteString("'")
-			buf.WriteString(v)
+			buf.WriteString(mysql.Escape(v))
 			buf.WriteString("'")
 		}
 	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 		buf.WriteString(fmt.Sprintf("%d", v))
+	case float32, float64:
+		buf.WriteString(fmt.Sprintf("%v", v))
 	default:
 		buf.WriteString("'")
 		buf.WriteString(fmt.Sprintf("%v", v))
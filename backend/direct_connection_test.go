@@ -15,7 +15,10 @@ package backend
 
 import (
 	"bytes"
+	"net"
 	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
 )
 
 func TestAppendSetVariable(t *testing.T) {
@@ -37,3 +40,100 @@ func TestAppendSetVariable2(t *testing.T) {
 	appendSetVariableToDefault(&buf, "sql_mode")
 	t.Log(buf.String())
 }
+
+func TestAppendSetVariableEscape(t *testing.T) {
+	var buf bytes.Buffer
+	appendSetVariable(&buf, "sql_mode", "ANSI', sql_log_bin = '0")
+	appendSetVariable(&buf, "long_query_time", 1.5)
+	expect := "SET sql_mode = 'ANSI\\', sql_log_bin = \\'0',long_query_time = 1.5"
+	if buf.String() != expect {
+		t.Errorf("expect %s, got %s", expect, buf.String())
+	}
+}
+
+func TestExecuteMultipleResults(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	dc := &DirectConnection{conn: mysql.NewConn(client), capability: mysql.ClientProtocol41}
+
+	// CALL returns a resultset and the final OK packet
+	go func() {
+		c := mysql.NewConn(server)
+		if _, err := c.ReadPacket(); err != nil {
+			return
+		}
+		more := mysql.ServerStatusAutocommit | mysql.ServerMoreResultsExists
+		_ = c.WritePacket([]byte{1})
+		_ = c.WritePacket((&mysql.Field{Name: []byte("id"), Type: mysql.TypeLonglong}).Dump())
+		_ = c.WriteEOFPacket(more, 0)
+		_ = c.WritePacket([]byte{1, '1'})
+		_ = c.WriteEOFPacket(more, 0)
+		_ = c.WriteOKPacket(1, 0, mysql.ServerStatusAutocommit, 0)
+	}()
+
+	r, err := dc.Execute("call p()")
+	if err != nil {
+		t.Fatalf("execute call error: %v", err)
+	}
+	if r.Resultset == nil || len(r.Values) != 1 || r.Status&mysql.ServerMoreResultsExists == 0 {
+		t.Fatalf("invalid first result: %+v", r)
+	}
+	if r.Next == nil || r.Next.Resultset != nil || r.Next.AffectedRows != 1 || r.Next.Next != nil {
+		t.Errorf("invalid last result: %+v", r.Next)
+	}
+	if dc.status&mysql.ServerMoreResultsExists != 0 {
+		t.Errorf("status of connection should be the status of last result, got %d", dc.status)
+	}
+}
+
+func TestExecutePipeline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	dc := &DirectConnection{conn: mysql.NewConn(client), capability: mysql.ClientProtocol41}
+
+	received := make(chan []string, 1)
+	go func() {
+		c := mysql.NewConn(server)
+		var sqls []string
+		for i := 0; i < 3; i++ {
+			c.SetSequence(0)
+			data, err := c.ReadPacket()
+			if err != nil {
+				return
+			}
+			sqls = append(sqls, string(data[1:]))
+		}
+		received <- sqls
+		// all statements are received before any result is sent
+		c.SetSequence(1)
+		_ = c.WriteOKPacket(1, 10, mysql.ServerStatusAutocommit, 0)
+		c.SetSequence(1)
+		_ = c.WriteErrorPacket(1062, "23000", "Duplicate entry '1' for key 'PRIMARY'")
+		c.SetSequence(1)
+		_ = c.WriteOKPacket(2, 0, mysql.ServerStatusAutocommit, 0)
+	}()
+
+	sqls := []string{"insert into t values(1)", "insert into t values(1)", "delete from t"}
+	rs, errs := dc.ExecutePipeline(sqls)
+	if got := <-received; len(got) != 3 || got[2] != "delete from t" {
+		t.Fatalf("invalid statements received: %v", got)
+	}
+	if errs[0] != nil || rs[0].AffectedRows != 1 || rs[0].InsertID != 10 {
+		t.Errorf("invalid first result: %+v, error: %v", rs[0], errs[0])
+	}
+	if e, ok := errs[1].(*mysql.SQLError); !ok || e.SQLCode() != 1062 || rs[1] != nil {
+		t.Errorf("expect duplicate entry error, got %v", errs[1])
+	}
+	if errs[2] != nil || rs[2].AffectedRows != 2 {
+		t.Errorf("invalid last result: %+v, error: %v", rs[2], errs[2])
+	}
+
+	// statements without result fail if connection is broken
+	server.Close()
+	_, errs = dc.ExecutePipeline([]string{"delete from t"})
+	if errs[0] == nil || !dc.IsClosed() {
+		t.Errorf("expect error of broken connection, got %v", errs[0])
+	}
+}
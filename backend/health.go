@@ -0,0 +1,150 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// CheckHealth check all backends of slice concurrently, each check returns in timeout
+func (s *Slice) CheckHealth(timeout time.Duration) []*models.BackendHealth {
+	type target struct {
+		cp   ConnectionPool
+		role string
+	}
+	targets := []target{{s.Master, models.BackendRoleMaster}}
+	for _, cp := range s.Slave {
+		targets = append(targets, target{cp, models.BackendRoleSlave})
+	}
+	for _, cp := range s.StatisticSlave {
+		targets = append(targets, target{cp, models.BackendRoleStatisticSlave})
+	}
+
+	ret := make([]*models.BackendHealth, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		if t.cp == nil {
+			ret[i] = &models.BackendHealth{Role: t.role, Error: "backend is not configured"}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, cp ConnectionPool, role string) {
+			defer wg.Done()
+			ret[i] = checkPoolHealth(cp, role, timeout)
+		}(i, t.cp, t.role)
+	}
+	wg.Wait()
+	return ret
+}
+
+// checkPoolHealth run a query on connection of pool, and check replication status of slaves
+func checkPoolHealth(cp ConnectionPool, role string, timeout time.Duration) *models.BackendHealth {
+	h := &models.BackendHealth{Addr: cp.Addr(), Role: role}
+	if cp.Breaker() != nil {
+		h.Circuit = cp.Breaker().State().String()
+	}
+
+	done := make(chan struct{})
+	start := time.Now()
+	// executing statements can not be canceled, so the check is abandoned if it does not finish in time
+	var r models.BackendHealth
+	go func() {
+		defer close(done)
+		r = *h
+		probeBackend(&r, cp, role, timeout)
+	}()
+	select {
+	case <-done:
+		r.Latency = int64(time.Since(start) / time.Millisecond)
+		return &r
+	case <-time.After(timeout):
+		h.Latency = int64(timeout / time.Millisecond)
+		h.Error = fmt.Sprintf("check backend timeout after %v", timeout)
+		return h
+	}
+}
+
+func probeBackend(h *models.BackendHealth, cp ConnectionPool, role string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	pc, err := cp.Get(ctx)
+	if err != nil {
+		h.Error = err.Error()
+		return
+	}
+	defer pc.Recycle()
+
+	if role == models.BackendRoleMaster {
+		if _, err := pc.Execute("SELECT 1"); err != nil {
+			pc.Close()
+			h.Error = err.Error()
+			return
+		}
+		h.Reachable = true
+		return
+	}
+
+	rs, err := pc.Execute("SHOW SLAVE STATUS")
+	if err != nil {
+		if _, ok := err.(*mysql.SQLError); !ok {
+			pc.Close()
+		}
+		h.Error = err.Error()
+		return
+	}
+	h.Reachable = true
+	h.Replication = parseSlaveStatus(rs)
+}
+
+// parseSlaveStatus parse result of SHOW SLAVE STATUS
+func parseSlaveStatus(rs *mysql.Result) *models.ReplicationStatus {
+	r := &models.ReplicationStatus{Lag: -1}
+	if rs == nil || rs.Resultset == nil || rs.RowNumber() == 0 {
+		r.Error = "replication is not configured"
+		return r
+	}
+	io, err := rs.GetStringByName(0, "Slave_IO_Running")
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	sql, err := rs.GetStringByName(0, "Slave_SQL_Running")
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Running = strings.EqualFold(io, "Yes") && strings.EqualFold(sql, "Yes")
+	if !r.Running {
+		r.Error = fmt.Sprintf("replication is not running, Slave_IO_Running: %s, Slave_SQL_Running: %s", io, sql)
+		return r
+	}
+	// Seconds_Behind_Master is NULL if replication is broken
+	if isNull, err := rs.IsNullByName(0, "Seconds_Behind_Master"); err != nil || isNull {
+		r.Error = "Seconds_Behind_Master is unknown"
+		return r
+	}
+	if r.Lag, err = rs.GetIntByName(0, "Seconds_Behind_Master"); err != nil {
+		r.Lag = -1
+		r.Error = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+func slaveStatusResult(ioRunning, sqlRunning string, lag interface{}) *mysql.Result {
+	names := []string{"Slave_IO_Running", "Slave_SQL_Running", "Seconds_Behind_Master"}
+	rs := &mysql.Resultset{FieldNames: make(map[string]int)}
+	for i, name := range names {
+		rs.Fields = append(rs.Fields, &mysql.Field{Name: []byte(name)})
+		rs.FieldNames[name] = i
+	}
+	rs.Values = [][]interface{}{{[]byte(ioRunning), []byte(sqlRunning), lag}}
+	return &mysql.Result{Resultset: rs}
+}
+
+func TestParseSlaveStatus(t *testing.T) {
+	tests := []struct {
+		rs      *mysql.Result
+		running bool
+		lag     int64
+		hasErr  bool
+	}{
+		{slaveStatusResult("Yes", "Yes", []byte("3")), true, 3, false},
+		{slaveStatusResult("Yes", "Yes", []byte("0")), true, 0, false},
+		{slaveStatusResult("Yes", "No", nil), false, -1, true},
+		{slaveStatusResult("Connecting", "Yes", nil), false, -1, true},
+		{slaveStatusResult("Yes", "Yes", nil), true, -1, true},
+		{&mysql.Result{Resultset: &mysql.Resultset{}}, false, -1, true},
+		{&mysql.Result{}, false, -1, true},
+	}
+	for i, test := range tests {
+		r := parseSlaveStatus(test.rs)
+		if r.Running != test.running || r.Lag != test.lag || (r.Error != "") != test.hasErr {
+			t.Errorf("test %d, expect running: %v, lag: %d, error: %v, got %+v", i, test.running, test.lag, test.hasErr, r)
+		}
+	}
+}
@@ -14,6 +14,8 @@ type PooledConnect interface {
 	IsClosed() bool
 	UseDB(db string) error
 	Execute(sql string) (*mysql.Result, error)
+	ExecuteStream(sql string, onFields func([]*mysql.Field) error, onRow func(mysql.RowData) error) (*mysql.Result, error)
+	ExecutePipeline(sqls []string) ([]*mysql.Result, []error)
 	SetAutoCommit(v uint8) error
 	Begin() error
 	Commit() error
@@ -21,8 +23,11 @@ type PooledConnect interface {
 	SetCharset(charset string, collation mysql.CollationID) (bool, error)
 	FieldList(table string, wildcard string) ([]*mysql.Field, error)
 	GetAddr() string
+	GetConnectionID() uint32
+	KillQuery() error
 	SetSessionVariables(frontend *mysql.SessionVariables) (bool, error)
 	WriteSetStatement() error
+	TakeGTIDs() string
 }
 
 type ConnectionPool interface {
@@ -44,4 +49,7 @@ type ConnectionPool interface {
 	WaitTime() time.Duration
 	IdleTimeout() time.Duration
 	IdleClosed() int64
+	LifetimeClosed() int64
+	PingFailed() int64
+	Breaker() *CircuitBreaker
 }
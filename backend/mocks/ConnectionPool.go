@@ -59,6 +59,22 @@ func (_m *ConnectionPool) Available() int64 {
 	return r0
 }
 
+// Breaker provides a mock function with given fields:
+func (_m *ConnectionPool) Breaker() *backend.CircuitBreaker {
+	ret := _m.Called()
+
+	var r0 *backend.CircuitBreaker
+	if rf, ok := ret.Get(0).(func() *backend.CircuitBreaker); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*backend.CircuitBreaker)
+		}
+	}
+
+	return r0
+}
+
 // Capacity provides a mock function with given fields:
 func (_m *ConnectionPool) Capacity() int64 {
 	ret := _m.Called()
@@ -143,6 +159,20 @@ func (_m *ConnectionPool) InUse() int64 {
 	return r0
 }
 
+// LifetimeClosed provides a mock function with given fields:
+func (_m *ConnectionPool) LifetimeClosed() int64 {
+	ret := _m.Called()
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	return r0
+}
+
 // MaxCap provides a mock function with given fields:
 func (_m *ConnectionPool) MaxCap() int64 {
 	ret := _m.Called()
@@ -162,6 +192,20 @@ func (_m *ConnectionPool) Open() {
 	_m.Called()
 }
 
+// PingFailed provides a mock function with given fields:
+func (_m *ConnectionPool) PingFailed() int64 {
+	ret := _m.Called()
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	return r0
+}
+
 // Put provides a mock function with given fields: pc
 func (_m *ConnectionPool) Put(pc backend.PooledConnect) {
 	_m.Called(pc)
@@ -68,6 +68,54 @@ func (_m *PooledConnect) Execute(sql string) (*mysql.Result, error) {
 	return r0, r1
 }
 
+// ExecuteStream provides a mock function with given fields: sql, onFields, onRow
+func (_m *PooledConnect) ExecuteStream(sql string, onFields func([]*mysql.Field) error, onRow func(mysql.RowData) error) (*mysql.Result, error) {
+	ret := _m.Called(sql, onFields, onRow)
+
+	var r0 *mysql.Result
+	if rf, ok := ret.Get(0).(func(string, func([]*mysql.Field) error, func(mysql.RowData) error) *mysql.Result); ok {
+		r0 = rf(sql, onFields, onRow)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*mysql.Result)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, func([]*mysql.Field) error, func(mysql.RowData) error) error); ok {
+		r1 = rf(sql, onFields, onRow)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ExecutePipeline provides a mock function with given fields: sqls
+func (_m *PooledConnect) ExecutePipeline(sqls []string) ([]*mysql.Result, []error) {
+	ret := _m.Called(sqls)
+
+	var r0 []*mysql.Result
+	if rf, ok := ret.Get(0).(func([]string) []*mysql.Result); ok {
+		r0 = rf(sqls)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*mysql.Result)
+		}
+	}
+
+	var r1 []error
+	if rf, ok := ret.Get(1).(func([]string) []error); ok {
+		r1 = rf(sqls)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]error)
+		}
+	}
+
+	return r0, r1
+}
+
 // FieldList provides a mock function with given fields: table, wildcard
 func (_m *PooledConnect) FieldList(table string, wildcard string) ([]*mysql.Field, error) {
 	ret := _m.Called(table, wildcard)
@@ -105,6 +153,20 @@ func (_m *PooledConnect) GetAddr() string {
 	return r0
 }
 
+// GetConnectionID provides a mock function with given fields:
+func (_m *PooledConnect) GetConnectionID() uint32 {
+	ret := _m.Called()
+
+	var r0 uint32
+	if rf, ok := ret.Get(0).(func() uint32); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(uint32)
+	}
+
+	return r0
+}
+
 // IsClosed provides a mock function with given fields:
 func (_m *PooledConnect) IsClosed() bool {
 	ret := _m.Called()
@@ -119,6 +181,20 @@ func (_m *PooledConnect) IsClosed() bool {
 	return r0
 }
 
+// KillQuery provides a mock function with given fields:
+func (_m *PooledConnect) KillQuery() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Reconnect provides a mock function with given fields:
 func (_m *PooledConnect) Reconnect() error {
 	ret := _m.Called()
@@ -208,6 +284,20 @@ func (_m *PooledConnect) SetSessionVariables(frontend *mysql.SessionVariables) (
 	return r0, r1
 }
 
+// TakeGTIDs provides a mock function with given fields:
+func (_m *PooledConnect) TakeGTIDs() string {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
 // UseDB provides a mock function with given fields: db
 func (_m *PooledConnect) UseDB(db string) error {
 	ret := _m.Called(db)
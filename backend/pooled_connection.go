@@ -15,6 +15,9 @@
 package backend
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/XiaoMi/Gaea/mysql"
 )
 
@@ -22,6 +25,7 @@ import (
 type pooledConnectImpl struct {
 	directConnection *DirectConnection
 	pool             *connectionPoolImpl
+	createTime       time.Time
 }
 
 // Recycle return PooledConnect to the pool
@@ -37,11 +41,12 @@ func (pc *pooledConnectImpl) Recycle() {
 // If we get "MySQL server has gone away (errno 2006)", then call Reconnect
 func (pc *pooledConnectImpl) Reconnect() error {
 	pc.directConnection.Close()
-	newConn, err := NewDirectConnection(pc.pool.addr, pc.pool.user, pc.pool.password, pc.pool.db, pc.pool.charset, pc.pool.collationID)
+	newConn, err := pc.pool.newDirectConnection(pc.pool.db)
 	if err != nil {
 		return err
 	}
 	pc.directConnection = newConn
+	pc.createTime = time.Now()
 	return nil
 }
 
@@ -65,7 +70,35 @@ func (pc *pooledConnectImpl) UseDB(db string) error {
 
 // Execute wrapper of direct connection, execute parser
 func (pc *pooledConnectImpl) Execute(sql string) (*mysql.Result, error) {
-	return pc.directConnection.Execute(sql)
+	r, err := pc.directConnection.Execute(sql)
+	pc.pool.recordResult(err)
+	return r, err
+}
+
+// ExecuteStream wrapper of direct connection, execute sql and pass rows to callbacks as they arrive
+func (pc *pooledConnectImpl) ExecuteStream(sql string, onFields func([]*mysql.Field) error, onRow func(mysql.RowData) error) (*mysql.Result, error) {
+	// errors returned by callbacks are not failures of backend
+	var cbErr error
+	r, err := pc.directConnection.ExecuteStream(sql, func(fields []*mysql.Field) error {
+		cbErr = onFields(fields)
+		return cbErr
+	}, func(row mysql.RowData) error {
+		cbErr = onRow(row)
+		return cbErr
+	})
+	if cbErr == nil {
+		pc.pool.recordResult(err)
+	}
+	return r, err
+}
+
+// ExecutePipeline wrapper of direct connection, execute sqls in a single round trip
+func (pc *pooledConnectImpl) ExecutePipeline(sqls []string) ([]*mysql.Result, []error) {
+	rs, errs := pc.directConnection.ExecutePipeline(sqls)
+	for _, err := range errs {
+		pc.pool.recordResult(err)
+	}
+	return rs, errs
 }
 
 // SetAutoCommit wrapper of direct connection, set autocommit
@@ -103,6 +136,24 @@ func (pc *pooledConnectImpl) GetAddr() string {
 	return pc.directConnection.GetAddr()
 }
 
+// GetConnectionID wrapper of return thread id of direct connection
+func (pc *pooledConnectImpl) GetConnectionID() uint32 {
+	return pc.directConnection.GetConnectionID()
+}
+
+// KillQuery kill the running statement of this connection through a new connection to the same backend
+func (pc *pooledConnectImpl) KillQuery() error {
+	id := pc.GetConnectionID()
+	dc, err := pc.pool.newDirectConnection("")
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+
+	_, err = dc.Execute(fmt.Sprintf("KILL QUERY %d", id))
+	return err
+}
+
 // SetSessionVariables set pc variables according to session
 func (pc *pooledConnectImpl) SetSessionVariables(frontend *mysql.SessionVariables) (bool, error) {
 	return pc.directConnection.SetSessionVariables(frontend)
@@ -112,3 +163,8 @@ func (pc *pooledConnectImpl) SetSessionVariables(frontend *mysql.SessionVariable
 func (pc *pooledConnectImpl) WriteSetStatement() error {
 	return pc.directConnection.WriteSetStatement()
 }
+
+// TakeGTIDs return GTID set of the last transaction committed in the connection and clear it
+func (pc *pooledConnectImpl) TakeGTIDs() string {
+	return pc.directConnection.TakeGTIDs()
+}
@@ -34,6 +34,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/XiaoMi/Gaea/core/errors"
 	"github.com/XiaoMi/Gaea/models"
@@ -67,6 +68,8 @@ type Slice struct {
 
 	charset     string
 	collationID mysql.CollationID
+
+	namespace string
 }
 
 // GetSliceName return name of slice
@@ -99,6 +102,33 @@ func (s *Slice) GetConn(fromSlave bool, userType int) (pc PooledConnect, err err
 	return
 }
 
+// GetReadConn get backend connection for read-only statement, read from slaves if master is isolated by circuit breaker
+func (s *Slice) GetReadConn(fromSlave bool, userType int) (PooledConnect, error) {
+	if !fromSlave && len(s.Slave) > 0 && !isPoolAvailable(s.Master) {
+		logging.DefaultLogger.Warnf("master %s is isolated by circuit breaker, read from slave", s.Master.Addr())
+		fromSlave = true
+	}
+	return s.GetConn(fromSlave, userType)
+}
+
+// GetCircuitBreakerStats return state of circuit breakers of master and slaves, backends without circuit breaker are ignored
+func (s *Slice) GetCircuitBreakerStats() []*CircuitBreakerStats {
+	var stats []*CircuitBreakerStats
+	pools := append([]ConnectionPool{s.Master}, s.Slave...)
+	for _, cp := range append(pools, s.StatisticSlave...) {
+		if cp == nil || cp.Breaker() == nil {
+			continue
+		}
+		stats = append(stats, cp.Breaker().Stats())
+	}
+	return stats
+}
+
+// isPoolAvailable return false if the backend is isolated by circuit breaker
+func isPoolAvailable(cp ConnectionPool) bool {
+	return cp == nil || cp.Breaker() == nil || cp.Breaker().Allow()
+}
+
 // GetMasterConn return a connection in master pool
 func (s *Slice) GetMasterConn() (PooledConnect, error) {
 	ctx := context.TODO()
@@ -149,6 +179,21 @@ func (s *Slice) Close() error {
 	return nil
 }
 
+func (s *Slice) poolConfig() PoolConfig {
+	return PoolConfig{
+		MinIdle:      s.Cfg.MinIdle,
+		MaxLifetime:  time.Duration(s.Cfg.MaxLifetime) * time.Second,
+		PingOnBorrow: s.Cfg.PingOnBorrow,
+		Compress:     s.Cfg.Compress,
+
+		ConnectAttrs:  map[string]string{"namespace": s.namespace, "slice": s.Cfg.Name},
+		ProxyProtocol: s.Cfg.ProxyProtocol,
+
+		BreakerFailures:      s.Cfg.CircuitBreakerFailures,
+		BreakerProbeInterval: time.Duration(s.Cfg.CircuitBreakerProbeInterval) * time.Second,
+	}
+}
+
 // ParseMaster create master connection pool
 func (s *Slice) ParseMaster(masterStr string) error {
 	if len(masterStr) == 0 {
@@ -158,7 +203,7 @@ func (s *Slice) ParseMaster(masterStr string) error {
 	if err != nil {
 		return err
 	}
-	s.Master = NewConnectionPool(masterStr, s.Cfg.UserName, s.Cfg.Password, "", s.Cfg.Capacity, s.Cfg.MaxCapacity, idleTimeout, s.charset, s.collationID)
+	s.Master = NewConnectionPool(masterStr, s.Cfg.UserName, s.Cfg.Password, "", s.Cfg.Capacity, s.Cfg.MaxCapacity, idleTimeout, s.charset, s.collationID, s.poolConfig())
 	s.Master.Open()
 	return nil
 }
@@ -193,7 +238,7 @@ func (s *Slice) ParseSlave(slaves []string) error {
 		if err != nil {
 			return err
 		}
-		cp := NewConnectionPool(addrAndWeight[0], s.Cfg.UserName, s.Cfg.Password, "", s.Cfg.Capacity, s.Cfg.MaxCapacity, idleTimeout, s.charset, s.collationID)
+		cp := NewConnectionPool(addrAndWeight[0], s.Cfg.UserName, s.Cfg.Password, "", s.Cfg.Capacity, s.Cfg.MaxCapacity, idleTimeout, s.charset, s.collationID, s.poolConfig())
 		cp.Open()
 		s.Slave = append(s.Slave, cp)
 	}
@@ -231,7 +276,7 @@ func (s *Slice) ParseStatisticSlave(statisticSlaves []string) error {
 		if err != nil {
 			return err
 		}
-		cp := NewConnectionPool(addrAndWeight[0], s.Cfg.UserName, s.Cfg.Password, "", s.Cfg.Capacity, s.Cfg.MaxCapacity, idleTimeout, s.charset, s.collationID)
+		cp := NewConnectionPool(addrAndWeight[0], s.Cfg.UserName, s.Cfg.Password, "", s.Cfg.Capacity, s.Cfg.MaxCapacity, idleTimeout, s.charset, s.collationID, s.poolConfig())
 		cp.Open()
 		s.StatisticSlave = append(s.StatisticSlave, cp)
 	}
@@ -244,3 +289,8 @@ func (s *Slice) SetCharsetInfo(charset string, collationID mysql.CollationID) {
 	s.charset = charset
 	s.collationID = collationID
 }
+
+// SetNamespace set name of namespace which the slice belongs to, it's sent to backend as connection attribute
+func (s *Slice) SetNamespace(namespace string) {
+	s.namespace = namespace
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cdc stream row changes of a namespace as a single logical change stream.
+// Binlog of master of each slice is read from GTID positions, names of sub tables are rewritten to logical
+// tables, and transactions of slices are merged in order of commit timestamp.
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// types of row change
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// StreamRequest request of change stream of namespace
+type StreamRequest struct {
+	Cluster   string            `json:"cluster"`   // empty means default cluster of cc
+	Namespace string            `json:"namespace"` // name of namespace
+	Tables    []string          `json:"tables"`    // logical tables streamed in format of db.table, empty means all tables
+	Positions map[string]string `json:"positions"` // key: slice, value: executed gtid set to start from, current gtid_executed of master is used if not set
+}
+
+// RowChange change of a row in logical table, columns not in table definition are named @1, @2 ...
+type RowChange struct {
+	Type   string                 `json:"type"`
+	DB     string                 `json:"db"`
+	Table  string                 `json:"table"`
+	Before map[string]interface{} `json:"before,omitempty"` // image before update or delete
+	After  map[string]interface{} `json:"after,omitempty"`  // image after insert or update
+}
+
+// Transaction row changes of a transaction committed in a slice, transactions without changes of
+// streamed tables are sent with empty changes to advance positions
+type Transaction struct {
+	Slice     string       `json:"slice"`
+	GTID      string       `json:"gtid"`
+	Position  string       `json:"position"`  // executed gtid set of the slice after the transaction, used to resume stream
+	Timestamp int64        `json:"timestamp"` // commit time in seconds
+	Changes   []*RowChange `json:"changes"`
+}
+
+// NamespaceLoader load decrypted config of namespace
+type NamespaceLoader func(cluster, name string) (*models.Namespace, error)
+
+// Stream read binlog of all slices of namespace and call send with transactions merged in order of commit timestamp,
+// until ctx is done or an error occurs
+func Stream(ctx context.Context, namespace *models.Namespace, req *StreamRequest, send func(*Transaction) error) error {
+	tables, err := newTableMap(namespace, req.Tables)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	slices := tables.slices()
+	sort.Strings(slices)
+	events := make(chan *shardEvent, len(slices)*16)
+	errC := make(chan error, len(slices))
+	for _, name := range slices {
+		slice := findSlice(namespace, name)
+		if slice == nil {
+			return fmt.Errorf("slice %s not found in namespace %s", name, namespace.Name)
+		}
+		r, err := newShardReader(namespace, slice, tables, req.Positions[name])
+		if err != nil {
+			return err
+		}
+		defer r.close()
+		go func() {
+			errC <- r.run(ctx, events)
+		}()
+	}
+
+	m := newMerger(slices)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errC:
+			return err
+		case e := <-events:
+			m.push(e)
+			for {
+				txn := m.pop()
+				if txn == nil {
+					break
+				}
+				if err := send(txn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func findSlice(namespace *models.Namespace, name string) *models.Slice {
+	for _, s := range namespace.Slices {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// shardEvent transaction read from binlog of a slice, or heartbeat if txn is nil
+type shardEvent struct {
+	slice     string
+	txn       *Transaction
+	watermark int64 // transactions committed in the slice later are not earlier than watermark, in seconds
+}
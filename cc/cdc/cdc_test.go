@@ -0,0 +1,119 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdc
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func TestTableMap(t *testing.T) {
+	ns := &models.Namespace{
+		Name:          "ns",
+		AllowedDBS:    map[string]bool{"db": true},
+		DefaultPhyDBS: map[string]string{"db": "db_phy"},
+		DefaultSlice:  "slice-0",
+		Slices:        []*models.Slice{{Name: "slice-0"}, {Name: "slice-1"}},
+		ShardRules: []*models.Shard{
+			{DB: "db", Table: "tbl", Type: models.ShardHash, Key: "id", Slices: []string{"slice-0", "slice-1"}, Locations: []int{2, 2}},
+			{DB: "db", Table: "tbl_global", Type: models.ShardGlobal, Slices: []string{"slice-0", "slice-1"}, Locations: []int{1, 1}},
+		},
+	}
+	m, err := newTableMap(ns, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		slice, phyDB, phyTable string
+		table                  string // empty means not streamed
+	}{
+		{"slice-0", "db", "tbl_0000", "db.tbl"},
+		{"slice-0", "db", "tbl_0002", ""},
+		{"slice-1", "db", "tbl_0003", "db.tbl"},
+		{"slice-0", "db_phy", "tbl", ""},
+		{"slice-0", "db_phy", "tbl_user", "db.tbl_user"},
+		{"slice-1", "db_phy", "tbl_user", ""},
+		{"slice-0", "mysql", "user", ""},
+		{"slice-0", "db", "tbl_global", "db.tbl_global"},
+		{"slice-1", "db", "tbl_global", ""},
+	}
+	for _, test := range tests {
+		db, table, ok := m.lookup(test.slice, test.phyDB, test.phyTable)
+		if (test.table != "") != ok || (ok && db+"."+table != test.table) {
+			t.Errorf("lookup %s %s.%s: %s.%s %v, expect: %s", test.slice, test.phyDB, test.phyTable, db, table, ok, test.table)
+		}
+	}
+
+	m, err = newTableMap(ns, []string{"db.TBL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := m.lookup("slice-1", "db", "tbl_0002"); !ok {
+		t.Errorf("sub table of streamed table is filtered")
+	}
+	if _, _, ok := m.lookup("slice-0", "db_phy", "tbl_user"); ok {
+		t.Errorf("table not streamed is not filtered")
+	}
+}
+
+func TestMerger(t *testing.T) {
+	m := newMerger([]string{"slice-0", "slice-1"})
+	txn := func(slice string, ts int64) *shardEvent {
+		return &shardEvent{slice: slice, txn: &Transaction{Slice: slice, Timestamp: ts}, watermark: ts}
+	}
+	pop := func(expect string, ts int64) {
+		txn := m.pop()
+		if expect == "" {
+			if txn != nil {
+				t.Fatalf("unexpected transaction of %s at %d", txn.Slice, txn.Timestamp)
+			}
+			return
+		}
+		if txn == nil || txn.Slice != expect || txn.Timestamp != ts {
+			t.Fatalf("expect transaction of %s at %d, got: %v", expect, ts, txn)
+		}
+	}
+
+	// wait for slice-1 which may have earlier transactions
+	m.push(txn("slice-0", 10))
+	m.push(txn("slice-0", 12))
+	pop("", 0)
+
+	m.push(txn("slice-1", 11))
+	pop("slice-0", 10)
+	pop("slice-1", 11)
+	pop("", 0)
+
+	// heartbeat of idle slice-1
+	m.push(&shardEvent{slice: "slice-1", watermark: 12})
+	pop("slice-0", 12)
+	pop("", 0)
+
+	m.push(txn("slice-1", 13))
+	m.push(txn("slice-1", 13))
+	pop("", 0)
+	m.push(&shardEvent{slice: "slice-0", watermark: 20})
+	pop("slice-1", 13)
+	pop("slice-1", 13)
+	pop("", 0)
+}
+
+func TestFormatGTID(t *testing.T) {
+	sid := []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+	if s := formatGTID(sid, 23); s != "3e11fa47-71ca-11e1-9e33-c80aa9429562:23" {
+		t.Errorf("format gtid error: %s", s)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/XiaoMi/Gaea/cc/proxy"
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// The change stream is served by gRPC service gaea.cdc.VStream with server streaming method Stream.
+// Messages are encoded in json instead of protobuf, clients should call with content subtype json,
+// and send username and password of cc admin in metadata.
+
+const (
+	// CodecName content subtype of messages of the service
+	CodecName = "json"
+	// StreamMethod full name of the streaming method
+	StreamMethod = "/gaea.cdc.VStream/Stream"
+
+	metadataUserName = "username"
+	metadataPassword = "password"
+)
+
+// jsonCodec encode messages in json
+type jsonCodec struct{}
+
+// Marshal implement encoding.Codec
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implement encoding.Codec
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name implement encoding.Codec
+func (jsonCodec) Name() string {
+	return CodecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// vstreamServer is the handler type of service
+type vstreamServer interface {
+	stream(req *StreamRequest, s grpc.ServerStream) error
+}
+
+var streamDesc = grpc.StreamDesc{
+	StreamName:    "Stream",
+	ServerStreams: true,
+	Handler: func(srv interface{}, s grpc.ServerStream) error {
+		req := &StreamRequest{}
+		if err := s.RecvMsg(req); err != nil {
+			return err
+		}
+		return srv.(vstreamServer).stream(req, s)
+	},
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "gaea.cdc.VStream",
+	HandlerType: (*vstreamServer)(nil),
+	Streams:     []grpc.StreamDesc{streamDesc},
+	Metadata:    "cdc",
+}
+
+// Server serve change streams of namespaces over gRPC
+type Server struct {
+	cfg      *models.CCConfig
+	load     NamespaceLoader
+	server   *grpc.Server
+	listener net.Listener
+}
+
+// NewServer create gRPC server listening on addr, namespaces are loaded by load
+func NewServer(addr string, cfg *models.CCConfig, load NamespaceLoader) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{cfg: cfg, load: load, server: grpc.NewServer(), listener: l}
+	s.server.RegisterService(&serviceDesc, s)
+	return s, nil
+}
+
+// Run serve until server is closed
+func (s *Server) Run() error {
+	return s.server.Serve(s.listener)
+}
+
+// Close stop server and all streams
+func (s *Server) Close() {
+	s.server.Stop()
+}
+
+func (s *Server) authenticate(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if get(md, metadataUserName) != s.cfg.AdminUserName || get(md, metadataPassword) != s.cfg.AdminPassword {
+		return status.Error(codes.Unauthenticated, "invalid username or password")
+	}
+	return nil
+}
+
+func get(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (s *Server) stream(req *StreamRequest, ss grpc.ServerStream) error {
+	ctx := ss.Context()
+	if err := s.authenticate(ctx); err != nil {
+		return err
+	}
+	cluster := req.Cluster
+	if cluster == "" {
+		cluster = s.cfg.DefaultCluster
+	}
+	namespace, err := s.load(cluster, req.Namespace)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "load namespace %s error: %v", req.Namespace, err)
+	}
+	if namespace == nil {
+		return status.Errorf(codes.NotFound, "namespace %s not found", req.Namespace)
+	}
+
+	proxy.ControllerLogger.Infof("start change stream of namespace %s, tables: %v, positions: %v", req.Namespace, req.Tables, req.Positions)
+	err = Stream(ctx, namespace, req, func(txn *Transaction) error {
+		return ss.SendMsg(txn)
+	})
+	proxy.ControllerLogger.Infof("change stream of namespace %s stopped: %v", req.Namespace, err)
+	if err == nil || ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Client read change stream from cc
+type Client struct {
+	conn     *grpc.ClientConn
+	userName string
+	password string
+}
+
+// NewClient connect to change stream service of cc
+func NewClient(addr, userName, password string) (*Client, error) {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CodecName)))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, userName: userName, password: password}, nil
+}
+
+// Stream call recv with transactions of change stream until ctx is done or an error occurs
+func (c *Client) Stream(ctx context.Context, req *StreamRequest, recv func(*Transaction) error) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, metadataUserName, c.userName, metadataPassword, c.password)
+	s, err := c.conn.NewStream(ctx, &streamDesc, StreamMethod)
+	if err != nil {
+		return err
+	}
+	if err := s.SendMsg(req); err != nil {
+		return err
+	}
+	if err := s.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		txn := &Transaction{}
+		if err := s.RecvMsg(txn); err != nil {
+			return fmt.Errorf("receive change stream error: %v", err)
+		}
+		if err := recv(txn); err != nil {
+			return err
+		}
+	}
+}
+
+// Close close connection to cc
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdc
+
+// merger merge transactions of slices in order of commit timestamp. A transaction is sent only if no earlier
+// transaction can be read from other slices, that is each other slice has pending transactions, or its watermark
+// is not earlier than the transaction. Transactions of the same slice keep the order in binlog.
+type merger struct {
+	slices     []string
+	pending    map[string][]*Transaction
+	watermarks map[string]int64
+}
+
+func newMerger(slices []string) *merger {
+	return &merger{
+		slices:     slices,
+		pending:    make(map[string][]*Transaction, len(slices)),
+		watermarks: make(map[string]int64, len(slices)),
+	}
+}
+
+func (m *merger) push(e *shardEvent) {
+	if e.watermark > m.watermarks[e.slice] {
+		m.watermarks[e.slice] = e.watermark
+	}
+	if e.txn != nil {
+		m.pending[e.slice] = append(m.pending[e.slice], e.txn)
+	}
+}
+
+// pop return the earliest transaction which can be sent, nil if there is none
+func (m *merger) pop() *Transaction {
+	first := ""
+	for _, slice := range m.slices {
+		if len(m.pending[slice]) == 0 {
+			continue
+		}
+		if first == "" || m.pending[slice][0].Timestamp < m.pending[first][0].Timestamp {
+			first = slice
+		}
+	}
+	if first == "" {
+		return nil
+	}
+	txn := m.pending[first][0]
+	for _, slice := range m.slices {
+		if slice != first && len(m.pending[slice]) == 0 && m.watermarks[slice] < txn.Timestamp {
+			return nil
+		}
+	}
+	m.pending[first] = m.pending[first][1:]
+	return txn
+}
@@ -0,0 +1,271 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	gomysql "github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+const (
+	// server ids of binlog dump connections, they must differ from server ids of mysql instances and resharding
+	minCDCServerID = 2000000
+	maxCDCServerID = 3000000
+
+	// master sends heartbeat when there is no binlog event in the period
+	heartbeatPeriod = time.Second
+	// clocks of masters and cc may differ, watermark of heartbeat is earlier than time of cc by the skew
+	maxClockSkew = time.Second
+)
+
+// shardReader read transactions of streamed tables from binlog of master of a slice
+type shardReader struct {
+	slice  string
+	tables *tableMap
+	gtids  gomysql.GTIDSet // executed gtid set of transactions read
+	syncer *replication.BinlogSyncer
+	conn   *backend.DirectConnection // query column names of physical tables
+
+	columns map[string][]string // key: phy_db.phy_table, value: column names
+}
+
+func newShardReader(namespace *models.Namespace, slice *models.Slice, tables *tableMap, position string) (*shardReader, error) {
+	conn, err := backend.NewDirectConnection(slice.Master, slice.UserName, slice.Password, "", mysql.DefaultCharset, mysql.DefaultCollationID, false)
+	if err != nil {
+		return nil, fmt.Errorf("connect to master %s of slice %s error: %v", slice.Master, slice.Name, err)
+	}
+	r := &shardReader{
+		slice:   slice.Name,
+		tables:  tables,
+		conn:    conn,
+		columns: make(map[string][]string),
+	}
+	if position == "" {
+		if position, err = r.executedGTIDs(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.gtids, err = gomysql.ParseMysqlGTIDSet(position); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid position %s of slice %s: %v", position, slice.Name, err)
+	}
+
+	host, port, err := net.SplitHostPort(slice.Master)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r.syncer = replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID:        uint32(minCDCServerID + time.Now().UnixNano()%(maxCDCServerID-minCDCServerID)),
+		Flavor:          gomysql.MySQLFlavor,
+		Host:            host,
+		Port:            uint16(p),
+		User:            slice.UserName,
+		Password:        slice.Password,
+		UseDecimal:      true, // keep precision of decimals
+		HeartbeatPeriod: heartbeatPeriod,
+	})
+	return r, nil
+}
+
+func (r *shardReader) executedGTIDs() (string, error) {
+	ret, err := r.conn.Execute("SELECT @@GLOBAL.gtid_executed")
+	if err != nil {
+		return "", fmt.Errorf("query gtid_executed of slice %s error: %v", r.slice, err)
+	}
+	if ret.Resultset == nil || ret.RowNumber() == 0 {
+		return "", fmt.Errorf("gtid of master of slice %s is disabled", r.slice)
+	}
+	gtids, err := ret.GetString(0, 0)
+	if err != nil {
+		return "", err
+	}
+	// gtid set of many server uuids is separated by newline
+	return strings.Replace(gtids, "\n", "", -1), nil
+}
+
+// run read binlog from gtid set, and send transactions and heartbeats to events until ctx is done
+func (r *shardReader) run(ctx context.Context, events chan<- *shardEvent) error {
+	streamer, err := r.syncer.StartSyncGTID(r.gtids.Clone())
+	if err != nil {
+		return fmt.Errorf("start binlog sync of slice %s error: %v", r.slice, err)
+	}
+	send := func(e *shardEvent) error {
+		select {
+		case events <- e:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var txn *Transaction
+	for {
+		ev, err := streamer.GetEvent(ctx)
+		if err != nil {
+			return fmt.Errorf("read binlog of slice %s error: %v", r.slice, err)
+		}
+		switch e := ev.Event.(type) {
+		case *replication.GTIDEvent:
+			txn = &Transaction{Slice: r.slice, GTID: formatGTID(e.SID, e.GNO), Timestamp: int64(ev.Header.Timestamp)}
+		case *replication.RowsEvent:
+			if txn == nil {
+				continue
+			}
+			changes, err := r.rowChanges(ev.Header.EventType, e)
+			if err != nil {
+				return err
+			}
+			txn.Changes = append(txn.Changes, changes...)
+		case *replication.XIDEvent:
+			if err := r.commit(txn, send); err != nil {
+				return err
+			}
+			txn = nil
+		case *replication.QueryEvent:
+			// DDL is committed implicitly, it's not streamed but its gtid is added to position
+			if txn != nil && !strings.EqualFold(string(e.Query), "BEGIN") {
+				txn.Changes = nil
+				if err := r.commit(txn, send); err != nil {
+					return err
+				}
+				txn = nil
+				// table definitions may be changed
+				r.columns = make(map[string][]string)
+			}
+		default:
+			if ev.Header.EventType == replication.HEARTBEAT_EVENT {
+				watermark := time.Now().Add(-maxClockSkew).Unix()
+				if err := send(&shardEvent{slice: r.slice, watermark: watermark}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (r *shardReader) commit(txn *Transaction, send func(*shardEvent) error) error {
+	if txn == nil {
+		return nil
+	}
+	if err := r.gtids.Update(txn.GTID); err != nil {
+		return fmt.Errorf("update gtid set of slice %s error: %v", r.slice, err)
+	}
+	txn.Position = r.gtids.String()
+	return send(&shardEvent{slice: r.slice, txn: txn, watermark: txn.Timestamp})
+}
+
+// rowChanges return changes of rows event with logical table names, nil if the table is not streamed
+func (r *shardReader) rowChanges(eventType replication.EventType, e *replication.RowsEvent) ([]*RowChange, error) {
+	phyDB, phyTable := string(e.Table.Schema), string(e.Table.Table)
+	db, table, ok := r.tables.lookup(r.slice, phyDB, phyTable)
+	if !ok {
+		return nil, nil
+	}
+	columns, err := r.columnNames(phyDB, phyTable, int(e.Table.ColumnCount))
+	if err != nil {
+		return nil, err
+	}
+	row := func(values []interface{}) map[string]interface{} {
+		m := make(map[string]interface{}, len(values))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			m[columns[i]] = v
+		}
+		return m
+	}
+
+	var changes []*RowChange
+	switch eventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		for _, values := range e.Rows {
+			changes = append(changes, &RowChange{Type: ChangeInsert, DB: db, Table: table, After: row(values)})
+		}
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		// rows are pairs of before and after images
+		for i := 0; i+1 < len(e.Rows); i += 2 {
+			changes = append(changes, &RowChange{Type: ChangeUpdate, DB: db, Table: table, Before: row(e.Rows[i]), After: row(e.Rows[i+1])})
+		}
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, values := range e.Rows {
+			changes = append(changes, &RowChange{Type: ChangeDelete, DB: db, Table: table, Before: row(values)})
+		}
+	}
+	return changes, nil
+}
+
+// columnNames return names of columns of physical table, columns not in current definition are named @1, @2 ...
+func (r *shardReader) columnNames(phyDB, phyTable string, count int) ([]string, error) {
+	key := phyDB + "." + phyTable
+	columns, ok := r.columns[key]
+	if !ok || len(columns) != count {
+		sql := fmt.Sprintf("SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = '%s' AND TABLE_NAME = '%s' ORDER BY ORDINAL_POSITION",
+			mysql.Escape(phyDB), mysql.Escape(phyTable))
+		ret, err := r.conn.Execute(sql)
+		if err != nil {
+			return nil, fmt.Errorf("query columns of %s in slice %s error: %v", key, r.slice, err)
+		}
+		columns = make([]string, 0, count)
+		for i := 0; ret.Resultset != nil && i < ret.RowNumber(); i++ {
+			name, err := ret.GetString(i, 0)
+			if err != nil {
+				return nil, err
+			}
+			columns = append(columns, name)
+		}
+		r.columns[key] = columns
+	}
+	names := make([]string, count)
+	for i := range names {
+		if i < len(columns) {
+			names[i] = columns[i]
+		} else {
+			names[i] = "@" + strconv.Itoa(i+1)
+		}
+	}
+	return names, nil
+}
+
+func (r *shardReader) close() {
+	r.syncer.Close()
+	r.conn.Close()
+}
+
+// formatGTID return gtid in format of uuid:gno
+func formatGTID(sid []byte, gno int64) string {
+	if len(sid) != 16 {
+		return fmt.Sprintf("%x:%d", sid, gno)
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x:%d", sid[0:4], sid[4:6], sid[6:8], sid[8:10], sid[10:16], gno)
+}
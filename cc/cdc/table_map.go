@@ -0,0 +1,134 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/proxy/router"
+)
+
+// logicalTable logical name of physical table in binlog, ignored tables are skipped
+type logicalTable struct {
+	db      string
+	table   string
+	ignored bool
+}
+
+// tableMap map physical tables in binlog of each slice to logical tables of namespace
+type tableMap struct {
+	defaultSlice string
+	tables       map[string]map[string]logicalTable // key: slice, phy_db.phy_table
+	defaultDBs   map[string]string                  // key: physical db in default slice, value: logical db
+	filter       map[string]bool                    // logical db.table streamed, empty means all tables
+}
+
+func subTableName(rule router.Rule, index int) string {
+	if rule.GetType() == router.GlobalTableRuleType || router.IsMycatShardingRule(rule.GetType()) {
+		return rule.GetTable()
+	}
+	return fmt.Sprintf("%s_%04d", rule.GetTable(), index)
+}
+
+// newTableMap build map of physical tables from shard rules of namespace, tables filters logical tables streamed.
+// Tables not in shard rules are unsharded tables in default slice. Global and broadcast tables have the same rows
+// in all slices, so only rows in the first slice are streamed.
+func newTableMap(namespace *models.Namespace, tables []string) (*tableMap, error) {
+	rt, err := router.NewRouter(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("build router of namespace %s error: %v", namespace.Name, err)
+	}
+	m := &tableMap{
+		defaultSlice: namespace.DefaultSlice,
+		tables:       make(map[string]map[string]logicalTable),
+		defaultDBs:   make(map[string]string),
+		filter:       make(map[string]bool, len(tables)),
+	}
+	for _, t := range tables {
+		m.filter[strings.ToLower(t)] = true
+	}
+	for db := range namespace.AllowedDBS {
+		phyDB := db
+		if v, ok := namespace.DefaultPhyDBS[db]; ok && v != "" {
+			phyDB = v
+		}
+		m.defaultDBs[phyDB] = db
+	}
+
+	for _, shard := range namespace.ShardRules {
+		rule, ok := rt.GetShardRule(shard.DB, shard.Table)
+		if !ok {
+			continue
+		}
+		// the unsharded table of dual write table in default slice has the same rows as sub tables
+		for phyDB, db := range m.defaultDBs {
+			if db == rule.GetDB() {
+				m.add(m.defaultSlice, phyDB, rule.GetTable(), logicalTable{ignored: true})
+			}
+		}
+		indexes := rule.GetSubTableIndexes()
+		if rule.GetType() == router.GlobalTableRuleType || rule.IsBroadcastRule() {
+			indexes = indexes[:1]
+		}
+		for _, index := range indexes {
+			phyDB, err := rule.GetDatabaseNameByTableIndex(index)
+			if err != nil {
+				return nil, fmt.Errorf("get database of %s.%s sub table %d error: %v", rule.GetDB(), rule.GetTable(), index, err)
+			}
+			slice := rule.GetSlice(rule.GetSliceIndexFromTableIndex(index))
+			m.add(slice, phyDB, subTableName(rule, index), logicalTable{db: rule.GetDB(), table: rule.GetTable()})
+		}
+	}
+	return m, nil
+}
+
+func (m *tableMap) add(slice, phyDB, phyTable string, t logicalTable) {
+	if m.tables[slice] == nil {
+		m.tables[slice] = make(map[string]logicalTable)
+	}
+	m.tables[slice][strings.ToLower(phyDB+"."+phyTable)] = t
+}
+
+// slices return slices with tables streamed
+func (m *tableMap) slices() []string {
+	ret := []string{m.defaultSlice}
+	for slice := range m.tables {
+		if slice != m.defaultSlice {
+			ret = append(ret, slice)
+		}
+	}
+	return ret
+}
+
+// lookup return logical table of physical table in binlog of slice, return false if rows of the table are not streamed
+func (m *tableMap) lookup(slice, phyDB, phyTable string) (string, string, bool) {
+	t, ok := m.tables[slice][strings.ToLower(phyDB+"."+phyTable)]
+	if !ok {
+		db, isDefault := m.defaultDBs[phyDB]
+		if slice != m.defaultSlice || !isDefault {
+			return "", "", false
+		}
+		t = logicalTable{db: db, table: phyTable}
+	}
+	if t.ignored {
+		return "", "", false
+	}
+	if len(m.filter) != 0 && !m.filter[strings.ToLower(t.db+"."+t.table)] {
+		return "", "", false
+	}
+	return t.db, t.table, true
+}
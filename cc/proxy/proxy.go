@@ -146,3 +146,9 @@ func QueryProxyConfigFingerprint(host string, cfg *models.CCConfig) (string, err
 	configFingerprint, err := c.proxyConfigFingerprint()
 	return configFingerprint, err
 }
+
+// QueryProxyHealth return readiness of proxy with health of backends
+func QueryProxyHealth(host string, cfg *models.CCConfig, maxLag int64) (*models.ProxyHealth, error) {
+	c := NewAPIClient(host, cfg.ProxyUserName, cfg.ProxyPassword)
+	return c.GetProxyHealth(maxLag)
+}
@@ -16,7 +16,11 @@ package proxy
 
 import (
 	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
 
+	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/util/requests"
 )
 
@@ -77,6 +81,25 @@ func (c *APIClient) proxyConfigFingerprint() (string, error) {
 	return r, err
 }
 
+// GetProxyHealth return readiness of proxy, health is returned with 503 if proxy is not ready
+func (c *APIClient) GetProxyHealth(maxLag int64) (*models.ProxyHealth, error) {
+	url := c.encodeURL("/readyz")
+	req := requests.NewRequest(url, requests.Get, nil, map[string]string{"max_replication_lag": strconv.FormatInt(maxLag, 10)}, nil)
+	req.SetBasicAuth(c.user, c.password)
+	resp, err := requests.Send(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil, errors.New(string(resp.Body))
+	}
+	var h models.ProxyHealth
+	if err := json.Unmarshal(resp.Body, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
 // Ping ping proxy
 func (c *APIClient) Ping() error {
 	url := c.encodeURL("/api/proxy/ping")
@@ -19,11 +19,14 @@ import (
 	"github.com/XiaoMi/Gaea/cc/proxy"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 
+	"github.com/XiaoMi/Gaea/cc/cdc"
 	"github.com/XiaoMi/Gaea/cc/service"
 	"github.com/XiaoMi/Gaea/models"
 )
@@ -32,6 +35,13 @@ import (
 type Server struct {
 	cfg *models.CCConfig
 
+	mirrors  *service.NamespaceMirrors
+	reshards *service.ReshardTasks
+	checks   *service.ConsistencyChecks
+	backups  *service.BackupTasks
+	restores *service.RestoreTasks
+	cdc      *cdc.Server // serve change streams of namespaces over gRPC, nil if disabled
+
 	engine   *gin.Engine
 	listener net.Listener
 
@@ -46,7 +56,15 @@ type RetHeader struct {
 
 // NewServer constructor of Server
 func NewServer(addr string, cfg *models.CCConfig) (*Server, error) {
-	srv := &Server{cfg: cfg, exitC: make(chan struct{})}
+	srv := &Server{
+		cfg:      cfg,
+		mirrors:  service.NewNamespaceMirrors(cfg),
+		reshards: service.NewReshardTasks(cfg),
+		checks:   service.NewConsistencyChecks(cfg),
+		backups:  service.NewBackupTasks(cfg),
+		restores: service.NewRestoreTasks(cfg),
+		exitC:    make(chan struct{}),
+	}
 	srv.engine = gin.New()
 
 	l, err := net.Listen("tcp", addr)
@@ -55,6 +73,14 @@ func NewServer(addr string, cfg *models.CCConfig) (*Server, error) {
 	}
 	srv.listener = l
 	srv.registerURL()
+
+	if cfg.CDCAddr != "" {
+		srv.cdc, err = cdc.NewServer(cfg.CDCAddr, cfg, srv.loadNamespace)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("listen on cdc_addr %s error: %v", cfg.CDCAddr, err)
+		}
+	}
 	return srv, nil
 }
 
@@ -69,9 +95,29 @@ func (s *Server) registerURL() {
 	api.GET("/namespace", s.queryNamespace)
 	api.GET("/namespace/detail/:name", s.detailNamespace)
 	api.PUT("/namespace/modify", s.modifyNamespace)
+	api.PUT("/namespace/validate", s.validateNamespace)
 	api.PUT("/namespace/delete/:name", s.delNamespace)
+	api.PUT("/namespace/sync/:name", s.syncNamespace)
+	api.PUT("/namespace/mirror/start/:name", s.startNamespaceMirror)
+	api.PUT("/namespace/mirror/stop/:name", s.stopNamespaceMirror)
+	api.GET("/namespace/mirror/list", s.listNamespaceMirrors)
+	api.PUT("/namespace/reshard/start", s.startReshard)
+	api.PUT("/namespace/reshard/cancel/:name", s.cancelReshard)
+	api.GET("/namespace/reshard/list", s.listReshards)
+	api.PUT("/namespace/check/start", s.startConsistencyCheck)
+	api.PUT("/namespace/check/cancel/:name", s.cancelConsistencyCheck)
+	api.GET("/namespace/check/list", s.listConsistencyChecks)
+	api.PUT("/namespace/backup/start", s.startBackup)
+	api.PUT("/namespace/backup/cancel/:name", s.cancelBackup)
+	api.GET("/namespace/backup/list", s.listBackups)
+	api.PUT("/namespace/restore/start", s.startRestore)
+	api.PUT("/namespace/restore/cancel/:name", s.cancelRestore)
+	api.GET("/namespace/restore/list", s.listRestores)
+	api.PUT("/namespace/export/stream", s.exportStream)
+	api.PUT("/namespace/export/files", s.exportFiles)
 	api.GET("/namespace/sqlfingerprint/:name", s.sqlFingerprint)
 	api.GET("/proxy/source/fingerprint", s.proxyConfigFingerprint)
+	api.GET("/cluster/health", s.clusterHealth)
 }
 
 // ListNamespaceResp list names of all namespace response
@@ -190,6 +236,32 @@ func (s *Server) modifyNamespace(c *gin.Context) {
 	return
 }
 
+// ValidateNamespaceResp validate namespace response
+type ValidateNamespaceResp struct {
+	RetHeader *RetHeader                `json:"ret_header"`
+	Data      *service.ValidationResult `json:"data"`
+}
+
+// validateNamespace check namespace in body without saving it
+func (s *Server) validateNamespace(c *gin.Context) {
+	var namespace models.Namespace
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	r := &ValidateNamespaceResp{RetHeader: h}
+
+	if err := c.BindJSON(&namespace); err != nil {
+		proxy.ControllerLogger.Warnf("validateNamespace got invalid data, err: %v", err)
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+	r.Data = service.ValidateNamespace(&namespace)
+
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, r)
+	return
+}
+
 func (s *Server) delNamespace(c *gin.Context) {
 	var err error
 	h := &RetHeader{RetCode: -1, RetMessage: ""}
@@ -213,6 +285,371 @@ func (s *Server) delNamespace(c *gin.Context) {
 	return
 }
 
+// SyncNamespaceResp sync namespace response
+type SyncNamespaceResp struct {
+	RetHeader *RetHeader          `json:"ret_header"`
+	Data      *service.SyncResult `json:"data"`
+}
+
+// syncNamespace copy namespace from source_cluster to target_cluster once
+func (s *Server) syncNamespace(c *gin.Context) {
+	var err error
+	r := &SyncNamespaceResp{RetHeader: &RetHeader{RetCode: -1, RetMessage: ""}}
+	name := strings.TrimSpace(c.Param("name"))
+	sourceCluster := c.DefaultQuery("source_cluster", s.cfg.DefaultCluster)
+	targetCluster := c.Query("target_cluster")
+	if name == "" || targetCluster == "" {
+		r.RetHeader.RetMessage = "input name or target_cluster is empty"
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	force := c.Query("force") == "true"
+	r.Data, err = service.SyncNamespace(name, s.cfg, sourceCluster, targetCluster, force)
+	if err != nil {
+		proxy.ControllerLogger.Warnf("sync namespace %s failed, %v", name, err)
+		r.RetHeader.RetMessage = err.Error()
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	r.RetHeader.RetCode = 0
+	r.RetHeader.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, r)
+	return
+}
+
+// startNamespaceMirror synchronize namespace from source_cluster to target_cluster every interval seconds
+func (s *Server) startNamespaceMirror(c *gin.Context) {
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	name := strings.TrimSpace(c.Param("name"))
+	sourceCluster := c.DefaultQuery("source_cluster", s.cfg.DefaultCluster)
+	targetCluster := c.Query("target_cluster")
+	if name == "" || targetCluster == "" {
+		h.RetMessage = "input name or target_cluster is empty"
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	interval, err := strconv.Atoi(c.DefaultQuery("interval", "0"))
+	if err != nil {
+		h.RetMessage = fmt.Sprintf("invalid interval, %v", err)
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	if err := s.mirrors.Start(name, sourceCluster, targetCluster, time.Duration(interval)*time.Second); err != nil {
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+func (s *Server) stopNamespaceMirror(c *gin.Context) {
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	name := strings.TrimSpace(c.Param("name"))
+	targetCluster := c.Query("target_cluster")
+	if err := s.mirrors.Stop(name, targetCluster); err != nil {
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+// ListNamespaceMirrorsResp list namespace mirrors response
+type ListNamespaceMirrorsResp struct {
+	RetHeader *RetHeader             `json:"ret_header"`
+	Data      []service.MirrorStatus `json:"data"`
+}
+
+func (s *Server) listNamespaceMirrors(c *gin.Context) {
+	r := &ListNamespaceMirrorsResp{RetHeader: &RetHeader{RetCode: 0, RetMessage: "SUCC"}}
+	r.Data = s.mirrors.List()
+	c.JSON(http.StatusOK, r)
+	return
+}
+
+// startReshard move sub tables of a sharding table to the new layout in json body
+func (s *Server) startReshard(c *gin.Context) {
+	var req service.ReshardRequest
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	if err := c.BindJSON(&req); err != nil {
+		proxy.ControllerLogger.Warnf("startReshard failed, err: %v", err)
+		c.JSON(http.StatusBadRequest, h)
+		return
+	}
+	if req.Cluster == "" {
+		req.Cluster = s.cfg.DefaultCluster
+	}
+	if err := s.reshards.Start(&req); err != nil {
+		proxy.ControllerLogger.Warnf("startReshard failed, err: %v", err)
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+func (s *Server) cancelReshard(c *gin.Context) {
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	name := strings.TrimSpace(c.Param("name"))
+	cluster := c.DefaultQuery("cluster", s.cfg.DefaultCluster)
+	if err := s.reshards.Cancel(cluster, name); err != nil {
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+// ListReshardsResp list resharding tasks response
+type ListReshardsResp struct {
+	RetHeader *RetHeader              `json:"ret_header"`
+	Data      []service.ReshardStatus `json:"data"`
+}
+
+func (s *Server) listReshards(c *gin.Context) {
+	r := &ListReshardsResp{RetHeader: &RetHeader{RetCode: 0, RetMessage: "SUCC"}}
+	r.Data = s.reshards.List()
+	c.JSON(http.StatusOK, r)
+	return
+}
+
+// startBackup dump slices of namespace and copy binlog up to a consistent point, request is in json body
+func (s *Server) startBackup(c *gin.Context) {
+	var req service.BackupRequest
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	if err := c.BindJSON(&req); err != nil {
+		proxy.ControllerLogger.Warnf("startBackup failed, err: %v", err)
+		c.JSON(http.StatusBadRequest, h)
+		return
+	}
+	if req.Cluster == "" {
+		req.Cluster = s.cfg.DefaultCluster
+	}
+	if err := s.backups.Start(&req); err != nil {
+		proxy.ControllerLogger.Warnf("startBackup failed, err: %v", err)
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+func (s *Server) cancelBackup(c *gin.Context) {
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	name := strings.TrimSpace(c.Param("name"))
+	cluster := c.DefaultQuery("cluster", s.cfg.DefaultCluster)
+	if err := s.backups.Cancel(cluster, name); err != nil {
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+// ListBackupsResp list backup tasks response
+type ListBackupsResp struct {
+	RetHeader *RetHeader             `json:"ret_header"`
+	Data      []service.BackupStatus `json:"data"`
+}
+
+func (s *Server) listBackups(c *gin.Context) {
+	r := &ListBackupsResp{RetHeader: &RetHeader{RetCode: 0, RetMessage: "SUCC"}}
+	r.Data = s.backups.List()
+	c.JSON(http.StatusOK, r)
+	return
+}
+
+// startRestore restore slices of namespace from backups to a point in time, request is in json body
+func (s *Server) startRestore(c *gin.Context) {
+	var req service.RestoreRequest
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	if err := c.BindJSON(&req); err != nil {
+		proxy.ControllerLogger.Warnf("startRestore failed, err: %v", err)
+		c.JSON(http.StatusBadRequest, h)
+		return
+	}
+	if req.Cluster == "" {
+		req.Cluster = s.cfg.DefaultCluster
+	}
+	if err := s.restores.Start(&req); err != nil {
+		proxy.ControllerLogger.Warnf("startRestore failed, err: %v", err)
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+func (s *Server) cancelRestore(c *gin.Context) {
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	name := strings.TrimSpace(c.Param("name"))
+	cluster := c.DefaultQuery("cluster", s.cfg.DefaultCluster)
+	if err := s.restores.Cancel(cluster, name); err != nil {
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+// ListRestoresResp list restore tasks response
+type ListRestoresResp struct {
+	RetHeader *RetHeader              `json:"ret_header"`
+	Data      []service.RestoreStatus `json:"data"`
+}
+
+func (s *Server) listRestores(c *gin.Context) {
+	r := &ListRestoresResp{RetHeader: &RetHeader{RetCode: 0, RetMessage: "SUCC"}}
+	r.Data = s.restores.List()
+	c.JSON(http.StatusOK, r)
+	return
+}
+
+// exportStream write rows of a logical table as csv in response body, request is in json body
+func (s *Server) exportStream(c *gin.Context) {
+	var req service.ExportRequest
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	if err := c.BindJSON(&req); err != nil {
+		proxy.ControllerLogger.Warnf("exportStream failed, err: %v", err)
+		c.JSON(http.StatusBadRequest, h)
+		return
+	}
+	if req.Cluster == "" {
+		req.Cluster = s.cfg.DefaultCluster
+	}
+	e, err := service.NewExport(s.cfg, &req)
+	if err != nil {
+		proxy.ControllerLogger.Warnf("exportStream failed, err: %v", err)
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	// header has been sent, the connection is closed without trailing rows if export fails
+	if _, err := e.WriteMerged(c.Request.Context(), c.Writer); err != nil {
+		c.Abort()
+		if hj, ok := c.Writer.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+			}
+		}
+	}
+	return
+}
+
+// ExportFilesResp export files response
+type ExportFilesResp struct {
+	RetHeader *RetHeader            `json:"ret_header"`
+	Data      *service.ExportResult `json:"data"`
+}
+
+// exportFiles write rows of each sub table of a logical table to a csv file in export_dir of gaea cc, request is in json body
+func (s *Server) exportFiles(c *gin.Context) {
+	var req service.ExportRequest
+	r := &ExportFilesResp{RetHeader: &RetHeader{RetCode: -1, RetMessage: ""}}
+	if err := c.BindJSON(&req); err != nil {
+		proxy.ControllerLogger.Warnf("exportFiles failed, err: %v", err)
+		c.JSON(http.StatusBadRequest, r)
+		return
+	}
+	if req.Cluster == "" {
+		req.Cluster = s.cfg.DefaultCluster
+	}
+	e, err := service.NewExport(s.cfg, &req)
+	if err == nil {
+		r.Data, err = e.WriteFiles(c.Request.Context())
+	}
+	if err != nil {
+		proxy.ControllerLogger.Warnf("exportFiles failed, err: %v", err)
+		r.RetHeader.RetMessage = err.Error()
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	r.RetHeader.RetCode = 0
+	r.RetHeader.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, r)
+	return
+}
+
+// startConsistencyCheck compare rows of a logical table between layouts or replicas, request is in json body
+func (s *Server) startConsistencyCheck(c *gin.Context) {
+	var req service.ConsistencyCheckRequest
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	if err := c.BindJSON(&req); err != nil {
+		proxy.ControllerLogger.Warnf("startConsistencyCheck failed, err: %v", err)
+		c.JSON(http.StatusBadRequest, h)
+		return
+	}
+	if req.Cluster == "" {
+		req.Cluster = s.cfg.DefaultCluster
+	}
+	if err := s.checks.Start(&req); err != nil {
+		proxy.ControllerLogger.Warnf("startConsistencyCheck failed, err: %v", err)
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+func (s *Server) cancelConsistencyCheck(c *gin.Context) {
+	h := &RetHeader{RetCode: -1, RetMessage: ""}
+	name := strings.TrimSpace(c.Param("name"))
+	cluster := c.DefaultQuery("cluster", s.cfg.DefaultCluster)
+	db := c.Query("db")
+	table := c.Query("table")
+	if err := s.checks.Cancel(cluster, name, db, table); err != nil {
+		h.RetMessage = err.Error()
+		c.JSON(http.StatusOK, h)
+		return
+	}
+	h.RetCode = 0
+	h.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, h)
+	return
+}
+
+// ListConsistencyChecksResp list consistency checks response
+type ListConsistencyChecksResp struct {
+	RetHeader *RetHeader                       `json:"ret_header"`
+	Data      []service.ConsistencyCheckStatus `json:"data"`
+}
+
+func (s *Server) listConsistencyChecks(c *gin.Context) {
+	r := &ListConsistencyChecksResp{RetHeader: &RetHeader{RetCode: 0, RetMessage: "SUCC"}}
+	r.Data = s.checks.List()
+	c.JSON(http.StatusOK, r)
+	return
+}
+
 type sqlFingerprintResp struct {
 	RetHeader *RetHeader        `json:"ret_header"`
 	ErrSQLs   map[string]string `json:"err_sqls"`
@@ -262,10 +699,46 @@ func (s *Server) proxyConfigFingerprint(c *gin.Context) {
 	return
 }
 
+// ClusterHealthResp cluster health response
+type ClusterHealthResp struct {
+	RetHeader *RetHeader                   `json:"ret_header"`
+	Data      *service.ClusterHealthReport `json:"data"`
+}
+
+func (s *Server) clusterHealth(c *gin.Context) {
+	r := &ClusterHealthResp{RetHeader: &RetHeader{RetCode: -1, RetMessage: ""}}
+	cluster := c.DefaultQuery("cluster", s.cfg.DefaultCluster)
+	maxLag, err := strconv.ParseInt(c.DefaultQuery("max_replication_lag", "10"), 10, 64)
+	if err != nil || maxLag < 0 {
+		r.RetHeader.RetMessage = "invalid max_replication_lag"
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	r.Data, err = service.ClusterHealth(s.cfg, cluster, maxLag)
+	if err != nil {
+		r.RetHeader.RetMessage = err.Error()
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	r.RetHeader.RetCode = 0
+	r.RetHeader.RetMessage = "SUCC"
+	c.JSON(http.StatusOK, r)
+	return
+}
+
+// loadNamespace load decrypted namespace for change stream, nil if not found
+func (s *Server) loadNamespace(cluster, name string) (*models.Namespace, error) {
+	namespaces, err := service.QueryNamespace([]string{name}, s.cfg, cluster)
+	if err != nil || len(namespaces) == 0 {
+		return nil, err
+	}
+	return namespaces[0], nil
+}
+
 func (s *Server) Run() {
 	defer s.listener.Close()
 
-	errC := make(chan error)
+	errC := make(chan error, 2)
 
 	go func(l net.Listener) {
 		h := http.NewServeMux()
@@ -274,6 +747,15 @@ func (s *Server) Run() {
 		errC <- hs.Serve(l)
 	}(s.listener)
 
+	if s.cdc != nil {
+		go func() {
+			// nil is returned after server is closed
+			if err := s.cdc.Run(); err != nil {
+				errC <- err
+			}
+		}()
+	}
+
 	select {
 	case <-s.exitC:
 		proxy.ControllerLogger.Infof("server exit.")
@@ -286,6 +768,14 @@ func (s *Server) Run() {
 }
 
 func (s *Server) Close() {
+	s.mirrors.Close()
+	s.reshards.Close()
+	s.checks.Close()
+	s.backups.Close()
+	s.restores.Close()
+	if s.cdc != nil {
+		s.cdc.Close()
+	}
 	s.exitC <- struct{}{}
 	return
 }
@@ -0,0 +1,676 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/cc/proxy"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/proxy/router"
+)
+
+// types of backup
+const (
+	// BackupFull dump every slice, then copy binlog from the dump to the consistent point
+	BackupFull = "full"
+	// BackupIncremental copy binlog of every slice from the consistent point of base backup to a new one
+	BackupIncremental = "incremental"
+)
+
+// states of backup task
+const (
+	BackupDumping      = "dumping"
+	BackupRecordingCut = "recording_cut"
+	BackupCopyingLogs  = "copying_binlog"
+	BackupDone         = "done"
+	BackupFailed       = "failed"
+	BackupCanceled     = "canceled"
+)
+
+// hook types passed to backup command in GAEA_BACKUP_TYPE
+const (
+	backupHookDump   = "dump"
+	backupHookBinlog = "binlog"
+)
+
+const (
+	backupManifestFile = "manifest.json"
+	// output of backup command kept in error message
+	maxBackupHookOutput = 512
+)
+
+var errBackupCanceled = errors.New("backup is canceled")
+
+// BackupRequest request of backup of namespace. Files are written by backup_command of gaea cc
+// to directory <backup_dir>/<cluster>/<namespace>/<id>.
+type BackupRequest struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Type      string `json:"type"`   // full or incremental, default full
+	Base      string `json:"base"`   // id of the backup which incremental backup starts from
+	Freeze    bool   `json:"freeze"` // reject writes for a while when recording the consistent point, so that no cross slice transaction is half applied
+}
+
+// BackupShard files and gtid positions of a slice
+type BackupShard struct {
+	Slice      string   `json:"slice"`
+	Master     string   `json:"master"`
+	Databases  []string `json:"databases"`             // physical databases dumped
+	DumpGTID   string   `json:"dump_gtid,omitempty"`   // gtid_executed of master before dump starts
+	DumpFile   string   `json:"dump_file,omitempty"`   // relative to directory of backup
+	BinlogFrom string   `json:"binlog_from"`           // binlog copied excludes transactions in the gtid set
+	BinlogTo   string   `json:"binlog_to"`             // binlog copied includes transactions in the gtid set, it is the consistent point of the slice
+	BinlogFile string   `json:"binlog_file,omitempty"` // relative to directory of backup
+	Done       bool     `json:"done"`
+}
+
+// BackupTablePart physical table of a logical table in a slice
+type BackupTablePart struct {
+	Slice string `json:"slice"`
+	DB    string `json:"db"`
+	Table string `json:"table"`
+	File  string `json:"file"` // dump file of the slice, empty for incremental backup
+}
+
+// BackupTable physical tables of logical table, table * means unsharded tables of the db in default slice
+type BackupTable struct {
+	DB    string             `json:"db"`
+	Table string             `json:"table"`
+	Type  string             `json:"type"`
+	Parts []*BackupTablePart `json:"parts"`
+}
+
+// BackupManifest written to manifest.json in directory of backup when backup is done.
+// Restore every slice to cut by loading dump file and applying binlog file, incremental backups
+// are applied in order after their base.
+type BackupManifest struct {
+	ID         string            `json:"id"`
+	Cluster    string            `json:"cluster"`
+	Namespace  string            `json:"namespace"`
+	Type       string            `json:"type"`
+	Base       string            `json:"base,omitempty"`
+	Freeze     bool              `json:"freeze"`
+	Cut        map[string]string `json:"cut"`      // key: slice, value: gtid_executed at the consistent point
+	CutTime    int64             `json:"cut_time"` // transactions in cut are committed before the time
+	Shards     []*BackupShard    `json:"shards"`
+	Tables     []*BackupTable    `json:"tables"`
+	StartTime  int64             `json:"start_time"`
+	FinishTime int64             `json:"finish_time"`
+}
+
+// BackupStatus progress of backup task
+type BackupStatus struct {
+	ID         string         `json:"id"`
+	Cluster    string         `json:"cluster"`
+	Namespace  string         `json:"namespace"`
+	Type       string         `json:"type"`
+	Base       string         `json:"base"`
+	Freeze     bool           `json:"freeze"`
+	Dir        string         `json:"dir"`
+	State      string         `json:"state"`
+	Shards     []*BackupShard `json:"shards"`
+	Error      string         `json:"error"`
+	StartTime  int64          `json:"start_time"`
+	FinishTime int64          `json:"finish_time"`
+}
+
+func (s *BackupStatus) clone() BackupStatus {
+	ret := *s
+	ret.Shards = make([]*BackupShard, 0, len(s.Shards))
+	for _, shard := range s.Shards {
+		c := *shard
+		ret.Shards = append(ret.Shards, &c)
+	}
+	return ret
+}
+
+func backupDir(cfg *models.CCConfig, cluster, namespace, id string) string {
+	return filepath.Join(cfg.BackupDir, cluster, namespace, id)
+}
+
+// backupTables map logical tables to physical tables in slices, and return physical databases of each slice
+func backupTables(namespace *models.Namespace) ([]*BackupTable, map[string][]string, error) {
+	rt, err := router.NewRouter(namespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("build router of namespace %s error: %v", namespace.Name, err)
+	}
+	dbs := make(map[string]map[string]bool)
+	addDB := func(slice, db string) {
+		if dbs[slice] == nil {
+			dbs[slice] = make(map[string]bool)
+		}
+		dbs[slice][db] = true
+	}
+
+	var tables []*BackupTable
+	for db := range namespace.AllowedDBS {
+		phyDB := db
+		if v, ok := namespace.DefaultPhyDBS[db]; ok && v != "" {
+			phyDB = v
+		}
+		addDB(namespace.DefaultSlice, phyDB)
+		tables = append(tables, &BackupTable{
+			DB:    db,
+			Table: "*",
+			Type:  models.ShardDefault,
+			Parts: []*BackupTablePart{{Slice: namespace.DefaultSlice, DB: phyDB, Table: "*"}},
+		})
+	}
+	for _, shard := range namespace.ShardRules {
+		rule, ok := rt.GetShardRule(shard.DB, shard.Table)
+		if !ok {
+			continue
+		}
+		t := &BackupTable{DB: rule.GetDB(), Table: rule.GetTable(), Type: shard.Type}
+		for _, index := range rule.GetSubTableIndexes() {
+			phyDB, err := rule.GetDatabaseNameByTableIndex(index)
+			if err != nil {
+				return nil, nil, fmt.Errorf("get database of %s.%s sub table %d error: %v", rule.GetDB(), rule.GetTable(), index, err)
+			}
+			slice := rule.GetSlice(rule.GetSliceIndexFromTableIndex(index))
+			phyTable := fmt.Sprintf("%s_%04d", rule.GetTable(), index)
+			if rule.GetType() == router.GlobalTableRuleType || router.IsMycatShardingRule(rule.GetType()) {
+				phyTable = rule.GetTable()
+			}
+			addDB(slice, phyDB)
+			t.Parts = append(t.Parts, &BackupTablePart{Slice: slice, DB: phyDB, Table: phyTable})
+		}
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].DB != tables[j].DB {
+			return tables[i].DB < tables[j].DB
+		}
+		return tables[i].Table < tables[j].Table
+	})
+
+	ret := make(map[string][]string, len(dbs))
+	for slice, m := range dbs {
+		for db := range m {
+			ret[slice] = append(ret[slice], db)
+		}
+		sort.Strings(ret[slice])
+	}
+	return tables, ret, nil
+}
+
+// backupHookEnv return environment variables of backup command
+func backupHookEnv(status *BackupStatus, slice *models.Slice, shard *BackupShard, hookType, file string) ([]string, error) {
+	host, port, err := net.SplitHostPort(slice.Master)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master %s of slice %s: %v", slice.Master, slice.Name, err)
+	}
+	env := map[string]string{
+		"GAEA_BACKUP_TYPE":      hookType,
+		"GAEA_BACKUP_ID":        status.ID,
+		"GAEA_BACKUP_NAMESPACE": status.Namespace,
+		"GAEA_BACKUP_SLICE":     slice.Name,
+		"GAEA_BACKUP_HOST":      host,
+		"GAEA_BACKUP_PORT":      port,
+		"GAEA_BACKUP_USER":      slice.UserName,
+		"GAEA_BACKUP_PASSWORD":  slice.Password,
+		"GAEA_BACKUP_DATABASES": strings.Join(shard.Databases, " "),
+		"GAEA_BACKUP_FILE":      filepath.Join(status.Dir, file),
+		"GAEA_BACKUP_FROM_GTID": shard.BinlogFrom,
+		"GAEA_BACKUP_TO_GTID":   shard.BinlogTo,
+	}
+	ret := os.Environ()
+	for k, v := range env {
+		ret = append(ret, k+"="+v)
+	}
+	return ret, nil
+}
+
+// runBackupHook run backup command by shell, the command must write the dump or binlog to GAEA_BACKUP_FILE
+func runBackupHook(ctx context.Context, command string, env []string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return errBackupCanceled
+	}
+	if err != nil {
+		if len(out) > maxBackupHookOutput {
+			out = out[len(out)-maxBackupHookOutput:]
+		}
+		return fmt.Errorf("%v, output: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// gtidExecuted return gtid_executed of master of slice, gtid must be enabled
+func (c *reshardConns) gtidExecuted(slice string) (string, error) {
+	r, err := c.execute(slice, "SELECT @@GLOBAL.gtid_mode, @@GLOBAL.gtid_executed")
+	if err != nil {
+		return "", err
+	}
+	if r.Resultset == nil || r.RowNumber() == 0 {
+		return "", fmt.Errorf("query gtid_executed of slice %s returns no rows", slice)
+	}
+	mode, err := r.GetString(0, 0)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(mode, "ON") {
+		return "", fmt.Errorf("gtid_mode of master of slice %s is %s, must be ON", slice, mode)
+	}
+	gtids, err := r.GetString(0, 1)
+	if err != nil {
+		return "", err
+	}
+	// gtid set of many server uuids is separated by newline
+	return strings.Replace(gtids, "\n", "", -1), nil
+}
+
+type backupTask struct {
+	req    *BackupRequest
+	status BackupStatus // protected by lock of BackupTasks
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// BackupTasks backup tasks of namespaces, a namespace has at most one running task.
+// Tasks are kept in memory and canceled when gaea cc exits, manifests of finished backups are kept in files.
+type BackupTasks struct {
+	sync.Mutex
+	cfg   *models.CCConfig
+	tasks map[string]*backupTask // key: cluster/namespace
+	wg    sync.WaitGroup
+}
+
+// NewBackupTasks constructor of BackupTasks
+func NewBackupTasks(cfg *models.CCConfig) *BackupTasks {
+	return &BackupTasks{cfg: cfg, tasks: make(map[string]*backupTask)}
+}
+
+func isBackupFinished(state string) bool {
+	return state == BackupDone || state == BackupFailed || state == BackupCanceled
+}
+
+// loadBackupManifest load manifest of finished backup
+func loadBackupManifest(cfg *models.CCConfig, cluster, namespace, id string) (*BackupManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(backupDir(cfg, cluster, namespace, id), backupManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("load manifest of backup %s error: %v", id, err)
+	}
+	m := &BackupManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse manifest of backup %s error: %v", id, err)
+	}
+	return m, nil
+}
+
+// Start check the request and start backup in background, progress is reported by List
+func (m *BackupTasks) Start(req *BackupRequest) error {
+	if m.cfg.BackupCommand == "" || m.cfg.BackupDir == "" {
+		return errors.New("backup_command and backup_dir of gaea cc are not set")
+	}
+	if req.Type == "" {
+		req.Type = BackupFull
+	}
+	var base *BackupManifest
+	switch req.Type {
+	case BackupFull:
+		if req.Base != "" {
+			return errors.New("base is only used by incremental backup")
+		}
+	case BackupIncremental:
+		if req.Base == "" {
+			return errors.New("base of incremental backup is not set")
+		}
+		var err error
+		if base, err = loadBackupManifest(m.cfg, req.Cluster, req.Namespace, req.Base); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid backup type %s", req.Type)
+	}
+
+	store := newStore(m.cfg, req.Cluster)
+	namespace, err := store.LoadNamespace(m.cfg.EncryptKey, req.Namespace)
+	store.Close()
+	if err != nil {
+		return fmt.Errorf("load namespace %s error: %v", req.Namespace, err)
+	}
+	tables, dbs, err := backupTables(namespace)
+	if err != nil {
+		return err
+	}
+	var shards []*BackupShard
+	for _, slice := range namespace.Slices {
+		if len(dbs[slice.Name]) == 0 {
+			continue
+		}
+		shard := &BackupShard{Slice: slice.Name, Master: slice.Master, Databases: dbs[slice.Name]}
+		if base != nil {
+			from, ok := base.Cut[slice.Name]
+			if !ok {
+				return fmt.Errorf("slice %s is not in base backup %s, take a full backup", slice.Name, req.Base)
+			}
+			shard.BinlogFrom = from
+		}
+		shards = append(shards, shard)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	key := reshardKey(req.Cluster, req.Namespace)
+	if t, ok := m.tasks[key]; ok && !isBackupFinished(t.status.State) {
+		return fmt.Errorf("backup of namespace %s is running", req.Namespace)
+	}
+	now := time.Now()
+	id := now.Format("20060102150405")
+	if t, ok := m.tasks[key]; ok && t.status.ID == id {
+		return fmt.Errorf("backup %s of namespace %s exists, retry later", id, req.Namespace)
+	}
+	dir := backupDir(m.cfg, req.Cluster, req.Namespace, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create backup directory error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &backupTask{
+		req: req,
+		status: BackupStatus{
+			ID:        id,
+			Cluster:   req.Cluster,
+			Namespace: req.Namespace,
+			Type:      req.Type,
+			Base:      req.Base,
+			Freeze:    req.Freeze,
+			Dir:       dir,
+			State:     BackupDumping,
+			Shards:    shards,
+			StartTime: now.Unix(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if req.Type == BackupIncremental {
+		t.status.State = BackupRecordingCut
+	}
+	m.tasks[key] = t
+	m.wg.Add(1)
+	go m.run(t, namespace, tables)
+	proxy.ControllerLogger.Infof("start %s backup %s of namespace %s, base: %s", req.Type, id, req.Namespace, req.Base)
+	return nil
+}
+
+func (m *BackupTasks) update(t *backupTask, f func(s *BackupStatus)) {
+	m.Lock()
+	defer m.Unlock()
+	f(&t.status)
+}
+
+func (m *BackupTasks) setState(t *backupTask, state string) {
+	m.update(t, func(s *BackupStatus) { s.State = state })
+	proxy.ControllerLogger.Infof("backup of namespace %s: %s", t.req.Namespace, state)
+}
+
+func (m *BackupTasks) run(t *backupTask, namespace *models.Namespace, tables []*BackupTable) {
+	defer m.wg.Done()
+	defer t.cancel()
+	err := m.backup(t, namespace, tables)
+	m.update(t, func(s *BackupStatus) {
+		s.FinishTime = time.Now().Unix()
+		switch {
+		case err == nil:
+			s.State = BackupDone
+		case err == errBackupCanceled:
+			s.State = BackupCanceled
+		default:
+			s.State = BackupFailed
+			s.Error = err.Error()
+		}
+	})
+	if err != nil {
+		proxy.ControllerLogger.Warnf("backup of namespace %s stopped, %v", t.req.Namespace, err)
+		return
+	}
+	proxy.ControllerLogger.Infof("backup of namespace %s finished", t.req.Namespace)
+}
+
+// backup dump slices (full backup only), record the consistent point of all slices, copy binlog of every
+// slice up to the point, then write the manifest. Binlog of full backup starts from gtid_executed before dump,
+// transactions already in dump are skipped by gtid when binlog is applied.
+func (m *BackupTasks) backup(t *backupTask, namespace *models.Namespace, tables []*BackupTable) error {
+	c := newReshardConns(namespace, "")
+	defer c.close()
+
+	m.Lock()
+	status := t.status.clone()
+	m.Unlock()
+
+	if t.req.Type == BackupFull {
+		for i, shard := range status.Shards {
+			gtids, err := c.gtidExecuted(shard.Slice)
+			if err != nil {
+				return err
+			}
+			file := shard.Slice + ".dump"
+			m.update(t, func(s *BackupStatus) {
+				s.Shards[i].DumpGTID, s.Shards[i].BinlogFrom, s.Shards[i].DumpFile = gtids, gtids, file
+			})
+		}
+		if err := m.runHooks(t, namespace, backupHookDump); err != nil {
+			return err
+		}
+		m.setState(t, BackupRecordingCut)
+	}
+
+	cut, err := m.recordCut(t, c, status.Shards)
+	if err != nil {
+		return err
+	}
+	cutTime := time.Now().Unix()
+	m.update(t, func(s *BackupStatus) {
+		for _, shard := range s.Shards {
+			shard.BinlogTo = cut[shard.Slice]
+			shard.BinlogFile = shard.Slice + ".binlog"
+			shard.Done = false
+		}
+	})
+
+	m.setState(t, BackupCopyingLogs)
+	if err := m.runHooks(t, namespace, backupHookBinlog); err != nil {
+		return err
+	}
+
+	m.Lock()
+	status = t.status.clone()
+	m.Unlock()
+	manifest := &BackupManifest{
+		ID:         status.ID,
+		Cluster:    status.Cluster,
+		Namespace:  status.Namespace,
+		Type:       status.Type,
+		Base:       status.Base,
+		Freeze:     status.Freeze,
+		Cut:        cut,
+		CutTime:    cutTime,
+		Shards:     status.Shards,
+		Tables:     tables,
+		StartTime:  status.StartTime,
+		FinishTime: time.Now().Unix(),
+	}
+	files := make(map[string]string, len(status.Shards))
+	for _, shard := range status.Shards {
+		files[shard.Slice] = shard.DumpFile
+	}
+	for _, table := range tables {
+		for _, part := range table.Parts {
+			part.File = files[part.Slice]
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(status.Dir, backupManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("write manifest error: %v", err)
+	}
+	return nil
+}
+
+// runHooks run backup command of all slices concurrently, return the first error
+func (m *BackupTasks) runHooks(t *backupTask, namespace *models.Namespace, hookType string) error {
+	m.Lock()
+	status := t.status.clone()
+	m.Unlock()
+
+	var wg sync.WaitGroup
+	errC := make(chan error, len(status.Shards))
+	slices := make(map[string]*models.Slice, len(namespace.Slices))
+	for _, slice := range namespace.Slices {
+		slices[slice.Name] = slice
+	}
+	for i, shard := range status.Shards {
+		file := shard.BinlogFile
+		if hookType == backupHookDump {
+			file = shard.DumpFile
+		}
+		slice, ok := slices[shard.Slice]
+		if !ok {
+			return fmt.Errorf("slice %s not found", shard.Slice)
+		}
+		env, err := backupHookEnv(&status, slice, shard, hookType, file)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func(i int, slice string) {
+			defer wg.Done()
+			if err := runBackupHook(t.ctx, m.cfg.BackupCommand, env); err != nil {
+				if err != errBackupCanceled {
+					err = fmt.Errorf("%s of slice %s error: %v", hookType, slice, err)
+				}
+				errC <- err
+				// stop hooks of other slices
+				t.cancel()
+				return
+			}
+			m.update(t, func(s *BackupStatus) { s.Shards[i].Done = true })
+		}(i, shard.Slice)
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != errBackupCanceled {
+			return err
+		}
+	}
+	if t.ctx.Err() != nil {
+		return errBackupCanceled
+	}
+	return nil
+}
+
+// recordCut record gtid_executed of all slices as the consistent point. If freeze is set, writes of the
+// namespace are rejected by proxies and transactions in flight are waited before recording.
+func (m *BackupTasks) recordCut(t *backupTask, c *reshardConns, shards []*BackupShard) (map[string]string, error) {
+	req := t.req
+	if req.Freeze {
+		namespace, err := m.loadNamespace(req)
+		if err != nil {
+			return nil, err
+		}
+		readOnly := namespace.ReadOnly
+		namespace.ReadOnly = true
+		if err := ModifyNamespace(namespace, m.cfg, req.Cluster); err != nil {
+			return nil, fmt.Errorf("reject writes of namespace error: %v", err)
+		}
+		defer func() {
+			namespace, err := m.loadNamespace(req)
+			if err == nil {
+				namespace.ReadOnly = readOnly
+				err = ModifyNamespace(namespace, m.cfg, req.Cluster)
+			}
+			if err != nil {
+				proxy.ControllerLogger.Warnf("restore writes of namespace %s failed, %v", req.Namespace, err)
+			}
+		}()
+		time.Sleep(reshardFreezeWait)
+	}
+
+	cut := make(map[string]string, len(shards))
+	for _, shard := range shards {
+		gtids, err := c.gtidExecuted(shard.Slice)
+		if err != nil {
+			return nil, err
+		}
+		cut[shard.Slice] = gtids
+	}
+	return cut, nil
+}
+
+func (m *BackupTasks) loadNamespace(req *BackupRequest) (*models.Namespace, error) {
+	store := newStore(m.cfg, req.Cluster)
+	defer store.Close()
+	namespace, err := store.LoadNamespace(m.cfg.EncryptKey, req.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("load namespace %s error: %v", req.Namespace, err)
+	}
+	return namespace, nil
+}
+
+// Cancel cancel backup of namespace, running backup commands are killed and files written are kept
+func (m *BackupTasks) Cancel(cluster, name string) error {
+	m.Lock()
+	defer m.Unlock()
+	t, ok := m.tasks[reshardKey(cluster, name)]
+	if !ok || isBackupFinished(t.status.State) {
+		return fmt.Errorf("backup of namespace %s is not running", name)
+	}
+	t.cancel()
+	return nil
+}
+
+// List return status of all backup tasks, including finished ones
+func (m *BackupTasks) List() []BackupStatus {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]BackupStatus, 0, len(m.tasks))
+	for _, t := range m.tasks {
+		ret = append(ret, t.status.clone())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return reshardKey(ret[i].Cluster, ret[i].Namespace) < reshardKey(ret[j].Cluster, ret[j].Namespace)
+	})
+	return ret
+}
+
+// Close cancel all running tasks
+func (m *BackupTasks) Close() {
+	m.Lock()
+	for _, t := range m.tasks {
+		if !isBackupFinished(t.status.State) {
+			t.cancel()
+		}
+	}
+	m.Unlock()
+	m.wg.Wait()
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func TestBackupTables(t *testing.T) {
+	ns := &models.Namespace{
+		Name:          "ns",
+		AllowedDBS:    map[string]bool{"db": true},
+		DefaultPhyDBS: map[string]string{"db": "db_phy"},
+		DefaultSlice:  "slice-0",
+		Slices:        []*models.Slice{{Name: "slice-0"}, {Name: "slice-1"}},
+		ShardRules: []*models.Shard{
+			{DB: "db", Table: "tbl", Type: models.ShardHash, Key: "id", Slices: []string{"slice-0", "slice-1"}, Locations: []int{1, 1}},
+			{DB: "db", Table: "tbl_global", Type: models.ShardGlobal, Slices: []string{"slice-0", "slice-1"}, Locations: []int{1, 1}},
+		},
+	}
+	tables, dbs, err := backupTables(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []*BackupTable{
+		{DB: "db", Table: "*", Type: models.ShardDefault, Parts: []*BackupTablePart{{Slice: "slice-0", DB: "db_phy", Table: "*"}}},
+		{DB: "db", Table: "tbl", Type: models.ShardHash, Parts: []*BackupTablePart{{Slice: "slice-0", DB: "db", Table: "tbl_0000"}, {Slice: "slice-1", DB: "db", Table: "tbl_0001"}}},
+		{DB: "db", Table: "tbl_global", Type: models.ShardGlobal, Parts: []*BackupTablePart{{Slice: "slice-0", DB: "db", Table: "tbl_global"}, {Slice: "slice-1", DB: "db", Table: "tbl_global"}}},
+	}
+	if len(tables) != len(expect) {
+		t.Fatalf("tables: %d, expect: %d", len(tables), len(expect))
+	}
+	for i := range expect {
+		if !reflect.DeepEqual(tables[i], expect[i]) {
+			t.Errorf("table %s.%s is not expected", tables[i].DB, tables[i].Table)
+		}
+	}
+	expectDBs := map[string][]string{"slice-0": {"db", "db_phy"}, "slice-1": {"db"}}
+	if !reflect.DeepEqual(dbs, expectDBs) {
+		t.Errorf("databases: %v, expect: %v", dbs, expectDBs)
+	}
+}
+
+func TestRunBackupHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaea_backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	status := &BackupStatus{ID: "20190101000000", Namespace: "ns", Dir: dir}
+	slice := &models.Slice{Name: "slice-0", Master: "127.0.0.1:3306", UserName: "root", Password: "pwd"}
+	shard := &BackupShard{Slice: "slice-0", Databases: []string{"db0", "db1"}, BinlogFrom: "uuid:1-5"}
+	env, err := backupHookEnv(status, slice, shard, backupHookDump, "slice-0.dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := `echo "$GAEA_BACKUP_TYPE $GAEA_BACKUP_HOST $GAEA_BACKUP_PORT $GAEA_BACKUP_DATABASES $GAEA_BACKUP_FROM_GTID" > "$GAEA_BACKUP_FILE"`
+	if err := runBackupHook(context.Background(), command, env); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "slice-0.dump"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.TrimSpace(string(data)); s != "dump 127.0.0.1 3306 db0 db1 uuid:1-5" {
+		t.Errorf("output of backup command: %s", s)
+	}
+
+	err = runBackupHook(context.Background(), "echo dump failed; exit 1", env)
+	if err == nil || !strings.Contains(err.Error(), "dump failed") {
+		t.Errorf("error of backup command: %v", err)
+	}
+
+	if _, err := backupHookEnv(status, &models.Slice{Name: "slice-1", Master: "127.0.0.1"}, shard, backupHookDump, ""); err == nil {
+		t.Errorf("invalid master is not checked")
+	}
+}
@@ -0,0 +1,618 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/cc/proxy"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// modes of consistency check
+const (
+	// CheckModeUnshard compare unsharded table in default slice with its sub tables, used by dual write migration
+	CheckModeUnshard = "unshard"
+	// CheckModeLayout compare sub tables of current layout with the same sub tables in another layout, used by resharding
+	CheckModeLayout = "layout"
+	// CheckModeReplica compare sub tables in master with the ones in slaves of each slice
+	CheckModeReplica = "replica"
+)
+
+// states of consistency check
+const (
+	CheckRunning  = "running"
+	CheckDone     = "done"
+	CheckFailed   = "failed"
+	CheckCanceled = "canceled"
+)
+
+const (
+	defaultCheckChunkSize = 1000
+	maxCheckChunkSize     = 100000
+	// mismatched chunks are checked again, the table may be written or replicated during checking
+	checkRetries       = 3
+	checkRetryInterval = time.Second
+	// mismatches reported in status, the others are only counted
+	maxReportedMismatches = 100
+)
+
+var errCheckCanceled = errors.New("consistency check canceled")
+
+// ConsistencyCheckRequest logical table to check, Slices and Locations are the other layout in layout mode
+type ConsistencyCheckRequest struct {
+	Cluster   string   `json:"cluster"`
+	Namespace string   `json:"namespace"`
+	DB        string   `json:"db"`
+	Table     string   `json:"table"`
+	Mode      string   `json:"mode"`
+	Slices    []string `json:"slices"`
+	Locations []int    `json:"locations"`
+	ChunkSize int      `json:"chunk_size"` // rows of source table checked in one chunk, default 1000
+}
+
+// ChunkMismatch chunk of primary key range whose row count or checksum differs
+type ChunkMismatch struct {
+	Table          string `json:"table"`  // physical table in source
+	Source         string `json:"source"` // e.g. slice-0
+	Target         string `json:"target"` // e.g. slice-0 slave 127.0.0.1:3307
+	Lower          string `json:"lower"`  // primary key of lower bound, exclusive, empty means the first row
+	Upper          string `json:"upper"`  // primary key of upper bound, inclusive, empty means the last row
+	SourceRows     int64  `json:"source_rows"`
+	TargetRows     int64  `json:"target_rows"`
+	SourceChecksum uint64 `json:"source_checksum"`
+	TargetChecksum uint64 `json:"target_checksum"`
+}
+
+// ConsistencyCheckStatus progress and result of consistency check
+type ConsistencyCheckStatus struct {
+	Cluster       string           `json:"cluster"`
+	Namespace     string           `json:"namespace"`
+	DB            string           `json:"db"`
+	Table         string           `json:"table"`
+	Mode          string           `json:"mode"`
+	State         string           `json:"state"`
+	Pairs         int              `json:"pairs"` // tables compared
+	CheckedPairs  int              `json:"checked_pairs"`
+	CheckedChunks int64            `json:"checked_chunks"`
+	CheckedRows   int64            `json:"checked_rows"` // rows of source tables
+	MismatchCount int              `json:"mismatch_count"`
+	Mismatches    []*ChunkMismatch `json:"mismatches"`
+	Error         string           `json:"error"`
+	StartTime     int64            `json:"start_time"`
+	FinishTime    int64            `json:"finish_time"`
+}
+
+func (s *ConsistencyCheckStatus) clone() ConsistencyCheckStatus {
+	ret := *s
+	ret.Mismatches = make([]*ChunkMismatch, 0, len(s.Mismatches))
+	for _, m := range s.Mismatches {
+		c := *m
+		ret.Mismatches = append(ret.Mismatches, &c)
+	}
+	return ret
+}
+
+// checkPart physical table in master or a slave of slice
+type checkPart struct {
+	slice string
+	addr  string // address of slave, empty means master
+	table string
+}
+
+func (p checkPart) String() string {
+	if p.addr != "" {
+		return fmt.Sprintf("%s slave %s", p.slice, p.addr)
+	}
+	return p.slice
+}
+
+// checkTarget rows of parts are compared with source together
+type checkTarget struct {
+	parts []checkPart
+}
+
+func (t *checkTarget) String() string {
+	names := make([]string, 0, len(t.parts))
+	for _, p := range t.parts {
+		if len(t.parts) > 1 {
+			names = append(names, p.String()+"."+p.table)
+		} else {
+			names = append(names, p.String())
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// checkPair source table and targets compared with it one by one
+type checkPair struct {
+	source  checkPart
+	targets []*checkTarget
+}
+
+func subTableName(table string, index int) string {
+	return fmt.Sprintf("%s_%04d", table, index)
+}
+
+// planConsistencyCheck return tables to compare in the mode
+func planConsistencyCheck(namespace *models.Namespace, req *ConsistencyCheckRequest) ([]*checkPair, error) {
+	shard := findShard(namespace, req.DB, req.Table)
+	if shard == nil {
+		if req.Mode != CheckModeReplica {
+			return nil, fmt.Errorf("shard rule of %s.%s not found", req.DB, req.Table)
+		}
+		// unsharded table is in default slice
+		return replicaPairs(namespace, []checkPart{{slice: namespace.DefaultSlice, table: req.Table}})
+	}
+	if !reshardableTypes[shard.Type] || len(shard.Databases) != 0 {
+		return nil, fmt.Errorf("consistency check of %s shard rule is not supported", shard.Type)
+	}
+
+	tables := reshardTables(namespace, shard)
+	layout := expandLocations(shard.Slices, shard.Locations)
+	var pairs []*checkPair
+	switch req.Mode {
+	case CheckModeUnshard:
+		for _, t := range tables {
+			target := &checkTarget{}
+			for i, slice := range layout {
+				target.parts = append(target.parts, checkPart{slice: slice, table: subTableName(t.Table, i)})
+			}
+			pairs = append(pairs, &checkPair{
+				source:  checkPart{slice: namespace.DefaultSlice, table: t.Table},
+				targets: []*checkTarget{target},
+			})
+		}
+	case CheckModeLayout:
+		if len(req.Slices) == 0 || len(req.Slices) != len(req.Locations) {
+			return nil, fmt.Errorf("slices and locations must have the same length")
+		}
+		exists := make(map[string]bool, len(namespace.Slices))
+		for _, s := range namespace.Slices {
+			exists[s.Name] = true
+		}
+		for _, s := range req.Slices {
+			if !exists[s] {
+				return nil, fmt.Errorf("slice %s not found in namespace", s)
+			}
+		}
+		other := expandLocations(req.Slices, req.Locations)
+		if len(other) != len(layout) {
+			return nil, fmt.Errorf("number of sub tables must be the same, current: %d, other: %d", len(layout), len(other))
+		}
+		for _, t := range tables {
+			for i := range layout {
+				if layout[i] == other[i] {
+					continue
+				}
+				table := subTableName(t.Table, i)
+				pairs = append(pairs, &checkPair{
+					source:  checkPart{slice: layout[i], table: table},
+					targets: []*checkTarget{{parts: []checkPart{{slice: other[i], table: table}}}},
+				})
+			}
+		}
+		if len(pairs) == 0 {
+			return nil, fmt.Errorf("the other layout is the same as current layout")
+		}
+	case CheckModeReplica:
+		var parts []checkPart
+		for _, t := range tables {
+			for i, slice := range layout {
+				parts = append(parts, checkPart{slice: slice, table: subTableName(t.Table, i)})
+			}
+		}
+		return replicaPairs(namespace, parts)
+	default:
+		return nil, fmt.Errorf("unknown mode %s", req.Mode)
+	}
+	return pairs, nil
+}
+
+func replicaPairs(namespace *models.Namespace, parts []checkPart) ([]*checkPair, error) {
+	slaves := make(map[string][]string)
+	for _, s := range namespace.Slices {
+		for _, addr := range s.Slaves {
+			// weight of slave is not part of the address
+			slaves[s.Name] = append(slaves[s.Name], strings.Split(addr, "@")[0])
+		}
+	}
+	var pairs []*checkPair
+	for _, p := range parts {
+		pair := &checkPair{source: p}
+		for _, addr := range slaves[p.slice] {
+			pair.targets = append(pair.targets, &checkTarget{parts: []checkPart{{slice: p.slice, addr: addr, table: p.table}}})
+		}
+		if len(pair.targets) != 0 {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no slave of slices to check")
+	}
+	return pairs, nil
+}
+
+// chunkChecksum row count and checksum of rows in a chunk, checksums of parts are merged by xor
+type chunkChecksum struct {
+	rows     int64
+	checksum uint64
+}
+
+// checksumSQL return sql computing row count and checksum of rows in the table, like pt-table-checksum,
+// null flags of columns are appended because CONCAT_WS skips null values
+func checksumSQL(t *reshardTable, table, where string) string {
+	columns := make([]string, 0, len(t.columns)+1)
+	nulls := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		columns = append(columns, quoteName(c.name))
+		nulls = append(nulls, "ISNULL("+quoteName(c.name)+")")
+	}
+	columns = append(columns, "CONCAT("+strings.Join(nulls, ",")+")")
+	sql := fmt.Sprintf("SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#',%s))),0) FROM %s.%s",
+		strings.Join(columns, ","), quoteName(t.db), quoteName(table))
+	if where != "" {
+		sql += " WHERE " + where
+	}
+	return sql
+}
+
+// chunkWhere return condition of rows in (lower, upper] of primary key, nil bound means no limit
+func chunkWhere(t *reshardTable, lower, upper []interface{}) string {
+	var conds []string
+	if lower != nil {
+		conds = append(conds, fmt.Sprintf("(%s) > %s", t.pkList(), pkTuple(t, lower)))
+	}
+	if upper != nil {
+		conds = append(conds, fmt.Sprintf("(%s) <= %s", t.pkList(), pkTuple(t, upper)))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// pkTuple format values of primary key columns as sql tuple
+func pkTuple(t *reshardTable, pk []interface{}) string {
+	vs := make([]string, 0, len(pk))
+	for i, v := range pk {
+		vs = append(vs, sqlValue(v, t.columns[t.pk[i]]))
+	}
+	return "(" + strings.Join(vs, ",") + ")"
+}
+
+// conn return connection to master or slave of the part
+func (c *reshardConns) partConn(p checkPart) (*backend.DirectConnection, error) {
+	if p.addr == "" {
+		return c.conn(p.slice)
+	}
+	key := p.slice + "@" + p.addr
+	if dc, ok := c.conns[key]; ok && !dc.IsClosed() {
+		return dc, nil
+	}
+	s, err := c.slice(p.slice)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := backend.NewDirectConnection(p.addr, s.UserName, s.Password, c.db, c.charset, c.collationID, false)
+	if err != nil {
+		return nil, fmt.Errorf("connect to slave %s of slice %s error: %v", p.addr, p.slice, err)
+	}
+	c.conns[key] = dc
+	return dc, nil
+}
+
+func (c *reshardConns) checksum(p checkPart, t *reshardTable, where string) (chunkChecksum, error) {
+	dc, err := c.partConn(p)
+	if err != nil {
+		return chunkChecksum{}, err
+	}
+	sql := checksumSQL(t, p.table, where)
+	r, err := dc.Execute(sql)
+	if err != nil {
+		return chunkChecksum{}, fmt.Errorf("execute %.128s in %s error: %v", sql, p, err)
+	}
+	rows, err := r.GetInt(0, 0)
+	if err != nil {
+		return chunkChecksum{}, err
+	}
+	sum, err := r.GetUint(0, 1)
+	if err != nil {
+		return chunkChecksum{}, err
+	}
+	return chunkChecksum{rows: rows, checksum: sum}, nil
+}
+
+func (c *reshardConns) targetChecksum(target *checkTarget, t *reshardTable, where string) (chunkChecksum, error) {
+	var ret chunkChecksum
+	for _, p := range target.parts {
+		s, err := c.checksum(p, t, where)
+		if err != nil {
+			return chunkChecksum{}, err
+		}
+		ret.rows += s.rows
+		ret.checksum ^= s.checksum
+	}
+	return ret, nil
+}
+
+// nextBoundary return primary key of the last row of next chunk after lower, nil if the rest rows are less than chunk size
+func (c *reshardConns) nextBoundary(p checkPart, t *reshardTable, lower []interface{}, chunkSize int) ([]interface{}, error) {
+	dc, err := c.partConn(p)
+	if err != nil {
+		return nil, err
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s.%s", t.pkList(), quoteName(t.db), quoteName(p.table))
+	if lower != nil {
+		sql += " WHERE " + chunkWhere(t, lower, nil)
+	}
+	sql += fmt.Sprintf(" ORDER BY %s LIMIT %d,1", t.pkList(), chunkSize-1)
+	var ret []interface{}
+	_, err = dc.ExecuteStream(sql, func([]*mysql.Field) error { return nil }, func(data mysql.RowData) error {
+		row, err := parseRawRow(data, len(t.pk))
+		ret = row
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read primary key of %s in %s error: %v", p.table, p, err)
+	}
+	return ret, nil
+}
+
+type checkTask struct {
+	req    *ConsistencyCheckRequest
+	pairs  []*checkPair
+	status ConsistencyCheckStatus // protected by lock of ConsistencyChecks
+	closeC chan struct{}
+}
+
+func (t *checkTask) canceled() bool {
+	select {
+	case <-t.closeC:
+		return true
+	default:
+		return false
+	}
+}
+
+// ConsistencyChecks consistency checks of logical tables, a table has at most one running check.
+// Checks are kept in memory and canceled when gaea cc exits.
+type ConsistencyChecks struct {
+	sync.Mutex
+	cfg    *models.CCConfig
+	checks map[string]*checkTask // key: cluster/namespace/db.table
+	wg     sync.WaitGroup
+}
+
+// NewConsistencyChecks constructor of ConsistencyChecks
+func NewConsistencyChecks(cfg *models.CCConfig) *ConsistencyChecks {
+	return &ConsistencyChecks{cfg: cfg, checks: make(map[string]*checkTask)}
+}
+
+func checkKey(cluster, namespace, db, table string) string {
+	return cluster + "/" + namespace + "/" + db + "." + table
+}
+
+// Start check the request and start consistency check in background, result is reported by List
+func (m *ConsistencyChecks) Start(req *ConsistencyCheckRequest) error {
+	if req.ChunkSize == 0 {
+		req.ChunkSize = defaultCheckChunkSize
+	}
+	if req.ChunkSize < 0 || req.ChunkSize > maxCheckChunkSize {
+		return fmt.Errorf("chunk_size must be in (0, %d]", maxCheckChunkSize)
+	}
+	store := newStore(m.cfg, req.Cluster)
+	namespace, err := store.LoadNamespace(m.cfg.EncryptKey, req.Namespace)
+	store.Close()
+	if err != nil {
+		return fmt.Errorf("load namespace %s error: %v", req.Namespace, err)
+	}
+	pairs, err := planConsistencyCheck(namespace, req)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	key := checkKey(req.Cluster, req.Namespace, req.DB, req.Table)
+	if t, ok := m.checks[key]; ok && t.status.State == CheckRunning {
+		return fmt.Errorf("consistency check of %s.%s is running", req.DB, req.Table)
+	}
+	t := &checkTask{
+		req:   req,
+		pairs: pairs,
+		status: ConsistencyCheckStatus{
+			Cluster:   req.Cluster,
+			Namespace: req.Namespace,
+			DB:        req.DB,
+			Table:     req.Table,
+			Mode:      req.Mode,
+			State:     CheckRunning,
+			Pairs:     len(pairs),
+			StartTime: time.Now().Unix(),
+		},
+		closeC: make(chan struct{}),
+	}
+	m.checks[key] = t
+	m.wg.Add(1)
+	go m.run(t, namespace)
+	proxy.ControllerLogger.Infof("start %s consistency check of %s.%s in namespace %s", req.Mode, req.DB, req.Table, req.Namespace)
+	return nil
+}
+
+func (m *ConsistencyChecks) update(t *checkTask, f func(s *ConsistencyCheckStatus)) {
+	m.Lock()
+	defer m.Unlock()
+	f(&t.status)
+}
+
+func (m *ConsistencyChecks) run(t *checkTask, namespace *models.Namespace) {
+	defer m.wg.Done()
+	c := newReshardConns(namespace, t.req.DB)
+	defer c.close()
+
+	var err error
+	for _, pair := range t.pairs {
+		if err = m.checkPair(t, c, pair); err != nil {
+			break
+		}
+		m.update(t, func(s *ConsistencyCheckStatus) { s.CheckedPairs++ })
+	}
+	var mismatches int
+	m.update(t, func(s *ConsistencyCheckStatus) {
+		s.FinishTime = time.Now().Unix()
+		mismatches = s.MismatchCount
+		switch {
+		case err == nil:
+			s.State = CheckDone
+		case err == errCheckCanceled:
+			s.State = CheckCanceled
+		default:
+			s.State = CheckFailed
+			s.Error = err.Error()
+		}
+	})
+	if err != nil {
+		proxy.ControllerLogger.Warnf("consistency check of %s.%s in namespace %s stopped, %v", t.req.DB, t.req.Table, t.req.Namespace, err)
+		return
+	}
+	proxy.ControllerLogger.Infof("consistency check of %s.%s in namespace %s finished, mismatched chunks: %d", t.req.DB, t.req.Table, t.req.Namespace, mismatches)
+}
+
+// checkPair compare chunks of source table with targets, chunks are split by primary key of source table,
+// the last chunk has no upper bound so that extra rows in targets are found
+func (m *ConsistencyChecks) checkPair(t *checkTask, c *reshardConns, pair *checkPair) error {
+	table, err := c.loadTable(pair.source.slice, pair.source.table)
+	if err != nil {
+		return err
+	}
+	var lower []interface{}
+	for {
+		if t.canceled() {
+			return errCheckCanceled
+		}
+		upper, err := c.nextBoundary(pair.source, table, lower, t.req.ChunkSize)
+		if err != nil {
+			return err
+		}
+		where := chunkWhere(table, lower, upper)
+		var rows int64
+		for _, target := range pair.targets {
+			source, mismatch, err := m.checkChunk(t, c, pair.source, target, table, where)
+			if err != nil {
+				return err
+			}
+			rows = source.rows
+			if mismatch != nil {
+				mismatch.Table, mismatch.Source, mismatch.Target = pair.source.table, pair.source.String(), target.String()
+				if lower != nil {
+					mismatch.Lower = pkTuple(table, lower)
+				}
+				if upper != nil {
+					mismatch.Upper = pkTuple(table, upper)
+				}
+				proxy.ControllerLogger.Warnf("consistency check of %s.%s in namespace %s, chunk mismatch: %+v", t.req.DB, t.req.Table, t.req.Namespace, *mismatch)
+				m.update(t, func(s *ConsistencyCheckStatus) {
+					s.MismatchCount++
+					if len(s.Mismatches) < maxReportedMismatches {
+						s.Mismatches = append(s.Mismatches, mismatch)
+					}
+				})
+			}
+		}
+		m.update(t, func(s *ConsistencyCheckStatus) {
+			s.CheckedChunks++
+			s.CheckedRows += rows
+		})
+		if upper == nil {
+			return nil
+		}
+		lower = upper
+	}
+}
+
+// checkChunk compare chunk of source with target, mismatched chunk is checked again after a while
+func (m *ConsistencyChecks) checkChunk(t *checkTask, c *reshardConns, source checkPart, target *checkTarget, table *reshardTable, where string) (chunkChecksum, *ChunkMismatch, error) {
+	for i := 0; ; i++ {
+		s, err := c.checksum(source, table, where)
+		if err != nil {
+			return s, nil, err
+		}
+		d, err := c.targetChecksum(target, table, where)
+		if err != nil {
+			return s, nil, err
+		}
+		if s == d {
+			return s, nil, nil
+		}
+		if i == checkRetries-1 {
+			return s, &ChunkMismatch{
+				SourceRows:     s.rows,
+				TargetRows:     d.rows,
+				SourceChecksum: s.checksum,
+				TargetChecksum: d.checksum,
+			}, nil
+		}
+		select {
+		case <-t.closeC:
+			return s, nil, errCheckCanceled
+		case <-time.After(checkRetryInterval):
+		}
+	}
+}
+
+// Cancel cancel running consistency check of the table
+func (m *ConsistencyChecks) Cancel(cluster, namespace, db, table string) error {
+	m.Lock()
+	defer m.Unlock()
+	t, ok := m.checks[checkKey(cluster, namespace, db, table)]
+	if !ok || t.status.State != CheckRunning {
+		return fmt.Errorf("consistency check of %s.%s is not running", db, table)
+	}
+	if !t.canceled() {
+		close(t.closeC)
+	}
+	return nil
+}
+
+// List return status of all consistency checks, including finished ones
+func (m *ConsistencyChecks) List() []ConsistencyCheckStatus {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]ConsistencyCheckStatus, 0, len(m.checks))
+	for _, t := range m.checks {
+		ret = append(ret, t.status.clone())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return checkKey(ret[i].Cluster, ret[i].Namespace, ret[i].DB, ret[i].Table) < checkKey(ret[j].Cluster, ret[j].Namespace, ret[j].DB, ret[j].Table)
+	})
+	return ret
+}
+
+// Close cancel all running checks
+func (m *ConsistencyChecks) Close() {
+	m.Lock()
+	for _, t := range m.checks {
+		if t.status.State == CheckRunning && !t.canceled() {
+			close(t.closeC)
+		}
+	}
+	m.Unlock()
+	m.wg.Wait()
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+)
+
+func TestPlanConsistencyCheck(t *testing.T) {
+	ns := reshardNamespace()
+	ns.DefaultSlice = "slice-0"
+	ns.Slices[1].Slaves = []string{"127.0.0.1:3307@2", "127.0.0.1:3308"}
+
+	pairs, err := planConsistencyCheck(ns, &ConsistencyCheckRequest{DB: "db", Table: "tbl", Mode: CheckModeUnshard})
+	if err != nil {
+		t.Fatalf("plan consistency check error: %v", err)
+	}
+	if len(pairs) != 2 || pairs[0].source.table != "tbl" || pairs[1].source.table != "tbl_ext" {
+		t.Fatalf("linked table should be checked together, got %v", pairs)
+	}
+	if s := pairs[0].targets[0].String(); s != "slice-0.tbl_0000,slice-0.tbl_0001,slice-1.tbl_0002,slice-1.tbl_0003" {
+		t.Errorf("unshard table should be compared with all sub tables, got %s", s)
+	}
+
+	// old: 0 0 1 1, new: 0 1 1 2
+	pairs, err = planConsistencyCheck(ns, &ConsistencyCheckRequest{DB: "db", Table: "tbl", Mode: CheckModeLayout,
+		Slices: []string{"slice-0", "slice-1", "slice-2"}, Locations: []int{1, 2, 1}})
+	if err != nil {
+		t.Fatalf("plan consistency check error: %v", err)
+	}
+	expect := []string{"tbl_0001 slice-0 slice-1", "tbl_0003 slice-1 slice-2", "tbl_ext_0001 slice-0 slice-1", "tbl_ext_0003 slice-1 slice-2"}
+	if len(pairs) != len(expect) {
+		t.Fatalf("expect %d pairs, got %d", len(expect), len(pairs))
+	}
+	for i, p := range pairs {
+		if s := p.source.table + " " + p.source.String() + " " + p.targets[0].String(); s != expect[i] {
+			t.Errorf("pair %d, expect %s, got %s", i, expect[i], s)
+		}
+	}
+
+	pairs, err = planConsistencyCheck(ns, &ConsistencyCheckRequest{DB: "db", Table: "other", Mode: CheckModeReplica})
+	if err != nil {
+		t.Fatalf("plan consistency check error: %v", err)
+	}
+	if len(pairs) != 2 || pairs[0].source.table != "other_0002" || len(pairs[0].targets) != 2 {
+		t.Fatalf("sub tables in slice with slaves should be checked, got %v", pairs)
+	}
+	if s := pairs[0].targets[0].String(); s != "slice-1 slave 127.0.0.1:3307" {
+		t.Errorf("weight of slave should be removed, got %s", s)
+	}
+
+	pairs, err = planConsistencyCheck(ns, &ConsistencyCheckRequest{DB: "db", Table: "unshard", Mode: CheckModeReplica})
+	if err == nil {
+		t.Errorf("default slice has no slave, got %v", pairs)
+	}
+}
+
+func TestPlanConsistencyCheckError(t *testing.T) {
+	ns := reshardNamespace()
+	tests := []*ConsistencyCheckRequest{
+		{DB: "db", Table: "unknown", Mode: CheckModeUnshard},
+		{DB: "db", Table: "tbl", Mode: "unknown"},
+		{DB: "db", Table: "tbl", Mode: CheckModeLayout, Slices: []string{"slice-0", "slice-3"}, Locations: []int{2, 2}},
+		{DB: "db", Table: "tbl", Mode: CheckModeLayout, Slices: []string{"slice-0", "slice-1"}, Locations: []int{2, 3}},
+		{DB: "db", Table: "tbl", Mode: CheckModeLayout, Slices: []string{"slice-0", "slice-1"}, Locations: []int{2, 2}},
+		{DB: "db", Table: "tbl", Mode: CheckModeReplica},
+	}
+	for _, req := range tests {
+		if _, err := planConsistencyCheck(ns, req); err == nil {
+			t.Errorf("plan consistency check of %+v should fail", *req)
+		}
+	}
+}
+
+func TestChecksumSQL(t *testing.T) {
+	table := &reshardTable{
+		db:      "db",
+		name:    "tbl_0000",
+		columns: []*reshardColumn{{name: "id"}, {name: "name"}},
+		pk:      []int{0},
+	}
+	where := chunkWhere(table, []interface{}{[]byte("10")}, []interface{}{[]byte("20")})
+	if expect := "(`id`) > ('10') AND (`id`) <= ('20')"; where != expect {
+		t.Errorf("expect %s, got %s", expect, where)
+	}
+	if where := chunkWhere(table, nil, nil); where != "" {
+		t.Errorf("chunk without bounds should have no condition, got %s", where)
+	}
+	sql := checksumSQL(table, "tbl_0001", where)
+	expect := "SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#',`id`,`name`,CONCAT(ISNULL(`id`),ISNULL(`name`))))),0) " +
+		"FROM `db`.`tbl_0001` WHERE (`id`) > ('10') AND (`id`) <= ('20')"
+	if sql != expect {
+		t.Errorf("expect %s, got %s", expect, sql)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/cc/proxy"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// formats of export
+const (
+	ExportCSV = "csv"
+)
+
+const (
+	defaultExportChunkSize = 1000
+	defaultExportParallel  = 4
+	maxExportParallel      = 64
+	// written for NULL in csv, the same as LOAD DATA
+	defaultExportNull = `\N`
+)
+
+// ExportRequest export rows of a logical table by scanning its sub tables in parallel
+type ExportRequest struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	DB        string `json:"db"`
+	Table     string `json:"table"`
+	Format    string `json:"format"`     // only csv is supported, default csv
+	ChunkSize int    `json:"chunk_size"` // rows read in one query, default 1000
+	Parallel  int    `json:"parallel"`   // sub tables scanned concurrently, default 4
+	Null      string `json:"null"`       // written for NULL, default \N
+}
+
+// ExportFile file of a sub table written by export
+type ExportFile struct {
+	Slice string `json:"slice"`
+	DB    string `json:"db"`
+	Table string `json:"table"`
+	File  string `json:"file"`
+	Rows  int64  `json:"rows"`
+}
+
+// ExportResult files written by export
+type ExportResult struct {
+	Dir   string        `json:"dir"`
+	Files []*ExportFile `json:"files"`
+	Rows  int64         `json:"rows"`
+}
+
+// Export scan of a logical table, rows of each sub table are read in order of primary key by keyset pagination.
+// Sub tables are not read in the same snapshot, rows written during export may be missed or exported.
+type Export struct {
+	cfg       *models.CCConfig
+	req       *ExportRequest
+	namespace *models.Namespace
+	parts     []*BackupTablePart
+}
+
+// exportParts return sub tables of logical table, global table is read from the first slice only
+func exportParts(namespace *models.Namespace, db, table string) ([]*BackupTablePart, error) {
+	if !namespace.AllowedDBS[db] {
+		return nil, fmt.Errorf("db %s is not allowed in namespace %s", db, namespace.Name)
+	}
+	tables, _, err := backupTables(namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tables {
+		if t.DB != db || !strings.EqualFold(t.Table, table) {
+			continue
+		}
+		if t.Type == models.ShardGlobal {
+			return t.Parts[:1], nil
+		}
+		return t.Parts, nil
+	}
+	// unsharded table in default slice
+	phyDB := db
+	if v, ok := namespace.DefaultPhyDBS[db]; ok && v != "" {
+		phyDB = v
+	}
+	return []*BackupTablePart{{Slice: namespace.DefaultSlice, DB: phyDB, Table: table}}, nil
+}
+
+// NewExport check request and find sub tables of logical table
+func NewExport(cfg *models.CCConfig, req *ExportRequest) (*Export, error) {
+	if req.Format == "" {
+		req.Format = ExportCSV
+	}
+	if req.Format != ExportCSV {
+		return nil, fmt.Errorf("unsupported export format %s", req.Format)
+	}
+	if req.ChunkSize == 0 {
+		req.ChunkSize = defaultExportChunkSize
+	}
+	if req.ChunkSize < 0 || req.ChunkSize > maxReshardChunkSize {
+		return nil, fmt.Errorf("chunk_size must be in (0, %d]", maxReshardChunkSize)
+	}
+	if req.Parallel == 0 {
+		req.Parallel = defaultExportParallel
+	}
+	if req.Parallel < 0 || req.Parallel > maxExportParallel {
+		return nil, fmt.Errorf("parallel must be in (0, %d]", maxExportParallel)
+	}
+	if req.Null == "" {
+		req.Null = defaultExportNull
+	}
+
+	store := newStore(cfg, req.Cluster)
+	namespace, err := store.LoadNamespace(cfg.EncryptKey, req.Namespace)
+	store.Close()
+	if err != nil {
+		return nil, fmt.Errorf("load namespace %s error: %v", req.Namespace, err)
+	}
+	parts, err := exportParts(namespace, req.DB, req.Table)
+	if err != nil {
+		return nil, err
+	}
+	return &Export{cfg: cfg, req: req, namespace: namespace, parts: parts}, nil
+}
+
+// exportRecord format values of row as csv record
+func exportRecord(row []interface{}, null string) []string {
+	record := make([]string, len(row))
+	for i, v := range row {
+		if v == nil {
+			record[i] = null
+			continue
+		}
+		record[i] = string(v.([]byte))
+	}
+	return record
+}
+
+// scan read rows of sub table in chunks ordered by primary key, emit is called with column names and rows of each chunk
+func (e *Export) scan(ctx context.Context, part *BackupTablePart, emit func(columns []string, rows [][]interface{}) error) error {
+	c := newReshardConns(e.namespace, "")
+	c.db = part.DB
+	defer c.close()
+	t, err := c.loadTable(part.Slice, part.Table)
+	if err != nil {
+		return err
+	}
+	columns := make([]string, 0, len(t.columns))
+	for _, col := range t.columns {
+		columns = append(columns, col.name)
+	}
+	dc, err := c.conn(part.Slice)
+	if err != nil {
+		return err
+	}
+
+	var last []interface{}
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		sql := fmt.Sprintf("SELECT %s FROM %s", t.columnList(), t.fullName())
+		if last != nil {
+			sql += fmt.Sprintf(" WHERE (%s) > %s", t.pkList(), t.pkValues(last))
+		}
+		sql += fmt.Sprintf(" ORDER BY %s LIMIT %d", t.pkList(), e.req.ChunkSize)
+
+		var rows [][]interface{}
+		_, err := dc.ExecuteStream(sql, func([]*mysql.Field) error { return nil }, func(data mysql.RowData) error {
+			row, err := parseRawRow(data, len(t.columns))
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("read %s.%s from slice %s error: %v", part.DB, part.Table, part.Slice, err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := emit(columns, rows); err != nil {
+			return err
+		}
+		if len(rows) < e.req.ChunkSize {
+			return nil
+		}
+		last = rows[len(rows)-1]
+	}
+}
+
+// scanAll scan sub tables with at most parallel goroutines, the first error cancels other scans
+func (e *Export) scanAll(ctx context.Context, scan func(ctx context.Context, index int, part *BackupTablePart) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	sem := make(chan struct{}, e.req.Parallel)
+	for i, part := range e.parts {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, part *BackupTablePart) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := scan(ctx, i, part); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i, part)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// WriteMerged write rows of all sub tables to w as a single csv with header, chunks of sub tables are interleaved
+func (e *Export) WriteMerged(ctx context.Context, w io.Writer) (int64, error) {
+	var lock sync.Mutex
+	var header []string
+	var total int64
+	cw := csv.NewWriter(w)
+	err := e.scanAll(ctx, func(ctx context.Context, _ int, part *BackupTablePart) error {
+		return e.scan(ctx, part, func(columns []string, rows [][]interface{}) error {
+			lock.Lock()
+			defer lock.Unlock()
+			if header == nil {
+				header = columns
+				if err := cw.Write(header); err != nil {
+					return err
+				}
+			} else if len(columns) != len(header) {
+				return fmt.Errorf("columns of %s.%s in slice %s are different from other sub tables", part.DB, part.Table, part.Slice)
+			}
+			for _, row := range rows {
+				if err := cw.Write(exportRecord(row, e.req.Null)); err != nil {
+					return err
+				}
+			}
+			cw.Flush()
+			total += int64(len(rows))
+			return cw.Error()
+		})
+	})
+	if err != nil {
+		proxy.ControllerLogger.Warnf("export %s.%s of namespace %s failed, %v", e.req.DB, e.req.Table, e.req.Namespace, err)
+		return total, err
+	}
+	proxy.ControllerLogger.Infof("export %s.%s of namespace %s finished, rows: %d", e.req.DB, e.req.Table, e.req.Namespace, total)
+	return total, nil
+}
+
+// WriteFiles write rows of each sub table to a csv file with header in <export_dir>/<cluster>/<namespace>/<db>.<table>.<time>
+func (e *Export) WriteFiles(ctx context.Context) (*ExportResult, error) {
+	if e.cfg.ExportDir == "" {
+		return nil, errors.New("export_dir of gaea cc is not set")
+	}
+	name := fmt.Sprintf("%s.%s.%s", e.req.DB, e.req.Table, time.Now().Format("20060102150405"))
+	dir := filepath.Join(e.cfg.ExportDir, e.req.Cluster, e.req.Namespace, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create export directory error: %v", err)
+	}
+	ret := &ExportResult{Dir: dir, Files: make([]*ExportFile, len(e.parts))}
+	for i, part := range e.parts {
+		ret.Files[i] = &ExportFile{Slice: part.Slice, DB: part.DB, Table: part.Table, File: fmt.Sprintf("%s.%s.%s.csv", part.Slice, part.DB, part.Table)}
+	}
+
+	err := e.scanAll(ctx, func(ctx context.Context, index int, part *BackupTablePart) error {
+		file := ret.Files[index]
+		f, err := os.Create(filepath.Join(dir, file.File))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cw := csv.NewWriter(f)
+		err = e.scan(ctx, part, func(columns []string, rows [][]interface{}) error {
+			if file.Rows == 0 {
+				if err := cw.Write(columns); err != nil {
+					return err
+				}
+			}
+			for _, row := range rows {
+				if err := cw.Write(exportRecord(row, e.req.Null)); err != nil {
+					return err
+				}
+			}
+			file.Rows += int64(len(rows))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		return f.Close()
+	})
+	if err != nil {
+		proxy.ControllerLogger.Warnf("export %s.%s of namespace %s failed, %v", e.req.DB, e.req.Table, e.req.Namespace, err)
+		return nil, err
+	}
+	for _, file := range ret.Files {
+		ret.Rows += file.Rows
+	}
+	proxy.ControllerLogger.Infof("export %s.%s of namespace %s to %s finished, rows: %d", e.req.DB, e.req.Table, e.req.Namespace, dir, ret.Rows)
+	return ret, nil
+}
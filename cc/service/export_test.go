@@ -0,0 +1,64 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func TestExportParts(t *testing.T) {
+	ns := &models.Namespace{
+		Name:          "ns",
+		AllowedDBS:    map[string]bool{"db": true},
+		DefaultPhyDBS: map[string]string{"db": "db_phy"},
+		DefaultSlice:  "slice-0",
+		Slices:        []*models.Slice{{Name: "slice-0"}, {Name: "slice-1"}},
+		ShardRules: []*models.Shard{
+			{DB: "db", Table: "tbl", Type: models.ShardHash, Key: "id", Slices: []string{"slice-0", "slice-1"}, Locations: []int{1, 1}},
+			{DB: "db", Table: "tbl_global", Type: models.ShardGlobal, Slices: []string{"slice-0", "slice-1"}, Locations: []int{1, 1}},
+		},
+	}
+	tests := []struct {
+		table  string
+		expect []*BackupTablePart
+	}{
+		{"tbl", []*BackupTablePart{{Slice: "slice-0", DB: "db", Table: "tbl_0000"}, {Slice: "slice-1", DB: "db", Table: "tbl_0001"}}},
+		{"TBL", []*BackupTablePart{{Slice: "slice-0", DB: "db", Table: "tbl_0000"}, {Slice: "slice-1", DB: "db", Table: "tbl_0001"}}},
+		{"tbl_global", []*BackupTablePart{{Slice: "slice-0", DB: "db", Table: "tbl_global"}}},
+		{"tbl_user", []*BackupTablePart{{Slice: "slice-0", DB: "db_phy", Table: "tbl_user"}}},
+	}
+	for _, test := range tests {
+		parts, err := exportParts(ns, "db", test.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parts, test.expect) {
+			t.Errorf("parts of %s are not expected", test.table)
+		}
+	}
+	if _, err := exportParts(ns, "other", "tbl"); err == nil {
+		t.Errorf("db not allowed is not checked")
+	}
+}
+
+func TestExportRecord(t *testing.T) {
+	record := exportRecord([]interface{}{[]byte("1"), nil, []byte("a,b")}, defaultExportNull)
+	if !reflect.DeepEqual(record, []string{"1", `\N`, "a,b"}) {
+		t.Errorf("record: %v", record)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"sync"
+
+	"github.com/XiaoMi/Gaea/cc/proxy"
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// ProxyHealthReport health reported by proxy, Error is set if proxy can not be queried
+type ProxyHealthReport struct {
+	Error  string              `json:"error,omitempty"`
+	Health *models.ProxyHealth `json:"health,omitempty"`
+}
+
+// ClusterHealthReport health of all proxies in cluster
+type ClusterHealthReport struct {
+	Cluster          string `json:"cluster"`
+	Healthy          bool   `json:"healthy"` // all proxies are ready with the same config and no slice is degraded
+	TotalProxies     int    `json:"total_proxies"`
+	ReadyProxies     int    `json:"ready_proxies"`
+	ConfigConsistent bool   `json:"config_consistent"` // all proxies run the same config fingerprint
+
+	// key: namespace/slice, value: the worst status reported by proxies
+	Slices  map[string]string             `json:"slices"`
+	Proxies map[string]*ProxyHealthReport `json:"proxies"` // key: ip:port of proxy admin
+}
+
+var sliceStatusLevel = map[string]int{
+	models.SliceHealthy:  0,
+	models.SliceDegraded: 1,
+	models.SliceDown:     2,
+}
+
+// ClusterHealth query readiness of all proxies in cluster concurrently and aggregate them
+func ClusterHealth(cfg *models.CCConfig, cluster string, maxLag int64) (*ClusterHealthReport, error) {
+	store := newStore(cfg, cluster)
+	defer store.Close()
+	proxies, err := store.ListProxyMonitorMetrics()
+	if err != nil {
+		proxy.ControllerLogger.Warnf("list proxy failed, %v", err)
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	reports := make(map[string]*ProxyHealthReport, len(proxies))
+	for _, p := range proxies {
+		host := p.IP + ":" + p.AdminPort
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			r := &ProxyHealthReport{}
+			h, err := proxy.QueryProxyHealth(host, cfg, maxLag)
+			if err != nil {
+				proxy.ControllerLogger.Warnf("query health of proxy failed, %s %v", host, err)
+				r.Error = err.Error()
+			} else {
+				r.Health = h
+			}
+			mu.Lock()
+			reports[host] = r
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+	return aggregateClusterHealth(cluster, reports), nil
+}
+
+func aggregateClusterHealth(cluster string, reports map[string]*ProxyHealthReport) *ClusterHealthReport {
+	r := &ClusterHealthReport{
+		Cluster:          cluster,
+		TotalProxies:     len(reports),
+		ConfigConsistent: true,
+		Slices:           make(map[string]string),
+		Proxies:          reports,
+	}
+	fingerprint := ""
+	for _, report := range reports {
+		if report.Health == nil {
+			continue
+		}
+		h := report.Health
+		if h.Ready {
+			r.ReadyProxies++
+		}
+		if fingerprint == "" {
+			fingerprint = h.ConfigFingerprint
+		} else if fingerprint != h.ConfigFingerprint {
+			r.ConfigConsistent = false
+		}
+		for ns, nh := range h.Namespaces {
+			for name, sh := range nh.Slices {
+				key := ns + "/" + name
+				if status, ok := r.Slices[key]; !ok || sliceStatusLevel[sh.Status] > sliceStatusLevel[status] {
+					r.Slices[key] = sh.Status
+				}
+			}
+		}
+	}
+
+	r.Healthy = r.ReadyProxies == r.TotalProxies && r.ConfigConsistent
+	for _, status := range r.Slices {
+		if status != models.SliceHealthy {
+			r.Healthy = false
+		}
+	}
+	return r
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func proxyHealth(ready bool, fingerprint string, slices map[string]string) *ProxyHealthReport {
+	nh := &models.NamespaceHealth{Ready: ready, Slices: make(map[string]*models.SliceHealth)}
+	for name, status := range slices {
+		nh.Slices[name] = &models.SliceHealth{Status: status}
+	}
+	return &ProxyHealthReport{Health: &models.ProxyHealth{
+		Ready:             ready,
+		ConfigFingerprint: fingerprint,
+		Namespaces:        map[string]*models.NamespaceHealth{"ns": nh},
+	}}
+}
+
+func TestAggregateClusterHealth(t *testing.T) {
+	healthy := map[string]string{"slice-0": models.SliceHealthy, "slice-1": models.SliceHealthy}
+	r := aggregateClusterHealth("c1", map[string]*ProxyHealthReport{
+		"p1": proxyHealth(true, "a", healthy),
+		"p2": proxyHealth(true, "a", healthy),
+	})
+	if !r.Healthy || r.ReadyProxies != 2 || r.TotalProxies != 2 || !r.ConfigConsistent {
+		t.Errorf("cluster should be healthy, got %+v", r)
+	}
+
+	r = aggregateClusterHealth("c1", map[string]*ProxyHealthReport{
+		"p1": proxyHealth(true, "a", healthy),
+		"p2": proxyHealth(true, "b", map[string]string{"slice-0": models.SliceDegraded, "slice-1": models.SliceHealthy}),
+		"p3": proxyHealth(false, "a", map[string]string{"slice-0": models.SliceHealthy, "slice-1": models.SliceDown}),
+		"p4": {Error: "connection refused"},
+	})
+	if r.Healthy || r.ReadyProxies != 2 || r.TotalProxies != 4 || r.ConfigConsistent {
+		t.Errorf("cluster should not be healthy, got %+v", r)
+	}
+	if r.Slices["ns/slice-0"] != models.SliceDegraded || r.Slices["ns/slice-1"] != models.SliceDown {
+		t.Errorf("slices should have the worst status, got %v", r.Slices)
+	}
+}
@@ -0,0 +1,559 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/cc/proxy"
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// states of resharding task
+const (
+	ReshardPreparing  = "preparing"
+	ReshardCopying    = "copying"
+	ReshardCatchingUp = "catching_up"
+	ReshardSwitching  = "switching"
+	ReshardDone       = "done"
+	ReshardFailed     = "failed"
+	ReshardCanceled   = "canceled"
+)
+
+const (
+	defaultReshardChunkSize = 1000
+	maxReshardChunkSize     = 100000
+	// binlog applied by resharding must be at most so many bytes behind source master before switching
+	reshardCatchupBytes = 1 << 20
+	// wait for transactions in flight to commit after writes are rejected by proxies
+	reshardFreezeWait     = 2 * time.Second
+	reshardSwitchTimeout  = 30 * time.Second
+	reshardPollInterval   = time.Second
+	reshardCatchupTimeout = time.Hour
+)
+
+var errReshardCanceled = errors.New("resharding is canceled")
+
+// sub tables of these types are named <table>_<index> and placed on slices by locations
+var reshardableTypes = map[string]bool{
+	models.ShardHash:       true,
+	models.ShardMod:        true,
+	models.ShardRange:      true,
+	models.ShardExpression: true,
+	models.ShardPlugin:     true,
+}
+
+// ReshardRequest new layout of a sharding table, the number of sub tables must not change.
+// Sub tables whose slice changes are moved, e.g. split slice-0 by moving half of its sub tables to a new slice.
+type ReshardRequest struct {
+	Cluster   string   `json:"cluster"`
+	Namespace string   `json:"namespace"`
+	DB        string   `json:"db"`
+	Table     string   `json:"table"`
+	Slices    []string `json:"slices"`
+	Locations []int    `json:"locations"`
+	ChunkSize int      `json:"chunk_size"` // rows copied in one batch, default 1000
+}
+
+// ReshardMove sub table moved from source slice to target slice
+type ReshardMove struct {
+	Table      string `json:"table"` // physical table, e.g. tbl_0001
+	Index      int    `json:"index"`
+	Source     string `json:"source"`
+	Target     string `json:"target"`
+	Rows       int64  `json:"rows"` // estimated rows of source table
+	CopiedRows int64  `json:"copied_rows"`
+	Copied     bool   `json:"copied"`
+}
+
+// ReshardStatus progress of resharding task
+type ReshardStatus struct {
+	Cluster       string            `json:"cluster"`
+	Namespace     string            `json:"namespace"`
+	DB            string            `json:"db"`
+	Table         string            `json:"table"`
+	Tables        []string          `json:"tables"` // logical tables moved together, including linked tables and tables in the same binding group
+	Slices        []string          `json:"slices"`
+	Locations     []int             `json:"locations"`
+	State         string            `json:"state"`
+	Moves         []*ReshardMove    `json:"moves"`
+	AppliedEvents int64             `json:"applied_events"` // row events of binlog applied to target slices
+	Positions     map[string]string `json:"positions"`      // key: source slice, value: applied binlog position
+	Error         string            `json:"error"`
+	StartTime     int64             `json:"start_time"`
+	FinishTime    int64             `json:"finish_time"`
+}
+
+func (s *ReshardStatus) clone() ReshardStatus {
+	ret := *s
+	ret.Moves = make([]*ReshardMove, 0, len(s.Moves))
+	for _, m := range s.Moves {
+		c := *m
+		ret.Moves = append(ret.Moves, &c)
+	}
+	ret.Positions = make(map[string]string, len(s.Positions))
+	for k, v := range s.Positions {
+		ret.Positions[k] = v
+	}
+	return ret
+}
+
+func findShard(namespace *models.Namespace, db, table string) *models.Shard {
+	for _, s := range namespace.ShardRules {
+		if s.DB == db && s.Table == table {
+			return s
+		}
+	}
+	return nil
+}
+
+// expandLocations return slice of each sub table
+func expandLocations(slices []string, locations []int) []string {
+	var ret []string
+	for i, n := range locations {
+		for j := 0; j < n; j++ {
+			ret = append(ret, slices[i])
+		}
+	}
+	return ret
+}
+
+// reshardTables return shard rules whose sub tables are moved together with the table:
+// tables in the same binding group and linked tables of them
+func reshardTables(namespace *models.Namespace, shard *models.Shard) []*models.Shard {
+	roots := map[string]bool{shard.Table: true}
+	if shard.BindingGroup != "" {
+		for _, s := range namespace.ShardRules {
+			if s.DB == shard.DB && s.BindingGroup == shard.BindingGroup {
+				roots[s.Table] = true
+			}
+		}
+	}
+	var ret []*models.Shard
+	for _, s := range namespace.ShardRules {
+		if s.DB != shard.DB {
+			continue
+		}
+		if roots[s.Table] || (s.Type == models.ShardLinked && roots[s.ParentTable]) {
+			ret = append(ret, s)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Table < ret[j].Table })
+	return ret
+}
+
+// planReshard check the new layout and return tables moved together and sub tables to move
+func planReshard(namespace *models.Namespace, req *ReshardRequest) ([]*models.Shard, []*ReshardMove, error) {
+	shard := findShard(namespace, req.DB, req.Table)
+	if shard == nil {
+		return nil, nil, fmt.Errorf("shard rule of %s.%s not found", req.DB, req.Table)
+	}
+	if !reshardableTypes[shard.Type] || len(shard.Databases) != 0 {
+		return nil, nil, fmt.Errorf("resharding of %s shard rule is not supported", shard.Type)
+	}
+	if len(req.Slices) == 0 || len(req.Slices) != len(req.Locations) {
+		return nil, nil, fmt.Errorf("slices and locations must have the same length")
+	}
+	exists := make(map[string]bool, len(namespace.Slices))
+	for _, s := range namespace.Slices {
+		exists[s.Name] = true
+	}
+	for i, s := range req.Slices {
+		if !exists[s] {
+			return nil, nil, fmt.Errorf("slice %s not found in namespace, add it before resharding", s)
+		}
+		if req.Locations[i] < 0 {
+			return nil, nil, fmt.Errorf("sub table count of slice %s is negative", s)
+		}
+	}
+
+	oldLayout := expandLocations(shard.Slices, shard.Locations)
+	newLayout := expandLocations(req.Slices, req.Locations)
+	if len(oldLayout) != len(newLayout) {
+		return nil, nil, fmt.Errorf("number of sub tables must not change, current: %d, new: %d", len(oldLayout), len(newLayout))
+	}
+
+	tables := reshardTables(namespace, shard)
+	var moves []*ReshardMove
+	for _, t := range tables {
+		if t.Type != models.ShardLinked && !sameLayout(t, shard) {
+			return nil, nil, fmt.Errorf("table %s in binding group %s has different locations", t.Table, shard.BindingGroup)
+		}
+		for i := range oldLayout {
+			if oldLayout[i] == newLayout[i] {
+				continue
+			}
+			moves = append(moves, &ReshardMove{
+				Table:  fmt.Sprintf("%s_%04d", t.Table, i),
+				Index:  i,
+				Source: oldLayout[i],
+				Target: newLayout[i],
+			})
+		}
+	}
+	if len(moves) == 0 {
+		return nil, nil, fmt.Errorf("layout of %s.%s is not changed", req.DB, req.Table)
+	}
+	return tables, moves, nil
+}
+
+func sameLayout(a, b *models.Shard) bool {
+	x, y := expandLocations(a.Slices, a.Locations), expandLocations(b.Slices, b.Locations)
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyReshardLayout set new slices and locations of the tables, linked tables follow their parent
+func applyReshardLayout(namespace *models.Namespace, tables []string, slices []string, locations []int) {
+	moved := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		moved[t] = true
+	}
+	for _, s := range namespace.ShardRules {
+		if s.Type == models.ShardLinked || !moved[s.Table] {
+			continue
+		}
+		s.Slices = append([]string(nil), slices...)
+		s.Locations = append([]int(nil), locations...)
+	}
+}
+
+type reshardTask struct {
+	req    *ReshardRequest
+	status ReshardStatus // protected by lock of ReshardTasks
+	closeC chan struct{}
+}
+
+func (t *reshardTask) canceled() bool {
+	select {
+	case <-t.closeC:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReshardTasks resharding tasks of namespaces, a namespace has at most one running task.
+// Tasks are kept in memory and canceled when gaea cc exits, sub tables copied to target slices are not cleaned.
+type ReshardTasks struct {
+	sync.Mutex
+	cfg   *models.CCConfig
+	tasks map[string]*reshardTask // key: cluster/namespace
+	wg    sync.WaitGroup
+}
+
+// NewReshardTasks constructor of ReshardTasks
+func NewReshardTasks(cfg *models.CCConfig) *ReshardTasks {
+	return &ReshardTasks{cfg: cfg, tasks: make(map[string]*reshardTask)}
+}
+
+func reshardKey(cluster, namespace string) string {
+	return cluster + "/" + namespace
+}
+
+func isReshardFinished(state string) bool {
+	return state == ReshardDone || state == ReshardFailed || state == ReshardCanceled
+}
+
+// Start check the new layout and start resharding in background, progress is reported by List
+func (m *ReshardTasks) Start(req *ReshardRequest) error {
+	if req.ChunkSize == 0 {
+		req.ChunkSize = defaultReshardChunkSize
+	}
+	if req.ChunkSize < 0 || req.ChunkSize > maxReshardChunkSize {
+		return fmt.Errorf("chunk_size must be in (0, %d]", maxReshardChunkSize)
+	}
+	store := newStore(m.cfg, req.Cluster)
+	namespace, err := store.LoadNamespace(m.cfg.EncryptKey, req.Namespace)
+	store.Close()
+	if err != nil {
+		return fmt.Errorf("load namespace %s error: %v", req.Namespace, err)
+	}
+	tables, moves, err := planReshard(namespace, req)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	key := reshardKey(req.Cluster, req.Namespace)
+	if t, ok := m.tasks[key]; ok && !isReshardFinished(t.status.State) {
+		return fmt.Errorf("resharding of namespace %s is running", req.Namespace)
+	}
+	t := &reshardTask{
+		req: req,
+		status: ReshardStatus{
+			Cluster:   req.Cluster,
+			Namespace: req.Namespace,
+			DB:        req.DB,
+			Table:     req.Table,
+			Slices:    req.Slices,
+			Locations: req.Locations,
+			State:     ReshardPreparing,
+			Moves:     moves,
+			Positions: make(map[string]string),
+			StartTime: time.Now().Unix(),
+		},
+		closeC: make(chan struct{}),
+	}
+	for _, s := range tables {
+		t.status.Tables = append(t.status.Tables, s.Table)
+	}
+	m.tasks[key] = t
+	m.wg.Add(1)
+	go m.run(t, namespace)
+	proxy.ControllerLogger.Infof("start resharding %s.%s of namespace %s, slices: %v, locations: %v", req.DB, req.Table, req.Namespace, req.Slices, req.Locations)
+	return nil
+}
+
+func (m *ReshardTasks) update(t *reshardTask, f func(s *ReshardStatus)) {
+	m.Lock()
+	defer m.Unlock()
+	f(&t.status)
+}
+
+func (m *ReshardTasks) setState(t *reshardTask, state string) {
+	m.update(t, func(s *ReshardStatus) { s.State = state })
+	proxy.ControllerLogger.Infof("resharding of namespace %s: %s", t.req.Namespace, state)
+}
+
+func (m *ReshardTasks) run(t *reshardTask, namespace *models.Namespace) {
+	defer m.wg.Done()
+	err := m.reshard(t, namespace)
+	m.update(t, func(s *ReshardStatus) {
+		s.FinishTime = time.Now().Unix()
+		switch {
+		case err == nil:
+			s.State = ReshardDone
+		case err == errReshardCanceled:
+			s.State = ReshardCanceled
+		default:
+			s.State = ReshardFailed
+			s.Error = err.Error()
+		}
+	})
+	if err != nil {
+		proxy.ControllerLogger.Warnf("resharding of namespace %s stopped, %v", t.req.Namespace, err)
+		return
+	}
+	proxy.ControllerLogger.Infof("resharding of namespace %s finished", t.req.Namespace)
+}
+
+// reshard copy sub tables to target slices, apply binlog of source slices to catch up, then switch routing
+func (m *ReshardTasks) reshard(t *reshardTask, namespace *models.Namespace) error {
+	m.Lock()
+	moves := t.status.clone().Moves
+	m.Unlock()
+
+	c := newReshardConns(namespace, t.req.DB)
+	defer c.close()
+
+	// binlog positions are recorded before copying, events after them are applied after copying
+	positions, err := c.prepare(moves)
+	if err != nil {
+		return err
+	}
+	tables, err := c.createTargetTables(moves)
+	if err != nil {
+		return err
+	}
+	for i, move := range moves {
+		rows := move.Rows
+		m.update(t, func(s *ReshardStatus) { s.Moves[i].Rows = rows })
+	}
+
+	m.setState(t, ReshardCopying)
+	for i, move := range moves {
+		err := c.copyTable(move, tables[move.Table], t.req.ChunkSize, func(copied int64) error {
+			m.update(t, func(s *ReshardStatus) { s.Moves[i].CopiedRows = copied })
+			if t.canceled() {
+				return errReshardCanceled
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		m.update(t, func(s *ReshardStatus) { s.Moves[i].Copied = true })
+	}
+
+	m.setState(t, ReshardCatchingUp)
+	appliers, err := m.startAppliers(t, namespace, moves, tables, positions)
+	if err != nil {
+		return err
+	}
+	defer appliers.stop()
+	if err := m.waitCatchup(t, c, appliers, reshardCatchupTimeout); err != nil {
+		return err
+	}
+
+	m.setState(t, ReshardSwitching)
+	return m.switchLayout(t, c, appliers)
+}
+
+// waitCatchup wait until binlog applied is close to source masters
+func (m *ReshardTasks) waitCatchup(t *reshardTask, c *reshardConns, appliers *binlogAppliers, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if err := appliers.err(); err != nil {
+			return err
+		}
+		m.update(t, func(s *ReshardStatus) {
+			s.AppliedEvents, s.Positions = appliers.progress()
+		})
+		caught, err := appliers.caughtUp(c, reshardCatchupBytes)
+		if err != nil {
+			return err
+		}
+		if caught {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("binlog is not caught up in %v", timeout)
+		}
+		select {
+		case <-t.closeC:
+			return errReshardCanceled
+		case <-time.After(reshardPollInterval):
+		}
+	}
+}
+
+// switchLayout reject writes of the namespace in proxies, wait until all binlog is applied,
+// then write the new layout and restore writes in one namespace update
+func (m *ReshardTasks) switchLayout(t *reshardTask, c *reshardConns, appliers *binlogAppliers) error {
+	req := t.req
+	namespace, err := m.loadNamespace(req)
+	if err != nil {
+		return err
+	}
+	readOnly := namespace.ReadOnly
+	namespace.ReadOnly = true
+	if err := ModifyNamespace(namespace, m.cfg, req.Cluster); err != nil {
+		return fmt.Errorf("reject writes of namespace error: %v", err)
+	}
+	// restore writes if switching fails, routing is not changed
+	restore := func(cause error) error {
+		namespace, err := m.loadNamespace(req)
+		if err == nil {
+			namespace.ReadOnly = readOnly
+			err = ModifyNamespace(namespace, m.cfg, req.Cluster)
+		}
+		if err != nil {
+			proxy.ControllerLogger.Warnf("restore writes of namespace %s failed, %v", req.Namespace, err)
+		}
+		return cause
+	}
+
+	time.Sleep(reshardFreezeWait)
+	deadline := time.Now().Add(reshardSwitchTimeout)
+	for {
+		if err := appliers.err(); err != nil {
+			return restore(err)
+		}
+		caught, err := appliers.caughtUp(c, 0)
+		if err != nil {
+			return restore(err)
+		}
+		if caught {
+			break
+		}
+		if time.Now().After(deadline) {
+			return restore(fmt.Errorf("binlog is not applied in %v after writes are rejected", reshardSwitchTimeout))
+		}
+		time.Sleep(reshardPollInterval / 10)
+	}
+	m.update(t, func(s *ReshardStatus) {
+		s.AppliedEvents, s.Positions = appliers.progress()
+	})
+
+	namespace, err = m.loadNamespace(req)
+	if err != nil {
+		return restore(err)
+	}
+	m.Lock()
+	tables := t.status.Tables
+	m.Unlock()
+	applyReshardLayout(namespace, tables, req.Slices, req.Locations)
+	namespace.ReadOnly = readOnly
+	if err := ModifyNamespace(namespace, m.cfg, req.Cluster); err != nil {
+		return restore(fmt.Errorf("switch routing error: %v", err))
+	}
+	return nil
+}
+
+func (m *ReshardTasks) loadNamespace(req *ReshardRequest) (*models.Namespace, error) {
+	store := newStore(m.cfg, req.Cluster)
+	defer store.Close()
+	namespace, err := store.LoadNamespace(m.cfg.EncryptKey, req.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("load namespace %s error: %v", req.Namespace, err)
+	}
+	return namespace, nil
+}
+
+// Cancel cancel resharding of namespace before switching routing
+func (m *ReshardTasks) Cancel(cluster, name string) error {
+	m.Lock()
+	defer m.Unlock()
+	t, ok := m.tasks[reshardKey(cluster, name)]
+	if !ok || isReshardFinished(t.status.State) {
+		return fmt.Errorf("resharding of namespace %s is not running", name)
+	}
+	if t.status.State == ReshardSwitching {
+		return fmt.Errorf("resharding of namespace %s is switching routing, it can not be canceled", name)
+	}
+	if !t.canceled() {
+		close(t.closeC)
+	}
+	return nil
+}
+
+// List return status of all resharding tasks, including finished ones
+func (m *ReshardTasks) List() []ReshardStatus {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]ReshardStatus, 0, len(m.tasks))
+	for _, t := range m.tasks {
+		ret = append(ret, t.status.clone())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return reshardKey(ret[i].Cluster, ret[i].Namespace) < reshardKey(ret[j].Cluster, ret[j].Namespace)
+	})
+	return ret
+}
+
+// Close cancel all running tasks
+func (m *ReshardTasks) Close() {
+	m.Lock()
+	for _, t := range m.tasks {
+		if !isReshardFinished(t.status.State) && !t.canceled() {
+			close(t.closeC)
+		}
+	}
+	m.Unlock()
+	m.wg.Wait()
+}
@@ -0,0 +1,265 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	gomysql "github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// server ids of binlog dump connections, they must differ from server ids of mysql instances
+const (
+	minReshardServerID = 1000000
+	maxReshardServerID = 2000000
+)
+
+// reshardTarget moved sub table and the slice it's moved to
+type reshardTarget struct {
+	table  *reshardTable
+	target string
+}
+
+// binlogApplier apply row events of moved sub tables in binlog of source master to target slices
+type binlogApplier struct {
+	slice   string
+	syncer  *replication.BinlogSyncer
+	targets map[string]*reshardTarget // key: db.table
+	conns   *reshardConns             // connections to target slices, not shared with other appliers
+
+	mu     sync.Mutex
+	pos    gomysql.Position
+	events int64
+	err    error
+}
+
+func (a *binlogApplier) run(ctx context.Context) {
+	err := a.apply(ctx)
+	if err != nil && ctx.Err() == nil {
+		a.mu.Lock()
+		a.err = fmt.Errorf("apply binlog of slice %s error: %v", a.slice, err)
+		a.mu.Unlock()
+	}
+}
+
+func (a *binlogApplier) apply(ctx context.Context) error {
+	a.mu.Lock()
+	pos := a.pos
+	a.mu.Unlock()
+	streamer, err := a.syncer.StartSync(pos)
+	if err != nil {
+		return err
+	}
+	for {
+		ev, err := streamer.GetEvent(ctx)
+		if err != nil {
+			return err
+		}
+		applied := int64(0)
+		switch e := ev.Event.(type) {
+		case *replication.RotateEvent:
+			pos = gomysql.Position{Name: string(e.NextLogName), Pos: uint32(e.Position)}
+		case *replication.RowsEvent:
+			if applied, err = a.applyRows(ev.Header.EventType, e); err != nil {
+				return err
+			}
+		}
+		// fake rotate event at start has no position
+		if ev.Header.LogPos != 0 {
+			pos.Pos = ev.Header.LogPos
+		}
+		a.mu.Lock()
+		a.pos = pos
+		a.events += applied
+		a.mu.Unlock()
+	}
+}
+
+// applyRows write full images of rows to target table, they are idempotent and events before copying can be applied again
+func (a *binlogApplier) applyRows(eventType replication.EventType, e *replication.RowsEvent) (int64, error) {
+	t, ok := a.targets[string(e.Table.Schema)+"."+string(e.Table.Table)]
+	if !ok {
+		return 0, nil
+	}
+	var sqls []string
+	switch eventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		sqls = append(sqls, t.table.replaceSQL(e.Rows))
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		// rows are pairs of before and after images
+		for i := 0; i+1 < len(e.Rows); i += 2 {
+			before, after := e.Rows[i], e.Rows[i+1]
+			if t.table.pkValues(before) != t.table.pkValues(after) {
+				sqls = append(sqls, t.table.deleteSQL(before))
+			}
+			sqls = append(sqls, t.table.replaceSQL([][]interface{}{after}))
+		}
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, row := range e.Rows {
+			sqls = append(sqls, t.table.deleteSQL(row))
+		}
+	default:
+		return 0, nil
+	}
+	for _, sql := range sqls {
+		if _, err := a.conns.execute(t.target, sql); err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
+func (a *binlogApplier) position() gomysql.Position {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.pos
+}
+
+// binlogAppliers appliers of all source slices
+type binlogAppliers struct {
+	appliers []*binlogApplier
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newBinlogSyncer(slice *models.Slice) (*replication.BinlogSyncer, error) {
+	host, port, err := net.SplitHostPort(slice.Master)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID:   uint32(minReshardServerID + time.Now().UnixNano()%(maxReshardServerID-minReshardServerID)),
+		Flavor:     gomysql.MySQLFlavor,
+		Host:       host,
+		Port:       uint16(p),
+		User:       slice.UserName,
+		Password:   slice.Password,
+		UseDecimal: true, // keep precision of decimals
+	}), nil
+}
+
+// startAppliers start applying binlog of source masters from positions recorded before copying
+func (m *ReshardTasks) startAppliers(t *reshardTask, namespace *models.Namespace, moves []*ReshardMove,
+	tables map[string]*reshardTable, positions map[string]gomysql.Position) (*binlogAppliers, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ret := &binlogAppliers{cancel: cancel}
+	for _, move := range moves {
+		var a *binlogApplier
+		for _, x := range ret.appliers {
+			if x.slice == move.Source {
+				a = x
+			}
+		}
+		if a == nil {
+			conns := newReshardConns(namespace, t.req.DB)
+			slice, err := conns.slice(move.Source)
+			if err != nil {
+				ret.stop()
+				return nil, err
+			}
+			syncer, err := newBinlogSyncer(slice)
+			if err != nil {
+				ret.stop()
+				return nil, err
+			}
+			a = &binlogApplier{
+				slice:   move.Source,
+				syncer:  syncer,
+				targets: make(map[string]*reshardTarget),
+				conns:   conns,
+				pos:     positions[move.Source],
+			}
+			ret.appliers = append(ret.appliers, a)
+		}
+		table := tables[move.Table]
+		a.targets[table.db+"."+table.name] = &reshardTarget{table: table, target: move.Target}
+	}
+
+	for _, a := range ret.appliers {
+		ret.wg.Add(1)
+		go func(a *binlogApplier) {
+			defer ret.wg.Done()
+			a.run(ctx)
+		}(a)
+	}
+	return ret, nil
+}
+
+func (b *binlogAppliers) stop() {
+	b.cancel()
+	b.wg.Wait()
+	for _, a := range b.appliers {
+		a.syncer.Close()
+		a.conns.close()
+	}
+}
+
+// err return the first error of appliers
+func (b *binlogAppliers) err() error {
+	for _, a := range b.appliers {
+		a.mu.Lock()
+		err := a.err
+		a.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *binlogAppliers) progress() (int64, map[string]string) {
+	var events int64
+	positions := make(map[string]string, len(b.appliers))
+	for _, a := range b.appliers {
+		a.mu.Lock()
+		events += a.events
+		positions[a.slice] = a.pos.String()
+		a.mu.Unlock()
+	}
+	return events, positions
+}
+
+// caughtUp return true if binlog applied of all source masters are at most maxBytes behind
+func (b *binlogAppliers) caughtUp(c *reshardConns, maxBytes uint32) (bool, error) {
+	for _, a := range b.appliers {
+		master, err := c.masterPosition(a.slice)
+		if err != nil {
+			return false, err
+		}
+		if !positionCaughtUp(a.position(), master, maxBytes) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func positionCaughtUp(applied, master gomysql.Position, maxBytes uint32) bool {
+	if applied.Name != master.Name {
+		return applied.Compare(master) >= 0
+	}
+	return applied.Pos >= master.Pos || master.Pos-applied.Pos <= maxBytes
+}
@@ -0,0 +1,456 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	gomysql "github.com/siddontang/go-mysql/mysql"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// values of these column types are written as hex literals, others are written as quoted strings
+var binaryColumnTypes = map[string]bool{
+	"binary": true, "varbinary": true, "bit": true,
+	"tinyblob": true, "blob": true, "mediumblob": true, "longblob": true,
+	"geometry": true, "point": true, "linestring": true, "polygon": true,
+	"multipoint": true, "multilinestring": true, "multipolygon": true, "geometrycollection": true,
+}
+
+type reshardColumn struct {
+	name     string
+	binary   bool
+	unsigned bool
+}
+
+// reshardTable columns and primary key of a physical sub table
+type reshardTable struct {
+	db      string
+	name    string
+	columns []*reshardColumn
+	pk      []int // indexes of primary key columns
+}
+
+func (t *reshardTable) fullName() string {
+	return quoteName(t.db) + "." + quoteName(t.name)
+}
+
+func (t *reshardTable) columnList() string {
+	names := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		names = append(names, quoteName(c.name))
+	}
+	return strings.Join(names, ",")
+}
+
+func (t *reshardTable) pkList() string {
+	names := make([]string, 0, len(t.pk))
+	for _, i := range t.pk {
+		names = append(names, quoteName(t.columns[i].name))
+	}
+	return strings.Join(names, ",")
+}
+
+func (t *reshardTable) values(row []interface{}) string {
+	vs := make([]string, 0, len(row))
+	for i, v := range row {
+		vs = append(vs, sqlValue(v, t.columns[i]))
+	}
+	return "(" + strings.Join(vs, ",") + ")"
+}
+
+func (t *reshardTable) pkValues(row []interface{}) string {
+	vs := make([]string, 0, len(t.pk))
+	for _, i := range t.pk {
+		vs = append(vs, sqlValue(row[i], t.columns[i]))
+	}
+	return "(" + strings.Join(vs, ",") + ")"
+}
+
+func (t *reshardTable) replaceSQL(rows [][]interface{}) string {
+	vs := make([]string, 0, len(rows))
+	for _, row := range rows {
+		vs = append(vs, t.values(row))
+	}
+	return fmt.Sprintf("REPLACE INTO %s (%s) VALUES %s", t.fullName(), t.columnList(), strings.Join(vs, ","))
+}
+
+func (t *reshardTable) deleteSQL(row []interface{}) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE (%s) = %s", t.fullName(), t.pkList(), t.pkValues(row))
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func quoteString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case 26:
+			b.WriteString(`\Z`)
+		case '\'', '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// sqlValue format value read from backend or binlog as sql literal
+func sqlValue(v interface{}, c *reshardColumn) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		if c.binary {
+			return "X'" + hex.EncodeToString(x) + "'"
+		}
+		return quoteString(string(x))
+	case string:
+		if c.binary {
+			return "X'" + hex.EncodeToString([]byte(x)) + "'"
+		}
+		return quoteString(x)
+	case int8:
+		if c.unsigned {
+			return strconv.FormatUint(uint64(uint8(x)), 10)
+		}
+		return strconv.FormatInt(int64(x), 10)
+	case int16:
+		if c.unsigned {
+			return strconv.FormatUint(uint64(uint16(x)), 10)
+		}
+		return strconv.FormatInt(int64(x), 10)
+	case int32:
+		if c.unsigned {
+			return strconv.FormatUint(uint64(uint32(x)), 10)
+		}
+		return strconv.FormatInt(int64(x), 10)
+	case int64:
+		if c.unsigned {
+			return strconv.FormatUint(uint64(x), 10)
+		}
+		return strconv.FormatInt(x, 10)
+	case int:
+		return strconv.Itoa(x)
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	default:
+		// e.g. decimal
+		return quoteString(fmt.Sprint(x))
+	}
+}
+
+// reshardConns connections to masters of slices used by resharding
+type reshardConns struct {
+	namespace   *models.Namespace
+	db          string // physical db of the tables
+	charset     string
+	collationID mysql.CollationID
+	conns       map[string]*backend.DirectConnection // key: slice name
+}
+
+func newReshardConns(namespace *models.Namespace, db string) *reshardConns {
+	c := &reshardConns{
+		namespace:   namespace,
+		db:          db,
+		charset:     mysql.DefaultCharset,
+		collationID: mysql.DefaultCollationID,
+		conns:       make(map[string]*backend.DirectConnection),
+	}
+	if phyDB, ok := namespace.DefaultPhyDBS[db]; ok && phyDB != "" {
+		c.db = phyDB
+	}
+	// the same charset as proxy, see parseCharset in proxy
+	collation := namespace.DefaultCollation
+	if collation == "" {
+		collation = mysql.CollationNameToCharset[namespace.DefaultCharset]
+	}
+	if id, ok := mysql.CollationIds[collation]; ok && namespace.DefaultCharset != "" {
+		c.charset, c.collationID = namespace.DefaultCharset, id
+	}
+	return c
+}
+
+func (c *reshardConns) slice(name string) (*models.Slice, error) {
+	for _, s := range c.namespace.Slices {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("slice %s not found", name)
+}
+
+func (c *reshardConns) conn(slice string) (*backend.DirectConnection, error) {
+	if dc, ok := c.conns[slice]; ok && !dc.IsClosed() {
+		return dc, nil
+	}
+	s, err := c.slice(slice)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := backend.NewDirectConnection(s.Master, s.UserName, s.Password, c.db, c.charset, c.collationID, false)
+	if err != nil {
+		return nil, fmt.Errorf("connect to master %s of slice %s error: %v", s.Master, slice, err)
+	}
+	c.conns[slice] = dc
+	return dc, nil
+}
+
+func (c *reshardConns) execute(slice, sql string) (*mysql.Result, error) {
+	dc, err := c.conn(slice)
+	if err != nil {
+		return nil, err
+	}
+	r, err := dc.Execute(sql)
+	if err != nil {
+		return nil, fmt.Errorf("execute %.128s in slice %s error: %v", sql, slice, err)
+	}
+	return r, nil
+}
+
+func (c *reshardConns) close() {
+	for _, dc := range c.conns {
+		dc.Close()
+	}
+}
+
+// masterPosition return current binlog position of master of slice
+func (c *reshardConns) masterPosition(slice string) (gomysql.Position, error) {
+	r, err := c.execute(slice, "SHOW MASTER STATUS")
+	if err != nil {
+		return gomysql.Position{}, err
+	}
+	if r.Resultset == nil || r.RowNumber() == 0 {
+		return gomysql.Position{}, fmt.Errorf("binlog of master of slice %s is disabled", slice)
+	}
+	name, err := r.GetString(0, 0)
+	if err != nil {
+		return gomysql.Position{}, err
+	}
+	pos, err := r.GetUint(0, 1)
+	if err != nil {
+		return gomysql.Position{}, err
+	}
+	return gomysql.Position{Name: name, Pos: uint32(pos)}, nil
+}
+
+// checkBinlog binlog of source master must contain full images of rows
+func (c *reshardConns) checkBinlog(slice string) error {
+	expected := map[string]string{"binlog_format": "ROW", "binlog_row_image": "FULL"}
+	for name, value := range expected {
+		r, err := c.execute(slice, fmt.Sprintf("SHOW GLOBAL VARIABLES LIKE '%s'", name))
+		if err != nil {
+			return err
+		}
+		if r.Resultset == nil || r.RowNumber() == 0 {
+			// binlog_row_image does not exist before mysql 5.6, full images are logged
+			continue
+		}
+		v, err := r.GetString(0, 1)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(v, value) {
+			return fmt.Errorf("%s of master of slice %s is %s, must be %s", name, slice, v, value)
+		}
+	}
+	return nil
+}
+
+// prepare check binlog of source masters and record their positions
+func (c *reshardConns) prepare(moves []*ReshardMove) (map[string]gomysql.Position, error) {
+	positions := make(map[string]gomysql.Position)
+	for _, move := range moves {
+		if _, ok := positions[move.Source]; ok {
+			continue
+		}
+		if err := c.checkBinlog(move.Source); err != nil {
+			return nil, err
+		}
+		pos, err := c.masterPosition(move.Source)
+		if err != nil {
+			return nil, err
+		}
+		positions[move.Source] = pos
+	}
+	return positions, nil
+}
+
+// loadTable load columns and primary key of sub table in slice
+func (c *reshardConns) loadTable(slice, table string) (*reshardTable, error) {
+	r, err := c.execute(slice, fmt.Sprintf("SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, COLUMN_KEY FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = %s AND TABLE_NAME = %s ORDER BY ORDINAL_POSITION", quoteString(c.db), quoteString(table)))
+	if err != nil {
+		return nil, err
+	}
+	t := &reshardTable{db: c.db, name: table}
+	pk := make(map[string]int)
+	for i := 0; i < r.RowNumber(); i++ {
+		name, _ := r.GetString(i, 0)
+		dataType, _ := r.GetString(i, 1)
+		columnType, _ := r.GetString(i, 2)
+		key, _ := r.GetString(i, 3)
+		t.columns = append(t.columns, &reshardColumn{
+			name:     name,
+			binary:   binaryColumnTypes[strings.ToLower(dataType)],
+			unsigned: strings.Contains(strings.ToLower(columnType), "unsigned"),
+		})
+		if key == "PRI" {
+			pk[name] = i
+		}
+	}
+	if len(t.columns) == 0 {
+		return nil, fmt.Errorf("table %s.%s not found in slice %s", c.db, table, slice)
+	}
+	if len(pk) == 0 {
+		return nil, fmt.Errorf("table %s.%s has no primary key", c.db, table)
+	}
+
+	// order of primary key columns
+	r, err = c.execute(slice, fmt.Sprintf("SELECT COLUMN_NAME FROM information_schema.STATISTICS "+
+		"WHERE TABLE_SCHEMA = %s AND TABLE_NAME = %s AND INDEX_NAME = 'PRIMARY' ORDER BY SEQ_IN_INDEX", quoteString(c.db), quoteString(table)))
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < r.RowNumber(); i++ {
+		name, _ := r.GetString(i, 0)
+		t.pk = append(t.pk, pk[name])
+	}
+	return t, nil
+}
+
+// createTargetTables create moved sub tables in target slices with the same definition, target tables must be empty
+func (c *reshardConns) createTargetTables(moves []*ReshardMove) (map[string]*reshardTable, error) {
+	tables := make(map[string]*reshardTable, len(moves))
+	for _, move := range moves {
+		t, err := c.loadTable(move.Source, move.Table)
+		if err != nil {
+			return nil, err
+		}
+		tables[move.Table] = t
+
+		r, err := c.execute(move.Source, fmt.Sprintf("SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = %s AND TABLE_NAME = %s",
+			quoteString(c.db), quoteString(move.Table)))
+		if err == nil && r.RowNumber() > 0 {
+			move.Rows, _ = r.GetInt(0, 0)
+		}
+
+		r, err = c.execute(move.Source, "SHOW CREATE TABLE "+t.fullName())
+		if err != nil {
+			return nil, err
+		}
+		ddl, err := r.GetString(0, 1)
+		if err != nil {
+			return nil, err
+		}
+		ddl = strings.Replace(ddl, "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1)
+		if _, err := c.execute(move.Target, ddl); err != nil {
+			return nil, err
+		}
+		r, err = c.execute(move.Target, fmt.Sprintf("SELECT 1 FROM %s LIMIT 1", t.fullName()))
+		if err != nil {
+			return nil, err
+		}
+		if r.RowNumber() != 0 {
+			return nil, fmt.Errorf("table %s.%s in target slice %s is not empty", c.db, move.Table, move.Target)
+		}
+	}
+	return tables, nil
+}
+
+// copyTable copy rows of sub table from source slice to target slice in chunks ordered by primary key,
+// onChunk is called with count of copied rows after each chunk, copying stops if it returns error
+func (c *reshardConns) copyTable(move *ReshardMove, t *reshardTable, chunkSize int, onChunk func(copied int64) error) error {
+	src, err := c.conn(move.Source)
+	if err != nil {
+		return err
+	}
+	var copied int64
+	var last []interface{}
+	for {
+		sql := fmt.Sprintf("SELECT %s FROM %s", t.columnList(), t.fullName())
+		if last != nil {
+			sql += fmt.Sprintf(" WHERE (%s) > %s", t.pkList(), t.pkValues(last))
+		}
+		sql += fmt.Sprintf(" ORDER BY %s LIMIT %d", t.pkList(), chunkSize)
+
+		var rows [][]interface{}
+		_, err := src.ExecuteStream(sql, func([]*mysql.Field) error { return nil }, func(data mysql.RowData) error {
+			row, err := parseRawRow(data, len(t.columns))
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("read %s from slice %s error: %v", move.Table, move.Source, err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if _, err := c.execute(move.Target, t.replaceSQL(rows)); err != nil {
+			return err
+		}
+		copied += int64(len(rows))
+		if err := onChunk(copied); err != nil {
+			return err
+		}
+		if len(rows) < chunkSize {
+			return nil
+		}
+		last = rows[len(rows)-1]
+	}
+}
+
+// parseRawRow return values of text row as []byte without conversion, so that decimals keep their precision
+func parseRawRow(data mysql.RowData, columns int) ([]interface{}, error) {
+	row := make([]interface{}, columns)
+	pos := 0
+	for i := 0; i < columns; i++ {
+		v, next, isNull, ok := mysql.ReadLenEncStringAsBytes(data, pos)
+		if !ok {
+			return nil, mysql.ErrMalformPacket
+		}
+		pos = next
+		if !isNull {
+			row[i] = append([]byte{}, v...)
+		}
+	}
+	return row, nil
+}
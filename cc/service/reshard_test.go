@@ -0,0 +1,130 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	gomysql "github.com/siddontang/go-mysql/mysql"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func reshardNamespace() *models.Namespace {
+	return &models.Namespace{
+		Name: "ns",
+		Slices: []*models.Slice{
+			{Name: "slice-0"}, {Name: "slice-1"}, {Name: "slice-2"},
+		},
+		ShardRules: []*models.Shard{
+			{DB: "db", Table: "tbl", Type: models.ShardHash, Key: "id", Slices: []string{"slice-0", "slice-1"}, Locations: []int{2, 2}},
+			{DB: "db", Table: "tbl_ext", Type: models.ShardLinked, ParentTable: "tbl", Key: "id"},
+			{DB: "db", Table: "other", Type: models.ShardMod, Key: "id", Slices: []string{"slice-0", "slice-1"}, Locations: []int{2, 2}},
+		},
+	}
+}
+
+func TestPlanReshard(t *testing.T) {
+	ns := reshardNamespace()
+	req := &ReshardRequest{DB: "db", Table: "tbl", Slices: []string{"slice-0", "slice-1", "slice-2"}, Locations: []int{1, 2, 1}}
+	tables, moves, err := planReshard(ns, req)
+	if err != nil {
+		t.Fatalf("plan reshard error: %v", err)
+	}
+	if len(tables) != 2 || tables[0].Table != "tbl" || tables[1].Table != "tbl_ext" {
+		t.Errorf("linked table should be moved together, got %v", tables)
+	}
+	// old: 0 0 1 1, new: 0 1 1 2
+	expect := []ReshardMove{
+		{Table: "tbl_0001", Index: 1, Source: "slice-0", Target: "slice-1"},
+		{Table: "tbl_0003", Index: 3, Source: "slice-1", Target: "slice-2"},
+		{Table: "tbl_ext_0001", Index: 1, Source: "slice-0", Target: "slice-1"},
+		{Table: "tbl_ext_0003", Index: 3, Source: "slice-1", Target: "slice-2"},
+	}
+	if len(moves) != len(expect) {
+		t.Fatalf("expect %d moves, got %d", len(expect), len(moves))
+	}
+	for i, m := range moves {
+		if *m != expect[i] {
+			t.Errorf("move %d, expect %+v, got %+v", i, expect[i], *m)
+		}
+	}
+
+	applyReshardLayout(ns, []string{"tbl", "tbl_ext"}, req.Slices, req.Locations)
+	if len(ns.ShardRules[0].Slices) != 3 || ns.ShardRules[0].Locations[2] != 1 {
+		t.Errorf("layout not applied, got %v %v", ns.ShardRules[0].Slices, ns.ShardRules[0].Locations)
+	}
+	if ns.ShardRules[1].Slices != nil || len(ns.ShardRules[2].Slices) != 2 {
+		t.Errorf("linked and other tables should not be changed")
+	}
+}
+
+func TestPlanReshardError(t *testing.T) {
+	tests := []*ReshardRequest{
+		{DB: "db", Table: "unknown", Slices: []string{"slice-0"}, Locations: []int{4}},
+		{DB: "db", Table: "tbl", Slices: []string{"slice-0", "slice-3"}, Locations: []int{2, 2}},
+		{DB: "db", Table: "tbl", Slices: []string{"slice-0", "slice-1"}, Locations: []int{2}},
+		{DB: "db", Table: "tbl", Slices: []string{"slice-0", "slice-1"}, Locations: []int{2, 3}},
+		{DB: "db", Table: "tbl", Slices: []string{"slice-0", "slice-1"}, Locations: []int{2, 2}},
+		{DB: "db", Table: "tbl_ext", Slices: []string{"slice-0"}, Locations: []int{4}},
+	}
+	for _, req := range tests {
+		if _, _, err := planReshard(reshardNamespace(), req); err == nil {
+			t.Errorf("plan reshard of %+v should fail", req)
+		}
+	}
+}
+
+func TestSQLValue(t *testing.T) {
+	tests := []struct {
+		v      interface{}
+		col    reshardColumn
+		expect string
+	}{
+		{nil, reshardColumn{}, "NULL"},
+		{[]byte("it's"), reshardColumn{}, `'it\'s'`},
+		{"a\nb\\", reshardColumn{}, `'a\nb\\'`},
+		{"中文'", reshardColumn{}, `'中文\''`},
+		{[]byte{0x00, 0xff}, reshardColumn{binary: true}, "X'00ff'"},
+		{int8(-1), reshardColumn{unsigned: true}, "255"},
+		{int32(-1), reshardColumn{}, "-1"},
+		{int64(-1), reshardColumn{unsigned: true}, "18446744073709551615"},
+		{float64(1.5), reshardColumn{}, "1.5"},
+	}
+	for _, test := range tests {
+		if got := sqlValue(test.v, &test.col); got != test.expect {
+			t.Errorf("sqlValue of %v, expect %s, got %s", test.v, test.expect, got)
+		}
+	}
+}
+
+func TestPositionCaughtUp(t *testing.T) {
+	tests := []struct {
+		applied, master gomysql.Position
+		maxBytes        uint32
+		expect          bool
+	}{
+		{gomysql.Position{Name: "bin.000001", Pos: 100}, gomysql.Position{Name: "bin.000001", Pos: 100}, 0, true},
+		{gomysql.Position{Name: "bin.000001", Pos: 100}, gomysql.Position{Name: "bin.000001", Pos: 200}, 0, false},
+		{gomysql.Position{Name: "bin.000001", Pos: 100}, gomysql.Position{Name: "bin.000001", Pos: 200}, 100, true},
+		{gomysql.Position{Name: "bin.000001", Pos: 100}, gomysql.Position{Name: "bin.000002", Pos: 4}, 1 << 20, false},
+		{gomysql.Position{Name: "bin.000002", Pos: 4}, gomysql.Position{Name: "bin.000001", Pos: 100}, 0, true},
+	}
+	for _, test := range tests {
+		if got := positionCaughtUp(test.applied, test.master, test.maxBytes); got != test.expect {
+			t.Errorf("applied %v, master %v, expect %v, got %v", test.applied, test.master, test.expect, got)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	gomysql "github.com/siddontang/go-mysql/mysql"
+
+	"github.com/XiaoMi/Gaea/cc/proxy"
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// states of restore task
+const (
+	RestoreReplaying   = "replaying"
+	RestoreRegistering = "registering"
+	RestoreDone        = "done"
+	RestoreFailed      = "failed"
+	RestoreCanceled    = "canceled"
+)
+
+// hook types passed to restore command in GAEA_RESTORE_TYPE
+const (
+	restoreHookLoad   = "load"
+	restoreHookBinlog = "binlog"
+)
+
+// format of GAEA_RESTORE_STOP_DATETIME, the same as --stop-datetime of mysqlbinlog
+const restoreDatetimeFormat = "2006-01-02 15:04:05"
+
+var errRestoreCanceled = errors.New("restore is canceled")
+
+// RestoreTarget empty mysql instance which a slice is restored to
+type RestoreTarget struct {
+	Master   string `json:"master"`
+	UserName string `json:"user_name"`
+	Password string `json:"password"`
+}
+
+// RestoreRequest restore slices of namespace from a backup and the incremental backups before it
+type RestoreRequest struct {
+	Cluster         string                    `json:"cluster"`
+	Namespace       string                    `json:"namespace"`        // namespace of backup
+	Backup          string                    `json:"backup"`           // id of backup, incremental backups are applied after their bases
+	Time            int64                     `json:"time"`             // unix timestamp, transactions committed before it are restored, 0 means the consistent point of backup
+	Targets         map[string]*RestoreTarget `json:"targets"`          // key: slice
+	Register        bool                      `json:"register"`         // register restored slices to target namespace after restore
+	TargetNamespace string                    `json:"target_namespace"` // existing namespace whose slices are replaced by targets, default is namespace of backup
+}
+
+// RestoreShard progress of a slice
+type RestoreShard struct {
+	Slice        string `json:"slice"`
+	Master       string `json:"master"` // target instance
+	Steps        int    `json:"steps"`  // dump and binlog files to apply
+	AppliedSteps int    `json:"applied_steps"`
+	GTID         string `json:"gtid"` // gtid_executed of target after the last applied step
+	Done         bool   `json:"done"`
+}
+
+// RestoreStatus progress of restore task
+type RestoreStatus struct {
+	Cluster         string          `json:"cluster"`
+	Namespace       string          `json:"namespace"`
+	Backup          string          `json:"backup"`
+	Chain           []string        `json:"chain"` // ids of backups applied in order
+	Time            int64           `json:"time"`
+	Register        bool            `json:"register"`
+	TargetNamespace string          `json:"target_namespace"`
+	State           string          `json:"state"`
+	Shards          []*RestoreShard `json:"shards"`
+	Error           string          `json:"error"`
+	StartTime       int64           `json:"start_time"`
+	FinishTime      int64           `json:"finish_time"`
+}
+
+func (s *RestoreStatus) clone() RestoreStatus {
+	ret := *s
+	ret.Chain = append([]string(nil), s.Chain...)
+	ret.Shards = make([]*RestoreShard, 0, len(s.Shards))
+	for _, shard := range s.Shards {
+		c := *shard
+		ret.Shards = append(ret.Shards, &c)
+	}
+	return ret
+}
+
+// restoreStep a file applied to target of a slice
+type restoreStep struct {
+	hookType string
+	file     string // absolute path
+	toGTID   string // gtid set target must contain after the step, empty if binlog is stopped by time
+	stopTime string // stop datetime of binlog, empty means binlog is applied to toGTID
+}
+
+// loadBackupChain load manifest of backup and its bases, return them from the full backup
+func loadBackupChain(cfg *models.CCConfig, cluster, namespace, id string) ([]*BackupManifest, error) {
+	var chain []*BackupManifest
+	for id != "" {
+		m, err := loadBackupManifest(cfg, cluster, namespace, id)
+		if err != nil {
+			return nil, err
+		}
+		chain = append([]*BackupManifest{m}, chain...)
+		if m.Type == BackupFull {
+			return chain, nil
+		}
+		if len(chain) > 1000 {
+			return nil, fmt.Errorf("too many incremental backups before %s", chain[len(chain)-1].ID)
+		}
+		id = m.Base
+	}
+	return nil, fmt.Errorf("full backup of %s is not found", chain[len(chain)-1].ID)
+}
+
+// planRestore return files applied to each slice in order. Backups whose consistent point is not later than
+// restore time are applied to their cut, binlog of the next backup is applied until the restore time.
+func planRestore(cfg *models.CCConfig, chain []*BackupManifest, restoreTime int64) (map[string][]*restoreStep, error) {
+	full := chain[0]
+	if restoreTime != 0 && restoreTime < full.CutTime {
+		return nil, fmt.Errorf("restore time is before consistent point of full backup %s", full.ID)
+	}
+	steps := make(map[string][]*restoreStep)
+	for _, shard := range full.Shards {
+		dir := backupDir(cfg, full.Cluster, full.Namespace, full.ID)
+		steps[shard.Slice] = []*restoreStep{{hookType: restoreHookLoad, file: filepath.Join(dir, shard.DumpFile)}}
+	}
+	for i, m := range chain {
+		stopTime := ""
+		if restoreTime != 0 && restoreTime < m.CutTime {
+			stopTime = time.Unix(restoreTime, 0).Format(restoreDatetimeFormat)
+		}
+		if len(m.Shards) != len(steps) {
+			return nil, fmt.Errorf("slices of backup %s are different from full backup %s", m.ID, full.ID)
+		}
+		dir := backupDir(cfg, m.Cluster, m.Namespace, m.ID)
+		for _, shard := range m.Shards {
+			if _, ok := steps[shard.Slice]; !ok {
+				return nil, fmt.Errorf("slice %s of backup %s is not in full backup %s", shard.Slice, m.ID, full.ID)
+			}
+			step := &restoreStep{hookType: restoreHookBinlog, file: filepath.Join(dir, shard.BinlogFile), toGTID: shard.BinlogTo, stopTime: stopTime}
+			if stopTime != "" {
+				step.toGTID = ""
+			}
+			steps[shard.Slice] = append(steps[shard.Slice], step)
+		}
+		if stopTime != "" {
+			return steps, nil
+		}
+		if restoreTime != 0 && i == len(chain)-1 {
+			return nil, fmt.Errorf("restore time is not before consistent point of backup %s, restore from a later backup", m.ID)
+		}
+	}
+	return steps, nil
+}
+
+// restoreHookEnv return environment variables of restore command
+func restoreHookEnv(slice string, target *RestoreTarget, step *restoreStep) ([]string, error) {
+	host, port, err := net.SplitHostPort(target.Master)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %s of slice %s: %v", target.Master, slice, err)
+	}
+	env := map[string]string{
+		"GAEA_RESTORE_TYPE":          step.hookType,
+		"GAEA_RESTORE_SLICE":         slice,
+		"GAEA_RESTORE_HOST":          host,
+		"GAEA_RESTORE_PORT":          port,
+		"GAEA_RESTORE_USER":          target.UserName,
+		"GAEA_RESTORE_PASSWORD":      target.Password,
+		"GAEA_RESTORE_FILE":          step.file,
+		"GAEA_RESTORE_TO_GTID":       step.toGTID,
+		"GAEA_RESTORE_STOP_DATETIME": step.stopTime,
+	}
+	ret := os.Environ()
+	for k, v := range env {
+		ret = append(ret, k+"="+v)
+	}
+	return ret, nil
+}
+
+// registerRestoredSlices replace masters of slices of namespace by targets, slaves are removed
+func registerRestoredSlices(namespace *models.Namespace, targets map[string]*RestoreTarget) error {
+	for _, slice := range namespace.Slices {
+		target, ok := targets[slice.Name]
+		if !ok {
+			return fmt.Errorf("slice %s of namespace is not restored", slice.Name)
+		}
+		slice.Master = target.Master
+		slice.UserName = target.UserName
+		slice.Password = target.Password
+		slice.Slaves = nil
+		slice.StatisticSlaves = nil
+	}
+	return nil
+}
+
+type restoreTask struct {
+	req    *RestoreRequest
+	status RestoreStatus // protected by lock of RestoreTasks
+	steps  map[string][]*restoreStep
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// RestoreTasks restore tasks of namespaces, a namespace has at most one running task.
+// Tasks are kept in memory and canceled when gaea cc exits, data restored to targets is not cleaned.
+type RestoreTasks struct {
+	sync.Mutex
+	cfg   *models.CCConfig
+	tasks map[string]*restoreTask // key: cluster/namespace
+	wg    sync.WaitGroup
+}
+
+// NewRestoreTasks constructor of RestoreTasks
+func NewRestoreTasks(cfg *models.CCConfig) *RestoreTasks {
+	return &RestoreTasks{cfg: cfg, tasks: make(map[string]*restoreTask)}
+}
+
+func isRestoreFinished(state string) bool {
+	return state == RestoreDone || state == RestoreFailed || state == RestoreCanceled
+}
+
+// Start plan files applied to each slice and start restore in background, progress is reported by List
+func (m *RestoreTasks) Start(req *RestoreRequest) error {
+	if m.cfg.RestoreCommand == "" || m.cfg.BackupDir == "" {
+		return errors.New("restore_command and backup_dir of gaea cc are not set")
+	}
+	if req.Backup == "" {
+		return errors.New("backup is not set")
+	}
+	if req.TargetNamespace == "" {
+		req.TargetNamespace = req.Namespace
+	}
+	chain, err := loadBackupChain(m.cfg, req.Cluster, req.Namespace, req.Backup)
+	if err != nil {
+		return err
+	}
+	steps, err := planRestore(m.cfg, chain, req.Time)
+	if err != nil {
+		return err
+	}
+	var shards []*RestoreShard
+	for slice, s := range steps {
+		target, ok := req.Targets[slice]
+		if !ok || target == nil {
+			return fmt.Errorf("target of slice %s is not set", slice)
+		}
+		shards = append(shards, &RestoreShard{Slice: slice, Master: target.Master, Steps: len(s)})
+	}
+	if len(req.Targets) != len(steps) {
+		return errors.New("targets are set for slices not in backup")
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].Slice < shards[j].Slice })
+
+	m.Lock()
+	defer m.Unlock()
+	key := reshardKey(req.Cluster, req.Namespace)
+	if t, ok := m.tasks[key]; ok && !isRestoreFinished(t.status.State) {
+		return fmt.Errorf("restore of namespace %s is running", req.Namespace)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &restoreTask{
+		req: req,
+		status: RestoreStatus{
+			Cluster:         req.Cluster,
+			Namespace:       req.Namespace,
+			Backup:          req.Backup,
+			Time:            req.Time,
+			Register:        req.Register,
+			TargetNamespace: req.TargetNamespace,
+			State:           RestoreReplaying,
+			Shards:          shards,
+			StartTime:       time.Now().Unix(),
+		},
+		steps:  steps,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for _, b := range chain {
+		t.status.Chain = append(t.status.Chain, b.ID)
+	}
+	m.tasks[key] = t
+	m.wg.Add(1)
+	go m.run(t)
+	proxy.ControllerLogger.Infof("start restore of namespace %s from backups %v, time: %d", req.Namespace, t.status.Chain, req.Time)
+	return nil
+}
+
+func (m *RestoreTasks) update(t *restoreTask, f func(s *RestoreStatus)) {
+	m.Lock()
+	defer m.Unlock()
+	f(&t.status)
+}
+
+func (m *RestoreTasks) setState(t *restoreTask, state string) {
+	m.update(t, func(s *RestoreStatus) { s.State = state })
+	proxy.ControllerLogger.Infof("restore of namespace %s: %s", t.req.Namespace, state)
+}
+
+func (m *RestoreTasks) run(t *restoreTask) {
+	defer m.wg.Done()
+	defer t.cancel()
+	err := m.restore(t)
+	m.update(t, func(s *RestoreStatus) {
+		s.FinishTime = time.Now().Unix()
+		switch {
+		case err == nil:
+			s.State = RestoreDone
+		case err == errRestoreCanceled:
+			s.State = RestoreCanceled
+		default:
+			s.State = RestoreFailed
+			s.Error = err.Error()
+		}
+	})
+	if err != nil {
+		proxy.ControllerLogger.Warnf("restore of namespace %s stopped, %v", t.req.Namespace, err)
+		return
+	}
+	proxy.ControllerLogger.Infof("restore of namespace %s finished", t.req.Namespace)
+}
+
+// restore apply files to targets of all slices concurrently, then register targets to namespace
+func (m *RestoreTasks) restore(t *restoreTask) error {
+	m.Lock()
+	shards := t.status.clone().Shards
+	m.Unlock()
+
+	var wg sync.WaitGroup
+	errC := make(chan error, len(shards))
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, slice string) {
+			defer wg.Done()
+			if err := m.restoreSlice(t, i, slice); err != nil {
+				errC <- err
+				// stop restore of other slices
+				t.cancel()
+			}
+		}(i, shard.Slice)
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != errRestoreCanceled {
+			return err
+		}
+	}
+	if t.ctx.Err() != nil {
+		return errRestoreCanceled
+	}
+
+	if !t.req.Register {
+		return nil
+	}
+	m.setState(t, RestoreRegistering)
+	store := newStore(m.cfg, t.req.Cluster)
+	namespace, err := store.LoadNamespace(m.cfg.EncryptKey, t.req.TargetNamespace)
+	store.Close()
+	if err != nil {
+		return fmt.Errorf("load namespace %s error: %v", t.req.TargetNamespace, err)
+	}
+	if err := registerRestoredSlices(namespace, t.req.Targets); err != nil {
+		return err
+	}
+	if err := ModifyNamespace(namespace, m.cfg, t.req.Cluster); err != nil {
+		return fmt.Errorf("register restored slices to namespace %s error: %v", t.req.TargetNamespace, err)
+	}
+	return nil
+}
+
+// restoreSlice apply files of slice in order, gtid_executed of target is checked after each binlog
+func (m *RestoreTasks) restoreSlice(t *restoreTask, index int, slice string) error {
+	target := t.req.Targets[slice]
+	c := newReshardConns(&models.Namespace{Slices: []*models.Slice{{
+		Name: slice, Master: target.Master, UserName: target.UserName, Password: target.Password,
+	}}}, "")
+	defer c.close()
+
+	for _, step := range t.steps[slice] {
+		env, err := restoreHookEnv(slice, target, step)
+		if err != nil {
+			return err
+		}
+		if err := runBackupHook(t.ctx, m.cfg.RestoreCommand, env); err != nil {
+			if err == errBackupCanceled {
+				return errRestoreCanceled
+			}
+			return fmt.Errorf("%s %s to slice %s error: %v", step.hookType, filepath.Base(step.file), slice, err)
+		}
+		gtids, err := c.gtidExecuted(slice)
+		if err != nil {
+			return err
+		}
+		if step.toGTID != "" {
+			if err := checkGTIDContain(gtids, step.toGTID); err != nil {
+				return fmt.Errorf("%s %s to slice %s is incomplete, %v", step.hookType, filepath.Base(step.file), slice, err)
+			}
+		}
+		m.update(t, func(s *RestoreStatus) {
+			s.Shards[index].AppliedSteps++
+			s.Shards[index].GTID = gtids
+		})
+	}
+	m.update(t, func(s *RestoreStatus) { s.Shards[index].Done = true })
+	return nil
+}
+
+// checkGTIDContain check that executed gtid set contains expected gtid set
+func checkGTIDContain(executed, expected string) error {
+	e, err := gomysql.ParseMysqlGTIDSet(executed)
+	if err != nil {
+		return fmt.Errorf("invalid gtid set %s: %v", executed, err)
+	}
+	o, err := gomysql.ParseMysqlGTIDSet(expected)
+	if err != nil {
+		return fmt.Errorf("invalid gtid set %s: %v", expected, err)
+	}
+	if !e.Contain(o) {
+		return fmt.Errorf("gtid_executed %s does not contain %s", executed, expected)
+	}
+	return nil
+}
+
+// Cancel cancel restore of namespace, running restore commands are killed
+func (m *RestoreTasks) Cancel(cluster, name string) error {
+	m.Lock()
+	defer m.Unlock()
+	t, ok := m.tasks[reshardKey(cluster, name)]
+	if !ok || isRestoreFinished(t.status.State) {
+		return fmt.Errorf("restore of namespace %s is not running", name)
+	}
+	if t.status.State == RestoreRegistering {
+		return fmt.Errorf("restore of namespace %s is registering slices, it can not be canceled", name)
+	}
+	t.cancel()
+	return nil
+}
+
+// List return status of all restore tasks, including finished ones
+func (m *RestoreTasks) List() []RestoreStatus {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]RestoreStatus, 0, len(m.tasks))
+	for _, t := range m.tasks {
+		ret = append(ret, t.status.clone())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return reshardKey(ret[i].Cluster, ret[i].Namespace) < reshardKey(ret[j].Cluster, ret[j].Namespace)
+	})
+	return ret
+}
+
+// Close cancel all running tasks
+func (m *RestoreTasks) Close() {
+	m.Lock()
+	for _, t := range m.tasks {
+		if !isRestoreFinished(t.status.State) {
+			t.cancel()
+		}
+	}
+	m.Unlock()
+	m.wg.Wait()
+}
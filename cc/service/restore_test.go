@@ -0,0 +1,133 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func restoreChain() []*BackupManifest {
+	backup := func(id, typ string, cutTime int64, gtid string) *BackupManifest {
+		m := &BackupManifest{ID: id, Cluster: "c", Namespace: "ns", Type: typ, CutTime: cutTime}
+		for _, slice := range []string{"slice-0", "slice-1"} {
+			shard := &BackupShard{Slice: slice, BinlogTo: slice + ":" + gtid, BinlogFile: slice + ".binlog"}
+			if typ == BackupFull {
+				shard.DumpFile = slice + ".dump"
+			}
+			m.Shards = append(m.Shards, shard)
+		}
+		return m
+	}
+	return []*BackupManifest{
+		backup("b0", BackupFull, 100, "1-10"),
+		backup("b1", BackupIncremental, 200, "1-20"),
+		backup("b2", BackupIncremental, 300, "1-30"),
+	}
+}
+
+func TestPlanRestore(t *testing.T) {
+	cfg := &models.CCConfig{BackupDir: "/backup"}
+	chain := restoreChain()
+
+	steps, err := planRestore(cfg, chain, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := steps["slice-1"]
+	if len(steps) != 2 || len(s) != 4 {
+		t.Fatalf("steps of slice-1: %d, expect: 4", len(s))
+	}
+	if s[0].hookType != restoreHookLoad || s[0].file != filepath.Join("/backup", "c", "ns", "b0", "slice-1.dump") {
+		t.Errorf("first step: %s %s", s[0].hookType, s[0].file)
+	}
+	if s[3].hookType != restoreHookBinlog || s[3].file != filepath.Join("/backup", "c", "ns", "b2", "slice-1.binlog") ||
+		s[3].toGTID != "slice-1:1-30" || s[3].stopTime != "" {
+		t.Errorf("last step: %s %s %s %s", s[3].hookType, s[3].file, s[3].toGTID, s[3].stopTime)
+	}
+
+	// binlog of b1 is applied to cut, binlog of b2 is stopped by time
+	steps, err = planRestore(cfg, chain, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = steps["slice-0"]
+	if len(s) != 4 || s[2].toGTID != "slice-0:1-20" || s[2].stopTime != "" ||
+		s[3].toGTID != "" || s[3].stopTime != time.Unix(250, 0).Format(restoreDatetimeFormat) {
+		t.Errorf("steps of point in time restore are not expected")
+	}
+
+	// stopped in binlog of full backup
+	steps, err = planRestore(cfg, chain, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps["slice-0"]) != 3 || steps["slice-0"][1].toGTID != "slice-0:1-10" || steps["slice-0"][2].stopTime == "" {
+		t.Errorf("steps of restore to cut time of full backup are not expected")
+	}
+
+	if _, err := planRestore(cfg, chain, 99); err == nil {
+		t.Errorf("restore time before full backup is not checked")
+	}
+	if _, err := planRestore(cfg, chain, 300); err == nil {
+		t.Errorf("restore time after the last backup is not checked")
+	}
+
+	chain[2].Shards = chain[2].Shards[:1]
+	if _, err := planRestore(cfg, chain, 0); err == nil {
+		t.Errorf("different slices of backups are not checked")
+	}
+}
+
+func TestRegisterRestoredSlices(t *testing.T) {
+	ns := &models.Namespace{
+		Name: "ns",
+		Slices: []*models.Slice{
+			{Name: "slice-0", Master: "10.0.0.1:3306", Slaves: []string{"10.0.0.2:3306"}},
+			{Name: "slice-1", Master: "10.0.0.3:3306"},
+		},
+	}
+	targets := map[string]*RestoreTarget{
+		"slice-0": {Master: "10.0.1.1:3306", UserName: "u", Password: "p"},
+	}
+	if err := registerRestoredSlices(ns, targets); err == nil {
+		t.Errorf("slice not restored is not checked")
+	}
+
+	targets["slice-1"] = &RestoreTarget{Master: "10.0.1.3:3306", UserName: "u", Password: "p"}
+	if err := registerRestoredSlices(ns, targets); err != nil {
+		t.Fatal(err)
+	}
+	s := ns.Slices[0]
+	if s.Master != "10.0.1.1:3306" || s.UserName != "u" || s.Password != "p" || len(s.Slaves) != 0 {
+		t.Errorf("slice-0 is not replaced: %v", s)
+	}
+	if ns.Slices[1].Master != "10.0.1.3:3306" {
+		t.Errorf("slice-1 is not replaced: %v", ns.Slices[1])
+	}
+}
+
+func TestCheckGTIDContain(t *testing.T) {
+	uuid := "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	if err := checkGTIDContain(uuid+":1-30", uuid+":1-20"); err != nil {
+		t.Error(err)
+	}
+	if err := checkGTIDContain(uuid+":1-10", uuid+":1-20"); err == nil {
+		t.Errorf("gtid set not contained is not checked")
+	}
+}
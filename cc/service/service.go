@@ -32,7 +32,7 @@ func getCoordinatorRoot(cluster string) string {
 
 // ListNamespace return names of all namespace
 func ListNamespace(cfg *models.CCConfig, cluster string) ([]string, error) {
-	client := provider.NewClient(provider.ConfigEtcd, cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
+	client := provider.NewClient(provider.CoordinatorType(cfg.CoordinatorType), cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
 	mConn := provider.NewStore(client)
 	defer mConn.Close()
 	return mConn.ListNamespace()
@@ -40,7 +40,7 @@ func ListNamespace(cfg *models.CCConfig, cluster string) ([]string, error) {
 
 // QueryNamespace return information of namespace specified by names
 func QueryNamespace(names []string, cfg *models.CCConfig, cluster string) (data []*models.Namespace, err error) {
-	client := provider.NewClient(provider.ConfigEtcd, cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
+	client := provider.NewClient(provider.CoordinatorType(cfg.CoordinatorType), cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
 	mConn := provider.NewStore(client)
 	defer mConn.Close()
 	for _, v := range names {
@@ -71,7 +71,7 @@ func ModifyNamespace(namespace *models.Namespace, cfg *models.CCConfig, cluster
 	}
 
 	// sink namespace
-	client := provider.NewClient(provider.ConfigEtcd, cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
+	client := provider.NewClient(provider.CoordinatorType(cfg.CoordinatorType), cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
 	storeConn := provider.NewStore(client)
 	defer storeConn.Close()
 
@@ -80,6 +80,11 @@ func ModifyNamespace(namespace *models.Namespace, cfg *models.CCConfig, cluster
 		return err
 	}
 
+	// proxies watch namespaces in consul and reload them by themselves
+	if provider.CoordinatorType(cfg.CoordinatorType) == provider.ConfigConsul {
+		return nil
+	}
+
 	// proxies ready to reload source
 	proxies, err := storeConn.ListProxyMonitorMetrics()
 	if err != nil {
@@ -108,7 +113,7 @@ func ModifyNamespace(namespace *models.Namespace, cfg *models.CCConfig, cluster
 
 // DelNamespace delete namespace
 func DelNamespace(name string, cfg *models.CCConfig, cluster string) error {
-	client := provider.NewClient(provider.ConfigEtcd, cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
+	client := provider.NewClient(provider.CoordinatorType(cfg.CoordinatorType), cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
 	mConn := provider.NewStore(client)
 	defer mConn.Close()
 
@@ -116,6 +121,13 @@ func DelNamespace(name string, cfg *models.CCConfig, cluster string) error {
 		proxy.ControllerLogger.Warnf("delete namespace %s failed, %s", name, err.Error())
 		return err
 	}
+	if err := mConn.DelNamespaceSyncState(name); err != nil {
+		proxy.ControllerLogger.Warnf("delete sync state of namespace %s failed, %s", name, err.Error())
+	}
+
+	if provider.CoordinatorType(cfg.CoordinatorType) == provider.ConfigConsul {
+		return nil
+	}
 
 	proxies, err := mConn.ListProxyMonitorMetrics()
 	if err != nil {
@@ -139,7 +151,7 @@ func SQLFingerprint(name string, cfg *models.CCConfig, cluster string) (slowSQLs
 	slowSQLs = make(map[string]string, 16)
 	errSQLs = make(map[string]string, 16)
 	// list proxy
-	client := provider.NewClient(provider.ConfigEtcd, cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
+	client := provider.NewClient(provider.CoordinatorType(cfg.CoordinatorType), cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
 	mConn := provider.NewStore(client)
 	defer mConn.Close()
 	proxies, err := mConn.ListProxyMonitorMetrics()
@@ -183,7 +195,7 @@ func SQLFingerprint(name string, cfg *models.CCConfig, cluster string) (slowSQLs
 // ProxyConfigFingerprint return fingerprints of all proxy
 func ProxyConfigFingerprint(cfg *models.CCConfig, cluster string) (r map[string]string, err error) {
 	// list proxy
-	client := provider.NewClient(provider.ConfigEtcd, cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
+	client := provider.NewClient(provider.CoordinatorType(cfg.CoordinatorType), cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
 	mConn := provider.NewStore(client)
 	defer mConn.Close()
 	proxies, err := mConn.ListProxyMonitorMetrics()
@@ -0,0 +1,297 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/cc/proxy"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/provider"
+)
+
+const (
+	defaultMirrorInterval = 10 * time.Second
+	minMirrorInterval     = time.Second
+)
+
+// SyncResult result of synchronizing namespace between clusters
+type SyncResult struct {
+	Name          string `json:"name"`
+	SourceCluster string `json:"source_cluster"`
+	TargetCluster string `json:"target_cluster"`
+	Fingerprint   string `json:"fingerprint"` // md5 of namespace config in source cluster
+	Synced        bool   `json:"synced"`      // false if namespace in target cluster is the same as source cluster
+}
+
+// SyncConflictError namespace in target cluster is modified after last synchronization or not synchronized from source cluster
+type SyncConflictError struct {
+	Name          string
+	TargetCluster string
+	Reason        string
+}
+
+func (e *SyncConflictError) Error() string {
+	return fmt.Sprintf("conflict of namespace %s in cluster %s: %s, use force to overwrite it", e.Name, e.TargetCluster, e.Reason)
+}
+
+func newStore(cfg *models.CCConfig, cluster string) *provider.Store {
+	client := provider.NewClient(provider.CoordinatorType(cfg.CoordinatorType), cfg.CoordinatorAddr, cfg.UserName, cfg.Password, getCoordinatorRoot(cluster))
+	return provider.NewStore(client)
+}
+
+func hasNamespace(store *provider.Store, name string) (bool, error) {
+	names, err := store.ListNamespace()
+	if err != nil {
+		return false, err
+	}
+	for _, n := range names {
+		if n == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SyncNamespace copy namespace from source cluster to target cluster and reload it in proxies of target cluster.
+// If namespace in target cluster is not synchronized from source cluster or modified after last synchronization,
+// SyncConflictError is returned unless force is true.
+func SyncNamespace(name string, cfg *models.CCConfig, sourceCluster, targetCluster string, force bool) (*SyncResult, error) {
+	if sourceCluster == targetCluster {
+		return nil, fmt.Errorf("source and target cluster are the same: %s", sourceCluster)
+	}
+	src := newStore(cfg, sourceCluster)
+	defer src.Close()
+	namespace, err := src.LoadNamespace(cfg.EncryptKey, name)
+	if err != nil {
+		return nil, fmt.Errorf("load namespace %s from cluster %s error: %v", name, sourceCluster, err)
+	}
+	r := &SyncResult{
+		Name:          name,
+		SourceCluster: sourceCluster,
+		TargetCluster: targetCluster,
+		Fingerprint:   namespace.Fingerprint(),
+	}
+
+	dst := newStore(cfg, targetCluster)
+	defer dst.Close()
+	exists, err := hasNamespace(dst, name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		current, err := dst.LoadNamespace(cfg.EncryptKey, name)
+		if err != nil {
+			return nil, fmt.Errorf("load namespace %s from cluster %s error: %v", name, targetCluster, err)
+		}
+		if current.Fingerprint() == r.Fingerprint {
+			return r, nil
+		}
+		if !force {
+			if err := checkSyncConflict(dst, current, sourceCluster, targetCluster); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// ModifyNamespace encrypts the namespace, fingerprint is computed before
+	if err := ModifyNamespace(namespace, cfg, targetCluster); err != nil {
+		return nil, err
+	}
+	state := &models.NamespaceSyncState{
+		Name:          name,
+		SourceCluster: sourceCluster,
+		Fingerprint:   r.Fingerprint,
+		SyncTime:      time.Now().Unix(),
+	}
+	if err := dst.UpdateNamespaceSyncState(state); err != nil {
+		return nil, fmt.Errorf("update sync state of namespace %s error: %v", name, err)
+	}
+	r.Synced = true
+	proxy.ControllerLogger.Infof("sync namespace %s from cluster %s to %s, fingerprint: %s", name, sourceCluster, targetCluster, r.Fingerprint)
+	return r, nil
+}
+
+// checkSyncConflict return SyncConflictError if current namespace in target cluster is not written by last synchronization from source cluster
+func checkSyncConflict(dst *provider.Store, current *models.Namespace, sourceCluster, targetCluster string) error {
+	state, err := dst.LoadNamespaceSyncState(current.Name)
+	if err != nil {
+		return err
+	}
+	conflict := &SyncConflictError{Name: current.Name, TargetCluster: targetCluster}
+	switch {
+	case state == nil:
+		conflict.Reason = "namespace exists and is not synchronized from other cluster"
+	case state.SourceCluster != sourceCluster:
+		conflict.Reason = fmt.Sprintf("namespace is synchronized from cluster %s", state.SourceCluster)
+	case state.Fingerprint != current.Fingerprint():
+		conflict.Reason = fmt.Sprintf("namespace is modified after last synchronization at %s", time.Unix(state.SyncTime, 0).Format("2006-01-02 15:04:05"))
+	default:
+		return nil
+	}
+	return conflict
+}
+
+// MirrorStatus status of namespace mirror
+type MirrorStatus struct {
+	Name          string `json:"name"`
+	SourceCluster string `json:"source_cluster"`
+	TargetCluster string `json:"target_cluster"`
+	Interval      int64  `json:"interval"`       // unit: second
+	LastSyncTime  int64  `json:"last_sync_time"` // unix timestamp of last synchronization which changes target cluster
+	LastCheckTime int64  `json:"last_check_time"`
+	Fingerprint   string `json:"fingerprint"`
+	Error         string `json:"error"` // error of last check, e.g. conflict
+}
+
+type namespaceMirror struct {
+	status MirrorStatus // protected by lock of NamespaceMirrors
+	closeC chan struct{}
+}
+
+// NamespaceMirrors mirrors that continuously synchronize namespaces from source cluster to target cluster.
+// Mirrors are kept in memory and stop when gaea cc exits.
+type NamespaceMirrors struct {
+	sync.Mutex
+	cfg     *models.CCConfig
+	mirrors map[string]*namespaceMirror // key: target cluster/name
+	wg      sync.WaitGroup
+}
+
+// NewNamespaceMirrors constructor of NamespaceMirrors
+func NewNamespaceMirrors(cfg *models.CCConfig) *NamespaceMirrors {
+	return &NamespaceMirrors{cfg: cfg, mirrors: make(map[string]*namespaceMirror)}
+}
+
+func mirrorKey(name, targetCluster string) string {
+	return targetCluster + "/" + name
+}
+
+// Start start mirroring namespace from source cluster to target cluster, namespace in source cluster is checked every interval.
+// A target namespace can only have one mirror, conflicts are not overwritten and reported in status.
+func (m *NamespaceMirrors) Start(name, sourceCluster, targetCluster string, interval time.Duration) error {
+	if sourceCluster == targetCluster {
+		return fmt.Errorf("source and target cluster are the same: %s", sourceCluster)
+	}
+	if interval == 0 {
+		interval = defaultMirrorInterval
+	}
+	if interval < minMirrorInterval {
+		return fmt.Errorf("mirror interval must be >= %v", minMirrorInterval)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	key := mirrorKey(name, targetCluster)
+	if _, ok := m.mirrors[key]; ok {
+		return fmt.Errorf("mirror of namespace %s in cluster %s exists", name, targetCluster)
+	}
+	mirror := &namespaceMirror{
+		status: MirrorStatus{
+			Name:          name,
+			SourceCluster: sourceCluster,
+			TargetCluster: targetCluster,
+			Interval:      int64(interval / time.Second),
+		},
+		closeC: make(chan struct{}),
+	}
+	m.mirrors[key] = mirror
+	m.wg.Add(1)
+	go m.run(mirror, interval)
+	proxy.ControllerLogger.Infof("start mirroring namespace %s from cluster %s to %s", name, sourceCluster, targetCluster)
+	return nil
+}
+
+func (m *NamespaceMirrors) run(mirror *namespaceMirror, interval time.Duration) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.check(mirror)
+		select {
+		case <-ticker.C:
+		case <-mirror.closeC:
+			return
+		}
+	}
+}
+
+func (m *NamespaceMirrors) check(mirror *namespaceMirror) {
+	m.Lock()
+	status := mirror.status
+	m.Unlock()
+
+	r, err := SyncNamespace(status.Name, m.cfg, status.SourceCluster, status.TargetCluster, false)
+	now := time.Now().Unix()
+
+	m.Lock()
+	defer m.Unlock()
+	mirror.status.LastCheckTime = now
+	if err != nil {
+		if mirror.status.Error != err.Error() {
+			proxy.ControllerLogger.Warnf("mirror namespace %s from cluster %s to %s failed, %v", status.Name, status.SourceCluster, status.TargetCluster, err)
+		}
+		mirror.status.Error = err.Error()
+		return
+	}
+	mirror.status.Error = ""
+	mirror.status.Fingerprint = r.Fingerprint
+	if r.Synced {
+		mirror.status.LastSyncTime = now
+	}
+}
+
+// Stop stop mirror of namespace in target cluster
+func (m *NamespaceMirrors) Stop(name, targetCluster string) error {
+	m.Lock()
+	defer m.Unlock()
+	key := mirrorKey(name, targetCluster)
+	mirror, ok := m.mirrors[key]
+	if !ok {
+		return fmt.Errorf("mirror of namespace %s in cluster %s not found", name, targetCluster)
+	}
+	close(mirror.closeC)
+	delete(m.mirrors, key)
+	proxy.ControllerLogger.Infof("stop mirroring namespace %s to cluster %s", name, targetCluster)
+	return nil
+}
+
+// List return status of all mirrors
+func (m *NamespaceMirrors) List() []MirrorStatus {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]MirrorStatus, 0, len(m.mirrors))
+	for _, mirror := range m.mirrors {
+		ret = append(ret, mirror.status)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return mirrorKey(ret[i].Name, ret[i].TargetCluster) < mirrorKey(ret[j].Name, ret[j].TargetCluster)
+	})
+	return ret
+}
+
+// Close stop all mirrors
+func (m *NamespaceMirrors) Close() {
+	m.Lock()
+	for key, mirror := range m.mirrors {
+		close(mirror.closeC)
+		delete(m.mirrors, key)
+	}
+	m.Unlock()
+	m.wg.Wait()
+}
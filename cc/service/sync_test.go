@@ -0,0 +1,74 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/provider"
+)
+
+// memorySource source provider in memory, only Read and Update are used in tests
+type memorySource struct {
+	data map[string][]byte
+}
+
+func (m *memorySource) GetName() string                       { return "memory" }
+func (m *memorySource) OnLoad()                               {}
+func (m *memorySource) Create(path string, data []byte) error { return m.Update(path, data) }
+func (m *memorySource) Update(path string, data []byte) error { m.data[path] = data; return nil }
+func (m *memorySource) Delete(path string) error              { delete(m.data, path); return nil }
+func (m *memorySource) Read(path string) ([]byte, error)      { return m.data[path], nil }
+func (m *memorySource) List(path string) ([]string, error)    { return nil, nil }
+func (m *memorySource) Close() error                          { return nil }
+func (m *memorySource) BasePrefix() string                    { return "/dr" }
+func (m *memorySource) UpdateWithTTL(p string, d []byte, _ time.Duration) error {
+	return m.Update(p, d)
+}
+
+func TestCheckSyncConflict(t *testing.T) {
+	store := provider.NewStore(&memorySource{data: make(map[string][]byte)})
+	current := &models.Namespace{Name: "ns", Online: true}
+
+	tests := []struct {
+		state  *models.NamespaceSyncState
+		reason string // empty means no conflict
+	}{
+		{nil, "not synchronized from other cluster"},
+		{&models.NamespaceSyncState{Name: "ns", SourceCluster: "other", Fingerprint: current.Fingerprint()}, "synchronized from cluster other"},
+		{&models.NamespaceSyncState{Name: "ns", SourceCluster: "prod", Fingerprint: "modified"}, "modified after last synchronization"},
+		{&models.NamespaceSyncState{Name: "ns", SourceCluster: "prod", Fingerprint: current.Fingerprint()}, ""},
+	}
+	for _, test := range tests {
+		if test.state != nil {
+			if err := store.UpdateNamespaceSyncState(test.state); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := checkSyncConflict(store, current, "prod", "dr")
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("expect no conflict, state: %+v, err: %v", test.state, err)
+			}
+			continue
+		}
+		if _, ok := err.(*SyncConflictError); !ok || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("expect conflict %s, state: %+v, err: %v", test.reason, test.state, err)
+		}
+	}
+}
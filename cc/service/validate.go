@@ -0,0 +1,283 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/proxy/router"
+)
+
+// levels of validation item
+const (
+	// ValidationError namespace can not be applied
+	ValidationError = "error"
+	// ValidationWarning namespace can be applied, but may not work as expected
+	ValidationWarning = "warning"
+)
+
+const backendDialTimeout = 3 * time.Second
+
+// ValidationItem problem found in namespace config
+type ValidationItem struct {
+	Level   string `json:"level"`
+	Target  string `json:"target"` // name of slice, backend address or db.table of shard rule, empty means the whole namespace
+	Message string `json:"message"`
+}
+
+// ValidationResult result of validating namespace config
+type ValidationResult struct {
+	Valid bool              `json:"valid"` // false if any error is found
+	Items []*ValidationItem `json:"items"`
+}
+
+func (r *ValidationResult) add(level, target, format string, args ...interface{}) {
+	r.Items = append(r.Items, &ValidationItem{Level: level, Target: target, Message: fmt.Sprintf(format, args...)})
+	if level == ValidationError {
+		r.Valid = false
+	}
+}
+
+// ValidateNamespace verify namespace config and run deep checks, including reachability of backends,
+// sub table counts and strategy config of shard rules. Nothing is written to coordinator or proxies.
+func ValidateNamespace(namespace *models.Namespace) *ValidationResult {
+	r := &ValidationResult{Valid: true}
+	verified := true
+	if err := namespace.Verify(); err != nil {
+		r.add(ValidationError, "", "verify namespace error: %v", err)
+		verified = false
+	}
+
+	for _, s := range namespace.ShardRules {
+		validateShardRule(r, s)
+	}
+	validateShardCounts(r, namespace.ShardRules)
+
+	// create router only if namespace is verified, router assumes that config is verified
+	if verified {
+		if _, err := router.NewRouter(namespace); err != nil {
+			r.add(ValidationError, "", "create router error: %v", err)
+		}
+	}
+
+	validateBackends(r, namespace.Slices)
+	return r
+}
+
+func shardTarget(s *models.Shard) string {
+	return s.DB + "." + s.Table
+}
+
+func isTableShard(ruleType string) bool {
+	switch ruleType {
+	case models.ShardDefault, models.ShardGlobal, models.ShardBroadcast, models.ShardLinked:
+		return false
+	}
+	return true
+}
+
+func isDateShard(ruleType string) bool {
+	switch ruleType {
+	case models.ShardYear, models.ShardMonth, models.ShardDay, models.ShardWeek:
+		return true
+	}
+	return false
+}
+
+// validateShardRule check slices, sub tables and strategy config of shard rule
+func validateShardRule(r *ValidationResult, s *models.Shard) {
+	target := shardTarget(s)
+	if isTableShard(s.Type) && strings.TrimSpace(s.Key) == "" {
+		r.add(ValidationError, target, "sharding key is empty")
+	}
+
+	seen := make(map[string]bool, len(s.Slices))
+	for _, slice := range s.Slices {
+		if seen[slice] {
+			r.add(ValidationWarning, target, "slice %s is listed more than once", slice)
+		}
+		seen[slice] = true
+	}
+
+	if s.Type == models.ShardLinked || s.Type == models.ShardDefault {
+		return
+	}
+	if isDateShard(s.Type) {
+		validateDateRanges(r, s)
+		return
+	}
+
+	sum := 0
+	for i, n := range s.Locations {
+		sum += n
+		if n == 0 && i < len(s.Slices) {
+			r.add(ValidationWarning, target, "slice %s has no sub table", s.Slices[i])
+		}
+		if n < 0 {
+			r.add(ValidationError, target, "sub table count %d of location %d is negative", n, i)
+		}
+	}
+	if len(s.Locations) != 0 && sum <= 0 {
+		r.add(ValidationError, target, "no sub table in locations")
+	}
+
+	if s.Type == models.ShardRange {
+		validateRangeBoundaries(r, s)
+	}
+}
+
+func validateRangeBoundaries(r *ValidationResult, s *models.Shard) {
+	target := shardTarget(s)
+	if len(s.RangeBoundaries) == 0 {
+		if s.TableRowLimit <= 0 {
+			r.add(ValidationError, target, "table_row_limit must be > 0 if range_boundaries is empty")
+		}
+		return
+	}
+
+	if s.TableRowLimit > 0 {
+		r.add(ValidationWarning, target, "table_row_limit is ignored because range_boundaries is set")
+	}
+	for i := 1; i < len(s.RangeBoundaries); i++ {
+		if s.RangeBoundaries[i] <= s.RangeBoundaries[i-1] {
+			r.add(ValidationError, target, "range boundaries %d and %d overlap, boundaries must be in ascending order",
+				s.RangeBoundaries[i-1], s.RangeBoundaries[i])
+		}
+	}
+	// n boundaries split keys into n+1 sub tables
+	sum := 0
+	for _, n := range s.Locations {
+		sum += n
+	}
+	if sum != len(s.RangeBoundaries)+1 {
+		r.add(ValidationError, target, "%d range boundaries make %d sub tables, but there are %d sub tables in locations",
+			len(s.RangeBoundaries), len(s.RangeBoundaries)+1, sum)
+	}
+}
+
+var dateRangeParsers = map[string]func(string) ([]int, error){
+	models.ShardYear:  models.ParseYearRange,
+	models.ShardMonth: models.ParseMonthRange,
+	models.ShardDay:   models.ParseDayRange,
+	models.ShardWeek:  models.ParseWeekRange,
+}
+
+// validateDateRanges check date ranges are valid and do not overlap with each other
+func validateDateRanges(r *ValidationResult, s *models.Shard) {
+	target := shardTarget(s)
+	if len(s.DateRange) == 0 {
+		r.add(ValidationError, target, "date_range is empty")
+		return
+	}
+	if s.FutureTables < 0 {
+		r.add(ValidationError, target, "future_tables must be >= 0")
+	}
+
+	dateRanges := models.ExpandDateRange(s.Type, s.DateRange, s.FutureTables, time.Now())
+	parse := dateRangeParsers[s.Type]
+	owners := make(map[int]string) // key: sub table index, value: date range
+	for _, dateRange := range dateRanges {
+		indexes, err := parse(dateRange)
+		if err != nil {
+			r.add(ValidationError, target, "invalid date range %s: %v", dateRange, err)
+			continue
+		}
+		for _, index := range indexes {
+			if owner, ok := owners[index]; ok {
+				r.add(ValidationError, target, "date range %s overlaps with %s", dateRange, owner)
+				break
+			}
+			owners[index] = dateRange
+		}
+	}
+}
+
+func subTableCount(s *models.Shard) int {
+	if s.Type == models.ShardRange && len(s.RangeBoundaries) != 0 {
+		return len(s.RangeBoundaries) + 1
+	}
+	sum := 0
+	for _, n := range s.Locations {
+		sum += n
+	}
+	return sum
+}
+
+// validateShardCounts warn tables with the same sharding key but different sub table counts,
+// rows with the same key of these tables are in sub tables of different index, so joins on the key are routed across sub tables
+func validateShardCounts(r *ValidationResult, shards []*models.Shard) {
+	first := make(map[string]*models.Shard) // key: db.sharding_key
+	for _, s := range shards {
+		if !isTableShard(s.Type) || isDateShard(s.Type) || s.Key == "" || s.BindingGroup != "" {
+			continue
+		}
+		key := s.DB + "." + strings.ToLower(s.Key)
+		f, ok := first[key]
+		if !ok {
+			first[key] = s
+			continue
+		}
+		if subTableCount(f) != subTableCount(s) {
+			r.add(ValidationWarning, shardTarget(s), "table %s has the same sharding key %s but %d sub tables, while %s has %d",
+				s.Table, s.Key, subTableCount(s), f.Table, subTableCount(f))
+		}
+	}
+}
+
+// validateBackends check that all backends of slices are reachable from gaea cc
+func validateBackends(r *ValidationResult, slices []*models.Slice) {
+	addrs := make(map[string]string) // key: address, value: slice name
+	for _, slice := range slices {
+		for _, addr := range append(append([]string{slice.Master}, slice.Slaves...), slice.StatisticSlaves...) {
+			if addr != "" {
+				addrs[addr] = slice.Name
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	var unreachable []*ValidationItem
+	var wg sync.WaitGroup
+	for addr, slice := range addrs {
+		wg.Add(1)
+		go func(addr, slice string) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", addr, backendDialTimeout)
+			if err != nil {
+				mu.Lock()
+				unreachable = append(unreachable, &ValidationItem{
+					Level:   ValidationWarning,
+					Target:  addr,
+					Message: fmt.Sprintf("backend of slice %s is unreachable: %v", slice, err),
+				})
+				mu.Unlock()
+				return
+			}
+			conn.Close()
+		}(addr, slice)
+	}
+	wg.Wait()
+
+	sort.Slice(unreachable, func(i, j int) bool {
+		return unreachable[i].Target < unreachable[j].Target
+	})
+	r.Items = append(r.Items, unreachable...)
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func TestValidateShardRules(t *testing.T) {
+	shards := []*models.Shard{
+		// overlapping range boundaries
+		{DB: "db", Table: "t_range", Type: models.ShardRange, Key: "id", Locations: []int{2, 1}, Slices: []string{"slice-0", "slice-1"}, RangeBoundaries: []int64{100, 100}, TableRowLimit: 10},
+		// overlapping date ranges
+		{DB: "db", Table: "t_month", Type: models.ShardMonth, Key: "ctime", Slices: []string{"slice-0", "slice-1"}, DateRange: []string{"202001-202006", "202005-202012"}},
+		// missing key and slice without sub table
+		{DB: "db", Table: "t_hash", Type: models.ShardHash, Locations: []int{4, 0}, Slices: []string{"slice-0", "slice-1"}},
+		// same key with different sub table counts
+		{DB: "db", Table: "t_mod_a", Type: models.ShardMod, Key: "uid", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		{DB: "db", Table: "t_mod_b", Type: models.ShardMod, Key: "UID", Locations: []int{4, 4}, Slices: []string{"slice-0", "slice-1"}},
+		{DB: "db", Table: "t_mod_c", Type: models.ShardMod, Key: "uid", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+	}
+	r := &ValidationResult{Valid: true}
+	for _, s := range shards {
+		validateShardRule(r, s)
+	}
+	validateShardCounts(r, shards)
+
+	expect := []ValidationItem{
+		{ValidationWarning, "db.t_range", "table_row_limit is ignored because range_boundaries is set"},
+		{ValidationError, "db.t_range", "range boundaries 100 and 100 overlap, boundaries must be in ascending order"},
+		{ValidationError, "db.t_month", "date range 202005-202012 overlaps with 202001-202006"},
+		{ValidationError, "db.t_hash", "sharding key is empty"},
+		{ValidationWarning, "db.t_hash", "slice slice-1 has no sub table"},
+		{ValidationWarning, "db.t_mod_b", "table t_mod_b has the same sharding key UID but 8 sub tables, while t_mod_a has 4"},
+	}
+	if r.Valid {
+		t.Errorf("result should be invalid")
+	}
+	if len(r.Items) != len(expect) {
+		t.Fatalf("expect %d items, got %d: %+v", len(expect), len(r.Items), r.Items)
+	}
+	for i, item := range r.Items {
+		if *item != expect[i] {
+			t.Errorf("item %d, expect: %+v, got: %+v", i, expect[i], *item)
+		}
+	}
+}
+
+func TestValidateBackends(t *testing.T) {
+	r := &ValidationResult{Valid: true}
+	// port 0 is never reachable
+	validateBackends(r, []*models.Slice{{Name: "slice-0", Master: "127.0.0.1:0"}})
+	if !r.Valid || len(r.Items) != 1 || r.Items[0].Level != ValidationWarning || r.Items[0].Target != "127.0.0.1:0" {
+		t.Errorf("unreachable backend should be warned, got: %+v", r.Items)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/XiaoMi/Gaea/core"
 	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/proxy/server"
+	"github.com/XiaoMi/Gaea/util/tracing"
 )
 
 func main() {
@@ -58,6 +59,24 @@ func main() {
 		cfg = c
 	}
 
+	// init log
+	logging.SetFormat(logging.ParseLogFormat(cfg.LogFormat))
+	if cfg.LogLevel != "" {
+		if err := logging.SetLevel("", cfg.LogLevel); err != nil {
+			fmt.Printf("set log level error:%v\n", err.Error())
+			return
+		}
+	}
+
+	// init tracing
+	if cfg.TracingEndpoint != "" {
+		if err := tracing.Init(cfg.TracingEndpoint, cfg.Service, cfg.TracingSampleRatio); err != nil {
+			fmt.Printf("init tracing error:%v\n", err.Error())
+			return
+		}
+		defer tracing.Close()
+	}
+
 	// init manager
 	mgr, err := server.LoadAndCreateManager(cfg)
 	if err != nil {
@@ -0,0 +1,321 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/XiaoMi/Gaea/config"
+	"github.com/XiaoMi/Gaea/logging"
+)
+
+// ErrClosedConsulClient means consul client closed
+var ErrClosedConsulClient = errors.New("use of closed consul client")
+
+const (
+	defaultConsulPrefix = "/gaea"
+	// consul session ttl must be between 10s and 86400s
+	minConsulSessionTTL = 10 * time.Second
+	consulWatchWaitTime = 5 * time.Minute
+	consulRetryInterval = time.Second
+)
+
+// consulSource consul kv client
+type consulSource struct {
+	sync.Mutex
+	kv      *api.KV
+	session *api.Session
+
+	// sessions of keys written with ttl, key is path
+	ttlSessions map[string]string
+
+	closed bool
+	closeC chan struct{}
+	Prefix string
+}
+
+// NewConsulSource constructor of consulSource, addr is a consul agent address, username and passwd are used for http basic auth
+func NewConsulSource(addr string, username, passwd, root string) (config.SourceProvider, error) {
+	cfg := api.DefaultConfig()
+	// consul client talks to one agent, use the first address if several are given
+	cfg.Address = strings.TrimPrefix(strings.Split(addr, ",")[0], "http://")
+	if username != "" {
+		cfg.HttpAuth = &api.HttpBasicAuth{Username: username, Password: passwd}
+	}
+	c, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(root) == "" {
+		root = defaultConsulPrefix
+	}
+	return &consulSource{
+		kv:          c.KV(),
+		session:     c.Session(),
+		ttlSessions: make(map[string]string),
+		closeC:      make(chan struct{}),
+		Prefix:      root,
+	}, nil
+}
+
+func (c *consulSource) GetName() string {
+	return "consul"
+}
+
+func (c *consulSource) OnLoad() {
+}
+
+// consul keys must not start with "/"
+func consulKey(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+func (c *consulSource) writeOptions() *api.WriteOptions {
+	return &api.WriteOptions{}
+}
+
+func (c *consulSource) queryOptions() *api.QueryOptions {
+	return &api.QueryOptions{RequireConsistent: true}
+}
+
+// Close close consul client and destroy sessions of keys written with ttl
+func (c *consulSource) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.closeC)
+	for path, id := range c.ttlSessions {
+		if _, err := c.session.Destroy(id, c.writeOptions()); err != nil {
+			logging.DefaultLogger.Warnf("consul destroy session of %s failed: %v", path, err)
+		}
+	}
+	c.ttlSessions = nil
+	return nil
+}
+
+// Mkdir consul has no directory, keys with the same prefix are listed as a directory
+func (c *consulSource) Mkdir(dir string) error {
+	return nil
+}
+
+// Create create path with data, return error if path exists
+func (c *consulSource) Create(path string, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedConsulClient
+	}
+	logging.DefaultLogger.Debugf("consul create node %s", path)
+	// check-and-set with index 0 only succeeds when the key does not exist
+	ok, _, err := c.kv.CAS(&api.KVPair{Key: consulKey(path), Value: data, ModifyIndex: 0}, c.writeOptions())
+	if err != nil {
+		logging.DefaultLogger.Debugf("consul create node %s failed: %s", path, err)
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("consul node %s already exists", path)
+	}
+	logging.DefaultLogger.Debugf("consul create node OK")
+	return nil
+}
+
+// Update update path with data
+func (c *consulSource) Update(path string, data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedConsulClient
+	}
+	logging.DefaultLogger.Debugf("consul update node %s", path)
+	if _, err := c.kv.Put(&api.KVPair{Key: consulKey(path), Value: data}, c.writeOptions()); err != nil {
+		logging.DefaultLogger.Debugf("consul update node %s failed: %s", path, err)
+		return err
+	}
+	logging.DefaultLogger.Debugf("consul update node OK")
+	return nil
+}
+
+// UpdateWithTTL update path with data and ttl.
+// The key is acquired by a session with ttl and deleted when the session expires,
+// calling it again renews the session, as the proxy does by heartbeat.
+func (c *consulSource) UpdateWithTTL(path string, data []byte, ttl time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedConsulClient
+	}
+	logging.DefaultLogger.Debugf("consul update node %s with ttl %d", path, ttl)
+	id, err := c.ttlSession(path, ttl)
+	if err != nil {
+		logging.DefaultLogger.Debugf("consul create session of %s failed: %s", path, err)
+		return err
+	}
+	ok, _, err := c.kv.Acquire(&api.KVPair{Key: consulKey(path), Value: data, Session: id}, c.writeOptions())
+	if err != nil {
+		logging.DefaultLogger.Debugf("consul update node %s failed: %s", path, err)
+		return err
+	}
+	if !ok {
+		delete(c.ttlSessions, path)
+		return fmt.Errorf("consul node %s is locked by other session", path)
+	}
+	logging.DefaultLogger.Debugf("consul update node OK")
+	return nil
+}
+
+// ttlSession renew session of path, or create a new one if it does not exist or has expired
+func (c *consulSource) ttlSession(path string, ttl time.Duration) (string, error) {
+	if id, ok := c.ttlSessions[path]; ok {
+		entry, _, err := c.session.Renew(id, c.writeOptions())
+		if err != nil {
+			return "", err
+		}
+		if entry != nil {
+			return id, nil
+		}
+		delete(c.ttlSessions, path)
+	}
+
+	if ttl < minConsulSessionTTL {
+		ttl = minConsulSessionTTL
+	}
+	id, _, err := c.session.Create(&api.SessionEntry{
+		Name:      "gaea-" + consulKey(path),
+		TTL:       ttl.String(),
+		Behavior:  api.SessionBehaviorDelete,
+		LockDelay: time.Nanosecond, // the key can be acquired again immediately after the session expires
+	}, c.writeOptions())
+	if err != nil {
+		return "", err
+	}
+	c.ttlSessions[path] = id
+	return id, nil
+}
+
+// Delete delete path
+func (c *consulSource) Delete(path string) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return ErrClosedConsulClient
+	}
+	logging.DefaultLogger.Debugf("consul delete node %s", path)
+	if _, err := c.kv.Delete(consulKey(path), c.writeOptions()); err != nil {
+		logging.DefaultLogger.Debugf("consul delete node %s failed: %s", path, err)
+		return err
+	}
+	if id, ok := c.ttlSessions[path]; ok {
+		delete(c.ttlSessions, path)
+		c.session.Destroy(id, c.writeOptions())
+	}
+	logging.DefaultLogger.Debugf("consul delete node OK")
+	return nil
+}
+
+// Read read path data, return nil if path does not exist
+func (c *consulSource) Read(path string) ([]byte, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedConsulClient
+	}
+	logging.DefaultLogger.Debugf("consul read node %s", path)
+	pair, _, err := c.kv.Get(consulKey(path), c.queryOptions())
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, nil
+	}
+	return pair.Value, nil
+}
+
+// List list path, return slice of all paths of direct children
+func (c *consulSource) List(path string) ([]string, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return nil, ErrClosedConsulClient
+	}
+	logging.DefaultLogger.Debugf("consul list node %s", path)
+	prefix := strings.TrimSuffix(consulKey(path), "/") + "/"
+	keys, _, err := c.kv.Keys(prefix, "/", c.queryOptions())
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, key := range keys {
+		// keys of sub directories end with separator
+		files = append(files, "/"+strings.TrimSuffix(key, "/"))
+	}
+	return files, nil
+}
+
+// Watch watch path and its children by blocking queries, send the new index to ch when any of them changes.
+// It blocks until the client is closed.
+func (c *consulSource) Watch(path string, ch chan string) error {
+	prefix := consulKey(path)
+	var lastIndex uint64
+	for {
+		select {
+		case <-c.closeC:
+			return ErrClosedConsulClient
+		default:
+		}
+
+		opts := c.queryOptions()
+		opts.WaitIndex = lastIndex
+		opts.WaitTime = consulWatchWaitTime
+		_, meta, err := c.kv.List(prefix, opts)
+		if err != nil {
+			logging.DefaultLogger.Warnf("consul watch %s failed: %v", path, err)
+			select {
+			case <-c.closeC:
+				return ErrClosedConsulClient
+			case <-time.After(consulRetryInterval):
+			}
+			continue
+		}
+
+		index := meta.LastIndex
+		// the index may go backwards, e.g. after a consul snapshot restore, reset and watch from beginning
+		if index < lastIndex {
+			index = 0
+		}
+		changed := lastIndex != 0 && index != lastIndex
+		lastIndex = index
+		if changed {
+			select {
+			case ch <- fmt.Sprintf("%d", index):
+			case <-c.closeC:
+				return ErrClosedConsulClient
+			}
+		}
+	}
+}
+
+// BasePrefix return base prefix
+func (c *consulSource) BasePrefix() string {
+	return c.Prefix
+}
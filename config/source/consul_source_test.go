@@ -0,0 +1,32 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"testing"
+)
+
+func Test_consulKey(t *testing.T) {
+	tests := map[string]string{
+		"/gaea/namespace/ns1": "gaea/namespace/ns1",
+		"gaea/namespace":      "gaea/namespace",
+		"/":                   "",
+	}
+	for path, key := range tests {
+		if got := consulKey(path); got != key {
+			t.Errorf("test consulKey of %s failed, got: %s, want: %s", path, got, key)
+		}
+	}
+}
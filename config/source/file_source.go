@@ -19,17 +19,30 @@ import (
 	"github.com/XiaoMi/Gaea/logging"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ghodss/yaml"
 )
 
 const (
 	defaultFilePath = "./etc/file"
+	// editors usually write a file in several operations, wait until they are done
+	fileWatchDelay = 500 * time.Millisecond
 )
 
+// extensions of config files, yaml files are converted to json when read
+var fileExtensions = []string{".json", ".yaml", ".yml"}
+
 // File source provider for configuration
 type fileSource struct {
 	Prefix string
+
+	closeOnce sync.Once
+	closeC    chan struct{}
 }
 
 // New constructor of etcdSource
@@ -41,7 +54,29 @@ func NewFileSource(path string) (*fileSource, error) {
 		logging.DefaultLogger.Warnf("check file source directory failed, %v", err)
 		return nil, err
 	}
-	return &fileSource{Prefix: path}, nil
+	return &fileSource{Prefix: path, closeC: make(chan struct{})}, nil
+}
+
+func (c *fileSource) GetName() string {
+	return "file"
+}
+
+func (c *fileSource) OnLoad() {
+}
+
+// trimFileExtension return name without extension, return false if it's not a config file
+func trimFileExtension(name string) (string, bool) {
+	// skip hidden files, e.g. swap files of vim
+	if strings.HasPrefix(name, ".") {
+		return "", false
+	}
+	ext := filepath.Ext(name)
+	for _, e := range fileExtensions {
+		if ext == e {
+			return strings.TrimSuffix(name, ext), true
+		}
+	}
+	return "", false
 }
 
 func checkDir(path string) error {
@@ -60,8 +95,11 @@ func checkDir(path string) error {
 	return nil
 }
 
-// Close do nothing
+// Close stop watching
 func (c *fileSource) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeC)
+	})
 	return nil
 }
 
@@ -85,16 +123,28 @@ func (c *fileSource) Delete(path string) error {
 	return nil
 }
 
-// Read read file data
+// Read read file data, path may be given without extension.
+// Content of yaml file is converted to json.
 func (c *fileSource) Read(file string) ([]byte, error) {
+	if _, ok := trimFileExtension(filepath.Base(file)); !ok {
+		for _, ext := range fileExtensions {
+			if _, err := os.Stat(file + ext); err == nil {
+				file += ext
+				break
+			}
+		}
+	}
 	value, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, err
 	}
+	if ext := filepath.Ext(file); ext == ".yaml" || ext == ".yml" {
+		return yaml.YAMLToJSON(value)
+	}
 	return value, nil
 }
 
-// List list path, return slice of all files
+// List list path, return slice of names of config files without extension
 func (c *fileSource) List(path string) ([]string, error) {
 	r := make([]string, 0)
 	files, err := ioutil.ReadDir(path)
@@ -103,12 +153,63 @@ func (c *fileSource) List(path string) ([]string, error) {
 	}
 
 	for _, f := range files {
-		r = append(r, f.Name())
+		if f.IsDir() {
+			continue
+		}
+		if name, ok := trimFileExtension(f.Name()); ok {
+			r = append(r, name)
+		}
 	}
 
 	return r, nil
 }
 
+// Watch watch config files in directory path, send the name of changed file to ch.
+// Changes in a short time are merged into one notification. It blocks until the source is closed.
+func (c *fileSource) Watch(path string, ch chan string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(path); err != nil {
+		return err
+	}
+
+	var timer <-chan time.Time
+	var changed string
+	for {
+		select {
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if _, ok := trimFileExtension(filepath.Base(e.Name)); !ok || e.Op == fsnotify.Chmod {
+				continue
+			}
+			logging.DefaultLogger.Debugf("file source event: %s", e)
+			changed = e.Name
+			if timer == nil {
+				timer = time.After(fileWatchDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logging.DefaultLogger.Warnf("watch file source %s error: %v", path, err)
+		case <-timer:
+			timer = nil
+			select {
+			case ch <- changed:
+			case <-c.closeC:
+				return nil
+			}
+		case <-c.closeC:
+			return nil
+		}
+	}
+}
+
 // BasePrefix return base prefix
 func (c *fileSource) BasePrefix() string {
 	return c.Prefix
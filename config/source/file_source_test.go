@@ -0,0 +1,87 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaea_file_source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"ns_json.json":      `{"name": "ns_json", "online": true}`,
+		"ns_yaml.yaml":      "name: ns_yaml\nonline: true\n",
+		".ns_yaml.yaml.swp": "",
+		"README":            "",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := NewFileSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	names, err := c.List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"ns_json", "ns_yaml"}) {
+		t.Errorf("test List failed, got: %v", names)
+	}
+
+	for name, expect := range map[string]string{
+		"ns_json":      `{"name": "ns_json", "online": true}`,
+		"ns_yaml":      `{"name":"ns_yaml","online":true}`,
+		"ns_yaml.yaml": `{"name":"ns_yaml","online":true}`,
+	} {
+		data, err := c.Read(filepath.Join(dir, name))
+		if err != nil || string(data) != expect {
+			t.Errorf("test Read %s failed, got: %s, err: %v", name, data, err)
+		}
+	}
+
+	ch := make(chan string, 1)
+	go c.Watch(dir, ch)
+	// wait for watcher to be added
+	time.Sleep(100 * time.Millisecond)
+	if err := ioutil.WriteFile(filepath.Join(dir, "ns_new.yml"), []byte("name: ns_new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-ch:
+		if filepath.Base(name) != "ns_new.yml" {
+			t.Errorf("test Watch failed, got: %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("test Watch failed, no notification")
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/XiaoMi/Gaea/config"
+	"github.com/XiaoMi/Gaea/logging"
+)
+
+// ErrReadOnlyKubernetesSource means config in kubernetes is managed by kubernetes api, not by gaea
+var ErrReadOnlyKubernetesSource = errors.New("kubernetes source is read only")
+
+const (
+	// ClusterLabel label of custom resources, value is name of gaea cluster
+	ClusterLabel = "gaea.io/cluster"
+
+	defaultKubernetesPrefix = "/gaea"
+	kubernetesNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	defaultKubernetesNS     = "default"
+	kubernetesTimeout       = 10 * time.Second
+	kubernetesResyncPeriod  = 10 * time.Minute
+)
+
+var (
+	// NamespaceResource custom resource of namespace, spec is the same as models.Namespace
+	NamespaceResource = schema.GroupVersionResource{Group: "gaea.io", Version: "v1alpha1", Resource: "gaeanamespaces"}
+	// DataSourceResource custom resource of data source, spec is the same as models.Slice
+	DataSourceResource = schema.GroupVersionResource{Group: "gaea.io", Version: "v1alpha1", Resource: "gaeadatasources"}
+)
+
+// kubernetesSource reads namespaces from custom resources in kubernetes.
+// Only paths under <prefix>/namespace are supported, other paths are empty.
+type kubernetesSource struct {
+	client    dynamic.Interface
+	namespace string // kubernetes namespace of custom resources
+	cluster   string // name of gaea cluster, value of ClusterLabel
+
+	closeOnce sync.Once
+	closeC    chan struct{}
+	Prefix    string
+}
+
+// NewKubernetesSource constructor of kubernetesSource, kubeconfig is path of kubeconfig file,
+// in-cluster config is used if it's empty. Custom resources in the namespace of pod with label gaea.io/cluster=<root> are read.
+func NewKubernetesSource(kubeconfig, root string) (config.SourceProvider, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(root) == "" {
+		root = defaultKubernetesPrefix
+	}
+	return &kubernetesSource{
+		client:    client,
+		namespace: podNamespace(),
+		cluster:   strings.TrimPrefix(root, "/"),
+		closeC:    make(chan struct{}),
+		Prefix:    root,
+	}, nil
+}
+
+// podNamespace return namespace of the pod which proxy runs in
+func podNamespace() string {
+	if data, err := ioutil.ReadFile(kubernetesNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return defaultKubernetesNS
+}
+
+func (c *kubernetesSource) GetName() string {
+	return "kubernetes"
+}
+
+func (c *kubernetesSource) OnLoad() {
+}
+
+// Close stop watching
+func (c *kubernetesSource) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeC)
+	})
+	return nil
+}
+
+// Create not supported
+func (c *kubernetesSource) Create(path string, data []byte) error {
+	return ErrReadOnlyKubernetesSource
+}
+
+// Update not supported
+func (c *kubernetesSource) Update(path string, data []byte) error {
+	return ErrReadOnlyKubernetesSource
+}
+
+// UpdateWithTTL not supported
+func (c *kubernetesSource) UpdateWithTTL(path string, data []byte, ttl time.Duration) error {
+	return ErrReadOnlyKubernetesSource
+}
+
+// Delete not supported
+func (c *kubernetesSource) Delete(path string) error {
+	return ErrReadOnlyKubernetesSource
+}
+
+// namespaceName return name of namespace if path is <prefix>/namespace/<name>
+func (c *kubernetesSource) namespaceName(path string) (string, bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, c.Prefix), "/"), "/")
+	if len(parts) != 2 || parts[0] != "namespace" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func (c *kubernetesSource) selector() string {
+	return ClusterLabel + "=" + c.cluster
+}
+
+// Read read spec of namespace as json, slices referenced by data_sources are appended to slices
+func (c *kubernetesSource) Read(path string) ([]byte, error) {
+	name, ok := c.namespaceName(path)
+	if !ok {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesTimeout)
+	defer cancel()
+
+	logging.DefaultLogger.Debugf("kubernetes read namespace %s/%s", c.namespace, name)
+	obj, err := c.client.Resource(NamespaceResource).Namespace(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if obj.GetLabels()[ClusterLabel] != c.cluster {
+		return nil, nil
+	}
+
+	spec, err := resourceSpec(obj)
+	if err != nil {
+		return nil, err
+	}
+	refs, _, err := unstructured.NestedStringSlice(spec, "data_sources")
+	if err != nil {
+		return nil, err
+	}
+	delete(spec, "data_sources")
+	slices, _, err := unstructured.NestedSlice(spec, "slices")
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		ds, err := c.client.Resource(DataSourceResource).Namespace(c.namespace).Get(ctx, ref, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("get data source %s of namespace %s failed: %v", ref, name, err)
+		}
+		slice, err := resourceSpec(ds)
+		if err != nil {
+			return nil, err
+		}
+		slices = append(slices, slice)
+	}
+	spec["slices"] = slices
+	return json.Marshal(spec)
+}
+
+// resourceSpec return spec of custom resource, name in spec defaults to name of resource
+func resourceSpec(obj *unstructured.Unstructured) (map[string]interface{}, error) {
+	spec, ok, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		spec = make(map[string]interface{})
+	}
+	if name, _ := spec["name"].(string); name == "" {
+		spec["name"] = obj.GetName()
+	}
+	return spec, nil
+}
+
+// List list names of namespaces of the gaea cluster if path is <prefix>/namespace
+func (c *kubernetesSource) List(path string) ([]string, error) {
+	if strings.Trim(strings.TrimPrefix(path, c.Prefix), "/") != "namespace" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kubernetesTimeout)
+	defer cancel()
+
+	logging.DefaultLogger.Debugf("kubernetes list namespaces in %s", c.namespace)
+	list, err := c.client.Resource(NamespaceResource).Namespace(c.namespace).List(ctx, metav1.ListOptions{LabelSelector: c.selector()})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	return names, nil
+}
+
+// Watch watch namespaces and data sources of the gaea cluster by informers, send name of changed resource to ch.
+// Notifications are dropped if ch is full, because receiver reloads all namespaces anyway. It blocks until the source is closed.
+func (c *kubernetesSource) Watch(path string, ch chan string) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client, kubernetesResyncPeriod, c.namespace, func(o *metav1.ListOptions) {
+		o.LabelSelector = c.selector()
+	})
+	notify := func(obj interface{}) {
+		key, _ := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		logging.DefaultLogger.Debugf("kubernetes resource changed: %s", key)
+		select {
+		case ch <- key:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, obj interface{}) { notify(obj) },
+		DeleteFunc: notify,
+	}
+	factory.ForResource(NamespaceResource).Informer().AddEventHandler(handler)
+	factory.ForResource(DataSourceResource).Informer().AddEventHandler(handler)
+	factory.Start(c.closeC)
+	factory.WaitForCacheSync(c.closeC)
+	<-c.closeC
+	return nil
+}
+
+// BasePrefix return base prefix
+func (c *kubernetesSource) BasePrefix() string {
+	return c.Prefix
+}
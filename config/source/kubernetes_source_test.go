@@ -0,0 +1,90 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func newTestResource(kind, name, cluster string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gaea.io/v1alpha1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+			"labels":    map[string]interface{}{ClusterLabel: cluster},
+		},
+		"spec": spec,
+	}}
+}
+
+func TestKubernetesSource(t *testing.T) {
+	objs := []runtime.Object{
+		newTestResource("GaeaNamespace", "ns-a", "gaea", map[string]interface{}{
+			"name":         "ns_a",
+			"data_sources": []interface{}{"ds-0"},
+			"slices":       []interface{}{map[string]interface{}{"name": "slice-inline"}},
+		}),
+		newTestResource("GaeaNamespace", "ns-b", "other", map[string]interface{}{}),
+		newTestResource("GaeaDataSource", "ds-0", "gaea", map[string]interface{}{"master": "127.0.0.1:3306"}),
+	}
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		NamespaceResource:  "GaeaNamespaceList",
+		DataSourceResource: "GaeaDataSourceList",
+	}, objs...)
+	c := &kubernetesSource{client: client, namespace: "default", cluster: "gaea", closeC: make(chan struct{}), Prefix: "/gaea"}
+
+	names, err := c.List("/gaea/namespace")
+	if err != nil || !reflect.DeepEqual(names, []string{"ns-a"}) {
+		t.Fatalf("test List failed, names: %v, err: %v", names, err)
+	}
+	if names, _ := c.List("/gaea/proxy"); len(names) != 0 {
+		t.Errorf("test List of unsupported path failed, names: %v", names)
+	}
+
+	data, err := c.Read("/gaea/namespace/ns-a")
+	if err != nil {
+		t.Fatalf("test Read failed, err: %v", err)
+	}
+	var ns map[string]interface{}
+	if err := json.Unmarshal(data, &ns); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]interface{}{
+		"name": "ns_a",
+		"slices": []interface{}{
+			map[string]interface{}{"name": "slice-inline"},
+			map[string]interface{}{"name": "ds-0", "master": "127.0.0.1:3306"},
+		},
+	}
+	if !reflect.DeepEqual(ns, expect) {
+		t.Errorf("test Read failed, got: %s", data)
+	}
+
+	// namespace of other cluster and missing namespace
+	for _, path := range []string{"/gaea/namespace/ns-b", "/gaea/namespace/ns-c"} {
+		if data, err := c.Read(path); err != nil || data != nil {
+			t.Errorf("test Read %s failed, got: %s, err: %v", path, data, err)
+		}
+	}
+}
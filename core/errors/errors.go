@@ -94,6 +94,9 @@ var (
 	// ErrUserIsReadOnly user is readonly
 	ErrUserIsReadOnly = errors.New("user is readonly")
 
+	// ErrExecutionCancelled execution in slice is cancelled because of error in other slice
+	ErrExecutionCancelled = errors.New("execution is cancelled because of error in other slice")
+
 	// ErrNamespaceNotPrepared commit namespace source without prepare
 	ErrNamespaceNotPrepared = errors.New("namespace is not prepared")
 )
@@ -3,22 +3,34 @@ module github.com/XiaoMi/Gaea
 go 1.15
 
 require (
+	github.com/Shopify/sarama v1.27.2
 	github.com/coreos/etcd v3.3.13+incompatible
 	github.com/emirpasic/gods v1.12.0
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/ghodss/yaml v1.0.1-0.20190212211648-25d852aebe32
 	github.com/gin-contrib/gzip v0.0.1
 	github.com/gin-gonic/gin v1.5.0
 	github.com/go-ini/ini v1.42.0
-	github.com/golang/mock v1.3.1
+	github.com/golang/mock v1.4.1
+	github.com/hashicorp/consul/api v1.4.0
 	github.com/pingcap/check v0.0.0-20200212061837-5e12011dc712
 	github.com/pingcap/errors v0.11.5-0.20190809092503-95897b64e011
 	github.com/pingcap/parser v0.0.0-20200623164729-3a18f1e5dceb
 	github.com/pingcap/tidb v1.1.0-beta.0.20200630082100-328b6d0a955c
 	github.com/prometheus/client_golang v1.5.1
+	github.com/siddontang/go-mysql v1.1.0
 	github.com/smartystreets/goconvey v0.0.0-20190222223459-a17d461953aa // indirect
-	github.com/stretchr/testify v1.5.1
+	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	go.uber.org/config v1.4.0
 	go.uber.org/multierr v1.5.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/text v0.3.6
+	google.golang.org/grpc v1.40.0
 	gopkg.in/ini.v1 v1.42.0
-
+	k8s.io/apimachinery v0.20.15
+	k8s.io/client-go v0.20.15
 )
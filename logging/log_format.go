@@ -1,5 +1,7 @@
 package logging
 
+import "strings"
+
 type LogFormat int
 
 const (
@@ -7,3 +9,15 @@ const (
 	PlaintextOutput
 	JSONOutput
 )
+
+// ParseLogFormat parse log format from config, colorized output is used if unknown
+func ParseLogFormat(format string) LogFormat {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "plain", "plaintext", "text":
+		return PlaintextOutput
+	case "json":
+		return JSONOutput
+	default:
+		return ColorizedOutput
+	}
+}
@@ -1,10 +1,34 @@
 package logging
 
 import (
+	"fmt"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"strings"
 	"sync"
+	"time"
+)
+
+// keys of structured log fields
+const (
+	FieldConnID      = "conn_id"
+	FieldNamespace   = "namespace"
+	FieldUser        = "user"
+	FieldFingerprint = "fingerprint"
+	FieldShard       = "shard"
+	FieldBackendAddr = "backend_addr"
+	FieldTraceID     = "trace_id"
+	FieldCost        = "cost_ms"
+	FieldSQL         = "sql"
+)
+
+// default sampling of high-volume logs: in every tick, log the first N entries with the same level and message,
+// and then log every Mth entry.
+const (
+	defaultSampleTick       = time.Second
+	defaultSampleFirst      = 10
+	defaultSampleThereafter = 100
 )
 
 var loggerMutex sync.RWMutex // guards access to global logger state
@@ -12,11 +36,16 @@ var loggerMutex sync.RWMutex // guards access to global logger state
 // loggers is the set of loggers in the system
 var loggers = make(map[string]*zap.SugaredLogger)
 
+// sampledLoggers is the set of sampled loggers, share the same level with the logger of the same name
+var sampledLoggers = make(map[string]*zap.SugaredLogger)
+
 var levels = make(map[string]zap.AtomicLevel)
 var defaultLevel zapcore.Level = zapcore.InfoLevel
 var output = zapcore.Lock(os.Stdout)
 
-var logCore = newCore(ColorizedOutput, output, defaultLevel)
+// level of each logger is controlled by levels, so the core accepts all levels
+var baseCore = newCore(ColorizedOutput, output, zapcore.DebugLevel)
+var logCore = &lockedMultiCore{cores: []zapcore.Core{baseCore}}
 
 /**
 func newLogger(options []zap.Option) (*zap.Logger, error) {
@@ -60,10 +89,8 @@ func GetLogger(name string) *zap.SugaredLogger {
 	defer loggerMutex.Unlock()
 	log, ok := loggers[name]
 	if !ok {
-		levels[name] = zap.NewAtomicLevelAt(defaultLevel)
-
 		log = zap.New(logCore, zap.AddCaller()).
-			WithOptions(zap.IncreaseLevel(levels[name])).
+			WithOptions(zap.IncreaseLevel(getLevel(name))).
 			Named(name).
 			Sugar()
 
@@ -72,3 +99,89 @@ func GetLogger(name string) *zap.SugaredLogger {
 
 	return log
 }
+
+// GetSampledLogger return logger with sampling, used in high-volume paths like write failures.
+// Use Warnw etc. with constant message to make sampling work, the entries are sampled by level and message.
+func GetSampledLogger(name string) *zap.SugaredLogger {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	log, ok := sampledLoggers[name]
+	if !ok {
+		log = zap.New(logCore, zap.AddCaller()).
+			WithOptions(zap.IncreaseLevel(getLevel(name)), zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+				return zapcore.NewSamplerWithOptions(core, defaultSampleTick, defaultSampleFirst, defaultSampleThereafter)
+			})).
+			Named(name).
+			Sugar()
+
+		sampledLoggers[name] = log
+	}
+
+	return log
+}
+
+// getLevel return level of logger, must be called with loggerMutex held
+func getLevel(name string) zap.AtomicLevel {
+	level, ok := levels[name]
+	if !ok {
+		level = zap.NewAtomicLevelAt(defaultLevel)
+		levels[name] = level
+	}
+	return level
+}
+
+// ParseLevel parse level string, notice is treated as info for compatibility
+func ParseLevel(level string) (zapcore.Level, error) {
+	var l zapcore.Level
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "notice" || level == "trace" {
+		level = "info"
+	}
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return l, fmt.Errorf("invalid log level: %s", level)
+	}
+	return l, nil
+}
+
+// SetLevel set level of logger with name dynamically, empty name means all loggers and the default level
+func SetLevel(name string, level string) error {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	if name == "" {
+		defaultLevel = l
+		for _, lv := range levels {
+			lv.SetLevel(l)
+		}
+		return nil
+	}
+	if _, ok := levels[name]; !ok {
+		return fmt.Errorf("logger not found: %s", name)
+	}
+	levels[name].SetLevel(l)
+	return nil
+}
+
+// GetLevels return levels of all loggers
+func GetLevels() map[string]string {
+	loggerMutex.RLock()
+	defer loggerMutex.RUnlock()
+	ret := make(map[string]string, len(levels))
+	for name, l := range levels {
+		ret[name] = l.Level().String()
+	}
+	return ret
+}
+
+// SetFormat set output format of all loggers
+func SetFormat(format LogFormat) {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	core := newCore(format, output, zapcore.DebugLevel)
+	logCore.ReplaceCore(baseCore, core)
+	baseCore = core
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level  string
+		expect string
+		valid  bool
+	}{
+		{"debug", "debug", true},
+		{"Notice", "info", true},
+		{" WARN ", "warn", true},
+		{"error", "error", true},
+		{"unknown", "", false},
+	}
+	for _, test := range tests {
+		l, err := ParseLevel(test.level)
+		if test.valid != (err == nil) {
+			t.Errorf("parse level %s, expect valid: %v, err: %v", test.level, test.valid, err)
+			continue
+		}
+		if test.valid && l.String() != test.expect {
+			t.Errorf("parse level %s, expect: %s, got: %s", test.level, test.expect, l.String())
+		}
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	log := GetLogger("test-set-level")
+	sampled := GetSampledLogger("test-set-level")
+
+	if err := SetLevel("test-set-level", "error"); err != nil {
+		t.Fatalf("set level error: %v", err)
+	}
+	if log.Desugar().Core().Enabled(zapcore.DebugLevel) || sampled.Desugar().Core().Enabled(zapcore.WarnLevel) {
+		t.Errorf("debug and warn log should be disabled")
+	}
+	if GetLevels()["test-set-level"] != "error" {
+		t.Errorf("get levels error: %v", GetLevels())
+	}
+
+	if err := SetLevel("test-set-level", "debug"); err != nil {
+		t.Fatalf("set level error: %v", err)
+	}
+	if !log.Desugar().Core().Enabled(zapcore.DebugLevel) || !sampled.Desugar().Core().Enabled(zapcore.DebugLevel) {
+		t.Errorf("debug log should be enabled")
+	}
+
+	if err := SetLevel("not-exist", "debug"); err == nil {
+		t.Errorf("set level of not exist logger should fail")
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	if ParseLogFormat("JSON") != JSONOutput || ParseLogFormat("plain") != PlaintextOutput || ParseLogFormat("") != ColorizedOutput {
+		t.Errorf("parse log format error")
+	}
+}
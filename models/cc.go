@@ -27,7 +27,8 @@ type CCConfig struct {
 	AdminPassword string `ini:"admin_password"`
 	ProxyUserName string `ini:"proxy_username"`
 	ProxyPassword string `ini:"proxy_password"`
-	// etcd 相关配置
+	// etcd/consul 相关配置
+	CoordinatorType string `ini:"coordinator_type"` // etcd/consul, 默认etcd
 	CoordinatorAddr string `ini:"coordinator_addr"`
 	CoordinatorRoot string `ini:"coordinator_root"`
 	UserName        string `ini:"username"`
@@ -41,6 +42,16 @@ type CCConfig struct {
 	LogOutput   string `ini:"log_output"`
 
 	EncryptKey string `ini:"encrypt_key"`
+
+	CDCAddr string `ini:"cdc_addr"` // 数据变更流的gRPC服务地址, 为空表示不开启
+
+	// 备份相关配置, 均设置时才能发起备份
+	BackupCommand string `ini:"backup_command"` // 每个slice执行的备份命令, 通过sh执行, 参数见GAEA_BACKUP_*环境变量
+	BackupDir     string `ini:"backup_dir"`     // 备份文件和manifest的根目录
+	// 恢复时每个slice执行的命令, 通过sh执行, 参数见GAEA_RESTORE_*环境变量
+	RestoreCommand string `ini:"restore_command"`
+
+	ExportDir string `ini:"export_dir"` // 逻辑表按子表导出文件的根目录
 }
 
 // ParseCCConfig parser gaea cc source from file
@@ -0,0 +1,43 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+// ProxyState runtime state of a proxy shared with other proxies in the same cluster
+type ProxyState struct {
+	Token      string `json:"token"`
+	UpdateTime int64  `json:"update_time"` // unix timestamp, second
+
+	// namespace -> user -> count of client connections
+	Connections map[string]map[string]int64 `json:"connections"`
+}
+
+// Encode encode proxy state
+func (p *ProxyState) Encode() []byte {
+	return JSONEncode(p)
+}
+
+// KillRequest request of killing all client connections of a user in the cluster
+type KillRequest struct {
+	ID         string `json:"id"`
+	Namespace  string `json:"namespace"`
+	User       string `json:"user"`
+	Token      string `json:"token"`       // token of the proxy which receives the request
+	CreateTime int64  `json:"create_time"` // unix timestamp, second
+}
+
+// Encode encode kill request
+func (k *KillRequest) Encode() []byte {
+	return JSONEncode(k)
+}
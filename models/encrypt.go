@@ -0,0 +1,111 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// encrypt modes of column
+const (
+	// EncryptModeRandom encrypt value with random nonce, the column can not be used in conditions
+	EncryptModeRandom = "random"
+	// EncryptModeDeterministic the same value is always encrypted to the same data, the column supports equality conditions
+	EncryptModeDeterministic = "deterministic"
+)
+
+// EncryptKeyProviderConfig keys are read from keys of encryption config
+const EncryptKeyProviderConfig = "config"
+
+// Encryption transparent encryption of sensitive columns, values are encrypted before written to backend
+// and decrypted in results
+type Encryption struct {
+	KeyProvider string            `json:"key_provider"` // 密钥来源, 为空表示config, 其他值需要在proxy中注册同名的KeyProvider
+	Keys        map[string]string `json:"keys"`         // key_provider为config时的密钥, key为密钥ID, value为base64编码的16/24/32字节AES密钥
+	Columns     []*EncryptColumn  `json:"columns"`
+}
+
+// EncryptColumn sensitive column, the column should be VARCHAR or VARBINARY to store base64 encoded data
+type EncryptColumn struct {
+	DB     string `json:"db"`
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	KeyID  string `json:"key_id"`
+	Mode   string `json:"mode"` // random/deterministic, 空表示random
+}
+
+// IsValidEncryptMode check if the encrypt mode is supported
+func IsValidEncryptMode(mode string) bool {
+	switch mode {
+	case EncryptModeRandom, EncryptModeDeterministic:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetKeyProvider return name of key provider
+func (e *Encryption) GetKeyProvider() string {
+	if e.KeyProvider == "" {
+		return EncryptKeyProviderConfig
+	}
+	return e.KeyProvider
+}
+
+func (e *Encryption) verify(rules []*Shard) error {
+	if e == nil {
+		return nil
+	}
+	if len(e.Columns) == 0 {
+		return fmt.Errorf("no encrypt column")
+	}
+	for id, key := range e.Keys {
+		data, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return fmt.Errorf("invalid encrypt key %s: %v", id, err)
+		}
+		if l := len(data); l != 16 && l != 24 && l != 32 {
+			return fmt.Errorf("invalid length of encrypt key %s: %d", id, l)
+		}
+	}
+
+	columns := make(map[string]bool, len(e.Columns))
+	for _, c := range e.Columns {
+		if c.DB == "" || c.Table == "" || c.Column == "" || c.KeyID == "" {
+			return fmt.Errorf("db, table, column and key_id of encrypt column must be set")
+		}
+		if c.Mode != "" && !IsValidEncryptMode(c.Mode) {
+			return fmt.Errorf("invalid encrypt mode of %s.%s.%s: %s", c.DB, c.Table, c.Column, c.Mode)
+		}
+		if _, ok := e.Keys[c.KeyID]; !ok && e.GetKeyProvider() == EncryptKeyProviderConfig {
+			return fmt.Errorf("encrypt key of %s.%s.%s not found: %s", c.DB, c.Table, c.Column, c.KeyID)
+		}
+		name := strings.ToLower(c.DB + "." + c.Table + "." + c.Column)
+		if columns[name] {
+			return fmt.Errorf("duplicate encrypt column: %s", name)
+		}
+		columns[name] = true
+	}
+
+	// sharding column is used to route statements, its value can not be encrypted
+	for _, r := range rules {
+		if r.Key != "" && columns[strings.ToLower(r.DB+"."+r.Table+"."+r.Key)] {
+			return fmt.Errorf("sharding column can not be encrypted: %s.%s.%s", r.DB, r.Table, r.Key)
+		}
+	}
+	return nil
+}
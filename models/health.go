@@ -0,0 +1,90 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+// roles of backend in slice
+const (
+	BackendRoleMaster         = "master"
+	BackendRoleSlave          = "slave"
+	BackendRoleStatisticSlave = "statistic_slave"
+)
+
+// health status of slice
+const (
+	// SliceHealthy all backends are reachable and replication lag of slaves is under threshold
+	SliceHealthy = "healthy"
+	// SliceDegraded master is reachable, but some slaves are unreachable or lag behind
+	SliceDegraded = "degraded"
+	// SliceDown master is unreachable
+	SliceDown = "down"
+)
+
+// BackendHealth connectivity and replication status of backend
+type BackendHealth struct {
+	Addr      string `json:"addr"`
+	Role      string `json:"role"`
+	Reachable bool   `json:"reachable"`
+	Circuit   string `json:"circuit,omitempty"` // state of circuit breaker, empty if breaker is disabled
+	Latency   int64  `json:"latency"`           // unit: millisecond
+	Error     string `json:"error,omitempty"`
+
+	Replication *ReplicationStatus `json:"replication,omitempty"` // nil for master
+}
+
+// ReplicationStatus replication status of slave from SHOW SLAVE STATUS
+type ReplicationStatus struct {
+	Running bool   `json:"running"` // both io and sql threads are running
+	Lag     int64  `json:"lag"`     // Seconds_Behind_Master, -1 if unknown
+	Error   string `json:"error,omitempty"`
+}
+
+// SliceHealth health of backends in slice
+type SliceHealth struct {
+	Status   string           `json:"status"`
+	Backends []*BackendHealth `json:"backends"`
+}
+
+// NamespaceHealth health of slices in namespace, namespace is ready if no slice is down
+type NamespaceHealth struct {
+	Ready  bool                    `json:"ready"`
+	Slices map[string]*SliceHealth `json:"slices"`
+}
+
+// ProxyHealth readiness of proxy, proxy is ready if all namespaces are ready
+type ProxyHealth struct {
+	Ready             bool                        `json:"ready"`
+	ConfigFingerprint string                      `json:"config_fingerprint"`
+	CheckTime         int64                       `json:"check_time"` // unix timestamp, second
+	Namespaces        map[string]*NamespaceHealth `json:"namespaces"`
+}
+
+// NewSliceHealth compute status of slice by health of backends, slaves whose lag exceeds maxLag are degraded
+func NewSliceHealth(backends []*BackendHealth, maxLag int64) *SliceHealth {
+	h := &SliceHealth{Status: SliceHealthy, Backends: backends}
+	for _, b := range backends {
+		if b.Role == BackendRoleMaster {
+			if !b.Reachable {
+				h.Status = SliceDown
+				return h
+			}
+			continue
+		}
+		if !b.Reachable || b.Replication == nil || !b.Replication.Running ||
+			b.Replication.Lag < 0 || b.Replication.Lag > maxLag {
+			h.Status = SliceDegraded
+		}
+	}
+	return h
+}
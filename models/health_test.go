@@ -0,0 +1,45 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "testing"
+
+func TestNewSliceHealth(t *testing.T) {
+	master := &BackendHealth{Role: BackendRoleMaster, Reachable: true}
+	slave := func(reachable, running bool, lag int64) *BackendHealth {
+		return &BackendHealth{
+			Role:        BackendRoleSlave,
+			Reachable:   reachable,
+			Replication: &ReplicationStatus{Running: running, Lag: lag},
+		}
+	}
+	tests := []struct {
+		backends []*BackendHealth
+		status   string
+	}{
+		{[]*BackendHealth{master}, SliceHealthy},
+		{[]*BackendHealth{master, slave(true, true, 10)}, SliceHealthy},
+		{[]*BackendHealth{master, slave(true, true, 11)}, SliceDegraded},
+		{[]*BackendHealth{master, slave(true, true, -1)}, SliceDegraded},
+		{[]*BackendHealth{master, slave(true, false, 0)}, SliceDegraded},
+		{[]*BackendHealth{master, {Role: BackendRoleStatisticSlave}}, SliceDegraded},
+		{[]*BackendHealth{{Role: BackendRoleMaster}, slave(true, true, 0)}, SliceDown},
+	}
+	for i, test := range tests {
+		if h := NewSliceHealth(test.backends, 10); h.Status != test.status {
+			t.Errorf("test %d, expect %s, got %s", i, test.status, h.Status)
+		}
+	}
+}
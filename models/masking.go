@@ -0,0 +1,90 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+)
+
+// mask types of values
+const (
+	// MaskTypeFull replace every character with *
+	MaskTypeFull = "full"
+	// MaskTypePhone keep the first 3 and the last 4 digits of mobile phone number
+	MaskTypePhone = "phone"
+	// MaskTypeEmail keep the first character of user name and the domain of email
+	MaskTypeEmail = "email"
+	// MaskTypeIDCard keep the first 6 and the last 4 characters of ID card number
+	MaskTypeIDCard = "id_card"
+)
+
+// Masking masks sensitive values in results returned to users
+type Masking struct {
+	Rules []*MaskRule `json:"rules"`
+}
+
+// MaskRule masks values of a column, or values matching the pattern of type in all string columns if column is not set
+type MaskRule struct {
+	Users  []string `json:"users"` // 生效的用户, 为空表示所有用户
+	DB     string   `json:"db"`    // 指定列时需要设置db和table
+	Table  string   `json:"table"`
+	Column string   `json:"column"` // 为空表示对所有字符串列中符合type格式的内容脱敏, 此时type不能是full
+	Type   string   `json:"type"`   // full/phone/email/id_card, 空表示full
+}
+
+// GetType return mask type of rule
+func (r *MaskRule) GetType() string {
+	if r.Type == "" {
+		return MaskTypeFull
+	}
+	return r.Type
+}
+
+// IsValidMaskType check if the mask type is supported
+func IsValidMaskType(t string) bool {
+	switch t {
+	case MaskTypeFull, MaskTypePhone, MaskTypeEmail, MaskTypeIDCard:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Masking) verify() error {
+	if m == nil {
+		return nil
+	}
+	if len(m.Rules) == 0 {
+		return fmt.Errorf("no mask rule")
+	}
+	for i, r := range m.Rules {
+		if !IsValidMaskType(r.GetType()) {
+			return fmt.Errorf("invalid mask type of rule %d: %s", i, r.Type)
+		}
+		if r.Column == "" {
+			if r.DB != "" || r.Table != "" {
+				return fmt.Errorf("column of mask rule %d must be set with db and table", i)
+			}
+			if r.GetType() == MaskTypeFull {
+				return fmt.Errorf("mask rule %d without column must have type phone, email or id_card", i)
+			}
+			continue
+		}
+		if r.DB == "" || r.Table == "" {
+			return fmt.Errorf("db and table of mask rule %d must be set", i)
+		}
+	}
+	return nil
+}
@@ -29,6 +29,7 @@ import (
 // Namespace means namespace model stored in etcd
 type Namespace struct {
 	OpenGeneralLog   bool              `json:"open_general_log"`
+	AuditLog         bool              `json:"audit_log"`  // 是否记录连接事件和DML/DDL语句的审计日志, 需要proxy配置audit_sink
 	IsEncrypt        bool              `json:"is_encrypt"` // true: 加密存储 false: 非加密存储，目前加密Slice、User中的用户名、密码
 	Name             string            `json:"name"`
 	Online           bool              `json:"online"`
@@ -45,6 +46,128 @@ type Namespace struct {
 	GlobalSequences  []*GlobalSequence `json:"global_sequences"`
 	DefaultCharset   string            `json:"default_charset"`
 	DefaultCollation string            `json:"default_collation"`
+	BackendCharset   string            `json:"backend_charset"`    // 后端连接使用的字符集, 与客户端字符集不同时由proxy转换SQL和结果, 空表示使用客户端字符集
+	MaxExecutionTime string            `json:"max_execution_time"` // 默认语句超时时间, 单位毫秒, 0或空表示不限制
+	TransactionMode  string            `json:"transaction_mode"`   // 默认事务模式, single/multi/twopc, 空表示multi
+	MaxParallelism   string            `json:"max_parallelism"`    // 跨分片执行时并发执行的分片数上限, 0或空表示不限制
+	StreamingSelect  bool              `json:"streaming_select"`   // 跨分片查询是否以流式方式将结果返回给客户端
+	MaxQueryMemory   string            `json:"max_query_memory"`   // 单条语句缓存结果集的内存上限, 单位字节, 0或空表示不限制
+	DDLStrategy      string            `json:"ddl_strategy"`       // 分片表ALTER TABLE的执行方式, direct/gh-ost/pt-osc, 空表示direct
+	FullScatter      string            `json:"full_scatter"`       // 没有分片列条件, 下发到所有子表的语句的处理方式, allow/warn/reject, 空表示allow
+	ReadConsistency  string            `json:"read_consistency"`   // 读写分离时读请求的一致性, eventual/session, 空表示eventual
+	ShardKeyUpdate   string            `json:"shard_key_update"`   // 修改分片列的UPDATE的处理方式, reject/relocate, 空表示reject
+
+	EnableSystemSettings bool `json:"enable_system_settings"` // 是否将proxy不处理的会话级系统变量下发到后端连接, false表示忽略这些变量
+
+	MaxConnections    int64  `json:"max_connections"`     // 集群范围内namespace所有用户的最大连接数, 0表示不限制
+	ClientIdleTimeout string `json:"client_idle_timeout"` // 客户端连接空闲超时时间, 单位秒, 0或空表示使用proxy的session_timeout
+
+	SchemaRefreshInterval string `json:"schema_refresh_interval"` // 从后端加载表结构的间隔, 单位秒, 0或空表示不自动加载
+
+	RateLimit   *RateLimit   `json:"rate_limit"`   // 单个proxy内namespace所有用户的每秒查询数上限, 为空表示不限制
+	Shadow      *Shadow      `json:"shadow"`       // 按比例将查询异步重放到影子namespace并比较结果, 为空表示不开启
+	Encryption  *Encryption  `json:"encryption"`   // 敏感列透明加密, 为空表示不加密
+	Tenant      *Tenant      `json:"tenant"`       // 多租户表的行级隔离, 为空表示不开启
+	ResultCache *ResultCache `json:"result_cache"` // 幂等查询的结果集缓存, 为空表示不开启
+	Masking     *Masking     `json:"masking"`      // 按用户对查询结果中的敏感数据脱敏, 为空表示不脱敏
+
+	QueryMiddlewares []string `json:"query_middlewares"` // 按顺序启用的自定义查询中间件, 需要编译到proxy中并注册
+}
+
+// transaction modes, namespace default can be overridden by session variable transaction_mode
+const (
+	// TransactionModeSingle only allow transaction in one slice
+	TransactionModeSingle = "single"
+	// TransactionModeMulti commit transaction of each slice sequentially, best effort
+	TransactionModeMulti = "multi"
+	// TransactionModeTwoPC commit transaction across slices with XA two-phase commit
+	TransactionModeTwoPC = "twopc"
+)
+
+// IsValidTransactionMode check if the transaction mode is supported
+func IsValidTransactionMode(mode string) bool {
+	switch mode {
+	case TransactionModeSingle, TransactionModeMulti, TransactionModeTwoPC:
+		return true
+	default:
+		return false
+	}
+}
+
+// ddl strategies of sharding table, namespace default can be overridden by session variable ddl_strategy
+const (
+	// DDLStrategyDirect execute DDL in each shard directly
+	DDLStrategyDirect = "direct"
+	// DDLStrategyGhost execute ALTER TABLE in each shard with gh-ost
+	DDLStrategyGhost = "gh-ost"
+	// DDLStrategyPtOsc execute ALTER TABLE in each shard with pt-online-schema-change
+	DDLStrategyPtOsc = "pt-osc"
+)
+
+// policies of statements routed to all sub tables without condition of sharding column
+const (
+	// FullScatterAllow execute the statement
+	FullScatterAllow = "allow"
+	// FullScatterWarn execute the statement, log and count it
+	FullScatterWarn = "warn"
+	// FullScatterReject reject the statement unless full_scan hint is specified
+	FullScatterReject = "reject"
+)
+
+// IsValidFullScatter check if the full scatter policy is supported
+func IsValidFullScatter(policy string) bool {
+	switch policy {
+	case FullScatterAllow, FullScatterWarn, FullScatterReject:
+		return true
+	default:
+		return false
+	}
+}
+
+// policies of UPDATE which modifies value of sharding column
+const (
+	// ShardKeyUpdateReject reject the statement
+	ShardKeyUpdateReject = "reject"
+	// ShardKeyUpdateRelocate delete rows from old shards and insert them into new shards in XA transaction
+	ShardKeyUpdateRelocate = "relocate"
+)
+
+// IsValidShardKeyUpdate check if the policy of updating sharding column is supported
+func IsValidShardKeyUpdate(policy string) bool {
+	switch policy {
+	case ShardKeyUpdateReject, ShardKeyUpdateRelocate:
+		return true
+	default:
+		return false
+	}
+}
+
+// read consistency of statements routed to slaves
+const (
+	// ReadConsistencyEventual read from slaves without waiting, writes of session may be invisible
+	ReadConsistencyEventual = "eventual"
+	// ReadConsistencySession wait until writes of session are applied in slave, or read from master if timeout
+	ReadConsistencySession = "session"
+)
+
+// IsValidReadConsistency check if the read consistency is supported
+func IsValidReadConsistency(consistency string) bool {
+	switch consistency {
+	case ReadConsistencyEventual, ReadConsistencySession:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsValidDDLStrategy check if the ddl strategy is supported
+func IsValidDDLStrategy(strategy string) bool {
+	switch strategy {
+	case DDLStrategyDirect, DDLStrategyGhost, DDLStrategyPtOsc:
+		return true
+	default:
+		return false
+	}
 }
 
 // Encode encode json
@@ -70,6 +193,74 @@ func (n *Namespace) Verify() error {
 		return err
 	}
 
+	if err := n.verifyMaxExecutionTime(); err != nil {
+		return err
+	}
+
+	if err := n.verifyConnectionLimits(); err != nil {
+		return err
+	}
+
+	if err := n.verifyTransactionMode(); err != nil {
+		return err
+	}
+
+	if err := n.verifyDDLStrategy(); err != nil {
+		return err
+	}
+
+	if err := n.verifyFullScatter(); err != nil {
+		return err
+	}
+
+	if err := n.verifyReadConsistency(); err != nil {
+		return err
+	}
+
+	if err := n.verifyShardKeyUpdate(); err != nil {
+		return err
+	}
+
+	if err := n.verifyMaxParallelism(); err != nil {
+		return err
+	}
+
+	if err := n.verifyMaxQueryMemory(); err != nil {
+		return err
+	}
+
+	if err := n.verifySchemaRefreshInterval(); err != nil {
+		return err
+	}
+
+	if err := n.RateLimit.verify(); err != nil {
+		return err
+	}
+
+	if err := n.Shadow.verify(n.Name); err != nil {
+		return err
+	}
+
+	if err := n.Encryption.verify(n.ShardRules); err != nil {
+		return err
+	}
+
+	if err := n.Tenant.verify(); err != nil {
+		return err
+	}
+
+	if err := n.ResultCache.verify(); err != nil {
+		return err
+	}
+
+	if err := n.Masking.verify(); err != nil {
+		return err
+	}
+
+	if err := n.verifyQueryMiddlewares(); err != nil {
+		return err
+	}
+
 	if err := n.verifyDBs(); err != nil {
 		return err
 	}
@@ -171,6 +362,109 @@ func (n *Namespace) isSlowSQLTimeValid() error {
 	return nil
 }
 
+func (n *Namespace) verifyMaxExecutionTime() error {
+	if n.MaxExecutionTime == "" {
+		return nil
+	}
+	if t, err := strconv.ParseInt(n.MaxExecutionTime, 10, 64); err != nil || t < 0 {
+		return errors.New("invalid max execution time")
+	}
+	return nil
+}
+
+// verifyQueryMiddlewares check names of middlewares are not empty or duplicated, middlewares are looked up when namespace is loaded
+func (n *Namespace) verifyQueryMiddlewares() error {
+	names := make(map[string]bool, len(n.QueryMiddlewares))
+	for _, name := range n.QueryMiddlewares {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("empty name of query middleware")
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate query middleware: %s", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+func (n *Namespace) verifyConnectionLimits() error {
+	if n.MaxConnections < 0 {
+		return fmt.Errorf("invalid max connections: %d", n.MaxConnections)
+	}
+	if n.ClientIdleTimeout == "" {
+		return nil
+	}
+	if t, err := strconv.ParseInt(n.ClientIdleTimeout, 10, 64); err != nil || t < 0 {
+		return errors.New("invalid client idle timeout")
+	}
+	return nil
+}
+
+func (n *Namespace) verifyMaxParallelism() error {
+	if n.MaxParallelism == "" {
+		return nil
+	}
+	if p, err := strconv.Atoi(n.MaxParallelism); err != nil || p < 0 {
+		return errors.New("invalid max parallelism")
+	}
+	return nil
+}
+
+func (n *Namespace) verifyMaxQueryMemory() error {
+	if n.MaxQueryMemory == "" {
+		return nil
+	}
+	if m, err := strconv.ParseInt(n.MaxQueryMemory, 10, 64); err != nil || m < 0 {
+		return errors.New("invalid max query memory")
+	}
+	return nil
+}
+
+func (n *Namespace) verifySchemaRefreshInterval() error {
+	if n.SchemaRefreshInterval == "" {
+		return nil
+	}
+	if i, err := strconv.Atoi(n.SchemaRefreshInterval); err != nil || i < 0 {
+		return errors.New("invalid schema refresh interval")
+	}
+	return nil
+}
+
+func (n *Namespace) verifyTransactionMode() error {
+	if n.TransactionMode == "" || IsValidTransactionMode(n.TransactionMode) {
+		return nil
+	}
+	return fmt.Errorf("invalid transaction mode: %s", n.TransactionMode)
+}
+
+func (n *Namespace) verifyDDLStrategy() error {
+	if n.DDLStrategy == "" || IsValidDDLStrategy(n.DDLStrategy) {
+		return nil
+	}
+	return fmt.Errorf("invalid ddl strategy: %s", n.DDLStrategy)
+}
+
+func (n *Namespace) verifyFullScatter() error {
+	if n.FullScatter == "" || IsValidFullScatter(n.FullScatter) {
+		return nil
+	}
+	return fmt.Errorf("invalid full scatter policy: %s", n.FullScatter)
+}
+
+func (n *Namespace) verifyShardKeyUpdate() error {
+	if n.ShardKeyUpdate == "" || IsValidShardKeyUpdate(n.ShardKeyUpdate) {
+		return nil
+	}
+	return fmt.Errorf("invalid shard key update policy: %s", n.ShardKeyUpdate)
+}
+
+func (n *Namespace) verifyReadConsistency() error {
+	if n.ReadConsistency == "" || IsValidReadConsistency(n.ReadConsistency) {
+		return nil
+	}
+	return fmt.Errorf("invalid read consistency: %s", n.ReadConsistency)
+}
+
 func (n *Namespace) verifyDBs() error {
 	// no logic database mode
 	if n.isDefaultPhyDBSEmpty() {
@@ -215,6 +509,9 @@ func (n *Namespace) verifyCharset() error {
 	if err := mysql.VerifyCharset(n.DefaultCharset, n.DefaultCollation); err != nil {
 		return fmt.Errorf("verify charset error: %v", err)
 	}
+	if n.BackendCharset != "" && !mysql.IsConvertibleCharset(n.BackendCharset) {
+		return fmt.Errorf("verify charset error: backend charset %s can not be converted", n.BackendCharset)
+	}
 	return nil
 }
 
@@ -321,7 +618,7 @@ func (n *Namespace) verifyShardRules() error {
 			return fmt.Errorf("LinkedRule cannot link to another LinkedRule")
 		}
 	}
-	return nil
+	return verifyBindingGroups(n.ShardRules)
 }
 
 // Decrypt decrypt user/password in namespace
@@ -0,0 +1,39 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"crypto/md5"
+	"fmt"
+)
+
+// NamespaceSyncState 从其他集群同步namespace的记录, 保存在目标集群中, 用于检测目标集群中的修改
+type NamespaceSyncState struct {
+	Name          string `json:"name"`
+	SourceCluster string `json:"source_cluster"`
+	Fingerprint   string `json:"fingerprint"` // 同步时namespace配置(解密后)的md5
+	SyncTime      int64  `json:"sync_time"`   // unix timestamp, second
+}
+
+// Encode encode namespace sync state
+func (s *NamespaceSyncState) Encode() []byte {
+	return JSONEncode(s)
+}
+
+// Fingerprint return md5 of namespace config, namespace should be decrypted
+// so that the fingerprint does not depend on the encrypt key
+func (n *Namespace) Fingerprint() string {
+	return fmt.Sprintf("%x", md5.Sum(n.Encode()))
+}
@@ -4,7 +4,7 @@
 // you may not use this file except in compliance with the License.
 // You may obtain a copy of the License at
 //
-//     http://www.apache.org/licenses/LICENSE-2.0
+//	http://www.apache.org/licenses/LICENSE-2.0
 //
 // Unless required by applicable law or agreed to in writing, software
 // distributed under the License is distributed on an "AS IS" BASIS,
@@ -16,7 +16,9 @@ package models
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func defaultNamespace() *Namespace {
@@ -115,6 +117,153 @@ func TestVerifyAllowDBS_Error(t *testing.T) {
 	}
 }
 
+func TestVerifyMaxExecutionTime(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{"0", true},
+		{"1000", true},
+		{"-1", false},
+		{"1s", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.MaxExecutionTime = test.value
+		err := n.verifyMaxExecutionTime()
+		if test.valid && err != nil {
+			t.Errorf("test verifyMaxExecutionTime failed, value: %s, %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifyMaxExecutionTime should fail but pass, value: %s", test.value)
+		}
+	}
+}
+
+func TestVerifyConnectionLimits(t *testing.T) {
+	tests := []struct {
+		maxConnections int64
+		idleTimeout    string
+		valid          bool
+	}{
+		{0, "", true},
+		{100, "600", true},
+		{-1, "", false},
+		{0, "-1", false},
+		{0, "10m", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.MaxConnections = test.maxConnections
+		n.ClientIdleTimeout = test.idleTimeout
+		err := n.verifyConnectionLimits()
+		if test.valid && err != nil {
+			t.Errorf("test verifyConnectionLimits failed, value: %d %s, %v", test.maxConnections, test.idleTimeout, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifyConnectionLimits should fail but pass, value: %d %s", test.maxConnections, test.idleTimeout)
+		}
+	}
+}
+
+func TestVerifyTransactionMode(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{TransactionModeSingle, true},
+		{TransactionModeMulti, true},
+		{TransactionModeTwoPC, true},
+		{"TWOPC", false},
+		{"xa", false},
+		{"2pc", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.TransactionMode = test.value
+		err := n.verifyTransactionMode()
+		if test.valid && err != nil {
+			t.Errorf("test verifyTransactionMode failed, value: %s, %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifyTransactionMode should fail but pass, value: %s", test.value)
+		}
+	}
+}
+
+func TestVerifyDDLStrategy(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{DDLStrategyDirect, true},
+		{DDLStrategyGhost, true},
+		{DDLStrategyPtOsc, true},
+		{"ghost", false},
+		{"pt-online-schema-change", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.DDLStrategy = test.value
+		err := n.verifyDDLStrategy()
+		if test.valid && err != nil {
+			t.Errorf("test verifyDDLStrategy failed, value: %s, %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifyDDLStrategy should fail but pass, value: %s", test.value)
+		}
+	}
+}
+
+func TestVerifyReadConsistency(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{ReadConsistencyEventual, true},
+		{ReadConsistencySession, true},
+		{"strong", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.ReadConsistency = test.value
+		err := n.verifyReadConsistency()
+		if test.valid && err != nil {
+			t.Errorf("test verifyReadConsistency failed, value: %s, %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifyReadConsistency should fail but pass, value: %s", test.value)
+		}
+	}
+}
+
+func TestVerifyShardKeyUpdate(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{ShardKeyUpdateReject, true},
+		{ShardKeyUpdateRelocate, true},
+		{"allow", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.ShardKeyUpdate = test.value
+		err := n.verifyShardKeyUpdate()
+		if test.valid && err != nil {
+			t.Errorf("test verifyShardKeyUpdate failed, value: %s, %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifyShardKeyUpdate should fail but pass, value: %s", test.value)
+		}
+	}
+}
+
 func TestVerifyUsers_Success(t *testing.T) {
 	n := defaultNamespace()
 	u1 := &User{UserName: "u1", Namespace: n.Name, Password: "pw1", RWFlag: ReadOnly, RWSplit: NoReadWriteSplit, OtherProperty: 0}
@@ -232,6 +381,22 @@ func TestVerifyCharset_Error(t *testing.T) {
 	}
 }
 
+func TestVerifyBackendCharset(t *testing.T) {
+	n := defaultNamespace()
+	for _, charset := range []string{"", "utf8mb4", "gbk"} {
+		n.BackendCharset = charset
+		if err := n.verifyCharset(); err != nil {
+			t.Errorf("test verifyCharset failed, backend charset: %s, %v", charset, err)
+		}
+	}
+	for _, charset := range []string{"test", "ucs2"} {
+		n.BackendCharset = charset
+		if err := n.verifyCharset(); err == nil {
+			t.Errorf("test verifyCharset should fail but pass, backend charset: %s", charset)
+		}
+	}
+}
+
 func TestVerifySlices_Success(t *testing.T) {
 	n := defaultNamespace()
 	var slice1 = &Slice{Name: "slice1", UserName: "user", Password: "", Master: "1.1.1.1:1", Slaves: []string{"1.1.1.1:2"}, Capacity: 1, MaxCapacity: 1, IdleTimeout: 100}
@@ -297,10 +462,13 @@ func TestVerifyShardRules_Success(t *testing.T) {
 		&Shard{DB: "db_ks", Table: "tbl_ks_user_child", Type: "linked", Key: "user_id", ParentTable: "tbl_ks"},
 		&Shard{DB: "db_ks", Table: "tbl_ks_global_one", Type: "global", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
 		&Shard{DB: "db_ks", Table: "tbl_ks_global_two", Type: "global", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		&Shard{DB: "db_ks", Table: "tbl_ks_broadcast", Type: "broadcast", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
 		&Shard{DB: "db_ks", Table: "tbl_ks_range", Type: "range", Key: "id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}, TableRowLimit: 100},
 		&Shard{DB: "db_ks", Table: "tbl_ks_year", Type: "date_year", Key: "create_time", Slices: []string{"slice-0", "slice-1"}, DateRange: []string{"2014-2017", "2018-2019"}},
 		&Shard{DB: "db_ks", Table: "tbl_ks_month", Type: "date_month", Key: "create_time", Slices: []string{"slice-0", "slice-1"}, DateRange: []string{"201405-201406", "201408-201409"}},
 		&Shard{DB: "db_ks", Table: "tbl_ks_day", Type: "date_day", Key: "create_time", Slices: []string{"slice-0", "slice-1"}, DateRange: []string{"20140901-20140905", "20140907-20140908"}},
+		&Shard{DB: "db_ks", Table: "tbl_ks_week", Type: "date_week", Key: "create_time", Slices: []string{"slice-0", "slice-1"}, DateRange: []string{"201451-201452", "201501-"}, FutureTables: 4},
+		&Shard{DB: "db_ks", Table: "tbl_ks_range_boundaries", Type: "range", Key: "id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}, RangeBoundaries: []int64{100, 1000, 10000}},
 		&Shard{DB: "db_mycat", Table: "tbl_mycat", Type: "mycat_mod", Key: "id", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}, Databases: []string{"db_mycat_[0-3]"}},
 		&Shard{DB: "db_mycat", Table: "tbl_mycat_child", Type: "linked", ParentTable: "tbl_mycat", Key: "id"},
 		&Shard{DB: "db_mycat", Table: "tbl_mycat_user_child", Type: "linked", ParentTable: "tbl_mycat", Key: "user_id"},
@@ -499,6 +667,43 @@ func TestVerifyShardRules_Error_ShardYear(t *testing.T) {
 	}
 }
 
+func TestVerifyShardRules_Error_ShardWeek(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice1"}, &Slice{Name: "slice2"}}
+	// week out of range
+	nf.ShardRules = []*Shard{&Shard{Type: ShardWeek, DateRange: []string{"201901-201953"}, Slices: []string{"slice1"}}}
+	if err := nf.verifyShardRules(); err == nil {
+		t.Errorf("test verifyShardRules should fail but pass, slices: %s, shardRule: %s", JSONEncode(nf.Slices), JSONEncode(nf.ShardRules))
+	}
+	// date range overlapped
+	nf.ShardRules = []*Shard{&Shard{Type: ShardWeek, DateRange: []string{"201905-201910", "201910"}, Slices: []string{"slice1", "slice2"}}}
+	if err := nf.verifyShardRules(); err == nil {
+		t.Errorf("test verifyShardRules should fail but pass, slices: %s, shardRule: %s", JSONEncode(nf.Slices), JSONEncode(nf.ShardRules))
+	}
+}
+
+func TestExpandDateRange(t *testing.T) {
+	now := time.Date(2020, 12, 30, 10, 0, 0, 0, time.Local)
+	tests := []struct {
+		ruleType  string
+		dateRange []string
+		future    int
+		expect    []string
+	}{
+		{ShardYear, []string{"2018-2019", "2020-"}, 1, []string{"2018-2019", "2020-2021"}},
+		{ShardMonth, []string{"202001-"}, 2, []string{"202001-202102"}},
+		{ShardWeek, []string{"202050-"}, 1, []string{"202050-202101"}},
+		{ShardDay, []string{"20201201-"}, 3, []string{"20201201-20210102"}},
+		{ShardDay, []string{"20201201-20201231"}, 3, []string{"20201201-20201231"}},
+	}
+	for _, test := range tests {
+		actual := ExpandDateRange(test.ruleType, test.dateRange, test.future, now)
+		if !reflect.DeepEqual(actual, test.expect) {
+			t.Errorf("expand date range %v of %s error, expect: %v, actual: %v", test.dateRange, test.ruleType, test.expect, actual)
+		}
+	}
+}
+
 func TestVerifyShardRules_Error_ShardMycatMod(t *testing.T) {
 	if err := testVerifyShardRules_Error_ShardMycatMod(ShardMycatMod); err != nil {
 		t.Error(err)
@@ -678,6 +883,30 @@ func TestVerifyShardRules_Error_ShardGlobal(t *testing.T) {
 	}
 }
 
+func TestVerifyShardRules_BindingGroup(t *testing.T) {
+	nf := defaultNamespace()
+	nf.Slices = []*Slice{&Slice{Name: "slice-0"}, &Slice{Name: "slice-1"}}
+	nf.ShardRules = []*Shard{
+		&Shard{DB: "db_ks", Table: "tbl_order", Type: ShardMod, Key: "id", BindingGroup: "order", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+		&Shard{DB: "db_ks", Table: "tbl_order_item", Type: ShardMod, Key: "order_id", BindingGroup: "order", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}},
+	}
+	if err := nf.verifyShardRules(); err != nil {
+		t.Errorf("test verifyShardRules failed, %v", err)
+	}
+
+	// locations are different
+	nf.ShardRules[1].Locations = []int{1, 3}
+	if err := nf.verifyShardRules(); err == nil {
+		t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+	}
+
+	// global table can not be bound
+	nf.ShardRules[1] = &Shard{DB: "db_ks", Table: "tbl_order_item", Type: ShardGlobal, BindingGroup: "order", Locations: []int{2, 2}, Slices: []string{"slice-0", "slice-1"}}
+	if err := nf.verifyShardRules(); err == nil {
+		t.Errorf("test verifyShardRules should fail but pass, shardRule: %s", JSONEncode(nf.ShardRules))
+	}
+}
+
 func TestNamespace_Verify(t *testing.T) {
 	nsStr := `
 {
@@ -980,3 +1209,156 @@ func TestNamespace_Verify(t *testing.T) {
 		t.Errorf("namespace verify failed, err: %v", err)
 	}
 }
+
+func TestVerifyMaxParallelism(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{"0", true},
+		{"4", true},
+		{"-1", false},
+		{"abc", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.MaxParallelism = test.value
+		err := n.verifyMaxParallelism()
+		if test.valid && err != nil {
+			t.Errorf("test verifyMaxParallelism failed, value: %s, %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifyMaxParallelism should fail but pass, value: %s", test.value)
+		}
+	}
+}
+
+func TestVerifyMaxQueryMemory(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{"0", true},
+		{"67108864", true},
+		{"-1", false},
+		{"64M", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.MaxQueryMemory = test.value
+		err := n.verifyMaxQueryMemory()
+		if test.valid && err != nil {
+			t.Errorf("test verifyMaxQueryMemory failed, value: %s, %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifyMaxQueryMemory should fail but pass, value: %s", test.value)
+		}
+	}
+}
+
+func TestVerifySchemaRefreshInterval(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{"", true},
+		{"0", true},
+		{"300", true},
+		{"-1", false},
+		{"5m", false},
+	}
+	for _, test := range tests {
+		n := defaultNamespace()
+		n.SchemaRefreshInterval = test.value
+		err := n.verifySchemaRefreshInterval()
+		if test.valid && err != nil {
+			t.Errorf("test verifySchemaRefreshInterval failed, value: %s, %v", test.value, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verifySchemaRefreshInterval should fail but pass, value: %s", test.value)
+		}
+	}
+}
+
+func TestVerifyEncryption(t *testing.T) {
+	key := "MTIzNGFiY2Q1Njc4ZWZnKg==" // 16 bytes
+	column := func(c string) *EncryptColumn {
+		return &EncryptColumn{DB: "db", Table: "tbl", Column: c, KeyID: "k1"}
+	}
+	rules := []*Shard{{DB: "db", Table: "tbl", Key: "id"}}
+	tests := []struct {
+		encryption *Encryption
+		valid      bool
+	}{
+		{nil, true},
+		{&Encryption{Keys: map[string]string{"k1": key}, Columns: []*EncryptColumn{column("phone")}}, true},
+		{&Encryption{KeyProvider: "kms", Columns: []*EncryptColumn{column("phone")}}, true},
+		{&Encryption{Keys: map[string]string{"k1": key}}, false},
+		{&Encryption{Keys: map[string]string{"k1": "abc"}, Columns: []*EncryptColumn{column("phone")}}, false},
+		{&Encryption{Keys: map[string]string{"k2": key}, Columns: []*EncryptColumn{column("phone")}}, false},
+		{&Encryption{Keys: map[string]string{"k1": key}, Columns: []*EncryptColumn{column("phone"), column("PHONE")}}, false},
+		{&Encryption{Keys: map[string]string{"k1": key}, Columns: []*EncryptColumn{column("id")}}, false},
+		{&Encryption{Keys: map[string]string{"k1": key}, Columns: []*EncryptColumn{{DB: "db", Table: "tbl", Column: "phone", KeyID: "k1", Mode: "ecb"}}}, false},
+	}
+	for i, test := range tests {
+		err := test.encryption.verify(rules)
+		if test.valid && err != nil {
+			t.Errorf("test verify encrypt failed, case: %d, %v", i, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verify encrypt should fail but pass, case: %d", i)
+		}
+	}
+}
+
+func TestVerifyTenant(t *testing.T) {
+	tests := []struct {
+		tenant *Tenant
+		valid  bool
+	}{
+		{nil, true},
+		{&Tenant{Tables: []string{"db.orders", "db.users"}}, true},
+		{&Tenant{}, false},
+		{&Tenant{Tables: []string{"orders"}}, false},
+		{&Tenant{Tables: []string{"db."}}, false},
+		{&Tenant{Tables: []string{"db.orders", "DB.orders"}}, false},
+	}
+	for i, test := range tests {
+		err := test.tenant.verify()
+		if test.valid && err != nil {
+			t.Errorf("test verify tenant failed, case: %d, %v", i, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verify tenant should fail but pass, case: %d", i)
+		}
+	}
+	if column := (&Tenant{}).GetColumn(); column != DefaultTenantColumn {
+		t.Errorf("default tenant column should be %s, got %s", DefaultTenantColumn, column)
+	}
+}
+
+func TestVerifyMasking(t *testing.T) {
+	tests := []struct {
+		masking *Masking
+		valid   bool
+	}{
+		{nil, true},
+		{&Masking{Rules: []*MaskRule{{Users: []string{"analyst"}, DB: "db", Table: "users", Column: "name"}, {Type: MaskTypePhone}}}, true},
+		{&Masking{}, false},
+		{&Masking{Rules: []*MaskRule{{DB: "db", Table: "users", Column: "name", Type: "hash"}}}, false},
+		{&Masking{Rules: []*MaskRule{{Column: "name"}}}, false},
+		{&Masking{Rules: []*MaskRule{{DB: "db", Type: MaskTypeEmail}}}, false},
+		{&Masking{Rules: []*MaskRule{{Type: MaskTypeFull}}}, false},
+	}
+	for i, test := range tests {
+		err := test.masking.verify()
+		if test.valid && err != nil {
+			t.Errorf("test verify masking failed, case: %d, %v", i, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("test verify masking should fail but pass, case: %d", i)
+		}
+	}
+}
@@ -52,11 +52,42 @@ type Proxy struct {
 	SlowSQLTime    int64  `yaml:"slow-sql_time"`
 	SessionTimeout int    `yaml:"session-timeout"`
 
+	// unix socket监听路径, 为空时不监听, unix socket连接的客户端地址视为127.0.0.1
+	ProxySocket string `yaml:"proxy-socket"`
+	// 发送PROXY protocol v1/v2头的四层负载均衡所在网段, 逗号分隔, *表示所有地址, 为空时不解析PROXY protocol头
+	ProxyProtocolNetworks string `yaml:"proxy-protocol-networks"`
+	// gRPC查询接口监听地址, 为空时不监听, 消息使用json编码
+	GRPCAddr string `yaml:"grpc-addr"`
+	// PostgreSQL协议监听地址, 为空时不监听, 只能执行只读语句
+	PGAddr string `yaml:"pg-addr"`
+
+	// 日志配置
+	LogLevel  string `yaml:"log-level"`  // debug/info/warn/error, 可通过admin接口按模块动态调整
+	LogFormat string `yaml:"log-format"` // color/plain/json
+
 	// 监控配置
 	StatsEnabled  string `yaml:"stats-enabled"`  // set true to enable stats
 	StatsInterval int    `yaml:"stats-interval"` // set stats interval of connect pool
 
+	// 审计日志配置, sink为file/syslog/kafka, 为空时不记录审计日志, 参数为url query格式
+	AuditSink       string `yaml:"audit-sink"`
+	AuditSinkParams string `yaml:"audit-sink-params"`
+	AuditBufferSize int    `yaml:"audit-buffer-size"` // 未写入sink的审计事件数上限, 超过时丢弃新的事件
+
+	// 链路追踪配置, OTLP gRPC collector地址, 为空时不上报span
+	TracingEndpoint    string  `yaml:"tracing-endpoint"`
+	TracingSampleRatio float64 `yaml:"tracing-sample-ratio"` // 根span的采样比例, 取值(0, 1], 0表示全部采样
+
 	EncryptKey string `ini:"encrypt-key"`
+
+	// caching_sha2_password完整认证使用的RSA私钥(PEM), 为空时启动时自动生成
+	CachingSha2PasswordPrivateKey string `yaml:"caching-sha2-password-private-key"`
+
+	// XA事务的恢复日志文件, 为空时使用当前目录下的xa_recovery.log
+	XALogPath string `yaml:"xa-log-path"`
+
+	// snowflake全局序列号的worker id, 集群内每个proxy必须不同, 取值[1, 1023], 为0时根据主机名和proxy-addr计算
+	SequenceWorkerID int64 `yaml:"sequence-worker-id"`
 }
 
 func DefaultProxy() *Proxy {
@@ -76,6 +107,8 @@ func DefaultProxy() *Proxy {
 		ProxyAddr:       "0.0.0.0:13306",
 		SlowSQLTime:     1000,
 		SessionTimeout:  3600,
+		LogLevel:        "info",
+		LogFormat:       "color",
 		StatsEnabled:    "false",
 		StatsInterval:   10,
 		EncryptKey:      "00000000000000000",
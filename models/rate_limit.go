@@ -0,0 +1,34 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "fmt"
+
+// RateLimit max queries per second of each proxy, 0 means no limit
+type RateLimit struct {
+	ReadQPS    int64 `json:"read_qps"`    // SELECT
+	WriteQPS   int64 `json:"write_qps"`   // INSERT, REPLACE, UPDATE, DELETE and DDL
+	ScatterQPS int64 `json:"scatter_qps"` // statements routed to more than one shard
+}
+
+func (r *RateLimit) verify() error {
+	if r == nil {
+		return nil
+	}
+	if r.ReadQPS < 0 || r.WriteQPS < 0 || r.ScatterQPS < 0 {
+		return fmt.Errorf("invalid rate limit, read_qps: %d, write_qps: %d, scatter_qps: %d", r.ReadQPS, r.WriteQPS, r.ScatterQPS)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultResultCacheCapacity default max bytes of cached results of a namespace in one proxy
+	DefaultResultCacheCapacity = 64 * 1024 * 1024
+	// DefaultResultCacheTTL default seconds a result is cached
+	DefaultResultCacheTTL = 60
+	// DefaultResultCacheMaxResultSize default max bytes of one cached result
+	DefaultResultCacheMaxResultSize = 1024 * 1024
+)
+
+// ResultCache cache results of idempotent SELECT in proxy. SELECT is cached if it has result_cache hint,
+// or matches fingerprint of a rule. Cached results of tables are invalidated by writes through the same proxy.
+type ResultCache struct {
+	Capacity      int64              `json:"capacity"`        // 单个proxy内缓存结果集的内存上限, 单位字节, 0表示64MB
+	TTL           int64              `json:"ttl"`             // 默认缓存时间, 单位秒, 0表示60秒
+	MaxResultSize int64              `json:"max_result_size"` // 单个结果集的大小上限, 超过时不缓存, 单位字节, 0表示1MB
+	Rules         []*ResultCacheRule `json:"rules"`           // 不需要hint也缓存的语句
+}
+
+// ResultCacheRule cache SELECT with the same fingerprint as SQL of the rule
+type ResultCacheRule struct {
+	SQL string `json:"sql"` // 缓存与该语句指纹相同的SELECT, 如select * from t where id = 1匹配所有按id查询t的语句
+	TTL int64  `json:"ttl"` // 缓存时间, 单位秒, 0表示使用默认缓存时间
+}
+
+func (c *ResultCache) verify() error {
+	if c == nil {
+		return nil
+	}
+	if c.Capacity < 0 || c.TTL < 0 || c.MaxResultSize < 0 {
+		return fmt.Errorf("capacity, ttl and max_result_size of result cache should be >= 0")
+	}
+	for _, rule := range c.Rules {
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(rule.SQL)), "select") {
+			return fmt.Errorf("invalid sql of result cache rule: %s, only select is supported", rule.SQL)
+		}
+		if rule.TTL < 0 {
+			return fmt.Errorf("invalid ttl of result cache rule: %d", rule.TTL)
+		}
+	}
+	return nil
+}
@@ -14,13 +14,23 @@
 
 package models
 
+// types of global sequence
+const (
+	GlobalSequenceMycat     = "mycat"
+	GlobalSequenceSnowflake = "snowflake"
+	GlobalSequenceEtcd      = "etcd"
+)
+
 // GlobalSequence means source of global sequences with different types
 type GlobalSequence struct {
 	DB        string `json:"db"`
 	Table     string `json:"table"`
-	Type      string `json:"type"`       // 全局序列号类型,目前只兼容mycat的数据库方式
+	Type      string `json:"type"`       // 全局序列号类型: mycat(默认), snowflake, etcd
 	SliceName string `json:"slice_name"` // 对应sequence表所在的分片，默认都在0号片
 	PKName    string `json:"pk_name"`    // 全局序列号字段名称
+
+	// etcd类型每次从etcd分配的序列号个数, 默认1000
+	Step int64 `json:"step"`
 }
 
 // Encode means encode for easy use
@@ -0,0 +1,37 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "fmt"
+
+// Shadow replay sampled queries in shadow namespace asynchronously, e.g. a namespace with new shard layout or new MySQL version
+type Shadow struct {
+	Namespace string `json:"namespace"` // shadow namespace in the same cluster, it must have users with the same names
+	Percent   int    `json:"percent"`   // percentage of queries replayed, 0 means no query
+	Write     bool   `json:"write"`     // replay INSERT, REPLACE, UPDATE and DELETE too, only SELECT is replayed by default
+}
+
+func (s *Shadow) verify(name string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Namespace == "" || s.Namespace == name {
+		return fmt.Errorf("invalid shadow namespace: %s", s.Namespace)
+	}
+	if s.Percent < 0 || s.Percent > 100 {
+		return fmt.Errorf("invalid shadow percent: %d", s.Percent)
+	}
+	return nil
+}
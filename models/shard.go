@@ -25,6 +25,7 @@ import (
 const (
 	ShardDefault         = "default"
 	ShardGlobal          = "global"
+	ShardBroadcast       = "broadcast"
 	ShardLinked          = "linked"
 	ShardMod             = "mod"
 	ShardHash            = "hash"
@@ -32,6 +33,9 @@ const (
 	ShardYear            = "date_year"
 	ShardMonth           = "date_month"
 	ShardDay             = "date_day"
+	ShardWeek            = "date_week"
+	ShardExpression      = "expression"
+	ShardPlugin          = "plugin"
 	ShardMycatMod        = "mycat_mod"
 	ShardMycatLong       = "mycat_long"
 	ShardMycatString     = "mycat_string"
@@ -64,9 +68,32 @@ type Shard struct {
 	DateRange     []string `json:"date_range"`
 	TableRowLimit int      `json:"table_row_limit"`
 
+	// tables in the same binding group of a db must have the same sharding rule except the sharding key,
+	// joins between them are routed to each sub table instead of across sub tables
+	BindingGroup string `json:"binding_group"`
+
+	// used in date shard, if the last date range has no end (e.g. 202401-),
+	// sub tables are generated until future_tables periods after the current date
+	FutureTables int `json:"future_tables"`
+
+	// used in range shard, boundaries of sub tables in ascending order,
+	// sub table i contains keys in [boundaries[i-1], boundaries[i]), table_row_limit is ignored if set
+	RangeBoundaries []int64 `json:"range_boundaries"`
+
+	// used in expression shard, result of the expression is the sub table index, e.g. crc32(user_id) % 8
+	Expression string `json:"expression"`
+
+	// used in plugin shard, name of registered sharding strategy or path of go plugin file, and its params
+	Plugin       string            `json:"plugin"`
+	PluginParams map[string]string `json:"plugin_params"`
+
 	// only used in mycat logic database (schema)
 	Databases []string `json:"databases"`
 
+	// used when migrating the table from default slice to the sharding layout, reads are routed to the unsharded
+	// table in default slice, writes are executed in it and then in sub tables, mismatches of the two writes are counted
+	DualWrite bool `json:"dual_write"`
+
 	// used in mycat partition long shard and partition string shard
 	PartitionCount  string `json:"partition_count"`
 	PartitionLength string `json:"partition_length"`
@@ -86,6 +113,9 @@ type Shard struct {
 }
 
 func (s *Shard) verify() error {
+	if s.DualWrite && (s.Type == ShardGlobal || s.Type == ShardBroadcast || s.Type == ShardLinked) {
+		return fmt.Errorf("dual_write is not supported by %s table %s, linked tables follow their parent", s.Type, s.Table)
+	}
 	if err := s.verifyRuleSliceInfos(); err != nil {
 		return err
 	}
@@ -16,8 +16,10 @@ package models
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/XiaoMi/Gaea/core/errors"
 )
@@ -29,12 +31,50 @@ var ruleVerifyFuncMapping = map[string]func(shard *Shard) error{
 	ShardDay:             verifyDayRule,
 	ShardMonth:           verifyMonthRule,
 	ShardYear:            verifyYearRule,
+	ShardWeek:            verifyWeekRule,
+	ShardExpression:      verifyExpressionRule,
+	ShardPlugin:          verifyPluginRule,
 	ShardMycatMod:        verifyMycatModRule,
 	ShardMycatLong:       verifyMycatLongRule,
 	ShardMycatString:     verifyMycatStringRule,
 	ShardMycatMURMUR:     verifyMycatMURMURRule,
 	ShardMycatPaddingMod: verifyMycatPaddingRule,
 	ShardGlobal:          verifyGlobalRule,
+	ShardBroadcast:       verifyGlobalRule,
+}
+
+// verifyBindingGroups check tables in the same binding group have the same sharding rule,
+// so rows with the same sharding key are in sub tables with the same index.
+func verifyBindingGroups(shards []*Shard) error {
+	groups := make(map[string]*Shard) // key: db.binding_group, value: first table of the group
+	for _, s := range shards {
+		if s.BindingGroup == "" {
+			continue
+		}
+		switch s.Type {
+		case ShardDefault, ShardLinked, ShardGlobal, ShardBroadcast:
+			return fmt.Errorf("table %s of type %s can not be in binding group", s.Table, s.Type)
+		}
+
+		key := s.DB + "." + s.BindingGroup
+		first, ok := groups[key]
+		if !ok {
+			groups[key] = s
+			continue
+		}
+		if !reflect.DeepEqual(bindingRule(first), bindingRule(s)) {
+			return fmt.Errorf("table %s and %s in binding group %s have different sharding rules", first.Table, s.Table, s.BindingGroup)
+		}
+	}
+	return nil
+}
+
+// bindingRule return the shard without table name and sharding key, which must be the same in a binding group
+func bindingRule(s *Shard) Shard {
+	r := *s
+	r.Table = ""
+	r.Key = ""
+	return r
 }
 
 func verifyHashRule(s *Shard) error {
@@ -57,7 +97,12 @@ func verifyRangeRule(s *Shard) error {
 		return err
 	}
 
-	tableCount, err := ParseNumSharding(s.Locations, s.TableRowLimit)
+	var tableCount []NumKeyRange
+	if len(s.RangeBoundaries) != 0 {
+		tableCount, err = ParseNumRangeBoundaries(s.RangeBoundaries)
+	} else {
+		tableCount, err = ParseNumSharding(s.Locations, s.TableRowLimit)
+	}
 	if err != nil {
 		return err
 	}
@@ -67,15 +112,37 @@ func verifyRangeRule(s *Shard) error {
 	return nil
 }
 
+func verifyExpressionRule(s *Shard) error {
+	if _, err := verifyHashRuleSliceInfos(s.Locations, s.Slices); err != nil {
+		return err
+	}
+	// the expression is parsed when router is created
+	if strings.TrimSpace(s.Expression) == "" {
+		return fmt.Errorf("expression of shard rule is empty")
+	}
+	return nil
+}
+
+func verifyPluginRule(s *Shard) error {
+	if _, err := verifyHashRuleSliceInfos(s.Locations, s.Slices); err != nil {
+		return err
+	}
+	// the plugin is loaded when router is created
+	if strings.TrimSpace(s.Plugin) == "" {
+		return fmt.Errorf("plugin of shard rule is empty")
+	}
+	return nil
+}
+
 func verifyDayRule(s *Shard) error {
-	if err := verifyDateDayRuleSliceInfos(s.DateRange, s.Slices); err != nil {
+	if err := verifyDateDayRuleSliceInfos(ExpandDateRange(s.Type, s.DateRange, s.FutureTables, time.Now()), s.Slices); err != nil {
 		return err
 	}
 	return nil
 }
 
 func verifyMonthRule(s *Shard) error {
-	err := verifyDateMonthRuleSliceInfos(s.DateRange, s.Slices)
+	err := verifyDateMonthRuleSliceInfos(ExpandDateRange(s.Type, s.DateRange, s.FutureTables, time.Now()), s.Slices)
 	if err != nil {
 		return err
 	}
@@ -83,7 +150,15 @@ func verifyMonthRule(s *Shard) error {
 }
 
 func verifyYearRule(s *Shard) error {
-	err := verifyDateYearRuleSliceInfos(s.DateRange, s.Slices)
+	err := verifyDateYearRuleSliceInfos(ExpandDateRange(s.Type, s.DateRange, s.FutureTables, time.Now()), s.Slices)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func verifyWeekRule(s *Shard) error {
+	err := verifyDateWeekRuleSliceInfos(ExpandDateRange(s.Type, s.DateRange, s.FutureTables, time.Now()), s.Slices)
 	if err != nil {
 		return err
 	}
@@ -242,6 +317,24 @@ func verifyDateYearRuleSliceInfos(dateRange []string, slices []string) error {
 	return nil
 }
 
+func verifyDateWeekRuleSliceInfos(dateRange []string, slices []string) error {
+	var subTableIndexs []int
+	if len(dateRange) != len(slices) {
+		return errors.ErrDateRangeCount
+	}
+	for i := 0; i < len(dateRange); i++ {
+		weekNumbers, err := ParseWeekRange(dateRange[i])
+		if err != nil {
+			return err
+		}
+		if len(subTableIndexs) > 0 && weekNumbers[0] <= subTableIndexs[len(subTableIndexs)-1] {
+			return errors.ErrDateRangeOverlap
+		}
+		subTableIndexs = append(subTableIndexs, weekNumbers...)
+	}
+	return nil
+}
+
 func verifyGlobalTableRuleSliceInfos(locations []int, slices []string, databases []string) error {
 	tableToSlice, err := verifyHashRuleSliceInfos(locations, slices)
 	if err != nil {
@@ -28,6 +28,16 @@ type Slice struct {
 	Capacity    int `json:"capacity"`     // connection pool capacity
 	MaxCapacity int `json:"max_capacity"` // max connection pool capacity
 	IdleTimeout int `json:"idle_timeout"` // close backend direct connection after idle_timeout,unit: seconds
+
+	MinIdle      int  `json:"min_idle"`       // connections created in background after the pool is opened
+	MaxLifetime  int  `json:"max_lifetime"`   // close backend direct connection after max_lifetime since connected, unit: seconds, 0 means no limit
+	PingOnBorrow bool `json:"ping_on_borrow"` // ping backend direct connection before using it, reconnect if ping fails
+
+	Compress      bool `json:"compress"`       // use compressed protocol between proxy and backend mysql
+	ProxyProtocol bool `json:"proxy_protocol"` // send PROXY protocol v2 header when connecting to backend mysql by tcp
+
+	CircuitBreakerFailures      int `json:"circuit_breaker_failures"`       // isolate backend after consecutive failures, 0 means disabled
+	CircuitBreakerProbeInterval int `json:"circuit_breaker_probe_interval"` // interval of probing isolated backend, unit: seconds, default 5
 }
 
 func (s *Slice) verify() error {
@@ -57,5 +67,17 @@ func (s *Slice) verify() error {
 		return errors.New("max connection pool capactiy should be > 0")
 	}
 
+	if s.MinIdle < 0 || s.MinIdle > s.Capacity {
+		return errors.New("min idle connections should be in [0, capacity]")
+	}
+
+	if s.MaxLifetime < 0 {
+		return errors.New("max lifetime of connection should be >= 0")
+	}
+
+	if s.CircuitBreakerFailures < 0 || s.CircuitBreakerProbeInterval < 0 {
+		return errors.New("circuit breaker failures and probe interval should be >= 0")
+	}
+
 	return nil
 }
@@ -0,0 +1,52 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+)
+
+func TestSliceVerifyPoolConfig(t *testing.T) {
+	tests := []struct {
+		minIdle     int
+		maxLifetime int
+		valid       bool
+	}{
+		{0, 0, true},
+		{8, 3600, true},
+		{16, 0, true},
+		{17, 0, false},
+		{-1, 0, false},
+		{0, -1, false},
+	}
+	for _, test := range tests {
+		s := &Slice{
+			Name:        "slice-0",
+			UserName:    "root",
+			Master:      "127.0.0.1:3306",
+			Capacity:    16,
+			MaxCapacity: 32,
+			MinIdle:     test.minIdle,
+			MaxLifetime: test.maxLifetime,
+		}
+		err := s.verify()
+		if test.valid && err != nil {
+			t.Errorf("verify slice failed, min_idle: %d, max_lifetime: %d, err: %v", test.minIdle, test.maxLifetime, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("verify slice should fail, min_idle: %d, max_lifetime: %d", test.minIdle, test.maxLifetime)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultTenantColumn column of tenant id if column of tenant config is not set
+const DefaultTenantColumn = "tenant_id"
+
+// Tenant row level isolation of multi-tenant tables, condition of tenant column is injected into statements of the tables.
+// Tenant id of session is tenant_id of user, or session variable tenant_id if the user has no tenant_id.
+type Tenant struct {
+	Column string   `json:"column"` // 租户id列名, 为空表示tenant_id
+	Tables []string `json:"tables"` // 多租户表, 格式为db.table
+}
+
+// GetColumn return column of tenant id
+func (t *Tenant) GetColumn() string {
+	if t.Column == "" {
+		return DefaultTenantColumn
+	}
+	return t.Column
+}
+
+func (t *Tenant) verify() error {
+	if t == nil {
+		return nil
+	}
+	if len(t.Tables) == 0 {
+		return fmt.Errorf("no multi-tenant table")
+	}
+	tables := make(map[string]bool, len(t.Tables))
+	for _, table := range t.Tables {
+		parts := strings.Split(table, ".")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid multi-tenant table: %s, format should be db.table", table)
+		}
+		name := strings.ToLower(table)
+		if tables[name] {
+			return fmt.Errorf("duplicate multi-tenant table: %s", table)
+		}
+		tables[name] = true
+	}
+	return nil
+}
@@ -41,12 +41,17 @@ const (
 
 // User meand user struct
 type User struct {
-	UserName      string `json:"user_name"`
-	Password      string `json:"password"`
-	Namespace     string `json:"namespace"`
-	RWFlag        int    `json:"rw_flag"`        //1: 只读 2:读写
-	RWSplit       int    `json:"rw_split"`       //0: 不采用读写分离 1:读写分离
-	OtherProperty int    `json:"other_property"` // 1:统计用户
+	UserName       string `json:"user_name"`
+	Password       string `json:"password"`
+	Namespace      string `json:"namespace"`
+	RWFlag         int    `json:"rw_flag"`         //1: 只读 2:读写
+	RWSplit        int    `json:"rw_split"`        //0: 不采用读写分离 1:读写分离
+	OtherProperty  int    `json:"other_property"`  // 1:统计用户
+	MaxConnections int64  `json:"max_connections"` // 集群范围内的最大连接数, 0表示不限制
+	Admin          bool   `json:"admin"`           // 是否可以使用admin库管理proxy
+	TenantID       string `json:"tenant_id"`       // 多租户表的租户id, 为空时使用会话变量tenant_id
+
+	RateLimit *RateLimit `json:"rate_limit"` // 单个proxy内该用户的每秒查询数上限, 为空表示不限制
 }
 
 func (p *User) verify() error {
@@ -77,5 +82,13 @@ func (p *User) verify() error {
 		return fmt.Errorf("invalid other property, user: %s, %d", p.UserName, p.OtherProperty)
 	}
 
+	if p.MaxConnections < 0 {
+		return fmt.Errorf("invalid max connections, user: %s, %d", p.UserName, p.MaxConnections)
+	}
+
+	if err := p.RateLimit.verify(); err != nil {
+		return fmt.Errorf("user: %s, %v", p.UserName, err)
+	}
+
 	return nil
 }
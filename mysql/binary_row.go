@@ -0,0 +1,288 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// binary protocol resultset row:
+// https://dev.mysql.com/doc/internals/en/binary-protocol-resultset-row.html
+
+// the NULL bitmap of binary protocol resultset row has an offset of 2 bits
+const binaryRowNullBitmapOffset = 2
+
+// AppendBinaryRow encode one row of binary protocol resultset, including the packet header and NULL bitmap
+func AppendBinaryRow(data []byte, fields []*Field, values []interface{}) ([]byte, error) {
+	if len(values) != len(fields) {
+		return data, fmt.Errorf("row has %d columns not equal %d", len(values), len(fields))
+	}
+
+	data = append(data, OKHeader)
+	bitmapPos := len(data)
+	bitmapLen := (len(fields) + 7 + binaryRowNullBitmapOffset) >> 3
+	data = append(data, make([]byte, bitmapLen)...)
+
+	for i, v := range values {
+		if v == nil {
+			bitPos := i + binaryRowNullBitmapOffset
+			data[bitmapPos+bitPos/8] |= 1 << uint(bitPos%8)
+			continue
+		}
+
+		var err error
+		data, err = AppendBinaryValue(data, fields[i].Type, v)
+		if err != nil {
+			return data, fmt.Errorf("encode column %d error: %v", i, err)
+		}
+	}
+	return data, nil
+}
+
+// AppendBinaryValue encode binary-type value of prepare binary protocol according to field type
+func AppendBinaryValue(data []byte, fieldType uint8, value interface{}) ([]byte, error) {
+	switch fieldType {
+	case TypeTiny, TypeShort, TypeYear, TypeInt24, TypeLong, TypeLonglong:
+		v, err := binaryIntValue(value)
+		if err != nil {
+			return data, err
+		}
+		switch fieldType {
+		case TypeTiny:
+			return append(data, byte(v)), nil
+		case TypeShort, TypeYear:
+			return AppendUint16(data, uint16(v)), nil
+		case TypeInt24, TypeLong:
+			return AppendUint32(data, uint32(v)), nil
+		default:
+			return AppendUint64(data, v), nil
+		}
+	case TypeFloat:
+		v, err := binaryFloatValue(value)
+		if err != nil {
+			return data, err
+		}
+		return AppendUint32(data, math.Float32bits(float32(v))), nil
+	case TypeDouble:
+		v, err := binaryFloatValue(value)
+		if err != nil {
+			return data, err
+		}
+		return AppendUint64(data, math.Float64bits(v)), nil
+	case TypeDate, TypeNewDate, TypeDatetime, TypeTimestamp:
+		s, err := binaryStringValue(value)
+		if err != nil {
+			return data, err
+		}
+		return appendBinaryDateTime(data, fieldType, s)
+	case TypeDuration:
+		s, err := binaryStringValue(value)
+		if err != nil {
+			return data, err
+		}
+		timeValue, err := stringToMysqlTime(s)
+		if err != nil {
+			return data, err
+		}
+		return append(data, mysqlTimeToBinaryResult(timeValue)...), nil
+	case TypeNull:
+		return data, nil
+	case TypeDecimal, TypeNewDecimal, TypeVarchar, TypeBit, TypeJSON, TypeEnum, TypeSet,
+		TypeTinyBlob, TypeMediumBlob, TypeLongBlob, TypeBlob, TypeVarString, TypeString, TypeGeometry:
+		b, err := binaryBytesValue(value)
+		if err != nil {
+			return data, err
+		}
+		return AppendLenEncStringBytes(data, b), nil
+	default:
+		return data, fmt.Errorf("not supported field type %d", fieldType)
+	}
+}
+
+func binaryIntValue(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case int8:
+		return uint64(v), nil
+	case int16:
+		return uint64(v), nil
+	case int32:
+		return uint64(v), nil
+	case int64:
+		return uint64(v), nil
+	case int:
+		return uint64(v), nil
+	case uint8:
+		return uint64(v), nil
+	case uint16:
+		return uint64(v), nil
+	case uint32:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case uint:
+		return uint64(v), nil
+	case float32:
+		return uint64(int64(v)), nil
+	case float64:
+		return uint64(int64(v)), nil
+	case string:
+		return parseBinaryIntValue(v)
+	case []byte:
+		return parseBinaryIntValue(hack.String(v))
+	default:
+		return 0, fmt.Errorf("invalid type %T for integer field", value)
+	}
+}
+
+func parseBinaryIntValue(s string) (uint64, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return uint64(i), nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func binaryFloatValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case []byte:
+		return strconv.ParseFloat(hack.String(v), 64)
+	default:
+		return 0, fmt.Errorf("invalid type %T for float field", value)
+	}
+}
+
+func binaryStringValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return hack.String(v), nil
+	default:
+		return "", fmt.Errorf("invalid type %T for time field", value)
+	}
+}
+
+func binaryBytesValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return hack.Slice(v), nil
+	case int8, int16, int32, int64, int:
+		i, _ := binaryIntValue(v)
+		return strconv.AppendInt(nil, int64(i), 10), nil
+	case uint8, uint16, uint32, uint64, uint:
+		i, _ := binaryIntValue(v)
+		return strconv.AppendUint(nil, i, 10), nil
+	case float32:
+		return strconv.AppendFloat(nil, float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'f', -1, 64), nil
+	case Decimal:
+		return hack.Slice(v.String()), nil
+	default:
+		return nil, fmt.Errorf("invalid type %T for string field", value)
+	}
+}
+
+// appendBinaryDateTime encode DATE, DATETIME and TIMESTAMP value in format
+// YYYY-MM-DD or YYYY-MM-DD hh:mm:ss[.ffffff], the length is 0, 4, 7 or 11 according to the value.
+// zero value like 0000-00-00 is valid in mysql, so it's parsed without time package.
+func appendBinaryDateTime(data []byte, fieldType uint8, s string) ([]byte, error) {
+	var year, month, day, hour, minute, second, microsecond int
+
+	datePart, timePart := s, ""
+	if i := strings.IndexByte(s, ' '); i != -1 {
+		datePart, timePart = s[:i], s[i+1:]
+	}
+
+	dateFields := strings.Split(datePart, "-")
+	if len(dateFields) != 3 {
+		return data, fmt.Errorf("invalid date time value %s", s)
+	}
+	if err := parseDateTimeFields(dateFields, &year, &month, &day); err != nil {
+		return data, fmt.Errorf("invalid date time value %s", s)
+	}
+
+	if timePart != "" && fieldType != TypeDate && fieldType != TypeNewDate {
+		fracPart := ""
+		if i := strings.IndexByte(timePart, '.'); i != -1 {
+			timePart, fracPart = timePart[:i], timePart[i+1:]
+		}
+		timeFields := strings.Split(timePart, ":")
+		if len(timeFields) != 3 {
+			return data, fmt.Errorf("invalid date time value %s", s)
+		}
+		if err := parseDateTimeFields(timeFields, &hour, &minute, &second); err != nil {
+			return data, fmt.Errorf("invalid date time value %s", s)
+		}
+		if fracPart != "" {
+			if len(fracPart) > 6 {
+				return data, fmt.Errorf("invalid date time value %s", s)
+			}
+			fracPart += strings.Repeat("0", 6-len(fracPart))
+			v, err := strconv.Atoi(fracPart)
+			if err != nil {
+				return data, fmt.Errorf("invalid date time value %s", s)
+			}
+			microsecond = v
+		}
+	}
+
+	switch {
+	case year == 0 && month == 0 && day == 0 && hour == 0 && minute == 0 && second == 0 && microsecond == 0:
+		return append(data, 0), nil
+	case hour == 0 && minute == 0 && second == 0 && microsecond == 0:
+		data = append(data, 4)
+		data = AppendUint16(data, uint16(year))
+		return append(data, byte(month), byte(day)), nil
+	case microsecond == 0:
+		data = append(data, 7)
+		data = AppendUint16(data, uint16(year))
+		return append(data, byte(month), byte(day), byte(hour), byte(minute), byte(second)), nil
+	default:
+		data = append(data, 11)
+		data = AppendUint16(data, uint16(year))
+		data = append(data, byte(month), byte(day), byte(hour), byte(minute), byte(second))
+		return AppendUint32(data, uint32(microsecond)), nil
+	}
+}
+
+func parseDateTimeFields(fields []string, values ...*int) error {
+	for i, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return err
+		}
+		*values[i] = v
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAppendBinaryValue(t *testing.T) {
+	tests := []struct {
+		fieldType uint8
+		value     interface{}
+		expect    []byte
+	}{
+		{TypeTiny, int64(-1), []byte{0xff}},
+		{TypeShort, "258", []byte{0x02, 0x01}},
+		{TypeLong, uint64(1), []byte{0x01, 0, 0, 0}},
+		{TypeLonglong, []byte("18446744073709551615"), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{TypeDouble, float64(1), []byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{TypeFloat, "1", []byte{0, 0, 0x80, 0x3f}},
+		{TypeNewDecimal, float64(2.5), []byte{3, '2', '.', '5'}},
+		{TypeEnum, []byte("ab"), []byte{2, 'a', 'b'}},
+		{TypeVarString, int64(12), []byte{2, '1', '2'}},
+		{TypeDate, "2020-01-02", []byte{4, 0xe4, 0x07, 1, 2}},
+		{TypeDate, "0000-00-00", []byte{0}},
+		{TypeDatetime, "2020-01-02 00:00:00", []byte{4, 0xe4, 0x07, 1, 2}},
+		{TypeDatetime, "2020-01-02 03:04:05", []byte{7, 0xe4, 0x07, 1, 2, 3, 4, 5}},
+		{TypeTimestamp, []byte("2020-01-02 03:04:05.1"), []byte{11, 0xe4, 0x07, 1, 2, 3, 4, 5, 0xa0, 0x86, 0x01, 0}},
+		{TypeDuration, "01:02:03", []byte{8, 0, 0, 0, 0, 0, 1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		data, err := AppendBinaryValue(nil, test.fieldType, test.value)
+		if err != nil {
+			t.Errorf("encode %v of type %d error: %v", test.value, test.fieldType, err)
+			continue
+		}
+		if !bytes.Equal(data, test.expect) {
+			t.Errorf("encode %v of type %d, expect: %v, got: %v", test.value, test.fieldType, test.expect, data)
+		}
+	}
+
+	if _, err := AppendBinaryValue(nil, TypeDatetime, "2020-01-02 03:04"); err == nil {
+		t.Errorf("invalid datetime should fail")
+	}
+}
+
+func TestAppendBinaryRow(t *testing.T) {
+	fields := []*Field{{Type: TypeLonglong}, {Type: TypeVarString}, {Type: TypeTiny}}
+	data, err := AppendBinaryRow(nil, fields, []interface{}{nil, "a", int64(1)})
+	if err != nil {
+		t.Fatalf("encode binary row error: %v", err)
+	}
+	// header, NULL bitmap with offset 2, "a", 1
+	expect := []byte{OKHeader, 0x04, 1, 'a', 1}
+	if !bytes.Equal(data, expect) {
+		t.Errorf("encode binary row, expect: %v, got: %v", expect, data)
+	}
+
+	values, err := RowData(data).ParseBinary(fields)
+	if err != nil {
+		t.Fatalf("parse binary row error: %v", err)
+	}
+	if values[0] != nil || string(values[1].([]byte)) != "a" || values[2].(int64) != 1 {
+		t.Errorf("parse binary row error: %v", values)
+	}
+
+	if _, err := AppendBinaryRow(nil, fields, []interface{}{int64(1)}); err == nil {
+		t.Errorf("column count mismatch should fail")
+	}
+}
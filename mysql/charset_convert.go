@@ -0,0 +1,226 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// charsetEncodings encodings of charsets which can be converted, nil means UTF-8 or charset without conversion
+var charsetEncodings = map[string]encoding.Encoding{
+	"utf8":    nil,
+	"utf8mb4": nil,
+	"ascii":   nil,
+	"binary":  nil,
+	"latin1":  charmap.Windows1252, // latin1 of MySQL is cp1252
+	"latin2":  charmap.ISO8859_2,
+	"greek":   charmap.ISO8859_7,
+	"hebrew":  charmap.ISO8859_8,
+	"latin5":  charmap.ISO8859_9,
+	"latin7":  charmap.ISO8859_13,
+	"cp1250":  charmap.Windows1250,
+	"cp1251":  charmap.Windows1251,
+	"cp1256":  charmap.Windows1256,
+	"cp1257":  charmap.Windows1257,
+	"cp866":   charmap.CodePage866,
+	"koi8r":   charmap.KOI8R,
+	"koi8u":   charmap.KOI8U,
+	"gbk":     simplifiedchinese.GBK,
+	"gb2312":  simplifiedchinese.GBK, // GBK is superset of gb2312
+	"gb18030": simplifiedchinese.GB18030,
+	"big5":    traditionalchinese.Big5,
+	"sjis":    japanese.ShiftJIS,
+	"ujis":    japanese.EUCJP,
+	"euckr":   korean.EUCKR,
+}
+
+// IsConvertibleCharset return whether strings of charset can be converted by CharsetConverter
+func IsConvertibleCharset(charset string) bool {
+	_, ok := charsetEncodings[charset]
+	return ok
+}
+
+// CharsetConverter converts strings between charset of client and charset of backend connections,
+// characters which can't be represented in the target charset are replaced with '?' like MySQL
+type CharsetConverter struct {
+	clientCharset  string
+	backendCharset string
+	client         encoding.Encoding
+	backend        encoding.Encoding
+}
+
+// NewCharsetConverter create converter between client charset and backend charset
+func NewCharsetConverter(clientCharset, backendCharset string) (*CharsetConverter, error) {
+	client, ok := charsetEncodings[clientCharset]
+	if !ok {
+		return nil, fmt.Errorf("charset %s can not be converted", clientCharset)
+	}
+	backend, ok := charsetEncodings[backendCharset]
+	if !ok {
+		return nil, fmt.Errorf("charset %s can not be converted", backendCharset)
+	}
+	return &CharsetConverter{
+		clientCharset:  clientCharset,
+		backendCharset: backendCharset,
+		client:         client,
+		backend:        backend,
+	}, nil
+}
+
+// ClientCharset return charset of client
+func (c *CharsetConverter) ClientCharset() string {
+	return c.clientCharset
+}
+
+// BackendCharset return charset of backend connections
+func (c *CharsetConverter) BackendCharset() string {
+	return c.backendCharset
+}
+
+// NeedConvert return whether strings need to be converted, e.g. utf8 and utf8mb4 don't
+func (c *CharsetConverter) NeedConvert() bool {
+	return c.client != c.backend
+}
+
+// ToBackend convert string from client charset to backend charset
+func (c *CharsetConverter) ToBackend(s []byte) []byte {
+	return convertCharset(s, c.client, c.backend)
+}
+
+// ToClient convert string from backend charset to client charset
+func (c *CharsetConverter) ToClient(s []byte) []byte {
+	return convertCharset(s, c.backend, c.client)
+}
+
+func convertCharset(s []byte, from, to encoding.Encoding) []byte {
+	if from == to || len(s) == 0 {
+		return s
+	}
+	if from != nil {
+		// invalid bytes are decoded as U+FFFD
+		if b, err := from.NewDecoder().Bytes(s); err == nil {
+			s = b
+		}
+	}
+	if to == nil {
+		return s
+	}
+	if b, err := to.NewEncoder().Bytes(s); err == nil {
+		return b
+	}
+
+	// encode characters one by one, characters not in charset are replaced with '?'
+	encoder := to.NewEncoder()
+	dst := make([]byte, 0, len(s))
+	for len(s) > 0 {
+		_, size := utf8.DecodeRune(s)
+		if b, err := encoder.Bytes(s[:size]); err == nil {
+			dst = append(dst, b...)
+		} else {
+			dst = append(dst, '?')
+		}
+		s = s[size:]
+	}
+	return dst
+}
+
+// ConvertFields return copies of fields whose names are converted to client charset,
+// charset of string columns is set to collation of client, columns of binary charset are not changed
+func (c *CharsetConverter) ConvertFields(fields []*Field, collation CollationID) []*Field {
+	converted := make([]*Field, len(fields))
+	for i, f := range fields {
+		nf := *f
+		nf.Data = nil
+		nf.Schema = c.ToClient(f.Schema)
+		nf.Table = c.ToClient(f.Table)
+		nf.OrgTable = c.ToClient(f.OrgTable)
+		nf.Name = c.ToClient(f.Name)
+		nf.OrgName = c.ToClient(f.OrgName)
+		if CollationID(f.Charset) != BinaryCollationID {
+			nf.Charset = uint16(collation)
+		}
+		converted[i] = &nf
+	}
+	return converted
+}
+
+// ConvertTextRow convert string columns of row in text format to client charset,
+// row may be trimmed and has less columns than fields
+func (c *CharsetConverter) ConvertTextRow(row RowData, fields []*Field) (RowData, error) {
+	dst := make(RowData, 0, len(row))
+	pos := 0
+	for i := 0; pos < len(row); i++ {
+		v, next, isNull, ok := ReadLenEncStringAsBytes(row, pos)
+		if !ok {
+			return nil, fmt.Errorf("ReadLenEncStringAsBytes in ConvertTextRow failed")
+		}
+		switch {
+		case isNull:
+			dst = append(dst, row[pos:next]...)
+		case i < len(fields) && CollationID(fields[i].Charset) != BinaryCollationID:
+			dst = AppendLenEncStringBytes(dst, c.ToClient(v))
+		default:
+			dst = append(dst, row[pos:next]...)
+		}
+		pos = next
+	}
+	return dst, nil
+}
+
+// ConvertResultset return copy of text resultset whose column definitions and strings are converted to client charset
+func (c *CharsetConverter) ConvertResultset(r *Resultset, collation CollationID) (*Resultset, error) {
+	nr := &Resultset{
+		Fields:     c.ConvertFields(r.Fields, collation),
+		FieldNames: make(map[string]int, len(r.FieldNames)),
+		Values:     make([][]interface{}, len(r.Values)),
+		RowDatas:   make([]RowData, len(r.RowDatas)),
+	}
+	for name, i := range r.FieldNames {
+		nr.FieldNames[string(c.ToClient([]byte(name)))] = i
+	}
+
+	for i, row := range r.Values {
+		values := make([]interface{}, len(row))
+		for j, v := range row {
+			if j < len(r.Fields) && CollationID(r.Fields[j].Charset) != BinaryCollationID {
+				switch s := v.(type) {
+				case string:
+					v = string(c.ToClient([]byte(s)))
+				case []byte:
+					v = c.ToClient(s)
+				}
+			}
+			values[j] = v
+		}
+		nr.Values[i] = values
+	}
+
+	for i, row := range r.RowDatas {
+		converted, err := c.ConvertTextRow(row, r.Fields)
+		if err != nil {
+			return nil, err
+		}
+		nr.RowDatas[i] = converted
+	}
+	return nr, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCharsetConvert(t *testing.T) {
+	gbk := []byte{0xc4, 0xe3, 0xba, 0xc3} // 你好 in gbk
+	tests := []struct {
+		client, backend string
+		clientStr       []byte
+		backendStr      []byte
+	}{
+		{"gbk", "utf8mb4", gbk, []byte("你好")},
+		{"utf8mb4", "gbk", []byte("你好"), gbk},
+		{"latin1", "utf8", []byte("caf\xe9"), []byte("café")},
+		{"gbk", "latin1", gbk, []byte("??")},
+		{"utf8", "utf8mb4", []byte("你好"), []byte("你好")},
+	}
+	for _, test := range tests {
+		c, err := NewCharsetConverter(test.client, test.backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := c.ToBackend(test.clientStr); !bytes.Equal(b, test.backendStr) {
+			t.Errorf("convert %q from %s to %s, expect %q, got %q", test.clientStr, test.client, test.backend, test.backendStr, b)
+		}
+	}
+
+	c, _ := NewCharsetConverter("gbk", "utf8mb4")
+	if b := c.ToClient([]byte("你好")); !bytes.Equal(b, gbk) {
+		t.Errorf("convert to client error, got %q", b)
+	}
+	if c, _ := NewCharsetConverter("utf8", "utf8mb4"); c.NeedConvert() {
+		t.Errorf("utf8 and utf8mb4 should not be converted")
+	}
+	if _, err := NewCharsetConverter("ucs2", "utf8mb4"); err == nil {
+		t.Errorf("expect error of charset which can not be converted")
+	}
+}
+
+func TestConvertResultset(t *testing.T) {
+	c, err := NewCharsetConverter("gbk", "utf8mb4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utf8CI := uint16(CollationIds["utf8mb4_general_ci"])
+	fields := []*Field{
+		{Name: []byte("名称"), Type: TypeVarString, Charset: utf8CI},
+		{Name: []byte("id"), Type: TypeLonglong, Charset: uint16(BinaryCollationID)},
+		{Name: []byte("data"), Type: TypeBlob, Charset: uint16(BinaryCollationID)},
+	}
+	var row RowData
+	row = AppendLenEncStringBytes(row, []byte("你好"))
+	row = append(row, 0xfb) // NULL
+	row = AppendLenEncStringBytes(row, []byte("你好"))
+	r := &Resultset{
+		Fields:     fields,
+		FieldNames: map[string]int{"名称": 0, "id": 1, "data": 2},
+		Values:     [][]interface{}{{"你好", nil, []byte("你好")}},
+		RowDatas:   []RowData{row},
+	}
+
+	nr, err := c.ConvertResultset(r, CollationIds["gbk_chinese_ci"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	gbk := string([]byte{0xc4, 0xe3, 0xba, 0xc3})
+	if string(nr.Fields[0].Name) != string([]byte{0xc3, 0xfb, 0xb3, 0xc6}) || nr.Fields[0].Charset != uint16(CollationIds["gbk_chinese_ci"]) {
+		t.Errorf("convert field error, got %q, %d", nr.Fields[0].Name, nr.Fields[0].Charset)
+	}
+	if nr.Fields[1].Charset != uint16(BinaryCollationID) || string(fields[0].Name) != "名称" {
+		t.Errorf("binary field or origin field should not be changed")
+	}
+	expect := [][]interface{}{{gbk, nil, []byte("你好")}}
+	if !reflect.DeepEqual(nr.Values, expect) {
+		t.Errorf("convert values error, expect %q, got %q", expect, nr.Values)
+	}
+	values, err := nr.RowDatas[0].ParseText(nr.Fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []interface{}{gbk, nil, []byte("你好")}) {
+		t.Errorf("convert row error, got %q", values)
+	}
+
+	// trimmed row has less columns than fields
+	trimmed, err := c.ConvertTextRow(AppendLenEncStringBytes(nil, []byte("你好")), fields)
+	if err != nil || string(trimmed) != "\x04"+gbk {
+		t.Errorf("convert trimmed row error, got %q, %v", trimmed, err)
+	}
+}
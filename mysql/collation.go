@@ -0,0 +1,276 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BinaryCollationID id of binary collation, which is the collation of numbers and binary strings
+const BinaryCollationID = CollationID(63)
+
+// Collator compares strings in the order of a MySQL collation.
+// Strings are equal in the collation if and only if their weight strings are equal,
+// and weight strings are ordered as the strings, so they can be used as keys of GROUP BY and DISTINCT.
+type Collator interface {
+	ID() CollationID
+	Name() string
+	// Compare compare s1 and s2, return -1, 0 or 1
+	Compare(s1, s2 []byte) int
+	// WeightString append weight string of s to dst
+	WeightString(dst, s []byte) []byte
+}
+
+type weightCollator struct {
+	id   CollationID
+	name string
+	// PAD SPACE collations ignore trailing spaces, NO PAD collations don't
+	padSpace bool
+	weights  func(dst, s []byte) []byte
+}
+
+func (c *weightCollator) ID() CollationID {
+	return c.id
+}
+
+func (c *weightCollator) Name() string {
+	return c.name
+}
+
+func (c *weightCollator) Compare(s1, s2 []byte) int {
+	return bytes.Compare(c.WeightString(nil, s1), c.WeightString(nil, s2))
+}
+
+func (c *weightCollator) WeightString(dst, s []byte) []byte {
+	if c.padSpace {
+		s = bytes.TrimRight(s, " ")
+	}
+	return c.weights(dst, s)
+}
+
+// collators of collations implemented with weight tables, other collations are handled by their names, see GetCollator
+var collators = map[CollationID]Collator{}
+
+func init() {
+	for _, c := range []*weightCollator{
+		{name: "binary", weights: binaryWeights},
+		{name: "utf8_general_ci", padSpace: true, weights: generalCIWeights},
+		{name: "utf8mb4_general_ci", padSpace: true, weights: generalCIWeights},
+		{name: "utf8_bin", padSpace: true, weights: binaryWeights},
+		{name: "utf8mb4_bin", padSpace: true, weights: binaryWeights},
+		{name: "utf8mb4_0900_ai_ci", weights: uca0900AICIWeights},
+		{name: "latin1_swedish_ci", padSpace: true, weights: latin1SwedishCIWeights},
+		{name: "latin1_bin", padSpace: true, weights: binaryWeights},
+	} {
+		c.id = CollationIds[c.name]
+		collators[c.id] = c
+	}
+}
+
+// GetCollator return collator of collation id. Collations without weight tables are handled by their names:
+// _bin and _cs collations compare bytes, other collations compare case-insensitively,
+// trailing spaces are ignored except NO PAD(_0900_) collations. Unknown collations compare bytes.
+func GetCollator(id CollationID) Collator {
+	if c, ok := collators[id]; ok {
+		return c
+	}
+	name, ok := Collations[id]
+	if !ok {
+		return collators[BinaryCollationID]
+	}
+
+	c := &weightCollator{id: id, name: name, padSpace: !strings.Contains(name, "_0900_")}
+	if strings.HasSuffix(name, "_bin") || strings.HasSuffix(name, "_cs") {
+		c.weights = binaryWeights
+	} else {
+		c.weights = upperCaseWeights
+	}
+	return c
+}
+
+// FieldCollator return collator of string column, ok is false if column is not string
+// or is binary string, ENUM or SET
+func FieldCollator(f *Field) (c Collator, ok bool) {
+	switch f.Type {
+	case TypeVarchar, TypeVarString, TypeString, TypeTinyBlob, TypeBlob, TypeMediumBlob, TypeLongBlob:
+	default:
+		return nil, false
+	}
+	if CollationID(f.Charset) == BinaryCollationID || uint(f.Flag)&(EnumFlag|SetFlag) != 0 {
+		return nil, false
+	}
+	return GetCollator(CollationID(f.Charset)), true
+}
+
+func binaryWeights(dst, s []byte) []byte {
+	return append(dst, s...)
+}
+
+// upperCaseWeights weight of each character is its upper case
+func upperCaseWeights(dst, s []byte) []byte {
+	for len(s) > 0 {
+		r, size := decodeRune(s)
+		dst = appendWeight24(dst, uint32(unicode.ToUpper(r)))
+		s = s[size:]
+	}
+	return dst
+}
+
+func appendWeight16(dst []byte, w uint32) []byte {
+	return append(dst, byte(w>>8), byte(w))
+}
+
+func appendWeight24(dst []byte, w uint32) []byte {
+	return append(dst, byte(w>>16), byte(w>>8), byte(w))
+}
+
+// latin1Letters base letters of U+00C0-U+00FF, '*' is expanded by latinExpansions, '.' is a letter itself
+const latin1Letters = "AAAAAA*CEEEEIIII.NOOOOO.OUUUUY.*" + "AAAAAA*CEEEEIIII.NOOOOO.OUUUUY.Y"
+
+// latinExtendedALetters base letters of U+0100-U+017F
+const latinExtendedALetters = "AAAAAACCCCCCCCDD" + "DDEEEEEEEEEEGGGG" + "GGGGHHHHIIIIIIII" + "II**JJKK.LLLLLLL" +
+	"LLLNNNNNNN..OOOO" + "OO**RRRRRRSSSSSS" + "SSTTTTTTUUUUUUUU" + "UUUUWWYYYZZZZZZS"
+
+var latinExpansions = map[rune]string{
+	'Æ': "AE", 'æ': "AE", 'ß': "SS", 'Ĳ': "IJ", 'ĳ': "IJ", 'Œ': "OE", 'œ': "OE",
+}
+
+// latinBaseLetter return upper case base letter of Latin character without accent,
+// 0 is returned if it's not a Latin letter with accent, '*' is returned if it should be expanded
+func latinBaseLetter(r rune) byte {
+	switch {
+	case r >= 0xc0 && r <= 0xff:
+		if c := latin1Letters[r-0xc0]; c != '.' {
+			return c
+		}
+	case r >= 0x100 && r <= 0x17f:
+		if c := latinExtendedALetters[r-0x100]; c != '.' {
+			return c
+		}
+	}
+	return 0
+}
+
+// generalCIWeights weights of utf8_general_ci and utf8mb4_general_ci. Each character of BMP is weighted
+// by its upper case with accents removed, ß is weighted as S, characters out of BMP are weighted as U+FFFD.
+func generalCIWeights(dst, s []byte) []byte {
+	for len(s) > 0 {
+		r, size := decodeRune(s)
+		s = s[size:]
+		switch {
+		case r > 0xffff:
+			r = 0xfffd
+		case r == 'ß':
+			r = 'S'
+		case r == 'µ':
+			r = 'Μ'
+		default:
+			if c := latinBaseLetter(r); c != 0 && c != '*' {
+				r = rune(c)
+			} else {
+				r = unicode.ToUpper(r)
+			}
+		}
+		dst = appendWeight16(dst, uint32(r))
+	}
+	return dst
+}
+
+// groups of primary weights of utf8mb4_0900_ai_ci, in the order of DUCET
+const (
+	ucaGroupVariable = iota + 1
+	ucaGroupDigit
+	ucaGroupLatin
+	ucaGroupOther
+)
+
+// uca0900AICIWeights weights of utf8mb4_0900_ai_ci, which is accent and case insensitive and NO PAD.
+// Only primary weights of DUCET are approximated: spaces, punctuations and symbols are sorted before digits,
+// digits before Latin letters and Latin letters before other characters, which are sorted by upper case.
+// Accents of Latin letters are removed, ligatures like æ and ß are expanded, combining marks and controls are ignored.
+func uca0900AICIWeights(dst, s []byte) []byte {
+	for len(s) > 0 {
+		r, size := decodeRune(s)
+		s = s[size:]
+
+		if r >= 0xff01 && r <= 0xff5e {
+			// full width forms are weighted as ASCII
+			r -= 0xff01 - 0x21
+		}
+		switch {
+		case unicode.Is(unicode.Mn, r) || (unicode.IsControl(r) && !unicode.IsSpace(r)):
+			continue
+		case r >= '0' && r <= '9':
+			dst = appendWeight24(dst, ucaGroupDigit<<21|uint32(r))
+		case r >= 'a' && r <= 'z':
+			dst = appendWeight24(dst, ucaGroupLatin<<21|uint32(r-'a'+'A'))
+		case r >= 'A' && r <= 'Z':
+			dst = appendWeight24(dst, ucaGroupLatin<<21|uint32(r))
+		case unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r):
+			dst = appendWeight24(dst, ucaGroupVariable<<21|uint32(r))
+		default:
+			c := latinBaseLetter(r)
+			if c == '*' {
+				for _, e := range latinExpansions[r] {
+					dst = appendWeight24(dst, ucaGroupLatin<<21|uint32(e))
+				}
+			} else if c != 0 {
+				dst = appendWeight24(dst, ucaGroupLatin<<21|uint32(c))
+			} else {
+				dst = appendWeight24(dst, ucaGroupOther<<21|uint32(unicode.ToUpper(r)))
+			}
+		}
+	}
+	return dst
+}
+
+// latin1SwedishCISortOrder sort_order_latin1 of MySQL, Ä, Å, Ö are sorted after Z
+var latin1SwedishCISortOrder = func() [256]byte {
+	var order [256]byte
+	for i := range order {
+		order[i] = byte(i)
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		order[c] = byte(c - 'a' + 'A')
+	}
+	high := []byte{
+		'A', 'A', 'A', 'A', '\\', '[', '\\', 'C', 'E', 'E', 'E', 'E', 'I', 'I', 'I', 'I',
+		'D', 'N', 'O', 'O', 'O', 'O', ']', 0xd7, 0xd8, 'U', 'U', 'U', 'Y', 'Y', 0xde, 0xdf,
+		'A', 'A', 'A', 'A', '\\', '[', '\\', 'C', 'E', 'E', 'E', 'E', 'I', 'I', 'I', 'I',
+		'D', 'N', 'O', 'O', 'O', 'O', ']', 0xf7, 0xd8, 'U', 'U', 'U', 'Y', 'Y', 0xde, 0xff,
+	}
+	copy(order[0xc0:], high)
+	return order
+}()
+
+// latin1SwedishCIWeights weights of latin1_swedish_ci, the default collation of latin1
+func latin1SwedishCIWeights(dst, s []byte) []byte {
+	for _, c := range s {
+		dst = append(dst, latin1SwedishCISortOrder[c])
+	}
+	return dst
+}
+
+// decodeRune return the byte as rune if it's not valid utf8
+func decodeRune(s []byte) (rune, int) {
+	r, size := utf8.DecodeRune(s)
+	if r == utf8.RuneError && size == 1 {
+		return rune(s[0]), 1
+	}
+	return r, size
+}
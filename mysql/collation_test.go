@@ -0,0 +1,116 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCollationCompare(t *testing.T) {
+	tests := []struct {
+		collation string
+		s1, s2    string
+		expect    int
+	}{
+		{"utf8mb4_general_ci", "Straße", "strase", 0},
+		{"utf8mb4_general_ci", "Ålesund", "alesund  ", 0},
+		{"utf8mb4_general_ci", "Æble", "Able", 1},
+		{"utf8mb4_general_ci", "a", "B", -1},
+		{"utf8mb4_general_ci", "😀", "😁", 0},
+		{"utf8mb4_0900_ai_ci", "Straße", "STRASSE", 0},
+		{"utf8mb4_0900_ai_ci", "Æble", "aeble", 0},
+		{"utf8mb4_0900_ai_ci", "résumé", "RESUME", 0},
+		{"utf8mb4_0900_ai_ci", "a ", "a", 1},
+		{"utf8mb4_0900_ai_ci", "_a", "0", -1},
+		{"utf8mb4_0900_ai_ci", "9", "a", -1},
+		{"utf8mb4_0900_ai_ci", "z", "α", -1},
+		{"utf8mb4_0900_ai_ci", "ＡＢＣ", "abc", 0},
+		{"utf8mb4_bin", "a", "B", 1},
+		{"utf8mb4_bin", "a  ", "a", 0},
+		{"latin1_swedish_ci", "\xe5", "z", 1},
+		{"latin1_swedish_ci", "\xe9t\xe9", "ETE", 0},
+		{"latin1_swedish_ci", "\xe4", "\xc6", 0},
+		{"latin1_bin", "\xe9", "E", 1},
+		{"binary", "a ", "a", 1},
+		{"utf8mb4_unicode_ci", "abc", "ABC", 0},
+		{"latin7_general_cs", "abc", "ABC", 1},
+	}
+	for _, test := range tests {
+		c := GetCollator(CollationIds[test.collation])
+		if c.Name() != test.collation {
+			t.Errorf("expect collation %s, got %s", test.collation, c.Name())
+		}
+		if v := c.Compare([]byte(test.s1), []byte(test.s2)); v != test.expect {
+			t.Errorf("compare %q and %q in %s, expect %d, got %d", test.s1, test.s2, test.collation, test.expect, v)
+		}
+	}
+
+	if c := GetCollator(1000); c.ID() != BinaryCollationID {
+		t.Errorf("expect binary collation for unknown id, got %s", c.Name())
+	}
+}
+
+func TestCollationWeightString(t *testing.T) {
+	c := GetCollator(CollationIds["utf8mb4_general_ci"])
+	w1 := c.WeightString(nil, []byte("abc "))
+	w2 := c.WeightString([]byte("x"), []byte("ABC"))
+	if !bytes.Equal(w1, w2[1:]) || w2[0] != 'x' {
+		t.Errorf("weight strings of equal strings should be equal, got %v and %v", w1, w2)
+	}
+	if bytes.Compare(w1, c.WeightString(nil, []byte("abd"))) != -1 {
+		t.Errorf("weight strings should be ordered as strings")
+	}
+}
+
+func TestFieldCollator(t *testing.T) {
+	utf8CI := uint16(CollationIds["utf8mb4_general_ci"])
+	tests := []struct {
+		field  Field
+		expect bool
+	}{
+		{Field{Type: TypeVarString, Charset: utf8CI}, true},
+		{Field{Type: TypeBlob, Charset: utf8CI}, true},
+		{Field{Type: TypeBlob, Charset: uint16(BinaryCollationID)}, false},
+		{Field{Type: TypeString, Charset: utf8CI, Flag: uint16(EnumFlag)}, false},
+		{Field{Type: TypeLonglong, Charset: uint16(BinaryCollationID)}, false},
+	}
+	for i, test := range tests {
+		if _, ok := FieldCollator(&test.field); ok != test.expect {
+			t.Errorf("test %d: expect %v, got %v", i, test.expect, ok)
+		}
+	}
+}
+
+func TestSortStringColumnByCollation(t *testing.T) {
+	fields := []*Field{{Type: TypeVarString, Charset: uint16(CollationIds["utf8mb4_0900_ai_ci"])}}
+	r := &Resultset{Fields: fields, Values: [][]interface{}{{"b"}, {"Á"}, {nil}, {"C"}}}
+	if err := r.SortWithoutColumnName([]SortKey{{Column: 0, Direction: SortAsc}}); err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]interface{}{{nil}, {"Á"}, {"b"}, {"C"}}
+	if !reflect.DeepEqual(r.Values, expect) {
+		t.Errorf("sort string column error, expect %v, got %v", expect, r.Values)
+	}
+
+	r1 := &Resultset{Fields: fields, Values: [][]interface{}{{[]byte("a")}, {[]byte("C")}}}
+	r2 := &Resultset{Fields: fields, Values: [][]interface{}{{[]byte("B")}, {[]byte("d")}}}
+	merged := MergeSortedResultsets([]*Resultset{r1, r2}, []SortKey{{Column: 0, Direction: SortAsc}}, -1)
+	expect = [][]interface{}{{[]byte("a")}, {[]byte("B")}, {[]byte("C")}, {[]byte("d")}}
+	if !reflect.DeepEqual(merged.Values, expect) {
+		t.Errorf("merge string column error, expect %v, got %v", expect, merged.Values)
+	}
+}
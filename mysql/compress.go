@@ -0,0 +1,161 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+const (
+	compressedHeaderSize = 7
+
+	// payload shorter than minCompressLength is sent without compression, the same as mysql
+	minCompressLength = 50
+)
+
+// compressedReadWriter implements the compressed protocol with zlib.
+// Each compressed packet carries a stream of plain packets, and plain packets
+// may span compressed packets, so it works as a stream under the packet layer.
+// see: https://dev.mysql.com/doc/internals/en/compressed-packet-header.html
+type compressedReadWriter struct {
+	r io.Reader
+	w io.Writer
+
+	// sequence of compressed packets, reset with plain sequence at the start of each command
+	sequence uint8
+
+	readBuf []byte // decompressed data not consumed yet
+	zr      io.ReadCloser
+
+	zw          *zlib.Writer
+	compressBuf bytes.Buffer
+	writeBuf    []byte
+}
+
+func newCompressedReadWriter(r io.Reader, w io.Writer) *compressedReadWriter {
+	return &compressedReadWriter{r: r, w: w}
+}
+
+// Read implement io.Reader, read decompressed data
+func (c *compressedReadWriter) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		if err := c.readCompressedPacket(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *compressedReadWriter) readCompressedPacket() error {
+	var header [compressedHeaderSize]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		// io.EOF is returned as is, so the client disconnecting can be recognized
+		return err
+	}
+
+	compressedLength := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	sequence := header[3]
+	if sequence != c.sequence {
+		return fmt.Errorf("invalid compressed sequence, expected %v got %v", c.sequence, sequence)
+	}
+	c.sequence++
+	uncompressedLength := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16)
+
+	payload := make([]byte, compressedLength)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return fmt.Errorf("io.ReadFull(compressed packet body of length %v) failed: %v", compressedLength, err)
+	}
+
+	// uncompressed length 0 means the payload is not compressed
+	if uncompressedLength == 0 {
+		c.readBuf = payload
+		return nil
+	}
+
+	if c.zr == nil {
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("create zlib reader failed: %v", err)
+		}
+		c.zr = zr
+	} else if err := c.zr.(zlib.Resetter).Reset(bytes.NewReader(payload), nil); err != nil {
+		return fmt.Errorf("reset zlib reader failed: %v", err)
+	}
+
+	data := make([]byte, uncompressedLength)
+	if _, err := io.ReadFull(c.zr, data); err != nil {
+		return fmt.Errorf("decompress packet of length %v failed: %v", uncompressedLength, err)
+	}
+	c.readBuf = data
+	return nil
+}
+
+// Write implement io.Writer, data is written as one or more compressed packets
+func (c *compressedReadWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > MaxPacketSize {
+			n = MaxPacketSize
+		}
+		if err := c.writeCompressedPacket(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *compressedReadWriter) writeCompressedPacket(data []byte) error {
+	payload := data
+	uncompressedLength := 0
+	if len(data) >= minCompressLength {
+		c.compressBuf.Reset()
+		if c.zw == nil {
+			c.zw = zlib.NewWriter(&c.compressBuf)
+		} else {
+			c.zw.Reset(&c.compressBuf)
+		}
+		if _, err := c.zw.Write(data); err != nil {
+			return fmt.Errorf("compress packet failed: %v", err)
+		}
+		if err := c.zw.Close(); err != nil {
+			return fmt.Errorf("compress packet failed: %v", err)
+		}
+		// send the raw data if compression doesn't help
+		if c.compressBuf.Len() < len(data) {
+			payload = c.compressBuf.Bytes()
+			uncompressedLength = len(data)
+		}
+	}
+
+	c.writeBuf = append(c.writeBuf[:0],
+		byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16),
+		c.sequence,
+		byte(uncompressedLength), byte(uncompressedLength>>8), byte(uncompressedLength>>16))
+	c.writeBuf = append(c.writeBuf, payload...)
+	c.sequence++
+
+	if _, err := c.w.Write(c.writeBuf); err != nil {
+		return err
+	}
+	return nil
+}
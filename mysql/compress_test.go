@@ -0,0 +1,101 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestCompressedReadWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newCompressedReadWriter(nil, &buf)
+
+	small := []byte("select 1")
+	large := bytes.Repeat([]byte("select * from tbl_compress where id = 1;"), 100)
+	if _, err := w.Write(small); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	// short payload is not compressed
+	raw := buf.Bytes()
+	if len(raw) != compressedHeaderSize+len(small) || raw[3] != 0 || raw[4] != 0 || raw[5] != 0 || raw[6] != 0 {
+		t.Fatalf("invalid uncompressed packet: %v", raw)
+	}
+
+	if _, err := w.Write(large); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if buf.Len() >= 2*compressedHeaderSize+len(small)+len(large) {
+		t.Errorf("large payload should be compressed, length: %d", buf.Len())
+	}
+
+	r := newCompressedReadWriter(&buf, nil)
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(len(small)+len(large))))
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	if !bytes.Equal(data, append(small, large...)) {
+		t.Errorf("read data not match")
+	}
+	if r.sequence != 2 {
+		t.Errorf("sequence of compressed packets error, expect 2, got: %d", r.sequence)
+	}
+
+	if _, err := r.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after all data should return io.EOF, got: %v", err)
+	}
+}
+
+func TestConnCompression(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	client, server := NewConn(clientConn), NewConn(serverConn)
+	defer client.Close()
+	defer server.Close()
+	client.EnableCompression()
+	server.EnableCompression()
+
+	packets := [][]byte{
+		[]byte("\x03select 1"),
+		append([]byte{ComQuery}, bytes.Repeat([]byte("x"), 3*connBufferSize)...),
+	}
+	errC := make(chan error, 1)
+	go func() {
+		for _, p := range packets {
+			client.SetSequence(0)
+			if err := client.WritePacket(p); err != nil {
+				errC <- err
+				return
+			}
+		}
+		errC <- nil
+	}()
+
+	for _, p := range packets {
+		server.SetSequence(0)
+		data, err := server.ReadPacket()
+		if err != nil {
+			t.Fatalf("read packet error: %v", err)
+		}
+		if !bytes.Equal(data, p) {
+			t.Errorf("packet not match, expect: %q, got: %q", p, data)
+		}
+	}
+	if err := <-errC; err != nil {
+		t.Fatalf("write packet error: %v", err)
+	}
+}
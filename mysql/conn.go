@@ -102,6 +102,9 @@ type Conn struct {
 	// currentEphemeralBuffer for tracking allocated temporary buffer for writes and reads respectively.
 	// It can be allocated from bufPool or heap and should be recycled in the same manner.
 	currentEphemeralBuffer *[]byte
+
+	// compressor is not nil if compressed protocol is enabled
+	compressor *compressedReadWriter
 }
 
 // bufPool is used to allocate and free buffers in an efficient way.
@@ -128,7 +131,30 @@ func (c *Conn) LocalAddr() net.Addr {
 // be terminated by a call to flush.
 func (c *Conn) StartWriterBuffering() {
 	c.bufferedWriter = writersPool.Get().(*bufio.Writer)
-	c.bufferedWriter.Reset(c.conn)
+	c.bufferedWriter.Reset(c.netWriter())
+}
+
+// EnableCompression switch to compressed protocol, it must be called
+// after the handshake is finished and before the next command.
+func (c *Conn) EnableCompression() {
+	if c.compressor != nil {
+		return
+	}
+	c.compressor = newCompressedReadWriter(c.getReader(), c.conn)
+	c.bufferedReader = bufio.NewReaderSize(c.compressor, connBufferSize)
+}
+
+// IsCompressionEnabled return true if compressed protocol is used
+func (c *Conn) IsCompressionEnabled() bool {
+	return c.compressor != nil
+}
+
+// netWriter returns the writer of the network, with compression if enabled
+func (c *Conn) netWriter() io.Writer {
+	if c.compressor != nil {
+		return c.compressor
+	}
+	return c.conn
 }
 
 // Flush flushes the written data to the socket.
@@ -153,7 +179,7 @@ func (c *Conn) getWriter() io.Writer {
 	if c.bufferedWriter != nil {
 		return c.bufferedWriter
 	}
-	return c.conn
+	return c.netWriter()
 }
 
 // getReader returns reader for connection. It can be *bufio.Reader or net.Conn
@@ -487,7 +513,7 @@ func (c *Conn) recycleWritePacket() {
 // Returns SQLError(CRServerGone) if it can't.
 func (c *Conn) writeComQuit() error {
 	// This is a new command, need to reset the sequence.
-	c.sequence = 0
+	c.SetSequence(0)
 
 	data := c.StartEphemeralPacket(1)
 	data[0] = ComQuit
@@ -515,6 +541,10 @@ func (c *Conn) SetConnectionID(connectionID uint32) {
 // SetSequence set sequence of conn
 func (c *Conn) SetSequence(sequence uint8) {
 	c.sequence = sequence
+	// sequence of compressed packets is reset at the start of each command too
+	if c.compressor != nil && sequence == 0 {
+		c.compressor.sequence = 0
+	}
 }
 
 // GetSequence return sequence of conn
@@ -566,6 +596,33 @@ func (c *Conn) WriteOKPacket(affectedRows, lastInsertID uint64, flags uint16, wa
 	return c.WriteEphemeralPacket()
 }
 
+// WriteOKPacketWithSessionState writes an OK packet with session state information,
+// it can only be sent to clients with CLIENT_SESSION_TRACK capability.
+// Server -> Client.
+// This method returns a generic error, not a SQLError.
+func (c *Conn) WriteOKPacketWithSessionState(affectedRows, lastInsertID uint64, flags uint16, warnings uint16, sessionState []byte) error {
+	flags |= ServerSessionStateChanged
+	length := 1 + // OKHeader
+		LenEncIntSize(affectedRows) +
+		LenEncIntSize(lastInsertID) +
+		2 + // flags
+		2 + // warnings
+		1 + // empty info
+		LenEncIntSize(uint64(len(sessionState))) + len(sessionState)
+	data := c.StartEphemeralPacket(length)
+	pos := 0
+	pos = WriteByte(data, pos, OKHeader)
+	pos = WriteLenEncInt(data, pos, affectedRows)
+	pos = WriteLenEncInt(data, pos, lastInsertID)
+	pos = WriteUint16(data, pos, flags)
+	pos = WriteUint16(data, pos, warnings)
+	pos = WriteLenEncInt(data, pos, 0)
+	pos = WriteLenEncInt(data, pos, uint64(len(sessionState)))
+	copy(data[pos:], sessionState)
+
+	return c.WriteEphemeralPacket()
+}
+
 // WriteOKPacketWithEOFHeader writes an OK packet with an EOF header.
 // This is used at the end of a result set if
 // CapabilityClientDeprecateEOF is set.
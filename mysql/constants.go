@@ -76,6 +76,18 @@ const (
 	ServerStatusMetadataChanged    uint16 = 0x0400
 	ServerStatusWasSlow            uint16 = 0x0800
 	ServerPSOutParams              uint16 = 0x1000
+	ServerStatusInTransReadonly    uint16 = 0x2000
+	ServerSessionStateChanged      uint16 = 0x4000
+)
+
+// types of session state information in OK packet
+const (
+	SessionTrackSystemVariables byte = iota
+	SessionTrackSchema
+	SessionTrackStateChange
+	SessionTrackGTIDs
+	SessionTrackTransactionCharacteristics
+	SessionTrackTransactionState
 )
 
 // ErrTextLength error text length limit.
@@ -142,6 +154,17 @@ const (
 	ClientPluginAuth
 	ClientConnectAtts
 	ClientPluginAuthLenencClientData
+	ClientCanHandleExpiredPasswords
+	ClientSessionTrack
+	ClientDeprecateEOF
+	ClientOptionalResultsetMetadata
+)
+
+// values of metadata_follows in resultset, controlled by session variable resultset_metadata
+// only sent to client with ClientOptionalResultsetMetadata capability
+const (
+	ResultsetMetadataNone byte = 0
+	ResultsetMetadataFull byte = 1
 )
 
 // PrivilegeType  privilege
@@ -0,0 +1,265 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DecimalMaxScale max scale of DECIMAL
+	DecimalMaxScale = 30
+	// DivPrecisionIncrement default value of div_precision_increment, scale of quotient is increased by it
+	DivPrecisionIncrement = 4
+)
+
+// ErrDecimalDivByZero division by zero of Decimal, MySQL returns NULL for it
+var ErrDecimalDivByZero = errors.New("decimal division by zero")
+
+// RoundMode rounding mode of Decimal, same as decimal_round_mode of MySQL
+type RoundMode int
+
+const (
+	// RoundHalfUp round half away from zero, used by ROUND() and when storing to DECIMAL column
+	RoundHalfUp RoundMode = iota
+	// RoundHalfEven round half to even
+	RoundHalfEven
+	// RoundTruncate round toward zero, used by TRUNCATE()
+	RoundTruncate
+	// RoundCeiling round toward positive infinity, used by CEIL()
+	RoundCeiling
+	// RoundFloor round toward negative infinity, used by FLOOR()
+	RoundFloor
+)
+
+var (
+	bigOne = big.NewInt(1)
+	bigTen = big.NewInt(10)
+)
+
+// Decimal exact number of arbitrary precision, the value is unscaled * 10^-scale.
+// Zero value of Decimal is 0, Decimal is immutable.
+type Decimal struct {
+	unscaled *big.Int
+	scale    int
+}
+
+// NewDecimalFromInt create Decimal from int64
+func NewDecimalFromInt(v int64) Decimal {
+	return Decimal{unscaled: big.NewInt(v)}
+}
+
+// NewDecimalFromUint create Decimal from uint64
+func NewDecimalFromUint(v uint64) Decimal {
+	return Decimal{unscaled: new(big.Int).SetUint64(v)}
+}
+
+// NewDecimalFromFloat create Decimal from the shortest decimal representation of float64
+func NewDecimalFromFloat(v float64) (Decimal, error) {
+	return ParseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+// ParseDecimal parse decimal string like "-12.340" or "1.5e3", scale of result is the count of fraction digits
+func ParseDecimal(s string) (Decimal, error) {
+	str := strings.TrimSpace(s)
+	exp := 0
+	if i := strings.IndexAny(str, "eE"); i != -1 {
+		var err error
+		if exp, err = strconv.Atoi(str[i+1:]); err != nil {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+		str = str[:i]
+	}
+
+	neg := false
+	if len(str) > 0 && (str[0] == '+' || str[0] == '-') {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+	intPart, fracPart := str, ""
+	if i := strings.IndexByte(str, '.'); i != -1 {
+		intPart, fracPart = str[:i], str[i+1:]
+	}
+	digits := intPart + fracPart
+	if digits == "" || strings.TrimLeft(digits, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+
+	unscaled, _ := new(big.Int).SetString(digits, 10)
+	if neg {
+		unscaled.Neg(unscaled)
+	}
+	scale := len(fracPart) - exp
+	if scale < 0 {
+		unscaled.Mul(unscaled, pow10(-scale))
+		scale = 0
+	}
+	return Decimal{unscaled: unscaled, scale: scale}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) value() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return d.unscaled
+}
+
+// rescale return unscaled value in the larger scale, scale must not be less than d.scale
+func (d Decimal) rescale(scale int) *big.Int {
+	if scale == d.scale {
+		return d.value()
+	}
+	return new(big.Int).Mul(d.value(), pow10(scale-d.scale))
+}
+
+// Scale return count of fraction digits
+func (d Decimal) Scale() int {
+	return d.scale
+}
+
+// Sign return -1, 0 or 1
+func (d Decimal) Sign() int {
+	return d.value().Sign()
+}
+
+// Cmp compare d and d2, return -1, 0 or 1
+func (d Decimal) Cmp(d2 Decimal) int {
+	scale := maxInt(d.scale, d2.scale)
+	return d.rescale(scale).Cmp(d2.rescale(scale))
+}
+
+// Add return d + d2, scale of result is the larger scale
+func (d Decimal) Add(d2 Decimal) Decimal {
+	scale := maxInt(d.scale, d2.scale)
+	return Decimal{unscaled: new(big.Int).Add(d.rescale(scale), d2.rescale(scale)), scale: scale}
+}
+
+// Sub return d - d2, scale of result is the larger scale
+func (d Decimal) Sub(d2 Decimal) Decimal {
+	scale := maxInt(d.scale, d2.scale)
+	return Decimal{unscaled: new(big.Int).Sub(d.rescale(scale), d2.rescale(scale)), scale: scale}
+}
+
+// Mul return d * d2, scale of result is the sum of scales, digits exceeding DecimalMaxScale are truncated
+func (d Decimal) Mul(d2 Decimal) Decimal {
+	r := Decimal{unscaled: new(big.Int).Mul(d.value(), d2.value()), scale: d.scale + d2.scale}
+	if r.scale > DecimalMaxScale {
+		return r.Round(DecimalMaxScale, RoundTruncate)
+	}
+	return r
+}
+
+// Div return d / d2 rounded half up to scale, MySQL uses scale of d plus DivPrecisionIncrement
+func (d Decimal) Div(d2 Decimal, scale int) (Decimal, error) {
+	if d2.Sign() == 0 {
+		return Decimal{}, ErrDecimalDivByZero
+	}
+	// d / d2 * 10^scale = (d.unscaled * 10^(scale+d2.scale)) / (d2.unscaled * 10^d.scale)
+	n := new(big.Int).Set(d.value())
+	m := new(big.Int).Set(d2.value())
+	if shift := scale + d2.scale - d.scale; shift >= 0 {
+		n.Mul(n, pow10(shift))
+	} else {
+		m.Mul(m, pow10(-shift))
+	}
+	return Decimal{unscaled: roundQuo(n, m, RoundHalfUp), scale: scale}, nil
+}
+
+// Round return d rounded to scale with mode, negative scale rounds digits before the decimal point.
+// d is returned if its scale is not larger than scale.
+func (d Decimal) Round(scale int, mode RoundMode) Decimal {
+	if scale >= d.scale {
+		return d
+	}
+	q := roundQuo(d.value(), pow10(d.scale-scale), mode)
+	if scale < 0 {
+		return Decimal{unscaled: q.Mul(q, pow10(-scale))}
+	}
+	return Decimal{unscaled: q, scale: scale}
+}
+
+// roundQuo return n / m rounded with mode
+func roundQuo(n, m *big.Int, mode RoundMode) *big.Int {
+	q, r := new(big.Int).QuoRem(n, m, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+
+	neg := n.Sign() != m.Sign()
+	awayFromZero := false
+	switch mode {
+	case RoundTruncate:
+	case RoundCeiling:
+		awayFromZero = !neg
+	case RoundFloor:
+		awayFromZero = neg
+	default:
+		half := new(big.Int).Abs(r)
+		half.Lsh(half, 1)
+		cmp := half.Cmp(new(big.Int).Abs(m))
+		awayFromZero = cmp > 0 || (cmp == 0 && (mode == RoundHalfUp || q.Bit(0) == 1))
+	}
+
+	if awayFromZero {
+		if neg {
+			q.Sub(q, bigOne)
+		} else {
+			q.Add(q, bigOne)
+		}
+	}
+	return q
+}
+
+// String return decimal string with scale fraction digits
+func (d Decimal) String() string {
+	v := d.value()
+	digits := new(big.Int).Abs(v).String()
+	if d.scale > 0 {
+		if len(digits) <= d.scale {
+			digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+	}
+	if v.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// Float64 return the nearest float64 value
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// MarshalJSON implement json.Marshaler, Decimal is encoded as json number without loss of precision
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustParseDecimal(t *testing.T, s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		t.Fatalf("parse decimal %s error: %v", s, err)
+	}
+	return d
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		s      string
+		expect string
+		scale  int
+	}{
+		{"0", "0", 0},
+		{"-12.340", "-12.340", 3},
+		{"+.5", "0.5", 1},
+		{"-0.001", "-0.001", 3},
+		{" 1.5e3 ", "1500", 0},
+		{"1.5E-3", "0.0015", 4},
+		{"12345678901234567890.123456789012345678", "12345678901234567890.123456789012345678", 18},
+	}
+	for _, test := range tests {
+		d := mustParseDecimal(t, test.s)
+		if d.String() != test.expect || d.Scale() != test.scale {
+			t.Errorf("parse %q, expect %s with scale %d, got %s with scale %d", test.s, test.expect, test.scale, d.String(), d.Scale())
+		}
+	}
+
+	for _, s := range []string{"", ".", "abc", "1.2.3", "1e", "--1"} {
+		if _, err := ParseDecimal(s); err == nil {
+			t.Errorf("expect error of parsing %q", s)
+		}
+	}
+
+	if (Decimal{}).String() != "0" {
+		t.Errorf("zero value of Decimal should be 0")
+	}
+	if d, _ := NewDecimalFromFloat(0.1); d.String() != "0.1" {
+		t.Errorf("expect 0.1, got %s", d.String())
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	a := mustParseDecimal(t, "0.1")
+	b := mustParseDecimal(t, "0.20")
+	if s := a.Add(b).String(); s != "0.30" {
+		t.Errorf("expect 0.30, got %s", s)
+	}
+	if s := a.Sub(b).String(); s != "-0.10" {
+		t.Errorf("expect -0.10, got %s", s)
+	}
+	if s := a.Mul(b).String(); s != "0.020" {
+		t.Errorf("expect 0.020, got %s", s)
+	}
+	if s := NewDecimalFromUint(18446744073709551615).Add(NewDecimalFromInt(1)).String(); s != "18446744073709551616" {
+		t.Errorf("expect 18446744073709551616, got %s", s)
+	}
+
+	// sum of many values is exact
+	sum := Decimal{}
+	for i := 0; i < 1000; i++ {
+		sum = sum.Add(a)
+	}
+	if s := sum.String(); s != "100.0" {
+		t.Errorf("expect 100.0, got %s", s)
+	}
+
+	q, err := mustParseDecimal(t, "10.00").Div(NewDecimalFromInt(3), 6)
+	if err != nil || q.String() != "3.333333" {
+		t.Errorf("expect 3.333333, got %s, %v", q.String(), err)
+	}
+	q, err = mustParseDecimal(t, "-2").Div(NewDecimalFromInt(3), 4)
+	if err != nil || q.String() != "-0.6667" {
+		t.Errorf("expect -0.6667, got %s, %v", q.String(), err)
+	}
+	if _, err = a.Div(Decimal{}, 4); err != ErrDecimalDivByZero {
+		t.Errorf("expect division by zero error, got %v", err)
+	}
+
+	if a.Cmp(b) != -1 || b.Cmp(mustParseDecimal(t, "0.2")) != 0 || b.Sign() != 1 {
+		t.Errorf("compare decimal error")
+	}
+}
+
+func TestDecimalRound(t *testing.T) {
+	tests := []struct {
+		s      string
+		scale  int
+		mode   RoundMode
+		expect string
+	}{
+		{"2.5", 0, RoundHalfUp, "3"},
+		{"-2.5", 0, RoundHalfUp, "-3"},
+		{"2.5", 0, RoundHalfEven, "2"},
+		{"3.5", 0, RoundHalfEven, "4"},
+		{"2.51", 0, RoundHalfEven, "3"},
+		{"-2.9", 0, RoundTruncate, "-2"},
+		{"2.1", 0, RoundCeiling, "3"},
+		{"-2.1", 0, RoundCeiling, "-2"},
+		{"2.9", 0, RoundFloor, "2"},
+		{"-2.1", 0, RoundFloor, "-3"},
+		{"1.2345", 2, RoundHalfUp, "1.23"},
+		{"1.235", 2, RoundHalfUp, "1.24"},
+		{"1.2", 3, RoundHalfUp, "1.2"},
+		{"155.5", -1, RoundHalfUp, "160"},
+		{"-0.4", 0, RoundHalfUp, "0"},
+	}
+	for _, test := range tests {
+		r := mustParseDecimal(t, test.s).Round(test.scale, test.mode)
+		if r.String() != test.expect {
+			t.Errorf("round %s to %d in mode %d, expect %s, got %s", test.s, test.scale, test.mode, test.expect, r.String())
+		}
+	}
+}
+
+func TestDecimalFormat(t *testing.T) {
+	row := []interface{}{mustParseDecimal(t, "-1.50")}
+	b, err := json.Marshal(row)
+	if err != nil || string(b) != "[-1.50]" {
+		t.Errorf("expect [-1.50], got %s, %v", b, err)
+	}
+
+	v, err := formatValue(row[0])
+	if err != nil || string(v) != "-1.50" {
+		t.Errorf("expect -1.50, got %s, %v", v, err)
+	}
+
+	data, err := AppendBinaryValue(nil, TypeNewDecimal, row[0])
+	if err != nil || string(data) != "\x05-1.50" {
+		t.Errorf("expect length encoded -1.50, got %q, %v", data, err)
+	}
+
+	rowData := RowData(AppendLenEncStringBytes(AppendLenEncStringBytes(nil, []byte("a")), []byte("12.30")))
+	col, isNull, err := rowData.TextColumn(1)
+	if err != nil || isNull || string(col) != "12.30" {
+		t.Errorf("expect 12.30, got %s, %v, %v", col, isNull, err)
+	}
+	if _, _, err = rowData.TextColumn(2); err == nil {
+		t.Errorf("expect error of reading column out of range")
+	}
+}
@@ -34,11 +34,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"math"
-	"strconv"
-	"time"
-
-	"github.com/XiaoMi/Gaea/util/hack"
 )
 
 // This file contains the data encoding and decoding functions.
@@ -468,125 +463,3 @@ func FormatBinaryTime(n int, data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("invalid time packet length %d", n)
 	}
 }
-
-// AppendBinaryValue encode binary-type value of prepare binary protocol according to type of value
-func AppendBinaryValue(data []byte, fieldType uint8, value interface{}) ([]byte, error) {
-	// constructor phase
-	var t []byte
-	switch v := value.(type) {
-	case int8:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case int16:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case int32:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case int64:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case int:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case uint8:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case uint16:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case uint32:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case uint64:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case uint:
-		t = make([]byte, 8)
-		WriteUint64(t, 0, uint64(v))
-	case float64:
-		switch fieldType {
-		case TypeFloat:
-			t = make([]byte, 4)
-			WriteUint32(t, 0, math.Float32bits(float32(v)))
-		case TypeNewDecimal:
-			tmp := strconv.FormatFloat(v, 'f', -1, 64)
-			t = []byte(tmp)
-		default:
-			t = make([]byte, 8)
-			WriteUint64(t, 0, math.Float64bits(v))
-		}
-	case []byte:
-		t = v
-	case string:
-		switch fieldType {
-		case TypeDatetime, TypeTimestamp:
-			if v == "0000-00-00 00:00:00" {
-				t = append(t, 0)
-			} else {
-				ts, err := time.Parse("2006-01-02 15:04:05", v)
-				if err != nil {
-					var mysqlTypeStr string
-					if fieldType == TypeDatetime {
-						mysqlTypeStr = "TypeDatetime"
-					} else {
-						mysqlTypeStr = "TypeTimestamp"
-					}
-					return nil, fmt.Errorf("invalid %s %s", mysqlTypeStr, v)
-				}
-
-				t = append(t, 11)
-				t = AppendUint16(t, uint16(ts.Year()))
-				t = append(t, byte(int(ts.Month())), byte(ts.Day()), byte(ts.Hour()), byte(ts.Minute()), byte(ts.Second()))
-				microseconds := uint32(ts.Nanosecond() / 1000)
-				t = AppendUint32(t, microseconds)
-			}
-		case TypeDate:
-			// format: 2006-01-02
-			ts, err := time.Parse("2006-01-02", v)
-			if err != nil {
-				t = append(t, 0)
-			} else {
-				t = append(t, 4)
-				t = AppendUint16(t, uint16(ts.Year()))
-				t = append(t, byte(int(ts.Month())), byte(ts.Day()))
-			}
-		case TypeDuration:
-			timeValue, err := stringToMysqlTime(v)
-			if err != nil {
-				return nil, err
-			}
-
-			t = mysqlTimeToBinaryResult(timeValue)
-		default:
-			t = hack.Slice(v)
-		}
-	default:
-		return data, fmt.Errorf("invalid type %T", value)
-	}
-
-	// append phase
-	switch fieldType {
-	case TypeTiny:
-		data = append(data, t[0])
-		return data, nil
-	case TypeShort, TypeYear:
-		data = append(data, t[:2]...)
-		return data, nil
-	case TypeFloat, TypeInt24, TypeLong:
-		data = append(data, t[:4]...)
-		return data, nil
-	case TypeLonglong, TypeDouble:
-		data = append(data, t[:8]...)
-		return data, nil
-	case TypeNewDecimal, TypeJSON, TypeString, TypeVarString, TypeVarchar, TypeBit, TypeTinyBlob, TypeMediumBlob, TypeLongBlob, TypeBlob:
-		tmp := make([]byte, 0, len(t)+9)
-		data = append(data, AppendLenEncStringBytes(tmp, t)...)
-		return data, nil
-	case TypeEnum, TypeSet, TypeDate, TypeDatetime, TypeDuration, TypeTimestamp, TypeNewDate:
-		data = append(data, t...)
-		return data, nil
-	default:
-		return data, fmt.Errorf("not supported field type")
-	}
-}
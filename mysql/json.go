@@ -0,0 +1,752 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSON values are decoded to nil(null), bool, int64, uint64, float64, string, []interface{}(array),
+// map[string]interface{}(object), and Decimal, DateTime, Duration for opaque values of binary format.
+
+// types of value in binary format of JSON, see json_binary.h of MySQL
+const (
+	jsonbSmallObject = 0x00
+	jsonbLargeObject = 0x01
+	jsonbSmallArray  = 0x02
+	jsonbLargeArray  = 0x03
+	jsonbLiteral     = 0x04
+	jsonbInt16       = 0x05
+	jsonbUint16      = 0x06
+	jsonbInt32       = 0x07
+	jsonbUint32      = 0x08
+	jsonbInt64       = 0x09
+	jsonbUint64      = 0x0a
+	jsonbDouble      = 0x0b
+	jsonbString      = 0x0c
+	jsonbOpaque      = 0x0f
+
+	jsonbLiteralNull  = 0x00
+	jsonbLiteralTrue  = 0x01
+	jsonbLiteralFalse = 0x02
+)
+
+// ParseJSON parse JSON text, integers are decoded to int64 or uint64, other numbers are decoded to float64
+func ParseJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON text: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON text: the document root must not be followed by other values")
+	}
+	return convertJSONNumbers(v), nil
+}
+
+func convertJSONNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return n
+		}
+		f, _ := strconv.ParseFloat(string(v), 64)
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = convertJSONNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = convertJSONNumbers(v[k])
+		}
+	}
+	return v
+}
+
+// DecodeJSONBinary decode JSON value in binary format of MySQL, which is used in binlog and storage
+func DecodeJSONBinary(data []byte) (interface{}, error) {
+	// empty value is null, e.g. JSON column of a row inserted before the column is added
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return decodeJSONBinaryValue(data[0], data[1:])
+}
+
+func decodeJSONBinaryValue(typ byte, data []byte) (interface{}, error) {
+	switch typ {
+	case jsonbSmallObject, jsonbLargeObject, jsonbSmallArray, jsonbLargeArray:
+		return decodeJSONBinaryContainer(typ, data)
+	case jsonbLiteral:
+		if len(data) < 1 {
+			return nil, errJSONBinaryTruncated
+		}
+		switch data[0] {
+		case jsonbLiteralNull:
+			return nil, nil
+		case jsonbLiteralTrue:
+			return true, nil
+		case jsonbLiteralFalse:
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid JSON literal %d", data[0])
+	case jsonbInt16:
+		if len(data) < 2 {
+			return nil, errJSONBinaryTruncated
+		}
+		return int64(int16(binary.LittleEndian.Uint16(data))), nil
+	case jsonbUint16:
+		if len(data) < 2 {
+			return nil, errJSONBinaryTruncated
+		}
+		return uint64(binary.LittleEndian.Uint16(data)), nil
+	case jsonbInt32:
+		if len(data) < 4 {
+			return nil, errJSONBinaryTruncated
+		}
+		return int64(int32(binary.LittleEndian.Uint32(data))), nil
+	case jsonbUint32:
+		if len(data) < 4 {
+			return nil, errJSONBinaryTruncated
+		}
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	case jsonbInt64:
+		if len(data) < 8 {
+			return nil, errJSONBinaryTruncated
+		}
+		return int64(binary.LittleEndian.Uint64(data)), nil
+	case jsonbUint64:
+		if len(data) < 8 {
+			return nil, errJSONBinaryTruncated
+		}
+		return binary.LittleEndian.Uint64(data), nil
+	case jsonbDouble:
+		if len(data) < 8 {
+			return nil, errJSONBinaryTruncated
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+	case jsonbString:
+		b, err := readJSONBinaryString(data)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case jsonbOpaque:
+		if len(data) < 1 {
+			return nil, errJSONBinaryTruncated
+		}
+		b, err := readJSONBinaryString(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		return decodeJSONOpaque(data[0], b)
+	default:
+		return nil, fmt.Errorf("invalid JSON binary type %d", typ)
+	}
+}
+
+var errJSONBinaryTruncated = fmt.Errorf("JSON binary value is truncated")
+
+// decodeJSONBinaryContainer decode object or array, offsets in entries are relative to the start of data
+func decodeJSONBinaryContainer(typ byte, data []byte) (interface{}, error) {
+	large := typ == jsonbLargeObject || typ == jsonbLargeArray
+	isObject := typ == jsonbSmallObject || typ == jsonbLargeObject
+	offsetSize := 2
+	if large {
+		offsetSize = 4
+	}
+	readOffset := func(pos int) int {
+		if large {
+			return int(binary.LittleEndian.Uint32(data[pos:]))
+		}
+		return int(binary.LittleEndian.Uint16(data[pos:]))
+	}
+
+	if len(data) < 2*offsetSize {
+		return nil, errJSONBinaryTruncated
+	}
+	count, size := readOffset(0), readOffset(offsetSize)
+	if size > len(data) {
+		return nil, errJSONBinaryTruncated
+	}
+	data = data[:size]
+
+	keyEntrySize, valueEntrySize := 0, 1+offsetSize
+	if isObject {
+		keyEntrySize = offsetSize + 2
+	}
+	headerSize := 2*offsetSize + count*(keyEntrySize+valueEntrySize)
+	if headerSize > size {
+		return nil, errJSONBinaryTruncated
+	}
+
+	values := make([]interface{}, count)
+	for i := 0; i < count; i++ {
+		entry := 2*offsetSize + count*keyEntrySize + i*valueEntrySize
+		valueType := data[entry]
+		if isJSONBinaryInlined(valueType, large) {
+			v, err := decodeJSONBinaryValue(valueType, data[entry+1:entry+valueEntrySize])
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+			continue
+		}
+		offset := readOffset(entry + 1)
+		if offset >= size {
+			return nil, errJSONBinaryTruncated
+		}
+		v, err := decodeJSONBinaryValue(valueType, data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	if !isObject {
+		return values, nil
+	}
+
+	obj := make(map[string]interface{}, count)
+	for i := 0; i < count; i++ {
+		entry := 2*offsetSize + i*keyEntrySize
+		offset := readOffset(entry)
+		length := int(binary.LittleEndian.Uint16(data[entry+offsetSize:]))
+		if offset+length > size {
+			return nil, errJSONBinaryTruncated
+		}
+		obj[string(data[offset:offset+length])] = values[i]
+	}
+	return obj, nil
+}
+
+// isJSONBinaryInlined return whether value of type is stored in value entry
+func isJSONBinaryInlined(typ byte, large bool) bool {
+	switch typ {
+	case jsonbLiteral, jsonbInt16, jsonbUint16:
+		return true
+	case jsonbInt32, jsonbUint32:
+		return large
+	}
+	return false
+}
+
+// readJSONBinaryString read data with length of variable size, each byte has 7 bits of length
+func readJSONBinaryString(data []byte) ([]byte, error) {
+	length, pos := 0, 0
+	for shift := uint(0); ; shift += 7 {
+		if pos >= len(data) || pos >= 5 {
+			return nil, errJSONBinaryTruncated
+		}
+		b := data[pos]
+		pos++
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if pos+length > len(data) {
+		return nil, errJSONBinaryTruncated
+	}
+	return data[pos : pos+length], nil
+}
+
+// decodeJSONOpaque decode opaque value of field type, values of unknown types are encoded like MySQL
+func decodeJSONOpaque(fieldType byte, data []byte) (interface{}, error) {
+	switch fieldType {
+	case TypeNewDecimal:
+		if len(data) < 2 {
+			return nil, errJSONBinaryTruncated
+		}
+		return decodeBinaryDecimal(int(data[0]), int(data[1]), data[2:])
+	case TypeDate, TypeDatetime, TypeTimestamp:
+		if len(data) < 8 {
+			return nil, errJSONBinaryTruncated
+		}
+		return unpackDateTime(fieldType, int64(binary.LittleEndian.Uint64(data))), nil
+	case TypeDuration:
+		if len(data) < 8 {
+			return nil, errJSONBinaryTruncated
+		}
+		return unpackDuration(int64(binary.LittleEndian.Uint64(data))), nil
+	default:
+		return fmt.Sprintf("base64:type%d:%s", fieldType, base64.StdEncoding.EncodeToString(data)), nil
+	}
+}
+
+// unpackDateTime unpack DATETIME in packed int64 format of MySQL
+func unpackDateTime(fieldType byte, packed int64) DateTime {
+	if packed < 0 {
+		packed = -packed
+	}
+	ymdhms := packed >> 24
+	ymd, hms := ymdhms>>17, ymdhms%(1<<17)
+	ym := ymd >> 5
+	t := DateTime{
+		Year:        int(ym / 13),
+		Month:       int(ym % 13),
+		Day:         int(ymd % (1 << 5)),
+		Hour:        int(hms >> 12),
+		Minute:      int((hms >> 6) % (1 << 6)),
+		Second:      int(hms % (1 << 6)),
+		Microsecond: int(packed % (1 << 24)),
+		Type:        fieldType,
+		Fsp:         MaxFsp,
+	}
+	if fieldType == TypeDate {
+		t.Fsp = 0
+	}
+	return t
+}
+
+// unpackDuration unpack TIME in packed int64 format of MySQL
+func unpackDuration(packed int64) Duration {
+	d := Duration{Fsp: MaxFsp}
+	if packed < 0 {
+		d.Negative = true
+		packed = -packed
+	}
+	hms := packed >> 24
+	d.Hour = int((hms >> 12) % (1 << 10))
+	d.Minute = int((hms >> 6) % (1 << 6))
+	d.Second = int(hms % (1 << 6))
+	d.Microsecond = int(packed % (1 << 24))
+	return d
+}
+
+// decodeBinaryDecimal decode DECIMAL in binary format of MySQL, 9 digits are stored in 4 bytes
+func decodeBinaryDecimal(precision, scale int, data []byte) (Decimal, error) {
+	compressedBytes := [...]int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+	integral := precision - scale
+	if precision <= 0 || integral < 0 {
+		return Decimal{}, fmt.Errorf("invalid decimal precision %d and scale %d", precision, scale)
+	}
+	uncompIntegral, uncompFractional := integral/9, scale/9
+	compIntegral, compFractional := integral-uncompIntegral*9, scale-uncompFractional*9
+	size := uncompIntegral*4 + compressedBytes[compIntegral] + uncompFractional*4 + compressedBytes[compFractional]
+	if len(data) < size {
+		return Decimal{}, errJSONBinaryTruncated
+	}
+
+	buf := make([]byte, size)
+	copy(buf, data)
+	// the highest bit is 1 for positive number, bytes of negative number are inverted
+	negative := buf[0]&0x80 == 0
+	buf[0] ^= 0x80
+	if negative {
+		for i := range buf {
+			buf[i] = ^buf[i]
+		}
+	}
+	readUint := func(n int) uint64 {
+		var v uint64
+		for _, b := range buf[:n] {
+			v = v<<8 | uint64(b)
+		}
+		buf = buf[n:]
+		return v
+	}
+
+	var sb strings.Builder
+	if negative {
+		sb.WriteByte('-')
+	}
+	sb.WriteString(strconv.FormatUint(readUint(compressedBytes[compIntegral]), 10))
+	for i := 0; i < uncompIntegral; i++ {
+		sb.WriteString(fmt.Sprintf("%09d", readUint(4)))
+	}
+	if scale > 0 {
+		sb.WriteByte('.')
+		for i := 0; i < uncompFractional; i++ {
+			sb.WriteString(fmt.Sprintf("%09d", readUint(4)))
+		}
+		if compFractional > 0 {
+			sb.WriteString(fmt.Sprintf("%0*d", compFractional, readUint(compressedBytes[compFractional])))
+		}
+	}
+	return ParseDecimal(sb.String())
+}
+
+// FormatJSON format JSON value like MySQL, keys of object are sorted by length and then bytes
+func FormatJSON(v interface{}) string {
+	var sb strings.Builder
+	formatJSONValue(&sb, v)
+	return sb.String()
+}
+
+func formatJSONValue(sb *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		sb.WriteString("null")
+	case bool:
+		sb.WriteString(strconv.FormatBool(v))
+	case int64:
+		sb.WriteString(strconv.FormatInt(v, 10))
+	case uint64:
+		sb.WriteString(strconv.FormatUint(v, 10))
+	case float64:
+		sb.WriteString(formatJSONDouble(v))
+	case Decimal:
+		sb.WriteString(v.String())
+	case string:
+		quoteJSONString(sb, v)
+	case DateTime:
+		quoteJSONString(sb, v.String())
+	case Duration:
+		quoteJSONString(sb, v.String())
+	case []interface{}:
+		sb.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			formatJSONValue(sb, e)
+		}
+		sb.WriteByte(']')
+	case map[string]interface{}:
+		sb.WriteByte('{')
+		for i, k := range sortedJSONKeys(v) {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			quoteJSONString(sb, k)
+			sb.WriteString(": ")
+			formatJSONValue(sb, v[k])
+		}
+		sb.WriteByte('}')
+	default:
+		quoteJSONString(sb, fmt.Sprint(v))
+	}
+}
+
+// sortedJSONKeys return keys of object in the order of MySQL
+func sortedJSONKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// formatJSONDouble format double like MySQL, integral value has a fraction part of .0
+func formatJSONDouble(f float64) string {
+	abs := math.Abs(f)
+	if abs != 0 && (abs < 1e-5 || abs >= 1e15) {
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		s = strings.Replace(s, "e+", "e", 1)
+		s = strings.Replace(s, "e-0", "e-", 1)
+		return strings.Replace(s, "e0", "e", 1)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsRune(s, '.') {
+		s += ".0"
+	}
+	return s
+}
+
+func quoteJSONString(sb *strings.Builder, s string) {
+	sb.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"':
+			sb.WriteString(`\"`)
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\b':
+			sb.WriteString(`\b`)
+		case r == '\f':
+			sb.WriteString(`\f`)
+		case r < 0x20:
+			sb.WriteString(fmt.Sprintf(`\u%04x`, r))
+		default:
+			sb.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	sb.WriteByte('"')
+}
+
+// kinds of leg of JSON path
+const (
+	jsonPathKey = iota
+	jsonPathIndex
+	jsonPathKeyWildcard
+	jsonPathIndexWildcard
+	jsonPathDoubleWildcard
+)
+
+type jsonPathLeg struct {
+	kind  int
+	key   string
+	index int
+}
+
+// JSONPath path expression of JSON like $.a[0]."b c".*, ** and wildcards are supported
+type JSONPath struct {
+	path string
+	legs []jsonPathLeg
+}
+
+// ParseJSONPath parse path expression
+func ParseJSONPath(path string) (*JSONPath, error) {
+	p := &JSONPath{path: path}
+	invalid := func(pos int) error {
+		return fmt.Errorf("invalid JSON path expression %q around character position %d", path, pos)
+	}
+
+	s := strings.TrimSpace(path)
+	if !strings.HasPrefix(s, "$") {
+		return nil, invalid(0)
+	}
+	i := 1
+	for {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+
+		switch {
+		case strings.HasPrefix(s[i:], "**"):
+			p.legs = append(p.legs, jsonPathLeg{kind: jsonPathDoubleWildcard})
+			i += 2
+		case s[i] == '.':
+			i++
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+			if i >= len(s) {
+				return nil, invalid(i)
+			}
+			switch {
+			case s[i] == '*':
+				p.legs = append(p.legs, jsonPathLeg{kind: jsonPathKeyWildcard})
+				i++
+			case s[i] == '"':
+				end := i + 1
+				for end < len(s) && s[end] != '"' {
+					if s[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(s) {
+					return nil, invalid(i)
+				}
+				var key string
+				if err := json.Unmarshal([]byte(s[i:end+1]), &key); err != nil {
+					return nil, invalid(i)
+				}
+				p.legs = append(p.legs, jsonPathLeg{kind: jsonPathKey, key: key})
+				i = end + 1
+			default:
+				end := i
+				for end < len(s) && isJSONPathKeyChar(s[end]) {
+					end++
+				}
+				if end == i || (s[i] >= '0' && s[i] <= '9') {
+					return nil, invalid(i)
+				}
+				p.legs = append(p.legs, jsonPathLeg{kind: jsonPathKey, key: s[i:end]})
+				i = end
+			}
+		case s[i] == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end == -1 {
+				return nil, invalid(i)
+			}
+			content := strings.TrimSpace(s[i+1 : i+end])
+			if content == "*" {
+				p.legs = append(p.legs, jsonPathLeg{kind: jsonPathIndexWildcard})
+			} else {
+				index, err := strconv.Atoi(content)
+				if err != nil || index < 0 || !isAllDigits(content) {
+					return nil, invalid(i)
+				}
+				p.legs = append(p.legs, jsonPathLeg{kind: jsonPathIndex, index: index})
+			}
+			i += end + 1
+		default:
+			return nil, invalid(i)
+		}
+	}
+
+	if n := len(p.legs); n > 0 && p.legs[n-1].kind == jsonPathDoubleWildcard {
+		return nil, invalid(len(s))
+	}
+	return p, nil
+}
+
+func isJSONPathKeyChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// String return the path expression
+func (p *JSONPath) String() string {
+	return p.path
+}
+
+// HasWildcard return whether the path contains *, [*] or **
+func (p *JSONPath) HasWildcard() bool {
+	for _, leg := range p.legs {
+		if leg.kind != jsonPathKey && leg.kind != jsonPathIndex {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *JSONPath) extract(v interface{}, legs []jsonPathLeg, matches []interface{}) []interface{} {
+	if len(legs) == 0 {
+		return append(matches, v)
+	}
+
+	leg := legs[0]
+	switch leg.kind {
+	case jsonPathKey:
+		if obj, ok := v.(map[string]interface{}); ok {
+			if child, ok := obj[leg.key]; ok {
+				matches = p.extract(child, legs[1:], matches)
+			}
+		}
+	case jsonPathIndex:
+		if arr, ok := v.([]interface{}); ok {
+			if leg.index < len(arr) {
+				matches = p.extract(arr[leg.index], legs[1:], matches)
+			}
+		} else if leg.index == 0 {
+			// scalar and object are treated as array of one element
+			matches = p.extract(v, legs[1:], matches)
+		}
+	case jsonPathKeyWildcard:
+		if obj, ok := v.(map[string]interface{}); ok {
+			for _, k := range sortedJSONKeys(obj) {
+				matches = p.extract(obj[k], legs[1:], matches)
+			}
+		}
+	case jsonPathIndexWildcard:
+		if arr, ok := v.([]interface{}); ok {
+			for _, e := range arr {
+				matches = p.extract(e, legs[1:], matches)
+			}
+		}
+	case jsonPathDoubleWildcard:
+		matches = p.extract(v, legs[1:], matches)
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for _, k := range sortedJSONKeys(v) {
+				matches = p.extract(v[k], legs, matches)
+			}
+		case []interface{}:
+			for _, e := range v {
+				matches = p.extract(e, legs, matches)
+			}
+		}
+	}
+	return matches
+}
+
+// JSONExtract return value of paths in doc like JSON_EXTRACT, matched values are wrapped in array
+// if there are multiple paths or wildcards in path. ok is false if nothing is matched.
+func JSONExtract(doc interface{}, paths ...*JSONPath) (v interface{}, ok bool) {
+	var matches []interface{}
+	wrap := len(paths) > 1
+	for _, p := range paths {
+		matches = p.extract(doc, p.legs, matches)
+		if p.HasWildcard() {
+			wrap = true
+		}
+	}
+	if len(matches) == 0 {
+		return nil, false
+	}
+	if !wrap {
+		return matches[0], true
+	}
+	return matches, true
+}
+
+// EvalJSONExtract evaluate JSON_EXTRACT(doc, path[, path]...) on value of result, doc is JSON text.
+// Result is JSON text, or NULL if doc is NULL or nothing is matched.
+func EvalJSONExtract(doc interface{}, paths ...string) (interface{}, error) {
+	if doc == nil {
+		return nil, nil
+	}
+	text, ok := stringBytes(doc)
+	if !ok {
+		return nil, fmt.Errorf("invalid JSON document type %T", doc)
+	}
+	v, err := ParseJSON(text)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonPaths := make([]*JSONPath, 0, len(paths))
+	for _, path := range paths {
+		p, err := ParseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		jsonPaths = append(jsonPaths, p)
+	}
+	if r, ok := JSONExtract(v, jsonPaths...); ok {
+		return FormatJSON(r), nil
+	}
+	return nil, nil
+}
+
+// EvalJSONUnquote evaluate JSON_UNQUOTE(JSON_EXTRACT(doc, path)), the ->> operator, on value of result
+func EvalJSONUnquote(doc interface{}, path string) (interface{}, error) {
+	r, err := EvalJSONExtract(doc, path)
+	if err != nil || r == nil {
+		return nil, err
+	}
+	text := r.(string)
+	if !strings.HasPrefix(text, `"`) {
+		return text, nil
+	}
+	var s string
+	if err := json.Unmarshal([]byte(text), &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestParseAndFormatJSON(t *testing.T) {
+	v, err := ParseJSON([]byte(`{"bb": 1.0, "a": [1, -2, 18446744073709551615, 1e20, 0.5, "x\ny<"], "c": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"a": [1, -2, 18446744073709551615, 1e20, 0.5, "x\ny<"], "c": null, "bb": 1.0}`
+	if s := FormatJSON(v); s != expect {
+		t.Errorf("expect %s, got %s", expect, s)
+	}
+
+	for _, s := range []string{"", "{", `{"a": 1} 2`, "[1,]"} {
+		if _, err := ParseJSON([]byte(s)); err == nil {
+			t.Errorf("expect error of parsing %q", s)
+		}
+	}
+}
+
+func TestDecodeJSONBinary(t *testing.T) {
+	// {"a": 1, "b": [true, "x"]}
+	data := []byte{
+		jsonbSmallObject,
+		0x02, 0x00, 0x20, 0x00, // count and size
+		0x12, 0x00, 0x01, 0x00, 0x13, 0x00, 0x01, 0x00, // key entries
+		jsonbInt16, 0x01, 0x00, jsonbSmallArray, 0x14, 0x00, // value entries
+		'a', 'b',
+		0x02, 0x00, 0x0c, 0x00, // array
+		jsonbLiteral, jsonbLiteralTrue, 0x00, jsonbString, 0x0a, 0x00,
+		0x01, 'x',
+	}
+	v, err := DecodeJSONBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := FormatJSON(v); s != `{"a": 1, "b": [true, "x"]}` {
+		t.Errorf("decode object error, got %s", s)
+	}
+	if _, err = DecodeJSONBinary(data[:20]); err == nil {
+		t.Errorf("expect error of truncated data")
+	}
+
+	double := make([]byte, 9)
+	double[0] = jsonbDouble
+	binary.LittleEndian.PutUint64(double[1:], math.Float64bits(-2.5))
+	packed := ((((2020*13+1)<<5|2)<<17|(3<<12|4<<6|5))<<24 | 6)
+	datetime := append([]byte{jsonbOpaque, TypeDatetime, 8}, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(datetime[3:], uint64(packed))
+
+	tests := []struct {
+		data   []byte
+		expect string
+	}{
+		{nil, "null"},
+		{[]byte{jsonbLiteral, jsonbLiteralFalse}, "false"},
+		{[]byte{jsonbInt32, 0xff, 0xff, 0xff, 0xff}, "-1"},
+		{[]byte{jsonbUint64, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "18446744073709551615"},
+		{double, "-2.5"},
+		{[]byte{jsonbString, 0x03, 'a', '"', 'b'}, `"a\"b"`},
+		{[]byte{jsonbOpaque, TypeNewDecimal, 0x06, 0x08, 0x04, 0x84, 0xd2, 0x16, 0x2e}, "1234.5678"},
+		{[]byte{jsonbOpaque, TypeNewDecimal, 0x06, 0x08, 0x04, 0x7b, 0x2d, 0xe9, 0xd1}, "-1234.5678"},
+		{datetime, `"2020-01-02 03:04:05.000006"`},
+		{[]byte{jsonbOpaque, TypeBlob, 0x02, 'h', 'i'}, `"base64:type252:aGk="`},
+	}
+	for _, test := range tests {
+		v, err := DecodeJSONBinary(test.data)
+		if err != nil {
+			t.Errorf("decode %v error: %v", test.data, err)
+			continue
+		}
+		if s := FormatJSON(v); s != test.expect {
+			t.Errorf("decode %v, expect %s, got %s", test.data, test.expect, s)
+		}
+	}
+}
+
+func TestEvalJSONExtract(t *testing.T) {
+	doc := `{"a": {"b": [10, 20, {"c": "x"}]}, "d e": true, "f": [1, 2]}`
+	tests := []struct {
+		paths  []string
+		expect interface{}
+	}{
+		{[]string{"$"}, `{"a": {"b": [10, 20, {"c": "x"}]}, "f": [1, 2], "d e": true}`},
+		{[]string{"$.a.b[1]"}, "20"},
+		{[]string{`$."d e"`}, "true"},
+		{[]string{" $.a.b[2].c "}, `"x"`},
+		{[]string{"$.f[0][0]"}, "1"},
+		{[]string{"$.f[*]"}, "[1, 2]"},
+		{[]string{"$**.c"}, `["x"]`},
+		{[]string{"$.*"}, `[{"b": [10, 20, {"c": "x"}]}, [1, 2], true]`},
+		{[]string{"$.f[0]", "$.x", "$.f[1]"}, "[1, 2]"},
+		{[]string{"$.x"}, nil},
+		{[]string{"$.f[2]"}, nil},
+	}
+	for _, test := range tests {
+		v, err := EvalJSONExtract(doc, test.paths...)
+		if err != nil {
+			t.Errorf("extract %v error: %v", test.paths, err)
+			continue
+		}
+		if v != test.expect {
+			t.Errorf("extract %v, expect %v, got %v", test.paths, test.expect, v)
+		}
+	}
+
+	if v, err := EvalJSONExtract(nil, "$.a"); v != nil || err != nil {
+		t.Errorf("expect NULL for NULL document, got %v, %v", v, err)
+	}
+	if v, err := EvalJSONUnquote([]byte(doc), "$.a.b[2].c"); v != "x" || err != nil {
+		t.Errorf("expect x, got %v, %v", v, err)
+	}
+	if v, err := EvalJSONUnquote(doc, "$.f"); v != "[1, 2]" || err != nil {
+		t.Errorf("expect [1, 2], got %v, %v", v, err)
+	}
+}
+
+func TestParseJSONPathError(t *testing.T) {
+	for _, path := range []string{"", "a", "$.", "$[a]", "$[-1]", "$**", "$.1a", `$."a`, "$.a[0"} {
+		if _, err := ParseJSONPath(path); err == nil {
+			t.Errorf("expect error of parsing %q", path)
+		}
+	}
+}
+
+func TestParseBinaryJSONColumn(t *testing.T) {
+	fields := []*Field{{Type: TypeJSON}}
+	row := RowData(AppendLenEncStringBytes([]byte{OKHeader, 0x00}, []byte(`{"a": 1}`)))
+	values, err := row.ParseBinary(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := AppendBinaryValue(nil, TypeJSON, values[0])
+	if err != nil || string(data) != "\x08{\"a\": 1}" {
+		t.Errorf("round trip of JSON column error, got %q, %v", data, err)
+	}
+}
@@ -96,6 +96,30 @@ func (p RowData) ParseText(f []*Field) ([]interface{}, error) {
 	return data, nil
 }
 
+// TrimText return the row of text format with only the first n columns, the data is shared with p
+func (p RowData) TrimText(n int) (RowData, error) {
+	pos := 0
+	for i := 0; i < n; i++ {
+		var ok bool
+		if _, pos, _, ok = ReadLenEncStringAsBytes(p, pos); !ok {
+			return nil, fmt.Errorf("ReadLenEncStringAsBytes in TrimText failed")
+		}
+	}
+	return p[:pos], nil
+}
+
+// TextColumn return value of column n of the row of text format, the data is shared with p
+func (p RowData) TextColumn(n int) (v []byte, isNull bool, err error) {
+	pos := 0
+	for i := 0; i <= n; i++ {
+		var ok bool
+		if v, pos, isNull, ok = ReadLenEncStringAsBytes(p, pos); !ok {
+			return nil, false, fmt.Errorf("ReadLenEncStringAsBytes in TextColumn failed")
+		}
+	}
+	return v, isNull, nil
+}
+
 // ParseBinary parse binary format data
 func (p RowData) ParseBinary(f []*Field) ([]interface{}, error) {
 	data := make([]interface{}, len(f))
@@ -200,7 +224,7 @@ func (p RowData) ParseBinary(f []*Field) ([]interface{}, error) {
 		case TypeDecimal, TypeNewDecimal, TypeVarchar,
 			TypeBit, TypeEnum, TypeSet, TypeTinyBlob,
 			TypeMediumBlob, TypeLongBlob, TypeBlob,
-			TypeVarString, TypeString, TypeGeometry:
+			TypeVarString, TypeString, TypeGeometry, TypeJSON:
 			var ok = false
 			v, pos, isNull, ok = ReadLenEncStringAsBytes(p, pos)
 			if !ok {
@@ -278,6 +302,9 @@ type Result struct {
 	AffectedRows uint64
 
 	*Resultset
+
+	// Next is the next result of statement returning multiple results, e.g. resultsets and the final OK of CALL
+	Next *Result
 }
 
 // Resultset means mysql results of parser execution, included split table parser
@@ -554,31 +581,11 @@ func BuildBinaryResultset(fields []*Field, values [][]interface{}) (*Resultset,
 		r.Fields[i] = fields[i]
 	}
 
-	bitmapLen := ((len(fields) + 7 + 2) >> 3)
 	for i, v := range values {
-		if len(v) != len(r.Fields) {
-			return nil, fmt.Errorf("row %d has %d columns not equal %d", i, len(v), len(r.Fields))
-		}
-
-		var row []byte
-		nullBitMap := make([]byte, bitmapLen)
-		row = append(row, 0)
-		row = append(row, nullBitMap...)
-		for j, rowVal := range v {
-			if rowVal == nil {
-				bytePos := (j + 2) / 8
-				bitPos := byte((j + 2) % 8)
-				nullBitMap[bytePos] |= 1 << bitPos
-				continue
-			}
-
-			var err error
-			row, err = AppendBinaryValue(row, r.Fields[j].Type, rowVal)
-			if err != nil {
-				return nil, err
-			}
+		row, err := AppendBinaryRow(nil, r.Fields, v)
+		if err != nil {
+			return nil, fmt.Errorf("build binary row %d error: %v", i, err)
 		}
-		copy(row[1:], nullBitMap)
 		r.RowDatas = append(r.RowDatas, row)
 	}
 
@@ -643,6 +650,8 @@ func formatValue(value interface{}) ([]byte, error) {
 		return v, nil
 	case string:
 		return hack.Slice(v), nil
+	case Decimal:
+		return hack.Slice(v.String()), nil
 	default:
 		return nil, fmt.Errorf("invalid type %T", value)
 	}
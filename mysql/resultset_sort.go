@@ -16,6 +16,7 @@ package mysql
 
 import (
 	"bytes"
+	"container/heap"
 	"fmt"
 	"sort"
 
@@ -38,6 +39,30 @@ type SortKey struct {
 
 	//column index of the field
 	Column int
+
+	//field type if the column is DATE, DATETIME, TIMESTAMP or TIME, 0 otherwise.
+	//values of temporal column are compared as temporal values, see SetSortKeyTypes
+	TemporalType uint8
+
+	//collator of string column, nil otherwise.
+	//values of string column are compared by collation, see SetSortKeyTypes
+	Collator Collator
+}
+
+// SetSortKeyTypes set TemporalType and Collator of sort keys by types of their columns
+func SetSortKeyTypes(sk []SortKey, fields []*Field) {
+	for i := range sk {
+		if sk[i].Column >= len(fields) {
+			continue
+		}
+		f := fields[sk[i].Column]
+		if IsTemporalType(f.Type) {
+			sk[i].TemporalType = f.Type
+		}
+		if c, ok := FieldCollator(f); ok {
+			sk[i].Collator = c
+		}
+	}
 }
 
 // ResultsetSorter contains resultset will sort
@@ -60,12 +85,14 @@ func newResultsetSorter(r *Resultset, sk []SortKey) (*ResultsetSorter, error) {
 		}
 	}
 
+	SetSortKeyTypes(sk, r.Fields)
 	s.sk = sk
 
 	return s, nil
 }
 
 func newResultsetSorterWithoutColumnName(r *Resultset, sk []SortKey) *ResultsetSorter {
+	SetSortKeyTypes(sk, r.Fields)
 	return &ResultsetSorter{
 		Resultset: r,
 		sk:        sk,
@@ -81,7 +108,7 @@ func (r *ResultsetSorter) Less(i, j int) bool {
 	v2 := r.Values[j]
 
 	for _, k := range r.sk {
-		v := cmpValue(v1[k.Column], v2[k.Column])
+		v := cmpSortValue(k, v1[k.Column], v2[k.Column])
 
 		if k.Direction == SortDesc {
 			v = -v
@@ -99,6 +126,28 @@ func (r *ResultsetSorter) Less(i, j int) bool {
 	return false
 }
 
+// cmpSortValue compare values of sort key, string values of temporal column are parsed and compared,
+// they are compared as strings if they can't be parsed. Strings of string column are compared by collation.
+func cmpSortValue(k SortKey, v1, v2 interface{}) int {
+	if k.TemporalType != 0 && v1 != nil && v2 != nil {
+		s1, ok1 := stringBytes(v1)
+		s2, ok2 := stringBytes(v2)
+		if ok1 && ok2 {
+			if v, err := CompareTemporal(k.TemporalType, hack.String(s1), hack.String(s2)); err == nil {
+				return v
+			}
+		}
+	}
+	if k.Collator != nil && v1 != nil && v2 != nil {
+		s1, ok1 := stringBytes(v1)
+		s2, ok2 := stringBytes(v2)
+		if ok1 && ok2 {
+			return k.Collator.Compare(s1, s2)
+		}
+	}
+	return cmpValue(v1, v2)
+}
+
 //compare value using asc
 func cmpValue(v1 interface{}, v2 interface{}) int {
 	if v1 == nil && v2 == nil {
@@ -116,6 +165,8 @@ func cmpValue(v1 interface{}, v2 interface{}) int {
 	case []byte:
 		s := v2.([]byte)
 		return bytes.Compare(v, s)
+	case Decimal:
+		return v.Cmp(v2.(Decimal))
 	case int64:
 		s := v2.(int64)
 		if v < s {
@@ -177,3 +228,119 @@ func (r *Resultset) SortWithoutColumnName(sk []SortKey) error {
 	sort.Sort(s)
 	return nil
 }
+
+// sortedResultsetCursor points to the current row of one sorted resultset in k-way merge
+type sortedResultsetCursor struct {
+	r     *Resultset
+	index int
+	row   int
+}
+
+// sortedResultsetHeap is a min heap of cursors, ordered by the current row of each cursor
+type sortedResultsetHeap struct {
+	cursors []*sortedResultsetCursor
+	sk      []SortKey
+}
+
+func (h *sortedResultsetHeap) Len() int {
+	return len(h.cursors)
+}
+
+func (h *sortedResultsetHeap) Less(i, j int) bool {
+	c1, c2 := h.cursors[i], h.cursors[j]
+	if v := CompareRows(c1.r.Values[c1.row], c2.r.Values[c2.row], h.sk); v != 0 {
+		return v < 0
+	}
+
+	// 排序列相同时按结果集顺序输出, 保证结果稳定
+	return c1.index < c2.index
+}
+
+// CompareRows compare two rows by sort keys, return -1 if v1 should be sorted before v2,
+// 1 if v1 should be sorted after v2, and 0 if they are equal in all sort keys.
+func CompareRows(v1, v2 []interface{}, sk []SortKey) int {
+	for _, k := range sk {
+		v := cmpSortValue(k, v1[k.Column], v2[k.Column])
+
+		if k.Direction == SortDesc {
+			v = -v
+		}
+
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+func (h *sortedResultsetHeap) Swap(i, j int) {
+	h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i]
+}
+
+func (h *sortedResultsetHeap) Push(x interface{}) {
+	h.cursors = append(h.cursors, x.(*sortedResultsetCursor))
+}
+
+func (h *sortedResultsetHeap) Pop() interface{} {
+	n := len(h.cursors)
+	c := h.cursors[n-1]
+	h.cursors = h.cursors[:n-1]
+	return c
+}
+
+// MergeSortedResultsets merge resultsets which are already sorted by sk into one sorted resultset, using k-way merge.
+// Fields of the first resultset are used. limit < 0 means all rows are merged,
+// otherwise merging stops after limit rows are taken.
+// RowDatas are kept only if all resultsets have RowDatas of every row.
+func MergeSortedResultsets(rs []*Resultset, sk []SortKey, limit int64) *Resultset {
+	if len(rs) == 0 {
+		return nil
+	}
+
+	SetSortKeyTypes(sk, rs[0].Fields)
+	total := 0
+	withRowData := true
+	h := &sortedResultsetHeap{
+		cursors: make([]*sortedResultsetCursor, 0, len(rs)),
+		sk:      sk,
+	}
+	for i, r := range rs {
+		total += len(r.Values)
+		if len(r.RowDatas) != len(r.Values) {
+			withRowData = false
+		}
+		if len(r.Values) != 0 {
+			h.cursors = append(h.cursors, &sortedResultsetCursor{r: r, index: i})
+		}
+	}
+	if limit >= 0 && int64(total) > limit {
+		total = int(limit)
+	}
+
+	ret := &Resultset{
+		Fields:     rs[0].Fields,
+		FieldNames: rs[0].FieldNames,
+		Values:     make([][]interface{}, 0, total),
+	}
+	if withRowData {
+		ret.RowDatas = make([]RowData, 0, total)
+	}
+
+	heap.Init(h)
+	for h.Len() != 0 && len(ret.Values) < total {
+		c := h.cursors[0]
+		ret.Values = append(ret.Values, c.r.Values[c.row])
+		if withRowData {
+			ret.RowDatas = append(ret.RowDatas, c.r.RowDatas[c.row])
+		}
+
+		c.row++
+		if c.row < len(c.r.Values) {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	return ret
+}
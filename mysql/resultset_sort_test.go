@@ -110,3 +110,49 @@ func TestResultsetSort(t *testing.T) {
 	}
 
 }
+
+func TestMergeSortedResultsets(t *testing.T) {
+	sk := []SortKey{
+		{Column: 0, Direction: SortAsc},
+		{Column: 1, Direction: SortDesc},
+	}
+	rs := []*Resultset{
+		{Values: [][]interface{}{{int64(1), "b"}, {int64(3), "a"}, {int64(5), "a"}}},
+		{Values: [][]interface{}{}},
+		{Values: [][]interface{}{{int64(1), "c"}, {int64(2), "a"}, {int64(5), "a"}, {int64(6), "a"}}},
+		{Values: [][]interface{}{{int64(4), "z"}}},
+	}
+
+	expect := [][]interface{}{
+		{int64(1), "c"},
+		{int64(1), "b"},
+		{int64(2), "a"},
+		{int64(3), "a"},
+		{int64(4), "z"},
+		{int64(5), "a"},
+		{int64(5), "a"},
+		{int64(6), "a"},
+	}
+
+	tests := []struct {
+		limit  int64
+		expect [][]interface{}
+	}{
+		{-1, expect},
+		{0, expect[:0]},
+		{3, expect[:3]},
+		{100, expect},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("limit %d", test.limit), func(t *testing.T) {
+			ret := MergeSortedResultsets(rs, sk, test.limit)
+			if !reflect.DeepEqual(ret.Values, test.expect) {
+				t.Errorf("merge sorted resultsets error, expect: %v, got: %v", test.expect, ret.Values)
+			}
+			if ret.RowDatas != nil {
+				t.Errorf("RowDatas should be nil, got: %v", ret.RowDatas)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+// ParseSessionTrackGTIDs return GTID set in session state information of OK packet, which is
+// reported if session_track_gtids is not OFF, empty if there is no GTID set in data.
+// See: https://dev.mysql.com/doc/internals/en/packet-OK_Packet.html
+func ParseSessionTrackGTIDs(data []byte) string {
+	pos := 0
+	for pos < len(data) {
+		tp := data[pos]
+		entry, next, _, ok := ReadLenEncStringAsBytes(data, pos+1)
+		if !ok {
+			return ""
+		}
+		pos = next
+		if tp != SessionTrackGTIDs || len(entry) == 0 {
+			continue
+		}
+		// the first byte is encoding specification, only 0 (the GTID set string) is defined
+		gtids, _, _, ok := ReadLenEncStringAsBytes(entry, 1)
+		if !ok || entry[0] != 0 {
+			return ""
+		}
+		return string(gtids)
+	}
+	return ""
+}
+
+// AppendSessionTrackSchema append schema change to session state information
+func AppendSessionTrackSchema(data []byte, db string) []byte {
+	return appendSessionTrackEntry(data, SessionTrackSchema, AppendLenEncStringBytes(nil, []byte(db)))
+}
+
+// AppendSessionTrackSystemVariable append system variable change to session state information
+func AppendSessionTrackSystemVariable(data []byte, name, value string) []byte {
+	entry := AppendLenEncStringBytes(nil, []byte(name))
+	entry = AppendLenEncStringBytes(entry, []byte(value))
+	return appendSessionTrackEntry(data, SessionTrackSystemVariables, entry)
+}
+
+// AppendSessionTrackStateChange append the flag that session state has changed to session state information
+func AppendSessionTrackStateChange(data []byte) []byte {
+	return appendSessionTrackEntry(data, SessionTrackStateChange, AppendLenEncStringBytes(nil, []byte("1")))
+}
+
+// AppendSessionTrackTransactionState append transaction state to session state information, e.g. T_R_W___
+func AppendSessionTrackTransactionState(data []byte, state string) []byte {
+	return appendSessionTrackEntry(data, SessionTrackTransactionState, AppendLenEncStringBytes(nil, []byte(state)))
+}
+
+// AppendSessionTrackTransactionCharacteristics append statements to restart transaction with the same characteristics
+// to session state information, e.g. SET TRANSACTION ISOLATION LEVEL READ COMMITTED; START TRANSACTION READ ONLY;
+func AppendSessionTrackTransactionCharacteristics(data []byte, characteristics string) []byte {
+	return appendSessionTrackEntry(data, SessionTrackTransactionCharacteristics, AppendLenEncStringBytes(nil, []byte(characteristics)))
+}
+
+func appendSessionTrackEntry(data []byte, tp byte, entry []byte) []byte {
+	data = append(data, tp)
+	return AppendLenEncStringBytes(data, entry)
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseSessionTrackGTIDs(t *testing.T) {
+	gtids := "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"
+	entry := append([]byte{0}, AppendLenEncStringBytes(nil, []byte(gtids))...)
+
+	data := AppendSessionTrackSchema(nil, "db")
+	data = append(data, SessionTrackGTIDs)
+	data = AppendLenEncStringBytes(data, entry)
+
+	if got := ParseSessionTrackGTIDs(data); got != gtids {
+		t.Errorf("expect %s, got %s", gtids, got)
+	}
+	if got := ParseSessionTrackGTIDs(data[:len(data)-5]); got != "" {
+		t.Errorf("truncated data should return empty, got %s", got)
+	}
+	if got := ParseSessionTrackGTIDs(nil); got != "" {
+		t.Errorf("empty data should return empty, got %s", got)
+	}
+}
+
+func TestAppendSessionTrack(t *testing.T) {
+	var data []byte
+	data = AppendSessionTrackSchema(data, "db")
+	data = AppendSessionTrackSystemVariable(data, "autocommit", "OFF")
+	data = AppendSessionTrackStateChange(data)
+	data = AppendSessionTrackTransactionState(data, "T_______")
+	expect := []byte{
+		SessionTrackSchema, 3, 2, 'd', 'b',
+		SessionTrackSystemVariables, 15, 10, 'a', 'u', 't', 'o', 'c', 'o', 'm', 'm', 'i', 't', 3, 'O', 'F', 'F',
+		SessionTrackStateChange, 2, 1, '1',
+		SessionTrackTransactionState, 9, 8, 'T', '_', '_', '_', '_', '_', '_', '_',
+	}
+	if !bytes.Equal(expect, data) {
+		t.Errorf("expect %v, got %v", expect, data)
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// MaxFsp max fractional seconds precision of temporal types
+	MaxFsp = 6
+	// MaxDurationHour max hour of TIME, the range of TIME is -838:59:59.000000 to 838:59:59.000000
+	MaxDurationHour = 838
+)
+
+// DateTime value of DATE, DATETIME or TIMESTAMP.
+// Zero date like 0000-00-00 and date with zero parts like 2020-00-00 are valid as in MySQL.
+type DateTime struct {
+	Year        int
+	Month       int
+	Day         int
+	Hour        int
+	Minute      int
+	Second      int
+	Microsecond int
+	Type        uint8 // TypeDate, TypeDatetime or TypeTimestamp
+	Fsp         int   // fractional seconds precision
+}
+
+// Duration value of TIME
+type Duration struct {
+	Negative    bool
+	Hour        int
+	Minute      int
+	Second      int
+	Microsecond int
+	Fsp         int // fractional seconds precision
+}
+
+// IsTemporalType return whether field type is DATE, DATETIME, TIMESTAMP or TIME
+func IsTemporalType(typ uint8) bool {
+	switch typ {
+	case TypeDate, TypeNewDate, TypeDatetime, TypeTimestamp, TypeDuration:
+		return true
+	}
+	return false
+}
+
+// ParseDateTime parse string of DATE, DATETIME or TIMESTAMP like MySQL, fractional seconds are rounded to fsp.
+// Formats are 'YYYY-MM-DD hh:mm:ss[.ffffff]' with any punctuation as delimiter, 'T' between date and time,
+// and numbers without delimiter 'YYYYMMDD[hhmmss][.ffffff]', two-digit year is converted to 1970-2069.
+// Time part is dropped for DATE.
+func ParseDateTime(s string, typ uint8, fsp int) (DateTime, error) {
+	t := DateTime{Type: typ, Fsp: fsp}
+	if typ == TypeNewDate {
+		t.Type = TypeDate
+	}
+	if fsp < 0 || fsp > MaxFsp {
+		return t, fmt.Errorf("invalid fsp %d", fsp)
+	}
+
+	str := strings.TrimSpace(s)
+	datePart, timePart, frac := str, "", ""
+	if i := strings.IndexAny(str, " T"); i != -1 {
+		datePart, timePart = str[:i], strings.TrimSpace(str[i+1:])
+	}
+
+	var fields []int
+	var err error
+	if number, fraction := splitFraction(datePart); timePart == "" && isAllDigits(number) && isAllDigits(fraction) {
+		frac = fraction
+		fields, err = splitNumericDateTime(number)
+	} else {
+		if number, fraction := splitFraction(timePart); strings.IndexByte(number, ':') != -1 {
+			timePart, frac = number, fraction
+		}
+		fields, err = splitDelimitedDateTime(datePart, timePart)
+	}
+	if err != nil {
+		return t, fmt.Errorf("invalid %s value %q", temporalTypeName(t.Type), s)
+	}
+	if frac != "" && !isAllDigits(frac) {
+		return t, fmt.Errorf("invalid %s value %q", temporalTypeName(t.Type), s)
+	}
+
+	for len(fields) < 6 {
+		fields = append(fields, 0)
+	}
+	t.Year, t.Month, t.Day, t.Hour, t.Minute, t.Second = fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+	if !isValidDate(t.Year, t.Month, t.Day) || t.Hour > 23 || t.Minute > 59 || t.Second > 59 {
+		return t, fmt.Errorf("invalid %s value %q", temporalTypeName(t.Type), s)
+	}
+
+	if t.Type == TypeDate {
+		t.Hour, t.Minute, t.Second, t.Fsp = 0, 0, 0, 0
+		return t, nil
+	}
+	microsecond, carry := roundFraction(frac, fsp)
+	t.Microsecond = microsecond
+	if carry {
+		t = t.addSecond()
+	}
+	return t, nil
+}
+
+// ParseDuration parse string of TIME like MySQL, fractional seconds are rounded to fsp.
+// Formats are '[-][D ]hh:mm:ss[.ffffff]', 'hh:mm', and numbers without delimiter '[-][hhh]mmss[.ffffff]'.
+func ParseDuration(s string, fsp int) (Duration, error) {
+	d := Duration{Fsp: fsp}
+	if fsp < 0 || fsp > MaxFsp {
+		return d, fmt.Errorf("invalid fsp %d", fsp)
+	}
+	invalid := fmt.Errorf("invalid TIME value %q", s)
+
+	str := strings.TrimSpace(s)
+	if strings.HasPrefix(str, "-") {
+		d.Negative = true
+		str = str[1:]
+	}
+	str, frac := splitFraction(str)
+	if !isAllDigits(frac) {
+		return d, invalid
+	}
+
+	days := 0
+	if i := strings.IndexByte(str, ' '); i != -1 {
+		var err error
+		if days, err = strconv.Atoi(str[:i]); err != nil || days < 0 {
+			return d, invalid
+		}
+		str = strings.TrimSpace(str[i+1:])
+	}
+
+	var fields []int
+	if strings.IndexByte(str, ':') != -1 {
+		for _, f := range strings.Split(str, ":") {
+			n, err := strconv.Atoi(f)
+			if err != nil || n < 0 || !isAllDigits(f) {
+				return d, invalid
+			}
+			fields = append(fields, n)
+		}
+		if len(fields) > 3 {
+			return d, invalid
+		}
+		for len(fields) < 3 {
+			fields = append(fields, 0)
+		}
+	} else {
+		if str == "" || !isAllDigits(str) || days != 0 {
+			return d, invalid
+		}
+		// numbers are read from the right: ss, mmss, hhmmss
+		n, _ := strconv.Atoi(str)
+		fields = []int{n / 10000, n / 100 % 100, n % 100}
+	}
+	if fields[1] > 59 || fields[2] > 59 {
+		return d, invalid
+	}
+
+	d.Hour, d.Minute, d.Second = days*24+fields[0], fields[1], fields[2]
+	microsecond, carry := roundFraction(frac, fsp)
+	d.Microsecond = microsecond
+	if carry {
+		total := int64(d.Hour)*3600 + int64(d.Minute)*60 + int64(d.Second) + 1
+		d.Hour, d.Minute, d.Second = int(total/3600), int(total/60%60), int(total%60)
+	}
+	if d.Hour > MaxDurationHour || (d.Hour == MaxDurationHour && (d.Minute > 59 || d.Second > 59 || d.Microsecond > 0)) {
+		return d, fmt.Errorf("TIME value %q is out of range", s)
+	}
+	if d.Hour == 0 && d.Minute == 0 && d.Second == 0 && d.Microsecond == 0 {
+		d.Negative = false
+	}
+	return d, nil
+}
+
+// CompareTemporal compare two strings of temporal type typ as typed values
+func CompareTemporal(typ uint8, s1, s2 string) (int, error) {
+	if typ == TypeDuration {
+		d1, err := ParseDuration(s1, MaxFsp)
+		if err != nil {
+			return 0, err
+		}
+		d2, err := ParseDuration(s2, MaxFsp)
+		if err != nil {
+			return 0, err
+		}
+		return d1.Compare(d2), nil
+	}
+
+	t1, err := ParseDateTime(s1, typ, MaxFsp)
+	if err != nil {
+		return 0, err
+	}
+	t2, err := ParseDateTime(s2, typ, MaxFsp)
+	if err != nil {
+		return 0, err
+	}
+	return t1.Compare(t2), nil
+}
+
+// IsZero return whether t is zero date 0000-00-00 00:00:00
+func (t DateTime) IsZero() bool {
+	return t.Year == 0 && t.Month == 0 && t.Day == 0 && t.Hour == 0 && t.Minute == 0 && t.Second == 0 && t.Microsecond == 0
+}
+
+// Compare compare t and t2, return -1, 0 or 1
+func (t DateTime) Compare(t2 DateTime) int {
+	a := [...]int{t.Year, t.Month, t.Day, t.Hour, t.Minute, t.Second, t.Microsecond}
+	b := [...]int{t2.Year, t2.Month, t2.Day, t2.Hour, t2.Minute, t2.Second, t2.Microsecond}
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		} else if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Time convert t to time.Time in loc, zero date and date with zero parts can't be converted
+func (t DateTime) Time(loc *time.Location) (time.Time, error) {
+	if t.Month == 0 || t.Day == 0 {
+		return time.Time{}, fmt.Errorf("%s can not be converted to time", t.String())
+	}
+	return time.Date(t.Year, time.Month(t.Month), t.Day, t.Hour, t.Minute, t.Second, t.Microsecond*1000, loc), nil
+}
+
+// String format t like MySQL, fractional seconds are formatted in fsp digits
+func (t DateTime) String() string {
+	s := fmt.Sprintf("%04d-%02d-%02d", t.Year, t.Month, t.Day)
+	if t.Type == TypeDate {
+		return s
+	}
+	return s + fmt.Sprintf(" %02d:%02d:%02d", t.Hour, t.Minute, t.Second) + formatFraction(t.Microsecond, t.Fsp)
+}
+
+func (t DateTime) addSecond() DateTime {
+	if t.Month == 0 || t.Day == 0 {
+		// date with zero parts can't be normalized, keep the second
+		t.Microsecond = 999999
+		return t
+	}
+	tm := time.Date(t.Year, time.Month(t.Month), t.Day, t.Hour, t.Minute, t.Second+1, 0, time.UTC)
+	t.Year, t.Month, t.Day = tm.Year(), int(tm.Month()), tm.Day()
+	t.Hour, t.Minute, t.Second = tm.Hour(), tm.Minute(), tm.Second()
+	return t
+}
+
+// Microseconds return the signed count of microseconds of d
+func (d Duration) Microseconds() int64 {
+	n := ((int64(d.Hour)*60+int64(d.Minute))*60+int64(d.Second))*1000000 + int64(d.Microsecond)
+	if d.Negative {
+		return -n
+	}
+	return n
+}
+
+// Compare compare d and d2, return -1, 0 or 1
+func (d Duration) Compare(d2 Duration) int {
+	n1, n2 := d.Microseconds(), d2.Microseconds()
+	if n1 < n2 {
+		return -1
+	} else if n1 > n2 {
+		return 1
+	}
+	return 0
+}
+
+// String format d like MySQL, fractional seconds are formatted in fsp digits
+func (d Duration) String() string {
+	sign := ""
+	if d.Negative {
+		sign = "-"
+	}
+	return fmt.Sprintf("%s%02d:%02d:%02d", sign, d.Hour, d.Minute, d.Second) + formatFraction(d.Microsecond, d.Fsp)
+}
+
+func temporalTypeName(typ uint8) string {
+	switch typ {
+	case TypeDate:
+		return "DATE"
+	case TypeTimestamp:
+		return "TIMESTAMP"
+	default:
+		return "DATETIME"
+	}
+}
+
+// splitFraction split s by the last '.', fraction is empty if there is no '.'
+func splitFraction(s string) (string, string) {
+	if i := strings.LastIndexByte(s, '.'); i != -1 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// splitDelimitedDateTime split date and time separated by any punctuation
+func splitDelimitedDateTime(datePart, timePart string) ([]int, error) {
+	dateFields := strings.FieldsFunc(datePart, isTemporalDelimiter)
+	if len(dateFields) != 3 {
+		return nil, fmt.Errorf("invalid date")
+	}
+	timeFields := strings.FieldsFunc(timePart, isTemporalDelimiter)
+	if len(timeFields) > 3 {
+		return nil, fmt.Errorf("invalid time")
+	}
+
+	var fields []int
+	for _, f := range append(dateFields, timeFields...) {
+		n, err := strconv.Atoi(f)
+		if err != nil || !isAllDigits(f) {
+			return nil, fmt.Errorf("invalid number %s", f)
+		}
+		fields = append(fields, n)
+	}
+	if len(dateFields[0]) <= 2 {
+		fields[0] = twoDigitYear(fields[0])
+	}
+	return fields, nil
+}
+
+func isTemporalDelimiter(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// splitNumericDateTime split number like YYYYMMDDhhmmss or YYMMDDhhmmss
+func splitNumericDateTime(s string) ([]int, error) {
+	var widths []int
+	switch len(s) {
+	case 14, 8:
+		widths = []int{4, 2, 2, 2, 2, 2}
+	case 12, 6:
+		widths = []int{2, 2, 2, 2, 2, 2}
+	default:
+		return nil, fmt.Errorf("invalid length")
+	}
+
+	var fields []int
+	for pos, i := 0, 0; pos < len(s); i++ {
+		n, _ := strconv.Atoi(s[pos : pos+widths[i]])
+		fields = append(fields, n)
+		pos += widths[i]
+	}
+	if widths[0] == 2 {
+		fields[0] = twoDigitYear(fields[0])
+	}
+	return fields, nil
+}
+
+// twoDigitYear convert year 70-99 to 1970-1999, and 00-69 to 2000-2069
+func twoDigitYear(year int) int {
+	if year >= 70 {
+		return 1900 + year
+	}
+	return 2000 + year
+}
+
+func isValidDate(year, month, day int) bool {
+	if year > 9999 || month > 12 || day > 31 {
+		return false
+	}
+	if month == 0 || day == 0 {
+		return true
+	}
+	// the day after the last day of month is normalized to the next month
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC).Day() == day
+}
+
+// roundFraction round fraction digits to fsp digits, return microseconds and whether it's carried to second
+func roundFraction(frac string, fsp int) (int, bool) {
+	if frac == "" {
+		return 0, false
+	}
+	digits := frac
+	if len(digits) > fsp {
+		digits = digits[:fsp]
+	}
+	n := 0
+	if digits != "" {
+		n, _ = strconv.Atoi(digits)
+	}
+	if len(frac) > fsp && frac[fsp] >= '5' {
+		n++
+	}
+	for i := len(digits); i < MaxFsp; i++ {
+		n *= 10
+	}
+	if n >= 1000000 {
+		return 0, true
+	}
+	return n, false
+}
+
+func formatFraction(microsecond, fsp int) string {
+	if fsp <= 0 {
+		return ""
+	}
+	return "." + fmt.Sprintf("%06d", microsecond)[:fsp]
+}
+
+func isAllDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
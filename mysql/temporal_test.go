@@ -0,0 +1,158 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDateTime(t *testing.T) {
+	tests := []struct {
+		s      string
+		typ    uint8
+		fsp    int
+		expect string
+	}{
+		{"2020-01-02", TypeDatetime, 0, "2020-01-02 00:00:00"},
+		{"2020-01-02 03:04:05", TypeDate, 0, "2020-01-02"},
+		{" 2020/1/2T3:4:5 ", TypeDatetime, 0, "2020-01-02 03:04:05"},
+		{"20-01-02 03:04", TypeDatetime, 0, "2020-01-02 03:04:00"},
+		{"99-01-02", TypeDate, 0, "1999-01-02"},
+		{"20200102030405", TypeDatetime, 0, "2020-01-02 03:04:05"},
+		{"20200102030405.123", TypeDatetime, 3, "2020-01-02 03:04:05.123"},
+		{"200102", TypeDate, 0, "2020-01-02"},
+		{"2020-01-02 03:04:05.1234567", TypeDatetime, 6, "2020-01-02 03:04:05.123457"},
+		{"2020-01-02 03:04:05.5", TypeTimestamp, 0, "2020-01-02 03:04:06"},
+		{"2020-12-31 23:59:59.9999", TypeDatetime, 2, "2021-01-01 00:00:00.00"},
+		{"0000-00-00 00:00:00", TypeDatetime, 0, "0000-00-00 00:00:00"},
+		{"2020-02-00", TypeDate, 0, "2020-02-00"},
+	}
+	for _, test := range tests {
+		v, err := ParseDateTime(test.s, test.typ, test.fsp)
+		if err != nil {
+			t.Errorf("parse %q error: %v", test.s, err)
+			continue
+		}
+		if v.String() != test.expect {
+			t.Errorf("parse %q, expect %s, got %s", test.s, test.expect, v.String())
+		}
+	}
+
+	for _, s := range []string{"", "2020", "2020-13-01", "2021-02-29", "2020-01-02 24:00:00", "2020-01-02 aa:00:00", "2020-01-02 00:00:00.1x", "202001"} {
+		if _, err := ParseDateTime(s, TypeDatetime, 0); err == nil {
+			t.Errorf("expect error of parsing %q", s)
+		}
+	}
+	if _, err := ParseDateTime("2020-01-02", TypeDatetime, 7); err == nil {
+		t.Errorf("expect error of invalid fsp")
+	}
+}
+
+func TestDateTimeTime(t *testing.T) {
+	v, _ := ParseDateTime("2020-01-02 03:04:05.000006", TypeDatetime, 6)
+	tm, err := v.Time(time.UTC)
+	if err != nil || !tm.Equal(time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)) {
+		t.Errorf("convert to time error: %v, %v", tm, err)
+	}
+
+	zero, _ := ParseDateTime("0000-00-00", TypeDate, 0)
+	if !zero.IsZero() {
+		t.Errorf("expect zero date")
+	}
+	if _, err := zero.Time(time.UTC); err == nil {
+		t.Errorf("zero date should not be converted to time")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s      string
+		fsp    int
+		expect string
+	}{
+		{"12:34:56", 0, "12:34:56"},
+		{"-838:59:59", 0, "-838:59:59"},
+		{"1 02:03:04", 0, "26:03:04"},
+		{"12:34", 0, "12:34:00"},
+		{"123456", 0, "12:34:56"},
+		{"3456.78", 2, "00:34:56.78"},
+		{"56", 0, "00:00:56"},
+		{"00:00:59.5", 0, "00:01:00"},
+		{"-00:00:00.1", 0, "00:00:00"},
+		{"10:00:00.123", 6, "10:00:00.123000"},
+	}
+	for _, test := range tests {
+		d, err := ParseDuration(test.s, test.fsp)
+		if err != nil {
+			t.Errorf("parse %q error: %v", test.s, err)
+			continue
+		}
+		if d.String() != test.expect {
+			t.Errorf("parse %q, expect %s, got %s", test.s, test.expect, d.String())
+		}
+	}
+
+	for _, s := range []string{"", "839:00:00", "838:59:59.1", "12:60:00", "1:2:3:4", "a", "1 1234"} {
+		if _, err := ParseDuration(s, 1); err == nil {
+			t.Errorf("expect error of parsing %q", s)
+		}
+	}
+}
+
+func TestCompareTemporal(t *testing.T) {
+	tests := []struct {
+		typ    uint8
+		s1, s2 string
+		expect int
+	}{
+		{TypeDuration, "-01:00:00", "00:30:00", -1},
+		{TypeDuration, "100:00:00", "99:00:00", 1},
+		{TypeDuration, "10:00:00.5", "10:00:00.500", 0},
+		{TypeDatetime, "2020-01-02 03:04:05.1", "2020-01-02 03:04:05", 1},
+		{TypeDatetime, "2020-1-2", "2020-01-02 00:00:00", 0},
+		{TypeDate, "0000-00-00", "2020-01-01", -1},
+		{TypeTimestamp, "2020-01-02 10:00:00", "2020-01-02 9:00:00", 1},
+	}
+	for _, test := range tests {
+		v, err := CompareTemporal(test.typ, test.s1, test.s2)
+		if err != nil || v != test.expect {
+			t.Errorf("compare %s and %s, expect %d, got %d, %v", test.s1, test.s2, test.expect, v, err)
+		}
+	}
+}
+
+func TestSortTemporalColumn(t *testing.T) {
+	r := &Resultset{
+		Fields: []*Field{{Type: TypeDuration}},
+		Values: [][]interface{}{{"100:00:00"}, {"-01:00:00"}, {nil}, {"9:00:00"}},
+	}
+	if err := r.SortWithoutColumnName([]SortKey{{Column: 0, Direction: SortAsc}}); err != nil {
+		t.Fatal(err)
+	}
+	expect := [][]interface{}{{nil}, {"-01:00:00"}, {"9:00:00"}, {"100:00:00"}}
+	if !reflect.DeepEqual(r.Values, expect) {
+		t.Errorf("sort TIME column error, expect %v, got %v", expect, r.Values)
+	}
+
+	r1 := &Resultset{Fields: r.Fields, Values: [][]interface{}{{"-02:00:00"}, {"10:00:00"}}}
+	r2 := &Resultset{Fields: r.Fields, Values: [][]interface{}{{"9:00:00"}, {"100:00:00"}}}
+	merged := MergeSortedResultsets([]*Resultset{r1, r2}, []SortKey{{Column: 0, Direction: SortAsc}}, -1)
+	expect = [][]interface{}{{"-02:00:00"}, {"9:00:00"}, {"10:00:00"}, {"100:00:00"}}
+	if !reflect.DeepEqual(merged.Values, expect) {
+		t.Errorf("merge TIME column error, expect %v, got %v", expect, merged.Values)
+	}
+}
@@ -0,0 +1,406 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Values evaluated here are values of Resultset: nil(NULL), int64, uint64, float64, string, []byte or Decimal.
+// They are evaluated like MySQL does, so residues of WHERE, HAVING clauses and computed ORDER BY keys
+// can be evaluated by proxy when results of shards are merged.
+
+// CompareOp comparison operator
+type CompareOp int
+
+const (
+	// CompareEQ =
+	CompareEQ CompareOp = iota
+	// CompareNE <> or !=
+	CompareNE
+	// CompareLT <
+	CompareLT
+	// CompareLE <=
+	CompareLE
+	// CompareGT >
+	CompareGT
+	// CompareGE >=
+	CompareGE
+	// CompareNullSafeEQ <=>
+	CompareNullSafeEQ
+)
+
+var compareOpNames = map[CompareOp]string{
+	CompareEQ:         "=",
+	CompareNE:         "<>",
+	CompareLT:         "<",
+	CompareLE:         "<=",
+	CompareGT:         ">",
+	CompareGE:         ">=",
+	CompareNullSafeEQ: "<=>",
+}
+
+// String return operator in sql
+func (op CompareOp) String() string {
+	if name, ok := compareOpNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("CompareOp(%d)", int(op))
+}
+
+// AddValues return v1 + v2
+func AddValues(v1, v2 interface{}) (interface{}, error) {
+	return evalArithmetic('+', v1, v2)
+}
+
+// SubValues return v1 - v2
+func SubValues(v1, v2 interface{}) (interface{}, error) {
+	return evalArithmetic('-', v1, v2)
+}
+
+// MulValues return v1 * v2
+func MulValues(v1, v2 interface{}) (interface{}, error) {
+	return evalArithmetic('*', v1, v2)
+}
+
+// DivValues return v1 / v2, result is float64 and NULL if v2 is zero.
+// MySQL returns DECIMAL when both are integers, the precision may be lost here.
+func DivValues(v1, v2 interface{}) (interface{}, error) {
+	return evalArithmetic('/', v1, v2)
+}
+
+// evalArithmetic integers are evaluated as integers and result is unsigned if one of them is unsigned,
+// Decimal with integer or Decimal is evaluated as Decimal except division, otherwise they are evaluated
+// as float64. Strings are converted to numbers.
+func evalArithmetic(op byte, v1, v2 interface{}) (interface{}, error) {
+	if v1 == nil || v2 == nil {
+		return nil, nil
+	}
+	if d1, d2, ok := decimalOperands(v1, v2); ok && op != '/' {
+		switch op {
+		case '+':
+			return d1.Add(d2), nil
+		case '-':
+			return d1.Sub(d2), nil
+		default:
+			return d1.Mul(d2), nil
+		}
+	}
+	n1, err := toNumber(v1)
+	if err != nil {
+		return nil, err
+	}
+	n2, err := toNumber(v2)
+	if err != nil {
+		return nil, err
+	}
+	expr := fmt.Sprintf("(%v %c %v)", n1, op, n2)
+
+	if op == '/' {
+		f2 := toFloat(n2)
+		if f2 == 0 {
+			return nil, nil
+		}
+		return checkFloat(toFloat(n1)/f2, expr)
+	}
+	if isInteger(n1) && isInteger(n2) {
+		return intArithmetic(op, n1, n2, expr)
+	}
+
+	f1, f2 := toFloat(n1), toFloat(n2)
+	switch op {
+	case '+':
+		return checkFloat(f1+f2, expr)
+	case '-':
+		return checkFloat(f1-f2, expr)
+	default:
+		return checkFloat(f1*f2, expr)
+	}
+}
+
+func intArithmetic(op byte, n1, n2 interface{}, expr string) (interface{}, error) {
+	x, y := toBigInt(n1), toBigInt(n2)
+	r := new(big.Int)
+	switch op {
+	case '+':
+		r.Add(x, y)
+	case '-':
+		r.Sub(x, y)
+	default:
+		r.Mul(x, y)
+	}
+
+	_, unsigned1 := n1.(uint64)
+	_, unsigned2 := n2.(uint64)
+	if unsigned1 || unsigned2 {
+		if r.Sign() < 0 || !r.IsUint64() {
+			return nil, NewDefaultError(ErrDataOutOfRange, "BIGINT UNSIGNED", expr)
+		}
+		return r.Uint64(), nil
+	}
+	if !r.IsInt64() {
+		return nil, NewDefaultError(ErrDataOutOfRange, "BIGINT", expr)
+	}
+	return r.Int64(), nil
+}
+
+func checkFloat(f float64, expr string) (interface{}, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, NewDefaultError(ErrDataOutOfRange, "DOUBLE", expr)
+	}
+	return f, nil
+}
+
+// EvalCompare evaluate comparison, result is 1, 0 or NULL like MySQL.
+// NULL is returned if one of values is NULL, except <=> which treats two NULLs as equal.
+func EvalCompare(op CompareOp, v1, v2 interface{}, collation CollationID) (interface{}, error) {
+	if op == CompareNullSafeEQ {
+		equal, err := NullSafeEqual(v1, v2, collation)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(equal), nil
+	}
+	cmp, isNull, err := CompareValues(v1, v2, collation)
+	if err != nil {
+		return nil, err
+	}
+	if isNull {
+		return nil, nil
+	}
+
+	switch op {
+	case CompareEQ:
+		return boolValue(cmp == 0), nil
+	case CompareNE:
+		return boolValue(cmp != 0), nil
+	case CompareLT:
+		return boolValue(cmp < 0), nil
+	case CompareLE:
+		return boolValue(cmp <= 0), nil
+	case CompareGT:
+		return boolValue(cmp > 0), nil
+	case CompareGE:
+		return boolValue(cmp >= 0), nil
+	default:
+		return nil, fmt.Errorf("unsupported comparison operator %v", op)
+	}
+}
+
+// CompareValues compare two values, isNull is true if one of them is NULL.
+// Two strings are compared by collation, two integers are compared as integers, Decimal with integer
+// or Decimal is compared exactly, others are converted to float64 and compared.
+func CompareValues(v1, v2 interface{}, collation CollationID) (cmp int, isNull bool, err error) {
+	if v1 == nil || v2 == nil {
+		return 0, true, nil
+	}
+	s1, ok1 := stringBytes(v1)
+	s2, ok2 := stringBytes(v2)
+	if ok1 && ok2 {
+		return CompareStrings(s1, s2, collation), false, nil
+	}
+	if d1, d2, ok := decimalOperands(v1, v2); ok {
+		return d1.Cmp(d2), false, nil
+	}
+
+	n1, err := toNumber(v1)
+	if err != nil {
+		return 0, false, err
+	}
+	n2, err := toNumber(v2)
+	if err != nil {
+		return 0, false, err
+	}
+	if isInteger(n1) && isInteger(n2) {
+		return toBigInt(n1).Cmp(toBigInt(n2)), false, nil
+	}
+	f1, f2 := toFloat(n1), toFloat(n2)
+	switch {
+	case f1 < f2:
+		return -1, false, nil
+	case f1 > f2:
+		return 1, false, nil
+	default:
+		return 0, false, nil
+	}
+}
+
+// CompareStrings compare strings by collation, see GetCollator
+func CompareStrings(s1, s2 []byte, collation CollationID) int {
+	return GetCollator(collation).Compare(s1, s2)
+}
+
+// IsTrueValue return whether value is true in condition, NULL and zero are false
+func IsTrueValue(v interface{}) (bool, error) {
+	if v == nil {
+		return false, nil
+	}
+	n, err := toNumber(v)
+	if err != nil {
+		return false, err
+	}
+	return toFloat(n) != 0, nil
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func stringBytes(v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), true
+	case []byte:
+		return v, true
+	}
+	return nil, false
+}
+
+// toNumber convert value to int64, uint64 or float64, Decimal is converted to float64
+func toNumber(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64, uint64, float64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint:
+		return uint64(v), nil
+	case uint8:
+		return uint64(v), nil
+	case uint16:
+		return uint64(v), nil
+	case uint32:
+		return uint64(v), nil
+	case float32:
+		return float64(v), nil
+	case Decimal:
+		return v.Float64(), nil
+	case string:
+		return parseNumberPrefix(v), nil
+	case []byte:
+		return parseNumberPrefix(string(v)), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// parseNumberPrefix convert string to float64 like MySQL, leading spaces are skipped and the longest
+// numeric prefix is parsed, 0 is returned if there is none
+func parseNumberPrefix(s string) float64 {
+	s = strings.TrimLeft(s, " \t\r\n")
+	i := 0
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := 0
+	for ; i < len(s) && isDigit(s[i]); i++ {
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		for ; i < len(s) && isDigit(s[i]); i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0
+	}
+	end := i
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			for j < len(s) && isDigit(s[j]) {
+				j++
+			}
+			end = j
+		}
+	}
+	// value out of range is parsed as +/-Inf with error
+	f, _ := strconv.ParseFloat(s[:end], 64)
+	if math.IsInf(f, 0) {
+		return math.Copysign(math.MaxFloat64, f)
+	}
+	return f
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// decimalOperands convert values to Decimal if one of them is Decimal and the other is Decimal or integer
+func decimalOperands(v1, v2 interface{}) (Decimal, Decimal, bool) {
+	_, isDecimal1 := v1.(Decimal)
+	_, isDecimal2 := v2.(Decimal)
+	if !isDecimal1 && !isDecimal2 {
+		return Decimal{}, Decimal{}, false
+	}
+	d1, ok1 := exactDecimal(v1)
+	d2, ok2 := exactDecimal(v2)
+	return d1, d2, ok1 && ok2
+}
+
+func exactDecimal(v interface{}) (Decimal, bool) {
+	switch v := v.(type) {
+	case Decimal:
+		return v, true
+	case int64:
+		return NewDecimalFromInt(v), true
+	case uint64:
+		return NewDecimalFromUint(v), true
+	}
+	return Decimal{}, false
+}
+
+func isInteger(n interface{}) bool {
+	switch n.(type) {
+	case int64, uint64:
+		return true
+	}
+	return false
+}
+
+func toFloat(n interface{}) float64 {
+	switch n := n.(type) {
+	case int64:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	default:
+		return n.(float64)
+	}
+}
+
+func toBigInt(n interface{}) *big.Int {
+	if u, ok := n.(uint64); ok {
+		return new(big.Int).SetUint64(u)
+	}
+	return big.NewInt(n.(int64))
+}
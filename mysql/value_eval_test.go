@@ -0,0 +1,204 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestArithmeticValues(t *testing.T) {
+	tests := []struct {
+		fn     func(v1, v2 interface{}) (interface{}, error)
+		v1, v2 interface{}
+		expect interface{}
+	}{
+		{AddValues, int64(1), int64(2), int64(3)},
+		{AddValues, int64(-1), uint64(2), uint64(1)},
+		{AddValues, int64(1), nil, nil},
+		{AddValues, int64(1), 1.5, 2.5},
+		{AddValues, "12abc", int64(1), float64(13)},
+		{AddValues, []byte(" 1e2x"), "abc", float64(100)},
+		{SubValues, int64(1), int64(3), int64(-2)},
+		{MulValues, uint64(3), int64(4), uint64(12)},
+		{MulValues, "-2.5", int64(2), float64(-5)},
+		{DivValues, int64(7), int64(2), 3.5},
+		{DivValues, int64(7), int64(0), nil},
+		{DivValues, int64(7), "a", nil},
+		{DivValues, nil, int64(1), nil},
+	}
+	for i, test := range tests {
+		v, err := test.fn(test.v1, test.v2)
+		if err != nil {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.expect) {
+			t.Errorf("test %d: expect %#v, got %#v", i, test.expect, v)
+		}
+	}
+}
+
+func TestArithmeticOutOfRange(t *testing.T) {
+	tests := []struct {
+		fn     func(v1, v2 interface{}) (interface{}, error)
+		v1, v2 interface{}
+	}{
+		{AddValues, int64(math.MaxInt64), int64(1)},
+		{SubValues, int64(math.MinInt64), int64(1)},
+		{MulValues, int64(math.MaxInt64), int64(2)},
+		{SubValues, uint64(1), int64(2)},
+		{AddValues, uint64(math.MaxUint64), uint64(1)},
+		{MulValues, math.MaxFloat64, int64(2)},
+	}
+	for i, test := range tests {
+		_, err := test.fn(test.v1, test.v2)
+		if e, ok := err.(*SQLError); !ok || e.SQLCode() != ErrDataOutOfRange {
+			t.Errorf("test %d: expect out of range error, got %v", i, err)
+		}
+	}
+
+	if _, err := AddValues(int64(1), struct{}{}); err == nil {
+		t.Errorf("expect error of unsupported type")
+	}
+}
+
+func TestDecimalValues(t *testing.T) {
+	d1, _ := ParseDecimal("0.1")
+	d2, _ := ParseDecimal("0.20")
+	tests := []struct {
+		fn     func(v1, v2 interface{}) (interface{}, error)
+		v1, v2 interface{}
+		expect string
+	}{
+		{AddValues, d1, d2, "0.30"},
+		{SubValues, d1, int64(1), "-0.9"},
+		{MulValues, uint64(3), d2, "0.60"},
+	}
+	for i, test := range tests {
+		v, err := test.fn(test.v1, test.v2)
+		if err != nil {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+		if d, ok := v.(Decimal); !ok || d.String() != test.expect {
+			t.Errorf("test %d: expect %s, got %#v", i, test.expect, v)
+		}
+	}
+
+	if v, _ := DivValues(d2, int64(4)); v != 0.05 {
+		t.Errorf("expect 0.05, got %#v", v)
+	}
+	sum, _ := AddValues(d1, d2)
+	if v, _ := EvalCompare(CompareEQ, sum, mustParseDecimal(t, "0.3"), 0); v != int64(1) {
+		t.Errorf("expect 0.1 + 0.20 = 0.3, got %#v", v)
+	}
+	if v, _ := EvalCompare(CompareGT, d2, 0.15, 0); v != int64(1) {
+		t.Errorf("expect 0.20 > 0.15, got %#v", v)
+	}
+	if ok, _ := IsTrueValue(d1); !ok {
+		t.Errorf("expect 0.1 is true")
+	}
+}
+
+func TestEvalCompare(t *testing.T) {
+	utf8CI := CollationIds["utf8_general_ci"]
+	tests := []struct {
+		op        CompareOp
+		v1, v2    interface{}
+		collation CollationID
+		expect    interface{}
+	}{
+		{CompareEQ, int64(1), uint64(1), 0, int64(1)},
+		{CompareLT, int64(-1), uint64(math.MaxUint64), 0, int64(1)},
+		{CompareGT, int64(9007199254740993), int64(9007199254740992), 0, int64(1)},
+		{CompareEQ, int64(1), 1.0, 0, int64(1)},
+		{CompareEQ, "1abc", int64(1), 0, int64(1)},
+		{CompareLT, "10", int64(9), 0, int64(0)},
+		{CompareLT, "10", "9", utf8CI, int64(1)},
+		{CompareNE, int64(1), int64(2), 0, int64(1)},
+		{CompareLE, 2.5, int64(2), 0, int64(0)},
+		{CompareGE, int64(2), int64(2), 0, int64(1)},
+		{CompareEQ, nil, int64(1), 0, nil},
+		{CompareNE, nil, nil, 0, nil},
+		{CompareNullSafeEQ, nil, nil, 0, int64(1)},
+		{CompareNullSafeEQ, nil, int64(1), 0, int64(0)},
+		{CompareNullSafeEQ, int64(1), int64(1), 0, int64(1)},
+	}
+	for i, test := range tests {
+		v, err := EvalCompare(test.op, test.v1, test.v2, test.collation)
+		if err != nil {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.expect) {
+			t.Errorf("test %d: %v %s %v expect %#v, got %#v", i, test.v1, test.op, test.v2, test.expect, v)
+		}
+	}
+}
+
+func TestCompareStrings(t *testing.T) {
+	tests := []struct {
+		s1, s2    string
+		collation string
+		expect    int
+	}{
+		{"abc", "ABC", "utf8_general_ci", 0},
+		{"abc ", "ABC", "utf8mb4_general_ci", 0},
+		{"straße", "STRASSE", "utf8mb4_general_ci", -1},
+		{"Éa", "éA", "utf8mb4_general_ci", 0},
+		{"a", "B", "utf8_general_ci", -1},
+		{"abc", "ABC", "utf8mb4_bin", 1},
+		{"abc ", "abc", "utf8mb4_bin", 0},
+		{"abc ", "abc", "binary", 1},
+		{"abc ", "ABC", "utf8mb4_0900_ai_ci", 1},
+		{"abc", "ABC", "latin7_general_cs", 1},
+		{"abc", "ABC", "unknown", 1},
+	}
+	for i, test := range tests {
+		cmp := CompareStrings([]byte(test.s1), []byte(test.s2), CollationIds[test.collation])
+		if cmp > 0 {
+			cmp = 1
+		} else if cmp < 0 {
+			cmp = -1
+		}
+		if cmp != test.expect {
+			t.Errorf("test %d: compare %q and %q in %s, expect %d, got %d", i, test.s1, test.s2, test.collation, test.expect, cmp)
+		}
+	}
+}
+
+func TestIsTrueValue(t *testing.T) {
+	tests := []struct {
+		v      interface{}
+		expect bool
+	}{
+		{nil, false},
+		{int64(0), false},
+		{int64(-1), true},
+		{uint64(1), true},
+		{0.0, false},
+		{"0.1", true},
+		{"abc", false},
+		{[]byte("1abc"), true},
+	}
+	for i, test := range tests {
+		b, err := IsTrueValue(test.v)
+		if err != nil || b != test.expect {
+			t.Errorf("test %d: expect %v, got %v, %v", i, test.expect, b, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+// TruthValue is a value of MySQL three-valued logic. Conditions on NULL are UNKNOWN,
+// and rows are only matched by WHERE, HAVING and ON if the condition is TRUE.
+type TruthValue int8
+
+const (
+	// TruthFalse FALSE
+	TruthFalse TruthValue = iota
+	// TruthTrue TRUE
+	TruthTrue
+	// TruthUnknown UNKNOWN, truth value of NULL
+	TruthUnknown
+)
+
+var truthValueNames = map[TruthValue]string{
+	TruthFalse:   "FALSE",
+	TruthTrue:    "TRUE",
+	TruthUnknown: "UNKNOWN",
+}
+
+// String return truth value in sql
+func (t TruthValue) String() string {
+	return truthValueNames[t]
+}
+
+// TruthValueOf return truth value of value in condition, NULL is UNKNOWN and zero is FALSE
+func TruthValueOf(v interface{}) (TruthValue, error) {
+	if v == nil {
+		return TruthUnknown, nil
+	}
+	b, err := IsTrueValue(v)
+	if err != nil {
+		return TruthUnknown, err
+	}
+	return truthValue(b), nil
+}
+
+func truthValue(b bool) TruthValue {
+	if b {
+		return TruthTrue
+	}
+	return TruthFalse
+}
+
+// Value return result of truth value, 1, 0 or NULL
+func (t TruthValue) Value() interface{} {
+	if t == TruthUnknown {
+		return nil
+	}
+	return boolValue(t == TruthTrue)
+}
+
+// Not return NOT t, NOT UNKNOWN is UNKNOWN
+func (t TruthValue) Not() TruthValue {
+	switch t {
+	case TruthTrue:
+		return TruthFalse
+	case TruthFalse:
+		return TruthTrue
+	default:
+		return TruthUnknown
+	}
+}
+
+// And return t AND o, FALSE if one of them is FALSE even if the other is UNKNOWN
+func (t TruthValue) And(o TruthValue) TruthValue {
+	switch {
+	case t == TruthFalse || o == TruthFalse:
+		return TruthFalse
+	case t == TruthUnknown || o == TruthUnknown:
+		return TruthUnknown
+	default:
+		return TruthTrue
+	}
+}
+
+// Or return t OR o, TRUE if one of them is TRUE even if the other is UNKNOWN
+func (t TruthValue) Or(o TruthValue) TruthValue {
+	switch {
+	case t == TruthTrue || o == TruthTrue:
+		return TruthTrue
+	case t == TruthUnknown || o == TruthUnknown:
+		return TruthUnknown
+	default:
+		return TruthFalse
+	}
+}
+
+// Xor return t XOR o, UNKNOWN if one of them is UNKNOWN
+func (t TruthValue) Xor(o TruthValue) TruthValue {
+	if t == TruthUnknown || o == TruthUnknown {
+		return TruthUnknown
+	}
+	return truthValue(t != o)
+}
+
+// EvalIs evaluate v IS [NOT] TRUE, v IS [NOT] FALSE or v IS [NOT] UNKNOWN, result is 1 or 0 and never NULL
+func EvalIs(v interface{}, t TruthValue, not bool) (interface{}, error) {
+	vt, err := TruthValueOf(v)
+	if err != nil {
+		return nil, err
+	}
+	return boolValue((vt == t) != not), nil
+}
+
+// NullSafeEqual return v1 <=> v2, two NULLs are equal and NULL is not equal to other values
+func NullSafeEqual(v1, v2 interface{}, collation CollationID) (bool, error) {
+	if v1 == nil || v2 == nil {
+		return v1 == nil && v2 == nil, nil
+	}
+	cmp, _, err := CompareValues(v1, v2, collation)
+	if err != nil {
+		return false, err
+	}
+	return cmp == 0, nil
+}
+
+// EvalIn evaluate v IN (list) like MySQL: 1 if v equals one of values, NULL if v is NULL
+// or v equals none of values but the list has NULL, otherwise 0. NOT IN is NOT of the result.
+func EvalIn(v interface{}, list []interface{}, collation CollationID) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	hasNull := false
+	for _, item := range list {
+		if item == nil {
+			hasNull = true
+			continue
+		}
+		cmp, _, err := CompareValues(v, item, collation)
+		if err != nil {
+			return nil, err
+		}
+		if cmp == 0 {
+			return boolValue(true), nil
+		}
+	}
+	if hasNull {
+		return nil, nil
+	}
+	return boolValue(false), nil
+}
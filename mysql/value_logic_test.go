@@ -0,0 +1,120 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"testing"
+)
+
+func TestTruthValueLogic(t *testing.T) {
+	values := []TruthValue{TruthFalse, TruthTrue, TruthUnknown}
+	// results of AND, OR and XOR, indexed by values
+	and := [3][3]TruthValue{
+		{TruthFalse, TruthFalse, TruthFalse},
+		{TruthFalse, TruthTrue, TruthUnknown},
+		{TruthFalse, TruthUnknown, TruthUnknown},
+	}
+	or := [3][3]TruthValue{
+		{TruthFalse, TruthTrue, TruthUnknown},
+		{TruthTrue, TruthTrue, TruthTrue},
+		{TruthUnknown, TruthTrue, TruthUnknown},
+	}
+	xor := [3][3]TruthValue{
+		{TruthFalse, TruthTrue, TruthUnknown},
+		{TruthTrue, TruthFalse, TruthUnknown},
+		{TruthUnknown, TruthUnknown, TruthUnknown},
+	}
+	for i, t1 := range values {
+		for j, t2 := range values {
+			if v := t1.And(t2); v != and[i][j] {
+				t.Errorf("%v AND %v, expect %v, got %v", t1, t2, and[i][j], v)
+			}
+			if v := t1.Or(t2); v != or[i][j] {
+				t.Errorf("%v OR %v, expect %v, got %v", t1, t2, or[i][j], v)
+			}
+			if v := t1.Xor(t2); v != xor[i][j] {
+				t.Errorf("%v XOR %v, expect %v, got %v", t1, t2, xor[i][j], v)
+			}
+		}
+	}
+
+	if TruthTrue.Not() != TruthFalse || TruthFalse.Not() != TruthTrue || TruthUnknown.Not() != TruthUnknown {
+		t.Errorf("NOT error")
+	}
+	if TruthTrue.Value() != int64(1) || TruthFalse.Value() != int64(0) || TruthUnknown.Value() != nil {
+		t.Errorf("value of truth value error")
+	}
+}
+
+func TestEvalIs(t *testing.T) {
+	tests := []struct {
+		v      interface{}
+		truth  TruthValue
+		not    bool
+		expect interface{}
+	}{
+		{int64(2), TruthTrue, false, int64(1)},
+		{"0", TruthFalse, false, int64(1)},
+		{nil, TruthTrue, false, int64(0)},
+		{nil, TruthFalse, false, int64(0)},
+		{nil, TruthUnknown, false, int64(1)},
+		{nil, TruthTrue, true, int64(1)},
+		{int64(0), TruthUnknown, true, int64(1)},
+	}
+	for i, test := range tests {
+		v, err := EvalIs(test.v, test.truth, test.not)
+		if err != nil || v != test.expect {
+			t.Errorf("test %d: expect %v, got %v, %v", i, test.expect, v, err)
+		}
+	}
+}
+
+func TestNullSafeEqualAndEvalIn(t *testing.T) {
+	utf8CI := CollationIds["utf8mb4_general_ci"]
+	equalTests := []struct {
+		v1, v2 interface{}
+		expect bool
+	}{
+		{nil, nil, true},
+		{nil, int64(0), false},
+		{"", nil, false},
+		{int64(1), "1", true},
+		{"a", "A", true},
+	}
+	for i, test := range equalTests {
+		equal, err := NullSafeEqual(test.v1, test.v2, utf8CI)
+		if err != nil || equal != test.expect {
+			t.Errorf("test %d: expect %v, got %v, %v", i, test.expect, equal, err)
+		}
+	}
+
+	inTests := []struct {
+		v      interface{}
+		list   []interface{}
+		expect interface{}
+	}{
+		{int64(1), []interface{}{int64(1), nil}, int64(1)},
+		{int64(2), []interface{}{int64(1), nil}, nil},
+		{int64(2), []interface{}{int64(1), "3"}, int64(0)},
+		{nil, []interface{}{int64(1)}, nil},
+		{"b", []interface{}{"A", "B"}, int64(1)},
+	}
+	for i, test := range inTests {
+		v, err := EvalIn(test.v, test.list, utf8CI)
+		if err != nil || v != test.expect {
+			t.Errorf("test %d: expect %v, got %v, %v", i, test.expect, v, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// ToNative convert value of resultset column to go native value by the column type. Integers are int64, or uint64
+// if the column is unsigned. BIT(1) is bool and other BIT is uint64. FLOAT and DOUBLE are float64, DECIMAL is Decimal
+// in the scale of column. DATE, DATETIME and TIMESTAMP are time.Time in loc, zero date is zero time.Time. TIME is
+// time.Duration, and JSON is the value returned by ParseJSON. Strings of binary charset are []byte, other strings
+// are string. The result is shared with v if they have the same type.
+func ToNative(v interface{}, f *Field, loc *time.Location) (interface{}, error) {
+	if v == nil || f == nil {
+		return v, nil
+	}
+
+	switch f.Type {
+	case TypeNull:
+		return nil, nil
+	case TypeTiny, TypeShort, TypeInt24, TypeLong, TypeLonglong, TypeYear:
+		return nativeInteger(v, f.Flag&uint16(UnsignedFlag) > 0)
+	case TypeFloat, TypeDouble:
+		n, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		return toFloat(n), nil
+	case TypeDecimal, TypeNewDecimal:
+		return nativeDecimal(v, int(f.Decimal))
+	case TypeBit:
+		return nativeBit(v, f.ColumnLength)
+	case TypeDate, TypeNewDate, TypeDatetime, TypeTimestamp:
+		return nativeTime(v, f, loc)
+	case TypeDuration:
+		s, ok := stringBytes(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid TIME value %v", v)
+		}
+		d, err := ParseDuration(string(s), temporalFsp(f))
+		if err != nil {
+			return nil, err
+		}
+		return time.Duration(d.Microseconds()) * time.Microsecond, nil
+	case TypeJSON:
+		s, ok := stringBytes(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid JSON value %v", v)
+		}
+		return ParseJSON(s)
+	}
+
+	switch s := v.(type) {
+	case string:
+		if CollationID(f.Charset) == BinaryCollationID {
+			return []byte(s), nil
+		}
+		return s, nil
+	case []byte:
+		if CollationID(f.Charset) == BinaryCollationID {
+			return s, nil
+		}
+		return string(s), nil
+	}
+	return v, nil
+}
+
+func nativeInteger(v interface{}, unsigned bool) (interface{}, error) {
+	if s, ok := stringBytes(v); ok {
+		if unsigned {
+			return strconv.ParseUint(string(s), 10, 64)
+		}
+		return strconv.ParseInt(string(s), 10, 64)
+	}
+	n, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	switch n := n.(type) {
+	case int64:
+		if unsigned {
+			return uint64(n), nil
+		}
+		return n, nil
+	case uint64:
+		if !unsigned {
+			return int64(n), nil
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("invalid integer value %v", v)
+}
+
+func nativeDecimal(v interface{}, scale int) (interface{}, error) {
+	var d Decimal
+	var err error
+	switch n := v.(type) {
+	case Decimal:
+		d = n
+	case int64:
+		d = NewDecimalFromInt(n)
+	case uint64:
+		d = NewDecimalFromUint(n)
+	case float64:
+		d, err = NewDecimalFromFloat(n)
+	case string:
+		d, err = ParseDecimal(n)
+	case []byte:
+		d, err = ParseDecimal(string(n))
+	default:
+		return nil, fmt.Errorf("invalid DECIMAL value %v", v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if scale > maxDecimalScale {
+		return d, nil
+	}
+	if d.scale < scale {
+		// keep trailing zeros of the column scale, e.g. 1.50 of DECIMAL(10,2)
+		return Decimal{unscaled: d.rescale(scale), scale: scale}, nil
+	}
+	return d.Round(scale, RoundHalfUp), nil
+}
+
+// maxDecimalScale max scale of DECIMAL column, Decimal of field is 31 if the scale is not fixed
+const maxDecimalScale = 30
+
+// nativeBit convert BIT value, which is big endian bytes in resultset
+func nativeBit(v interface{}, length uint32) (interface{}, error) {
+	var n uint64
+	switch b := v.(type) {
+	case []byte:
+		if len(b) > 8 {
+			return nil, fmt.Errorf("invalid BIT value %v", v)
+		}
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+	case string:
+		return nativeBit([]byte(b), length)
+	case int64:
+		n = uint64(b)
+	case uint64:
+		n = b
+	default:
+		return nil, fmt.Errorf("invalid BIT value %v", v)
+	}
+	if length == 1 {
+		return n != 0, nil
+	}
+	return n, nil
+}
+
+func nativeTime(v interface{}, f *Field, loc *time.Location) (interface{}, error) {
+	s, ok := stringBytes(v)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s value %v", temporalTypeName(f.Type), v)
+	}
+	t, err := ParseDateTime(string(s), f.Type, temporalFsp(f))
+	if err != nil {
+		return nil, err
+	}
+	if t.IsZero() {
+		return time.Time{}, nil
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return t.Time(loc)
+}
+
+func temporalFsp(f *Field) int {
+	if int(f.Decimal) > MaxFsp {
+		return MaxFsp
+	}
+	return int(f.Decimal)
+}
+
+// FromNative convert go native value to value of resultset, which can be used as arg of statement. Signed integers
+// are int64, unsigned integers are uint64, and bool is 1 or 0 like TRUE and FALSE of MySQL. float32 is float64,
+// *big.Int and *big.Float are Decimal. time.Time is DATETIME string in its location, zero time.Time is zero date.
+// time.Duration is TIME string, DateTime and Duration are formatted like MySQL.
+// nil, int64, uint64, float64, string, []byte and Decimal are not changed.
+func FromNative(v interface{}) (interface{}, error) {
+	switch n := v.(type) {
+	case nil, int64, uint64, float64, string, []byte, Decimal:
+		return n, nil
+	case bool:
+		return boolValue(n), nil
+	case int, int8, int16, int32, uint, uint8, uint16, uint32, float32:
+		return toNumber(n)
+	case *big.Int:
+		if n == nil {
+			return nil, nil
+		}
+		return Decimal{unscaled: new(big.Int).Set(n)}, nil
+	case *big.Float:
+		if n == nil {
+			return nil, nil
+		}
+		if n.IsInf() {
+			return nil, fmt.Errorf("infinite value %v can not be converted to DECIMAL", n)
+		}
+		return ParseDecimal(n.Text('f', -1))
+	case time.Time:
+		return formatNativeTime(n), nil
+	case time.Duration:
+		return formatNativeDuration(n)
+	case DateTime:
+		return n.String(), nil
+	case Duration:
+		return n.String(), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+func formatNativeTime(t time.Time) string {
+	if t.IsZero() {
+		return "0000-00-00 00:00:00"
+	}
+	dt := DateTime{
+		Year:        t.Year(),
+		Month:       int(t.Month()),
+		Day:         t.Day(),
+		Hour:        t.Hour(),
+		Minute:      t.Minute(),
+		Second:      t.Second(),
+		Microsecond: t.Nanosecond() / 1000,
+		Type:        TypeDatetime,
+	}
+	if dt.Microsecond != 0 {
+		dt.Fsp = MaxFsp
+	}
+	return dt.String()
+}
+
+func formatNativeDuration(n time.Duration) (string, error) {
+	d := Duration{Negative: n < 0}
+	if d.Negative {
+		n = -n
+	}
+	us := int64(n / time.Microsecond)
+	d.Microsecond = int(us % 1000000)
+	d.Second = int(us / 1000000 % 60)
+	d.Minute = int(us / 60000000 % 60)
+	d.Hour = int(us / 3600000000)
+	if d.Hour > MaxDurationHour {
+		return "", fmt.Errorf("duration %v is out of range of TIME", n)
+	}
+	if d.Microsecond != 0 {
+		d.Fsp = MaxFsp
+	}
+	return d.String(), nil
+}
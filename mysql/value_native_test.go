@@ -0,0 +1,148 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestToNative(t *testing.T) {
+	utf8CI := uint16(CollationIds["utf8mb4_general_ci"])
+	binary := uint16(BinaryCollationID)
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		v      interface{}
+		field  Field
+		expect interface{}
+	}{
+		{nil, Field{Type: TypeLonglong}, nil},
+		{int64(-1), Field{Type: TypeLonglong}, int64(-1)},
+		{"18446744073709551615", Field{Type: TypeLonglong, Flag: uint16(UnsignedFlag)}, uint64(18446744073709551615)},
+		{int64(2020), Field{Type: TypeYear, Flag: uint16(UnsignedFlag)}, uint64(2020)},
+		{"1.5", Field{Type: TypeDouble}, 1.5},
+		{1.5, Field{Type: TypeNewDecimal, Decimal: 2}, "1.50"},
+		{"-12.345", Field{Type: TypeNewDecimal, Decimal: 2}, "-12.35"},
+		{"12345678901234567890.123", Field{Type: TypeNewDecimal, Decimal: 3}, "12345678901234567890.123"},
+		{[]byte{1}, Field{Type: TypeBit, ColumnLength: 1, Charset: binary}, true},
+		{[]byte{0}, Field{Type: TypeBit, ColumnLength: 1, Charset: binary}, false},
+		{[]byte{1, 2}, Field{Type: TypeBit, ColumnLength: 16, Charset: binary}, uint64(258)},
+		{"2020-01-02 03:04:05.5", Field{Type: TypeDatetime, Decimal: 1}, time.Date(2020, 1, 2, 3, 4, 5, 500000000, loc)},
+		{"2020-01-02", Field{Type: TypeDate}, time.Date(2020, 1, 2, 0, 0, 0, 0, loc)},
+		{"0000-00-00 00:00:00", Field{Type: TypeTimestamp}, time.Time{}},
+		{"-838:59:59", Field{Type: TypeDuration}, -(838*time.Hour + 59*time.Minute + 59*time.Second)},
+		{"01:02:03.25", Field{Type: TypeDuration, Decimal: 2}, time.Hour + 2*time.Minute + 3250*time.Millisecond},
+		{[]byte(`{"a": [1, true]}`), Field{Type: TypeJSON, Charset: binary}, map[string]interface{}{"a": []interface{}{int64(1), true}}},
+		{[]byte("abc"), Field{Type: TypeVarString, Charset: utf8CI}, "abc"},
+		{"abc", Field{Type: TypeBlob, Charset: binary}, []byte("abc")},
+	}
+	for i, test := range tests {
+		v, err := ToNative(test.v, &test.field, loc)
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if d, ok := v.(Decimal); ok {
+			v = d.String()
+		}
+		if tm, ok := v.(time.Time); ok && !tm.IsZero() {
+			if !tm.Equal(test.expect.(time.Time)) || tm.Location() != loc {
+				t.Errorf("test %d: expect %v, got %v", i, test.expect, tm)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(v, test.expect) {
+			t.Errorf("test %d: expect %v(%T), got %v(%T)", i, test.expect, test.expect, v, v)
+		}
+	}
+
+	for _, test := range []struct {
+		v     interface{}
+		field Field
+	}{
+		{"a", Field{Type: TypeLonglong}},
+		{"2020-00-01", Field{Type: TypeDate}},
+		{"a", Field{Type: TypeNewDecimal}},
+		{"{", Field{Type: TypeJSON}},
+	} {
+		if _, err := ToNative(test.v, &test.field, loc); err == nil {
+			t.Errorf("invalid value %v of type %d is not checked", test.v, test.field.Type)
+		}
+	}
+}
+
+func TestFromNative(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	d, _ := ParseDecimal("1.25")
+	tests := []struct {
+		v      interface{}
+		expect interface{}
+	}{
+		{nil, nil},
+		{true, int64(1)},
+		{false, int64(0)},
+		{int32(-3), int64(-3)},
+		{uint8(3), uint64(3)},
+		{float32(0.5), 0.5},
+		{"a", "a"},
+		{[]byte("a"), []byte("a")},
+		{d, d},
+		{new(big.Int).Lsh(big.NewInt(1), 64), "18446744073709551616"},
+		{big.NewFloat(-2.5), "-2.5"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, loc), "2020-01-02 03:04:05"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 6000, loc), "2020-01-02 03:04:05.000006"},
+		{time.Time{}, "0000-00-00 00:00:00"},
+		{-(26*time.Hour + 3*time.Second + 500*time.Millisecond), "-26:00:03.500000"},
+		{DateTime{Year: 2020, Month: 1, Day: 2, Type: TypeDate}, "2020-01-02"},
+		{Duration{Hour: 1}, "01:00:00"},
+	}
+	for i, test := range tests {
+		v, err := FromNative(test.v)
+		if err != nil {
+			t.Errorf("test %d: %v", i, err)
+			continue
+		}
+		if d, ok := v.(Decimal); ok && reflect.TypeOf(test.expect).Kind() == reflect.String {
+			v = d.String()
+		}
+		if !reflect.DeepEqual(v, test.expect) {
+			t.Errorf("test %d: expect %v(%T), got %v(%T)", i, test.expect, test.expect, v, v)
+		}
+	}
+
+	for _, v := range []interface{}{struct{}{}, 839 * time.Hour, new(big.Float).SetInf(false)} {
+		if _, err := FromNative(v); err == nil {
+			t.Errorf("unsupported value %v is not checked", v)
+		}
+	}
+}
+
+func TestNativeRoundTrip(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tm := time.Date(2020, 2, 29, 23, 59, 59, 123456000, loc)
+	s, err := FromNative(tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := ToNative(s, &Field{Type: TypeDatetime, Decimal: 6}, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.(time.Time).Equal(tm) {
+		t.Errorf("expect %v, got %v", tm, v)
+	}
+}
@@ -26,9 +26,15 @@ type verifyFunc func(interface{}) error
 
 // allowed session variables
 const (
-	SQLModeStr     = "sql_mode"
-	SQLSafeUpdates = "sql_safe_updates"
-	TimeZone       = "time_zone"
+	SQLModeStr           = "sql_mode"
+	SQLSafeUpdates       = "sql_safe_updates"
+	TimeZone             = "time_zone"
+	GroupConcatMaxLen    = "group_concat_max_len"
+	ForeignKeyChecks     = "foreign_key_checks"
+	UniqueChecks         = "unique_checks"
+	TransactionIsolation = "transaction_isolation"
+	TxIsolation          = "tx_isolation"
+	SessionTrackGTIDsStr = "session_track_gtids"
 )
 
 // not allowed session variables
@@ -37,9 +43,15 @@ const (
 )
 
 var variableVerifyFuncMap = map[string]verifyFunc{
-	SQLModeStr:     verifySQLMode,
-	SQLSafeUpdates: verifyOnOffInteger,
-	TimeZone:       verifyTimeZone,
+	SQLModeStr:           verifySQLMode,
+	SQLSafeUpdates:       verifyOnOffInteger,
+	TimeZone:             verifyTimeZone,
+	GroupConcatMaxLen:    verifyPositiveInteger,
+	ForeignKeyChecks:     verifyOnOffInteger,
+	UniqueChecks:         verifyOnOffInteger,
+	TransactionIsolation: verifyIsolationLevel,
+	TxIsolation:          verifyIsolationLevel,
+	SessionTrackGTIDsStr: verifySessionTrackGTIDs,
 }
 
 // SessionVariables variables in session
@@ -74,40 +86,26 @@ func (s *SessionVariables) Equals(dst *SessionVariables) bool {
 
 // SetEqualsWith set the SessionVariables equals with the dst, and variables not contained in dst are moved to unused.
 func (s *SessionVariables) SetEqualsWith(dst *SessionVariables) ( /*changed*/ bool, error) {
-	if len(s.variables) == 0 && len(dst.variables) != 0 {
-		for _, v := range dst.variables {
-			if err := s.Set(v.Name(), v.Get()); err != nil {
-				return false, err
-			}
+	changed := false
+	for name, srcVar := range s.variables {
+		if _, ok := dst.variables[name]; !ok {
+			changed = true
+			s.unused[name] = srcVar
+			delete(s.variables, name)
 		}
-		return true, nil
 	}
-
-	if len(s.variables) != 0 && len(dst.variables) == 0 {
-		for _, v := range s.variables {
-			s.unused[v.Name()] = v
-			delete(s.variables, v.Name())
+	for name, dstVar := range dst.variables {
+		srcVar, ok := s.variables[name]
+		if ok && srcVar.Get() == dstVar.Get() {
+			continue
 		}
-		return true, nil
-	}
-
-	changed := false
-	for variableName := range variableVerifyFuncMap {
-		srcVar, srcOK := s.variables[variableName]
-		dstVar, dstOK := dst.variables[variableName]
-		if srcOK && dstOK {
-			if srcVar.Get() != dstVar.Get() {
-				changed = true
-				srcVar.Set(dstVar.Get())
-			}
-		} else if srcOK && !dstOK {
-			changed = true
-			s.unused[variableName] = srcVar
-			delete(s.variables, variableName)
-		} else if !srcOK && dstOK {
-			changed = true
-			s.Set(variableName, dstVar.Get())
+		changed = true
+		if ok {
+			srcVar.value = dstVar.Get()
+		} else {
+			s.variables[name] = &Variable{name: name, value: dstVar.Get(), verify: dstVar.verify}
 		}
+		delete(s.unused, name)
 	}
 
 	return changed, nil
@@ -126,21 +124,37 @@ func (s *SessionVariables) Set(key string, value interface{}) error {
 		return fmt.Errorf("variable not support")
 	}
 
-	if variable, ok := s.variables[formatKey]; ok {
-		return variable.Set(value)
+	return s.setVariable(formatKey, value, verifyFunc)
+}
+
+// SetSystemVariable store system variable which is not verified by proxy, the value is verified by backend mysql
+// when the variable is pushed down to backend connections.
+func (s *SessionVariables) SetSystemVariable(key string, value interface{}) error {
+	formatKey := formatVariableName(key)
+	if verifyFunc, ok := variableVerifyFuncMap[formatKey]; ok {
+		return s.setVariable(formatKey, value, verifyFunc)
+	}
+	if !isValidVariableName(formatKey) {
+		return fmt.Errorf("invalid variable name: %s", key)
 	}
+	return s.setVariable(formatKey, value, verifyScalar)
+}
 
-	variable, err := NewVariable(formatKey, value, verifyFunc)
+func (s *SessionVariables) setVariable(key string, value interface{}, verify verifyFunc) error {
+	if variable, ok := s.variables[key]; ok {
+		return variable.Set(value)
+	}
+	variable, err := NewVariable(key, value, verify)
 	if err != nil {
 		return err
 	}
-	s.variables[formatKey] = variable
+	s.variables[key] = variable
 	return nil
 }
 
 // Get return variable with specific key
 func (s *SessionVariables) Get(key string) (interface{}, bool) {
-	v, ok := s.variables[key]
+	v, ok := s.variables[formatVariableName(key)]
 	return v, ok
 }
 
@@ -162,6 +176,19 @@ func formatVariableName(name string) string {
 	return name
 }
 
+// isValidVariableName check name of system variable, the name is written into SET statement of backend connections
+func isValidVariableName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
 // Variable variable definition in session
 type Variable struct {
 	name   string
@@ -302,3 +329,57 @@ func verifyTimeZone(v interface{}) error {
 
 	return nil
 }
+
+func verifyPositiveInteger(v interface{}) error {
+	val, ok := v.(int64)
+	if !ok {
+		return fmt.Errorf("value is not int64")
+	}
+	if val <= 0 {
+		return fmt.Errorf("value is not positive")
+	}
+	return nil
+}
+
+func verifyIsolationLevel(v interface{}) error {
+	value, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("invalid type of isolation level")
+	}
+	if !IsValidIsolationLevel(value) {
+		return fmt.Errorf("invalid isolation level: %s", value)
+	}
+	return nil
+}
+
+// IsValidIsolationLevel check if the value is a transaction isolation level, such as READ-COMMITTED
+func IsValidIsolationLevel(value string) bool {
+	switch strings.ToUpper(value) {
+	case "READ-UNCOMMITTED", "READ-COMMITTED", "REPEATABLE-READ", "SERIALIZABLE":
+		return true
+	default:
+		return false
+	}
+}
+
+func verifySessionTrackGTIDs(v interface{}) error {
+	value, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("invalid type of session_track_gtids")
+	}
+	switch strings.ToUpper(value) {
+	case "OFF", "OWN_GTID", "ALL_GTIDS":
+		return nil
+	default:
+		return fmt.Errorf("invalid value of session_track_gtids: %s", value)
+	}
+}
+
+func verifyScalar(v interface{}) error {
+	switch v.(type) {
+	case int64, uint64, float32, float64, string:
+		return nil
+	default:
+		return fmt.Errorf("invalid type of variable value: %T", v)
+	}
+}
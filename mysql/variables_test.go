@@ -0,0 +1,68 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"testing"
+)
+
+func TestSessionVariablesSetSystemVariable(t *testing.T) {
+	s := NewSessionVariables()
+	if err := s.SetSystemVariable("Sql_Auto_Is_Null", int64(1)); err != nil {
+		t.Fatalf("set system variable error: %v", err)
+	}
+	if _, ok := s.Get("sql_auto_is_null"); !ok {
+		t.Errorf("variable sql_auto_is_null not found")
+	}
+	if err := s.SetSystemVariable("sql_mode", "NOT_A_MODE"); err == nil {
+		t.Errorf("known variable should be verified")
+	}
+	if err := s.SetSystemVariable("x = 1, sql_log_bin", int64(0)); err == nil {
+		t.Errorf("invalid variable name should fail")
+	}
+	if err := s.Set("sql_auto_is_null", int64(1)); err == nil {
+		t.Errorf("unverified variable should not be set by Set")
+	}
+}
+
+func TestSessionVariablesSetEqualsWith(t *testing.T) {
+	frontend := NewSessionVariables()
+	backend := NewSessionVariables()
+	if changed, _ := backend.SetEqualsWith(frontend); changed {
+		t.Errorf("empty variables should not be changed")
+	}
+
+	frontend.Set(SQLModeStr, "STRICT_TRANS_TABLES")
+	frontend.SetSystemVariable("sql_auto_is_null", int64(1))
+	if changed, _ := backend.SetEqualsWith(frontend); !changed || len(backend.GetAll()) != 2 {
+		t.Errorf("variables should be copied, changed: %v, variables: %v", changed, backend.GetAll())
+	}
+	if changed, _ := backend.SetEqualsWith(frontend); changed {
+		t.Errorf("equal variables should not be changed")
+	}
+
+	frontend.Delete("sql_auto_is_null")
+	frontend.Set(SQLModeStr, "ANSI")
+	if changed, _ := backend.SetEqualsWith(frontend); !changed {
+		t.Errorf("variables should be changed")
+	}
+	if v, _ := backend.Get(SQLModeStr); v.(*Variable).Get() != "ANSI" {
+		t.Errorf("sql_mode should be ANSI, got %v", v.(*Variable).Get())
+	}
+	unused := backend.GetUnusedAndClear()
+	if _, ok := unused["sql_auto_is_null"]; !ok || len(unused) != 1 {
+		t.Errorf("sql_auto_is_null should be unused, got %v", unused)
+	}
+}
@@ -1,10 +1,53 @@
 package parser
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 )
 
+// traceIDRegex match trace id in comment, e.g. /* trace_id=abc123 */
+var traceIDRegex = regexp.MustCompile(`(?i)\btrace_id\s*[=:]\s*['"]?([0-9a-zA-Z_.:\-]{1,64})`)
+
+// maxExecutionTimeRegex match optimizer hint of statement timeout, e.g. SELECT /*+ MAX_EXECUTION_TIME(1000) */ ...
+var maxExecutionTimeRegex = regexp.MustCompile(`(?is)^\s*select\s*/\*\+[^*]*?\bmax_execution_time\s*\(\s*(\d+)\s*\)`)
+
+// routeHintRegex match optimizer style comment, e.g. /*+ shard_key(user_id=42) */
+var routeHintRegex = regexp.MustCompile(`(?s)/\*\+(.*?)\*/`)
+
+// routeHintItemRegex match one routing hint in optimizer style comment, e.g. route_to(db0)
+var routeHintItemRegex = regexp.MustCompile(`(?i)\b(shard_key|route_to|full_scan)\s*(?:\(([^)]*)\))?`)
+
+// resultCacheHintRegex match result cache hint in optimizer style comment, e.g. result_cache or result_cache(60)
+var resultCacheHintRegex = regexp.MustCompile(`(?i)\bresult_cache\b\s*(?:\(\s*(\d*)\s*\))?`)
+
+// explainShardingRegex match EXPLAIN SHARDING statement of proxy, e.g. EXPLAIN SHARDING SELECT ...
+var explainShardingRegex = regexp.MustCompile(`(?is)^explain\s+sharding\s+(.+)$`)
+
+// explainShardingHintRegex match explain hint at the beginning of sql, e.g. /*!gosharding explain*/ SELECT ...
+var explainShardingHintRegex = regexp.MustCompile(`(?is)^\s*/\*!\s*gosharding\s+explain\s*\*/`)
+
+// routing hints
+const (
+	RouteHintShardKey = "shard_key"
+	RouteHintRouteTo  = "route_to"
+	RouteHintFullScan = "full_scan"
+)
+
+// RouteHint is the routing hint in leading comments of sql, it overrides the route computed by sharding rules.
+// only one of the hints can be specified:
+// /*+ shard_key(user_id=42) */ route by the given sharding column value
+// /*+ route_to(db0) */ route to the given physical database or slice
+// /*+ full_scan */ route to all sub tables
+type RouteHint struct {
+	Type        string
+	ShardColumn string
+	ShardValue  string
+	Target      string
+}
+
 func isNonSpace(r rune) bool {
 	return !unicode.IsSpace(r)
 }
@@ -97,6 +140,119 @@ func SplitMarginComments(sql string) (query string, comments MarginComments) {
 	return strings.TrimFunc(sql[leadingEnd:trailingStart], unicode.IsSpace), comments
 }
 
+// ExtractTraceID return trace id in leading or trailing comments of sql, return empty string if not found
+func ExtractTraceID(sql string) string {
+	_, comments := SplitMarginComments(sql)
+	for _, c := range []string{comments.Leading, comments.Trailing} {
+		if c == "" {
+			continue
+		}
+		if m := traceIDRegex.FindStringSubmatch(c); len(m) == 2 {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// ExtractMaxExecutionTime return timeout in millisecond of MAX_EXECUTION_TIME hint, only select statement is supported
+func ExtractMaxExecutionTime(sql string) (int64, bool) {
+	m := maxExecutionTimeRegex.FindStringSubmatch(StripLeadingComments(sql))
+	if len(m) != 2 {
+		return 0, false
+	}
+	t, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return t, true
+}
+
+// ExtractExplainSharding check if sql is EXPLAIN SHARDING <sql> or starts with /*!gosharding explain*/ hint,
+// return the statement to explain with the keywords or hint removed.
+func ExtractExplainSharding(sql string) (string, bool) {
+	if loc := explainShardingHintRegex.FindStringIndex(sql); loc != nil {
+		return strings.TrimSpace(sql[loc[1]:]), true
+	}
+	if m := explainShardingRegex.FindStringSubmatch(StripLeadingComments(sql)); len(m) == 2 {
+		return m[1], true
+	}
+	return "", false
+}
+
+// ExtractRouteHint return routing hint in leading comments of sql, return nil if not found.
+// The hint must be placed before the statement keyword, hints after SELECT are optimizer hints of mysql.
+func ExtractRouteHint(sql string) (*RouteHint, error) {
+	_, comments := SplitMarginComments(sql)
+	if comments.Leading == "" {
+		return nil, nil
+	}
+
+	var hint *RouteHint
+	for _, c := range routeHintRegex.FindAllStringSubmatch(comments.Leading, -1) {
+		for _, m := range routeHintItemRegex.FindAllStringSubmatch(c[1], -1) {
+			if hint != nil {
+				return nil, fmt.Errorf("only one routing hint is allowed")
+			}
+			h, err := parseRouteHint(strings.ToLower(m[1]), strings.TrimSpace(m[2]))
+			if err != nil {
+				return nil, err
+			}
+			hint = h
+		}
+	}
+	return hint, nil
+}
+
+// ExtractResultCacheHint check if there is /*+ result_cache */ or /*+ result_cache(ttl) */ hint in leading comments
+// of sql, return ttl in seconds of the hint, 0 means default ttl.
+func ExtractResultCacheHint(sql string) (int64, bool) {
+	_, comments := SplitMarginComments(sql)
+	if comments.Leading == "" {
+		return 0, false
+	}
+	for _, c := range routeHintRegex.FindAllStringSubmatch(comments.Leading, -1) {
+		if m := resultCacheHintRegex.FindStringSubmatch(c[1]); m != nil {
+			ttl, _ := strconv.ParseInt(m[1], 10, 64)
+			return ttl, true
+		}
+	}
+	return 0, false
+}
+
+func parseRouteHint(tp, arg string) (*RouteHint, error) {
+	switch tp {
+	case RouteHintShardKey:
+		idx := strings.IndexByte(arg, '=')
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid shard_key hint: %s", arg)
+		}
+		column := strings.ToLower(strings.Trim(strings.TrimSpace(arg[:idx]), "`"))
+		value := trimHintQuote(strings.TrimSpace(arg[idx+1:]))
+		if column == "" || value == "" {
+			return nil, fmt.Errorf("invalid shard_key hint: %s", arg)
+		}
+		return &RouteHint{Type: tp, ShardColumn: column, ShardValue: value}, nil
+	case RouteHintRouteTo:
+		target := trimHintQuote(arg)
+		if target == "" {
+			return nil, fmt.Errorf("invalid route_to hint: %s", arg)
+		}
+		return &RouteHint{Type: tp, Target: target}, nil
+	default:
+		if arg != "" {
+			return nil, fmt.Errorf("invalid full_scan hint: %s", arg)
+		}
+		return &RouteHint{Type: tp}, nil
+	}
+}
+
+func trimHintQuote(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"' || s[0] == '`') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
 // StripLeadingComments trims the SQL string and removes any leading comments
 func StripLeadingComments(sql string) string {
 	sql = strings.TrimFunc(sql, unicode.IsSpace)
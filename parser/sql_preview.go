@@ -29,6 +29,9 @@ const (
 	StmtSavepoint
 	StmtRelease
 	StmtSRollback
+	StmtKill
+	StmtLoadData
+	StmtCall
 )
 
 // Preview analyzes the beginning of the query using a simpler and faster
@@ -97,6 +100,12 @@ func PreviewSql(sql string) StatementType {
 		return StmtRelease
 	case "rollback":
 		return StmtSRollback
+	case "kill":
+		return StmtKill
+	case "load":
+		return StmtLoadData
+	case "call":
+		return StmtCall
 	}
 	return StmtUnknown
 }
@@ -141,6 +150,12 @@ func (s StatementType) String() string {
 		return "SAVEPOINT_ROLLBACK"
 	case StmtRelease:
 		return "RELEASE"
+	case StmtKill:
+		return "KILL"
+	case StmtLoadData:
+		return "LOAD_DATA"
+	case StmtCall:
+		return "CALL"
 	default:
 		return "UNKNOWN"
 	}
@@ -148,7 +163,7 @@ func (s StatementType) String() string {
 
 func (s StatementType) CanHandleWithoutPlan() bool {
 	switch s {
-	case StmtShow, StmtSet, StmtBegin, StmtComment, StmtRollback, StmtUse, StmtPriv, StmtSavepoint, StmtRelease:
+	case StmtShow, StmtSet, StmtBegin, StmtComment, StmtRollback, StmtUse, StmtPriv, StmtSavepoint, StmtRelease, StmtKill:
 		return true
 	}
 	return false
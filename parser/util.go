@@ -19,10 +19,26 @@ func getTesterParser() *parser.Parser {
 
 //仅用于测试
 func ParseSQL(sql string) (ast.StmtNode, error) {
-	n, e := getTesterParser().ParseOneStmt(sql, "", "")
+	n, e := ParseOneStmt(getTesterParser(), sql)
 	return n, e
 }
 
+// ParseOneStmt parse one statement with p. Keywords of window functions such as OVER, RANK and ROWS are reserved
+// words if window functions are enabled, so sql is parsed with them only if it can't be parsed without them,
+// and tables or columns with these names can still be used without quotes in other statements.
+func ParseOneStmt(p *parser.Parser, sql string) (ast.StmtNode, error) {
+	n, err := p.ParseOneStmt(sql, "", "")
+	if err == nil {
+		return n, nil
+	}
+	p.EnableWindowFunc(true)
+	defer p.EnableWindowFunc(false)
+	if n, e := p.ParseOneStmt(sql, "", ""); e == nil {
+		return n, nil
+	}
+	return nil, err
+}
+
 const resultTableNameFlag format.RestoreFlags = 0
 
 // NodeToStringWithoutQuote get node text
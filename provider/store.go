@@ -28,10 +28,27 @@ import (
 
 // source type
 const (
-	ConfigFile = "file"
-	ConfigEtcd = "etcd"
+	ConfigFile       = "file"
+	ConfigEtcd       = "etcd"
+	ConfigConsul     = "consul"
+	ConfigKubernetes = "kubernetes"
 )
 
+// CoordinatorType return source type of coordinator shared by gaea cc and proxies,
+// it's consul if configured, otherwise etcd
+func CoordinatorType(configType string) string {
+	if configType == ConfigConsul {
+		return ConfigConsul
+	}
+	return ConfigEtcd
+}
+
+// HasCoordinator return true if proxies share a coordinator to register themselves and sync state,
+// namespaces in local files or kubernetes are read only
+func HasCoordinator(configType string) bool {
+	return configType == ConfigEtcd || configType == ConfigConsul
+}
+
 // Store means exported client to use
 type Store struct {
 	client config.SourceProvider
@@ -42,7 +59,7 @@ type Store struct {
 func NewClient(configType, addr, username, password, root string) config.SourceProvider {
 	switch configType {
 	case ConfigFile:
-		c, err := source.NewEtcdConfig(root)
+		c, err := source.NewFileSource(root)
 		if err != nil {
 			logging.DefaultLogger.Warnf("create fileclient failed, %s", addr)
 			return nil
@@ -56,6 +73,21 @@ func NewClient(configType, addr, username, password, root string) config.SourceP
 			return nil
 		}
 		return c
+	case ConfigConsul:
+		c, err := source.NewConsulSource(addr, username, password, root)
+		if err != nil {
+			logging.DefaultLogger.Fatalf("create consulclient to %s failed, %v", addr, err)
+			return nil
+		}
+		return c
+	case ConfigKubernetes:
+		// addr is path of kubeconfig, in-cluster config is used if it's empty
+		c, err := source.NewKubernetesSource(addr, root)
+		if err != nil {
+			logging.DefaultLogger.Fatalf("create kubernetes client failed, %v", err)
+			return nil
+		}
+		return c
 	}
 	logging.DefaultLogger.Fatalf("unknown source type")
 	return nil
@@ -84,6 +116,11 @@ func (s *Store) NamespacePath(name string) string {
 	return filepath.Join(s.prefix, "namespace", name)
 }
 
+// NamespaceSyncStatePath concat path of sync state of namespace
+func (s *Store) NamespaceSyncStatePath(name string) string {
+	return filepath.Join(s.prefix, "namespace_sync", name)
+}
+
 // ProxyBase return proxy path base
 func (s *Store) ProxyBase() string {
 	return filepath.Join(s.prefix, "proxy")
@@ -94,6 +131,26 @@ func (s *Store) ProxyPath(token string) string {
 	return filepath.Join(s.prefix, "proxy", fmt.Sprintf("proxy-%s", token))
 }
 
+// ProxyStateBase return proxy state path base
+func (s *Store) ProxyStateBase() string {
+	return filepath.Join(s.prefix, "proxy_state")
+}
+
+// ProxyStatePath concat proxy state path
+func (s *Store) ProxyStatePath(token string) string {
+	return filepath.Join(s.prefix, "proxy_state", fmt.Sprintf("proxy-%s", token))
+}
+
+// KillRequestBase return kill request path base
+func (s *Store) KillRequestBase() string {
+	return filepath.Join(s.prefix, "kill")
+}
+
+// KillRequestPath concat kill request path
+func (s *Store) KillRequestPath(id string) string {
+	return filepath.Join(s.prefix, "kill", id)
+}
+
 // CreateProxy create proxy model
 func (s *Store) CreateProxy(p *models.ProxyInfo) error {
 	return s.client.Update(s.ProxyPath(p.Token), p.Encode())
@@ -154,6 +211,29 @@ func (s *Store) DelNamespace(name string) error {
 	return s.client.Delete(s.NamespacePath(name))
 }
 
+// LoadNamespaceSyncState load sync state of namespace, return nil if the namespace is not synchronized from other cluster
+func (s *Store) LoadNamespaceSyncState(name string) (*models.NamespaceSyncState, error) {
+	b, err := s.client.Read(s.NamespaceSyncStatePath(name))
+	if err != nil || b == nil {
+		return nil, err
+	}
+	state := &models.NamespaceSyncState{}
+	if err := models.JSONDecode(state, b); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// DelNamespaceSyncState delete sync state of namespace
+func (s *Store) DelNamespaceSyncState(name string) error {
+	return s.client.Delete(s.NamespaceSyncStatePath(name))
+}
+
+// UpdateNamespaceSyncState update sync state of namespace
+func (s *Store) UpdateNamespaceSyncState(state *models.NamespaceSyncState) error {
+	return s.client.Update(s.NamespaceSyncStatePath(state.Name), state.Encode())
+}
+
 // ListProxyMonitorMetrics list proxies in proxy register path
 func (s *Store) ListProxyMonitorMetrics() (map[string]*models.ProxyMonitorMetric, error) {
 	files, err := s.client.List(s.ProxyBase())
@@ -174,3 +254,67 @@ func (s *Store) ListProxyMonitorMetrics() (map[string]*models.ProxyMonitorMetric
 	}
 	return proxy, nil
 }
+
+// UpdateProxyState update proxy state, the state will be expired after ttl if not updated
+func (s *Store) UpdateProxyState(p *models.ProxyState, ttl time.Duration) error {
+	return s.client.UpdateWithTTL(s.ProxyStatePath(p.Token), p.Encode(), ttl)
+}
+
+// DeleteProxyState delete proxy state path
+func (s *Store) DeleteProxyState(token string) error {
+	return s.client.Delete(s.ProxyStatePath(token))
+}
+
+// ListProxyStates list states of all proxies
+func (s *Store) ListProxyStates() ([]*models.ProxyState, error) {
+	files, err := s.client.List(s.ProxyStateBase())
+	if err != nil {
+		return nil, err
+	}
+	states := make([]*models.ProxyState, 0, len(files))
+	for _, path := range files {
+		b, err := s.client.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		// expired between list and read
+		if b == nil {
+			continue
+		}
+		p := &models.ProxyState{}
+		if err := models.JSONDecode(p, b); err != nil {
+			return nil, err
+		}
+		states = append(states, p)
+	}
+	return states, nil
+}
+
+// CreateKillRequest create kill request, the request will be expired after ttl
+func (s *Store) CreateKillRequest(k *models.KillRequest, ttl time.Duration) error {
+	return s.client.UpdateWithTTL(s.KillRequestPath(k.ID), k.Encode(), ttl)
+}
+
+// ListKillRequests list all unexpired kill requests
+func (s *Store) ListKillRequests() ([]*models.KillRequest, error) {
+	files, err := s.client.List(s.KillRequestBase())
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]*models.KillRequest, 0, len(files))
+	for _, path := range files {
+		b, err := s.client.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		k := &models.KillRequest{}
+		if err := models.JSONDecode(k, b); err != nil {
+			return nil, err
+		}
+		requests = append(requests, k)
+	}
+	return requests, nil
+}
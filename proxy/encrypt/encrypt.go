@@ -0,0 +1,235 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encrypt implements transparent encryption of sensitive columns.
+// Values of encrypted columns in statements are replaced by base64 encoded AES-GCM data
+// before statements are planned, and the data is decrypted in results returned to client.
+package encrypt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util/crypto"
+)
+
+// KeyProvider return AES key of the key id. It's called when namespace is loaded,
+// so keys can be fetched from a key management service without affecting queries.
+type KeyProvider func(namespace, keyID string) ([]byte, error)
+
+var (
+	providerLock sync.Mutex
+	keyProviders = make(map[string]KeyProvider)
+)
+
+// RegisterKeyProvider register key provider compiled into proxy, it can be used as key_provider of encryption config
+func RegisterKeyProvider(name string, provider KeyProvider) {
+	providerLock.Lock()
+	defer providerLock.Unlock()
+	keyProviders[name] = provider
+}
+
+func getKeyProvider(cfg *models.Encryption) (KeyProvider, error) {
+	name := cfg.GetKeyProvider()
+	if name == models.EncryptKeyProviderConfig {
+		return func(_, keyID string) ([]byte, error) {
+			return base64.StdEncoding.DecodeString(cfg.Keys[keyID])
+		}, nil
+	}
+
+	providerLock.Lock()
+	defer providerLock.Unlock()
+	p, ok := keyProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("key provider not registered: %s", name)
+	}
+	return p, nil
+}
+
+type column struct {
+	name          string // db.table.column
+	key           []byte
+	deterministic bool
+}
+
+func (c *column) encrypt(value string) (string, error) {
+	data, err := crypto.EncryptGCM(c.key, []byte(value), c.deterministic)
+	if err != nil {
+		return "", fmt.Errorf("encrypt value of %s error: %v", c.name, err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (c *column) decrypt(value []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil {
+		return nil, err
+	}
+	return crypto.DecryptGCM(c.key, data)
+}
+
+// Encryptor encrypt and decrypt values of encrypted columns of a namespace
+type Encryptor struct {
+	tables map[string]map[string]*column // key: db.table, column
+	fields map[string][]*column          // key: table.column, result fields only have physical db
+}
+
+// NewEncryptor constructor of Encryptor, keys of all columns are loaded from key provider
+func NewEncryptor(namespace string, cfg *models.Encryption) (*Encryptor, error) {
+	provider, err := getKeyProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Encryptor{
+		tables: make(map[string]map[string]*column),
+		fields: make(map[string][]*column),
+	}
+	keys := make(map[string][]byte)
+	for _, c := range cfg.Columns {
+		key, ok := keys[c.KeyID]
+		if !ok {
+			if key, err = provider(namespace, c.KeyID); err != nil {
+				return nil, fmt.Errorf("get encrypt key %s error: %v", c.KeyID, err)
+			}
+			if l := len(key); l != 16 && l != 24 && l != 32 {
+				return nil, fmt.Errorf("invalid length of encrypt key %s: %d", c.KeyID, l)
+			}
+			keys[c.KeyID] = key
+		}
+
+		db, table, name := strings.ToLower(c.DB), strings.ToLower(c.Table), strings.ToLower(c.Column)
+		col := &column{
+			name:          db + "." + table + "." + name,
+			key:           key,
+			deterministic: c.Mode == models.EncryptModeDeterministic,
+		}
+		if e.tables[db+"."+table] == nil {
+			e.tables[db+"."+table] = make(map[string]*column)
+		}
+		e.tables[db+"."+table][name] = col
+		e.fields[table+"."+name] = append(e.fields[table+"."+name], col)
+	}
+	return e, nil
+}
+
+func (e *Encryptor) getColumns(db, table string) map[string]*column {
+	return e.tables[db+"."+table]
+}
+
+// getFieldColumns return encrypted columns of result field, physical table name of sub table
+// has an index suffix, e.g. tbl_0001, the suffix is removed if the table itself is not found.
+func (e *Encryptor) getFieldColumns(table, name string) []*column {
+	table, name = strings.ToLower(table), strings.ToLower(name)
+	if cols, ok := e.fields[table+"."+name]; ok {
+		return cols
+	}
+	i := strings.LastIndexByte(table, '_')
+	if i <= 0 || i == len(table)-1 || strings.Trim(table[i+1:], "0123456789") != "" {
+		return nil
+	}
+	return e.fields[table[:i]+"."+name]
+}
+
+// Decrypt decrypt values of encrypted columns in result, result fields are matched by original table and column names.
+// Values failed to be decrypted are returned as they are, e.g. data written before the column is encrypted.
+func (e *Encryptor) Decrypt(r *mysql.Result) error {
+	if r == nil || r.Resultset == nil {
+		return nil
+	}
+
+	columns := make(map[int][]*column)
+	for i, f := range r.Fields {
+		if cols := e.getFieldColumns(string(f.OrgTable), string(f.OrgName)); len(cols) != 0 {
+			columns[i] = cols
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	for i, row := range r.Values {
+		decrypted := false
+		for idx, cols := range columns {
+			if v, ok := decryptValue(cols, row[idx]); ok {
+				row[idx] = v
+				decrypted = true
+			}
+		}
+		if !decrypted || i >= len(r.RowDatas) {
+			continue
+		}
+		data, err := rewriteRowData(r.RowDatas[i], row, columns)
+		if err != nil {
+			return err
+		}
+		r.RowDatas[i] = data
+	}
+	return nil
+}
+
+// decryptValue try keys of columns with the same table and column names in different dbs,
+// the right key is found by authentication of gcm.
+func decryptValue(cols []*column, value interface{}) (interface{}, bool) {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return nil, false
+	}
+	for _, c := range cols {
+		plain, err := c.decrypt(data)
+		if err != nil {
+			continue
+		}
+		if _, ok := value.(string); ok {
+			return string(plain), true
+		}
+		return plain, true
+	}
+	return nil, false
+}
+
+// rewriteRowData replace values of encrypted columns in row of text format
+func rewriteRowData(data mysql.RowData, row []interface{}, columns map[int][]*column) (mysql.RowData, error) {
+	ret := make([]byte, 0, len(data))
+	pos := 0
+	for i := range row {
+		start := pos
+		v, next, isNull, ok := mysql.ReadLenEncStringAsBytes(data, pos)
+		if !ok {
+			return nil, fmt.Errorf("ReadLenEncStringAsBytes in rewriteRowData failed")
+		}
+		pos = next
+		if _, encrypted := columns[i]; !encrypted || isNull {
+			ret = append(ret, data[start:pos]...)
+			continue
+		}
+		switch value := row[i].(type) {
+		case string:
+			v = []byte(value)
+		case []byte:
+			v = value
+		}
+		ret = mysql.AppendLenEncStringBytes(ret, v)
+	}
+	return ret, nil
+}
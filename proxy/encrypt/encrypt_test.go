@@ -0,0 +1,199 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser/format"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+func newTestEncryptor(t *testing.T) *Encryptor {
+	cfg := &models.Encryption{
+		Keys: map[string]string{"k1": "MTIzNGFiY2Q1Njc4ZWZnKg=="},
+		Columns: []*models.EncryptColumn{
+			{DB: "db", Table: "tbl", Column: "phone", KeyID: "k1", Mode: models.EncryptModeDeterministic},
+			{DB: "db", Table: "tbl", Column: "email", KeyID: "k1"},
+		},
+	}
+	e, err := NewEncryptor("ns", cfg)
+	if err != nil {
+		t.Fatalf("create encryptor error: %v", err)
+	}
+	return e
+}
+
+func TestNewEncryptor(t *testing.T) {
+	cfg := &models.Encryption{
+		KeyProvider: "test_kms",
+		Columns:     []*models.EncryptColumn{{DB: "db", Table: "tbl", Column: "phone", KeyID: "k1"}},
+	}
+	if _, err := NewEncryptor("ns", cfg); err == nil {
+		t.Errorf("key provider is not registered, should fail")
+	}
+
+	RegisterKeyProvider("test_kms", func(namespace, keyID string) ([]byte, error) {
+		if namespace != "ns" || keyID != "k1" {
+			return nil, fmt.Errorf("key not found")
+		}
+		return []byte("0123456789abcdef0123456789abcdef"), nil
+	})
+	e, err := NewEncryptor("ns", cfg)
+	if err != nil {
+		t.Fatalf("create encryptor error: %v", err)
+	}
+	if c := e.getColumns("db", "tbl")["phone"]; c == nil || len(c.key) != 32 || c.deterministic {
+		t.Errorf("invalid column: %+v", c)
+	}
+	cfg.Columns[0].KeyID = "k2"
+	if _, err := NewEncryptor("ns", cfg); err == nil {
+		t.Errorf("key is not found, should fail")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	e := newTestEncryptor(t)
+	phone := e.getColumns("db", "tbl")["phone"]
+	enc := func(v string) string {
+		data, err := phone.encrypt(v)
+		if err != nil {
+			t.Fatalf("encrypt error: %v", err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		sql    string
+		expect string
+	}{
+		{
+			"insert into tbl (id, phone, name) values (1, '138', 'a'), (2, null, 'b')",
+			fmt.Sprintf("INSERT INTO `tbl` (`id`,`phone`,`name`) VALUES (1,'%s','a'),(2,NULL,'b')", enc("138")),
+		},
+		{
+			"insert into tbl set id = 1, phone = 138 on duplicate key update phone = values(phone)",
+			fmt.Sprintf("INSERT INTO `tbl` SET `id`=1,`phone`='%s' ON DUPLICATE KEY UPDATE `phone`=VALUES(`phone`)", enc("138")),
+		},
+		{
+			"update tbl set phone = '138' where phone = '139' and id = 1",
+			fmt.Sprintf("UPDATE `tbl` SET `phone`='%s' WHERE `phone`='%s' AND `id`=1", enc("138"), enc("139")),
+		},
+		{
+			"select name from db.tbl t where '138' = t.phone or t.phone in ('139', '140')",
+			fmt.Sprintf("SELECT `name` FROM `db`.`tbl` AS `t` WHERE '%s'=`t`.`phone` OR `t`.`phone` IN ('%s','%s')", enc("138"), enc("139"), enc("140")),
+		},
+		{
+			"delete from tbl where phone = '138'",
+			fmt.Sprintf("DELETE FROM `tbl` WHERE `phone`='%s'", enc("138")),
+		},
+		{
+			"select * from tbl2 where phone = '138' and id > 1",
+			"SELECT * FROM `tbl2` WHERE `phone`='138' AND `id`>1",
+		},
+		{
+			"select * from other.tbl where phone = '138'",
+			"SELECT * FROM `other`.`tbl` WHERE `phone`='138'",
+		},
+	}
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		if err := e.Rewrite(stmt, "db"); err != nil {
+			t.Errorf("rewrite %s error: %v", test.sql, err)
+			continue
+		}
+		s := &strings.Builder{}
+		_ = stmt.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, s))
+		if s.String() != test.expect {
+			t.Errorf("rewrite %s, expect %s, got %s", test.sql, test.expect, s.String())
+		}
+	}
+}
+
+func TestRewriteError(t *testing.T) {
+	e := newTestEncryptor(t)
+	tests := []string{
+		"select * from tbl where email = 'a@b.com'",
+		"select * from tbl where phone like '138%'",
+		"select * from tbl where phone > '138'",
+		"select * from tbl where phone between '138' and '139'",
+		"insert into tbl values (1, '138', 'a')",
+		"update tbl set phone = concat(phone, '1')",
+	}
+	for _, sql := range tests {
+		stmt, err := parser.ParseSQL(sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		if err := e.Rewrite(stmt, "db"); err == nil {
+			t.Errorf("rewrite %s should fail", sql)
+		}
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	e := newTestEncryptor(t)
+	email := e.getColumns("db", "tbl")["email"]
+	data, err := email.encrypt("a@b.com")
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+
+	r := &mysql.Result{Resultset: &mysql.Resultset{
+		Fields: []*mysql.Field{
+			{OrgTable: []byte("tbl_0001"), OrgName: []byte("id"), Type: mysql.TypeVarString},
+			{OrgTable: []byte("tbl_0001"), OrgName: []byte("email"), Type: mysql.TypeVarString},
+		},
+		// the second row is written before the column is encrypted
+		Values: [][]interface{}{{int64(1), data}, {int64(2), "c@d.com"}, {int64(3), nil}},
+	}}
+	for _, row := range [][]string{{"1", data}, {"2", "c@d.com"}} {
+		var rowData []byte
+		for _, v := range row {
+			rowData = mysql.AppendLenEncStringBytes(rowData, []byte(v))
+		}
+		r.RowDatas = append(r.RowDatas, rowData)
+	}
+	r.RowDatas = append(r.RowDatas, append(mysql.AppendLenEncStringBytes(nil, []byte("3")), 0xfb))
+
+	if err := e.Decrypt(r); err != nil {
+		t.Fatalf("decrypt error: %v", err)
+	}
+	for i, expect := range []interface{}{"a@b.com", "c@d.com", nil} {
+		if r.Values[i][1] != expect {
+			t.Errorf("row %d, expect %v, got %v", i, expect, r.Values[i][1])
+		}
+		values, err := r.RowDatas[i].ParseText(r.Fields)
+		if err != nil {
+			t.Fatalf("parse row data error: %v", err)
+		}
+		if fmt.Sprint(values[1]) != fmt.Sprint(r.Values[i][1]) {
+			t.Errorf("row data %d, expect %v, got %v", i, r.Values[i][1], values[1])
+		}
+	}
+
+	if cols := e.getFieldColumns("tbl_abc", "email"); cols != nil {
+		t.Errorf("table without index suffix should not match")
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encrypt
+
+import (
+	"fmt"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/util"
+)
+
+type tableRef struct {
+	db    string
+	table string
+	alias string
+}
+
+// rewriter encrypt literal values assigned to or compared with encrypted columns
+type rewriter struct {
+	e      *Encryptor
+	db     string
+	tables []*tableRef
+	err    error
+}
+
+// Rewrite encrypt values of encrypted columns in INSERT, REPLACE, UPDATE assignments and conditions,
+// the statement is modified in place. Only equality conditions of deterministic columns are supported,
+// other comparisons of encrypted columns with values are rejected since they can't be evaluated on encrypted data.
+func (e *Encryptor) Rewrite(stmt ast.StmtNode, db string) error {
+	switch stmt.(type) {
+	case *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt, *ast.SelectStmt:
+	default:
+		return nil
+	}
+
+	r := &rewriter{e: e, db: db}
+	stmt.Accept(&tableCollector{r: r})
+	if !r.hasEncryptedTable() {
+		return nil
+	}
+
+	if s, ok := stmt.(*ast.InsertStmt); ok {
+		if err := r.rewriteInsertValues(s); err != nil {
+			return err
+		}
+	}
+	stmt.Accept(r)
+	return r.err
+}
+
+func (r *rewriter) hasEncryptedTable() bool {
+	for _, t := range r.tables {
+		if len(r.e.getColumns(t.db, t.table)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// findColumn return encrypted column referenced by name, nil if it's not encrypted
+func (r *rewriter) findColumn(name *ast.ColumnName) *column {
+	for _, t := range r.tables {
+		if name.Table.L != "" {
+			if t.alias != "" && name.Table.L != t.alias || t.alias == "" && name.Table.L != t.table {
+				continue
+			}
+			if name.Schema.L != "" && name.Schema.L != t.db {
+				continue
+			}
+		}
+		if c, ok := r.e.getColumns(t.db, t.table)[name.Name.L]; ok {
+			return c
+		}
+	}
+	return nil
+}
+
+func (r *rewriter) rewriteInsertValues(stmt *ast.InsertStmt) error {
+	ts, ok := stmt.Table.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil
+	}
+	name, ok := ts.Source.(*ast.TableName)
+	if !ok {
+		return nil
+	}
+	db := name.Schema.L
+	if db == "" {
+		db = r.db
+	}
+	columns := r.e.getColumns(db, name.Name.L)
+	if len(columns) == 0 {
+		return nil
+	}
+	if len(stmt.Columns) == 0 && len(stmt.Lists) != 0 {
+		return fmt.Errorf("column list is required to insert into table with encrypted columns")
+	}
+	for i, name := range stmt.Columns {
+		c, ok := columns[name.Name.L]
+		if !ok {
+			continue
+		}
+		for _, row := range stmt.Lists {
+			if i >= len(row) {
+				return fmt.Errorf("column count doesn't match value count")
+			}
+			if err := encryptValue(c, &row[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encryptValue replace literal value of column with encrypted data, NULL is not encrypted.
+// Expressions copying data of columns are allowed, other expressions can't be encrypted.
+func encryptValue(c *column, expr *ast.ExprNode) error {
+	switch v := (*expr).(type) {
+	case *driver.ValueExpr:
+		value, err := util.GetValueExprResult(v)
+		if err != nil {
+			return fmt.Errorf("get value of %s error: %v", c.name, err)
+		}
+		if value == nil {
+			return nil
+		}
+		data, err := c.encrypt(fmt.Sprintf("%v", value))
+		if err != nil {
+			return err
+		}
+		*expr = ast.NewValueExpr(data, "", "")
+		return nil
+	case *ast.ColumnNameExpr, *ast.ValuesExpr, *ast.DefaultExpr:
+		return nil
+	default:
+		return fmt.Errorf("only literal value is supported for encrypted column %s", c.name)
+	}
+}
+
+func (r *rewriter) encryptCondition(c *column, expr *ast.ExprNode) error {
+	if _, ok := (*expr).(*driver.ValueExpr); !ok {
+		return nil
+	}
+	if !c.deterministic {
+		return fmt.Errorf("encrypted column %s in random mode can not be used in condition", c.name)
+	}
+	return encryptValue(c, expr)
+}
+
+func (r *rewriter) columnOf(expr ast.ExprNode) *column {
+	if col, ok := expr.(*ast.ColumnNameExpr); ok {
+		return r.findColumn(col.Name)
+	}
+	return nil
+}
+
+// Enter implement ast.Visitor
+func (r *rewriter) Enter(n ast.Node) (ast.Node, bool) {
+	if r.err != nil {
+		return n, true
+	}
+	switch nn := n.(type) {
+	case *ast.Assignment:
+		if c := r.findColumn(nn.Column); c != nil {
+			r.err = encryptValue(c, &nn.Expr)
+		}
+	case *ast.BinaryOperationExpr:
+		r.err = r.rewriteBinaryOperation(nn)
+	case *ast.PatternInExpr:
+		if c := r.columnOf(nn.Expr); c != nil {
+			for i := range nn.List {
+				if r.err = r.encryptCondition(c, &nn.List[i]); r.err != nil {
+					break
+				}
+			}
+		}
+	case *ast.BetweenExpr:
+		if c := r.columnOf(nn.Expr); c != nil {
+			r.err = fmt.Errorf("encrypted column %s only supports equality condition", c.name)
+		}
+	case *ast.PatternLikeExpr:
+		if c := r.columnOf(nn.Expr); c != nil {
+			r.err = fmt.Errorf("encrypted column %s only supports equality condition", c.name)
+		}
+	}
+	return n, r.err != nil
+}
+
+func (r *rewriter) rewriteBinaryOperation(expr *ast.BinaryOperationExpr) error {
+	l, rc := r.columnOf(expr.L), r.columnOf(expr.R)
+	if l == nil && rc == nil {
+		return nil
+	}
+	switch expr.Op {
+	case opcode.EQ, opcode.NE, opcode.NullEQ:
+		if l != nil {
+			return r.encryptCondition(l, &expr.R)
+		}
+		return r.encryptCondition(rc, &expr.L)
+	case opcode.LT, opcode.LE, opcode.GT, opcode.GE:
+		if l == nil {
+			l = rc
+		}
+		return fmt.Errorf("encrypted column %s only supports equality condition", l.name)
+	default:
+		return nil
+	}
+}
+
+// Leave implement ast.Visitor
+func (r *rewriter) Leave(n ast.Node) (ast.Node, bool) {
+	return n, r.err == nil
+}
+
+// tableCollector collect tables in statement
+type tableCollector struct {
+	r *rewriter
+}
+
+// Enter implement ast.Visitor
+func (t *tableCollector) Enter(n ast.Node) (ast.Node, bool) {
+	ts, ok := n.(*ast.TableSource)
+	if !ok {
+		return n, false
+	}
+	if name, ok := ts.Source.(*ast.TableName); ok {
+		db := name.Schema.L
+		if db == "" {
+			db = t.r.db
+		}
+		t.r.tables = append(t.r.tables, &tableRef{db: db, table: name.Name.L, alias: ts.AsName.L})
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (t *tableCollector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
@@ -0,0 +1,288 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mask implements masking of sensitive values in results.
+// Values of masked columns, or values matching phone, email or ID card patterns in string columns,
+// are replaced before results are written to users the rules apply to.
+package mask
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+type maskFunc func(s string) string
+
+var maskFuncs = map[string]maskFunc{
+	models.MaskTypeFull:   maskFull,
+	models.MaskTypePhone:  maskPhone,
+	models.MaskTypeEmail:  maskEmail,
+	models.MaskTypeIDCard: maskIDCard,
+}
+
+// patterns of values in string columns, \b is boundary of ASCII word characters,
+// so numbers adjacent to Chinese characters are matched.
+var maskPatterns = map[string]*regexp.Regexp{
+	models.MaskTypePhone:  regexp.MustCompile(`\b1[3-9][0-9]{9}\b`),
+	models.MaskTypeEmail:  regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	models.MaskTypeIDCard: regexp.MustCompile(`\b[0-9]{17}[0-9Xx]\b`),
+}
+
+func maskFull(s string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(s))
+}
+
+// maskKeep keep the first head and the last tail characters, value not longer than head + tail is fully masked
+func maskKeep(s string, head, tail int) string {
+	r := []rune(s)
+	if len(r) <= head+tail {
+		return maskFull(s)
+	}
+	return string(r[:head]) + strings.Repeat("*", len(r)-head-tail) + string(r[len(r)-tail:])
+}
+
+func maskPhone(s string) string {
+	return maskKeep(s, 3, 4)
+}
+
+func maskIDCard(s string) string {
+	return maskKeep(s, 6, 4)
+}
+
+func maskEmail(s string) string {
+	at := strings.LastIndexByte(s, '@')
+	if at <= 0 {
+		return maskFull(s)
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size] + "***" + s[at:]
+}
+
+type rule struct {
+	users   map[string]bool // nil means all users
+	mask    maskFunc
+	pattern *regexp.Regexp // nil if the rule masks a column
+}
+
+func (r *rule) appliesTo(user string) bool {
+	return r.users == nil || r.users[user]
+}
+
+func (r *rule) apply(s string) string {
+	if r.pattern == nil {
+		return r.mask(s)
+	}
+	return r.pattern.ReplaceAllStringFunc(s, r.mask)
+}
+
+// Masker masks values in results of a namespace according to mask rules of users
+type Masker struct {
+	columns  map[string][]*rule // key: table.column, result fields only have physical db
+	patterns []*rule
+}
+
+// NewMasker constructor of Masker, the config has been verified
+func NewMasker(cfg *models.Masking) *Masker {
+	m := &Masker{columns: make(map[string][]*rule)}
+	for _, c := range cfg.Rules {
+		r := &rule{mask: maskFuncs[c.GetType()]}
+		if len(c.Users) != 0 {
+			r.users = make(map[string]bool, len(c.Users))
+			for _, u := range c.Users {
+				r.users[u] = true
+			}
+		}
+		if c.Column == "" {
+			r.pattern = maskPatterns[c.GetType()]
+			m.patterns = append(m.patterns, r)
+			continue
+		}
+		name := strings.ToLower(c.Table + "." + c.Column)
+		m.columns[name] = append(m.columns[name], r)
+	}
+	return m
+}
+
+// IsEnabled check if any rule applies to the user
+func (m *Masker) IsEnabled(user string) bool {
+	for _, rules := range m.columns {
+		for _, r := range rules {
+			if r.appliesTo(user) {
+				return true
+			}
+		}
+	}
+	for _, r := range m.patterns {
+		if r.appliesTo(user) {
+			return true
+		}
+	}
+	return false
+}
+
+// getFieldRules return rules of result field applying to the user, physical table name of sub table
+// has an index suffix, e.g. tbl_0001, the suffix is removed if the table itself is not found.
+func (m *Masker) getFieldRules(user string, f *mysql.Field) []*rule {
+	switch f.Type {
+	case mysql.TypeVarchar, mysql.TypeVarString, mysql.TypeString, mysql.TypeTinyBlob, mysql.TypeBlob,
+		mysql.TypeMediumBlob, mysql.TypeLongBlob:
+	default:
+		return nil
+	}
+
+	table, name := strings.ToLower(string(f.OrgTable)), strings.ToLower(string(f.OrgName))
+	columns, ok := m.columns[table+"."+name]
+	if !ok {
+		if i := strings.LastIndexByte(table, '_'); i > 0 && i < len(table)-1 && strings.Trim(table[i+1:], "0123456789") == "" {
+			columns = m.columns[table[:i]+"."+name]
+		}
+	}
+
+	var rules []*rule
+	for _, r := range columns {
+		if r.appliesTo(user) {
+			// the column is masked by its first rule
+			rules = append(rules, r)
+			break
+		}
+	}
+	for _, r := range m.patterns {
+		if r.appliesTo(user) {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// Mask return result with values masked by rules of the user. The result is not modified,
+// rows with masked values are copied.
+func (m *Masker) Mask(user string, r *mysql.Result) (*mysql.Result, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	ret := *r
+	if r.Next != nil {
+		next, err := m.Mask(user, r.Next)
+		if err != nil {
+			return nil, err
+		}
+		ret.Next = next
+	}
+	if r.Resultset == nil {
+		return &ret, nil
+	}
+
+	columns := make(map[int][]*rule)
+	for i, f := range r.Fields {
+		if rules := m.getFieldRules(user, f); len(rules) != 0 {
+			columns[i] = rules
+		}
+	}
+	if len(columns) == 0 {
+		return &ret, nil
+	}
+
+	rs := *r.Resultset
+	rs.Values = make([][]interface{}, len(r.Values))
+	if len(r.RowDatas) == len(r.Values) {
+		rs.RowDatas = make([]mysql.RowData, len(r.RowDatas))
+		copy(rs.RowDatas, r.RowDatas)
+	}
+	for i, row := range r.Values {
+		rs.Values[i] = row
+		masked := maskRow(row, columns)
+		if masked == nil {
+			continue
+		}
+		rs.Values[i] = masked
+		if i >= len(rs.RowDatas) {
+			continue
+		}
+		data, err := rewriteRowData(r.RowDatas[i], masked, columns)
+		if err != nil {
+			return nil, err
+		}
+		rs.RowDatas[i] = data
+	}
+	ret.Resultset = &rs
+	return &ret, nil
+}
+
+// maskRow return copy of the row with masked values, nil if no value is changed
+func maskRow(row []interface{}, columns map[int][]*rule) []interface{} {
+	var masked []interface{}
+	for idx, rules := range columns {
+		if idx >= len(row) {
+			continue
+		}
+		var s string
+		switch v := row[idx].(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			continue
+		}
+		ms := s
+		for _, r := range rules {
+			ms = r.apply(ms)
+		}
+		if ms == s {
+			continue
+		}
+		if masked == nil {
+			masked = make([]interface{}, len(row))
+			copy(masked, row)
+		}
+		if _, ok := row[idx].(string); ok {
+			masked[idx] = ms
+		} else {
+			masked[idx] = []byte(ms)
+		}
+	}
+	return masked
+}
+
+// rewriteRowData replace values of masked columns in row of text format
+func rewriteRowData(data mysql.RowData, row []interface{}, columns map[int][]*rule) (mysql.RowData, error) {
+	ret := make([]byte, 0, len(data))
+	pos := 0
+	for i := range row {
+		start := pos
+		v, next, isNull, ok := mysql.ReadLenEncStringAsBytes(data, pos)
+		if !ok {
+			return nil, fmt.Errorf("ReadLenEncStringAsBytes in rewriteRowData failed")
+		}
+		pos = next
+		if _, masked := columns[i]; !masked || isNull {
+			ret = append(ret, data[start:pos]...)
+			continue
+		}
+		switch value := row[i].(type) {
+		case string:
+			v = []byte(value)
+		case []byte:
+			v = value
+		}
+		ret = mysql.AppendLenEncStringBytes(ret, v)
+	}
+	return ret, nil
+}
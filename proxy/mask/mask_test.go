@@ -0,0 +1,108 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mask
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+func TestMaskFuncs(t *testing.T) {
+	tests := []struct {
+		fn     maskFunc
+		value  string
+		expect string
+	}{
+		{maskFull, "张三", "**"},
+		{maskPhone, "13812345678", "138****5678"},
+		{maskPhone, "1234567", "*******"},
+		{maskEmail, "alice@example.com", "a***@example.com"},
+		{maskEmail, "王@example.com", "王***@example.com"},
+		{maskEmail, "alice", "*****"},
+		{maskIDCard, "11010519491231002X", "110105********002X"},
+	}
+	for i, test := range tests {
+		if v := test.fn(test.value); v != test.expect {
+			t.Errorf("test %d: expect %s, got %s", i, test.expect, v)
+		}
+	}
+}
+
+func newTestResult(t *testing.T) *mysql.Result {
+	fields := []*mysql.Field{
+		{Name: []byte("id"), OrgName: []byte("id"), OrgTable: []byte("tbl_0001"), Type: mysql.TypeLonglong},
+		{Name: []byte("name"), OrgName: []byte("name"), OrgTable: []byte("tbl_0001"), Type: mysql.TypeVarString},
+		{Name: []byte("memo"), OrgName: []byte("memo"), OrgTable: []byte("tbl_0001"), Type: mysql.TypeBlob},
+	}
+	rs, err := mysql.BuildResultset(fields, []string{"id", "name", "memo"}, [][]interface{}{
+		{int64(1), "alice", []byte("手机13812345678, 邮箱alice@example.com")},
+		{int64(2), "bob", []byte("none")},
+	})
+	if err != nil {
+		t.Fatalf("build resultset error: %v", err)
+	}
+	return &mysql.Result{Resultset: rs}
+}
+
+func TestMask(t *testing.T) {
+	m := NewMasker(&models.Masking{Rules: []*models.MaskRule{
+		{Users: []string{"analyst"}, DB: "db", Table: "tbl", Column: "name"},
+		{Users: []string{"analyst", "ops"}, Type: models.MaskTypePhone},
+		{Type: models.MaskTypeEmail},
+	}})
+	if !m.IsEnabled("analyst") || !m.IsEnabled("root") {
+		t.Errorf("rule of all users should be enabled")
+	}
+
+	r := newTestResult(t)
+	masked, err := m.Mask("analyst", r)
+	if err != nil {
+		t.Fatalf("mask error: %v", err)
+	}
+	expect := [][]interface{}{
+		{int64(1), "*****", []byte("手机138****5678, 邮箱a***@example.com")},
+		{int64(2), "***", []byte("none")},
+	}
+	if !reflect.DeepEqual(masked.Values, expect) {
+		t.Errorf("masked values not match, expect: %v, got: %v", expect, masked.Values)
+	}
+	row, err := masked.RowDatas[0].ParseText(masked.Fields)
+	if err != nil || !reflect.DeepEqual(row, expect[0]) {
+		t.Errorf("masked row data not match, expect: %v, got: %v, %v", expect[0], row, err)
+	}
+	// the result may be cached, it should not be modified
+	if r.Values[0][1] != "alice" || !reflect.DeepEqual(r.RowDatas, newTestResult(t).RowDatas) {
+		t.Errorf("origin result is modified: %v", r.Values)
+	}
+
+	masked, err = m.Mask("ops", r)
+	if err != nil {
+		t.Fatalf("mask error: %v", err)
+	}
+	if masked.Values[0][1] != "alice" || string(masked.Values[0][2].([]byte)) != "手机138****5678, 邮箱a***@example.com" {
+		t.Errorf("masked values of ops not match: %v", masked.Values)
+	}
+
+	masked, err = m.Mask("root", &mysql.Result{AffectedRows: 1, Next: r})
+	if err != nil {
+		t.Fatalf("mask error: %v", err)
+	}
+	if masked.AffectedRows != 1 || string(masked.Next.Values[0][2].([]byte)) != "手机13812345678, 邮箱a***@example.com" {
+		t.Errorf("masked values of next result not match: %v", masked.Next.Values)
+	}
+}
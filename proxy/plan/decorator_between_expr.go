@@ -147,6 +147,14 @@ func getShardBetweenExprRouteResult(rule router.Rule, n *ast.BetweenExpr) ([]int
 		return nil, fmt.Errorf("get value from n.Right error: %v", err)
 	}
 
+	// 边界为NULL时, BETWEEN的结果为FALSE或UNKNOWN, 不匹配任何行; NOT BETWEEN仍可能匹配另一侧的行, 不确定分片
+	if leftValue == nil || rightValue == nil {
+		if n.Not {
+			return rule.GetSubTableIndexes(), nil
+		}
+		return []int{}, nil
+	}
+
 	start, err := rule.FindTableIndex(leftValue)
 	if err != nil {
 		return nil, fmt.Errorf("FindTableIndex for n.Left error: %v", err)
@@ -164,13 +172,13 @@ func getShardBetweenExprRouteResult(rule router.Rule, n *ast.BetweenExpr) ([]int
 			start = adjustShardIndex(rangeShard, leftValue, start)
 		}
 
-		l1 := makeList(rule.GetFirstTableIndex(), start+1)
-		l2 := makeList(last, rule.GetLastTableIndex()+1)
+		l1 := makeRangeList(rule.GetFirstTableIndex(), start+1, rule.GetSubTableIndexes())
+		l2 := makeRangeList(last, rule.GetLastTableIndex()+1, rule.GetSubTableIndexes())
 		return unionList(l1, l2), nil
 
 	}
 	if start > last {
 		start, last = last, start
 	}
-	return makeList(start, last+1), nil
+	return makeRangeList(start, last+1, rule.GetSubTableIndexes()), nil
 }
@@ -119,6 +119,10 @@ func getPatternInRouteResult(n *ast.ColumnName, isNotIn bool, rule router.Rule,
 	if isNotIn {
 		indexes := rule.GetSubTableIndexes()
 		valueMap := getBroadcastValueMap(indexes, values)
+		// 列表中有NULL时, NOT IN的结果为FALSE或UNKNOWN, 不匹配任何行
+		if hasNullValue(values) {
+			return []int{}, valueMap, nil
+		}
 		return indexes, valueMap, nil
 	}
 	if rule.GetShardingColumn() != column {
@@ -127,7 +131,8 @@ func getPatternInRouteResult(n *ast.ColumnName, isNotIn bool, rule router.Rule,
 		return indexes, valueMap, nil
 	}
 
-	var indexes []int
+	indexes := []int{}
+	var nullValues []ast.ExprNode
 	valueMap := make(map[int][]ast.ExprNode)
 	for _, vi := range values {
 		v, _ := vi.(*driver.ValueExpr)
@@ -135,6 +140,11 @@ func getPatternInRouteResult(n *ast.ColumnName, isNotIn bool, rule router.Rule,
 		if err != nil {
 			return nil, nil, err
 		}
+		// NULL不等于任何值, 不参与路由
+		if value == nil {
+			nullValues = append(nullValues, vi)
+			continue
+		}
 		idx, err := rule.FindTableIndex(value)
 		if err != nil {
 			return nil, nil, err
@@ -144,10 +154,23 @@ func getPatternInRouteResult(n *ast.ColumnName, isNotIn bool, rule router.Rule,
 		}
 		valueMap[idx] = append(valueMap[idx], vi)
 	}
+	// NULL保留在每个分片的列表中, 使IN在没有匹配值时的结果仍然为UNKNOWN
+	for _, idx := range indexes {
+		valueMap[idx] = append(valueMap[idx], nullValues...)
+	}
 	sort.Ints(indexes)
 	return indexes, valueMap, nil
 }
 
+func hasNullValue(values []ast.ExprNode) bool {
+	for _, v := range values {
+		if ve, ok := v.(*driver.ValueExpr); ok && ve.Datum.IsNull() {
+			return true
+		}
+	}
+	return false
+}
+
 // 所有的值类型必须为*driver.ValueExpr
 func checkValueType(values []ast.ExprNode) error {
 	for i, v := range values {
@@ -188,7 +211,12 @@ func (p *PatternInExprDecorator) Restore(ctx *format.RestoreCtx) error {
 	}
 
 	ctx.WritePlain("(")
-	for i, expr := range p.indexValueMap[tableIndex] {
+	values := p.indexValueMap[tableIndex]
+	if len(values) == 0 {
+		// OR的其他条件路由到的分片上, IN列表中没有值匹配, 结果为FALSE或UNKNOWN, 用NULL保持语句合法
+		ctx.WriteKeyWord("NULL")
+	}
+	for i, expr := range values {
 		if i != 0 {
 			ctx.WritePlain(",")
 		}
@@ -0,0 +1,154 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// distinctRowSetEntrySize is the estimated memory in bytes of a row reference and its hash bucket in distinctRowSet
+const distinctRowSetEntrySize = 64
+
+// distinctRowSet is a hash set of result rows used by SELECT DISTINCT.
+// Rows are hashed by the first columnCount columns and kept by reference without copying,
+// rows with the same hash are compared by value to handle hash collision.
+// Strings of columns with collation are compared by their weight strings.
+// Memory of the set is counted in tracker, adding rows fails if the limit of tracker is exceeded.
+type distinctRowSet struct {
+	columnCount int
+	collators   []mysql.Collator
+	buckets     map[uint64][][]interface{}
+
+	tracker  *util.MemoryTracker // nil means no limit
+	consumed int64
+}
+
+func newDistinctRowSet(columnCount int, fields []*mysql.Field, tracker *util.MemoryTracker) *distinctRowSet {
+	return &distinctRowSet{
+		columnCount: columnCount,
+		collators:   getColumnCollators(fields),
+		buckets:     make(map[uint64][][]interface{}),
+		tracker:     tracker,
+	}
+}
+
+// add add row to set, return false if the same row exists
+func (s *distinctRowSet) add(row []interface{}) (bool, error) {
+	h, err := s.hash(row)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range s.buckets[h] {
+		equal, err := s.equal(r, row)
+		if err != nil {
+			return false, err
+		}
+		if equal {
+			return false, nil
+		}
+	}
+
+	if s.tracker != nil {
+		s.consumed += distinctRowSetEntrySize
+		if !s.tracker.Consume(distinctRowSetEntrySize) {
+			return false, mysql.NewErrf(mysql.ErrOutofMemory, "query memory exceeds max_query_memory: %d bytes", s.tracker.Limit())
+		}
+	}
+	s.buckets[h] = append(s.buckets[h], row)
+	return true, nil
+}
+
+// release release memory of the set counted in tracker, the set can't be used after released
+func (s *distinctRowSet) release() {
+	if s.tracker != nil {
+		s.tracker.Release(s.consumed)
+		s.consumed = 0
+	}
+	s.buckets = nil
+}
+
+func (s *distinctRowSet) hash(row []interface{}) (uint64, error) {
+	h := fnv.New64a()
+	var lenBuf [binary.MaxVarintLen64]byte
+	for i := 0; i < s.columnCount; i++ {
+		// NULL与字符串"NULL"不同, 用长度-1区分
+		if row[i] == nil {
+			n := binary.PutVarint(lenBuf[:], -1)
+			h.Write(lenBuf[:n])
+			continue
+		}
+		b, err := collationKey(s.collators, i, row[i])
+		if err != nil {
+			return 0, err
+		}
+		n := binary.PutVarint(lenBuf[:], int64(len(b)))
+		h.Write(lenBuf[:n])
+		h.Write(b)
+	}
+	return h.Sum64(), nil
+}
+
+func (s *distinctRowSet) equal(r1, r2 []interface{}) (bool, error) {
+	for i := 0; i < s.columnCount; i++ {
+		if r1[i] == nil || r2[i] == nil {
+			if r1[i] != nil || r2[i] != nil {
+				return false, nil
+			}
+			continue
+		}
+		b1, err := collationKey(s.collators, i, r1[i])
+		if err != nil {
+			return false, err
+		}
+		b2, err := collationKey(s.collators, i, r2[i])
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(b1, b2) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// getColumnCollators return collators of string columns, items of other columns are nil
+func getColumnCollators(fields []*mysql.Field) []mysql.Collator {
+	collators := make([]mysql.Collator, len(fields))
+	for i, f := range fields {
+		if c, ok := mysql.FieldCollator(f); ok {
+			collators[i] = c
+		}
+	}
+	return collators
+}
+
+// collationKey return weight string of string value if the column has collation, or formatted value otherwise
+func collationKey(collators []mysql.Collator, column int, v interface{}) ([]byte, error) {
+	if column < len(collators) && collators[column] != nil {
+		switch s := v.(type) {
+		case string:
+			return collators[column].WeightString(nil, []byte(s)), nil
+		case []byte:
+			return collators[column].WeightString(nil, s), nil
+		}
+	}
+	return formatValue(v)
+}
@@ -0,0 +1,375 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// havingFilter evaluates HAVING condition on merged aggregate results.
+// 路由到多个分片时, 各分片只有部分数据的聚合结果, HAVING不能下推到分片,
+// 需要在proxy合并聚合结果之后计算, 只保留条件为TRUE的行.
+type havingFilter struct {
+	eval havingExprFunc
+}
+
+// havingExprFunc 计算表达式在一行合并结果上的值, fields用于获取字符串列的排序规则
+type havingExprFunc func(row ResultRow, fields []*mysql.Field) (interface{}, error)
+
+// newHavingFilter compile HAVING expression, columns are result column indexes of
+// aggregate functions and columns in the expression.
+func newHavingFilter(expr ast.ExprNode, columns map[ast.ExprNode]int) (*havingFilter, error) {
+	c := &havingCompiler{columns: columns}
+	eval, err := c.compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &havingFilter{eval: eval}, nil
+}
+
+// filter remove rows whose HAVING condition is FALSE or UNKNOWN
+func (h *havingFilter) filter(r *mysql.Result) error {
+	values := r.Values[:0]
+	for _, v := range r.Values {
+		if v == nil {
+			continue
+		}
+		ret, err := h.eval(ResultRow(v), r.Fields)
+		if err != nil {
+			return err
+		}
+		truth, err := mysql.TruthValueOf(ret)
+		if err != nil {
+			return err
+		}
+		if truth == mysql.TruthTrue {
+			values = append(values, v)
+		}
+	}
+	r.Values = values
+	r.RowDatas = nil
+	return nil
+}
+
+// havingCompiler 把HAVING表达式编译为在结果行上计算的函数,
+// 在生成执行计划时编译, 不支持的表达式在生成执行计划时返回错误.
+type havingCompiler struct {
+	columns map[ast.ExprNode]int
+}
+
+func (c *havingCompiler) compile(expr ast.ExprNode) (havingExprFunc, error) {
+	if idx, ok := c.columns[expr]; ok {
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			if idx >= len(row) {
+				return nil, fmt.Errorf("field index out of bound: %d", idx)
+			}
+			return row.GetValue(idx), nil
+		}, nil
+	}
+
+	switch x := expr.(type) {
+	case *ast.ParenthesesExpr:
+		return c.compile(x.Expr)
+	case *driver.ValueExpr:
+		v, err := getHavingConstValue(x)
+		if err != nil {
+			return nil, err
+		}
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			return v, nil
+		}, nil
+	case *ast.BinaryOperationExpr:
+		return c.compileBinaryOperation(x)
+	case *ast.UnaryOperationExpr:
+		return c.compileUnaryOperation(x)
+	case *ast.IsNullExpr:
+		e, err := c.compile(x.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			v, err := e(row, fields)
+			if err != nil {
+				return nil, err
+			}
+			return mysql.EvalIs(v, mysql.TruthUnknown, x.Not)
+		}, nil
+	case *ast.IsTruthExpr:
+		e, err := c.compile(x.Expr)
+		if err != nil {
+			return nil, err
+		}
+		truth := mysql.TruthFalse
+		if x.True != 0 {
+			truth = mysql.TruthTrue
+		}
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			v, err := e(row, fields)
+			if err != nil {
+				return nil, err
+			}
+			return mysql.EvalIs(v, truth, x.Not)
+		}, nil
+	case *ast.PatternInExpr:
+		return c.compilePatternIn(x)
+	case *ast.BetweenExpr:
+		return c.compileBetween(x)
+	}
+	return nil, fmt.Errorf("unsupported expression in HAVING: %T", expr)
+}
+
+var havingCompareOps = map[opcode.Op]mysql.CompareOp{
+	opcode.EQ:     mysql.CompareEQ,
+	opcode.NE:     mysql.CompareNE,
+	opcode.LT:     mysql.CompareLT,
+	opcode.LE:     mysql.CompareLE,
+	opcode.GT:     mysql.CompareGT,
+	opcode.GE:     mysql.CompareGE,
+	opcode.NullEQ: mysql.CompareNullSafeEQ,
+}
+
+var havingArithmeticOps = map[opcode.Op]func(v1, v2 interface{}) (interface{}, error){
+	opcode.Plus:  mysql.AddValues,
+	opcode.Minus: mysql.SubValues,
+	opcode.Mul:   mysql.MulValues,
+	opcode.Div:   mysql.DivValues,
+}
+
+func (c *havingCompiler) compileBinaryOperation(expr *ast.BinaryOperationExpr) (havingExprFunc, error) {
+	l, err := c.compile(expr.L)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.compile(expr.R)
+	if err != nil {
+		return nil, err
+	}
+
+	switch expr.Op {
+	case opcode.LogicAnd, opcode.LogicOr, opcode.LogicXor:
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			lt, err := evalHavingTruth(l, row, fields)
+			if err != nil {
+				return nil, err
+			}
+			rt, err := evalHavingTruth(r, row, fields)
+			if err != nil {
+				return nil, err
+			}
+			switch expr.Op {
+			case opcode.LogicAnd:
+				return lt.And(rt).Value(), nil
+			case opcode.LogicOr:
+				return lt.Or(rt).Value(), nil
+			default:
+				return lt.Xor(rt).Value(), nil
+			}
+		}, nil
+	}
+
+	if op, ok := havingCompareOps[expr.Op]; ok {
+		collation := c.compileCollation(expr.L, expr.R)
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			v1, v2, err := evalHavingOperands(l, r, row, fields)
+			if err != nil {
+				return nil, err
+			}
+			return mysql.EvalCompare(op, v1, v2, collation(fields))
+		}, nil
+	}
+
+	if fn, ok := havingArithmeticOps[expr.Op]; ok {
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			v1, v2, err := evalHavingOperands(l, r, row, fields)
+			if err != nil {
+				return nil, err
+			}
+			return fn(v1, v2)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator in HAVING: %s", expr.Op)
+}
+
+func (c *havingCompiler) compileUnaryOperation(expr *ast.UnaryOperationExpr) (havingExprFunc, error) {
+	e, err := c.compile(expr.V)
+	if err != nil {
+		return nil, err
+	}
+
+	switch expr.Op {
+	case opcode.Not:
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			t, err := evalHavingTruth(e, row, fields)
+			if err != nil {
+				return nil, err
+			}
+			return t.Not().Value(), nil
+		}, nil
+	case opcode.Minus:
+		return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+			v, err := e(row, fields)
+			if err != nil {
+				return nil, err
+			}
+			return mysql.SubValues(int64(0), v)
+		}, nil
+	case opcode.Plus:
+		return e, nil
+	}
+	return nil, fmt.Errorf("unsupported operator in HAVING: %s", expr.Op)
+}
+
+func (c *havingCompiler) compilePatternIn(expr *ast.PatternInExpr) (havingExprFunc, error) {
+	if expr.Sel != nil {
+		return nil, fmt.Errorf("subquery in HAVING is not supported")
+	}
+	e, err := c.compile(expr.Expr)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]havingExprFunc, 0, len(expr.List))
+	for _, item := range expr.List {
+		f, err := c.compile(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, f)
+	}
+	collation := c.compileCollation(append([]ast.ExprNode{expr.Expr}, expr.List...)...)
+
+	return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+		v, err := e(row, fields)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0, len(list))
+		for _, f := range list {
+			item, err := f(row, fields)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, item)
+		}
+		ret, err := mysql.EvalIn(v, values, collation(fields))
+		if err != nil || !expr.Not {
+			return ret, err
+		}
+		t, err := mysql.TruthValueOf(ret)
+		if err != nil {
+			return nil, err
+		}
+		return t.Not().Value(), nil
+	}, nil
+}
+
+// x BETWEEN a AND b 等价于 x >= a AND x <= b
+func (c *havingCompiler) compileBetween(expr *ast.BetweenExpr) (havingExprFunc, error) {
+	e, err := c.compile(expr.Expr)
+	if err != nil {
+		return nil, err
+	}
+	left, err := c.compile(expr.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.compile(expr.Right)
+	if err != nil {
+		return nil, err
+	}
+	collation := c.compileCollation(expr.Expr, expr.Left, expr.Right)
+
+	return func(row ResultRow, fields []*mysql.Field) (interface{}, error) {
+		v, err := e(row, fields)
+		if err != nil {
+			return nil, err
+		}
+		lv, rv, err := evalHavingOperands(left, right, row, fields)
+		if err != nil {
+			return nil, err
+		}
+		ge, err := mysql.EvalCompare(mysql.CompareGE, v, lv, collation(fields))
+		if err != nil {
+			return nil, err
+		}
+		le, err := mysql.EvalCompare(mysql.CompareLE, v, rv, collation(fields))
+		if err != nil {
+			return nil, err
+		}
+		t1, _ := mysql.TruthValueOf(ge)
+		t2, _ := mysql.TruthValueOf(le)
+		t := t1.And(t2)
+		if expr.Not {
+			t = t.Not()
+		}
+		return t.Value(), nil
+	}, nil
+}
+
+// compileCollation 字符串比较使用第一个结果列的排序规则, 都是常量时使用默认排序规则
+func (c *havingCompiler) compileCollation(exprs ...ast.ExprNode) func(fields []*mysql.Field) mysql.CollationID {
+	column := -1
+	for _, expr := range exprs {
+		if idx, ok := c.columns[expr]; ok {
+			column = idx
+			break
+		}
+	}
+	return func(fields []*mysql.Field) mysql.CollationID {
+		if column < 0 || column >= len(fields) {
+			return mysql.DefaultCollationID
+		}
+		return mysql.CollationID(fields[column].Charset)
+	}
+}
+
+func evalHavingOperands(l, r havingExprFunc, row ResultRow, fields []*mysql.Field) (interface{}, interface{}, error) {
+	v1, err := l(row, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	v2, err := r(row, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v1, v2, nil
+}
+
+func evalHavingTruth(e havingExprFunc, row ResultRow, fields []*mysql.Field) (mysql.TruthValue, error) {
+	v, err := e(row, fields)
+	if err != nil {
+		return mysql.TruthUnknown, err
+	}
+	return mysql.TruthValueOf(v)
+}
+
+// getHavingConstValue 常量转换为结果集中的值, DECIMAL常量转换为mysql.Decimal, 避免比较时丢失精度
+func getHavingConstValue(v *driver.ValueExpr) (interface{}, error) {
+	switch v.Kind() {
+	case types.KindMysqlDecimal:
+		return mysql.ParseDecimal(v.GetMysqlDecimal().String())
+	case types.KindBinaryLiteral, types.KindMysqlBit:
+		return []byte(v.GetBinaryLiteral()), nil
+	}
+	return util.GetValueExprResult(v)
+}
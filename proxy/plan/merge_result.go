@@ -21,6 +21,7 @@ import (
 	"strings"
 
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util"
 	"github.com/XiaoMi/Gaea/util/hack"
 	"github.com/XiaoMi/Gaea/util/math"
 )
@@ -92,6 +93,29 @@ func (r ResultRow) GetFloat(column int) (float64, error) {
 	}
 }
 
+// GetDecimal get decimal value from column
+func (r ResultRow) GetDecimal(column int) (mysql.Decimal, error) {
+	d := r[column]
+	switch v := d.(type) {
+	case mysql.Decimal:
+		return v, nil
+	case uint64:
+		return mysql.NewDecimalFromUint(v), nil
+	case int64:
+		return mysql.NewDecimalFromInt(v), nil
+	case float64:
+		return mysql.NewDecimalFromFloat(v)
+	case string:
+		return mysql.ParseDecimal(v)
+	case []byte:
+		return mysql.ParseDecimal(string(v))
+	case nil:
+		return mysql.Decimal{}, nil
+	default:
+		return mysql.Decimal{}, fmt.Errorf("data type is %T", v)
+	}
+}
+
 // SetValue set value to column
 func (r ResultRow) SetValue(column int, value interface{}) {
 	r[column] = value
@@ -108,6 +132,13 @@ type AggregateFuncMerger interface {
 	MergeTo(from, to ResultRow) error
 }
 
+// AggregateFuncFinisher is implemented by the merger of aggregate function
+// whose final value can only be calculated after all rows are merged, like AVG()
+type AggregateFuncFinisher interface {
+	// Finish 计算聚合结果行的最终值
+	Finish(row ResultRow) error
+}
+
 type aggregateFuncBaseMerger struct {
 	fieldIndex int // 所在列位置
 }
@@ -180,7 +211,14 @@ func (a *AggregateFuncSumMerger) MergeTo(from, to ResultRow) error {
 		return nil
 	}
 
+	// DECIMAL column is summed exactly
+	if _, ok := fromValueI.(mysql.Decimal); ok {
+		return a.sumToDecimal(from, to)
+	}
+
 	switch to.GetValue(idx).(type) {
+	case mysql.Decimal:
+		return a.sumToDecimal(from, to)
 	case int64:
 		return a.sumToInt64(from, to)
 	case uint64:
@@ -236,6 +274,20 @@ func (a *AggregateFuncSumMerger) sumToFloat64(from, to ResultRow) error {
 	return nil
 }
 
+func (a *AggregateFuncSumMerger) sumToDecimal(from, to ResultRow) error {
+	idx := a.fieldIndex // does not need to check
+	valueToMerge, err := from.GetDecimal(idx)
+	if err != nil {
+		return fmt.Errorf("get from decimal value error: %v", err)
+	}
+	originValue, err := to.GetDecimal(idx)
+	if err != nil {
+		return fmt.Errorf("get to decimal value error: %v", err)
+	}
+	to.SetValue(idx, originValue.Add(valueToMerge))
+	return nil
+}
+
 // AggregateFuncMaxMerger merge MAX() column in result
 type AggregateFuncMaxMerger struct {
 	aggregateFuncBaseMerger
@@ -336,6 +388,75 @@ func (a *AggregateFuncMinMerger) MergeTo(from, to ResultRow) error {
 	}
 }
 
+// AggregateFuncAvgMerger merge AVG() column in result.
+// AVG() is rewritten to SUM() in the origin column and COUNT() in an extra column,
+// the sum and count are merged separately, and the average is calculated in Finish().
+type AggregateFuncAvgMerger struct {
+	sum   *AggregateFuncSumMerger
+	count *AggregateFuncCountMerger
+}
+
+// CreateAggregateFuncAvgMerger create AggregateFuncAvgMerger
+func CreateAggregateFuncAvgMerger(sumIndex, countIndex int) *AggregateFuncAvgMerger {
+	ret := &AggregateFuncAvgMerger{
+		sum:   new(AggregateFuncSumMerger),
+		count: new(AggregateFuncCountMerger),
+	}
+	ret.sum.fieldIndex = sumIndex
+	ret.count.fieldIndex = countIndex
+	return ret
+}
+
+// MergeTo implement AggregateFuncMerger
+func (a *AggregateFuncAvgMerger) MergeTo(from, to ResultRow) error {
+	if err := a.sum.MergeTo(from, to); err != nil {
+		return fmt.Errorf("merge sum error: %v", err)
+	}
+	if err := a.count.MergeTo(from, to); err != nil {
+		return fmt.Errorf("merge count error: %v", err)
+	}
+	return nil
+}
+
+// Finish implement AggregateFuncFinisher
+func (a *AggregateFuncAvgMerger) Finish(row ResultRow) error {
+	sumIdx, countIdx := a.sum.fieldIndex, a.count.fieldIndex
+	if sumIdx >= len(row) || countIdx >= len(row) {
+		return fmt.Errorf("field index out of bound: %d, %d", sumIdx, countIdx)
+	}
+
+	count, err := row.GetInt(countIdx)
+	if err != nil {
+		return fmt.Errorf("get count value error: %v", err)
+	}
+	// 与MySQL一致, 没有非NULL值时AVG()返回NULL
+	if count == 0 || row.GetValue(sumIdx) == nil {
+		row.SetValue(sumIdx, nil)
+		return nil
+	}
+
+	// 与MySQL一致, DECIMAL的平均值小数位数增加div_precision_increment位
+	if sum, ok := row.GetValue(sumIdx).(mysql.Decimal); ok {
+		scale := sum.Scale() + mysql.DivPrecisionIncrement
+		if scale > mysql.DecimalMaxScale {
+			scale = mysql.DecimalMaxScale
+		}
+		avg, err := sum.Div(mysql.NewDecimalFromInt(count), scale)
+		if err != nil {
+			return fmt.Errorf("calculate avg value error: %v", err)
+		}
+		row.SetValue(sumIdx, avg)
+		return nil
+	}
+
+	sum, err := row.GetFloat(sumIdx)
+	if err != nil {
+		return fmt.Errorf("get sum value error: %v", err)
+	}
+	row.SetValue(sumIdx, sum/float64(count))
+	return nil
+}
+
 // MergeExecResult merge execution results, like UPDATE, INSERT, DELETE, ...
 func MergeExecResult(rs []*mysql.Result) (*mysql.Result, error) {
 	r := new(mysql.Result)
@@ -354,12 +475,20 @@ func MergeExecResult(rs []*mysql.Result) (*mysql.Result, error) {
 	return r, nil
 }
 
-// MergeSelectResult merge select results
-func MergeSelectResult(p *SelectPlan, stmt *ast.SelectStmt, rs []*mysql.Result) (*mysql.Result, error) {
+// MergeSelectResult merge select results, memory used in merging is counted in tracker if it's not nil
+func MergeSelectResult(p *SelectPlan, stmt *ast.SelectStmt, rs []*mysql.Result, tracker *util.MemoryTracker) (*mysql.Result, error) {
+	if canMergeSortedResultSet(p, stmt, rs) {
+		return mergeSortedSelectResult(p, rs)
+	}
+
 	ret := mergeMultiResultSet(rs)
 
+	if err := convertDecimalAggregateColumns(p, ret); err != nil {
+		return nil, err
+	}
+
 	if p.distinct {
-		if err := removeDistinctRowInResult(p, ret); err != nil {
+		if err := removeDistinctRowInResult(p, ret, tracker); err != nil {
 			return nil, err
 		}
 	}
@@ -374,6 +503,16 @@ func MergeSelectResult(p *SelectPlan, stmt *ast.SelectStmt, rs []*mysql.Result)
 		}
 	}
 
+	if err := finishAggregateFuncs(p, ret); err != nil {
+		return nil, err
+	}
+
+	if p.having != nil {
+		if err := p.having.filter(ret); err != nil {
+			return nil, fmt.Errorf("filter having error: %v", err)
+		}
+	}
+
 	if err := sortSelectResult(p, stmt, ret); err != nil {
 		return nil, err
 	}
@@ -393,6 +532,52 @@ func MergeSelectResult(p *SelectPlan, stmt *ast.SelectStmt, rs []*mysql.Result)
 	return ret, nil
 }
 
+// 只有ORDER BY, 没有DISTINCT, GROUP BY和聚合函数时, 各分片结果已经按ORDER BY列排好序,
+// 可以直接归并, 不需要合并后再整体排序
+func canMergeSortedResultSet(p *SelectPlan, stmt *ast.SelectStmt, rs []*mysql.Result) bool {
+	if len(rs) < 2 || !p.HasOrderBy() || p.distinct || stmt.GroupBy != nil || len(p.aggregateFuncs) != 0 {
+		return false
+	}
+	for _, r := range rs {
+		if r == nil || r.Resultset == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeSortedSelectResult merge sorted results of shards by ORDER BY columns with k-way merge,
+// if there is a LIMIT clause, only offset + count rows are merged.
+func mergeSortedSelectResult(p *SelectPlan, rs []*mysql.Result) (*mysql.Result, error) {
+	var limit int64 = -1
+	if p.HasLimit() {
+		start, count := p.GetLimitValue()
+		limit = start + count
+	}
+
+	resultsets := make([]*mysql.Resultset, 0, len(rs))
+	ret := &mysql.Result{}
+	for _, r := range rs {
+		ret.Status |= r.Status
+		resultsets = append(resultsets, r.Resultset)
+	}
+	ret.Resultset = mysql.MergeSortedResultsets(resultsets, getOrderBySortKeys(p, len(rs[0].Fields)), limit)
+
+	if err := limitSelectResult(p, ret); err != nil {
+		return nil, err
+	}
+
+	if err := trimExtraFields(p, ret); err != nil {
+		return nil, fmt.Errorf("trimExtraFields error: %v", err)
+	}
+
+	if err := GenerateSelectResultRowData(ret); err != nil {
+		return nil, fmt.Errorf("generate RowData error: %v", err)
+	}
+
+	return ret, nil
+}
+
 // 合并结果集, 返回一个Result
 func mergeMultiResultSet(rs []*mysql.Result) *mysql.Result {
 	if len(rs) == 1 {
@@ -409,70 +594,117 @@ func mergeMultiResultSet(rs []*mysql.Result) *mysql.Result {
 	return rs[0]
 }
 
-func removeDistinctRowInResult(p *SelectPlan, r *mysql.Result) error {
-	distinctKeySet := make(map[string]bool)
-	var rowToRemove []int
-	// 计算除补列之外的原始列数
-	resultFieldLength := len(r.Fields)
-	originColumnCount := p.GetColumnCount()
-	deltaColumnCount := resultFieldLength - originColumnCount
-	colCnt := p.originColumnCount + deltaColumnCount
+// SUM()和AVG()的DECIMAL列转换为mysql.Decimal后再合并, 避免转换为float64丢失精度.
+// 文本协议的DECIMAL值已被解析为float64, 从RowData中重新读取原始值
+func convertDecimalAggregateColumns(p *SelectPlan, r *mysql.Result) error {
+	if len(p.aggregateFuncs) == 0 || r.Resultset == nil {
+		return nil
+	}
 
-	// 根据原始列的值进行去重
-	rowCount := len(r.Values)
-	for i := 0; i < rowCount; i++ {
-		keySlice := r.Values[i][0:colCnt]
-		mk, err := generateMapKey(keySlice)
-		if err != nil {
-			return err
+	for _, mfunc := range p.aggregateFuncs {
+		var idx int
+		switch m := mfunc.(type) {
+		case *AggregateFuncSumMerger:
+			idx = m.fieldIndex
+		case *AggregateFuncAvgMerger:
+			idx = m.sum.fieldIndex
+		default:
+			continue
+		}
+		if idx >= len(r.Fields) || (r.Fields[idx].Type != mysql.TypeNewDecimal && r.Fields[idx].Type != mysql.TypeDecimal) {
+			continue
 		}
 
-		_, ok := distinctKeySet[mk]
-		if !ok {
-			distinctKeySet[mk] = true
-		} else {
-			rowToRemove = append(rowToRemove, i)
+		for i, row := range r.Values {
+			var d mysql.Decimal
+			var err error
+			switch v := row[idx].(type) {
+			case nil, mysql.Decimal:
+				continue
+			case float64:
+				if len(r.RowDatas) != len(r.Values) {
+					d, err = mysql.NewDecimalFromFloat(v)
+					break
+				}
+				var raw []byte
+				if raw, _, err = r.RowDatas[i].TextColumn(idx); err == nil {
+					d, err = mysql.ParseDecimal(string(raw))
+				}
+			default:
+				d, err = ResultRow(row).GetDecimal(idx)
+			}
+			if err != nil {
+				return fmt.Errorf("convert decimal column %d error: %v", idx, err)
+			}
+			row[idx] = d
 		}
 	}
+	return nil
+}
 
-	rowToRemoveCnt := len(rowToRemove)
-	if rowToRemoveCnt == 0 {
-		return nil
-	}
+// 根据原始列的值去重, 保留每组重复行中第一次出现的行
+// 去重集合中只保存行的哈希值和引用, 不复制行数据, 内存占用只与不重复的行数有关,
+// 计入语句的内存统计, 超过max_query_memory时中止语句并返回错误
+// 没有ORDER BY, GROUP BY和聚合函数时, 得到足够LIMIT的行后就不再继续处理
+func removeDistinctRowInResult(p *SelectPlan, r *mysql.Result, tracker *util.MemoryTracker) error {
+	// 计算除补列之外的原始列数
+	resultFieldLength := len(r.Fields)
+	originColumnCount := p.GetColumnCount()
+	deltaColumnCount := resultFieldLength - originColumnCount
+	colCnt := p.originColumnCount + deltaColumnCount
 
-	originRows := r.Values
-	r.RowDatas = nil
-	r.Values = originRows[:0]
+	maxRowCount := -1
+	if p.HasLimit() && !p.HasOrderBy() && !p.HasGroupBy() && len(p.aggregateFuncs) == 0 {
+		start, count := p.GetLimitValue()
+		maxRowCount = int(start + count)
+	}
 
-	var j int
-	for i := 0; i < rowCount; i++ {
-		if j == rowToRemoveCnt {
-			r.Values = append(r.Values, originRows[i:]...)
+	rowSet := newDistinctRowSet(colCnt, r.Fields, tracker)
+	defer rowSet.release()
+	values := r.Values[:0]
+	for _, row := range r.Values {
+		if maxRowCount >= 0 && len(values) >= maxRowCount {
 			break
 		}
-		if i == rowToRemove[j] {
-			j++
-		} else {
-			r.Values = append(r.Values, originRows[i])
+		added, err := rowSet.add(row)
+		if err != nil {
+			return err
+		}
+		if added {
+			values = append(values, row)
 		}
 	}
 
+	if len(values) != len(r.Values) {
+		r.Values = values
+		r.RowDatas = nil
+	}
 	return nil
 }
 
 // contains mergeGroupByWithoutFunc() and mergeGroupByWithFunc()
+// 各分片返回的是分片内的部分分组结果, 这里按group by列的值重新分组合并, 分组按首次出现的顺序输出
 func buildSelectGroupByResult(p *SelectPlan, r *mysql.Result) error {
 	resultMap := make(map[string]ResultRow)
+	var groupKeys []string
 
 	resultFieldLength := len(r.Fields)
 	originColumnCount := p.GetColumnCount()
 	deltaColumnCount := resultFieldLength - originColumnCount
 
+	// 字符串分组列按排序规则的weight string分组, 与MySQL一致, 如utf8mb4_general_ci下'a'与'A'为同一组
+	collators := getColumnCollators(r.Fields)
+
 	// 根据group by的列进行结果聚合
 	for i, v := range r.Values {
 		keySlice := make([]interface{}, 0)
 		for _, index := range p.GetGroupByColumnInfo() {
-			keySlice = append(keySlice, v[index+deltaColumnCount])
+			column := index + deltaColumnCount
+			key, err := collationKey(collators, column, v[column])
+			if err != nil {
+				return err
+			}
+			keySlice = append(keySlice, key)
 		}
 		mk, err := generateMapKey(keySlice)
 		if err != nil {
@@ -483,6 +715,7 @@ func buildSelectGroupByResult(p *SelectPlan, r *mysql.Result) error {
 		_, ok := resultMap[mk]
 		if !ok {
 			resultMap[mk] = ResultRow(r.Values[i])
+			groupKeys = append(groupKeys, mk)
 			continue
 		}
 
@@ -499,7 +732,7 @@ func buildSelectGroupByResult(p *SelectPlan, r *mysql.Result) error {
 		}
 	}
 
-	err := buildResultFromResultMap(r, resultMap)
+	err := buildResultFromResultMap(r, groupKeys, resultMap)
 	if err != nil {
 		return fmt.Errorf("buildResultFromResultMap error: %v", err)
 	}
@@ -539,8 +772,27 @@ func buildSelectOnlyResult(p *SelectPlan, rs *mysql.Result) error {
 	return nil
 }
 
+// 所有行合并完成后, 计算需要最终处理的聚合列的值, 如AVG()
+func finishAggregateFuncs(p *SelectPlan, r *mysql.Result) error {
+	for _, mfunc := range p.aggregateFuncs {
+		finisher, ok := mfunc.(AggregateFuncFinisher)
+		if !ok {
+			continue
+		}
+		for _, v := range r.Values {
+			if v == nil {
+				continue
+			}
+			if err := finisher.Finish(ResultRow(v)); err != nil {
+				return fmt.Errorf("Finish error, func: %v, value: %v, err: %v", mfunc, v, err)
+			}
+		}
+	}
+	return nil
+}
+
 // this function modifies the first value of origin results
-func buildResultFromResultMap(r *mysql.Result, resultMap map[string]ResultRow) error {
+func buildResultFromResultMap(r *mysql.Result, keys []string, resultMap map[string]ResultRow) error {
 	// no group by result means the result row count is 0, so return the first result
 	if len(resultMap) == 0 {
 		return nil
@@ -548,8 +800,8 @@ func buildResultFromResultMap(r *mysql.Result, resultMap map[string]ResultRow) e
 
 	r.Values = nil
 	r.RowDatas = nil
-	for _, v := range resultMap {
-		r.Values = append(r.Values, v)
+	for _, k := range keys {
+		r.Values = append(r.Values, resultMap[k])
 	}
 
 	return nil
@@ -558,10 +810,7 @@ func buildResultFromResultMap(r *mysql.Result, resultMap map[string]ResultRow) e
 // 去掉补充的列
 // 与补充列的顺序相反, 先去掉ORDER BY补充的列, 再去掉GROUP BY补充的列
 func trimExtraFields(p *SelectPlan, r *mysql.Result) error {
-	resultFieldLength := len(r.Fields)
-	originColumnCount := p.GetColumnCount()
-	deltaColumnCount := resultFieldLength - originColumnCount
-	extraFieldStartIndex := deltaColumnCount + p.GetOriginColumnCount()
+	extraFieldStartIndex := p.GetExtraFieldStartIndex(len(r.Fields))
 
 	if extraFieldStartIndex != -1 {
 		r.Fields = r.Fields[0:extraFieldStartIndex]
@@ -575,10 +824,36 @@ func trimExtraFields(p *SelectPlan, r *mysql.Result) error {
 
 func sortSelectResult(p *SelectPlan, stmt *ast.SelectStmt, ret *mysql.Result) error {
 	if !p.HasOrderBy() {
+		// 与MySQL 5.7一致, 没有ORDER BY时按GROUP BY的列排序
+		if stmt.GroupBy != nil && p.HasGroupBy() {
+			return ret.SortWithoutColumnName(getGroupBySortKeys(p, stmt, len(ret.Fields)))
+		}
 		return nil
 	}
 
-	resultFieldLength := len(ret.Fields)
+	return ret.SortWithoutColumnName(getOrderBySortKeys(p, len(ret.Fields)))
+}
+
+func getGroupBySortKeys(p *SelectPlan, stmt *ast.SelectStmt, resultFieldLength int) []mysql.SortKey {
+	originColumnCount := p.GetColumnCount()
+	deltaColumnCount := resultFieldLength - originColumnCount
+
+	groupByColumns := p.GetGroupByColumnInfo()
+	var sortKeys []mysql.SortKey
+	for i := 0; i < len(groupByColumns); i++ {
+		sortKey := mysql.SortKey{}
+		sortKey.Column = groupByColumns[i] + deltaColumnCount
+		if i < len(stmt.GroupBy.Items) && stmt.GroupBy.Items[i].Desc {
+			sortKey.Direction = mysql.SortDesc
+		} else {
+			sortKey.Direction = mysql.SortAsc
+		}
+		sortKeys = append(sortKeys, sortKey)
+	}
+	return sortKeys
+}
+
+func getOrderBySortKeys(p *SelectPlan, resultFieldLength int) []mysql.SortKey {
 	originColumnCount := p.GetColumnCount()
 	deltaColumnCount := resultFieldLength - originColumnCount
 
@@ -594,8 +869,7 @@ func sortSelectResult(p *SelectPlan, stmt *ast.SelectStmt, ret *mysql.Result) er
 		}
 		sortKeys = append(sortKeys, sortKey)
 	}
-
-	return ret.SortWithoutColumnName(sortKeys)
+	return sortKeys
 }
 
 // the result from backend is aggregated and offset = 0, count = (originOffset + originCount)
@@ -704,6 +978,8 @@ func formatValue(value interface{}) ([]byte, error) {
 		return v, nil
 	case string:
 		return hack.Slice(v), nil
+	case mysql.Decimal:
+		return hack.Slice(v.String()), nil
 	default:
 		return nil, fmt.Errorf("invalid type %T", value)
 	}
@@ -16,9 +16,13 @@ package plan
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/pingcap/parser/ast"
 )
 
 func TestLimitSelectResult(t *testing.T) {
@@ -63,3 +67,349 @@ func TestLimitSelectResult(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeSortedSelectResult(t *testing.T) {
+	// SELECT name FROM tbl ORDER BY id DESC LIMIT 1, 2, id is the extra column for ORDER BY
+	p := &SelectPlan{
+		orderByColumn:     []int{1},
+		orderByDirections: []bool{true},
+		originColumnCount: 1,
+		columnCount:       2,
+		offset:            1,
+		count:             2,
+	}
+
+	newResult := func(values ...[]interface{}) *mysql.Result {
+		return &mysql.Result{
+			Resultset: &mysql.Resultset{
+				Fields: []*mysql.Field{{Name: []byte("name")}, {Name: []byte("id")}},
+				Values: values,
+			},
+		}
+	}
+	rs := []*mysql.Result{
+		newResult([]interface{}{"a", int64(9)}, []interface{}{"b", int64(4)}),
+		newResult([]interface{}{"c", int64(7)}, []interface{}{"d", int64(6)}, []interface{}{"e", int64(1)}),
+	}
+
+	ret, err := mergeSortedSelectResult(p, rs)
+	if err != nil {
+		t.Fatalf("mergeSortedSelectResult error: %v", err)
+	}
+
+	expect := [][]interface{}{{"c"}, {"d"}}
+	if !reflect.DeepEqual(ret.Values, expect) {
+		t.Errorf("merged values not equal, expect: %v, actual: %v", expect, ret.Values)
+	}
+	if len(ret.Fields) != 1 || len(ret.RowDatas) != len(expect) {
+		t.Errorf("fields or row data not trimmed, fields: %d, row data: %d", len(ret.Fields), len(ret.RowDatas))
+	}
+}
+
+func TestAggregateFuncAvgMerger(t *testing.T) {
+	// SELECT AVG(score) is rewritten to SELECT SUM(score), COUNT(score)
+	tests := []struct {
+		rows   []ResultRow
+		expect interface{}
+	}{
+		{[]ResultRow{{"10", int64(4)}, {"5", int64(1)}, {"15", int64(5)}}, float64(3)},
+		{[]ResultRow{{float64(1.5), int64(1)}, {nil, int64(0)}}, float64(1.5)},
+		{[]ResultRow{{nil, int64(0)}, {nil, int64(0)}}, nil},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			m := CreateAggregateFuncAvgMerger(0, 1)
+			ret := test.rows[0]
+			for _, row := range test.rows[1:] {
+				if err := m.MergeTo(row, ret); err != nil {
+					t.Fatalf("MergeTo error: %v", err)
+				}
+			}
+			if err := m.Finish(ret); err != nil {
+				t.Fatalf("Finish error: %v", err)
+			}
+			if !reflect.DeepEqual(ret.GetValue(0), test.expect) {
+				t.Errorf("avg not equal, expect: %v, actual: %v", test.expect, ret.GetValue(0))
+			}
+		})
+	}
+}
+
+func TestMergeDecimalAggregateResult(t *testing.T) {
+	// SELECT SUM(price), AVG(price) is rewritten to SELECT SUM(price), SUM(price), COUNT(price)
+	sumMerger, _ := CreateAggregateFunctionMerger("sum", 0)
+	p := &SelectPlan{
+		originColumnCount: 2,
+		columnCount:       3,
+		aggregateFuncs:    map[int]AggregateFuncMerger{0: sumMerger, 1: CreateAggregateFuncAvgMerger(1, 2)},
+		offset:            -1,
+		count:             -1,
+	}
+	fields := []*mysql.Field{{Type: mysql.TypeNewDecimal}, {Type: mysql.TypeNewDecimal}, {Type: mysql.TypeLonglong}}
+	newResult := func(columns ...string) *mysql.Result {
+		var row mysql.RowData
+		for _, c := range columns {
+			row = mysql.AppendLenEncStringBytes(row, []byte(c))
+		}
+		values, err := row.ParseText(fields)
+		if err != nil {
+			t.Fatalf("parse row error: %v", err)
+		}
+		return &mysql.Result{
+			Resultset: &mysql.Resultset{Fields: fields, Values: [][]interface{}{values}, RowDatas: []mysql.RowData{row}},
+		}
+	}
+	rs := []*mysql.Result{newResult("0.10", "0.10", "1"), newResult("0.20", "0.20", "2")}
+
+	ret, err := MergeSelectResult(p, &ast.SelectStmt{}, rs, nil)
+	if err != nil {
+		t.Fatalf("MergeSelectResult error: %v", err)
+	}
+	if len(ret.Values) != 1 || len(ret.Values[0]) != 2 {
+		t.Fatalf("merged values error: %v", ret.Values)
+	}
+	// 0.1 + 0.2 is not exact in float64
+	for i, expect := range []string{"0.30", "0.100000"} {
+		if v, ok := ret.Values[0][i].(mysql.Decimal); !ok || v.String() != expect {
+			t.Errorf("column %d not equal, expect: %s, actual: %v", i, expect, ret.Values[0][i])
+		}
+	}
+}
+
+func TestMergeGroupByResult(t *testing.T) {
+	// SELECT uid, COUNT(id) FROM tbl GROUP BY uid
+	countMerger, _ := CreateAggregateFunctionMerger("count", 1)
+	p := &SelectPlan{
+		groupByColumn:     []int{0},
+		originColumnCount: 2,
+		columnCount:       2,
+		aggregateFuncs:    map[int]AggregateFuncMerger{1: countMerger},
+		offset:            -1,
+		count:             -1,
+	}
+
+	tests := []struct {
+		desc   bool
+		expect [][]interface{}
+	}{
+		{false, [][]interface{}{{int64(1), int64(3)}, {int64(2), int64(1)}, {int64(3), int64(7)}}},
+		{true, [][]interface{}{{int64(3), int64(7)}, {int64(2), int64(1)}, {int64(1), int64(3)}}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("desc %v", test.desc), func(t *testing.T) {
+			stmt := &ast.SelectStmt{
+				GroupBy: &ast.GroupByClause{Items: []*ast.ByItem{{Desc: test.desc}}},
+			}
+			newResult := func(values ...[]interface{}) *mysql.Result {
+				return &mysql.Result{
+					Resultset: &mysql.Resultset{
+						Fields: []*mysql.Field{{Name: []byte("uid")}, {Name: []byte("count(id)")}},
+						Values: values,
+					},
+				}
+			}
+			rs := []*mysql.Result{
+				newResult([]interface{}{int64(3), int64(4)}, []interface{}{int64(1), int64(2)}),
+				newResult([]interface{}{int64(2), int64(1)}, []interface{}{int64(1), int64(1)}, []interface{}{int64(3), int64(3)}),
+			}
+
+			ret, err := MergeSelectResult(p, stmt, rs, nil)
+			if err != nil {
+				t.Fatalf("MergeSelectResult error: %v", err)
+			}
+			if !reflect.DeepEqual(ret.Values, test.expect) {
+				t.Errorf("group by result not equal, expect: %v, actual: %v", test.expect, ret.Values)
+			}
+		})
+	}
+}
+
+func TestMergeHavingResult(t *testing.T) {
+	// SELECT name, COUNT(id) FROM tbl GROUP BY name HAVING COUNT(id) > 3 AND MAX(id) < 10 OR name = 'B'
+	// is rewritten to SELECT name, COUNT(id), MAX(id) FROM tbl GROUP BY name
+	stmt, err := parser.ParseSQL("select name, count(id) from tbl group by name having count(id) > 3 and max(id) < 10 or name = 'B'")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	having := stmt.(*ast.SelectStmt).Having.Expr
+	collector := &havingRefCollector{}
+	having.Accept(collector)
+	if len(collector.refs) != 3 {
+		t.Fatalf("refs of having not equal, expect 3, actual: %d", len(collector.refs))
+	}
+	filter, err := newHavingFilter(having, map[ast.ExprNode]int{collector.refs[0]: 1, collector.refs[1]: 2, collector.refs[2]: 0})
+	if err != nil {
+		t.Fatalf("newHavingFilter error: %v", err)
+	}
+
+	countMerger, _ := CreateAggregateFunctionMerger("count", 1)
+	maxMerger, _ := CreateAggregateFunctionMerger("max", 2)
+	p := &SelectPlan{
+		groupByColumn:     []int{0},
+		originColumnCount: 2,
+		columnCount:       3,
+		aggregateFuncs:    map[int]AggregateFuncMerger{1: countMerger, 2: maxMerger},
+		having:            filter,
+		offset:            -1,
+		count:             -1,
+	}
+	fields := []*mysql.Field{
+		{Name: []byte("name"), Type: mysql.TypeVarString, Charset: uint16(mysql.CollationIds["utf8mb4_general_ci"])},
+		{Name: []byte("count(id)"), Type: mysql.TypeLonglong},
+		{Name: []byte("max(id)"), Type: mysql.TypeLonglong},
+	}
+	newResult := func(values ...[]interface{}) *mysql.Result {
+		return &mysql.Result{Resultset: &mysql.Resultset{Fields: fields, Values: values}}
+	}
+	rs := []*mysql.Result{
+		newResult([]interface{}{"a", int64(2), int64(5)}, []interface{}{"b", int64(1), int64(20)}),
+		newResult([]interface{}{"A ", int64(3), int64(9)}, []interface{}{"c", int64(4), int64(11)}),
+	}
+
+	ret, err := MergeSelectResult(p, stmt.(*ast.SelectStmt), rs, nil)
+	if err != nil {
+		t.Fatalf("MergeSelectResult error: %v", err)
+	}
+	expect := [][]interface{}{{"a", int64(5)}, {"b", int64(1)}}
+	if !reflect.DeepEqual(ret.Values, expect) {
+		t.Errorf("having result not equal, expect: %v, actual: %v", expect, ret.Values)
+	}
+
+	for _, sql := range []string{
+		"select name from tbl group by name having name like 'a%'",
+		"select name from tbl group by name having count(id) > (select 1)",
+	} {
+		stmt, err := parser.ParseSQL(sql)
+		if err != nil {
+			t.Fatalf("parse error: %v", err)
+		}
+		having := stmt.(*ast.SelectStmt).Having.Expr
+		collector := &havingRefCollector{}
+		having.Accept(collector)
+		columns := make(map[ast.ExprNode]int)
+		for i, ref := range collector.refs {
+			columns[ref] = i
+		}
+		if _, err := newHavingFilter(having, columns); err == nil {
+			t.Errorf("unsupported having is not checked: %s", sql)
+		}
+	}
+}
+
+func TestRemoveDistinctRowInResult(t *testing.T) {
+	tests := []struct {
+		count  int64
+		values [][]interface{}
+		expect [][]interface{}
+	}{
+		{
+			count:  -1,
+			values: [][]interface{}{{int64(1), "a"}, {int64(2), nil}, {int64(1), "a"}, {int64(2), "NULL"}, {int64(2), nil}},
+			expect: [][]interface{}{{int64(1), "a"}, {int64(2), nil}, {int64(2), "NULL"}},
+		},
+		{
+			count:  2,
+			values: [][]interface{}{{int64(1), "a"}, {int64(1), "a"}, {int64(3), "c"}, {int64(4), "d"}, {int64(3), "c"}},
+			expect: [][]interface{}{{int64(1), "a"}, {int64(3), "c"}},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			p := &SelectPlan{
+				distinct:          true,
+				originColumnCount: 2,
+				columnCount:       2,
+				offset:            0,
+				count:             test.count,
+			}
+			ret := &mysql.Result{
+				Resultset: &mysql.Resultset{
+					Fields: []*mysql.Field{{Name: []byte("id")}, {Name: []byte("name")}},
+					Values: test.values,
+				},
+			}
+			if err := removeDistinctRowInResult(p, ret, nil); err != nil {
+				t.Fatalf("removeDistinctRowInResult error: %v", err)
+			}
+			if !reflect.DeepEqual(ret.Values, test.expect) {
+				t.Errorf("distinct rows not equal, expect: %v, actual: %v", test.expect, ret.Values)
+			}
+		})
+	}
+}
+
+func TestRemoveDistinctRowInResultMemoryLimit(t *testing.T) {
+	p := &SelectPlan{distinct: true, originColumnCount: 1, columnCount: 1, offset: -1, count: -1}
+	newResult := func() *mysql.Result {
+		return &mysql.Result{
+			Resultset: &mysql.Resultset{
+				Fields: []*mysql.Field{{Name: []byte("id")}},
+				Values: [][]interface{}{{int64(1)}, {int64(2)}, {int64(1)}, {int64(3)}},
+			},
+		}
+	}
+
+	// 3 distinct rows
+	tracker := util.NewMemoryTracker(3 * distinctRowSetEntrySize)
+	if err := removeDistinctRowInResult(p, newResult(), tracker); err != nil {
+		t.Fatalf("removeDistinctRowInResult error: %v", err)
+	}
+	if tracker.Used() != 0 {
+		t.Errorf("memory of distinct row set should be released, used: %d", tracker.Used())
+	}
+
+	tracker = util.NewMemoryTracker(2 * distinctRowSetEntrySize)
+	err := removeDistinctRowInResult(p, newResult(), tracker)
+	if e, ok := err.(*mysql.SQLError); !ok || e.SQLCode() != mysql.ErrOutofMemory {
+		t.Errorf("removeDistinctRowInResult should exceed memory limit, err: %v", err)
+	}
+}
+
+func TestMergeResultWithCollation(t *testing.T) {
+	fields := []*mysql.Field{
+		{Name: []byte("name"), Type: mysql.TypeVarString, Charset: uint16(mysql.CollationIds["utf8mb4_general_ci"])},
+		{Name: []byte("count(id)"), Type: mysql.TypeLonglong},
+	}
+	newResult := func(values ...[]interface{}) *mysql.Result {
+		return &mysql.Result{Resultset: &mysql.Resultset{Fields: fields, Values: values}}
+	}
+
+	// SELECT name, COUNT(id) FROM tbl GROUP BY name
+	countMerger, _ := CreateAggregateFunctionMerger("count", 1)
+	p := &SelectPlan{
+		groupByColumn:     []int{0},
+		originColumnCount: 2,
+		columnCount:       2,
+		aggregateFuncs:    map[int]AggregateFuncMerger{1: countMerger},
+		offset:            -1,
+		count:             -1,
+	}
+	stmt := &ast.SelectStmt{GroupBy: &ast.GroupByClause{Items: []*ast.ByItem{{}}}}
+	rs := []*mysql.Result{
+		newResult([]interface{}{"B", int64(1)}, []interface{}{"a", int64(2)}),
+		newResult([]interface{}{"A ", int64(3)}),
+	}
+	ret, err := MergeSelectResult(p, stmt, rs, nil)
+	if err != nil {
+		t.Fatalf("MergeSelectResult error: %v", err)
+	}
+	expect := [][]interface{}{{"a", int64(5)}, {"B", int64(1)}}
+	if !reflect.DeepEqual(ret.Values, expect) {
+		t.Errorf("group by result not equal, expect: %v, actual: %v", expect, ret.Values)
+	}
+
+	// SELECT DISTINCT name FROM tbl
+	p = &SelectPlan{distinct: true, originColumnCount: 1, columnCount: 1, offset: -1, count: -1}
+	ret = newResult([]interface{}{[]byte("abc")}, []interface{}{[]byte("ABC ")}, []interface{}{[]byte("Äbc")}, []interface{}{nil})
+	ret.Fields = fields[:1]
+	if err := removeDistinctRowInResult(p, ret, nil); err != nil {
+		t.Fatalf("removeDistinctRowInResult error: %v", err)
+	}
+	expect = [][]interface{}{{[]byte("abc")}, {nil}}
+	if !reflect.DeepEqual(ret.Values, expect) {
+		t.Errorf("distinct rows not equal, expect: %v, actual: %v", expect, ret.Values)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/XiaoMi/Gaea/parser"
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/format"
+	"strconv"
 	"strings"
 
 	"github.com/XiaoMi/Gaea/mysql"
@@ -34,7 +35,9 @@ var _ Plan = &SelectPlan{}
 var _ Plan = &DeletePlan{}
 var _ Plan = &UpdatePlan{}
 var _ Plan = &InsertPlan{}
-var _ Plan = &SelectLastInsertIDPlan{}
+var _ Plan = &SessionFunctionPlan{}
+var _ Plan = &FoundRowsPlan{}
+var _ Plan = &DualWritePlan{}
 
 // Plan is a interface for select/insert etc.
 type Plan interface {
@@ -57,6 +60,12 @@ type Executor interface {
 	SetLastInsertID(uint64)
 
 	GetLastInsertID() uint64
+
+	// 会话状态, 用于返回ROW_COUNT(), FOUND_ROWS(), CONNECTION_ID(), DATABASE()
+	GetRowCount() int64
+	GetFoundRows() uint64
+	GetConnectionID() uint32
+	GetDatabase() string
 }
 
 // Checker 用于检查SelectStmt是不是分表的Visitor, 以及是否包含DB信息
@@ -170,14 +179,18 @@ type TableAliasStmtInfo struct {
 
 // BuildPlan build plan for ast
 func BuildPlan(stmt ast.StmtNode, phyDBs map[string]string, db, sql string, router *router.Router, seq *sequence.SequenceManager) (Plan, error) {
-	if IsSelectLastInsertIDStmt(stmt) {
-		return CreateSelectLastInsertIDPlan(), nil
+	if IsSessionFunctionStmt(stmt) {
+		return CreateSessionFunctionPlan(stmt), nil
 	}
 
 	if estmt, ok := stmt.(*ast.ExplainStmt); ok {
 		return buildExplainPlan(estmt, phyDBs, db, sql, router, seq)
 	}
 
+	if IsInformationSchemaStmt(stmt) {
+		return buildInformationSchemaPlan(stmt, phyDBs, sql, router)
+	}
+
 	checker := NewChecker(db, router)
 	stmt.Accept(checker)
 
@@ -185,15 +198,33 @@ func BuildPlan(stmt ast.StmtNode, phyDBs map[string]string, db, sql string, rout
 		return nil, fmt.Errorf("no database selected") // TODO: return standard MySQL error
 	}
 
+	var p Plan
+	var err error
 	if checker.IsShard() {
-		return buildShardPlan(stmt, db, sql, router, seq)
+		if c := checkDualWrite(stmt, db, router); c != nil {
+			p, err = buildDualWritePlan(stmt, c, phyDBs, db, sql, router, seq)
+		} else {
+			p, err = buildShardPlan(stmt, phyDBs, db, sql, router, seq)
+		}
+	} else {
+		p, err = CreateUnshardPlan(stmt, phyDBs, db, checker.GetUnshardTableNames(), router.GetSchema())
+	}
+	if err != nil {
+		return nil, err
 	}
-	return CreateUnshardPlan(stmt, phyDBs, db, checker.GetUnshardTableNames())
+
+	if IsCalcFoundRowsStmt(stmt) {
+		return buildFoundRowsPlan(p, phyDBs, db, sql, router, seq)
+	}
+	return p, nil
 }
 
-func buildShardPlan(stmt ast.StmtNode, db string, sql string, router *router.Router, seq *sequence.SequenceManager) (Plan, error) {
+func buildShardPlan(stmt ast.StmtNode, phyDBs map[string]string, db string, sql string, router *router.Router, seq *sequence.SequenceManager) (Plan, error) {
 	switch s := stmt.(type) {
 	case *ast.SelectStmt:
+		if hasInlineSubquery(s, db, router) {
+			return buildSubqueryPlan(s, phyDBs, db, sql, router, seq)
+		}
 		plan := NewSelectPlan(db, sql, router)
 		if err := HandleSelectStmt(plan, s); err != nil {
 			return nil, err
@@ -218,6 +249,8 @@ func buildShardPlan(stmt ast.StmtNode, db string, sql string, router *router.Rou
 			return nil, err
 		}
 		return plan, nil
+	case *ast.CreateTableStmt, *ast.AlterTableStmt, *ast.DropTableStmt:
+		return buildDDLPlan(s, db, sql, router)
 	default:
 		return nil, fmt.Errorf("stmt type does not support shard now")
 	}
@@ -305,6 +338,8 @@ func (s *StmtInfo) checkStmtRouteResult(rule router.Rule) error {
 	} else {
 		table = rule.GetTable()
 	}
+	// 同一绑定表组中的表使用组内第一个表作为路由表, 因此可以JOIN
+	table = s.router.GetBindingTable(db, table)
 
 	if s.result.db == "" && s.result.table == "" {
 		s.result.db = db
@@ -375,6 +410,179 @@ func postHandleGlobalTableRouteResultInModify(p *StmtInfo) error {
 	return nil
 }
 
+// 分表的UPDATE, DELETE同时有ORDER BY和LIMIT时只能路由到一个分表.
+// 路由到多个分表时每个分表各自按ORDER BY修改前LIMIT行, 修改的行和总行数都与在单表中执行不同, 因此返回错误;
+// 只有LIMIT时按原语句在各分表执行, 只含全局表时各分片的数据相同, 不做检查.
+func checkModifyOrderByLimit(p *StmtInfo, stmtType string, order *ast.OrderByClause, limit *ast.Limit) error {
+	if order == nil || limit == nil || len(p.tableRules) == 0 {
+		return nil
+	}
+	if len(p.result.indexes) > 1 {
+		return fmt.Errorf("%s with ORDER BY and LIMIT must be routed to one shard, add sharding key condition to WHERE, route result: %v", stmtType, p.result.indexes)
+	}
+	return nil
+}
+
+// IsCacheablePlan check if the plan can be reused by executions of the same sql in all sessions.
+// Only read plans are cached, write plans may contain values generated in building like global sequences.
+func IsCacheablePlan(p Plan) bool {
+	switch pp := p.(type) {
+	case *SelectPlan:
+		return true
+	case *UnshardPlan:
+		_, ok := pp.stmt.(*ast.SelectStmt)
+		return ok
+	default:
+		return false
+	}
+}
+
+// IsBroadcastWrite check if the plan is INSERT, UPDATE or DELETE only containing broadcast tables,
+// it's executed in all slices and should be committed within one transaction.
+func IsBroadcastWrite(p Plan) bool {
+	var s *StmtInfo
+	switch pp := p.(type) {
+	case *InsertPlan:
+		s = pp.StmtInfo
+	case *UpdatePlan:
+		s = pp.StmtInfo
+	case *DeletePlan:
+		s = pp.StmtInfo
+	default:
+		return false
+	}
+
+	if len(s.tableRules) != 0 {
+		return false
+	}
+	for _, r := range s.globalTableRules {
+		if r.IsBroadcastRule() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFullScatter check if the plan is SELECT, UPDATE or DELETE of sharding tables routed to all sub tables,
+// because there is no condition of sharding column. It returns false if full_scan hint is specified explicitly.
+func IsFullScatter(p Plan) bool {
+	var s *StmtInfo
+	switch pp := p.(type) {
+	case *SelectPlan:
+		s = pp.StmtInfo
+	case *UpdatePlan:
+		s = pp.StmtInfo
+	case *DeletePlan:
+		s = pp.StmtInfo
+	case *ShardKeyRelocatePlan:
+		return IsFullScatter(pp.deletePlan)
+	default:
+		return false
+	}
+
+	if len(s.tableRules) == 0 {
+		return false
+	}
+	rule, ok := s.router.GetShardRule(s.result.db, s.result.table)
+	if !ok {
+		return false
+	}
+	all := rule.GetSubTableIndexes()
+	if len(all) <= 1 || len(s.result.indexes) != len(all) {
+		return false
+	}
+	hint, _ := parser.ExtractRouteHint(s.sql)
+	return hint == nil || hint.Type != parser.RouteHintFullScan
+}
+
+// GetPipelineSQLs return sqls executed in shards of INSERT, UPDATE or DELETE plan, they can be pipelined with sqls
+// of other statements since results of shards are merged regardless of order. ok is false for other plans.
+func GetPipelineSQLs(p Plan) (sqls map[string]map[string][]string, ok bool) {
+	switch pp := p.(type) {
+	case *InsertPlan:
+		sqls = pp.sqls
+	case *UpdatePlan:
+		sqls = pp.sqls
+	case *DeletePlan:
+		sqls = pp.sqls
+	default:
+		return nil, false
+	}
+	return sqls, len(sqls) != 0
+}
+
+// MergePipelineResults merge results of sqls returned by GetPipelineSQLs as ExecuteIn of the plan does
+func MergePipelineResults(p Plan, rs []*mysql.Result) (*mysql.Result, error) {
+	r, err := MergeExecResult(rs)
+	if err != nil {
+		return nil, err
+	}
+	// 由全局序列号生成的值不是自增列生成的, 后端不会返回insert id
+	if ip, ok := p.(*InsertPlan); ok && ip.lastInsertID != 0 {
+		r.InsertID = ip.lastInsertID
+	}
+	return r, nil
+}
+
+// IsAutoIncrementSpecified check if values of auto increment column are specified in INSERT of the plan,
+// insert id returned by backend doesn't change session last insert id in this case
+func IsAutoIncrementSpecified(p Plan) bool {
+	ip, ok := p.(*InsertPlan)
+	return ok && ip.autoIncrementSpecified
+}
+
+// postHandleRouteHint 处理语句开头注释中的路由hint, 覆盖根据分片规则计算出的路由
+// 用于DBA手动指定临时查询的路由
+func postHandleRouteHint(p *StmtInfo) error {
+	hint, err := parser.ExtractRouteHint(p.sql)
+	if err != nil {
+		return err
+	}
+	if hint == nil {
+		return nil
+	}
+
+	rule, ok := p.router.GetShardRule(p.result.db, p.result.table)
+	if !ok {
+		return fmt.Errorf("sharding rule of route result not found, result: %v", p.result)
+	}
+
+	switch hint.Type {
+	case parser.RouteHintShardKey:
+		if rule.GetType() == router.GlobalTableRuleType {
+			return fmt.Errorf("shard_key hint does not support global table")
+		}
+		if hint.ShardColumn != rule.GetShardingColumn() {
+			return fmt.Errorf("shard_key hint column %s is not sharding column of table %s", hint.ShardColumn, p.result.table)
+		}
+		var value interface{} = hint.ShardValue
+		if v, err := strconv.ParseInt(hint.ShardValue, 10, 64); err == nil {
+			value = v
+		}
+		idx, err := rule.FindTableIndex(value)
+		if err != nil {
+			return fmt.Errorf("find table index of shard_key hint error: %v", err)
+		}
+		p.result.indexes = []int{idx}
+	case parser.RouteHintRouteTo:
+		var indexes []int
+		for _, idx := range rule.GetSubTableIndexes() {
+			dbName, _ := rule.GetDatabaseNameByTableIndex(idx)
+			sliceName := rule.GetSlice(rule.GetSliceIndexFromTableIndex(idx))
+			if dbName == hint.Target || sliceName == hint.Target {
+				indexes = append(indexes, idx)
+			}
+		}
+		if len(indexes) == 0 {
+			return fmt.Errorf("route_to hint target not found: %s", hint.Target)
+		}
+		p.result.indexes = indexes
+	case parser.RouteHintFullScan:
+		p.result.indexes = rule.GetSubTableIndexes()
+	}
+	return nil
+}
+
 // RecordSubqueryTableAlias 记录表名位置的子查询的别名, 便于后续处理
 // 返回已存在Rule的第一个 (任意一个即可)
 // 限制: 子查询中的表对应的路由规则必须与外层查询相关联, 或者为全局表
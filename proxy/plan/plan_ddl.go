@@ -0,0 +1,183 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// DDLShard is a physical table of the logical table which the DDL is executed in
+type DDLShard struct {
+	Slice string
+	DB    string
+	Table string
+	SQL   string
+}
+
+// String return slice.db.table of the shard
+func (s *DDLShard) String() string {
+	return s.Slice + "." + s.DB + "." + s.Table
+}
+
+// DDLPlan is the plan for CREATE, ALTER and DROP TABLE of sharding table,
+// the DDL is rewritten and executed in each physical table of the logical table.
+type DDLPlan struct {
+	basePlan
+
+	db        string
+	table     string
+	sql       string
+	isAlter   bool
+	alterSpec string // specs of ALTER TABLE, used by online schema change tools
+	shards    []*DDLShard
+}
+
+// GetDB return the logical db of the table
+func (p *DDLPlan) GetDB() string {
+	return p.db
+}
+
+// GetTable return the logical table name
+func (p *DDLPlan) GetTable() string {
+	return p.table
+}
+
+// GetSQL return the origin DDL
+func (p *DDLPlan) GetSQL() string {
+	return p.sql
+}
+
+// IsAlter check if the DDL is ALTER TABLE
+func (p *DDLPlan) IsAlter() bool {
+	return p.isAlter
+}
+
+// GetAlterSpec return specs of ALTER TABLE without table name, such as "ADD COLUMN `c` INT"
+func (p *DDLPlan) GetAlterSpec() string {
+	return p.alterSpec
+}
+
+// GetShards return all physical tables of the DDL
+func (p *DDLPlan) GetShards() []*DDLShard {
+	return p.shards
+}
+
+func buildDDLPlan(stmt ast.StmtNode, db, sql string, r *router.Router) (*DDLPlan, error) {
+	var table *ast.TableName
+	p := &DDLPlan{sql: sql}
+	switch s := stmt.(type) {
+	case *ast.CreateTableStmt:
+		if s.ReferTable != nil || s.Select != nil {
+			return nil, fmt.Errorf("CREATE TABLE LIKE or SELECT is not supported in sharding table")
+		}
+		table = s.Table
+	case *ast.AlterTableStmt:
+		var specs []string
+		for _, spec := range s.Specs {
+			if spec.Tp == ast.AlterTableRenameTable {
+				return nil, fmt.Errorf("rename sharding table is not supported")
+			}
+			sb := &strings.Builder{}
+			if err := spec.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb)); err != nil {
+				return nil, fmt.Errorf("restore alter table spec error: %v", err)
+			}
+			specs = append(specs, sb.String())
+		}
+		table = s.Table
+		p.isAlter = true
+		p.alterSpec = strings.Join(specs, ", ")
+	case *ast.DropTableStmt:
+		if s.IsView {
+			return nil, fmt.Errorf("drop view is not supported in sharding table")
+		}
+		if len(s.Tables) != 1 {
+			return nil, fmt.Errorf("drop multiple tables with sharding table is not supported")
+		}
+		table = s.Tables[0]
+	default:
+		return nil, fmt.Errorf("ddl type does not support shard now: %T", stmt)
+	}
+
+	p.db = table.Schema.O
+	if p.db == "" {
+		p.db = db
+	}
+	p.table = table.Name.L
+	rule, ok := r.GetShardRule(p.db, p.table)
+	if !ok {
+		return nil, fmt.Errorf("cannot find shard rule, db: %s, table: %s", p.db, p.table)
+	}
+
+	// rewrite the table name for each sub table, and restore it after all
+	origin := *table
+	defer func() {
+		*table = origin
+	}()
+	for _, index := range rule.GetSubTableIndexes() {
+		dbName, err := rule.GetDatabaseNameByTableIndex(index)
+		if err != nil {
+			return nil, fmt.Errorf("get database name of sub table %d error: %v", index, err)
+		}
+		tableName := origin.Name.O
+		if rule.GetType() != router.GlobalTableRuleType && !router.IsMycatShardingRule(rule.GetType()) {
+			tableName = fmt.Sprintf("%s_%04d", origin.Name.O, index)
+		} else if origin.Schema.O != "" {
+			table.Schema = model.NewCIStr(dbName)
+		}
+		table.Name = model.NewCIStr(tableName)
+
+		sb := &strings.Builder{}
+		if err := stmt.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb)); err != nil {
+			return nil, fmt.Errorf("restore ddl error: %v", err)
+		}
+		p.shards = append(p.shards, &DDLShard{
+			Slice: rule.GetSlice(rule.GetSliceIndexFromTableIndex(index)),
+			DB:    dbName,
+			Table: tableName,
+			SQL:   sb.String(),
+		})
+	}
+	return p, nil
+}
+
+// ExecuteIn implement Plan, the DDL is executed in all shards directly
+func (p *DDLPlan) ExecuteIn(reqCtx *util.RequestContext, se Executor) (*mysql.Result, error) {
+	var failed []string
+	for _, shard := range p.shards {
+		if err := p.ExecuteShard(reqCtx, se, shard); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", shard, err))
+		}
+	}
+	if len(failed) != 0 {
+		return nil, fmt.Errorf("execute ddl failed in %d of %d shards: %s", len(failed), len(p.shards), strings.Join(failed, "; "))
+	}
+	return &mysql.Result{}, nil
+}
+
+// ExecuteShard execute the DDL in a shard
+func (p *DDLPlan) ExecuteShard(reqCtx *util.RequestContext, se Executor, shard *DDLShard) error {
+	sqls := map[string]map[string][]string{shard.Slice: {shard.DB: {shard.SQL}}}
+	_, err := se.ExecuteSQLs(reqCtx, sqls)
+	return err
+}
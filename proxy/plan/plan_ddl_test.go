@@ -0,0 +1,116 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+func TestDDLPlan(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		db        string
+		sql       string
+		shards    []DDLShard
+		alterSpec string
+		hasErr    bool
+	}{
+		{
+			db:  "db_mycat",
+			sql: "alter table tbl_mycat add column c int",
+			shards: []DDLShard{
+				{"slice-0", "db_mycat_0", "tbl_mycat", "ALTER TABLE `tbl_mycat` ADD COLUMN `c` INT"},
+				{"slice-0", "db_mycat_1", "tbl_mycat", "ALTER TABLE `tbl_mycat` ADD COLUMN `c` INT"},
+				{"slice-1", "db_mycat_2", "tbl_mycat", "ALTER TABLE `tbl_mycat` ADD COLUMN `c` INT"},
+				{"slice-1", "db_mycat_3", "tbl_mycat", "ALTER TABLE `tbl_mycat` ADD COLUMN `c` INT"},
+			},
+			alterSpec: "ADD COLUMN `c` INT",
+		},
+		{
+			db:  "db_mycat",
+			sql: "create table db_mycat.tbl_mycat (id int)",
+			shards: []DDLShard{
+				{"slice-0", "db_mycat_0", "tbl_mycat", "CREATE TABLE `db_mycat_0`.`tbl_mycat` (`id` INT)"},
+				{"slice-0", "db_mycat_1", "tbl_mycat", "CREATE TABLE `db_mycat_1`.`tbl_mycat` (`id` INT)"},
+				{"slice-1", "db_mycat_2", "tbl_mycat", "CREATE TABLE `db_mycat_2`.`tbl_mycat` (`id` INT)"},
+				{"slice-1", "db_mycat_3", "tbl_mycat", "CREATE TABLE `db_mycat_3`.`tbl_mycat` (`id` INT)"},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "drop table if exists tbl_ks",
+			shards: []DDLShard{
+				{"slice-0", "db_ks", "tbl_ks_0000", "DROP TABLE IF EXISTS `tbl_ks_0000`"},
+				{"slice-0", "db_ks", "tbl_ks_0001", "DROP TABLE IF EXISTS `tbl_ks_0001`"},
+				{"slice-1", "db_ks", "tbl_ks_0002", "DROP TABLE IF EXISTS `tbl_ks_0002`"},
+				{"slice-1", "db_ks", "tbl_ks_0003", "DROP TABLE IF EXISTS `tbl_ks_0003`"},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "alter table tbl_mycat rename to tbl_mycat_new",
+			hasErr: true, // rename is not supported
+		},
+		{
+			db:     "db_mycat",
+			sql:    "create table tbl_mycat like tbl_mycat_unknown",
+			hasErr: true,
+		},
+		{
+			db:     "db_mycat",
+			sql:    "drop table tbl_mycat, tbl_mycat_unknown",
+			hasErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, test.db, test.sql, ns.rt, ns.seqs)
+			if test.hasErr {
+				if err == nil {
+					t.Errorf("expect error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			dp, ok := p.(*DDLPlan)
+			if !ok {
+				t.Fatalf("not a DDLPlan: %T", p)
+			}
+			var shards []DDLShard
+			for _, shard := range dp.GetShards() {
+				shards = append(shards, *shard)
+			}
+			if !reflect.DeepEqual(test.shards, shards) {
+				t.Errorf("shards not equal, expect: %v, actual: %v", test.shards, shards)
+			}
+			if dp.GetAlterSpec() != test.alterSpec {
+				t.Errorf("alter spec not equal, expect: %s, actual: %s", test.alterSpec, dp.GetAlterSpec())
+			}
+		})
+	}
+}
@@ -233,6 +233,29 @@ func TestMycatShardDeleteWithLimit(t *testing.T) {
 				},
 			},
 		},
+		{
+			db:  "db_mycat",
+			sql: "delete from tbl_mycat where id = 1 order by id limit 10",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"DELETE FROM `tbl_mycat` WHERE `id`=1 ORDER BY `id` LIMIT 10"},
+				},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "delete from tbl_mycat where id in (1, 2) order by id limit 10",
+			hasErr: true, // ORDER BY和LIMIT路由到多个分片
+		},
+		{
+			db:  "db_mycat",
+			sql: "/*+ route_to(db_mycat_2) */ delete from tbl_mycat order by id limit 10",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_mycat_2": {"DELETE FROM `tbl_mycat` ORDER BY `id` LIMIT 10"},
+				},
+			},
+		},
 		{
 			db:     "db_mycat",
 			sql:    "delete from tbl_mycat limit 0, 10",
@@ -248,3 +271,25 @@ func TestMycatShardDeleteWithLimit(t *testing.T) {
 		t.Run(test.sql, getTestFunc(ns, test))
 	}
 }
+
+func TestMycatShardDeleteWithRouteHint(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_mycat",
+			sql: "/*+ route_to(db_mycat_3) */ delete from tbl_mycat where k = 1",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_mycat_3": {"DELETE FROM `tbl_mycat` WHERE `k`=1"},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// reasons of dual write mismatch
+const (
+	DualWriteMismatchPlan         = "plan"          // statement can not be routed in sharding layout
+	DualWriteMismatchError        = "error"         // write of sharding layout failed
+	DualWriteMismatchAffectedRows = "affected_rows" // affected rows of the two writes differ
+)
+
+// DualWriteRecorder is implemented by executors which count mismatches of dual writes
+type DualWriteRecorder interface {
+	RecordDualWriteMismatch(db, table, reason string, err error)
+}
+
+// DualWritePlan is the plan for INSERT, UPDATE and DELETE of dual write tables.
+// The statement is executed in the unsharded table of default slice first and its result is returned,
+// then it's executed in sub tables of the sharding layout, failure of the sharding write is only counted as mismatch.
+type DualWritePlan struct {
+	basePlan
+
+	db        string
+	table     string // first dual write table in the statement, used to record mismatches
+	sql       string
+	primary   *UnshardPlan
+	secondary Plan
+	buildErr  error // error of building secondary plan
+}
+
+// dualWriteChecker find dual write tables in statement
+type dualWriteChecker struct {
+	db     string
+	router *router.Router
+
+	tableNames []*ast.TableName
+	dualDB     string
+	dualTable  string
+	otherTable string // sharding table which is not dual written
+}
+
+// Enter for node visit
+func (c *dualWriteChecker) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	tn, ok := n.(*ast.TableName)
+	if !ok {
+		return n, false
+	}
+	c.tableNames = append(c.tableNames, tn)
+	db := tn.Schema.L
+	if db == "" {
+		db = c.db
+	}
+	rule, ok := c.router.GetShardRule(db, tn.Name.L)
+	if !ok || rule.GetType() == router.GlobalTableRuleType {
+		return n, false
+	}
+	if !rule.IsDualWrite() {
+		c.otherTable = tn.Name.L
+	} else if c.dualTable == "" {
+		c.dualDB, c.dualTable = db, tn.Name.L
+	}
+	return n, false
+}
+
+// Leave for node visit
+func (c *dualWriteChecker) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// checkDualWrite return checker containing dual write tables of the statement, or nil if there is none
+func checkDualWrite(stmt ast.StmtNode, db string, r *router.Router) *dualWriteChecker {
+	if !r.HasDualWriteTable() {
+		return nil
+	}
+	c := &dualWriteChecker{db: db, router: r}
+	stmt.Accept(c)
+	if c.dualTable == "" {
+		return nil
+	}
+	return c
+}
+
+// buildDualWritePlan route reads of dual write tables to default slice and create DualWritePlan for writes
+func buildDualWritePlan(stmt ast.StmtNode, c *dualWriteChecker, phyDBs map[string]string, db, sql string, r *router.Router, seq *sequence.SequenceManager) (Plan, error) {
+	if c.otherTable != "" {
+		return nil, fmt.Errorf("dual write table %s can not be used with sharding table %s in one statement", c.dualTable, c.otherTable)
+	}
+
+	switch stmt.(type) {
+	case *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+	case *ast.CreateTableStmt, *ast.AlterTableStmt, *ast.DropTableStmt:
+		return nil, fmt.Errorf("ddl of dual write table %s is not supported, execute it in default slice and sub tables separately", c.dualTable)
+	default:
+		return CreateUnshardPlan(stmt, phyDBs, db, c.tableNames, r.GetSchema())
+	}
+
+	// table names are rewritten to physical db names when creating unshard plan, restore them for the sharding plan
+	schemas := make([]model.CIStr, 0, len(c.tableNames))
+	for _, tn := range c.tableNames {
+		schemas = append(schemas, tn.Schema)
+	}
+	primary, err := CreateUnshardPlan(stmt, phyDBs, db, c.tableNames, r.GetSchema())
+	if err != nil {
+		return nil, err
+	}
+	for i, tn := range c.tableNames {
+		tn.Schema = schemas[i]
+	}
+
+	p := &DualWritePlan{
+		db:      c.dualDB,
+		table:   c.dualTable,
+		sql:     sql,
+		primary: primary,
+	}
+	p.secondary, p.buildErr = buildShardPlan(stmt, phyDBs, db, sql, r, seq)
+	return p, nil
+}
+
+// ExecuteIn implement Plan
+func (p *DualWritePlan) ExecuteIn(reqCtx *util.RequestContext, se Executor) (*mysql.Result, error) {
+	r, err := p.primary.ExecuteIn(reqCtx, se)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.buildErr != nil {
+		p.recordMismatch(se, DualWriteMismatchPlan, p.buildErr)
+		return r, nil
+	}
+
+	// last insert id of client is generated by the unsharded table
+	lastInsertID := se.GetLastInsertID()
+	sr, err := p.secondary.ExecuteIn(reqCtx, se)
+	se.SetLastInsertID(lastInsertID)
+	if err != nil {
+		p.recordMismatch(se, DualWriteMismatchError, err)
+	} else if sr.AffectedRows != r.AffectedRows {
+		p.recordMismatch(se, DualWriteMismatchAffectedRows,
+			fmt.Errorf("affected rows of default slice: %d, sub tables: %d", r.AffectedRows, sr.AffectedRows))
+	}
+	return r, nil
+}
+
+func (p *DualWritePlan) recordMismatch(se Executor, reason string, err error) {
+	if recorder, ok := se.(DualWriteRecorder); ok {
+		recorder.RecordDualWriteMismatch(p.db, p.table, reason, fmt.Errorf("%v, sql: %s", err, p.sql))
+	}
+}
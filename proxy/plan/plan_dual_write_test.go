@@ -0,0 +1,168 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+func prepareDualWritePlanInfo(t *testing.T) *PlanInfo {
+	nsStr := `
+{
+    "name": "gaea_namespace_dual_write",
+    "online": true,
+    "allowed_dbs": {"db_ks": true},
+    "default_phy_dbs": {"db_ks": "db_ks_phy"},
+    "slices": [
+        {"name": "slice-0", "user_name": "root", "password": "root", "master": "127.0.0.1:3306", "capacity": 64, "max_capacity": 128, "idle_timeout": 3600},
+        {"name": "slice-1", "user_name": "root", "password": "root", "master": "127.0.0.1:3307", "capacity": 64, "max_capacity": 128, "idle_timeout": 3600}
+    ],
+    "shard_rules": [
+        {"db": "db_ks", "table": "tbl_dw", "type": "hash", "key": "id", "locations": [2, 2], "slices": ["slice-0", "slice-1"], "dual_write": true},
+        {"db": "db_ks", "table": "tbl_ks", "type": "hash", "key": "id", "locations": [2, 2], "slices": ["slice-0", "slice-1"]}
+    ],
+    "users": [
+        {"user_name": "test", "password": "test", "namespace": "gaea_namespace_dual_write", "rw_flag": 2, "rw_split": 1}
+    ],
+    "default_slice": "slice-0"
+}`
+	ns, err := createNamespace(nsStr)
+	if err != nil {
+		t.Fatalf("create namespace error: %v", err)
+	}
+	rt, err := createRouter(ns)
+	if err != nil {
+		t.Fatalf("create router error: %v", err)
+	}
+	seqs, _ := createSequenceManager(ns)
+	return &PlanInfo{phyDBs: ns.DefaultPhyDBS, rt: rt, seqs: seqs}
+}
+
+func buildTestPlan(t *testing.T, info *PlanInfo, sql string) (Plan, error) {
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	return BuildPlan(stmt, info.phyDBs, "db_ks", sql, info.rt, info.seqs)
+}
+
+func TestDualWritePlan(t *testing.T) {
+	info := prepareDualWritePlanInfo(t)
+
+	p, err := buildTestPlan(t, info, "select * from db_ks.tbl_dw where id = 5")
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	up, ok := p.(*UnshardPlan)
+	if !ok {
+		t.Fatalf("read of dual write table should be routed to default slice, got %T", p)
+	}
+	if expect := "SELECT * FROM `db_ks_phy`.`tbl_dw` WHERE `id`=5"; up.GetSQL() != expect {
+		t.Errorf("expect %s, got %s", expect, up.GetSQL())
+	}
+
+	p, err = buildTestPlan(t, info, "insert into db_ks.tbl_dw (id, a) values (5, 'hi')")
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	dp, ok := p.(*DualWritePlan)
+	if !ok {
+		t.Fatalf("write of dual write table should be dual written, got %T", p)
+	}
+	if expect := "INSERT INTO `db_ks_phy`.`tbl_dw` (`id`,`a`) VALUES (5,'hi')"; dp.primary.GetSQL() != expect {
+		t.Errorf("expect %s, got %s", expect, dp.primary.GetSQL())
+	}
+	expectSQLs := map[string]map[string][]string{
+		"slice-0": {"db_ks": {"INSERT INTO `db_ks`.`tbl_dw_0001` (`id`,`a`) VALUES (5,'hi')"}},
+	}
+	if dp.buildErr != nil || !checkSQLs(expectSQLs, dp.secondary.(*InsertPlan).sqls) {
+		t.Errorf("expect %v, got %v %v", expectSQLs, dp.secondary, dp.buildErr)
+	}
+
+	p, err = buildTestPlan(t, info, "insert into tbl_dw (a) values ('hi')")
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	if dp := p.(*DualWritePlan); dp.buildErr == nil {
+		t.Errorf("insert without sharding key can not be routed in sharding layout")
+	}
+
+	for _, sql := range []string{
+		"select * from tbl_dw join tbl_ks on tbl_dw.id = tbl_ks.id",
+		"alter table tbl_dw add column b int",
+	} {
+		if _, err := buildTestPlan(t, info, sql); err == nil {
+			t.Errorf("build plan of %s should fail", sql)
+		}
+	}
+}
+
+type dualWriteExecutor struct {
+	Executor
+	affectedRows map[string]uint64 // key: slice
+	mismatches   []string
+}
+
+func (e *dualWriteExecutor) ExecuteSQL(ctx *util.RequestContext, slice, db, sql string) (*mysql.Result, error) {
+	return &mysql.Result{AffectedRows: e.affectedRows[slice]}, nil
+}
+
+func (e *dualWriteExecutor) ExecuteSQLs(ctx *util.RequestContext, sqls map[string]map[string][]string) ([]*mysql.Result, error) {
+	var rs []*mysql.Result
+	for slice, dbSQLs := range sqls {
+		for _, tableSQLs := range dbSQLs {
+			for range tableSQLs {
+				rs = append(rs, &mysql.Result{AffectedRows: e.affectedRows[slice+"-shard"]})
+			}
+		}
+	}
+	return rs, nil
+}
+
+func (e *dualWriteExecutor) SetLastInsertID(uint64) {}
+
+func (e *dualWriteExecutor) GetLastInsertID() uint64 { return 0 }
+
+func (e *dualWriteExecutor) RecordDualWriteMismatch(db, table, reason string, err error) {
+	e.mismatches = append(e.mismatches, db+"."+table+":"+reason)
+}
+
+func TestDualWritePlanExecute(t *testing.T) {
+	info := prepareDualWritePlanInfo(t)
+	p, err := buildTestPlan(t, info, "delete from tbl_dw where id = 5")
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+
+	se := &dualWriteExecutor{affectedRows: map[string]uint64{backend.DefaultSlice: 1, "slice-0-shard": 1}}
+	r, err := p.ExecuteIn(util.NewRequestContext(), se)
+	if err != nil || r.AffectedRows != 1 || len(se.mismatches) != 0 {
+		t.Errorf("execute dual write error, result: %v, err: %v, mismatches: %v", r, err, se.mismatches)
+	}
+
+	se = &dualWriteExecutor{affectedRows: map[string]uint64{backend.DefaultSlice: 1}}
+	r, err = p.ExecuteIn(util.NewRequestContext(), se)
+	if err != nil || r.AffectedRows != 1 {
+		t.Errorf("result of default slice should be returned, result: %v, err: %v", r, err)
+	}
+	if len(se.mismatches) != 1 || se.mismatches[0] != "db_ks.tbl_dw:"+DualWriteMismatchAffectedRows {
+		t.Errorf("mismatch of affected rows should be recorded, got %v", se.mismatches)
+	}
+}
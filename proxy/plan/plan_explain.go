@@ -16,6 +16,9 @@ package plan
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/mysql"
 	"github.com/XiaoMi/Gaea/proxy/router"
@@ -26,8 +29,9 @@ import (
 
 // constants of ShardType
 const (
-	ShardTypeUnshard = "unshard"
-	ShardTypeShard   = "shard"
+	ShardTypeUnshard   = "unshard"
+	ShardTypeShard     = "shard"
+	ShardTypeDualWrite = "dual_write" // executed in default slice and then in sub tables
 )
 
 // ExplainPlan is the plan for explain statement
@@ -47,37 +51,53 @@ func buildExplainPlan(stmt *ast.ExplainStmt, phyDBs map[string]string, db, sql s
 		return nil, fmt.Errorf("build plan to explain error: %v", err)
 	}
 
-	ep := &ExplainPlan{}
+	shardType, sqls, err := getExplainSQLs(p, phyDBs)
+	if err != nil {
+		return nil, err
+	}
+	return &ExplainPlan{shardType: shardType, sqls: sqls}, nil
+}
 
+// getExplainSQLs return shard type and sqls executed in shards of the plan
+func getExplainSQLs(p Plan, phyDBs map[string]string) (string, map[string]map[string][]string, error) {
 	switch pl := p.(type) {
 	case *SelectPlan:
-		ep.shardType = ShardTypeShard
-		ep.sqls = pl.sqls
-		return ep, nil
+		return ShardTypeShard, pl.sqls, nil
 	case *DeletePlan:
-		ep.shardType = ShardTypeShard
-		ep.sqls = pl.sqls
-		return ep, nil
+		return ShardTypeShard, pl.sqls, nil
 	case *UpdatePlan:
-		ep.shardType = ShardTypeShard
-		ep.sqls = pl.sqls
-		return ep, nil
+		return ShardTypeShard, pl.sqls, nil
 	case *InsertPlan:
-		ep.shardType = ShardTypeShard
-		ep.sqls = pl.sqls
-		return ep, nil
+		return ShardTypeShard, pl.sqls, nil
 	case *UnshardPlan:
-		ep.shardType = ShardTypeUnshard
-		ep.sqls = make(map[string]map[string][]string)
+		sqls := make(map[string]map[string][]string)
 		dbSQLs := make(map[string][]string)
 		if phyDB, ok := phyDBs[pl.db]; ok {
 			pl.db = phyDB
 		}
 		dbSQLs[pl.db] = []string{pl.sql}
-		ep.sqls[backend.DefaultSlice] = dbSQLs
-		return ep, nil
+		sqls[backend.DefaultSlice] = dbSQLs
+		return ShardTypeUnshard, sqls, nil
+	case *DualWritePlan:
+		_, sqls, err := getExplainSQLs(pl.primary, phyDBs)
+		if err != nil || pl.buildErr != nil {
+			return ShardTypeDualWrite, sqls, err
+		}
+		_, shardSQLs, err := getExplainSQLs(pl.secondary, phyDBs)
+		if err != nil {
+			return "", nil, err
+		}
+		for slice, dbSQLs := range shardSQLs {
+			if _, ok := sqls[slice]; !ok {
+				sqls[slice] = make(map[string][]string)
+			}
+			for db, tableSQLs := range dbSQLs {
+				sqls[slice][db] = append(sqls[slice][db], tableSQLs...)
+			}
+		}
+		return ShardTypeDualWrite, sqls, nil
 	default:
-		return nil, fmt.Errorf("unsupport plan to explain, type: %T", p)
+		return "", nil, fmt.Errorf("unsupport plan to explain, type: %T", p)
 	}
 }
 
@@ -111,3 +131,122 @@ func createExplainResult(shardType string, sqls map[string]map[string][]string)
 
 	return ret
 }
+
+// ExplainShardingPlan is the plan for EXPLAIN SHARDING statement of proxy,
+// it shows the shards chosen, sql executed in each shard and the steps to merge results of shards.
+type ExplainShardingPlan struct {
+	basePlan
+
+	shardType string
+	sqls      map[string]map[string][]string
+	merge     string
+}
+
+// BuildExplainShardingPlan build plan of EXPLAIN SHARDING, stmt and sql are the statement to explain
+func BuildExplainShardingPlan(stmt ast.StmtNode, phyDBs map[string]string, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*ExplainShardingPlan, error) {
+	if _, ok := stmt.(*ast.ExplainStmt); ok {
+		return nil, fmt.Errorf("nested explain")
+	}
+
+	p, err := BuildPlan(stmt, phyDBs, db, sql, r, seq)
+	if err != nil {
+		return nil, fmt.Errorf("build plan to explain error: %v", err)
+	}
+
+	shardType, sqls, err := getExplainSQLs(p, phyDBs)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := getMergeSteps(p, sqls)
+	merge := "none"
+	if len(steps) != 0 {
+		merge = strings.Join(steps, ", ")
+	}
+	return &ExplainShardingPlan{shardType: shardType, sqls: sqls, merge: merge}, nil
+}
+
+// getMergeSteps return steps to merge results of shards in proxy
+func getMergeSteps(p Plan, sqls map[string]map[string][]string) []string {
+	shardCount := 0
+	for _, dbSQLs := range sqls {
+		for _, tableSQLs := range dbSQLs {
+			shardCount += len(tableSQLs)
+		}
+	}
+	if shardCount == 0 {
+		return nil
+	}
+
+	sp, ok := p.(*SelectPlan)
+	if !ok {
+		if shardCount > 1 {
+			return []string{"sum affected rows"}
+		}
+		return nil
+	}
+
+	var steps []string
+	if shardCount > 1 {
+		steps = append(steps, fmt.Sprintf("merge %d results", shardCount))
+	}
+	if sp.distinct {
+		steps = append(steps, "distinct")
+	}
+	if sp.HasGroupBy() {
+		steps = append(steps, "group by")
+	}
+	if len(sp.aggregateFuncs) != 0 {
+		steps = append(steps, "aggregate")
+	}
+	if sp.having != nil {
+		steps = append(steps, "having")
+	}
+	if sp.HasOrderBy() {
+		steps = append(steps, "order by")
+	}
+	if sp.HasLimit() {
+		steps = append(steps, fmt.Sprintf("limit %d,%d", sp.offset, sp.count))
+	}
+	if sp.columnCount > sp.originColumnCount {
+		steps = append(steps, "remove extra columns")
+	}
+	return steps
+}
+
+// ExecuteIn implement Plan
+func (p *ExplainShardingPlan) ExecuteIn(*util.RequestContext, Executor) (*mysql.Result, error) {
+	var rows [][]interface{}
+	var names = []string{"type", "slice", "db", "sql", "merge"}
+
+	slices := make([]string, 0, len(p.sqls))
+	for slice := range p.sqls {
+		slices = append(slices, slice)
+	}
+	sort.Strings(slices)
+	for _, slice := range slices {
+		dbs := make([]string, 0, len(p.sqls[slice]))
+		for db := range p.sqls[slice] {
+			dbs = append(dbs, db)
+		}
+		sort.Strings(dbs)
+		for _, db := range dbs {
+			for _, sql := range p.sqls[slice][db] {
+				rows = append(rows, []interface{}{p.shardType, slice, db, sql, p.merge})
+			}
+		}
+	}
+
+	r, err := mysql.BuildResultset(nil, names, rows)
+	if err != nil {
+		return nil, err
+	}
+	// fields are built from the first row, no shard is chosen if all the values are pruned
+	if len(rows) == 0 {
+		for i, name := range names {
+			r.Fields[i] = &mysql.Field{Name: []byte(name), Charset: 33, Type: mysql.TypeVarString}
+			r.FieldNames[name] = i
+		}
+	}
+	return &mysql.Result{Resultset: r}, nil
+}
@@ -14,7 +14,13 @@
 
 package plan
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+)
 
 func TestExplainMycatShardSimpleInsert(t *testing.T) {
 	ns, err := preparePlanInfo()
@@ -104,9 +110,18 @@ func TestExplainMycatShardSimpleInsert(t *testing.T) {
 				},
 			},
 		},
+		{
+			db:  "db_mycat",
+			sql: "explain insert into tbl_mycat (a) values ('hi')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"INSERT INTO `tbl_mycat` (`a`,`id`) VALUES ('hi',1)"}, // sharding column is filled by global sequence
+				},
+			},
+		},
 		{
 			db:     "db_mycat",
-			sql:    "explain insert into tbl_mycat (a) values ('hi')",
+			sql:    "explain insert into tbl_mycat_murmur (a) values ('hi')",
 			hasErr: true, // sharding column not found
 		},
 		{
@@ -220,3 +235,86 @@ func TestExplainUnshardInsertWithDb(t *testing.T) {
 		t.Run(test.sql, getTestFunc(ns, test))
 	}
 }
+
+func TestExplainSharding(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql  string
+		rows [][]interface{}
+	}{
+		{
+			sql: "select * from tbl_mycat where id = 1",
+			rows: [][]interface{}{
+				{"shard", "slice-0", "db_mycat_1", "SELECT * FROM `tbl_mycat` WHERE `id`=1", "none"},
+			},
+		},
+		{
+			sql: "select count(*) from tbl_mycat",
+			rows: [][]interface{}{
+				{"shard", "slice-0", "db_mycat_0", "SELECT COUNT(1) FROM `tbl_mycat`", "merge 4 results, aggregate"},
+				{"shard", "slice-0", "db_mycat_1", "SELECT COUNT(1) FROM `tbl_mycat`", "merge 4 results, aggregate"},
+				{"shard", "slice-1", "db_mycat_2", "SELECT COUNT(1) FROM `tbl_mycat`", "merge 4 results, aggregate"},
+				{"shard", "slice-1", "db_mycat_3", "SELECT COUNT(1) FROM `tbl_mycat`", "merge 4 results, aggregate"},
+			},
+		},
+		{
+			sql: "select count(*) from tbl_mycat having max(id) > 1",
+			rows: [][]interface{}{
+				{"shard", "slice-0", "db_mycat_0", "SELECT COUNT(1),MAX(`id`) FROM `tbl_mycat`", "merge 4 results, aggregate, having, remove extra columns"},
+				{"shard", "slice-0", "db_mycat_1", "SELECT COUNT(1),MAX(`id`) FROM `tbl_mycat`", "merge 4 results, aggregate, having, remove extra columns"},
+				{"shard", "slice-1", "db_mycat_2", "SELECT COUNT(1),MAX(`id`) FROM `tbl_mycat`", "merge 4 results, aggregate, having, remove extra columns"},
+				{"shard", "slice-1", "db_mycat_3", "SELECT COUNT(1),MAX(`id`) FROM `tbl_mycat`", "merge 4 results, aggregate, having, remove extra columns"},
+			},
+		},
+		{
+			sql: "insert into tbl_unshard (id, a) values (0, 'hi')",
+			rows: [][]interface{}{
+				{"unshard", "slice-0", "db_mycat_0", "INSERT INTO `tbl_unshard` (`id`,`a`) VALUES (0,'hi')", "none"},
+			},
+		},
+		{
+			sql: "select id, row_number() over (order by id) from tbl_mycat where id = 1",
+			rows: [][]interface{}{
+				{"shard", "slice-0", "db_mycat_1", "SELECT `id`,ROW_NUMBER() OVER (ORDER BY `id`) FROM `tbl_mycat` WHERE `id`=1", "none"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildExplainShardingPlan(stmt, ns.phyDBs, "db_mycat", test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("build explain sharding plan error: %v", err)
+			}
+			r, err := p.ExecuteIn(nil, nil)
+			if err != nil {
+				t.Fatalf("execute explain sharding plan error: %v", err)
+			}
+			if len(r.Values) != len(test.rows) {
+				t.Fatalf("row count not equal, expect: %v, actual: %v", test.rows, r.Values)
+			}
+			for i, row := range test.rows {
+				for j, v := range row {
+					if fmt.Sprintf("%v", r.Values[i][j]) != v {
+						t.Errorf("not equal, row: %d, column: %d, expect: %v, actual: %v", i, j, v, r.Values[i][j])
+					}
+				}
+			}
+		})
+	}
+	sql := "select id, row_number() over (order by id) from tbl_mycat"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	if _, err := BuildExplainShardingPlan(stmt, ns.phyDBs, "db_mycat", sql, ns.rt, ns.seqs); err == nil || !strings.Contains(err.Error(), "window function ROW_NUMBER()") {
+		t.Errorf("window function routed to multiple shards is not checked, err: %v", err)
+	}
+}
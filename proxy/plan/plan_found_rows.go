@@ -0,0 +1,126 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	parser2 "github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// FoundRowsPlan is the plan for SELECT SQL_CALC_FOUND_ROWS.
+// SQL_CALC_FOUND_ROWS is not sent to backend, because FOUND_ROWS() is answered by proxy and each slice
+// only knows its own rows, the total rows without LIMIT are counted by a separate COUNT query.
+type FoundRowsPlan struct {
+	basePlan
+
+	plan      Plan
+	countPlan Plan
+}
+
+// IsCalcFoundRowsStmt check if the statement is SELECT SQL_CALC_FOUND_ROWS
+func IsCalcFoundRowsStmt(stmt ast.StmtNode) bool {
+	s, ok := stmt.(*ast.SelectStmt)
+	return ok && s.SelectStmtOpts != nil && s.SelectStmtOpts.CalcFoundRows
+}
+
+// buildFoundRowsPlan build plan of the select and the COUNT query.
+// For unshard select, the select without LIMIT is wrapped in COUNT(*) as a derived table.
+// For shard select, fields are replaced with COUNT(1) and ORDER BY, LIMIT are removed, so select with
+// GROUP BY, DISTINCT or aggregate functions is not supported, rows of them may be duplicated in slices.
+func buildFoundRowsPlan(p Plan, phyDBs map[string]string, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*FoundRowsPlan, error) {
+	// the origin stmt has been rewritten by the plan, parse again
+	n, err := parser2.ParseOneStmt(parser.New(), sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse select error: %v", err)
+	}
+	stmt, ok := n.(*ast.SelectStmt)
+	if !ok {
+		return nil, fmt.Errorf("not a select statement, type: %T", n)
+	}
+	stmt.SelectStmtOpts.CalcFoundRows = false
+	stmt.Limit = nil
+
+	var countSQL string
+	if _, ok := p.(*UnshardPlan); ok {
+		s, err := generateUnshardingSQL(stmt)
+		if err != nil {
+			return nil, err
+		}
+		countSQL = "SELECT COUNT(*) FROM (" + s + ") AS `found_rows`"
+	} else {
+		if stmt.Distinct || stmt.GroupBy != nil || stmt.Having != nil {
+			return nil, fmt.Errorf("SQL_CALC_FOUND_ROWS with GROUP BY, DISTINCT or HAVING is not supported in sharding table")
+		}
+		for _, field := range stmt.Fields.Fields {
+			if field.Expr != nil && ast.HasAggFlag(field.Expr) {
+				return nil, fmt.Errorf("SQL_CALC_FOUND_ROWS with aggregate function is not supported in sharding table")
+			}
+		}
+		stmt.Fields.Fields = []*ast.SelectField{{
+			Expr: &ast.AggregateFuncExpr{
+				F:    ast.AggFuncCount,
+				Args: []ast.ExprNode{ast.NewValueExpr(1, "", "")},
+			},
+		}}
+		stmt.OrderBy = nil
+		s, err := generateUnshardingSQL(stmt)
+		if err != nil {
+			return nil, err
+		}
+		// keep the routing hint in leading comments
+		_, comments := parser2.SplitMarginComments(sql)
+		countSQL = comments.Leading + s
+	}
+
+	countStmt, err := parser2.ParseOneStmt(parser.New(), countSQL)
+	if err != nil {
+		return nil, fmt.Errorf("parse count sql error: %v, sql: %s", err, countSQL)
+	}
+	countPlan, err := BuildPlan(countStmt, phyDBs, db, countSQL, r, seq)
+	if err != nil {
+		return nil, fmt.Errorf("build count plan error: %v", err)
+	}
+	return &FoundRowsPlan{plan: p, countPlan: countPlan}, nil
+}
+
+// ExecuteIn implement Plan
+func (p *FoundRowsPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	r, err := p.plan.ExecuteIn(reqCtx, sess)
+	if err != nil {
+		return nil, err
+	}
+
+	cr, err := p.countPlan.ExecuteIn(reqCtx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("execute count query of SQL_CALC_FOUND_ROWS error: %v", err)
+	}
+	if cr.Resultset == nil || len(cr.Values) != 1 {
+		return nil, fmt.Errorf("invalid result of count query")
+	}
+	foundRows, err := cr.GetUint(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get result of count query error: %v", err)
+	}
+	reqCtx.Set(util.FoundRows, foundRows)
+	return r, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+func TestFoundRowsPlanMycat(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_mycat",
+			sql: "select sql_calc_found_rows * from tbl_mycat where id = 2 order by a limit 10",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_mycat_2": {"SELECT COUNT(1) FROM `tbl_mycat` WHERE `id`=2"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "/*+ route_to(db_mycat_1) */ select sql_calc_found_rows id from tbl_mycat limit 1",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT COUNT(1) FROM `tbl_mycat`"}, // routing hint is kept
+				},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select sql_calc_found_rows a, count(*) from tbl_mycat group by a limit 10",
+			hasErr: true, // group by is not supported
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select distinct sql_calc_found_rows a from tbl_mycat limit 10",
+			hasErr: true, // distinct is not supported
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select sql_calc_found_rows max(id) from tbl_mycat",
+			hasErr: true, // aggregate function is not supported
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, test.db, test.sql, ns.rt, ns.seqs)
+			if test.hasErr {
+				if err == nil {
+					t.Errorf("expect error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			fp, ok := p.(*FoundRowsPlan)
+			if !ok {
+				t.Fatalf("not a FoundRowsPlan: %T", p)
+			}
+			if _, ok := fp.plan.(*SelectPlan); !ok {
+				t.Errorf("not a SelectPlan: %T", fp.plan)
+			}
+			actualSQLs := fp.countPlan.(*SelectPlan).GetSQLs()
+			if !checkSQLs(test.sqls, actualSQLs) {
+				t.Errorf("not equal, expect: %v, actual: %v", test.sqls, actualSQLs)
+			}
+		})
+	}
+}
+
+func TestFoundRowsPlanUnshard(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	sql := "select sql_calc_found_rows a, count(*) from tbl_mycat_unknown group by a limit 10"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_mycat", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	fp := p.(*FoundRowsPlan)
+	if strings.Contains(strings.ToUpper(fp.plan.(*UnshardPlan).sql), "SQL_CALC_FOUND_ROWS") {
+		t.Errorf("SQL_CALC_FOUND_ROWS should not be sent to backend: %s", fp.plan.(*UnshardPlan).sql)
+	}
+
+	// group by is counted in derived table, COUNT(*) is restored as COUNT(1)
+	countSQL := fp.countPlan.(*UnshardPlan).sql
+	if !strings.HasPrefix(countSQL, "SELECT COUNT(1) FROM (SELECT") || !strings.HasSuffix(countSQL, "AS `found_rows`") ||
+		!strings.Contains(countSQL, "GROUP BY") || strings.Contains(countSQL, "LIMIT") {
+		t.Errorf("unexpected count sql: %s", countSQL)
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/mysql"
+	parser2 "github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+const informationSchemaDB = "information_schema"
+
+// informationSchemaTables tables of information_schema presented with logical schema
+var informationSchemaTables = map[string]bool{
+	"tables":     true,
+	"columns":    true,
+	"statistics": true,
+}
+
+// columns of information_schema.TABLES summed up from all sub tables
+var informationSchemaSumColumns = map[string]bool{
+	"TABLE_ROWS":   true,
+	"DATA_LENGTH":  true,
+	"INDEX_LENGTH": true,
+	"DATA_FREE":    true,
+}
+
+// InformationSchemaPlan is the plan for SELECT from information_schema.TABLES, COLUMNS and STATISTICS.
+// Metadata of the physical dbs in the namespace is read from all slices and converted to logical dbs and tables,
+// sub tables of a sharding table are presented by the first sub table, and sizes in TABLES are summed up.
+// Then the select is executed in default slice with the metadata as a derived table.
+type InformationSchemaPlan struct {
+	basePlan
+
+	sql   string
+	table string // upper case name of the information_schema table
+
+	sliceDBs  map[string][]string              // physical dbs in each slice
+	subTables map[string]*informationSchemaKey // key: slice.phyDB.phyTable, value: logical table
+	phyTables map[string]bool                  // key: phyDB.phyTable, physical tables of sharding tables
+	unshardDB map[string]string                // physical db of unshard tables in default slice to logical db
+}
+
+type informationSchemaKey struct {
+	db    string
+	table string
+	index int
+}
+
+// IsInformationSchemaStmt check if the statement selects from one of information_schema.TABLES, COLUMNS and STATISTICS
+func IsInformationSchemaStmt(stmt ast.StmtNode) bool {
+	_, ok := getInformationSchemaTableSource(stmt)
+	return ok
+}
+
+func getInformationSchemaTableSource(stmt ast.StmtNode) (*ast.TableSource, bool) {
+	s, ok := stmt.(*ast.SelectStmt)
+	if !ok || s.From == nil || s.From.TableRefs == nil || s.From.TableRefs.Right != nil {
+		return nil, false
+	}
+	ts, ok := s.From.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, false
+	}
+	tn, ok := ts.Source.(*ast.TableName)
+	if !ok || tn.Schema.L != informationSchemaDB || !informationSchemaTables[tn.Name.L] {
+		return nil, false
+	}
+	return ts, true
+}
+
+func buildInformationSchemaPlan(stmt ast.StmtNode, phyDBs map[string]string, sql string, r *router.Router) (*InformationSchemaPlan, error) {
+	ts, _ := getInformationSchemaTableSource(stmt)
+	p := &InformationSchemaPlan{
+		sql:       sql,
+		table:     strings.ToUpper(ts.Source.(*ast.TableName).Name.L),
+		sliceDBs:  make(map[string][]string),
+		subTables: make(map[string]*informationSchemaKey),
+		phyTables: make(map[string]bool),
+		unshardDB: make(map[string]string),
+	}
+
+	dbs := make(map[string]map[string]bool)
+	addDB := func(slice, db string) {
+		if dbs[slice] == nil {
+			dbs[slice] = make(map[string]bool)
+		}
+		dbs[slice][db] = true
+	}
+	for db, phyDB := range phyDBs {
+		p.unshardDB[phyDB] = db
+		addDB(backend.DefaultSlice, phyDB)
+		for table, rule := range r.GetShardRules(db) {
+			for _, index := range rule.GetSubTableIndexes() {
+				slice := rule.GetSlice(rule.GetSliceIndexFromTableIndex(index))
+				dbName, err := rule.GetDatabaseNameByTableIndex(index)
+				if err != nil {
+					return nil, fmt.Errorf("get database name of sub table %d error: %v", index, err)
+				}
+				tableName := table
+				if rule.GetType() != router.GlobalTableRuleType && !router.IsMycatShardingRule(rule.GetType()) {
+					tableName = fmt.Sprintf("%s_%04d", table, index)
+				}
+				p.subTables[slice+"."+dbName+"."+tableName] = &informationSchemaKey{db: db, table: table, index: index}
+				p.phyTables[dbName+"."+tableName] = true
+				addDB(slice, dbName)
+			}
+		}
+	}
+	for slice, sliceDBs := range dbs {
+		for db := range sliceDBs {
+			p.sliceDBs[slice] = append(p.sliceDBs[slice], db)
+		}
+		sort.Strings(p.sliceDBs[slice])
+	}
+	return p, nil
+}
+
+// ExecuteIn implement Plan
+func (p *InformationSchemaPlan) ExecuteIn(reqCtx *util.RequestContext, se Executor) (*mysql.Result, error) {
+	names, rows, err := p.readMetadata(reqCtx, se)
+	if err != nil {
+		return nil, err
+	}
+
+	derived, err := buildDerivedTableSQL(names, rows)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := p.rewrite(derived)
+	if err != nil {
+		return nil, err
+	}
+	sb := &strings.Builder{}
+	if err := stmt.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb)); err != nil {
+		return nil, fmt.Errorf("restore information_schema query error: %v", err)
+	}
+	return se.ExecuteSQL(reqCtx, backend.DefaultSlice, "", sb.String())
+}
+
+// readMetadata read rows of the information_schema table from all slices, and convert them to logical dbs and tables
+func (p *InformationSchemaPlan) readMetadata(reqCtx *util.RequestContext, se Executor) ([]string, [][]interface{}, error) {
+	slices := make([]string, 0, len(p.sliceDBs))
+	for slice := range p.sliceDBs {
+		slices = append(slices, slice)
+	}
+	sort.Strings(slices)
+
+	var names []string
+	var schemaColumns []int
+	tableColumn := -1
+	groups := make(map[informationSchemaKey][][]interface{})
+	for _, slice := range slices {
+		sql, err := p.metadataSQL(p.sliceDBs[slice])
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := se.ExecuteSQL(reqCtx, slice, "", sql)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read information_schema of slice %s error: %v", slice, err)
+		}
+		if r.Resultset == nil {
+			return nil, nil, fmt.Errorf("read information_schema of slice %s returns no result set", slice)
+		}
+		if names == nil {
+			for i, f := range r.Fields {
+				name := strings.ToUpper(string(f.Name))
+				names = append(names, name)
+				switch name {
+				case "TABLE_SCHEMA", "INDEX_SCHEMA":
+					schemaColumns = append(schemaColumns, i)
+				case "TABLE_NAME":
+					tableColumn = i
+				}
+			}
+			if len(schemaColumns) == 0 || tableColumn < 0 {
+				return nil, nil, fmt.Errorf("TABLE_SCHEMA or TABLE_NAME not found in information_schema.%s", p.table)
+			}
+		}
+
+		for _, row := range r.Values {
+			phyDB, phyTable := resultString(row[schemaColumns[0]]), resultString(row[tableColumn])
+			key, ok := p.logicalTable(slice, phyDB, phyTable)
+			if !ok {
+				continue
+			}
+			for _, i := range schemaColumns {
+				row[i] = key.db
+			}
+			row[tableColumn] = key.table
+			groups[*key] = append(groups[*key], row)
+		}
+	}
+
+	// sub tables of the same table are presented by the first one
+	keys := make([]informationSchemaKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].db != keys[j].db {
+			return keys[i].db < keys[j].db
+		}
+		if keys[i].table != keys[j].table {
+			return keys[i].table < keys[j].table
+		}
+		return keys[i].index < keys[j].index
+	})
+	var rows [][]interface{}
+	for i, key := range keys {
+		if i > 0 && keys[i-1].db == key.db && keys[i-1].table == key.table {
+			if p.table == "TABLES" && len(rows) != 0 {
+				sumTableSizes(names, rows[len(rows)-1], groups[key])
+			}
+			continue
+		}
+		rows = append(rows, groups[key]...)
+	}
+	return names, rows, nil
+}
+
+// logicalTable return the logical table of the physical table, sub tables of sharding tables are matched by slice,
+// tables which are not sub tables are unshard tables only if they are in default slice.
+func (p *InformationSchemaPlan) logicalTable(slice, phyDB, phyTable string) (*informationSchemaKey, bool) {
+	if key, ok := p.subTables[slice+"."+phyDB+"."+phyTable]; ok {
+		return key, true
+	}
+	if slice != backend.DefaultSlice || p.phyTables[phyDB+"."+phyTable] {
+		return nil, false
+	}
+	db, ok := p.unshardDB[phyDB]
+	if !ok {
+		return nil, false
+	}
+	return &informationSchemaKey{db: db, table: phyTable}, true
+}
+
+func (p *InformationSchemaPlan) metadataSQL(dbs []string) (string, error) {
+	values := make([]string, 0, len(dbs))
+	for _, db := range dbs {
+		v, err := restoreValue(db)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, v)
+	}
+	return fmt.Sprintf("SELECT * FROM `information_schema`.`%s` WHERE `TABLE_SCHEMA` IN (%s)", p.table, strings.Join(values, ",")), nil
+}
+
+// rewrite replace the information_schema table with the derived table
+func (p *InformationSchemaPlan) rewrite(derived string) (*ast.SelectStmt, error) {
+	n, err := parser2.ParseOneStmt(parser.New(), p.sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse information_schema query error: %v", err)
+	}
+	stmt := n.(*ast.SelectStmt)
+	ts, ok := getInformationSchemaTableSource(stmt)
+	if !ok {
+		return nil, fmt.Errorf("information_schema table not found")
+	}
+
+	d, err := parser2.ParseOneStmt(parser.New(), derived)
+	if err != nil {
+		return nil, fmt.Errorf("parse derived table error: %v", err)
+	}
+	if ts.AsName.L == "" {
+		ts.AsName = ts.Source.(*ast.TableName).Name
+	}
+	ts.Source = d.(ast.ResultSetNode)
+
+	// columns like information_schema.TABLES.TABLE_NAME refer to the derived table
+	stmt.Accept(&informationSchemaColumnVisitor{})
+	return stmt, nil
+}
+
+type informationSchemaColumnVisitor struct{}
+
+// Enter implement ast.Visitor
+func (v *informationSchemaColumnVisitor) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	if c, ok := n.(*ast.ColumnName); ok && c.Schema.L == informationSchemaDB {
+		c.Schema = model.CIStr{}
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (v *informationSchemaColumnVisitor) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// buildDerivedTableSQL return UNION ALL of the rows, or a select returns no row with the columns
+func buildDerivedTableSQL(names []string, rows [][]interface{}) (string, error) {
+	columns := make([]string, len(names))
+	for i, name := range names {
+		columns[i] = "`" + strings.Replace(name, "`", "``", -1) + "`"
+	}
+	if len(rows) == 0 {
+		fields := make([]string, len(names))
+		for i := range names {
+			fields[i] = "NULL AS " + columns[i]
+		}
+		return "SELECT " + strings.Join(fields, ",") + " FROM DUAL WHERE FALSE", nil
+	}
+
+	sb := &strings.Builder{}
+	for i, row := range rows {
+		if i > 0 {
+			sb.WriteString(" UNION ALL ")
+		}
+		sb.WriteString("SELECT ")
+		for j, value := range row {
+			if j > 0 {
+				sb.WriteString(",")
+			}
+			v, err := restoreValue(value)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(v)
+			if i == 0 {
+				sb.WriteString(" AS " + columns[j])
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// sumTableSizes add sizes of other sub tables to the row of the first sub table
+func sumTableSizes(names []string, row []interface{}, others [][]interface{}) {
+	for i, name := range names {
+		if !informationSchemaSumColumns[name] {
+			continue
+		}
+		for _, other := range others {
+			row[i] = addUint(row[i], other[i])
+		}
+	}
+}
+
+func addUint(a, b interface{}) interface{} {
+	toUint := func(v interface{}) (uint64, bool) {
+		switch n := v.(type) {
+		case uint64:
+			return n, true
+		case int64:
+			return uint64(n), n >= 0
+		default:
+			return 0, false
+		}
+	}
+	x, ok := toUint(a)
+	if !ok {
+		return b
+	}
+	y, ok := toUint(b)
+	if !ok {
+		return a
+	}
+	return x + y
+}
+
+func restoreValue(value interface{}) (string, error) {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	if value == nil {
+		return "NULL", nil
+	}
+	sb := &strings.Builder{}
+	if err := ast.NewValueExpr(value, "", "").Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb)); err != nil {
+		return "", fmt.Errorf("restore value %v error: %v", value, err)
+	}
+	return sb.String(), nil
+}
+
+func resultString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
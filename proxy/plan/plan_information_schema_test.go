@@ -0,0 +1,119 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+type informationSchemaExecutor struct {
+	Executor
+	metadata map[string][][]interface{} // key: slice
+	sqls     []string
+}
+
+func (e *informationSchemaExecutor) ExecuteSQL(ctx *util.RequestContext, slice, db, sql string) (*mysql.Result, error) {
+	e.sqls = append(e.sqls, sql)
+	if strings.HasPrefix(sql, "SELECT * FROM `information_schema`.`TABLES`") {
+		rs, err := mysql.BuildResultset(nil, []string{"TABLE_SCHEMA", "TABLE_NAME", "TABLE_ROWS"}, e.metadata[slice])
+		if err != nil {
+			return nil, err
+		}
+		return &mysql.Result{Resultset: rs}, nil
+	}
+	return &mysql.Result{}, nil
+}
+
+func TestIsInformationSchemaStmt(t *testing.T) {
+	tests := []struct {
+		sql    string
+		expect bool
+	}{
+		{"select * from information_schema.tables", true},
+		{"SELECT column_name FROM INFORMATION_SCHEMA.COLUMNS c WHERE c.table_name = 'tbl_ks'", true},
+		{"select * from information_schema.statistics where table_schema = 'db_ks'", true},
+		{"select * from information_schema.processlist", false},
+		{"select * from tables", false},
+		{"select * from information_schema.tables t join information_schema.columns c on t.table_name = c.table_name", false},
+	}
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		if actual := IsInformationSchemaStmt(stmt); actual != test.expect {
+			t.Errorf("not equal, sql: %s, expect: %v, actual: %v", test.sql, test.expect, actual)
+		}
+	}
+}
+
+func TestInformationSchemaPlan(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	sql := "select table_name, table_rows from information_schema.tables where table_schema = 'db_ks' order by table_name"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+
+	se := &informationSchemaExecutor{
+		metadata: map[string][][]interface{}{
+			"slice-0": {
+				{"db_ks", "tbl_a", uint64(1)},
+				{"db_ks", "tbl_ks_0000", uint64(10)},
+				{"db_ks", "tbl_ks_0001", uint64(20)},
+				{"db_mycat_0", "tbl_mycat", uint64(5)},
+				{"db_mycat_1", "tbl_mycat", uint64(6)},
+				{"db_mycat_1", "tbl_unknown", uint64(1)}, // not in default physical db
+			},
+			"slice-1": {
+				{"db_ks", "tbl_b", uint64(1)}, // unshard table is only in default slice
+				{"db_ks", "tbl_ks_0002", uint64(30)},
+				{"db_ks", "tbl_ks_0003", uint64(40)},
+				{"db_mycat_2", "tbl_mycat", uint64(7)},
+			},
+		},
+	}
+	if _, err := p.ExecuteIn(util.NewRequestContext(), se); err != nil {
+		t.Fatalf("execute plan error: %v", err)
+	}
+
+	if len(se.sqls) != 3 {
+		t.Fatalf("expect 3 sqls, actual: %v", se.sqls)
+	}
+	actual := se.sqls[2]
+	for _, s := range []string{"'db_ks','tbl_ks',100", "'db_mycat','tbl_mycat',18", "AS `tables` WHERE `table_schema`='db_ks' ORDER BY `table_name`"} {
+		if !strings.Contains(actual, s) {
+			t.Errorf("%s not found in %s", s, actual)
+		}
+	}
+	for _, s := range []string{"tbl_ks_0000", "tbl_b", "tbl_unknown", "db_mycat_0", "information_schema"} {
+		if strings.Contains(actual, s) {
+			t.Errorf("%s should not be in %s", s, actual)
+		}
+	}
+}
@@ -20,10 +20,15 @@ import (
 	"github.com/XiaoMi/Gaea/logging"
 	"github.com/XiaoMi/Gaea/mysql"
 	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/schema"
 	"github.com/XiaoMi/Gaea/proxy/sequence"
 	"github.com/XiaoMi/Gaea/util"
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
 	driver "github.com/pingcap/tidb/types/parser_driver"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // InsertPlan is the plan for insert statement
@@ -39,6 +44,11 @@ type InsertPlan struct {
 
 	sequences *sequence.SequenceManager
 
+	rows map[int][][]ast.ExprNode // values of each sub table index
+
+	lastInsertID           uint64 // first sequence number generated
+	autoIncrementSpecified bool   // values of auto increment column are specified, session last insert id is not changed
+
 	sqls map[string]map[string][]string
 }
 
@@ -77,6 +87,13 @@ func HandleInsertStmt(p *InsertPlan, stmt *ast.InsertStmt) error {
 		return fmt.Errorf("handleInsertGlobalSequenceValue error: %v", err)
 	}
 
+	if _, ok := p.sequences.GetSequence(p.db, p.table); ok {
+		// values of global sequence column are specified in all rows if no sequence number is generated
+		p.autoIncrementSpecified = p.lastInsertID == 0
+	} else {
+		p.autoIncrementSpecified = isAutoIncrementSpecified(p.router.GetSchema(), p.db, p.table, p.stmt)
+	}
+
 	if err := handleInsertColumnNames(p); err != nil {
 		return fmt.Errorf("handleInsertColumnNames error: %v", err)
 	}
@@ -89,7 +106,7 @@ func HandleInsertStmt(p *InsertPlan, stmt *ast.InsertStmt) error {
 		return fmt.Errorf("handleInsertValues error: %v", err)
 	}
 
-	sqls, err := generateShardingSQLs(p.stmt, p.result, p.router)
+	sqls, err := generateInsertShardingSQLs(p)
 	if err != nil {
 		logging.DefaultLogger.Warnf("generate insert parser failed, %v", err)
 		return err
@@ -206,54 +223,92 @@ func removeSchemaAndTableInfoInColumnName(column *ast.ColumnName) {
 	column.Table.L = ""
 }
 
-// TODO: refactor
+// handleInsertValues compute route result from sharding values.
+// Rows of batch insert are grouped by sub table, and each sub table only receives its own rows.
 func handleInsertValues(p *InsertPlan) error {
 	// assignment mode
 	if p.isAssignmentMode {
-		valueItem := p.stmt.Setlist[p.shardingColumnIndex].Expr
-		switch x := valueItem.(type) {
-		case *driver.ValueExpr:
-			v, err := util.GetValueExprResult(x)
-			if err != nil {
-				return fmt.Errorf("get value expr result failed, %v", err)
-			}
-			if v == nil {
-				return fmt.Errorf("sharding value cannot be null")
-			}
-			routeIdx, err := p.tableRules[p.table].FindTableIndex(v)
-			if err != nil {
-				return fmt.Errorf("find table index error: %v", err)
-			}
-			p.result.Inter([]int{routeIdx})
+		routeIdx, err := findInsertTableIndex(p, p.stmt.Setlist[p.shardingColumnIndex].Expr)
+		if err != nil {
+			return err
 		}
+		p.result.Inter([]int{routeIdx})
 		return nil
 	}
 
 	// not assignment mode
+	rows := make(map[int][][]ast.ExprNode)
+	var routeIndexes []int
 	for _, valueList := range p.stmt.Lists {
-		valueItem := valueList[p.shardingColumnIndex]
-		switch x := valueItem.(type) {
-		case *driver.ValueExpr:
-			v, err := util.GetValueExprResult(x)
-			if err != nil {
-				return fmt.Errorf("get value expr result failed, %v", err)
-			}
-			if v == nil {
-				return fmt.Errorf("sharding value cannot be null")
-			}
-			routeIdx, err := p.tableRules[p.table].FindTableIndex(v)
-			if err != nil {
-				return fmt.Errorf("find table index error: %v", err)
-			}
-			p.result.Inter([]int{routeIdx})
+		routeIdx, err := findInsertTableIndex(p, valueList[p.shardingColumnIndex])
+		if err != nil {
+			return err
 		}
+		if _, ok := rows[routeIdx]; !ok {
+			routeIndexes = append(routeIndexes, routeIdx)
+		}
+		rows[routeIdx] = append(rows[routeIdx], valueList)
 	}
-	if len(p.result.GetShardIndexes()) == 0 {
-		return fmt.Errorf("batch insert has cross slice values or no route found")
+	// indexes of route result are sorted
+	sort.Ints(routeIndexes)
+	p.result.Inter(routeIndexes)
+	if len(p.result.GetShardIndexes()) != len(rows) {
+		return fmt.Errorf("batch insert has values out of route range")
 	}
+	p.rows = rows
 	return nil
 }
 
+func findInsertTableIndex(p *InsertPlan, valueItem ast.ExprNode) (int, error) {
+	x, ok := valueItem.(*driver.ValueExpr)
+	if !ok {
+		return -1, fmt.Errorf("sharding value must be a constant")
+	}
+	v, err := util.GetValueExprResult(x)
+	if err != nil {
+		return -1, fmt.Errorf("get value expr result failed, %v", err)
+	}
+	if v == nil {
+		return -1, fmt.Errorf("sharding value cannot be null")
+	}
+	routeIdx, err := p.tableRules[p.table].FindTableIndex(v)
+	if err != nil {
+		return -1, fmt.Errorf("find table index error: %v", err)
+	}
+	return routeIdx, nil
+}
+
+// generateInsertShardingSQLs generate sqls of each sub table with the rows routed to it
+func generateInsertShardingSQLs(p *InsertPlan) (map[string]map[string][]string, error) {
+	if len(p.rows) <= 1 {
+		return generateShardingSQLs(p.stmt, p.result, p.router)
+	}
+
+	lists, indexes := p.stmt.Lists, p.result.indexes
+	defer func() {
+		p.stmt.Lists, p.result.indexes = lists, indexes
+	}()
+
+	ret := make(map[string]map[string][]string)
+	for _, idx := range indexes {
+		p.stmt.Lists = p.rows[idx]
+		p.result.indexes = []int{idx}
+		sqls, err := generateShardingSQLs(p.stmt, p.result, p.router)
+		if err != nil {
+			return nil, err
+		}
+		for sliceName, dbSQLs := range sqls {
+			if _, ok := ret[sliceName]; !ok {
+				ret[sliceName] = make(map[string][]string)
+			}
+			for dbName, s := range dbSQLs {
+				ret[sliceName][dbName] = append(ret[sliceName][dbName], s...)
+			}
+		}
+	}
+	return ret, nil
+}
+
 // check on duplicate key
 // 不管分片表的配置信息, 只要在OnDuplicate出现分片列, 就返回错误
 // 去掉ColumnName中的DB名和表名
@@ -273,31 +328,39 @@ func handleInsertOnDuplicate(p *InsertPlan) error {
 	return nil
 }
 
-// 处理全局序列号, 目前一条SQL中只允许一个列使用全局序列号
+// 处理全局序列号, 目前一条SQL中只允许一个列使用全局序列号.
+// 序列号列的值为nextval()或NULL, 以及没有指定序列号列时, 填充下一个序列号
 func handleInsertGlobalSequenceValue(p *InsertPlan) error {
 	seq, ok := p.sequences.GetSequence(p.db, p.table)
 	if !ok {
 		return nil
 	}
-	pkName := seq.GetPKName()
+	pkName := model.NewCIStr(seq.GetPKName())
 
-	// not assignment mode
+	// assignment mode
 	if p.isAssignmentMode {
 		for _, assignment := range p.stmt.Setlist {
-			columnName := assignment.Column.Name.L
-			if columnName == pkName {
-				if x, ok := assignment.Expr.(*ast.FuncCallExpr); ok {
-					if x.FnName.L == "nextval" {
-						id, err := seq.NextSeq()
-						if err != nil {
-							return fmt.Errorf("get next seq error: %v", err)
-						}
-						assignment.Expr = ast.NewValueExpr(id, "", "")
-						break
-					}
+			if assignment.Column.Name.L == pkName.L {
+				if !isSequenceValuePlaceholder(assignment.Expr) {
+					return nil
+				}
+				id, err := p.nextSequenceValue(seq)
+				if err != nil {
+					return err
 				}
+				assignment.Expr = ast.NewValueExpr(id, "", "")
+				return nil
 			}
 		}
+
+		id, err := p.nextSequenceValue(seq)
+		if err != nil {
+			return err
+		}
+		p.stmt.Setlist = append(p.stmt.Setlist, &ast.Assignment{
+			Column: &ast.ColumnName{Name: pkName},
+			Expr:   ast.NewValueExpr(id, "", ""),
+		})
 		return nil
 	}
 
@@ -305,32 +368,132 @@ func handleInsertGlobalSequenceValue(p *InsertPlan) error {
 	var seqIndex = -1
 	for i, column := range p.stmt.Columns {
 		columnName := column.Name.L
-		if columnName == pkName {
+		if columnName == pkName.L {
 			seqIndex = i
 			break
 		}
 	}
 
-	// global sequence column not found
+	// global sequence column not found, append it to columns
 	if seqIndex == -1 {
-		return nil
+		p.stmt.Columns = append(p.stmt.Columns, &ast.ColumnName{Name: pkName})
+		for i := range p.stmt.Lists {
+			p.stmt.Lists[i] = append(p.stmt.Lists[i], ast.NewValueExpr(nil, "", ""))
+		}
+		seqIndex = len(p.stmt.Columns) - 1
 	}
 
 	for _, valueList := range p.stmt.Lists {
-		if x, ok := valueList[seqIndex].(*ast.FuncCallExpr); ok {
-			if x.FnName.L == "nextval" {
-				id, err := seq.NextSeq()
-				if err != nil {
-					return fmt.Errorf("get next seq error: %v", err)
-				}
-				valueList[seqIndex] = ast.NewValueExpr(id, "", "")
+		if isSequenceValuePlaceholder(valueList[seqIndex]) {
+			id, err := p.nextSequenceValue(seq)
+			if err != nil {
+				return err
 			}
+			valueList[seqIndex] = ast.NewValueExpr(id, "", "")
 		}
 	}
 
 	return nil
 }
 
+// isSequenceValuePlaceholder check if the value of sequence column should be generated
+func isSequenceValuePlaceholder(expr ast.ExprNode) bool {
+	switch x := expr.(type) {
+	case *ast.FuncCallExpr:
+		return x.FnName.L == "nextval"
+	case *driver.ValueExpr:
+		return x.Datum.IsNull()
+	}
+	return false
+}
+
+// isAutoIncrementSpecified check if non-zero values of auto increment column are specified in all rows of INSERT.
+// Backend returns the specified value as insert id, but LAST_INSERT_ID() is only changed by generated values.
+// It returns false if the auto increment column is unknown, e.g. schema of the table is not loaded.
+func isAutoIncrementSpecified(s *schema.Schema, db, table string, stmt *ast.InsertStmt) bool {
+	t, ok := s.GetTable(db, table)
+	if !ok {
+		return false
+	}
+	index, ok := t.AutoIncrementColumn()
+	if !ok {
+		return false
+	}
+	name := strings.ToLower(t.Columns[index].Name)
+
+	// INSERT INTO tbl SET col=val, ...
+	if len(stmt.Setlist) != 0 {
+		for _, assignment := range stmt.Setlist {
+			if assignment.Column.Name.L == name {
+				return !isAutoIncrementValueGenerated(assignment.Expr)
+			}
+		}
+		return false
+	}
+
+	if stmt.Select != nil || len(stmt.Lists) == 0 {
+		return false
+	}
+	// values are in order of table columns if column names are omitted
+	if len(stmt.Columns) != 0 {
+		index = -1
+		for i, column := range stmt.Columns {
+			if column.Name.L == name {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return false
+		}
+	}
+	for _, valueList := range stmt.Lists {
+		if index >= len(valueList) || isAutoIncrementValueGenerated(valueList[index]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isAutoIncrementValueGenerated check if value of auto increment column is generated by backend, i.e. NULL or 0.
+// Values unknown before execution such as DEFAULT, functions and parameters are treated as generated.
+func isAutoIncrementValueGenerated(expr ast.ExprNode) bool {
+	x, ok := expr.(*driver.ValueExpr)
+	if !ok {
+		return true
+	}
+	v, err := util.GetValueExprResult(x)
+	if err != nil {
+		return true
+	}
+	switch n := v.(type) {
+	case nil:
+		return true
+	case int64:
+		return n == 0
+	case uint64:
+		return n == 0
+	case float64:
+		return n == 0
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return err == nil && f == 0
+	}
+	return false
+}
+
+// nextSequenceValue get next sequence number, the first one is returned as last insert id
+func (s *InsertPlan) nextSequenceValue(seq sequence.Sequence) (int64, error) {
+	id, err := seq.NextSeq()
+	if err != nil {
+		return 0, fmt.Errorf("get next seq error: %v", err)
+	}
+	if s.lastInsertID == 0 {
+		s.lastInsertID = uint64(id)
+	}
+	return id, nil
+}
+
 // ExecuteIn implement Plan
 func (s *InsertPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
 	rs, err := sess.ExecuteSQLs(reqCtx, s.sqls)
@@ -343,7 +506,11 @@ func (s *InsertPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mys
 		return nil, err
 	}
 
-	if r.InsertID != 0 {
+	// 由全局序列号生成的值不是自增列生成的, 后端不会返回insert id
+	if s.lastInsertID != 0 {
+		r.InsertID = s.lastInsertID
+	}
+	if r.InsertID != 0 && !s.autoIncrementSpecified {
 		sess.SetLastInsertID(r.InsertID)
 	}
 
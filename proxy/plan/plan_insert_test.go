@@ -14,7 +14,14 @@
 
 package plan
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/schema"
+	"github.com/XiaoMi/Gaea/util"
+)
 
 func TestMycatShardSimpleInsert(t *testing.T) {
 	ns, err := preparePlanInfo()
@@ -104,9 +111,18 @@ func TestMycatShardSimpleInsert(t *testing.T) {
 				},
 			},
 		},
+		{
+			db:  "db_mycat",
+			sql: "insert into tbl_mycat (a) values ('hi')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"INSERT INTO `tbl_mycat` (`a`,`id`) VALUES ('hi',1)"}, // sharding column is filled by global sequence
+				},
+			},
+		},
 		{
 			db:     "db_mycat",
-			sql:    "insert into tbl_mycat (a) values ('hi')",
+			sql:    "insert into tbl_mycat_murmur (a) values ('hi')",
 			hasErr: true, // sharding column not found
 		},
 		{
@@ -193,15 +209,61 @@ func TestMycatShardBatchInsert(t *testing.T) {
 				},
 			},
 		},
+		{
+			db:  "db_mycat",
+			sql: "insert into tbl_mycat (id, a) values (0, 'hi'), (1, 'hi'), (4, 'hi')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"INSERT INTO `tbl_mycat` (`id`,`a`) VALUES (0,'hi'),(4,'hi')"},
+					"db_mycat_1": {"INSERT INTO `tbl_mycat` (`id`,`a`) VALUES (1,'hi')"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "insert into tbl_mycat (id, a) values (6, 'hi'), (5, 'hello')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"INSERT INTO `tbl_mycat` (`id`,`a`) VALUES (5,'hello')"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"INSERT INTO `tbl_mycat` (`id`,`a`) VALUES (6,'hi')"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "insert into tbl_mycat (id, a) values (0, 'hi'), (2, 'hello') on duplicate key update a = values(a)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"INSERT INTO `tbl_mycat` (`id`,`a`) VALUES (0,'hi') ON DUPLICATE KEY UPDATE `a`=VALUES(`a`)"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"INSERT INTO `tbl_mycat` (`id`,`a`) VALUES (2,'hello') ON DUPLICATE KEY UPDATE `a`=VALUES(`a`)"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "replace into tbl_mycat (id, a) values (0, 'hi'), (3, 'hello')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"REPLACE INTO `tbl_mycat` (`id`,`a`) VALUES (0,'hi')"},
+				},
+				"slice-1": {
+					"db_mycat_3": {"REPLACE INTO `tbl_mycat` (`id`,`a`) VALUES (3,'hello')"},
+				},
+			},
+		},
 		{
 			db:     "db_mycat",
-			sql:    "insert into tbl_mycat (id, a) values (0, 'hi'), (1, 'hi'), (4, 'hi')",
-			hasErr: true, // batch insert has cross slice values
+			sql:    "insert into tbl_mycat (id, a) values (0, 'hi'), (2, 'hello') on duplicate key update id = values(id)",
+			hasErr: true, // routing key in update expression
 		},
 		{
 			db:     "db_mycat",
-			sql:    "insert into tbl_mycat (id, a) values (6, 'hi'), (5, 'hello')",
-			hasErr: true, // batch insert has cross slice values
+			sql:    "insert into tbl_mycat (id, a) values (0, 'hi'), (1+1, 'hello')",
+			hasErr: true, // sharding value must be a constant
 		},
 	}
 	for _, test := range tests {
@@ -690,6 +752,52 @@ func TestMycatInsertSequenceShardKey(t *testing.T) {
 	}
 }
 
+func TestMycatInsertOmittedSequenceColumn(t *testing.T) {
+	tests := []SQLTestcase{
+		{
+			db:  "db_mycat",
+			sql: "insert into tbl_mycat (a) values ('hi'), ('hello')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"INSERT INTO `tbl_mycat` (`a`,`id`) VALUES ('hi',1)"}, // omitted sequence column
+				},
+				"slice-1": {
+					"db_mycat_2": {"INSERT INTO `tbl_mycat` (`a`,`id`) VALUES ('hello',2)"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "insert into tbl_mycat set a = 'hi'",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"INSERT INTO `tbl_mycat` SET `a`='hi',`id`=1"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "insert into tbl_mycat (id, a) values (null, 'hi'), (2, 'hello')",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"INSERT INTO `tbl_mycat` (`id`,`a`) VALUES (1,'hi')"}, // null is replaced
+				},
+				"slice-1": {
+					"db_mycat_2": {"INSERT INTO `tbl_mycat` (`id`,`a`) VALUES (2,'hello')"},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		// each case starts from a new global sequence
+		ns, err := preparePlanInfo()
+		if err != nil {
+			t.Fatalf("prepare namespace error: %v", err)
+		}
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
 // 注意这一组各个测试用例之前有关联, 因为都用到了同一个全局序列号
 func TestMycatInsertSequenceUnshardKey(t *testing.T) {
 	ns, err := preparePlanInfo()
@@ -743,7 +851,7 @@ func TestEscapeBackslashShard(t *testing.T) {
 			sql: `insert into tbl_ks (id,name) values (1,'hello\\"world')`,
 			sqls: map[string]map[string][]string{
 				"slice-0": {
-					"db_ks": {"INSERT INTO `tbl_ks_0001` (`id`,`name`) VALUES (1,'hello\\\\\"world')"},
+					"db_ks": {"INSERT INTO `tbl_ks_0001` (`id`,`name`,`user_id`) VALUES (1,'hello\\\\\"world',1)"},
 				},
 			},
 		},
@@ -819,3 +927,100 @@ func TestInserTableNameColumnCaseInsensitive(t *testing.T) {
 		t.Run(test.sql, getTestFunc(ns, test))
 	}
 }
+
+func TestInsertSequenceLastInsertID(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	sql := "insert into tbl_ks (id, a) values (0, 'hi'), (0, 'hello')"
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	p, err := BuildPlan(stmt, ns.phyDBs, "db_ks", sql, ns.rt, ns.seqs)
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	// last insert id is the first sequence number generated
+	if id := p.(*InsertPlan).lastInsertID; id != 1 {
+		t.Errorf("last insert id not equal, expect: 1, actual: %d", id)
+	}
+}
+
+type insertIDExecutor struct {
+	Executor
+	lastInsertID uint64
+}
+
+func (e *insertIDExecutor) ExecuteSQL(ctx *util.RequestContext, slice, db, sql string) (*mysql.Result, error) {
+	return &mysql.Result{AffectedRows: 1, InsertID: 10}, nil
+}
+
+func (e *insertIDExecutor) ExecuteSQLs(ctx *util.RequestContext, sqls map[string]map[string][]string) ([]*mysql.Result, error) {
+	return []*mysql.Result{{AffectedRows: 1, InsertID: 10}}, nil
+}
+
+func (e *insertIDExecutor) SetLastInsertID(id uint64) { e.lastInsertID = id }
+
+func (e *insertIDExecutor) GetLastInsertID() uint64 { return e.lastInsertID }
+
+func TestInsertAutoIncrementSpecifiedLastInsertID(t *testing.T) {
+	tests := []struct {
+		db      string
+		sql     string
+		changed bool // last insert id of session is changed
+	}{
+		{"db_mycat", "insert into tbl_mycat_murmur (id, seq, a) values (1, 10, 'hi')", false},
+		{"db_mycat", "insert into tbl_mycat_murmur (id, seq, a) values (1, 10, 'hi'), (2, null, 'hello')", true},
+		{"db_mycat", "insert into tbl_mycat_murmur (id, a) values (1, 'hi')", true},
+		{"db_mycat", "insert into tbl_mycat_murmur set id = 1, seq = '0'", true},
+		{"db_mycat", "insert into tbl_mycat_murmur set id = 1, seq = 5", false},
+		{"db_mycat", "insert into tbl_mycat (id, a) values (5, 'hi')", false}, // global sequence column
+		{"db_mycat", "insert into tbl_mycat (a) values ('hi')", true},
+		{"db_mycat", "insert into tbl_unshard values (3, 'hi')", false},
+		{"db_mycat", "insert into db_mycat.tbl_unshard (a) values ('hi')", true},
+		{"db_mycat", "insert into tbl_unshard values (default, 'hi')", true},
+		{"db_mycat", "insert into tbl_unknown (id) values (3)", true}, // schema is not loaded
+	}
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			ns, err := preparePlanInfo()
+			if err != nil {
+				t.Fatalf("prepare namespace error: %v", err)
+			}
+			ns.rt.GetSchema().SetTables("db_mycat", map[string]*schema.Table{
+				"tbl_mycat_murmur": schema.NewTable([]*schema.Column{
+					{Name: "id", DataType: "bigint", ColumnType: "bigint(20)"},
+					{Name: "seq", DataType: "bigint", ColumnType: "bigint(20)", AutoIncrement: true},
+					{Name: "a", DataType: "varchar", ColumnType: "varchar(64)", Nullable: true},
+				}),
+				"tbl_unshard": schema.NewTable([]*schema.Column{
+					{Name: "id", DataType: "int", ColumnType: "int(11)", AutoIncrement: true},
+					{Name: "a", DataType: "varchar", ColumnType: "varchar(64)", Nullable: true},
+				}),
+			})
+
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			p, err := BuildPlan(stmt, ns.phyDBs, test.db, test.sql, ns.rt, ns.seqs)
+			if err != nil {
+				t.Fatalf("build plan error: %v", err)
+			}
+			e := &insertIDExecutor{}
+			r, err := p.ExecuteIn(util.NewRequestContext(), e)
+			if err != nil {
+				t.Fatalf("execute error: %v", err)
+			}
+			if r.InsertID == 0 {
+				t.Errorf("insert id of backend is not returned")
+			}
+			if changed := e.lastInsertID != 0; changed != test.changed {
+				t.Errorf("last insert id changed not equal, expect: %v, actual: %v", test.changed, changed)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+)
+
+// PreparedShardPlan is the plan of prepared SELECT, UPDATE or DELETE of a sharding table,
+// which is routed by the placeholder of sharding column, e.g. SELECT * FROM t WHERE id = ?.
+// The plan is built once at prepare time with all sub tables, and each execution only prunes
+// the sub tables with the bound value of the placeholder, so the statement is not parsed again.
+type PreparedShardPlan struct {
+	plan        Plan
+	stmtInfo    *TableAliasStmtInfo
+	rule        router.Rule
+	paramOffset int // offset of the placeholder of sharding column in origin sql
+
+	sqls map[int]map[string]map[string][]string // key = sub table index
+}
+
+// BuildPreparedShardPlan build plan of prepared statement, which is routed by the placeholder of sharding column.
+// An error is returned if the route of statement is not only decided by the placeholder, these statements
+// should be planned with bound values in each execution.
+func BuildPreparedShardPlan(stmt ast.StmtNode, phyDBs map[string]string, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*PreparedShardPlan, error) {
+	var tableRefs *ast.TableRefsClause
+	var where ast.ExprNode
+	switch s := stmt.(type) {
+	case *ast.SelectStmt:
+		tableRefs, where = s.From, s.Where
+	case *ast.UpdateStmt:
+		tableRefs, where = s.TableRefs, s.Where
+	case *ast.DeleteStmt:
+		if s.IsMultiTable {
+			return nil, fmt.Errorf("multiple table delete is not supported")
+		}
+		tableRefs, where = s.TableRefs, s.Where
+	default:
+		return nil, fmt.Errorf("stmt type %T is not supported", stmt)
+	}
+	if hint, err := parser.ExtractRouteHint(sql); err != nil || hint != nil {
+		return nil, fmt.Errorf("statement with route hint is not supported")
+	}
+	if tableRefs == nil || tableRefs.TableRefs == nil || tableRefs.TableRefs.Right != nil {
+		return nil, fmt.Errorf("statement must contain only one table")
+	}
+	tableSource, ok := tableRefs.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, fmt.Errorf("statement must contain only one table")
+	}
+	tableName, ok := tableSource.Source.(*ast.TableName)
+	if !ok {
+		return nil, fmt.Errorf("table source must be table name")
+	}
+	tableDB := db
+	if tableName.Schema.O != "" {
+		tableDB = tableName.Schema.O
+	}
+	rule, ok := r.GetShardRule(tableDB, tableName.Name.L)
+	if !ok || rule.GetType() == router.GlobalTableRuleType {
+		return nil, fmt.Errorf("table %s.%s is not a sharding table", tableDB, tableName.Name.L)
+	}
+
+	// 在BuildPlan改写语法树之前查找分表列的占位符
+	marker, ok := findShardingParamMarker(where, rule.GetShardingColumn(), tableName.Name.L, tableSource.AsName.L)
+	if !ok {
+		return nil, fmt.Errorf("no placeholder of sharding column %s in WHERE", rule.GetShardingColumn())
+	}
+
+	p, err := BuildPlan(stmt, phyDBs, db, sql, r, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	var stmtInfo *TableAliasStmtInfo
+	var stmtNode ast.StmtNode
+	switch pp := p.(type) {
+	case *SelectPlan:
+		// HAVING在proxy中计算时, 占位符会被移动到select列中, 无法按顺序绑定参数
+		if pp.having != nil {
+			return nil, fmt.Errorf("HAVING computed in proxy is not supported")
+		}
+		stmtInfo, stmtNode = pp.TableAliasStmtInfo, pp.stmt
+	case *UpdatePlan:
+		stmtInfo, stmtNode = pp.TableAliasStmtInfo, pp.stmt
+	case *DeletePlan:
+		stmtInfo, stmtNode = pp.TableAliasStmtInfo, pp.stmt
+	default:
+		return nil, fmt.Errorf("plan type %T is not supported", p)
+	}
+
+	// 除了分表列的占位符, 其他条件不能参与路由计算
+	if !isAllSubTables(stmtInfo.result.indexes, rule.GetSubTableIndexes()) {
+		return nil, fmt.Errorf("route result %v is decided by other conditions", stmtInfo.result.indexes)
+	}
+
+	indexes := stmtInfo.result.indexes
+	defer func() {
+		stmtInfo.result.indexes = indexes
+	}()
+	sqls := make(map[int]map[string]map[string][]string, len(indexes))
+	for _, idx := range indexes {
+		stmtInfo.result.indexes = []int{idx}
+		idxSQLs, err := generateShardingSQLs(stmtNode, stmtInfo.result, r)
+		if err != nil {
+			return nil, err
+		}
+		sqls[idx] = idxSQLs
+	}
+
+	return &PreparedShardPlan{
+		plan:        p,
+		stmtInfo:    stmtInfo,
+		rule:        rule,
+		paramOffset: marker.Offset,
+		sqls:        sqls,
+	}, nil
+}
+
+// GetParamOffset return offset of the placeholder of sharding column in origin sql
+func (p *PreparedShardPlan) GetParamOffset() int {
+	return p.paramOffset
+}
+
+// GetAllSQLs return sqls of all sub tables with placeholders, values are bound to them in Bind
+func (p *PreparedShardPlan) GetAllSQLs() []string {
+	var ret []string
+	for _, idxSQLs := range p.sqls {
+		for _, dbSQLs := range idxSQLs {
+			for _, sqls := range dbSQLs {
+				ret = append(ret, sqls...)
+			}
+		}
+	}
+	return ret
+}
+
+// Bind return the plan routed to the sub table of value of sharding column,
+// bind is called to bind values to each sql of the sub table.
+func (p *PreparedShardPlan) Bind(value interface{}, bind func(sql string) string) (ret Plan, err error) {
+	// 分片函数遇到不支持的值会panic
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("find table index panic: %v", e)
+		}
+	}()
+
+	idx, err := p.rule.FindTableIndex(value)
+	if err != nil {
+		return nil, err
+	}
+	idxSQLs, ok := p.sqls[idx]
+	if !ok {
+		return nil, fmt.Errorf("sql of table index %d not found", idx)
+	}
+
+	sqls := make(map[string]map[string][]string, len(idxSQLs))
+	for slice, dbSQLs := range idxSQLs {
+		sqls[slice] = make(map[string][]string, len(dbSQLs))
+		for phyDB, s := range dbSQLs {
+			bound := make([]string, len(s))
+			for i := range s {
+				bound[i] = bind(s[i])
+			}
+			sqls[slice][phyDB] = bound
+		}
+	}
+
+	// 路由结果只包含一个分表, 使IsFullScatter等检查与执行时生成的plan一致
+	result := *p.stmtInfo.result
+	result.currentIndex = 0
+	result.indexes = []int{idx}
+	stmtInfo := *p.stmtInfo.StmtInfo
+	stmtInfo.result = &result
+	aliasStmtInfo := *p.stmtInfo
+	aliasStmtInfo.StmtInfo = &stmtInfo
+
+	switch pp := p.plan.(type) {
+	case *SelectPlan:
+		np := *pp
+		np.TableAliasStmtInfo = &aliasStmtInfo
+		np.sqls = sqls
+		return &np, nil
+	case *UpdatePlan:
+		np := *pp
+		np.TableAliasStmtInfo = &aliasStmtInfo
+		np.sqls = sqls
+		return &np, nil
+	case *DeletePlan:
+		np := *pp
+		np.TableAliasStmtInfo = &aliasStmtInfo
+		np.sqls = sqls
+		return &np, nil
+	default:
+		return nil, fmt.Errorf("plan type %T is not supported", p.plan)
+	}
+}
+
+// findShardingParamMarker 在WHERE的顶层AND条件中查找 分表列 = 占位符 的条件
+func findShardingParamMarker(where ast.ExprNode, column, table, alias string) (*driver.ParamMarkerExpr, bool) {
+	switch x := where.(type) {
+	case *ast.ParenthesesExpr:
+		return findShardingParamMarker(x.Expr, column, table, alias)
+	case *ast.BinaryOperationExpr:
+		switch x.Op {
+		case opcode.LogicAnd:
+			if m, ok := findShardingParamMarker(x.L, column, table, alias); ok {
+				return m, true
+			}
+			return findShardingParamMarker(x.R, column, table, alias)
+		case opcode.EQ:
+			if m, ok := x.R.(*driver.ParamMarkerExpr); ok && isShardingColumnExpr(x.L, column, table, alias) {
+				return m, true
+			}
+			if m, ok := x.L.(*driver.ParamMarkerExpr); ok && isShardingColumnExpr(x.R, column, table, alias) {
+				return m, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func isShardingColumnExpr(expr ast.ExprNode, column, table, alias string) bool {
+	c, ok := expr.(*ast.ColumnNameExpr)
+	if !ok || c.Name.Name.L != column {
+		return false
+	}
+	if alias != "" {
+		return c.Name.Table.L == "" || c.Name.Table.L == alias
+	}
+	return c.Name.Table.L == "" || c.Name.Table.L == table
+}
+
+func isAllSubTables(indexes, all []int) bool {
+	if len(indexes) != len(all) {
+		return false
+	}
+	sorted := append([]int(nil), all...)
+	sort.Ints(sorted)
+	for i := range indexes {
+		if indexes[i] != sorted[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+func TestPreparedShardPlan(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql    string
+		offset int
+		value  interface{}
+		expect map[string]map[string][]string
+	}{
+		{
+			"select * from tbl_mycat where id = ? and a = ?",
+			35,
+			int64(2),
+			map[string]map[string][]string{"slice-1": {"db_mycat_2": {"SELECT * FROM `tbl_mycat` WHERE `id`=2 AND `a`=2"}}},
+		},
+		{
+			"select count(*) from tbl_mycat t where ? = t.id order by a limit 10",
+			39,
+			int64(5),
+			map[string]map[string][]string{"slice-0": {"db_mycat_1": {"SELECT COUNT(1),`a` FROM `tbl_mycat` AS `t` WHERE 5=`t`.`id` ORDER BY `a` LIMIT 10"}}},
+		},
+		{
+			"update tbl_mycat set a = ? where (id = ?)",
+			39,
+			"3",
+			map[string]map[string][]string{"slice-1": {"db_mycat_3": {"UPDATE `tbl_mycat` SET `a`=3 WHERE (`id`=3)"}}},
+		},
+		{
+			"delete from tbl_mycat where id = ?",
+			33,
+			int64(0),
+			map[string]map[string][]string{"slice-0": {"db_mycat_0": {"DELETE FROM `tbl_mycat` WHERE `id`=0"}}},
+		},
+	}
+
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error, sql: %s, err: %v", test.sql, err)
+		}
+		p, err := BuildPreparedShardPlan(stmt, ns.phyDBs, "db_mycat", test.sql, ns.rt, ns.seqs)
+		if err != nil {
+			t.Fatalf("build prepared plan error, sql: %s, err: %v", test.sql, err)
+		}
+		if len(p.GetAllSQLs()) != 4 {
+			t.Errorf("prepared plan should contain sqls of all sub tables, sql: %s, actual: %v", test.sql, p.GetAllSQLs())
+		}
+		if p.GetParamOffset() != test.offset {
+			t.Errorf("param offset error, sql: %s, expect: %d, actual: %d", test.sql, test.offset, p.GetParamOffset())
+		}
+
+		bp, err := p.Bind(test.value, func(sql string) string {
+			return strings.Replace(sql, "?", fmt.Sprint(test.value), -1)
+		})
+		if err != nil {
+			t.Fatalf("bind prepared plan error, sql: %s, err: %v", test.sql, err)
+		}
+		var sqls map[string]map[string][]string
+		switch x := bp.(type) {
+		case *SelectPlan:
+			sqls = x.GetSQLs()
+		case *UpdatePlan:
+			sqls = x.sqls
+		case *DeletePlan:
+			sqls = x.sqls
+		}
+		if !reflect.DeepEqual(sqls, test.expect) {
+			t.Errorf("bind prepared plan error, sql: %s, expect: %v, actual: %v", test.sql, test.expect, sqls)
+		}
+		if IsFullScatter(bp) {
+			t.Errorf("bound plan should not be full scatter, sql: %s", test.sql)
+		}
+	}
+}
+
+func TestPreparedShardPlanNotSupported(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []string{
+		"select * from tbl_mycat where a = ?",                              // no placeholder of sharding column
+		"select * from tbl_mycat where id = ? or a = 1",                    // not AND condition
+		"select * from tbl_mycat where id = ? and id = 1",                  // routed by other condition
+		"select * from tbl_mycat where id in (?, ?)",                       // IN list is routed with values
+		"select * from tbl_mycat where id = ? limit ?",                     // LIMIT is merged with values
+		"select * from tbl_unshard where id = ?",                           // not sharding table
+		"/*+ route_to(db_mycat_0) */ select * from tbl_mycat where id = ?", // route hint
+		"insert into tbl_mycat (id, a) values (?, ?)",
+	}
+	for _, sql := range tests {
+		stmt, err := parser.ParseSQL(sql)
+		if err != nil {
+			t.Fatalf("parse sql error, sql: %s, err: %v", sql, err)
+		}
+		if _, err := BuildPreparedShardPlan(stmt, ns.phyDBs, "db_mycat", sql, ns.rt, ns.seqs); err == nil {
+			t.Errorf("prepared plan should not be built, sql: %s", sql)
+		}
+	}
+}
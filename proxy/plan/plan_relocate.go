@@ -0,0 +1,292 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	parser2 "github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// ShardKeyRelocatePlan is the plan for UPDATE which modifies the sharding column in relocate mode.
+// 修改分片列后行可能属于另一个分表, 不能直接UPDATE, 改为先SELECT ... FOR UPDATE锁定并读出原来的行,
+// 从原分表删除后按新的值重新路由插入, 需要在分布式事务中执行才能保证原子性.
+type ShardKeyRelocatePlan struct {
+	basePlan
+
+	db        string
+	table     string // restored table name in INSERT
+	comments  string // leading comments of the origin sql, such as route hint
+	router    *router.Router
+	sequences *sequence.SequenceManager
+
+	selectPlan Plan
+	deletePlan Plan
+	values     map[string]string // key = lower column name, value = restored literal of the assignment
+}
+
+// IsShardingColumnUpdate check if the UPDATE of single sharding table assigns the sharding column
+func IsShardingColumnUpdate(stmt *ast.UpdateStmt, db string, r *router.Router) bool {
+	tableName, _, ok := getRelocateTable(stmt)
+	if !ok {
+		return false
+	}
+	if tableName.Schema.L != "" {
+		db = tableName.Schema.L
+	}
+	rule, ok := r.GetShardRule(db, tableName.Name.L)
+	if !ok || rule.GetType() == router.GlobalTableRuleType {
+		return false
+	}
+	for _, assignment := range stmt.List {
+		if assignment.Column.Name.L == rule.GetShardingColumn() {
+			return true
+		}
+	}
+	return false
+}
+
+func getRelocateTable(stmt *ast.UpdateStmt) (*ast.TableName, *ast.TableSource, bool) {
+	if stmt.TableRefs == nil || stmt.TableRefs.TableRefs == nil || stmt.TableRefs.TableRefs.Right != nil {
+		return nil, nil, false
+	}
+	tableSource, ok := stmt.TableRefs.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, nil, false
+	}
+	tableName, ok := tableSource.Source.(*ast.TableName)
+	if !ok {
+		return nil, nil, false
+	}
+	return tableName, tableSource, true
+}
+
+// BuildShardKeyRelocatePlan build plan of UPDATE which modifies the sharding column.
+// Values of the assignments must be constants, since rows are inserted again by proxy,
+// and ORDER BY, LIMIT are not supported.
+func BuildShardKeyRelocatePlan(stmt *ast.UpdateStmt, phyDBs map[string]string, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*ShardKeyRelocatePlan, error) {
+	tableName, tableSource, ok := getRelocateTable(stmt)
+	if !ok {
+		return nil, fmt.Errorf("update sharding column of multiple tables is not supported")
+	}
+	if tableSource.AsName.L != "" {
+		return nil, fmt.Errorf("update sharding column with table alias is not supported")
+	}
+	if stmt.Order != nil || stmt.Limit != nil {
+		return nil, fmt.Errorf("update sharding column with ORDER BY or LIMIT is not supported")
+	}
+
+	p := &ShardKeyRelocatePlan{
+		db:        db,
+		router:    r,
+		sequences: seq,
+		values:    make(map[string]string, len(stmt.List)),
+	}
+	for _, assignment := range stmt.List {
+		if !isRelocateConstant(assignment.Expr) {
+			return nil, fmt.Errorf("value of column %s must be constant when updating sharding column", assignment.Column.Name.O)
+		}
+		if _, ok := p.values[assignment.Column.Name.L]; ok {
+			return nil, fmt.Errorf("column %s is assigned more than once", assignment.Column.Name.O)
+		}
+		v, err := restoreRelocateNode(assignment.Expr)
+		if err != nil {
+			return nil, err
+		}
+		p.values[assignment.Column.Name.L] = v
+	}
+
+	table, err := restoreRelocateNode(tableName)
+	if err != nil {
+		return nil, err
+	}
+	p.table = table
+
+	where := ""
+	if stmt.Where != nil {
+		w, err := restoreRelocateNode(stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		where = " WHERE " + w
+	}
+
+	// 保留路由hint等注释, 查询和删除的行与原UPDATE一致
+	_, comments := parser2.SplitMarginComments(sql)
+	p.comments = comments.Leading
+
+	selectSQL := p.comments + "SELECT * FROM " + table + where + " FOR UPDATE"
+	if p.selectPlan, err = buildRelocateSubPlan(selectSQL, phyDBs, db, r, seq); err != nil {
+		return nil, err
+	}
+	deleteSQL := p.comments + "DELETE FROM " + table + where
+	if p.deletePlan, err = buildRelocateSubPlan(deleteSQL, phyDBs, db, r, seq); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// isRelocateConstant check if the expression is a constant, signed numbers are parsed as unary operations
+func isRelocateConstant(expr ast.ExprNode) bool {
+	switch x := expr.(type) {
+	case *driver.ValueExpr:
+		return true
+	case *ast.UnaryOperationExpr:
+		return (x.Op == opcode.Minus || x.Op == opcode.Plus) && isRelocateConstant(x.V)
+	case *ast.ParenthesesExpr:
+		return isRelocateConstant(x.Expr)
+	}
+	return false
+}
+
+func restoreRelocateNode(node ast.Node) (string, error) {
+	s := &strings.Builder{}
+	if err := node.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, s)); err != nil {
+		return "", fmt.Errorf("restore sql error: %v", err)
+	}
+	return s.String(), nil
+}
+
+func buildRelocateSubPlan(sql string, phyDBs map[string]string, db string, r *router.Router, seq *sequence.SequenceManager) (Plan, error) {
+	stmt, err := parser2.ParseOneStmt(parser.New(), sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse sql error: %v, sql: %s", err, sql)
+	}
+	p, err := BuildPlan(stmt, phyDBs, db, sql, r, seq)
+	if err != nil {
+		return nil, fmt.Errorf("build plan error: %v, sql: %s", err, sql)
+	}
+	return p, nil
+}
+
+// ExecuteIn implement Plan
+func (p *ShardKeyRelocatePlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	rows, err := p.selectPlan.ExecuteIn(reqCtx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("select rows to relocate error: %v", err)
+	}
+	if rows == nil || rows.Resultset == nil || len(rows.Values) == 0 {
+		return &mysql.Result{}, nil
+	}
+
+	dr, err := p.deletePlan.ExecuteIn(reqCtx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("delete rows to relocate error: %v", err)
+	}
+	// 行已经被SELECT ... FOR UPDATE锁定, 删除的行数不一致说明路由结果不同, 不能继续插入
+	if dr == nil || dr.AffectedRows != uint64(len(rows.Values)) {
+		return nil, fmt.Errorf("rows deleted in relocation doesn't match rows selected: %d", len(rows.Values))
+	}
+
+	insertSQL, err := p.generateInsertSQL(rows)
+	if err != nil {
+		return nil, err
+	}
+	insertPlan, err := buildRelocateSubPlan(insertSQL, nil, p.db, p.router, p.sequences)
+	if err != nil {
+		return nil, err
+	}
+	// 重新插入的行不是新增的行, 不能修改LAST_INSERT_ID()
+	lastInsertID := sess.GetLastInsertID()
+	_, err = insertPlan.ExecuteIn(reqCtx, sess)
+	sess.SetLastInsertID(lastInsertID)
+	if err != nil {
+		return nil, fmt.Errorf("insert relocated rows error: %v", err)
+	}
+
+	return &mysql.Result{AffectedRows: uint64(len(rows.Values))}, nil
+}
+
+// generateInsertSQL generate INSERT of the selected rows, assigned columns are replaced with new values
+func (p *ShardKeyRelocatePlan) generateInsertSQL(rows *mysql.Result) (string, error) {
+	columns := make([]string, 0, len(rows.Fields))
+	for _, f := range rows.Fields {
+		columns = append(columns, "`"+strings.Replace(string(f.Name), "`", "``", -1)+"`")
+	}
+
+	values := make([]string, 0, len(rows.Values))
+	for i := range rows.Values {
+		row := make([]string, 0, len(rows.Fields))
+		for j, f := range rows.Fields {
+			if v, ok := p.values[strings.ToLower(string(f.Name))]; ok {
+				row = append(row, v)
+				continue
+			}
+			v, err := getRelocateValue(rows, i, j)
+			if err != nil {
+				return "", err
+			}
+			row = append(row, v)
+		}
+		values = append(values, "("+strings.Join(row, ",")+")")
+	}
+
+	return p.comments + "INSERT INTO " + p.table + " (" + strings.Join(columns, ",") + ") VALUES " + strings.Join(values, ","), nil
+}
+
+var relocateStringEscaper = strings.NewReplacer("\\", "\\\\", "'", "\\'", "\x00", "\\0")
+
+// getRelocateValue return literal of the selected value, use the text of the row if it's available,
+// so that DECIMAL is not converted to float. Binary strings are written in hexadecimal,
+// other values are quoted, which are converted to column types by MySQL.
+func getRelocateValue(rows *mysql.Result, row, column int) (string, error) {
+	var raw []byte
+	if len(rows.RowDatas) == len(rows.Values) {
+		v, isNull, err := rows.RowDatas[row].TextColumn(column)
+		if err != nil {
+			return "", err
+		}
+		if isNull {
+			return "NULL", nil
+		}
+		raw = v
+	} else {
+		v := rows.Values[row][column]
+		if v == nil {
+			return "NULL", nil
+		}
+		if b, ok := v.([]byte); ok {
+			raw = b
+		} else {
+			_, s := util.ItoString(v)
+			raw = []byte(s)
+		}
+	}
+
+	f := rows.Fields[column]
+	switch f.Type {
+	case mysql.TypeVarchar, mysql.TypeVarString, mysql.TypeString, mysql.TypeTinyBlob, mysql.TypeBlob,
+		mysql.TypeMediumBlob, mysql.TypeLongBlob, mysql.TypeBit, mysql.TypeGeometry:
+		if mysql.CollationID(f.Charset) == mysql.BinaryCollationID {
+			if len(raw) == 0 {
+				return "''", nil
+			}
+			return "X'" + hex.EncodeToString(raw) + "'", nil
+		}
+	}
+	return "'" + relocateStringEscaper.Replace(string(raw)) + "'", nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+func buildTestRelocatePlan(t *testing.T, info *PlanInfo, sql string) (*ShardKeyRelocatePlan, error) {
+	n, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	stmt := n.(*ast.UpdateStmt)
+	if !IsShardingColumnUpdate(stmt, "db_mycat", info.rt) {
+		t.Fatalf("%s should update sharding column", sql)
+	}
+	return BuildShardKeyRelocatePlan(stmt, nil, "db_mycat", sql, info.rt, info.seqs)
+}
+
+func TestIsShardingColumnUpdate(t *testing.T) {
+	info, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare plan info error: %v", err)
+	}
+	tests := []struct {
+		sql    string
+		expect bool
+	}{
+		{"update tbl_mycat set id = 5 where id = 1", true},
+		{"update db_mycat.tbl_mycat set tbl_mycat.ID = 5", true},
+		{"update tbl_mycat set user = 'a' where id = 1", false},
+		{"update tbl_mycat_global_one set id = 5", false},
+		{"update tbl_unshard set id = 5", false},
+	}
+	for _, test := range tests {
+		n, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		if ret := IsShardingColumnUpdate(n.(*ast.UpdateStmt), "db_mycat", info.rt); ret != test.expect {
+			t.Errorf("%s: expect %v, got %v", test.sql, test.expect, ret)
+		}
+	}
+}
+
+func TestBuildShardKeyRelocatePlan(t *testing.T) {
+	info, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare plan info error: %v", err)
+	}
+
+	p, err := buildTestRelocatePlan(t, info, "/*+ route_to(db_mycat_1) */ update tbl_mycat set id = -6, user = 'a' where id = 1")
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+	expectSelect := map[string]map[string][]string{
+		"slice-0": {"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `id`=1 FOR UPDATE"}},
+	}
+	if sqls := p.selectPlan.(*SelectPlan).sqls; !reflect.DeepEqual(sqls, expectSelect) {
+		t.Errorf("select sqls not match, expect: %v, got: %v", expectSelect, sqls)
+	}
+	expectDelete := map[string]map[string][]string{
+		"slice-0": {"db_mycat_1": {"DELETE FROM `tbl_mycat` WHERE `id`=1"}},
+	}
+	if sqls := p.deletePlan.(*DeletePlan).sqls; !reflect.DeepEqual(sqls, expectDelete) {
+		t.Errorf("delete sqls not match, expect: %v, got: %v", expectDelete, sqls)
+	}
+	if !reflect.DeepEqual(p.values, map[string]string{"id": "-6", "user": "'a'"}) {
+		t.Errorf("assignments not match: %v", p.values)
+	}
+
+	for _, sql := range []string{
+		"update tbl_mycat set id = id + 1 where id = 1",
+		"update tbl_mycat set id = 5, user = concat(user, 'a') where id = 1",
+		"update tbl_mycat set id = 5 where id = 1 order by id limit 1",
+		"update tbl_mycat t set t.id = 5 where t.id = 1",
+	} {
+		if _, err := buildTestRelocatePlan(t, info, sql); err == nil {
+			t.Errorf("build plan of %s should fail", sql)
+		}
+	}
+}
+
+type relocateExecutor struct {
+	Executor
+	rows         *mysql.Result
+	affectedRows uint64
+	sqls         []string
+	lastInsertID uint64
+}
+
+func (e *relocateExecutor) ExecuteSQLs(ctx *util.RequestContext, sqls map[string]map[string][]string) ([]*mysql.Result, error) {
+	var rs []*mysql.Result
+	for _, dbSQLs := range sqls {
+		for _, tableSQLs := range dbSQLs {
+			for _, sql := range tableSQLs {
+				e.sqls = append(e.sqls, sql)
+				switch {
+				case strings.HasPrefix(sql, "SELECT"):
+					rs = append(rs, e.rows)
+				case strings.HasPrefix(sql, "DELETE"):
+					rs = append(rs, &mysql.Result{AffectedRows: e.affectedRows})
+				default:
+					rs = append(rs, &mysql.Result{AffectedRows: 1, InsertID: 6})
+				}
+			}
+		}
+	}
+	return rs, nil
+}
+
+func (e *relocateExecutor) SetLastInsertID(id uint64) { e.lastInsertID = id }
+
+func (e *relocateExecutor) GetLastInsertID() uint64 { return e.lastInsertID }
+
+func TestShardKeyRelocatePlanExecute(t *testing.T) {
+	info, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare plan info error: %v", err)
+	}
+	p, err := buildTestRelocatePlan(t, info, "update tbl_mycat set id = 6 where id = 1")
+	if err != nil {
+		t.Fatalf("build plan error: %v", err)
+	}
+
+	rs, err := mysql.BuildResultset(nil, []string{"id", "user"}, [][]interface{}{{int64(1), "x'y"}})
+	if err != nil {
+		t.Fatalf("build resultset error: %v", err)
+	}
+	se := &relocateExecutor{rows: &mysql.Result{Resultset: rs}, affectedRows: 1, lastInsertID: 3}
+	r, err := p.ExecuteIn(util.NewRequestContext(), se)
+	if err != nil {
+		t.Fatalf("execute error: %v", err)
+	}
+	expect := []string{
+		"SELECT * FROM `tbl_mycat` WHERE `id`=1 FOR UPDATE",
+		"DELETE FROM `tbl_mycat` WHERE `id`=1",
+		"INSERT INTO `tbl_mycat` (`id`,`user`) VALUES (6,'x''y')",
+	}
+	if !reflect.DeepEqual(se.sqls, expect) {
+		t.Errorf("executed sqls not match, expect: %v, got: %v", expect, se.sqls)
+	}
+	if r.AffectedRows != 1 || se.lastInsertID != 3 {
+		t.Errorf("affected rows: %d, last insert id: %d", r.AffectedRows, se.lastInsertID)
+	}
+
+	// rows deleted from old shards don't match rows selected, the insert is not executed
+	se = &relocateExecutor{rows: &mysql.Result{Resultset: rs}}
+	if _, err := p.ExecuteIn(util.NewRequestContext(), se); err == nil || len(se.sqls) != 2 {
+		t.Errorf("relocation should fail, err: %v, sqls: %v", err, se.sqls)
+	}
+}
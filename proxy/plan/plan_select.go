@@ -16,10 +16,14 @@ package plan
 
 import (
 	"fmt"
+	"strings"
+
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/opcode"
 
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
 	"github.com/XiaoMi/Gaea/proxy/router"
 	"github.com/XiaoMi/Gaea/util"
 	driver "github.com/pingcap/tidb/types/parser_driver"
@@ -44,6 +48,11 @@ type SelectPlan struct {
 	offset int64 // LIMIT offset
 	count  int64 // LIMIT count, 未设置则为-1
 
+	shardingSubquery bool   // WHERE中存在下推到分片执行的分片表子查询
+	windowFunc       string // 语句中的窗口函数名, 窗口函数在分片中计算, 不能合并
+
+	having *havingFilter // 在proxy中计算的HAVING条件, 为nil时HAVING下推到分片
+
 	sqls map[string]map[string][]string
 }
 
@@ -78,7 +87,8 @@ func (s *SelectPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mys
 		return nil, fmt.Errorf("execute in SelectPlan error: %v", err)
 	}
 
-	r, err := MergeSelectResult(s, s.stmt, rs)
+	tracker, _ := reqCtx.Get(util.QueryMemoryTracker).(*util.MemoryTracker)
+	r, err := MergeSelectResult(s, s.stmt, rs, tracker)
 	if err != nil {
 		return nil, fmt.Errorf("merge select result error: %v", err)
 	}
@@ -140,6 +150,23 @@ func (s *SelectPlan) GetOrderByColumnInfo() ([]int, []bool) {
 	return s.orderByColumn, s.orderByDirections
 }
 
+// IsStreamable check if rows of shards can be returned to client as they arrive,
+// rows of shards can not be streamed if they need to be aggregated or deduplicated.
+func (s *SelectPlan) IsStreamable() bool {
+	return !s.distinct && s.stmt.GroupBy == nil && len(s.aggregateFuncs) == 0
+}
+
+// GetOrderBySortKeys get sort keys of order by columns in result fields
+func (s *SelectPlan) GetOrderBySortKeys(resultFieldLength int) []mysql.SortKey {
+	return getOrderBySortKeys(s, resultFieldLength)
+}
+
+// GetExtraFieldStartIndex get index of the first extra column in result fields,
+// the extra columns added by group by and order by are trimmed before returned to client.
+func (s *SelectPlan) GetExtraFieldStartIndex(resultFieldLength int) int {
+	return resultFieldLength - s.GetColumnCount() + s.GetOriginColumnCount()
+}
+
 // GetSQLs get generated SQLs
 // the first key is slice, the second key is backend database name, the value is parser list.
 func (s *SelectPlan) GetSQLs() map[string]map[string][]string {
@@ -153,6 +180,12 @@ func HandleSelectStmt(p *SelectPlan, stmt *ast.SelectStmt) error {
 
 	p.distinct = stmt.Distinct
 
+	// 在改写语法树之前查找窗口函数, 改写后的装饰器不再遍历子节点
+	p.windowFunc = findWindowFunc(stmt)
+
+	// *的展开必须在table处理之前, 需要使用原始的表名
+	expandWildcardFields(p, stmt)
+
 	if err := handleTableRefs(p, stmt); err != nil {
 		return fmt.Errorf("handle From error: %v", err)
 	}
@@ -167,6 +200,10 @@ func HandleSelectStmt(p *SelectPlan, stmt *ast.SelectStmt) error {
 		p.originColumnCount = len(stmt.Fields.Fields)
 	}
 
+	if err := handleWindowSpecs(p, stmt); err != nil {
+		return fmt.Errorf("handle Window error: %v", err)
+	}
+
 	// group by的处理必须在table处理之后
 	if err := handleGroupBy(p, stmt); err != nil {
 		return fmt.Errorf("handle GroupBy error: %v", err)
@@ -180,11 +217,20 @@ func HandleSelectStmt(p *SelectPlan, stmt *ast.SelectStmt) error {
 
 	handleExtraFieldList(p, stmt)
 
-	// 记录补列后的Fields长度, 后面的handler不会补列了
+	// AVG()补充的COUNT()列放在最后, 不影响group by和order by补列的位置
+	if err := handleAggregateFuncAvg(p, stmt); err != nil {
+		return fmt.Errorf("handle AVG error: %v", err)
+	}
+
+	// 记录补列后的Fields长度, 后面只有在proxy中计算HAVING时会补列, 并更新这个长度
 	if stmt.Fields != nil {
 		p.columnCount = len(stmt.Fields.Fields)
 	}
 
+	if err := handleWhereSubqueries(p, stmt); err != nil {
+		return fmt.Errorf("handle subquery in Where error: %v", err)
+	}
+
 	if err := handleWhere(p, stmt); err != nil {
 		return fmt.Errorf("handle Where error: %v", err)
 	}
@@ -205,6 +251,19 @@ func HandleSelectStmt(p *SelectPlan, stmt *ast.SelectStmt) error {
 		return fmt.Errorf("handle Hint error: %v", err)
 	}
 
+	if err := postHandleRouteHint(p.StmtInfo); err != nil {
+		return fmt.Errorf("handle route hint error: %v", err)
+	}
+
+	if p.shardingSubquery && len(p.result.indexes) > 1 {
+		return fmt.Errorf("subquery of sharding table must be routed to one shard with the outer query, route result: %v", p.result.indexes)
+	}
+
+	// 各分片只能计算本分片数据上的窗口, 合并后的结果是错误的, 因此窗口函数只能路由到一个分片
+	if p.windowFunc != "" && len(p.result.indexes) > 1 {
+		return fmt.Errorf("window function %s must be routed to one shard, cross-shard window is not supported, route result: %v", p.windowFunc, p.result.indexes)
+	}
+
 	sqls, err := generateShardingSQLs(p.stmt, p.result, p.router)
 	if err != nil {
 		return fmt.Errorf("generate select SQL error: %v", err)
@@ -216,104 +275,126 @@ func HandleSelectStmt(p *SelectPlan, stmt *ast.SelectStmt) error {
 }
 
 // 处理GroupBy, 把GroupBy的列补到FieldList中, 然后把GroupBy去掉
+// GROUP BY位置序号直接使用对应的列, 不需要补列
 func handleGroupBy(p *SelectPlan, stmt *ast.SelectStmt) error {
 	if stmt.GroupBy == nil {
 		return nil
 	}
 
-	groupByFields, err := createSelectFieldsFromByItems(p, stmt.GroupBy.Items)
+	columns, err := appendSelectFieldsFromByItems(p, stmt, stmt.GroupBy.Items)
 	if err != nil {
 		return fmt.Errorf("get group by fields error: %v", err)
 	}
-
-	for i := 0; i < len(groupByFields); i++ {
-		p.groupByColumn = append(p.groupByColumn, i+len(stmt.Fields.Fields))
-	}
-
-	// append group by fields
-	stmt.Fields.Fields = append(stmt.Fields.Fields, groupByFields...)
-
+	p.groupByColumn = append(p.groupByColumn, columns...)
 	return nil
 }
 
+// ORDER BY位置序号直接使用对应的列, 不需要补列
 func handleOrderBy(p *SelectPlan, stmt *ast.SelectStmt) error {
 	if stmt.OrderBy == nil {
 		return nil
 	}
 
-	orderByFields, err := createSelectFieldsFromByItems(p, stmt.OrderBy.Items)
+	columns, err := appendSelectFieldsFromByItems(p, stmt, stmt.OrderBy.Items)
 	if err != nil {
 		return fmt.Errorf("get order by fields error: %v", err)
 	}
-
-	for i := 0; i < len(orderByFields); i++ {
-		p.orderByColumn = append(p.orderByColumn, i+len(stmt.Fields.Fields))
-	}
+	p.orderByColumn = append(p.orderByColumn, columns...)
 
 	for _, f := range stmt.OrderBy.Items {
 		p.orderByDirections = append(p.orderByDirections, f.Desc)
 	}
-
-	stmt.Fields.Fields = append(stmt.Fields.Fields, orderByFields...)
 	return nil
 }
 
+// 补充的列如果与原有的列相同, 去掉补充的列, 使用原有的列. 单表时只按列名匹配, 多表时还需要匹配表名
 func handleExtraFieldList(p *SelectPlan, stmt *ast.SelectStmt) {
+	single := stmt.From != nil && stmt.From.TableRefs != nil && stmt.From.TableRefs.Right == nil
 	selectFields := make(map[string]int)
 	for i := 0; i < p.originColumnCount; i++ {
 		field := stmt.Fields.Fields[i]
 		if field.AsName.L != "" {
-			selectFields[field.AsName.L] = i
+			selectFields[extraFieldKey(single, "", field.AsName.L)] = i
 		}
 		if field, isColumnExpr := stmt.Fields.Fields[i].Expr.(*ast.ColumnNameExpr); isColumnExpr {
-			selectFields[field.Name.Name.L] = i
+			selectFields[extraFieldKey(single, field.Name.Table.L, field.Name.Name.L)] = i
 		}
 	}
 
-	deleteNum := 0
-	for i := 0; i < len(p.groupByColumn); i++ {
-		p.groupByColumn[i] -= deleteNum
-		currColumnIndex := p.originColumnCount + i - deleteNum
-		field, isColumnExpr := stmt.Fields.Fields[currColumnIndex].Expr.(*ast.ColumnNameExpr)
-		if !isColumnExpr {
-			continue
+	// 补充的列依次对应groupByColumn, orderByColumn中不小于originColumnCount的列
+	var columns []*int
+	for i := range p.groupByColumn {
+		if p.groupByColumn[i] >= p.originColumnCount {
+			columns = append(columns, &p.groupByColumn[i])
 		}
-		if index, ok := selectFields[field.Name.Name.L]; !ok {
-			continue
-		} else {
-			stmt.Fields.Fields = append(stmt.Fields.Fields[:currColumnIndex], stmt.Fields.Fields[currColumnIndex+1:]...)
-			p.groupByColumn[i] = index
-			deleteNum++
+	}
+	for i := range p.orderByColumn {
+		if p.orderByColumn[i] >= p.originColumnCount {
+			columns = append(columns, &p.orderByColumn[i])
 		}
 	}
 
-	for i := 0; i < len(p.orderByColumn); i++ {
-		p.orderByColumn[i] -= deleteNum
-		currColumnIndex := p.originColumnCount + len(p.groupByColumn) + i - deleteNum
+	deleteNum := 0
+	for _, column := range columns {
+		*column -= deleteNum
+		currColumnIndex := *column
 		field, isColumnExpr := stmt.Fields.Fields[currColumnIndex].Expr.(*ast.ColumnNameExpr)
 		if !isColumnExpr {
 			continue
 		}
-		if index, ok := selectFields[field.Name.Name.L]; !ok {
+		if index, ok := selectFields[extraFieldKey(single, field.Name.Table.L, field.Name.Name.L)]; !ok {
 			continue
 		} else {
 			stmt.Fields.Fields = append(stmt.Fields.Fields[:currColumnIndex], stmt.Fields.Fields[currColumnIndex+1:]...)
-			p.orderByColumn[i] = index
+			*column = index
 			deleteNum++
 		}
 	}
 }
 
-func createSelectFieldsFromByItems(p *SelectPlan, items []*ast.ByItem) ([]*ast.SelectField, error) {
-	var ret []*ast.SelectField
+func extraFieldKey(single bool, table, column string) string {
+	if single {
+		return column
+	}
+	return table + "." + column
+}
+
+// appendSelectFieldsFromByItems 把GROUP BY, ORDER BY的列补到FieldList中, 返回每一项在FieldList中的下标
+func appendSelectFieldsFromByItems(p *SelectPlan, stmt *ast.SelectStmt, items []*ast.ByItem) ([]int, error) {
+	var columns []int
 	for _, item := range items {
+		if position, ok := item.Expr.(*ast.PositionExpr); ok {
+			index, err := getPositionColumnIndex(p, stmt, position)
+			if err != nil {
+				return nil, err
+			}
+			columns = append(columns, index)
+			continue
+		}
 		selectField, err := createSelectFieldFromByItem(p, item)
 		if err != nil {
 			return nil, err
 		}
-		ret = append(ret, selectField)
+		columns = append(columns, len(stmt.Fields.Fields))
+		stmt.Fields.Fields = append(stmt.Fields.Fields, selectField)
 	}
-	return ret, nil
+	return columns, nil
+}
+
+// 位置序号从1开始, 对应原有的列, 原有的列中有未展开的*时无法确定对应的列
+func getPositionColumnIndex(p *SelectPlan, stmt *ast.SelectStmt, position *ast.PositionExpr) (int, error) {
+	if position.P != nil {
+		return 0, fmt.Errorf("position of parameter is not supported")
+	}
+	if position.N < 1 || position.N > p.originColumnCount {
+		return 0, fmt.Errorf("unknown column '%d'", position.N)
+	}
+	for i := 0; i < position.N; i++ {
+		if stmt.Fields.Fields[i].WildCard != nil {
+			return 0, fmt.Errorf("position %d after * is not supported, schema of table is not loaded", position.N)
+		}
+	}
+	return position.N - 1, nil
 }
 
 func createSelectFieldFromByItem(p *SelectPlan, item *ast.ByItem) (*ast.SelectField, error) {
@@ -365,6 +446,84 @@ func handleTableRefs(p *SelectPlan, stmt *ast.SelectStmt) error {
 	return handleJoin(p.TableAliasStmtInfo, join)
 }
 
+// 如果FROM中的表已经加载了表结构, 把*展开为表的所有列, 使结果集的列在proxy中是确定的,
+// GROUP BY, ORDER BY使用的列可以直接对应到展开后的列, 而不需要补列. 多表时展开的列带上表名或别名.
+// 表结构未加载, FROM中有子查询或*的表名与FROM中的表不一致时, 保持原样
+func expandWildcardFields(p *SelectPlan, stmt *ast.SelectStmt) {
+	if stmt.Fields == nil || stmt.From == nil || stmt.From.TableRefs == nil {
+		return
+	}
+	sources, ok := collectTableSources(stmt.From.TableRefs, nil)
+	if !ok {
+		return
+	}
+	single := len(sources) == 1
+
+	var fields []*ast.SelectField
+	for _, f := range stmt.Fields.Fields {
+		if f.WildCard == nil {
+			fields = append(fields, f)
+			continue
+		}
+		matched := false
+		for _, tableSource := range sources {
+			tableName := tableSource.Source.(*ast.TableName)
+			db := tableName.Schema.O
+			if db == "" {
+				db = p.db
+			}
+			if f.WildCard.Schema.L != "" && f.WildCard.Schema.L != strings.ToLower(db) {
+				continue
+			}
+			if f.WildCard.Table.L != "" && f.WildCard.Table.L != tableName.Name.L && f.WildCard.Table.L != tableSource.AsName.L {
+				continue
+			}
+			table, ok := p.router.GetSchema().GetTable(db, tableName.Name.L)
+			if !ok || len(table.Columns) == 0 {
+				return
+			}
+			qualifier := tableSource.AsName
+			if qualifier.L == "" {
+				qualifier = tableName.Name
+			}
+			for _, c := range table.Columns {
+				name := &ast.ColumnName{Name: model.NewCIStr(c.Name)}
+				if !single {
+					name.Table = qualifier
+				}
+				fields = append(fields, &ast.SelectField{Expr: &ast.ColumnNameExpr{Name: name}})
+			}
+			matched = true
+		}
+		if !matched {
+			return
+		}
+	}
+	stmt.Fields.Fields = fields
+}
+
+// collectTableSources 按FROM中的顺序返回所有的表, 如果有子查询返回false
+func collectTableSources(node ast.ResultSetNode, sources []*ast.TableSource) ([]*ast.TableSource, bool) {
+	switch n := node.(type) {
+	case *ast.Join:
+		sources, ok := collectTableSources(n.Left, sources)
+		if !ok {
+			return nil, false
+		}
+		if n.Right == nil {
+			return sources, true
+		}
+		return collectTableSources(n.Right, sources)
+	case *ast.TableSource:
+		if _, ok := n.Source.(*ast.TableName); !ok {
+			return nil, false
+		}
+		return append(sources, n), true
+	default:
+		return nil, false
+	}
+}
+
 func handleJoin(p *TableAliasStmtInfo, join *ast.Join) error {
 	if err := precheckJoinClause(join); err != nil {
 		return fmt.Errorf("precheck Join error: %v", err)
@@ -584,6 +743,10 @@ func handleFieldList(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 	for i, f := range fields.Fields {
 		switch field := f.Expr.(type) {
 		case *ast.AggregateFuncExpr:
+			// AVG()需要在补列之后处理, 见handleAggregateFuncAvg()
+			if strings.ToLower(field.F) == ast.AggFuncAvg {
+				continue
+			}
 			merger, err := CreateAggregateFunctionMerger(field.F, i)
 			if err != nil {
 				return fmt.Errorf("create aggregate function merger error, column index: %d, err: %v", i, err)
@@ -598,6 +761,109 @@ func handleFieldList(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 	return nil
 }
 
+// 改写WINDOW子句中命名窗口的列名, 窗口函数中的窗口在处理Fields时已经改写
+func handleWindowSpecs(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("handleWindowSpecs panic: %v", e)
+		}
+	}()
+
+	columnNameRewriter := NewColumnNameRewriteVisitor(p.TableAliasStmtInfo)
+	for i := range stmt.WindowSpecs {
+		stmt.WindowSpecs[i].Accept(columnNameRewriter)
+	}
+	return nil
+}
+
+// findWindowFunc return name of the first window function in statement.
+// WHERE中的子查询不检查, 下推的子查询已经要求与外层查询路由到一个分片, 其他子查询单独生成执行计划.
+func findWindowFunc(stmt *ast.SelectStmt) string {
+	finder := &windowFuncFinder{}
+	stmt.Accept(finder)
+	return finder.name
+}
+
+// windowFuncFinder find the first window function in statement, subqueries in expressions are skipped
+type windowFuncFinder struct {
+	name string
+}
+
+// Enter implement ast.Visitor
+func (f *windowFuncFinder) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	switch x := n.(type) {
+	case *ast.WindowFuncExpr:
+		if f.name == "" {
+			f.name = strings.ToUpper(x.F) + "()"
+		}
+		return n, true
+	case *ast.SubqueryExpr:
+		return n, true
+	}
+	return n, f.name != ""
+}
+
+// Leave implement ast.Visitor
+func (f *windowFuncFinder) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// 把AVG(x)改写为SUM(x), 并在FieldList最后补充COUNT(x)列, 合并结果时用SUM/COUNT计算平均值
+// 改写后的SUM(x)使用原始列名作为别名, 保证返回给客户端的列名不变
+func handleAggregateFuncAvg(p *SelectPlan, stmt *ast.SelectStmt) error {
+	if stmt.Fields == nil {
+		return nil
+	}
+
+	for i := 0; i < p.originColumnCount; i++ {
+		field, ok := stmt.Fields.Fields[i].Expr.(*ast.AggregateFuncExpr)
+		if !ok || strings.ToLower(field.F) != ast.AggFuncAvg {
+			continue
+		}
+		if err := rewriteAggregateFuncAvg(p, stmt, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rewriteAggregateFuncAvg(p *SelectPlan, stmt *ast.SelectStmt, i int) error {
+	f := stmt.Fields.Fields[i]
+	field := f.Expr.(*ast.AggregateFuncExpr)
+	if field.Distinct {
+		return fmt.Errorf("AVG(DISTINCT) is not supported, column index: %d", i)
+	}
+
+	if f.AsName.L == "" {
+		name := f.Text()
+		if name == "" {
+			var err error
+			if name, err = parser.NodeToStringWithoutQuote(field); err != nil {
+				return fmt.Errorf("get name of AVG() column error, column index: %d, err: %v", i, err)
+			}
+		}
+		f.AsName = model.NewCIStr(name)
+	}
+	field.F = ast.AggFuncSum
+
+	countField := &ast.SelectField{
+		Expr: &ast.AggregateFuncExpr{
+			F:    ast.AggFuncCount,
+			Args: field.Args,
+		},
+	}
+	countIndex := len(stmt.Fields.Fields)
+	stmt.Fields.Fields = append(stmt.Fields.Fields, countField)
+
+	if err := p.setAggregateFuncMerger(i, CreateAggregateFuncAvgMerger(i, countIndex)); err != nil {
+		return fmt.Errorf("set aggregate function merger error, column index: %d, err: %v", i, err)
+	}
+	return nil
+}
+
+// 处理HAVING子句
+// 路由到多个分片的聚合查询, 各分片只有部分数据的聚合结果, HAVING不能下推, 去掉HAVING并在合并聚合结果后由proxy计算;
+// 其他情况HAVING原样下推, 只改写其中的表名.
 func handleHaving(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -610,6 +876,14 @@ func handleHaving(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 		return nil
 	}
 
+	if r := p.GetRouteResult(); r != nil && len(r.GetShardIndexes()) > 1 {
+		collector := &havingRefCollector{}
+		having.Expr.Accept(collector)
+		if stmt.GroupBy != nil || len(p.aggregateFuncs) != 0 || collector.hasAggregateFunc {
+			return handleHavingInProxy(p, stmt, collector.refs)
+		}
+	}
+
 	// 先用一个Visitor生成一个替换表名的装饰器
 	// 这里如果出错, 只能通过panic返回err
 	columnNameRewriter := NewColumnNameRewriteVisitor(p.TableAliasStmtInfo)
@@ -617,6 +891,165 @@ func handleHaving(p *SelectPlan, stmt *ast.SelectStmt) (err error) {
 	return nil
 }
 
+// HAVING中的聚合函数和列使用FieldList中相同的列, 没有相同的列则补列, 补充的列与其他补充的列一样在返回结果前去掉
+func handleHavingInProxy(p *SelectPlan, stmt *ast.SelectStmt, refs []ast.ExprNode) error {
+	columnNameRewriter := NewColumnNameRewriteVisitor(p.TableAliasStmtInfo)
+	columns := make(map[ast.ExprNode]int)
+	for _, ref := range refs {
+		idx := findHavingRefColumn(p, stmt, ref)
+		if idx == -1 {
+			var err error
+			if idx, err = appendHavingRefField(p, stmt, ref, columnNameRewriter); err != nil {
+				return err
+			}
+		}
+		columns[ref] = idx
+	}
+
+	filter, err := newHavingFilter(stmt.Having.Expr, columns)
+	if err != nil {
+		return err
+	}
+	p.having = filter
+	p.columnCount = len(stmt.Fields.Fields)
+	stmt.Having = nil
+	return nil
+}
+
+// 查找HAVING中的聚合函数或列在FieldList中对应的列, 没有则返回-1
+// 聚合函数只匹配有合并函数的列, 不带表名的列先匹配别名, 与MySQL一致
+func findHavingRefColumn(p *SelectPlan, stmt *ast.SelectStmt, ref ast.ExprNode) int {
+	switch x := ref.(type) {
+	case *ast.AggregateFuncExpr:
+		key, ok := aggregateFuncKey(x, strings.ToLower(x.F))
+		if !ok {
+			return -1
+		}
+		for i, f := range stmt.Fields.Fields {
+			merger, ok := p.aggregateFuncs[i]
+			if !ok {
+				continue
+			}
+			field, ok := f.Expr.(*ast.AggregateFuncExpr)
+			if !ok {
+				continue
+			}
+			name := strings.ToLower(field.F)
+			if _, isAvg := merger.(*AggregateFuncAvgMerger); isAvg {
+				name = ast.AggFuncAvg
+			}
+			if k, ok := aggregateFuncKey(field, name); ok && k == key {
+				return i
+			}
+		}
+	case *ast.ColumnNameExpr:
+		if x.Name.Table.L == "" {
+			for i := 0; i < p.originColumnCount; i++ {
+				if stmt.Fields.Fields[i].AsName.L == x.Name.Name.L {
+					return i
+				}
+			}
+		}
+		for i, f := range stmt.Fields.Fields {
+			column := getSelectFieldColumnName(f.Expr)
+			if column != nil && column.Name.L == x.Name.Name.L && (x.Name.Table.L == "" || x.Name.Table.L == column.Table.L) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// 把HAVING中的聚合函数或列补到FieldList中, 并创建聚合函数的合并函数
+func appendHavingRefField(p *SelectPlan, stmt *ast.SelectStmt, ref ast.ExprNode, columnNameRewriter *ColumnNameRewriteVisitor) (int, error) {
+	field := &ast.SelectField{
+		Expr: ref,
+	}
+	idx := len(stmt.Fields.Fields)
+	stmt.Fields.Fields = append(stmt.Fields.Fields, field)
+	field.Accept(columnNameRewriter)
+
+	aggregateFunc, ok := ref.(*ast.AggregateFuncExpr)
+	if !ok {
+		return idx, nil
+	}
+	if strings.ToLower(aggregateFunc.F) == ast.AggFuncAvg {
+		return idx, rewriteAggregateFuncAvg(p, stmt, idx)
+	}
+	merger, err := CreateAggregateFunctionMerger(aggregateFunc.F, idx)
+	if err != nil {
+		return 0, fmt.Errorf("create aggregate function merger error, column index: %d, err: %v", idx, err)
+	}
+	if err := p.setAggregateFuncMerger(idx, merger); err != nil {
+		return 0, fmt.Errorf("set aggregate function merger error, column index: %d, err: %v", idx, err)
+	}
+	return idx, nil
+}
+
+// aggregateFuncKey 比较聚合函数是否相同, 参数只支持列和常量, 其他参数返回false
+func aggregateFuncKey(f *ast.AggregateFuncExpr, name string) (string, bool) {
+	var key strings.Builder
+	key.WriteString(name)
+	key.WriteString("(")
+	if f.Distinct {
+		key.WriteString("distinct ")
+	}
+	for i, arg := range f.Args {
+		if i > 0 {
+			key.WriteString(",")
+		}
+		if column := getSelectFieldColumnName(arg); column != nil {
+			key.WriteString(column.Table.L + "." + column.Name.L)
+			continue
+		}
+		v, ok := arg.(*driver.ValueExpr)
+		if !ok {
+			return "", false
+		}
+		key.WriteString(fmt.Sprintf("%d:%v", v.Kind(), v.GetValue()))
+	}
+	key.WriteString(")")
+	return key.String(), true
+}
+
+// 获取列表达式的原始列名, 不是列表达式返回nil
+func getSelectFieldColumnName(expr ast.ExprNode) *ast.ColumnName {
+	switch x := expr.(type) {
+	case *ast.ColumnNameExpr:
+		return x.Name
+	case *ColumnNameExprDecorator:
+		return x.ColumnNameExpr.Name
+	}
+	return nil
+}
+
+// havingRefCollector collect aggregate functions and columns out of aggregate functions in HAVING
+type havingRefCollector struct {
+	refs             []ast.ExprNode
+	hasAggregateFunc bool
+}
+
+// Enter implement ast.Visitor
+func (c *havingRefCollector) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	switch x := n.(type) {
+	case *ast.AggregateFuncExpr:
+		c.refs = append(c.refs, x)
+		c.hasAggregateFunc = true
+		return n, true
+	case *ast.ColumnNameExpr:
+		c.refs = append(c.refs, x)
+		return n, true
+	case *ast.SubqueryExpr:
+		return n, true
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (c *havingRefCollector) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
 func handleComparisonExpr(p *TableAliasStmtInfo, comp ast.ExprNode) (bool, []int, ast.ExprNode, error) {
 	switch expr := comp.(type) {
 	case *ast.BinaryOperationExpr:
@@ -638,6 +1071,22 @@ func handleComparisonExpr(p *TableAliasStmtInfo, comp ast.ExprNode) (bool, []int
 }
 
 func handlePatternInExpr(p *TableAliasStmtInfo, expr *ast.PatternInExpr) (bool, []int, ast.ExprNode, error) {
+	// IN子查询已经在handleWhereSubqueries中改写, 这里只装饰列名, 不计算路由
+	if expr.Sel != nil {
+		column, ok := expr.Expr.(*ast.ColumnNameExpr)
+		if !ok {
+			return false, nil, expr, nil
+		}
+		rule, need, isAlias, err := NeedCreateColumnNameExprDecoratorInCondition(p, column)
+		if err != nil {
+			return false, nil, nil, fmt.Errorf("check ColumnNameExpr error in PatternInExpr: %v", err)
+		}
+		if need {
+			expr.Expr = CreateColumnNameExprDecorator(column, rule, isAlias, p.GetRouteResult())
+		}
+		return false, nil, expr, nil
+	}
+
 	rule, need, isAlias, err := NeedCreatePatternInExprDecorator(p, expr)
 	if err != nil {
 		return false, nil, nil, fmt.Errorf("check PatternInExpr error: %v", err)
@@ -680,7 +1129,7 @@ func handleBinaryOperationExpr(p *TableAliasStmtInfo, expr *ast.BinaryOperationE
 	switch expr.Op {
 	case opcode.LogicAnd, opcode.LogicOr:
 		return handleBinaryOperationExprLogic(p, expr)
-	case opcode.EQ, opcode.NE, opcode.GT, opcode.GE, opcode.LT, opcode.LE:
+	case opcode.EQ, opcode.NE, opcode.GT, opcode.GE, opcode.LT, opcode.LE, opcode.NullEQ:
 		return handleBinaryOperationExprMathCompare(p, expr)
 	default:
 		return handleBinaryOperationExprOther(p, expr)
@@ -828,6 +1277,15 @@ func getDatabaseFuncHint(f *ast.FuncCallExpr, v ast.ExprNode) (string, error) {
 // 左边为列名, 右边为参数
 func getFindTableIndexesFunc(op opcode.Op) func(rule router.Rule, columnName string, v interface{}) ([]int, error) {
 	findTableIndexesFunc := func(rule router.Rule, columnName string, v interface{}) ([]int, error) {
+		// 三值逻辑: 与NULL比较的结果为UNKNOWN, 不匹配任何行, 不需要路由到任何分片;
+		// <=> NULL相当于IS NULL, 不能确定分片
+		if v == nil {
+			if op == opcode.NullEQ {
+				return rule.GetSubTableIndexes(), nil
+			}
+			return []int{}, nil
+		}
+
 		// 如果不是分表列, 则需要返回所有分片
 		if rule.GetShardingColumn() != columnName {
 			return rule.GetSubTableIndexes(), nil
@@ -835,7 +1293,7 @@ func getFindTableIndexesFunc(op opcode.Op) func(rule router.Rule, columnName str
 
 		// 如果是分表列, 还需要根据运算符判断
 		switch op {
-		case opcode.EQ:
+		case opcode.EQ, opcode.NullEQ:
 			index, err := rule.FindTableIndex(v)
 			if err != nil {
 				return nil, err
@@ -854,9 +1312,9 @@ func getFindTableIndexesFunc(op opcode.Op) func(rule router.Rule, columnName str
 					if op == opcode.LT {
 						index = adjustShardIndex(rangeShard, v, index)
 					}
-					return makeList(rule.GetFirstTableIndex(), index+1), nil
+					return makeRangeList(rule.GetFirstTableIndex(), index+1, rule.GetSubTableIndexes()), nil
 				} else {
-					return makeList(index, rule.GetLastTableIndex()+1), nil
+					return makeRangeList(index, rule.GetLastTableIndex()+1, rule.GetSubTableIndexes()), nil
 				}
 			}
 
@@ -1002,10 +1460,34 @@ func mergeBinaryOperationRouteResult(op opcode.Op, lHas bool, lResult []int, rHa
 	return false, nil
 }
 
+// 处理LIMIT子句
+// 只路由到一个分片时, LIMIT原样下推, proxy不需要再截取结果;
+// 路由到多个分片时, 向每个分片取 offset + count 行, 合并排序后再在proxy中截取;
+// 如果有GROUP BY, 分片只返回部分分组的聚合结果, 必须全部合并后才能截取, 因此不下推LIMIT.
 func handleLimit(p *SelectPlan, stmt *ast.SelectStmt) error {
+	if stmt.Limit == nil {
+		return nil
+	}
+
+	if r := p.GetRouteResult(); r != nil && len(r.GetShardIndexes()) == 1 {
+		return nil
+	}
+
+	// placeholders of prepared statement have no value to merge results of shards
+	if _, ok := stmt.Limit.Count.(*driver.ValueExpr); !ok {
+		return fmt.Errorf("limit count is not a constant")
+	}
+	if _, ok := stmt.Limit.Offset.(*driver.ValueExpr); stmt.Limit.Offset != nil && !ok {
+		return fmt.Errorf("limit offset is not a constant")
+	}
+
 	need, originOffset, originCount, newLimit := NeedRewriteLimitOrCreateRewrite(stmt)
 	p.offset = originOffset
 	p.count = originCount
+	if stmt.GroupBy != nil {
+		stmt.Limit = nil
+		return nil
+	}
 	if need {
 		stmt.Limit = newLimit
 	}
@@ -16,9 +16,16 @@ package plan
 
 import (
 	"fmt"
+	"github.com/pingcap/parser"
 	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"strings"
 
+	"github.com/XiaoMi/Gaea/mysql"
+	parser2 "github.com/XiaoMi/Gaea/parser"
 	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/util"
 )
 
 // SubqueryColumnNameRewriteVisitor visit ColumnNameExpr in subquery, check if need decorate, and then decorate it.
@@ -184,3 +191,356 @@ func rewriteSubqueryTableNameInTableSource(p *TableAliasStmtInfo, tableSource *a
 	tableSource.Source = d
 	return nil
 }
+
+// 子查询在WHERE中的类型
+const (
+	scalarSubquery = iota // 标量子查询, 如 id = (SELECT ...)
+	inSubquery            // IN (SELECT ...)
+	otherSubquery         // EXISTS, ANY, ALL等
+)
+
+// whereSubquery WHERE条件中的子查询
+type whereSubquery struct {
+	expr       *ast.SubqueryExpr
+	stmt       *ast.SelectStmt // UNION子查询为nil
+	kind       int
+	sharding   bool // 是否使用了分片表, 全局表不计算在内
+	correlated bool // 是否引用了外层查询的表
+}
+
+// canInline 不相关的分片表标量子查询和IN子查询可以先执行, 再将结果作为常量替换到外层查询中
+func (w *whereSubquery) canInline() bool {
+	return w.stmt != nil && w.sharding && !w.correlated && w.kind != otherSubquery
+}
+
+// subqueryCollector collect subqueries in WHERE in traversal order, nested subqueries are not collected
+type subqueryCollector struct {
+	db         string
+	router     *router.Router
+	kinds      map[*ast.SubqueryExpr]int
+	subqueries []*whereSubquery
+}
+
+// collectWhereSubqueries return subqueries in WHERE, the order is stable for the same SQL
+func collectWhereSubqueries(where ast.ExprNode, db string, r *router.Router) []*whereSubquery {
+	if where == nil {
+		return nil
+	}
+	c := &subqueryCollector{
+		db:     db,
+		router: r,
+		kinds:  make(map[*ast.SubqueryExpr]int),
+	}
+	where.Accept(c)
+	return c.subqueries
+}
+
+// Enter implement ast.Visitor
+func (c *subqueryCollector) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	switch e := n.(type) {
+	case *ast.PatternInExpr:
+		if sq, ok := e.Sel.(*ast.SubqueryExpr); ok {
+			c.kinds[sq] = inSubquery
+		}
+	case *ast.ExistsSubqueryExpr:
+		if sq, ok := e.Sel.(*ast.SubqueryExpr); ok {
+			c.kinds[sq] = otherSubquery
+		}
+	case *ast.CompareSubqueryExpr:
+		if sq, ok := e.R.(*ast.SubqueryExpr); ok {
+			c.kinds[sq] = otherSubquery
+		}
+	case *ast.SubqueryExpr:
+		w := &whereSubquery{
+			expr:     e,
+			kind:     c.kinds[e],
+			sharding: hasShardingTable(e.Query, c.db, c.router),
+		}
+		if sel, ok := e.Query.(*ast.SelectStmt); ok {
+			w.stmt = sel
+			w.correlated = isCorrelatedSubquery(sel)
+		}
+		c.subqueries = append(c.subqueries, w)
+		return n, true
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (c *subqueryCollector) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// subqueryTableCollector collect table names, table alias and column names in subquery
+type subqueryTableCollector struct {
+	sources []*ast.TableSource
+	tables  []*ast.TableName
+	names   map[string]bool // table names and alias
+	columns []*ast.ColumnName
+}
+
+// Enter implement ast.Visitor
+func (c *subqueryTableCollector) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	switch e := n.(type) {
+	case *ast.TableSource:
+		c.sources = append(c.sources, e)
+		if e.AsName.L != "" {
+			c.names[e.AsName.L] = true
+		}
+	case *ast.TableName:
+		c.tables = append(c.tables, e)
+		c.names[e.Name.L] = true
+	case *ast.ColumnNameExpr:
+		c.columns = append(c.columns, e.Name)
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (c *subqueryTableCollector) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+func collectSubqueryTables(n ast.Node) *subqueryTableCollector {
+	c := &subqueryTableCollector{names: make(map[string]bool)}
+	n.Accept(c)
+	return c
+}
+
+func hasShardingTable(n ast.Node, db string, r *router.Router) bool {
+	for _, t := range collectSubqueryTables(n).tables {
+		tdb, table := getTableInfoFromTableName(t)
+		if tdb == "" {
+			tdb = db
+		}
+		if rule, ok := r.GetShardRule(tdb, table); ok && rule.GetType() != router.GlobalTableRuleType {
+			return true
+		}
+	}
+	return false
+}
+
+// isCorrelatedSubquery check if the subquery references tables of outer query,
+// columns without table name are considered as columns of tables in the subquery.
+func isCorrelatedSubquery(sel *ast.SelectStmt) bool {
+	c := collectSubqueryTables(sel)
+	for _, column := range c.columns {
+		if column.Table.L != "" && !c.names[column.Table.L] {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWhereSubqueries 改写WHERE中需要下推到分片执行的子查询 (相关子查询, EXISTS等).
+// 使用分片表的子查询只能与外层查询路由到同一个分片, 否则每个分片上子查询只能得到部分结果.
+func handleWhereSubqueries(p *SelectPlan, stmt *ast.SelectStmt) error {
+	for _, w := range collectWhereSubqueries(stmt.Where, p.db, p.router) {
+		if w.stmt == nil {
+			if w.sharding {
+				return fmt.Errorf("UNION subquery of sharding table is not supported")
+			}
+			continue
+		}
+
+		// 记录子查询中的表别名, 以便WHERE条件中的列名能找到对应的表
+		for _, ts := range collectSubqueryTables(w.stmt).sources {
+			tableName, ok := ts.Source.(*ast.TableName)
+			if !ok || ts.AsName.L == "" {
+				continue
+			}
+			if err := p.setTableAlias(tableName.Name.L, ts.AsName.L); err != nil {
+				return fmt.Errorf("record subquery table alias error: %v", err)
+			}
+		}
+
+		if err := handleSubquerySelectStmt(p.TableAliasStmtInfo, w.stmt); err != nil {
+			return fmt.Errorf("handle subquery error: %v", err)
+		}
+		if w.sharding {
+			p.shardingSubquery = true
+		}
+	}
+	return nil
+}
+
+// SubqueryPlan 先执行WHERE中不相关的分片表标量子查询和IN子查询, 将结果作为常量替换到外层查询中,
+// 再根据替换后的条件计算外层查询的路由. 每次执行时重新解析外层查询, 因此plan可以重复执行.
+type SubqueryPlan struct {
+	basePlan
+
+	db     string
+	sql    string
+	phyDBs map[string]string
+	router *router.Router
+	seq    *sequence.SequenceManager
+
+	subqueries []*inlineSubquery
+}
+
+type inlineSubquery struct {
+	plan      Plan
+	multiRows bool // IN子查询, 否则为标量子查询
+}
+
+// hasInlineSubquery check if there is any subquery in WHERE that should be executed before the outer query
+func hasInlineSubquery(stmt *ast.SelectStmt, db string, r *router.Router) bool {
+	for _, w := range collectWhereSubqueries(stmt.Where, db, r) {
+		if w.canInline() {
+			return true
+		}
+	}
+	return false
+}
+
+func buildSubqueryPlan(stmt *ast.SelectStmt, phyDBs map[string]string, db, sql string, r *router.Router, seq *sequence.SequenceManager) (*SubqueryPlan, error) {
+	p := &SubqueryPlan{
+		db:     db,
+		sql:    sql,
+		phyDBs: phyDBs,
+		router: r,
+		seq:    seq,
+	}
+
+	for _, w := range collectWhereSubqueries(stmt.Where, db, r) {
+		if !w.canInline() {
+			continue
+		}
+		sb := &strings.Builder{}
+		if err := w.stmt.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb)); err != nil {
+			return nil, fmt.Errorf("restore subquery error: %v", err)
+		}
+		sp, err := BuildPlan(w.stmt, phyDBs, db, sb.String(), r, seq)
+		if err != nil {
+			return nil, fmt.Errorf("build subquery plan error: %v", err)
+		}
+		p.subqueries = append(p.subqueries, &inlineSubquery{plan: sp, multiRows: w.kind == inSubquery})
+	}
+	return p, nil
+}
+
+// ExecuteIn implement Plan
+func (p *SubqueryPlan) ExecuteIn(reqCtx *util.RequestContext, sess Executor) (*mysql.Result, error) {
+	values := make([][]interface{}, 0, len(p.subqueries))
+	for _, sub := range p.subqueries {
+		v, err := sub.execute(reqCtx, sess)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	stmt, err := p.inline(values)
+	if err != nil {
+		return nil, err
+	}
+	outer, err := BuildPlan(stmt, p.phyDBs, p.db, p.sql, p.router, p.seq)
+	if err != nil {
+		return nil, fmt.Errorf("build plan with subquery result error: %v", err)
+	}
+	return outer.ExecuteIn(reqCtx, sess)
+}
+
+// inline parse the outer query and replace subqueries with their results
+func (p *SubqueryPlan) inline(values [][]interface{}) (*ast.SelectStmt, error) {
+	n, err := parser2.ParseOneStmt(parser.New(), p.sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse outer query error: %v", err)
+	}
+	stmt, ok := n.(*ast.SelectStmt)
+	if !ok {
+		return nil, fmt.Errorf("outer query is not SELECT, type: %T", n)
+	}
+
+	inliner := &subqueryInliner{
+		scalars: make(map[*ast.SubqueryExpr]interface{}),
+		lists:   make(map[*ast.SubqueryExpr][]interface{}),
+	}
+	i := 0
+	for _, w := range collectWhereSubqueries(stmt.Where, p.db, p.router) {
+		if !w.canInline() {
+			continue
+		}
+		if i >= len(values) {
+			return nil, fmt.Errorf("subquery count not match")
+		}
+		if w.kind == inSubquery {
+			inliner.lists[w.expr] = values[i]
+		} else if len(values[i]) != 0 {
+			inliner.scalars[w.expr] = values[i][0]
+		} else {
+			inliner.scalars[w.expr] = nil // 标量子查询结果为空时为NULL
+		}
+		i++
+	}
+
+	where, _ := stmt.Where.Accept(inliner)
+	stmt.Where = where.(ast.ExprNode)
+	return stmt, nil
+}
+
+// execute return values of the only column in subquery result
+func (s *inlineSubquery) execute(reqCtx *util.RequestContext, sess Executor) ([]interface{}, error) {
+	r, err := s.plan.ExecuteIn(reqCtx, sess)
+	if err != nil {
+		return nil, fmt.Errorf("execute subquery error: %v", err)
+	}
+	if r == nil || r.Resultset == nil {
+		return nil, fmt.Errorf("subquery returns no result set")
+	}
+	if len(r.Fields) != 1 {
+		return nil, mysql.NewDefaultError(mysql.ErrOperandColumns, 1)
+	}
+	if !s.multiRows && len(r.Values) > 1 {
+		return nil, mysql.NewDefaultError(mysql.ErrSubqueryNo1Row)
+	}
+
+	values := make([]interface{}, 0, len(r.Values))
+	for _, row := range r.Values {
+		values = append(values, row[0])
+	}
+	return values, nil
+}
+
+// subqueryInliner replace subqueries with literals of their results
+type subqueryInliner struct {
+	scalars map[*ast.SubqueryExpr]interface{}
+	lists   map[*ast.SubqueryExpr][]interface{}
+}
+
+// Enter implement ast.Visitor
+func (v *subqueryInliner) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (v *subqueryInliner) Leave(n ast.Node) (node ast.Node, ok bool) {
+	switch e := n.(type) {
+	case *ast.SubqueryExpr:
+		if value, ok := v.scalars[e]; ok {
+			return ast.NewValueExpr(value, "", ""), true
+		}
+	case *ast.PatternInExpr:
+		sq, ok := e.Sel.(*ast.SubqueryExpr)
+		if !ok {
+			break
+		}
+		values, ok := v.lists[sq]
+		if !ok {
+			break
+		}
+		// IN的结果集为空时, IN为false, NOT IN为true
+		if len(values) == 0 {
+			if e.Not {
+				return ast.NewValueExpr(int64(1), "", ""), true
+			}
+			return ast.NewValueExpr(int64(0), "", ""), true
+		}
+		e.Sel = nil
+		e.List = make([]ast.ExprNode, 0, len(values))
+		for _, value := range values {
+			e.List = append(e.List, ast.NewValueExpr(value, "", ""))
+		}
+	}
+	return n, true
+}
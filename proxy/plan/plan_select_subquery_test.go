@@ -0,0 +1,166 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+)
+
+func TestSelectWhereSubqueryKingshard(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks where tbl_ks.id = 1 and exists (select 1 from tbl_ks_child c where c.id = tbl_ks.id)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_0001` WHERE `tbl_ks_0001`.`id`=1 AND EXISTS (SELECT 1 FROM `tbl_ks_child_0001` AS `c` WHERE `c`.`id`=`tbl_ks_0001`.`id`)",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks where tbl_ks.id in (select id from tbl_ks_global_one)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_0000` WHERE `tbl_ks_0000`.`id` IN (SELECT `id` FROM `tbl_ks_global_one`)",
+						"SELECT * FROM `tbl_ks_0001` WHERE `tbl_ks_0001`.`id` IN (SELECT `id` FROM `tbl_ks_global_one`)",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_0002` WHERE `tbl_ks_0002`.`id` IN (SELECT `id` FROM `tbl_ks_global_one`)",
+						"SELECT * FROM `tbl_ks_0003` WHERE `tbl_ks_0003`.`id` IN (SELECT `id` FROM `tbl_ks_global_one`)",
+					},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "select * from tbl_ks where exists (select 1 from tbl_ks_child c where c.id = tbl_ks.id)",
+			hasErr: true, // correlated subquery of sharding table is routed to multiple shards
+		},
+		{
+			db:     "db_ks",
+			sql:    "select * from tbl_ks where tbl_ks.id = 1 and exists (select 1 from tbl_ks_range r where r.id = tbl_ks.id)",
+			hasErr: true, // sharding table in subquery is not linked to outer table
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestCollectWhereSubqueries(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql        string
+		kind       int
+		sharding   bool
+		correlated bool
+		inline     bool
+	}{
+		{"select * from tbl_ks where id = (select max(id) from tbl_ks_range)", scalarSubquery, true, false, true},
+		{"select * from tbl_ks where id in (select id from tbl_ks_range r where r.id > 10)", inSubquery, true, false, true},
+		{"select * from tbl_ks where id in (select id from tbl_ks_global_one)", inSubquery, false, false, false},
+		{"select * from tbl_ks where exists (select 1 from tbl_ks_range)", otherSubquery, true, false, false},
+		{"select * from tbl_ks a where a.id > any (select id from tbl_ks_range)", otherSubquery, true, false, false},
+		{"select * from tbl_ks a where a.id = (select max(id) from tbl_ks_child c where c.id = a.id)", scalarSubquery, true, true, false},
+	}
+
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		subqueries := collectWhereSubqueries(stmt.(*ast.SelectStmt).Where, "db_ks", ns.rt)
+		if len(subqueries) != 1 {
+			t.Fatalf("subquery count of %s not equal, expect: 1, actual: %d", test.sql, len(subqueries))
+		}
+		w := subqueries[0]
+		if w.kind != test.kind || w.sharding != test.sharding || w.correlated != test.correlated || w.canInline() != test.inline {
+			t.Errorf("subquery of %s not match, kind: %d, sharding: %v, correlated: %v, inline: %v",
+				test.sql, w.kind, w.sharding, w.correlated, w.canInline())
+		}
+	}
+}
+
+func TestSubqueryPlanInline(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql    string
+		values []interface{}
+		where  string
+	}{
+		{"select * from tbl_ks where tbl_ks.id = (select max(id) from tbl_ks_range)", []interface{}{int64(5)}, "`tbl_ks`.`id`=5"},
+		{"select * from tbl_ks where tbl_ks.id = (select max(id) from tbl_ks_range)", []interface{}{}, "`tbl_ks`.`id`=NULL"},
+		{"select * from tbl_ks where tbl_ks.id in (select id from tbl_ks_range)", []interface{}{int64(1), int64(2)}, "`tbl_ks`.`id` IN (1,2)"},
+		{"select * from tbl_ks where tbl_ks.id in (select id from tbl_ks_range)", []interface{}{}, "0"},
+		{"select * from tbl_ks where tbl_ks.id not in (select id from tbl_ks_range)", []interface{}{}, "1"},
+		{"select * from tbl_ks where tbl_ks.name = (select name from tbl_ks_range limit 1)", []interface{}{"hello"}, "`tbl_ks`.`name`='hello'"},
+	}
+
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		p, err := BuildPlan(stmt, nil, "db_ks", test.sql, ns.rt, ns.seqs)
+		if err != nil {
+			t.Fatalf("build plan of %s error: %v", test.sql, err)
+		}
+		sp, ok := p.(*SubqueryPlan)
+		if !ok {
+			t.Fatalf("plan of %s should be SubqueryPlan, type: %T", test.sql, p)
+		}
+		if len(sp.subqueries) != 1 {
+			t.Fatalf("subquery count of %s not equal, expect: 1, actual: %d", test.sql, len(sp.subqueries))
+		}
+
+		sel, err := sp.inline([][]interface{}{test.values})
+		if err != nil {
+			t.Fatalf("inline subquery of %s error: %v", test.sql, err)
+		}
+		sb := &strings.Builder{}
+		if err := sel.Where.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb)); err != nil {
+			t.Fatalf("restore where of %s error: %v", test.sql, err)
+		}
+		if sb.String() != test.where {
+			t.Errorf("where of %s not equal, expect: %s, actual: %s", test.sql, test.where, sb.String())
+		}
+	}
+}
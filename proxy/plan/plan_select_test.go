@@ -15,9 +15,12 @@
 package plan
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/XiaoMi/Gaea/parser"
 	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/proxy/schema"
 )
 
 func TestSimpleSelectShardMycatMod(t *testing.T) {
@@ -484,12 +487,12 @@ func TestMycatSelectMultiTablesEQ(t *testing.T) {
 				},
 			},
 		},
-		// TODO: 分表列是否需要支持等值比较NULL
-		//{
-		//	db:     "db_mycat",
-		//	parser:    "select * from tbl_mycat, tbl_mycat_child where tbl_mycat.id = null",
-		//	hasErr: true,
-		//},
+		// 分表列等值比较NULL的结果为UNKNOWN, 不路由到任何分片
+		{
+			db:   "db_mycat",
+			sql:  "select * from tbl_mycat, tbl_mycat_child where tbl_mycat.id = null",
+			sqls: map[string]map[string][]string{},
+		},
 		{
 			db:  "db_mycat",
 			sql: "select * from tbl_mycat, tbl_mycat_child where 1 = 1 and tbl_mycat.id = 1",
@@ -1197,6 +1200,170 @@ func TestMycatSelectPatternIn(t *testing.T) {
 	}
 }
 
+func TestSelectNullPredicates(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:   "db_mycat",
+			sql:  "select * from tbl_mycat where id = null",
+			sqls: map[string]map[string][]string{},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select * from tbl_mycat where id = 1 or id > null",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `id`=1 OR `id`>NULL"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select * from tbl_mycat where id <=> 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `id`<=>5"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select * from tbl_mycat where id <=> null",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"SELECT * FROM `tbl_mycat` WHERE `id`<=>NULL"},
+					"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `id`<=>NULL"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"SELECT * FROM `tbl_mycat` WHERE `id`<=>NULL"},
+					"db_mycat_3": {"SELECT * FROM `tbl_mycat` WHERE `id`<=>NULL"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select * from tbl_mycat where id in (1, 2, null)",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `id` IN (1,NULL)"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"SELECT * FROM `tbl_mycat` WHERE `id` IN (2,NULL)"},
+				},
+			},
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select * from tbl_mycat where id in (null)",
+			sqls: map[string]map[string][]string{},
+		},
+		{
+			db:   "db_mycat",
+			sql:  "select * from tbl_mycat where id not in (1, null)",
+			sqls: map[string]map[string][]string{},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select * from tbl_mycat where id in (1) or id = 2",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `id` IN (1) OR `id`=2"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"SELECT * FROM `tbl_mycat` WHERE `id` IN (NULL) OR `id`=2"},
+				},
+			},
+		},
+		{
+			db:   "db_ks",
+			sql:  "select * from tbl_ks_range where id between null and 150",
+			sqls: map[string]map[string][]string{},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_range where id not between 50 and null",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_range_0000` WHERE `id` NOT BETWEEN 50 AND NULL",
+						"SELECT * FROM `tbl_ks_range_0001` WHERE `id` NOT BETWEEN 50 AND NULL",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_range_0002` WHERE `id` NOT BETWEEN 50 AND NULL",
+						"SELECT * FROM `tbl_ks_range_0003` WHERE `id` NOT BETWEEN 50 AND NULL",
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectWindowFunction(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select id, row_number() over (partition by tbl_ks.name order by tbl_ks.id desc) as rn from tbl_ks where id = 3",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"SELECT `id`,ROW_NUMBER() OVER (PARTITION BY `tbl_ks_0003`.`name` ORDER BY `tbl_ks_0003`.`id` DESC) AS `rn` FROM `tbl_ks_0003` WHERE `id`=3"},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select id, sum(id) over w from tbl_ks where id = 3 window w as (order by tbl_ks.id rows between 1 preceding and current row)",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_ks": {"SELECT `id`,SUM(`id`) OVER `w` FROM `tbl_ks_0003` WHERE `id`=3 WINDOW `w` AS (ORDER BY `tbl_ks_0003`.`id` ROWS BETWEEN 1 PRECEDING AND CURRENT ROW)"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select id, rank() over (order by id) from tbl_mycat where id = 1 order by id",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT `id`,RANK() OVER (ORDER BY `id`) FROM `tbl_mycat` WHERE `id`=1 ORDER BY `id`"},
+				},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select id, rank() over (order by id) from tbl_mycat where id in (1, 2)",
+			hasErr: true,
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select * from tbl_mycat order by row_number() over (order by id)",
+			hasErr: true,
+		},
+		{
+			db:     "db_ks",
+			sql:    "select * from (select id, lag(id) over (order by id) as prev from tbl_ks) t",
+			hasErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
 func TestMycatSelectPatternInWithFuncDatabase(t *testing.T) {
 	ns, err := preparePlanInfo()
 	if err != nil {
@@ -1438,7 +1605,7 @@ func TestMycatSelectPatternLogicOperator(t *testing.T) {
 			sql: "select * from tbl_mycat where id = 0 or id in (1,2)",
 			sqls: map[string]map[string][]string{
 				"slice-0": {
-					"db_mycat_0": {"SELECT * FROM `tbl_mycat` WHERE `id`=0 OR `id` IN ()"},
+					"db_mycat_0": {"SELECT * FROM `tbl_mycat` WHERE `id`=0 OR `id` IN (NULL)"},
 					"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `id`=0 OR `id` IN (1)"},
 				},
 				"slice-1": {
@@ -1752,6 +1919,48 @@ func TestMycatSelectAggregationFunctionSum(t *testing.T) {
 	}
 }
 
+func TestMycatSelectAggregationFunctionAvg(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_mycat",
+			sql: "select avg(id) from tbl_mycat",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"SELECT SUM(`id`) AS `avg(id)`,COUNT(`id`) FROM `tbl_mycat`"},
+					"db_mycat_1": {"SELECT SUM(`id`) AS `avg(id)`,COUNT(`id`) FROM `tbl_mycat`"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"SELECT SUM(`id`) AS `avg(id)`,COUNT(`id`) FROM `tbl_mycat`"},
+					"db_mycat_3": {"SELECT SUM(`id`) AS `avg(id)`,COUNT(`id`) FROM `tbl_mycat`"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select id, avg(user) as a from tbl_mycat where id = 1 group by id",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT `id`,SUM(`user`) AS `a`,COUNT(`user`) FROM `tbl_mycat` WHERE `id`=1 GROUP BY `id`"},
+				},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select avg(distinct id) from tbl_mycat",
+			hasErr: true, // AVG(DISTINCT) cannot be merged
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
 func TestMycatSelectGroupBy(t *testing.T) {
 	ns, err := preparePlanInfo()
 	if err != nil {
@@ -1847,6 +2056,39 @@ func TestMycatSelectHaving(t *testing.T) {
 				},
 			},
 		},
+		{
+			db:  "db_mycat",
+			sql: "select id, count(user) from tbl_mycat group by id having count(user) > 5",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`"},
+					"db_mycat_1": {"SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`"},
+					"db_mycat_3": {"SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select user from tbl_mycat group by user having avg(id) > 1 and max(tbl_mycat.id) < 10",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"SELECT `user`,SUM(`id`) AS `avg(id)`,COUNT(`id`),MAX(`tbl_mycat`.`id`) FROM `tbl_mycat` GROUP BY `user`"},
+					"db_mycat_1": {"SELECT `user`,SUM(`id`) AS `avg(id)`,COUNT(`id`),MAX(`tbl_mycat`.`id`) FROM `tbl_mycat` GROUP BY `user`"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"SELECT `user`,SUM(`id`) AS `avg(id)`,COUNT(`id`),MAX(`tbl_mycat`.`id`) FROM `tbl_mycat` GROUP BY `user`"},
+					"db_mycat_3": {"SELECT `user`,SUM(`id`) AS `avg(id)`,COUNT(`id`),MAX(`tbl_mycat`.`id`) FROM `tbl_mycat` GROUP BY `user`"},
+				},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select id, count(user) from tbl_mycat group by id having count(user) > (select 1)",
+			hasErr: true, // 在proxy中计算的HAVING不支持子查询
+		},
 	}
 
 	for _, test := range tests {
@@ -1968,6 +2210,29 @@ func TestMycatSelectLimit(t *testing.T) {
 				},
 			},
 		},
+		{
+			db:  "db_mycat",
+			sql: "select id, user from tbl_mycat where id = 1 limit 10, 10",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT `id`,`user` FROM `tbl_mycat` WHERE `id`=1 LIMIT 10,10"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select id, count(user) from tbl_mycat group by id limit 10, 10",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`"},
+					"db_mycat_1": {"SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`"},
+					"db_mycat_3": {"SELECT `id`,COUNT(`user`) FROM `tbl_mycat` GROUP BY `id`"},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -2126,6 +2391,54 @@ func TestSelectMultiTablesKingshard(t *testing.T) {
 	}
 }
 
+func TestSelectBindingTablesKingshard(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_order o join tbl_ks_order_item i on o.id = i.order_id where i.order_id = 1",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_order_0001` AS `o` JOIN `tbl_ks_order_item_0001` AS `i` ON `o`.`id`=`i`.`order_id` WHERE `i`.`order_id`=1",
+					},
+				},
+			},
+		},
+		{
+			db:  "db_ks",
+			sql: "select * from tbl_ks_order join tbl_ks_order_item",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_order_0000` JOIN `tbl_ks_order_item_0000`",
+						"SELECT * FROM `tbl_ks_order_0001` JOIN `tbl_ks_order_item_0001`",
+					},
+				},
+				"slice-1": {
+					"db_ks": {
+						"SELECT * FROM `tbl_ks_order_0002` JOIN `tbl_ks_order_item_0002`",
+						"SELECT * FROM `tbl_ks_order_0003` JOIN `tbl_ks_order_item_0003`",
+					},
+				},
+			},
+		},
+		{
+			db:     "db_ks",
+			sql:    "select * from tbl_ks join tbl_ks_order_item",
+			hasErr: true, // not in the same binding group
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
 func TestSelectKingshardNumRange(t *testing.T) {
 	ns, err := preparePlanInfo()
 	if err != nil {
@@ -3648,3 +3961,215 @@ func prepareShardKingshardRouter() (*router.Router, error) {
 
 	return createRouter(nsModel)
 }
+
+func TestMycatSelectRouteHint(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_mycat",
+			sql: "/*+ shard_key(id=2) */ select * from tbl_mycat where k = 0",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_mycat_2": {"SELECT * FROM `tbl_mycat` WHERE `k`=0"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "/*+ shard_key(id='3') */ select * from tbl_mycat where id = 0",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_mycat_3": {"SELECT * FROM `tbl_mycat` WHERE `id`=0"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "/*+ route_to(db_mycat_1) */ select * from tbl_mycat where k = 0",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `k`=0"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "/*+ ROUTE_TO(slice-1) */ select * from tbl_mycat where id = 0",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_mycat_2": {"SELECT * FROM `tbl_mycat` WHERE `id`=0"},
+					"db_mycat_3": {"SELECT * FROM `tbl_mycat` WHERE `id`=0"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "/*+ full_scan */ select * from tbl_mycat where id = 0",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"SELECT * FROM `tbl_mycat` WHERE `id`=0"},
+					"db_mycat_1": {"SELECT * FROM `tbl_mycat` WHERE `id`=0"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"SELECT * FROM `tbl_mycat` WHERE `id`=0"},
+					"db_mycat_3": {"SELECT * FROM `tbl_mycat` WHERE `id`=0"},
+				},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "/*+ shard_key(k=1) */ select * from tbl_mycat where id = 0",
+			hasErr: true,
+		},
+		{
+			db:     "db_mycat",
+			sql:    "/*+ route_to(db_mycat_9) */ select * from tbl_mycat where id = 0",
+			hasErr: true,
+		},
+		{
+			db:     "db_mycat",
+			sql:    "/*+ full_scan route_to(db_mycat_1) */ select * from tbl_mycat where id = 0",
+			hasErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectWithLoadedSchema(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+	ns.rt.GetSchema().SetTables("db_mycat", map[string]*schema.Table{
+		"tbl_mycat": schema.NewTable([]*schema.Column{
+			{Name: "id", DataType: "bigint", ColumnType: "bigint(20)"},
+			{Name: "name", DataType: "varchar", ColumnType: "varchar(64)", Nullable: true},
+		}),
+		"tbl_mycat_child": schema.NewTable([]*schema.Column{
+			{Name: "id", DataType: "bigint", ColumnType: "bigint(20)"},
+			{Name: "score", DataType: "int", ColumnType: "int(11)"},
+		}),
+	})
+
+	tests := []SQLTestcase{
+		{
+			db:  "db_mycat",
+			sql: "select * from tbl_mycat where id = 0",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"SELECT `id`,`name` FROM `tbl_mycat` WHERE `id`=0"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select a.*, 1 from tbl_mycat a where a.id = 1",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT `id`,`name`,1 FROM `tbl_mycat` AS `a` WHERE `a`.`id`=1"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select id from tbl_mycat where id = '3'",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_mycat_3": {"SELECT `id` FROM `tbl_mycat` WHERE `id`='3'"},
+				},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "select * from tbl_mycat where id = 'abc'",
+			hasErr: true,
+		},
+		{
+			db:  "db_mycat",
+			sql: "select * from tbl_mycat where id = 1 order by name",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT `id`,`name` FROM `tbl_mycat` WHERE `id`=1 ORDER BY `name`"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select * from tbl_mycat where id = 1 order by 2 desc",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT `id`,`name` FROM `tbl_mycat` WHERE `id`=1 ORDER BY 2 DESC"},
+				},
+			},
+		},
+		{
+			db:  "db_mycat",
+			sql: "select a.*, b.* from tbl_mycat a join tbl_mycat_child b on a.id = b.id where a.id = 1 order by b.score",
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_1": {"SELECT `a`.`id`,`a`.`name`,`b`.`id`,`b`.`score`,`b`.`score` FROM `tbl_mycat` AS `a` JOIN `tbl_mycat_child` AS `b` ON `a`.`id`=`b`.`id` WHERE `a`.`id`=1 ORDER BY `b`.`score`"},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, getTestFunc(ns, test))
+	}
+}
+
+func TestSelectOrderByPosition(t *testing.T) {
+	ns, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+
+	tests := []struct {
+		sql           string
+		loadSchema    bool
+		groupByColumn []int
+		orderByColumn []int
+		columnCount   int
+		hasErr        bool
+	}{
+		{sql: "select id, name from tbl_mycat order by 2, id", orderByColumn: []int{1, 0}, columnCount: 2},
+		{sql: "select name, count(id) from tbl_mycat group by 1 order by 1", groupByColumn: []int{0}, orderByColumn: []int{0}, columnCount: 2},
+		{sql: "select id from tbl_mycat group by 1 order by name", groupByColumn: []int{0}, orderByColumn: []int{1}, columnCount: 2},
+		{sql: "select id from tbl_mycat order by 2", hasErr: true},
+		{sql: "select * from tbl_mycat order by 1", hasErr: true},
+		{sql: "select * from tbl_mycat order by 2", loadSchema: true, orderByColumn: []int{1}, columnCount: 2},
+		{sql: "select * from tbl_mycat order by unknown_col", loadSchema: true, orderByColumn: []int{2}, columnCount: 3},
+	}
+	for _, test := range tests {
+		tables := map[string]*schema.Table{}
+		if test.loadSchema {
+			tables["tbl_mycat"] = schema.NewTable([]*schema.Column{{Name: "id", DataType: "bigint"}, {Name: "name", DataType: "varchar"}})
+		}
+		ns.rt.GetSchema().SetTables("db_mycat", tables)
+
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		p, err := BuildPlan(stmt, ns.phyDBs, "db_mycat", test.sql, ns.rt, ns.seqs)
+		if test.hasErr {
+			if err == nil {
+				t.Errorf("expect error, sql: %s", test.sql)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("build plan error, sql: %s, err: %v", test.sql, err)
+		}
+		sp := p.(*SelectPlan)
+		if !reflect.DeepEqual(test.groupByColumn, sp.groupByColumn) || !reflect.DeepEqual(test.orderByColumn, sp.orderByColumn) || test.columnCount != sp.columnCount {
+			t.Errorf("not equal, sql: %s, expect: %v %v %d, actual: %v %v %d", test.sql,
+				test.groupByColumn, test.orderByColumn, test.columnCount, sp.groupByColumn, sp.orderByColumn, sp.columnCount)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"github.com/pingcap/parser/ast"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// sessionFunctions functions whose values depend on the client session, they are answered by proxy,
+// because backend connections are shared by sessions and return values of other sessions.
+var sessionFunctions = map[string]func(se Executor) (interface{}, *mysql.Field){
+	"last_insert_id": func(se Executor) (interface{}, *mysql.Field) {
+		return se.GetLastInsertID(), newSessionFunctionField(mysql.TypeLonglong, mysql.UnsignedFlag)
+	},
+	"row_count": func(se Executor) (interface{}, *mysql.Field) {
+		return se.GetRowCount(), newSessionFunctionField(mysql.TypeLonglong, 0)
+	},
+	"found_rows": func(se Executor) (interface{}, *mysql.Field) {
+		return se.GetFoundRows(), newSessionFunctionField(mysql.TypeLonglong, mysql.UnsignedFlag)
+	},
+	"connection_id": func(se Executor) (interface{}, *mysql.Field) {
+		return uint64(se.GetConnectionID()), newSessionFunctionField(mysql.TypeLonglong, mysql.UnsignedFlag)
+	},
+	"database": sessionDatabase,
+	"schema":   sessionDatabase,
+}
+
+func sessionDatabase(se Executor) (interface{}, *mysql.Field) {
+	field := &mysql.Field{Charset: 33, Type: mysql.TypeVarString, ColumnLength: 192}
+	if db := se.GetDatabase(); db != "" {
+		return db, field
+	}
+	return nil, field
+}
+
+func newSessionFunctionField(tp byte, flag uint) *mysql.Field {
+	return &mysql.Field{Charset: 63, Type: tp, Flag: uint16(mysql.BinaryFlag | mysql.NotNullFlag | flag), ColumnLength: 21}
+}
+
+// SessionFunctionPlan is the plan for SELECT of session functions without table,
+// such as SELECT LAST_INSERT_ID(), ROW_COUNT(), FOUND_ROWS(), CONNECTION_ID(), DATABASE()
+type SessionFunctionPlan struct {
+	basePlan
+
+	names     []string
+	functions []string
+}
+
+// IsSessionFunctionStmt check if the statement only selects session functions without arguments
+func IsSessionFunctionStmt(stmt ast.StmtNode) bool {
+	s, ok := stmt.(*ast.SelectStmt)
+	if !ok {
+		return false
+	}
+
+	if s.From != nil || s.Where != nil || s.GroupBy != nil || s.Having != nil || s.OrderBy != nil || s.Limit != nil || s.LockTp != ast.SelectLockNone {
+		return false
+	}
+
+	for _, field := range s.Fields.Fields {
+		f, ok := field.Expr.(*ast.FuncCallExpr)
+		if !ok || len(f.Args) != 0 {
+			return false
+		}
+		if _, ok := sessionFunctions[f.FnName.L]; !ok {
+			return false
+		}
+	}
+	return len(s.Fields.Fields) != 0
+}
+
+// CreateSessionFunctionPlan constructor of SessionFunctionPlan, stmt must be checked by IsSessionFunctionStmt
+func CreateSessionFunctionPlan(stmt ast.StmtNode) *SessionFunctionPlan {
+	p := &SessionFunctionPlan{}
+	for _, field := range stmt.(*ast.SelectStmt).Fields.Fields {
+		f := field.Expr.(*ast.FuncCallExpr)
+		name := f.FnName.O + "()"
+		if field.AsName.O != "" {
+			name = field.AsName.O
+		}
+		p.names = append(p.names, name)
+		p.functions = append(p.functions, f.FnName.L)
+	}
+	return p
+}
+
+// ExecuteIn implement Plan
+func (p *SessionFunctionPlan) ExecuteIn(reqCtx *util.RequestContext, se Executor) (*mysql.Result, error) {
+	rs := &mysql.Resultset{
+		Fields:     make([]*mysql.Field, len(p.functions)),
+		FieldNames: make(map[string]int, len(p.functions)),
+		Values:     [][]interface{}{make([]interface{}, len(p.functions))},
+	}
+	for i, fn := range p.functions {
+		value, field := sessionFunctions[fn](se)
+		field.Name = hack.Slice(p.names[i])
+		rs.Fields[i] = field
+		rs.FieldNames[p.names[i]] = i
+		rs.Values[0][i] = value
+	}
+
+	r := &mysql.Result{Resultset: rs}
+	if err := GenerateSelectResultRowData(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+type sessionStateExecutor struct {
+	Executor
+	lastInsertID uint64
+	rowCount     int64
+	foundRows    uint64
+	connID       uint32
+	db           string
+}
+
+func (e *sessionStateExecutor) GetLastInsertID() uint64 { return e.lastInsertID }
+func (e *sessionStateExecutor) GetRowCount() int64      { return e.rowCount }
+func (e *sessionStateExecutor) GetFoundRows() uint64    { return e.foundRows }
+func (e *sessionStateExecutor) GetConnectionID() uint32 { return e.connID }
+func (e *sessionStateExecutor) GetDatabase() string     { return e.db }
+
+func TestIsSessionFunctionStmt(t *testing.T) {
+	tests := []struct {
+		sql    string
+		expect bool
+	}{
+		{"select last_insert_id()", true},
+		{"SELECT LAST_INSERT_ID() AS id, ROW_COUNT(), FOUND_ROWS(), CONNECTION_ID(), DATABASE()", true},
+		{"select last_insert_id(5)", false},
+		{"select last_insert_id(), 1", false},
+		{"select now()", false},
+		{"select found_rows() from tbl_mycat", false},
+		{"select database() for update", false},
+	}
+	for _, test := range tests {
+		t.Run(test.sql, func(t *testing.T) {
+			stmt, err := parser.ParseSQL(test.sql)
+			if err != nil {
+				t.Fatalf("parse sql error: %v", err)
+			}
+			if actual := IsSessionFunctionStmt(stmt); actual != test.expect {
+				t.Errorf("not equal, expect: %v, actual: %v", test.expect, actual)
+			}
+		})
+	}
+}
+
+func TestSessionFunctionPlan(t *testing.T) {
+	stmt, err := parser.ParseSQL("SELECT LAST_INSERT_ID() AS id, row_count(), FOUND_ROWS(), CONNECTION_ID(), DATABASE()")
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	se := &sessionStateExecutor{lastInsertID: 100, rowCount: -1, foundRows: 3, connID: 7}
+	r, err := CreateSessionFunctionPlan(stmt).ExecuteIn(util.NewRequestContext(), se)
+	if err != nil {
+		t.Fatalf("execute plan error: %v", err)
+	}
+
+	expectNames := map[string]int{"id": 0, "row_count()": 1, "FOUND_ROWS()": 2, "CONNECTION_ID()": 3, "DATABASE()": 4}
+	if !reflect.DeepEqual(expectNames, r.FieldNames) {
+		t.Errorf("field names not equal, expect: %v, actual: %v", expectNames, r.FieldNames)
+	}
+	expectValues := [][]interface{}{{uint64(100), int64(-1), uint64(3), uint64(7), nil}}
+	if !reflect.DeepEqual(expectValues, r.Values) {
+		t.Errorf("values not equal, expect: %v, actual: %v", expectValues, r.Values)
+	}
+	// database is null if no db selected
+	if r.RowDatas[0][len(r.RowDatas[0])-1] != 0xfb {
+		t.Errorf("database() is not encoded as null: %v", r.RowDatas[0])
+	}
+	if r.Fields[4].Type != mysql.TypeVarString {
+		t.Errorf("unexpected type of database(): %d", r.Fields[4].Type)
+	}
+}
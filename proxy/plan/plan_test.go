@@ -22,6 +22,7 @@ import (
 
 	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
 	"github.com/XiaoMi/Gaea/proxy/router"
 	"github.com/XiaoMi/Gaea/proxy/sequence"
 )
@@ -226,6 +227,36 @@ func preparePlanInfo() (*PlanInfo, error) {
             "type": "linked",
             "key": "user_id",
             "parent_table": "tbl_ks"
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_order",
+            "type": "mod",
+            "key": "id",
+            "binding_group": "order",
+            "locations": [
+                2,
+                2
+            ],
+            "slices": [
+                "slice-0",
+                "slice-1"
+            ]
+        },
+        {
+            "db": "db_ks",
+            "table": "tbl_ks_order_item",
+            "type": "mod",
+            "key": "order_id",
+            "binding_group": "order",
+            "locations": [
+                2,
+                2
+            ],
+            "slices": [
+                "slice-0",
+                "slice-1"
+            ]
         },
 		{
             "db": "db_ks",
@@ -500,3 +531,86 @@ func preparePlanInfo() (*PlanInfo, error) {
 	}
 	return planInfo, nil
 }
+
+func TestIsFullScatter(t *testing.T) {
+	info, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+	tests := []struct {
+		sql    string
+		expect bool
+	}{
+		{"select * from tbl_ks", true},
+		{"select * from tbl_ks where name = 'a'", true},
+		{"select * from tbl_ks where id = 1", false},
+		{"select * from tbl_ks where id in (1, 2)", false},
+		{"update tbl_ks set name = 'a' where name = 'b'", true},
+		{"delete from tbl_ks where id = 3", false},
+		{"insert into tbl_ks (id, name) values (1, 'a')", false},
+		{"select * from tbl_ks_global_one", false},
+		{"select * from tbl_unshard", false},
+		{"/*+ full_scan */ select * from tbl_ks", false},
+		{"/*+ route_to(slice-0) */ select * from tbl_ks", false},
+	}
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		p, err := BuildPlan(stmt, info.phyDBs, "db_ks", test.sql, info.rt, info.seqs)
+		if err != nil {
+			t.Fatalf("build plan of %s error: %v", test.sql, err)
+		}
+		if IsFullScatter(p) != test.expect {
+			t.Errorf("full scatter of %s, expect %v", test.sql, test.expect)
+		}
+	}
+}
+
+func TestGetPipelineSQLs(t *testing.T) {
+	info, err := preparePlanInfo()
+	if err != nil {
+		t.Fatalf("prepare namespace error: %v", err)
+	}
+	tests := []struct {
+		sql    string
+		ok     bool
+		shards int
+	}{
+		{"insert into tbl_ks (id, name) values (1, 'a'), (2, 'b')", true, 2},
+		{"update tbl_ks set name = 'a' where id = 1", true, 1},
+		{"delete from tbl_ks where id in (1, 2)", true, 2},
+		{"select * from tbl_ks where id = 1", false, 0},
+		{"insert into tbl_unshard (id) values (1)", false, 0},
+	}
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		p, err := BuildPlan(stmt, info.phyDBs, "db_ks", test.sql, info.rt, info.seqs)
+		if err != nil {
+			t.Fatalf("build plan of %s error: %v", test.sql, err)
+		}
+		sqls, ok := GetPipelineSQLs(p)
+		if ok != test.ok {
+			t.Errorf("pipeline of %s, expect %v", test.sql, test.ok)
+			continue
+		}
+		shards := 0
+		for _, dbSQLs := range sqls {
+			for _, tableSQLs := range dbSQLs {
+				shards += len(tableSQLs)
+			}
+		}
+		if shards != test.shards {
+			t.Errorf("sqls of %s, expect %d shards, got %v", test.sql, test.shards, sqls)
+		}
+	}
+
+	r, err := MergePipelineResults(&InsertPlan{lastInsertID: 5}, []*mysql.Result{{AffectedRows: 1}, {AffectedRows: 2}})
+	if err != nil || r.AffectedRows != 3 || r.InsertID != 5 {
+		t.Errorf("merge results error: %v, result: %+v", err, r)
+	}
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/schema"
 	"github.com/XiaoMi/Gaea/util"
 )
 
@@ -33,47 +34,27 @@ type UnshardPlan struct {
 	phyDBs map[string]string
 	sql    string
 	stmt   ast.StmtNode
-}
 
-// SelectLastInsertIDPlan is the plan for SELECT LAST_INSERT_ID()
-// TODO: fix below
-// https://dev.mysql.com/doc/refman/5.6/en/information-functions.html#function_last-insert-id
-// The value of LAST_INSERT_ID() is not changed if you set the AUTO_INCREMENT column of a row
-// to a non-“magic” value (that is, a value that is not NULL and not 0).
-type SelectLastInsertIDPlan struct {
-	basePlan
-}
-
-// IsSelectLastInsertIDStmt check if the statement is SELECT LAST_INSERT_ID()
-func IsSelectLastInsertIDStmt(stmt ast.StmtNode) bool {
-	s, ok := stmt.(*ast.SelectStmt)
-	if !ok {
-		return false
-	}
-
-	if len(s.Fields.Fields) != 1 {
-		return false
-	}
-
-	if s.From != nil || s.Where != nil || s.GroupBy != nil || s.Having != nil || s.OrderBy != nil || s.Limit != nil {
-		return false
-	}
-
-	f, ok := s.Fields.Fields[0].Expr.(*ast.FuncCallExpr)
-	if !ok {
-		return false
-	}
-
-	return f.FnName.L == "last_insert_id"
+	autoIncrementSpecified bool // values of auto increment column of INSERT are specified, session last insert id is not changed
 }
 
 // CreateUnshardPlan constructor of UnshardPlan
-func CreateUnshardPlan(stmt ast.StmtNode, phyDBs map[string]string, db string, tableNames []*ast.TableName) (*UnshardPlan, error) {
+func CreateUnshardPlan(stmt ast.StmtNode, phyDBs map[string]string, db string, tableNames []*ast.TableName, s *schema.Schema) (*UnshardPlan, error) {
 	p := &UnshardPlan{
 		db:     db,
 		phyDBs: phyDBs,
 		stmt:   stmt,
 	}
+	// table names are logical before rewritten
+	if insertStmt, ok := stmt.(*ast.InsertStmt); ok {
+		if tableName, ok := insertTableName(insertStmt); ok {
+			tableDB := tableName.Schema.O
+			if tableDB == "" {
+				tableDB = db
+			}
+			p.autoIncrementSpecified = isAutoIncrementSpecified(s, tableDB, tableName.Name.L, insertStmt)
+		}
+	}
 	rewriteUnshardTableName(phyDBs, tableNames)
 	rsql, err := generateUnshardingSQL(stmt)
 	if err != nil {
@@ -83,6 +64,32 @@ func CreateUnshardPlan(stmt ast.StmtNode, phyDBs map[string]string, db string, t
 	return p, nil
 }
 
+// GetSQL return sql executed in default slice, table names are rewritten with physical db names
+func (p *UnshardPlan) GetSQL() string {
+	return p.sql
+}
+
+// BindSQL return a copy of the plan executing the given sql,
+// used by prepared statement to execute the plan built at prepare time with bound values.
+func (p *UnshardPlan) BindSQL(sql string) *UnshardPlan {
+	np := *p
+	np.sql = sql
+	return &np
+}
+
+// insertTableName return table of INSERT, ok is false if it's not a single table
+func insertTableName(stmt *ast.InsertStmt) (*ast.TableName, bool) {
+	if stmt.Table == nil || stmt.Table.TableRefs.Right != nil {
+		return nil, false
+	}
+	tableSource, ok := stmt.Table.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, false
+	}
+	tableName, ok := tableSource.Source.(*ast.TableName)
+	return tableName, ok
+}
+
 func rewriteUnshardTableName(phyDBs map[string]string, tableNames []*ast.TableName) {
 	for _, tableName := range tableNames {
 		if phyDB, ok := phyDBs[tableName.Schema.String()]; ok {
@@ -99,11 +106,6 @@ func generateUnshardingSQL(stmt ast.StmtNode) (string, error) {
 	return s.String(), nil
 }
 
-// CreateSelectLastInsertIDPlan constructor of SelectLastInsertIDPlan
-func CreateSelectLastInsertIDPlan() *SelectLastInsertIDPlan {
-	return &SelectLastInsertIDPlan{}
-}
-
 // ExecuteIn implement Plan
 func (p *UnshardPlan) ExecuteIn(reqCtx *util.RequestContext, se Executor) (*mysql.Result, error) {
 	r, err := se.ExecuteSQL(reqCtx, backend.DefaultSlice, p.db, p.sql)
@@ -112,7 +114,7 @@ func (p *UnshardPlan) ExecuteIn(reqCtx *util.RequestContext, se Executor) (*mysq
 	}
 
 	// set last insert id to session
-	if _, ok := p.stmt.(*ast.InsertStmt); ok {
+	if _, ok := p.stmt.(*ast.InsertStmt); ok && !p.autoIncrementSpecified {
 		if r.InsertID != 0 {
 			se.SetLastInsertID(r.InsertID)
 		}
@@ -120,38 +122,3 @@ func (p *UnshardPlan) ExecuteIn(reqCtx *util.RequestContext, se Executor) (*mysq
 
 	return r, nil
 }
-
-// ExecuteIn implement Plan
-func (p *SelectLastInsertIDPlan) ExecuteIn(reqCtx *util.RequestContext, se Executor) (*mysql.Result, error) {
-	r := createLastInsertIDResult(se.GetLastInsertID())
-	return r, nil
-}
-
-func createLastInsertIDResult(lastInsertID uint64) *mysql.Result {
-	name := "last_insert_id()"
-	var column = 1
-	var rows [][]string
-	var names = []string{
-		name,
-	}
-
-	var t = fmt.Sprintf("%d", lastInsertID)
-	rows = append(rows, []string{t})
-
-	r := new(mysql.Resultset)
-
-	var values = make([][]interface{}, len(rows))
-	for i := range rows {
-		values[i] = make([]interface{}, column)
-		for j := range rows[i] {
-			values[i][j] = rows[i][j]
-		}
-	}
-
-	r, _ = mysql.BuildResultset(nil, names, values)
-	ret := &mysql.Result{
-		Resultset: r,
-	}
-
-	return ret
-}
@@ -83,13 +83,21 @@ func HandleUpdatePlan(p *UpdatePlan) error {
 		return fmt.Errorf("handle OrderBy error: %v", err)
 	}
 
-	// Limit clause does not need to handle
+	// LIMIT原样下推, 与ORDER BY一起使用时在计算路由后检查只路由到一个分表
 
 	// handle global table
 	if err := postHandleGlobalTableRouteResultInModify(p.StmtInfo); err != nil {
 		return fmt.Errorf("post handle global table error: %v", err)
 	}
 
+	if err := postHandleRouteHint(p.StmtInfo); err != nil {
+		return fmt.Errorf("handle route hint error: %v", err)
+	}
+
+	if err := checkModifyOrderByLimit(p.StmtInfo, "UPDATE", p.stmt.Order, p.stmt.Limit); err != nil {
+		return err
+	}
+
 	sqls, err := generateShardingSQLs(p.stmt, p.GetRouteResult(), p.router)
 	if err != nil {
 		return fmt.Errorf("generate sqls error: %v", err)
@@ -280,6 +280,20 @@ func TestMycatShardUpdateWithLimit(t *testing.T) {
 				},
 			},
 		},
+		{
+			db:  "db_mycat",
+			sql: "update tbl_mycat set a = 'hi' where id = 3 order by id desc limit 10",
+			sqls: map[string]map[string][]string{
+				"slice-1": {
+					"db_mycat_3": {"UPDATE `tbl_mycat` SET `a`='hi' WHERE `id`=3 ORDER BY `id` DESC LIMIT 10"},
+				},
+			},
+		},
+		{
+			db:     "db_mycat",
+			sql:    "update tbl_mycat set a = 'hi' order by id limit 10",
+			hasErr: true, // ORDER BY和LIMIT路由到多个分片
+		},
 		{
 			db:     "db_mycat",
 			sql:    "update tbl_mycat set a = 'hi' limit 0, 10",
@@ -317,6 +331,20 @@ func TestGlobalTableUpdate(t *testing.T) {
 				},
 			},
 		},
+		{
+			db:  "db_mycat",
+			sql: "update tbl_mycat_global_one set a = 'hi' order by id limit 10", // 全局表各分片数据相同, 不限制路由
+			sqls: map[string]map[string][]string{
+				"slice-0": {
+					"db_mycat_0": {"UPDATE `tbl_mycat_global_one` SET `a`='hi' ORDER BY `id` LIMIT 10"},
+					"db_mycat_1": {"UPDATE `tbl_mycat_global_one` SET `a`='hi' ORDER BY `id` LIMIT 10"},
+				},
+				"slice-1": {
+					"db_mycat_2": {"UPDATE `tbl_mycat_global_one` SET `a`='hi' ORDER BY `id` LIMIT 10"},
+					"db_mycat_3": {"UPDATE `tbl_mycat_global_one` SET `a`='hi' ORDER BY `id` LIMIT 10"},
+				},
+			},
+		},
 		{
 			db:  "db_mycat",
 			sql: "update db_mycat.tbl_mycat_global_one set db_mycat.tbl_mycat_global_one.a = 'hi' limit 10",
@@ -40,6 +40,19 @@ func makeList(start, end int) []int {
 	return list
 }
 
+// if start is 201511, end is 201603, and indexs is [201510,201511,201512,201601,201602,201603]
+// the result is [201511,201512,201601,201602]
+// the indexs must be sorted, the indexes of date shard are not continuous
+func makeRangeList(start, end int, indexs []int) []int {
+	list := make([]int, 0)
+	for _, v := range indexs {
+		if v >= start && v < end {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 //if value is 2016, and indexs is [2015,2016,2017]
 //the result is [2015,2016]
 // the indexs must be sorted
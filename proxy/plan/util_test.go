@@ -169,3 +169,13 @@ func TestMakeGtList(t *testing.T) {
 	l4 := makeGtList(20150828, l1)
 	testCheckList(t, l4, []int{}...)
 }
+
+func TestMakeRangeList(t *testing.T) {
+	l1 := []int{201510, 201511, 201512, 201601, 201602, 201603}
+	l2 := makeRangeList(201511, 201603, l1)
+	testCheckList(t, l2, 201511, 201512, 201601, 201602)
+	l3 := makeRangeList(201500, 201511, l1)
+	testCheckList(t, l3, 201510)
+	l4 := makeRangeList(201604, 201700, l1)
+	testCheckList(t, l4, []int{}...)
+}
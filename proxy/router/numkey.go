@@ -68,6 +68,22 @@ func ParseNumSharding(Locations []int, TableRowLimit int) ([]NumKeyRange, error)
 	return ranges, nil
 }
 
+// ParseNumRangeBoundaries parse num shard with boundaries of sub tables,
+// the first sub table has no lower bound and the last sub table has no upper bound.
+func ParseNumRangeBoundaries(boundaries []int64) ([]NumKeyRange, error) {
+	ranges := make([]NumKeyRange, len(boundaries)+1)
+	start := int64(MinNumKey)
+	for i, b := range boundaries {
+		if i > 0 && b <= boundaries[i-1] {
+			return nil, fmt.Errorf("range boundaries must be in ascending order: %v", boundaries)
+		}
+		ranges[i] = NumKeyRange{Start: start, End: b}
+		start = b
+	}
+	ranges[len(boundaries)] = NumKeyRange{Start: start, End: MaxNumKey}
+	return ranges, nil
+}
+
 // ParseDayRange return date of daynumber by order
 //20151201-20151205
 //20151201,20151202,20151203,20151204,20151205
@@ -225,3 +241,55 @@ func ParseYearRange(dateRange string) ([]int, error) {
 
 	return dateYear, nil
 }
+
+// ParseWeekRange return date of ISO week by order
+// 202451-202502
+// 202451,202452,202501,202502
+func ParseWeekRange(dateRange string) ([]int, error) {
+	dateWeek := make([]int, 0)
+	dateLength := 6
+
+	dateTmp := strings.SplitN(dateRange, "-", 2)
+	for _, d := range dateTmp {
+		if len(d) != dateLength {
+			return nil, errors.ErrDateRangeIllegal
+		}
+	}
+	//change the begin week and the end week
+	if len(dateTmp) == 2 && dateTmp[1] < dateTmp[0] {
+		dateTmp[0], dateTmp[1] = dateTmp[1], dateTmp[0]
+	}
+
+	weeks := make([]int, 0, 2)
+	for _, d := range dateTmp {
+		dateNum, err := strconv.Atoi(d)
+		if err != nil {
+			return nil, err
+		}
+		if week := dateNum % 100; week < 1 || week > WeeksInYear(dateNum/100) {
+			return nil, errors.ErrDateRangeIllegal
+		}
+		weeks = append(weeks, dateNum)
+	}
+	if len(weeks) == 1 {
+		return weeks, nil
+	}
+
+	year, week := weeks[0]/100, weeks[0]%100
+	for year*100+week <= weeks[1] {
+		dateWeek = append(dateWeek, year*100+week)
+		week++
+		if week > WeeksInYear(year) {
+			year++
+			week = 1
+		}
+	}
+	return dateWeek, nil
+}
+
+// WeeksInYear return count of ISO weeks in the year, 52 or 53
+func WeeksInYear(year int) int {
+	// December 28th is always in the last week of the year
+	_, week := time.Date(year, 12, 28, 0, 0, 0, 0, time.UTC).ISOWeek()
+	return week
+}
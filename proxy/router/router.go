@@ -18,11 +18,20 @@ import (
 	"strings"
 
 	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/proxy/schema"
 )
 
 type Router struct {
 	rules       map[string]map[string]Rule // dbname-tablename
 	defaultRule Rule
+
+	// dbname-tablename, value is the first table of the binding group which the table belongs to
+	bindingTables map[string]map[string]string
+
+	// column definitions of logical tables, used to check sharding values
+	schema *schema.Schema
+
+	dualWrite bool // some tables are migrating from default slice with dual write
 }
 
 //NewRouter build router according to the models of namespace
@@ -43,8 +52,11 @@ func NewRouter(namespace *models.Namespace) (*Router, error) {
 	rt := new(Router)
 	rt.rules = make(map[string]map[string]Rule)
 	rt.defaultRule = NewDefaultRule(namespace.DefaultSlice)
+	rt.bindingTables = make(map[string]map[string]string)
+	rt.schema = schema.NewSchema()
 
 	linkedRuleIndexes := make([]int, 0)
+	bindingGroups := make(map[string]string) // key: db.binding_group, value: first table of the group
 
 	for i, shard := range namespace.ShardRules {
 		for _, slice := range shard.Slices {
@@ -64,6 +76,10 @@ func NewRouter(namespace *models.Namespace) (*Router, error) {
 		if err != nil {
 			return nil, err
 		}
+		rule.schema = rt.schema
+		if rule.dualWrite {
+			rt.dualWrite = true
+		}
 
 		// if global table rule, use the namespace slice names
 		// TODO: refactor
@@ -86,6 +102,17 @@ func NewRouter(namespace *models.Namespace) (*Router, error) {
 			rt.rules[rule.db] = m
 			rt.rules[rule.db][rule.table] = rule
 		}
+
+		if shard.BindingGroup != "" {
+			group := rule.db + "." + shard.BindingGroup
+			if _, ok := bindingGroups[group]; !ok {
+				bindingGroups[group] = rule.table
+			}
+			if _, ok := rt.bindingTables[rule.db]; !ok {
+				rt.bindingTables[rule.db] = make(map[string]string)
+			}
+			rt.bindingTables[rule.db][rule.table] = bindingGroups[group]
+		}
 	}
 
 	// create linked rule
@@ -110,6 +137,31 @@ func (r *Router) GetShardRule(db, table string) (Rule, bool) {
 	return rule, ok
 }
 
+// GetShardRules return shard rules of tables in the db, key is table name, the map must not be modified
+func (r *Router) GetShardRules(db string) map[string]Rule {
+	return r.rules[db]
+}
+
+// GetBindingTable return the first table of the binding group which the table belongs to,
+// tables in the same binding group are routed together, so joins between them are executed in each sub table.
+// The table itself is returned if it's not in any binding group.
+func (r *Router) GetBindingTable(db, table string) string {
+	if t, ok := r.bindingTables[db][table]; ok {
+		return t
+	}
+	return table
+}
+
+// HasDualWriteTable return true if any table is migrating from default slice with dual write
+func (r *Router) HasDualWriteTable() bool {
+	return r.dualWrite
+}
+
+// GetSchema return column definitions of logical tables, tables are loaded by schema tracker of namespace
+func (r *Router) GetSchema() *schema.Schema {
+	return r.schema
+}
+
 func (r *Router) GetRule(db, table string) Rule {
 	arry := strings.Split(table, ".")
 	if len(arry) == 2 {
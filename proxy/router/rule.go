@@ -33,14 +33,17 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/XiaoMi/Gaea/core/errors"
 	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/proxy/schema"
 )
 
 const (
 	DefaultRuleType         = models.ShardDefault
 	GlobalTableRuleType     = models.ShardGlobal
+	BroadcastTableRuleType  = models.ShardBroadcast
 	LinkedTableRuleType     = models.ShardLinked // this type only exists in conf, then transfer to LinkedRule
 	HashRuleType            = models.ShardHash
 	RangeRuleType           = models.ShardRange
@@ -48,6 +51,9 @@ const (
 	DateYearRuleType        = models.ShardYear
 	DateMonthRuleType       = models.ShardMonth
 	DateDayRuleType         = models.ShardDay
+	DateWeekRuleType        = models.ShardWeek
+	ExpressionRuleType      = models.ShardExpression
+	PluginRuleType          = models.ShardPlugin
 	MycatModRuleType        = models.ShardMycatMod
 	MycatLongRuleType       = models.ShardMycatLong
 	MycatStringRuleType     = models.ShardMycatString
@@ -64,6 +70,8 @@ type Rule interface {
 	GetTable() string
 	GetShardingColumn() string
 	IsLinkedRule() bool
+	IsBroadcastRule() bool
+	IsDualWrite() bool
 	GetShard() Shard
 	FindTableIndex(key interface{}) (int, error)
 	GetSlice(i int) string // i is slice index
@@ -88,6 +96,8 @@ type BaseRule struct {
 	shardingColumn string
 
 	ruleType        string
+	broadcast       bool        // global table whose DML is executed in all slices within one transaction
+	dualWrite       bool        // table migrating from default slice, see models.Shard.DualWrite
 	slices          []string    // not the namespace slices
 	subTableIndexes []int       //subTableIndexes store all the index of sharding sub-table
 	tableToSlice    map[int]int //key is table index, and value is slice index
@@ -96,6 +106,8 @@ type BaseRule struct {
 	// TODO: 目前全局表也借用这两个field存放默认分片的物理DB名
 	mycatDatabases               []string
 	mycatDatabaseToTableIndexMap map[string]int // key: phy db name, value: table index
+
+	schema *schema.Schema // column definitions used to check sharding value, nil if not available
 }
 
 type LinkedRule struct {
@@ -132,14 +144,38 @@ func (r *BaseRule) IsLinkedRule() bool {
 	return false
 }
 
+func (r *BaseRule) IsBroadcastRule() bool {
+	return r.broadcast
+}
+
+func (r *BaseRule) IsDualWrite() bool {
+	return r.dualWrite
+}
+
 func (r *BaseRule) GetShard() Shard {
 	return r.shard
 }
 
 func (r *BaseRule) FindTableIndex(key interface{}) (int, error) {
+	key, err := r.convertShardingValue(key)
+	if err != nil {
+		return -1, err
+	}
 	return r.shard.FindForKey(key)
 }
 
+// convertShardingValue check the sharding value with type of sharding column if the column definition is loaded
+func (r *BaseRule) convertShardingValue(key interface{}) (interface{}, error) {
+	if r.schema == nil || r.shardingColumn == "" {
+		return key, nil
+	}
+	column, ok := r.schema.GetColumn(r.db, r.table, r.shardingColumn)
+	if !ok {
+		return key, nil
+	}
+	return column.ConvertValue(key)
+}
+
 // The confs should be verified before use to avoid panic.
 func (r *BaseRule) GetSlice(i int) string {
 	return r.slices[i]
@@ -218,6 +254,14 @@ func (l *LinkedRule) IsLinkedRule() bool {
 	return true
 }
 
+func (l *LinkedRule) IsBroadcastRule() bool {
+	return l.linkToRule.IsBroadcastRule()
+}
+
+func (l *LinkedRule) IsDualWrite() bool {
+	return l.linkToRule.IsDualWrite()
+}
+
 func (l *LinkedRule) GetShard() Shard {
 	return l.linkToRule.GetShard()
 }
@@ -299,10 +343,18 @@ func createLinkedRule(rules map[string]map[string]Rule, shard *models.Shard) (*L
 
 func parseRule(cfg *models.Shard) (*BaseRule, error) {
 	r := new(BaseRule)
+	// broadcast table is routed as global table, so joins with sharding tables are executed locally in each slice
+	if cfg.Type == BroadcastTableRuleType {
+		globalCfg := *cfg
+		globalCfg.Type = GlobalTableRuleType
+		cfg = &globalCfg
+		r.broadcast = true
+	}
 	r.db = cfg.DB
 	r.table = strings.ToLower(cfg.Table)
 	r.shardingColumn = strings.ToLower(cfg.Key) //ignore case
 	r.ruleType = cfg.Type
+	r.dualWrite = cfg.DualWrite
 	r.slices = cfg.Slices //将rule model中的slices赋值给rule
 	r.mycatDatabaseToTableIndexMap = make(map[string]int)
 
@@ -346,6 +398,7 @@ func parseRule(cfg *models.Shard) (*BaseRule, error) {
 }
 
 func parseRuleSliceInfos(cfg *models.Shard) ([]int, map[int]int, Shard, error) {
+	dateRange := models.ExpandDateRange(cfg.Type, cfg.DateRange, cfg.FutureTables, time.Now())
 	switch cfg.Type {
 	case HashRuleType:
 		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
@@ -361,12 +414,37 @@ func parseRuleSliceInfos(cfg *models.Shard) ([]int, map[int]int, Shard, error) {
 		}
 		shard := &ModShard{ShardNum: len(tableToSlice)}
 		return subTableIndexs, tableToSlice, shard, nil
+	case ExpressionRuleType:
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard, err := NewExpressionShard(len(tableToSlice), cfg.Expression, cfg.Key)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return subTableIndexs, tableToSlice, shard, nil
+	case PluginRuleType:
+		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard, err := NewPluginShard(len(tableToSlice), cfg.Plugin, cfg.PluginParams)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return subTableIndexs, tableToSlice, shard, nil
 	case RangeRuleType:
 		subTableIndexs, tableToSlice, err := parseHashRuleSliceInfos(cfg.Locations, cfg.Slices)
 		if err != nil {
 			return nil, nil, nil, err
 		}
-		rs, err := ParseNumSharding(cfg.Locations, cfg.TableRowLimit)
+		var rs []NumKeyRange
+		if len(cfg.RangeBoundaries) != 0 {
+			rs, err = ParseNumRangeBoundaries(cfg.RangeBoundaries)
+		} else {
+			rs, err = ParseNumSharding(cfg.Locations, cfg.TableRowLimit)
+		}
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -376,21 +454,28 @@ func parseRuleSliceInfos(cfg *models.Shard) ([]int, map[int]int, Shard, error) {
 		shard := &NumRangeShard{Shards: rs}
 		return subTableIndexs, tableToSlice, shard, nil
 	case DateDayRuleType:
-		subTableIndexs, tableToSlice, err := parseDateDayRuleSliceInfos(cfg.DateRange, cfg.Slices)
+		subTableIndexs, tableToSlice, err := parseDateDayRuleSliceInfos(dateRange, cfg.Slices)
 		if err != nil {
 			return nil, nil, nil, err
 		}
 		shard := &DateDayShard{}
 		return subTableIndexs, tableToSlice, shard, nil
+	case DateWeekRuleType:
+		subTableIndexs, tableToSlice, err := parseDateWeekRuleSliceInfos(dateRange, cfg.Slices)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		shard := &DateWeekShard{}
+		return subTableIndexs, tableToSlice, shard, nil
 	case DateMonthRuleType:
-		subTableIndexs, tableToSlice, err := parseDateMonthRuleSliceInfos(cfg.DateRange, cfg.Slices)
+		subTableIndexs, tableToSlice, err := parseDateMonthRuleSliceInfos(dateRange, cfg.Slices)
 		if err != nil {
 			return nil, nil, nil, err
 		}
 		shard := &DateMonthShard{}
 		return subTableIndexs, tableToSlice, shard, nil
 	case DateYearRuleType:
-		subTableIndexs, tableToSlice, err := parseDateYearRuleSliceInfos(cfg.DateRange, cfg.Slices)
+		subTableIndexs, tableToSlice, err := parseDateYearRuleSliceInfos(dateRange, cfg.Slices)
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -545,6 +630,29 @@ func parseDateMonthRuleSliceInfos(dateRange []string, slices []string) ([]int, m
 	return subTableIndexs, tableToSlice, nil
 }
 
+func parseDateWeekRuleSliceInfos(dateRange []string, slices []string) ([]int, map[int]int, error) {
+	var subTableIndexs []int
+	tableToSlice := make(map[int]int, 0)
+
+	if len(dateRange) != len(slices) {
+		return nil, nil, errors.ErrDateRangeCount
+	}
+	for i := 0; i < len(dateRange); i++ {
+		weekNumbers, err := ParseWeekRange(dateRange[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(subTableIndexs) > 0 && weekNumbers[0] <= subTableIndexs[len(subTableIndexs)-1] {
+			return nil, nil, errors.ErrDateRangeOverlap
+		}
+		for _, v := range weekNumbers {
+			subTableIndexs = append(subTableIndexs, v)
+			tableToSlice[v] = i
+		}
+	}
+	return subTableIndexs, tableToSlice, nil
+}
+
 func parseDateYearRuleSliceInfos(dateRange []string, slices []string) ([]int, map[int]int, error) {
 	var subTableIndexs []int
 	tableToSlice := make(map[int]int, 0)
@@ -458,3 +458,173 @@ func TestParseRule(t *testing.T) {
 		t.Fatal("nil error")
 	}
 }
+
+func TestParseRangeBoundariesRule(t *testing.T) {
+	cfg := &models.Shard{
+		DB:              "gaea",
+		Table:           "test_shard_range",
+		Type:            RangeRuleType,
+		Key:             "id",
+		Locations:       []int{2, 2},
+		Slices:          []string{"slice-0", "slice-1"},
+		RangeBoundaries: []int64{1000000, 2000000, 5000000},
+	}
+	rule, err := parseRule(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key   interface{}
+		index int
+	}{
+		{int64(-1), 0},
+		{int64(0), 0},
+		{int64(999999), 0},
+		{int64(1000000), 1},
+		{"1999999", 1},
+		{uint64(2000000), 2},
+		{int64(5000000), 3},
+		{int64(MaxNumKey - 1), 3},
+	}
+	for _, test := range tests {
+		index, err := rule.FindTableIndex(test.key)
+		if err != nil {
+			t.Fatalf("find table index of %v error: %v", test.key, err)
+		}
+		if index != test.index {
+			t.Errorf("table index of %v not equal, expect: %d, actual: %d", test.key, test.index, index)
+		}
+	}
+
+	if !rule.GetShard().(RangeShard).EqualStart(int64(2000000), 2) {
+		t.Errorf("2000000 should be start of table 2")
+	}
+
+	cfg.RangeBoundaries = []int64{1000000, 1000000, 5000000}
+	if _, err := parseRule(cfg); err == nil {
+		t.Errorf("boundaries not in ascending order should be error")
+	}
+	cfg.RangeBoundaries = []int64{1000000, 2000000}
+	if _, err := parseRule(cfg); err == nil {
+		t.Errorf("count of tables not equal to count of ranges should be error")
+	}
+}
+
+func TestParseDateWeekRule(t *testing.T) {
+	cfg := &models.Shard{
+		DB:        "gaea",
+		Table:     "test_shard_week",
+		Type:      DateWeekRuleType,
+		Key:       "create_time",
+		Slices:    []string{"slice-0", "slice-1"},
+		DateRange: []string{"202051-202052", "202053-202102"},
+	}
+	rule, err := parseRule(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2020 has 53 ISO weeks
+	expectIndexes := []int{202051, 202052, 202053, 202101, 202102}
+	if fmt.Sprint(rule.GetSubTableIndexes()) != fmt.Sprint(expectIndexes) {
+		t.Fatalf("sub table indexes not equal, expect: %v, actual: %v", expectIndexes, rule.GetSubTableIndexes())
+	}
+	if rule.GetSliceIndexFromTableIndex(202053) != 1 {
+		t.Errorf("table 202053 should be in slice-1")
+	}
+
+	tests := []struct {
+		key   interface{}
+		index int
+		start bool
+	}{
+		{"2020-12-31", 202053, false},
+		{"2021-01-04 00:00:00", 202101, true},
+		{"2021-01-10 23:59:59", 202101, false},
+		{"2021-01-11", 202102, true},
+	}
+	for _, test := range tests {
+		index, err := rule.FindTableIndex(test.key)
+		if err != nil {
+			t.Fatalf("find table index of %v error: %v", test.key, err)
+		}
+		if index != test.index {
+			t.Errorf("table index of %v not equal, expect: %d, actual: %d", test.key, test.index, index)
+		}
+		if start := rule.GetShard().(RangeShard).EqualStart(test.key, index); start != test.start {
+			t.Errorf("%v is start of table %d: %v, expect: %v", test.key, index, start, test.start)
+		}
+	}
+
+	cfg.DateRange = []string{"202051-202052", "202054"}
+	if _, err := parseRule(cfg); err == nil {
+		t.Errorf("week 54 should be error")
+	}
+}
+
+func TestParseBroadcastRule(t *testing.T) {
+	cfg := &models.Shard{
+		DB:        "gaea",
+		Table:     "test_broadcast",
+		Type:      BroadcastTableRuleType,
+		Locations: []int{2, 2},
+		Slices:    []string{"slice-0", "slice-1"},
+	}
+	rule, err := parseRule(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.GetType() != GlobalTableRuleType {
+		t.Errorf("broadcast rule should be routed as global rule, type: %s", rule.GetType())
+	}
+	if !rule.IsBroadcastRule() {
+		t.Errorf("rule should be broadcast")
+	}
+	if fmt.Sprint(rule.GetSubTableIndexes()) != fmt.Sprint([]int{0, 1, 2, 3}) {
+		t.Errorf("sub table indexes not equal, actual: %v", rule.GetSubTableIndexes())
+	}
+	if cfg.Type != BroadcastTableRuleType {
+		t.Errorf("config should not be modified")
+	}
+
+	cfg.Type = GlobalTableRuleType
+	rule, err = parseRule(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.IsBroadcastRule() {
+		t.Errorf("global rule should not be broadcast")
+	}
+}
+
+func TestDateShardEqualStart(t *testing.T) {
+	year, month, day := &DateYearShard{}, &DateMonthShard{}, &DateDayShard{}
+	if !year.EqualStart("2016-01-01 00:00:00", 2016) || year.EqualStart("2016-05-01", 2016) {
+		t.Errorf("check start of year error")
+	}
+	if !month.EqualStart("2016-05-01", 201605) || month.EqualStart("2016-05-02", 201605) {
+		t.Errorf("check start of month error")
+	}
+	if !day.EqualStart("2016-05-02", 20160502) || day.EqualStart("2016-05-02 12:00:00", 20160502) {
+		t.Errorf("check start of day error")
+	}
+}
+
+func TestDateShardStringKey(t *testing.T) {
+	year, month, day := &DateYearShard{}, &DateMonthShard{}, &DateDayShard{}
+	// keys are parsed as DATETIME, delimiters, compact format and fractional seconds are supported
+	if index, err := day.FindForKey("2016/5/2 12:00:00.5"); err != nil || index != 20160502 {
+		t.Errorf("find day of key error: %d, %v", index, err)
+	}
+	if index, err := month.FindForKey("20160501"); err != nil || index != 201605 {
+		t.Errorf("find month of key error: %d, %v", index, err)
+	}
+	if day.EqualStart("2016-05-02 00:00:00.5", 20160502) || !month.EqualStart("20160501000000", 201605) {
+		t.Errorf("check start of date with fractional seconds error")
+	}
+	for _, key := range []string{"2016", "2016-13-01", "2016-05-02 25:00:00"} {
+		if _, err := year.FindForKey(key); err == nil {
+			t.Errorf("expect error of invalid date %s", key)
+		}
+	}
+}
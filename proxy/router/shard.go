@@ -41,6 +41,7 @@ import (
 	"time"
 
 	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/mysql"
 	"github.com/XiaoMi/Gaea/util/hack"
 )
 
@@ -217,11 +218,11 @@ func (s *DateYearShard) getNumYear(key interface{}) (int, error) {
 		tm := time.Unix(val, 0)
 		return tm.Year(), nil
 	case string:
-		if v, err := strconv.Atoi(val[:4]); err != nil {
-			return -1, NewInvalidDateFormatKeyError(key)
-		} else {
-			return v, nil
+		t, err := parseDateString(key, val)
+		if err != nil {
+			return -1, err
 		}
+		return t.Year, nil
 	}
 	return -1, NewKeyError("Unexpected key variable type %T", key)
 }
@@ -237,7 +238,9 @@ func (s *DateYearShard) EqualStart(key interface{}, index int) bool {
 		return false
 	}
 
-	return numYear == index
+	return numYear == index && isDateStart(key, func(t time.Time) time.Time {
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	})
 }
 
 type DateMonthShard struct {
@@ -274,15 +277,11 @@ func (s *DateMonthShard) getNumYearMonth(key interface{}) (int, error) {
 		}
 		return yearMonth, nil
 	case string:
-		if len(val) < len(timeFormat) {
-			return -1, NewInvalidDateFormatKeyError(key)
-		}
-		s := val[:4] + val[5:7]
-		if v, err := strconv.Atoi(s); err != nil {
-			return -1, NewInvalidDateFormatKeyError(key)
-		} else {
-			return v, nil
+		t, err := parseDateString(key, val)
+		if err != nil {
+			return -1, err
 		}
+		return t.Year*100 + t.Month, nil
 	}
 	return -1, NewKeyError("Unexpected key variable type %T", key)
 }
@@ -298,7 +297,9 @@ func (s *DateMonthShard) EqualStart(key interface{}, index int) bool {
 		return false
 	}
 
-	return numYear == index
+	return numYear == index && isDateStart(key, func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	})
 }
 
 type DateDayShard struct {
@@ -335,15 +336,11 @@ func (s *DateDayShard) getNumYearMonthDay(key interface{}) (int, error) {
 		}
 		return yearMonthDay, nil
 	case string:
-		if len(val) < len(timeFormat) {
-			return -1, NewInvalidDateFormatKeyError(key)
-		}
-		s := val[:4] + val[5:7] + val[8:10]
-		if v, err := strconv.Atoi(s); err != nil {
-			return -1, NewInvalidDateFormatKeyError(key)
-		} else {
-			return v, nil
+		t, err := parseDateString(key, val)
+		if err != nil {
+			return -1, err
 		}
+		return t.Year*10000 + t.Month*100 + t.Day, nil
 	}
 	return -1, NewKeyError("Unexpected key variable type %T", key)
 }
@@ -359,7 +356,80 @@ func (s *DateDayShard) EqualStart(key interface{}, index int) bool {
 		return false
 	}
 
-	return numYear == index
+	return numYear == index && isDateStart(key, func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	})
+}
+
+type DateWeekShard struct {
+}
+
+func (s *DateWeekShard) getNumYearWeek(key interface{}) (int, error) {
+	tm, err := parseDateKey(key)
+	if err != nil {
+		return -1, err
+	}
+	year, week := tm.ISOWeek()
+	return year*100 + week, nil
+}
+
+// the format of date is: YYYY-MM-DD HH:MM:SS,YYYY-MM-DD or unix timestamp(int), weeks start on Monday
+func (s *DateWeekShard) FindForKey(key interface{}) (int, error) {
+	return s.getNumYearWeek(key)
+}
+
+func (s *DateWeekShard) EqualStart(key interface{}, index int) bool {
+	numWeek, err := s.getNumYearWeek(key)
+	if err != nil {
+		return false
+	}
+
+	return numWeek == index && isDateStart(key, func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	})
+}
+
+// parseDateKey parse key of date shard to time,
+// the format of date is: YYYY-MM-DD HH:MM:SS,YYYY-MM-DD or unix timestamp(int)
+func parseDateKey(key interface{}) (time.Time, error) {
+	switch val := key.(type) {
+	case int:
+		return time.Unix(int64(val), 0), nil
+	case uint64:
+		return time.Unix(int64(val), 0), nil
+	case int64:
+		return time.Unix(val, 0), nil
+	case string:
+		t, err := parseDateString(key, val)
+		if err != nil {
+			return time.Time{}, err
+		}
+		tm, err := t.Time(time.Local)
+		if err != nil {
+			return time.Time{}, NewInvalidDateFormatKeyError(key)
+		}
+		return tm, nil
+	}
+	return time.Time{}, NewKeyError("Unexpected key variable type %T", key)
+}
+
+// parseDateString parse date key of string as DATETIME, fractional seconds are kept
+func parseDateString(key interface{}, val string) (mysql.DateTime, error) {
+	t, err := mysql.ParseDateTime(val, mysql.TypeDatetime, mysql.MaxFsp)
+	if err != nil {
+		return t, NewInvalidDateFormatKeyError(key)
+	}
+	return t, nil
+}
+
+// isDateStart check if the date key is the start time of its sub table,
+// e.g. the sub table is excluded for `create_time < '2016-01-01 00:00:00'` in year shard, but not for '2016-05-01'.
+func isDateStart(key interface{}, start func(t time.Time) time.Time) bool {
+	tm, err := parseDateKey(key)
+	if err != nil {
+		return false
+	}
+	return tm.Equal(start(tm))
 }
 
 type DefaultShard struct {
@@ -0,0 +1,392 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// ExpressionShard calculate sub table index with user defined expression, such as `crc32(user_id) % 8`.
+// The expression supports integer and string literals, the sharding column (or `value`),
+// operators + - * / % with parentheses, and functions in expressionFuncs.
+type ExpressionShard struct {
+	ShardNum int
+	expr     exprNode
+}
+
+// NewExpressionShard constructor of ExpressionShard, the column is the name of the sharding value in expression
+func NewExpressionShard(shardNum int, expression string, column string) (*ExpressionShard, error) {
+	p := &exprParser{input: expression, column: strings.ToLower(column)}
+	expr, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("parse shard expression `%s` error: %v", expression, err)
+	}
+	return &ExpressionShard{ShardNum: shardNum, expr: expr}, nil
+}
+
+// FindForKey return result of the expression, it must be a sub table index
+func (s *ExpressionShard) FindForKey(key interface{}) (int, error) {
+	if b, ok := key.([]byte); ok {
+		key = string(b)
+	}
+	v, err := s.expr.eval(key)
+	if err != nil {
+		return -1, err
+	}
+	index, err := exprInt(v)
+	if err != nil {
+		return -1, err
+	}
+	if index < 0 || index >= int64(s.ShardNum) {
+		return -1, errors.ErrKeyOutOfRange
+	}
+	return int(index), nil
+}
+
+// exprNode value of node is int64 or string
+type exprNode interface {
+	eval(key interface{}) (interface{}, error)
+}
+
+type exprLiteral struct {
+	value interface{}
+}
+
+func (e *exprLiteral) eval(key interface{}) (interface{}, error) {
+	return e.value, nil
+}
+
+type exprKey struct{}
+
+func (e *exprKey) eval(key interface{}) (interface{}, error) {
+	switch val := key.(type) {
+	case int:
+		return int64(val), nil
+	case int64:
+		return val, nil
+	case uint64:
+		return int64(val), nil
+	case string:
+		return val, nil
+	}
+	return nil, NewKeyError("Unexpected key variable type %T", key)
+}
+
+type exprBinary struct {
+	op   byte
+	l, r exprNode
+}
+
+func (e *exprBinary) eval(key interface{}) (interface{}, error) {
+	lv, err := e.l.eval(key)
+	if err != nil {
+		return nil, err
+	}
+	rv, err := e.r.eval(key)
+	if err != nil {
+		return nil, err
+	}
+	l, err := exprInt(lv)
+	if err != nil {
+		return nil, err
+	}
+	r, err := exprInt(rv)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	case '/', '%':
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if e.op == '/' {
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+	return nil, fmt.Errorf("unknown operator %c", e.op)
+}
+
+type exprFunc struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []exprNode
+}
+
+func (e *exprFunc) eval(key interface{}) (interface{}, error) {
+	args := make([]interface{}, 0, len(e.args))
+	for _, a := range e.args {
+		v, err := a.eval(key)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return e.fn(args)
+}
+
+// expressionFuncs functions supported in shard expression, name -> (min args, max args, function)
+var expressionFuncs = map[string]struct {
+	minArgs, maxArgs int
+	fn               func(args []interface{}) (interface{}, error)
+}{
+	"abs": {1, 1, func(args []interface{}) (interface{}, error) {
+		v, err := exprInt(args[0])
+		return hack.Abs(v), err
+	}},
+	// crc32 checksum of the value as string
+	"crc32": {1, 1, func(args []interface{}) (interface{}, error) {
+		return int64(crc32.ChecksumIEEE([]byte(exprString(args[0])))), nil
+	}},
+	// the same as hash shard
+	"hash": {1, 1, func(args []interface{}) (interface{}, error) {
+		return int64(HashValue(args[0]) & (1<<63 - 1)), nil
+	}},
+	"int": {1, 1, func(args []interface{}) (interface{}, error) {
+		return exprInt(args[0])
+	}},
+	"length": {1, 1, func(args []interface{}) (interface{}, error) {
+		return int64(len(exprString(args[0]))), nil
+	}},
+	// substr(str, pos[, len]), pos starts from 1, negative pos counts from the end, the same as mysql
+	"substr": {2, 3, func(args []interface{}) (interface{}, error) {
+		s := exprString(args[0])
+		pos, err := exprInt(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if pos < 0 {
+			pos = int64(len(s)) + pos + 1
+		}
+		if pos < 1 || pos > int64(len(s)) {
+			return "", nil
+		}
+		s = s[pos-1:]
+		if len(args) == 3 {
+			n, err := exprInt(args[2])
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 {
+				n = 0
+			}
+			if n < int64(len(s)) {
+				s = s[:n]
+			}
+		}
+		return s, nil
+	}},
+}
+
+func exprInt(v interface{}) (int64, error) {
+	switch val := v.(type) {
+	case int64:
+		return val, nil
+	case string:
+		i, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid num format %s", val)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("unexpected value type %T", v)
+}
+
+func exprString(v interface{}) string {
+	if i, ok := v.(int64); ok {
+		return strconv.FormatInt(i, 10)
+	}
+	return v.(string)
+}
+
+// exprParser recursive descent parser of shard expression,
+// operators * / % have higher precedence than + -, and all of them are left associative.
+type exprParser struct {
+	input  string
+	pos    int
+	column string
+}
+
+func (p *exprParser) parse() (exprNode, error) {
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	return e, nil
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept skip spaces and consume c if it's the next character
+func (p *exprParser) accept(c byte) bool {
+	p.skipSpaces()
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseExpr() (exprNode, error) {
+	l, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op byte
+		if p.accept('+') {
+			op = '+'
+		} else if p.accept('-') {
+			op = '-'
+		} else {
+			return l, nil
+		}
+		r, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		l = &exprBinary{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseTerm() (exprNode, error) {
+	l, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op byte
+		if p.accept('*') {
+			op = '*'
+		} else if p.accept('/') {
+			op = '/'
+		} else if p.accept('%') {
+			op = '%'
+		} else {
+			return l, nil
+		}
+		r, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		l = &exprBinary{op: op, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseFactor() (exprNode, error) {
+	if p.accept('-') {
+		e, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &exprBinary{op: '-', l: &exprLiteral{value: int64(0)}, r: e}, nil
+	}
+	if p.accept('(') {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos)
+		}
+		return e, nil
+	}
+
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	start := p.pos
+	c := p.input[p.pos]
+	switch {
+	case c == '\'' || c == '"':
+		end := strings.IndexByte(p.input[start+1:], c)
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated string at position %d", start)
+		}
+		p.pos = start + end + 2
+		return &exprLiteral{value: p.input[start+1 : start+1+end]}, nil
+	case c >= '0' && c <= '9':
+		for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+		}
+		v, err := strconv.ParseInt(p.input[start:p.pos], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &exprLiteral{value: v}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.input) && (p.input[p.pos] == '_' || unicode.IsLetter(rune(p.input[p.pos])) || unicode.IsDigit(rune(p.input[p.pos]))) {
+			p.pos++
+		}
+		name := strings.ToLower(p.input[start:p.pos])
+		if p.accept('(') {
+			return p.parseFunc(name)
+		}
+		if name != "value" && name != p.column {
+			return nil, fmt.Errorf("unknown identifier %s, only sharding column or value is allowed", name)
+		}
+		return &exprKey{}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", c, start)
+}
+
+func (p *exprParser) parseFunc(name string) (exprNode, error) {
+	f, ok := expressionFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	var args []exprNode
+	if !p.accept(')') {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(')') {
+				break
+			}
+			if !p.accept(',') {
+				return nil, fmt.Errorf("missing ) of function %s at position %d", name, p.pos)
+			}
+		}
+	}
+	if len(args) < f.minArgs || len(args) > f.maxArgs {
+		return nil, fmt.Errorf("invalid argument count of function %s: %d", name, len(args))
+	}
+	return &exprFunc{name: name, fn: f.fn, args: args}, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"hash/crc32"
+	"testing"
+)
+
+func TestExpressionShard(t *testing.T) {
+	tests := []struct {
+		expr  string
+		key   interface{}
+		index int
+	}{
+		{"id % 4", int64(7), 3},
+		{"value % 4", "10", 2},
+		{"(id / 100) % 4 + 1", uint64(250), 3},
+		{"abs(-ID) % 4", 5, 1},
+		{"-2 + 3 * 2", int64(0), 4},
+		{"crc32(id) % 8", "abc", int(crc32.ChecksumIEEE([]byte("abc")) % 8)},
+		{"hash(id) % 8", int64(13), 5},
+		{"int(substr(id, 1, 2)) % 8", "1523456", 7},
+		{"int(substr(id, -2)) % 8", []byte("2024010"), 2},
+		{"length('abc') + length(id)", "de", 5},
+	}
+	for _, test := range tests {
+		s, err := NewExpressionShard(8, test.expr, "id")
+		if err != nil {
+			t.Fatalf("create expression shard %s error: %v", test.expr, err)
+		}
+		index, err := s.FindForKey(test.key)
+		if err != nil {
+			t.Errorf("find key %v with %s error: %v", test.key, test.expr, err)
+			continue
+		}
+		if index != test.index {
+			t.Errorf("find key %v with %s error, expect: %d, actual: %d", test.key, test.expr, test.index, index)
+		}
+	}
+}
+
+func TestExpressionShardError(t *testing.T) {
+	invalidExprs := []string{"", "id %", "(id % 4", "user_id % 4", "md5(id)", "substr(id)", "id % 4 )", "'abc"}
+	for _, expr := range invalidExprs {
+		if _, err := NewExpressionShard(4, expr, "id"); err == nil {
+			t.Errorf("create expression shard %s should fail", expr)
+		}
+	}
+
+	s, err := NewExpressionShard(4, "id + 4", "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.FindForKey(int64(1)); err == nil {
+		t.Errorf("index out of range should fail")
+	}
+	if _, err := s.FindForKey("abc"); err == nil {
+		t.Errorf("invalid num should fail")
+	}
+
+	s, _ = NewExpressionShard(4, "id % 0", "id")
+	if _, err := s.FindForKey(int64(1)); err == nil {
+		t.Errorf("division by zero should fail")
+	}
+}
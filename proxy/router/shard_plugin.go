@@ -0,0 +1,117 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"plugin"
+	"sync"
+
+	"github.com/XiaoMi/Gaea/core/errors"
+)
+
+// PluginSymbolName name of the function exported by sharding strategy plugin, its type must be ShardingStrategyFactory
+const PluginSymbolName = "NewShardingStrategy"
+
+// ShardingStrategyFactory create the function calculating sub table index of sharding value.
+// shardNum is the count of sub tables, params is plugin_params of the shard rule.
+// Only builtin types are used, so plugins don't need to import gaea, and the strategy
+// can be implemented in any way, e.g. calling a remote sharding service.
+type ShardingStrategyFactory func(shardNum int, params map[string]string) (func(key interface{}) (int, error), error)
+
+var (
+	strategyLock       sync.Mutex
+	shardingStrategies = make(map[string]ShardingStrategyFactory)
+)
+
+// RegisterShardingStrategy register sharding strategy compiled into proxy, it can be used as plugin name of shard rule
+func RegisterShardingStrategy(name string, factory ShardingStrategyFactory) {
+	strategyLock.Lock()
+	defer strategyLock.Unlock()
+	shardingStrategies[name] = factory
+}
+
+// getShardingStrategy return registered sharding strategy, or load it from go plugin file,
+// plugins are cached since a go plugin can not be unloaded.
+func getShardingStrategy(name string) (ShardingStrategyFactory, error) {
+	strategyLock.Lock()
+	defer strategyLock.Unlock()
+
+	if factory, ok := shardingStrategies[name]; ok {
+		return factory, nil
+	}
+
+	p, err := plugin.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open sharding plugin %s error: %v", name, err)
+	}
+	sym, err := p.Lookup(PluginSymbolName)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s in sharding plugin %s error: %v", PluginSymbolName, name, err)
+	}
+
+	var factory ShardingStrategyFactory
+	switch f := sym.(type) {
+	case func(int, map[string]string) (func(interface{}) (int, error), error):
+		factory = f
+	case *ShardingStrategyFactory:
+		factory = *f
+	default:
+		return nil, fmt.Errorf("invalid type of %s in sharding plugin %s: %T", PluginSymbolName, name, sym)
+	}
+	shardingStrategies[name] = factory
+	return factory, nil
+}
+
+// PluginShard calculate sub table index with sharding strategy registered or loaded from go plugin
+type PluginShard struct {
+	ShardNum int
+	name     string
+	find     func(key interface{}) (int, error)
+}
+
+// NewPluginShard constructor of PluginShard
+func NewPluginShard(shardNum int, name string, params map[string]string) (*PluginShard, error) {
+	factory, err := getShardingStrategy(name)
+	if err != nil {
+		return nil, err
+	}
+	find, err := factory(shardNum, params)
+	if err != nil {
+		return nil, fmt.Errorf("create sharding strategy %s error: %v", name, err)
+	}
+	return &PluginShard{ShardNum: shardNum, name: name, find: find}, nil
+}
+
+// FindForKey return sub table index calculated by the strategy, panic of the strategy is returned as error
+func (s *PluginShard) FindForKey(key interface{}) (index int, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			index, err = -1, fmt.Errorf("sharding strategy %s panic: %v", s.name, e)
+		}
+	}()
+
+	if b, ok := key.([]byte); ok {
+		key = string(b)
+	}
+	index, err = s.find(key)
+	if err != nil {
+		return -1, err
+	}
+	if index < 0 || index >= s.ShardNum {
+		return -1, errors.ErrKeyOutOfRange
+	}
+	return index, nil
+}
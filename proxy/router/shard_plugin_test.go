@@ -0,0 +1,57 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestPluginShard(t *testing.T) {
+	RegisterShardingStrategy("test_prefix", func(shardNum int, params map[string]string) (func(key interface{}) (int, error), error) {
+		length, err := strconv.Atoi(params["prefix_length"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix_length: %v", err)
+		}
+		return func(key interface{}) (int, error) {
+			s := key.(string)
+			v, err := strconv.Atoi(s[:length])
+			if err != nil {
+				return -1, err
+			}
+			return v % shardNum, nil
+		}, nil
+	})
+
+	s, err := NewPluginShard(4, "test_prefix", map[string]string{"prefix_length": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := s.FindForKey([]byte("1500"))
+	if err != nil || index != 3 {
+		t.Errorf("find key error, expect: 3, actual: %d, err: %v", index, err)
+	}
+	if _, err := s.FindForKey("a"); err == nil {
+		t.Errorf("panic of strategy should be returned as error")
+	}
+
+	if _, err := NewPluginShard(4, "test_prefix", nil); err == nil {
+		t.Errorf("create strategy with invalid params should fail")
+	}
+	if _, err := NewPluginShard(4, "/not/exist/strategy.so", nil); err == nil {
+		t.Errorf("open not exist plugin should fail")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// Column column definition of logical table, fetched from information_schema.COLUMNS of backend
+type Column struct {
+	Name          string
+	DataType      string // DATA_TYPE, such as int, varchar
+	ColumnType    string // COLUMN_TYPE, such as int(10) unsigned, varchar(64)
+	Nullable      bool
+	AutoIncrement bool // EXTRA contains auto_increment
+}
+
+// IsUnsigned check if the column is unsigned number
+func (c *Column) IsUnsigned() bool {
+	return strings.Contains(strings.ToLower(c.ColumnType), "unsigned")
+}
+
+// IsInteger check if the column is integer type
+func (c *Column) IsInteger() bool {
+	switch strings.ToLower(c.DataType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+	return false
+}
+
+// IsString check if the column is string type
+func (c *Column) IsString() bool {
+	switch strings.ToLower(c.DataType) {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set":
+		return true
+	}
+	return false
+}
+
+// FieldType return mysql field type of the column used in column definition packet
+func (c *Column) FieldType() byte {
+	switch strings.ToLower(c.DataType) {
+	case "tinyint":
+		return mysql.TypeTiny
+	case "smallint":
+		return mysql.TypeShort
+	case "mediumint":
+		return mysql.TypeInt24
+	case "int", "integer":
+		return mysql.TypeLong
+	case "bigint":
+		return mysql.TypeLonglong
+	case "float":
+		return mysql.TypeFloat
+	case "double", "real":
+		return mysql.TypeDouble
+	case "decimal", "numeric":
+		return mysql.TypeNewDecimal
+	case "bit":
+		return mysql.TypeBit
+	case "date":
+		return mysql.TypeDate
+	case "time":
+		return mysql.TypeDuration
+	case "datetime":
+		return mysql.TypeDatetime
+	case "timestamp":
+		return mysql.TypeTimestamp
+	case "year":
+		return mysql.TypeYear
+	case "char", "binary", "enum", "set":
+		return mysql.TypeString
+	case "tinytext", "tinyblob", "text", "blob", "mediumtext", "mediumblob", "longtext", "longblob":
+		return mysql.TypeBlob
+	case "json":
+		return mysql.TypeJSON
+	case "geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection":
+		return mysql.TypeGeometry
+	default:
+		return mysql.TypeVarString
+	}
+}
+
+// ConvertValue check the value compared with the column and convert it to the column type,
+// so that the same value is always routed to the same sub table, e.g. '10' and 10 of integer column.
+func (c *Column) ConvertValue(value interface{}) (interface{}, error) {
+	switch {
+	case c.IsInteger():
+		return c.convertIntegerValue(value)
+	case c.IsString():
+		switch v := value.(type) {
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case uint64:
+			return strconv.FormatUint(v, 10), nil
+		case int:
+			return strconv.Itoa(v), nil
+		case []byte:
+			return string(v), nil
+		}
+	}
+	return value, nil
+}
+
+func (c *Column) convertIntegerValue(value interface{}) (interface{}, error) {
+	var str string
+	switch v := value.(type) {
+	case int, int64, uint64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) {
+			return nil, fmt.Errorf("value %v of integer column %s is not integer", v, c.Name)
+		}
+		return int64(v), nil
+	case string:
+		str = v
+	case []byte:
+		str = hack.String(v)
+	default:
+		return value, nil
+	}
+
+	str = strings.TrimSpace(str)
+	if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(str, 10, 64); err == nil {
+		return u, nil
+	}
+	return nil, fmt.Errorf("value '%s' of integer column %s is not integer", str, c.Name)
+}
+
+// Table columns of logical table, in order of ORDINAL_POSITION
+type Table struct {
+	Columns []*Column
+	index   map[string]int // key: lower case column name
+}
+
+// NewTable create table with columns
+func NewTable(columns []*Column) *Table {
+	t := &Table{Columns: columns, index: make(map[string]int, len(columns))}
+	for i, c := range columns {
+		t.index[strings.ToLower(c.Name)] = i
+	}
+	return t
+}
+
+// GetColumn return column by name, case insensitive
+func (t *Table) GetColumn(name string) (*Column, bool) {
+	i, ok := t.index[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	return t.Columns[i], true
+}
+
+// AutoIncrementColumn return index of the auto increment column, ok is false if the table has no auto increment column
+func (t *Table) AutoIncrementColumn() (int, bool) {
+	for i, c := range t.Columns {
+		if c.AutoIncrement {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Schema column definitions of logical tables of a namespace, key is logical db and table name
+type Schema struct {
+	sync.RWMutex
+	tables map[string]map[string]*Table
+}
+
+// NewSchema create empty schema
+func NewSchema() *Schema {
+	return &Schema{tables: make(map[string]map[string]*Table)}
+}
+
+// GetTable return table of logical db, ok is false if the table is not loaded
+func (s *Schema) GetTable(db, table string) (*Table, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	t, ok := s.tables[db][strings.ToLower(table)]
+	return t, ok
+}
+
+// GetColumn return column of logical table
+func (s *Schema) GetColumn(db, table, column string) (*Column, bool) {
+	t, ok := s.GetTable(db, table)
+	if !ok {
+		return nil, false
+	}
+	return t.GetColumn(column)
+}
+
+// SetTables replace all the tables of logical db, key of tables is table name, return true if any table is changed
+func (s *Schema) SetTables(db string, tables map[string]*Table) bool {
+	m := make(map[string]*Table, len(tables))
+	for name, t := range tables {
+		m[strings.ToLower(name)] = t
+	}
+	s.Lock()
+	defer s.Unlock()
+	changed := !reflect.DeepEqual(s.tables[db], m)
+	s.tables[db] = m
+	return changed
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+func TestColumnConvertValue(t *testing.T) {
+	intColumn := &Column{Name: "id", DataType: "int", ColumnType: "int(11)"}
+	strColumn := &Column{Name: "name", DataType: "varchar", ColumnType: "varchar(64)"}
+	dateColumn := &Column{Name: "create_time", DataType: "datetime", ColumnType: "datetime"}
+
+	tests := []struct {
+		column *Column
+		value  interface{}
+		expect interface{}
+		hasErr bool
+	}{
+		{intColumn, int64(10), int64(10), false},
+		{intColumn, "10", int64(10), false},
+		{intColumn, []byte(" 10 "), int64(10), false},
+		{intColumn, "18446744073709551615", uint64(18446744073709551615), false},
+		{intColumn, float64(3), int64(3), false},
+		{intColumn, float64(3.5), nil, true},
+		{intColumn, "abc", nil, true},
+		{strColumn, int64(10), "10", false},
+		{strColumn, uint64(10), "10", false},
+		{strColumn, []byte("abc"), "abc", false},
+		{dateColumn, "2020-01-01", "2020-01-01", false},
+	}
+	for _, test := range tests {
+		actual, err := test.column.ConvertValue(test.value)
+		if test.hasErr {
+			if err == nil {
+				t.Errorf("expect error, column: %s, value: %v", test.column.Name, test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("convert value error, column: %s, value: %v, err: %v", test.column.Name, test.value, err)
+			continue
+		}
+		if actual != test.expect {
+			t.Errorf("not equal, column: %s, value: %v, expect: %v(%T), actual: %v(%T)",
+				test.column.Name, test.value, test.expect, test.expect, actual, actual)
+		}
+	}
+}
+
+func TestColumnFieldType(t *testing.T) {
+	tests := []struct {
+		dataType string
+		expect   byte
+	}{
+		{"int", mysql.TypeLong},
+		{"BIGINT", mysql.TypeLonglong},
+		{"varchar", mysql.TypeVarString},
+		{"char", mysql.TypeString},
+		{"text", mysql.TypeBlob},
+		{"decimal", mysql.TypeNewDecimal},
+		{"datetime", mysql.TypeDatetime},
+	}
+	for _, test := range tests {
+		c := &Column{DataType: test.dataType}
+		if actual := c.FieldType(); actual != test.expect {
+			t.Errorf("not equal, data type: %s, expect: %d, actual: %d", test.dataType, test.expect, actual)
+		}
+	}
+}
+
+func TestSchemaSetTables(t *testing.T) {
+	s := NewSchema()
+	newTables := func() map[string]*Table {
+		return map[string]*Table{
+			"Tbl_A": NewTable([]*Column{{Name: "ID", DataType: "int"}}),
+		}
+	}
+
+	if !s.SetTables("db", newTables()) {
+		t.Errorf("expect changed in the first load")
+	}
+	if s.SetTables("db", newTables()) {
+		t.Errorf("expect not changed when tables are the same")
+	}
+
+	c, ok := s.GetColumn("db", "tbl_a", "id")
+	if !ok || c.Name != "ID" {
+		t.Errorf("column not found, ok: %v, column: %v", ok, c)
+	}
+	if _, ok := s.GetTable("db", "tbl_b"); ok {
+		t.Errorf("expect tbl_b not found")
+	}
+
+	if !s.SetTables("db", nil) {
+		t.Errorf("expect changed when tables are removed")
+	}
+	if _, ok := s.GetTable("db", "tbl_a"); ok {
+		t.Errorf("expect tbl_a not found after removed")
+	}
+}
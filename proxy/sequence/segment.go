@@ -0,0 +1,165 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequence
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/client"
+)
+
+const (
+	// DefaultSegmentStep default count of sequence numbers allocated once
+	DefaultSegmentStep int64 = 1000
+
+	defaultEtcdSequenceTimeout = 3 * time.Second
+	maxSegmentAllocateRetry    = 10
+)
+
+// SegmentAllocator allocate segment of sequence numbers atomically,
+// return the max sequence number allocated before, the segment is (max, max + step]
+type SegmentAllocator interface {
+	Allocate(key string, step int64) (int64, error)
+}
+
+// SegmentSequence sequence numbers are allocated from SegmentAllocator segment by segment,
+// numbers left in the segment are discarded when proxy stops.
+type SegmentSequence struct {
+	allocator SegmentAllocator
+	key       string
+	pkName    string
+	step      int64
+
+	lock sync.Mutex
+	curr int64
+	max  int64
+}
+
+// NewSegmentSequence constructor of SegmentSequence
+func NewSegmentSequence(allocator SegmentAllocator, key, pkName string, step int64) *SegmentSequence {
+	if step <= 0 {
+		step = DefaultSegmentStep
+	}
+	return &SegmentSequence{
+		allocator: allocator,
+		key:       key,
+		pkName:    pkName,
+		step:      step,
+	}
+}
+
+// NextSeq get next sequence number
+func (s *SegmentSequence) NextSeq() (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.curr >= s.max {
+		start, err := s.allocator.Allocate(s.key, s.step)
+		if err != nil {
+			return 0, fmt.Errorf("allocate segment of sequence %s error: %v", s.key, err)
+		}
+		s.curr = start
+		s.max = start + s.step
+	}
+	s.curr++
+	return s.curr, nil
+}
+
+// GetPKName return sequence column
+func (s *SegmentSequence) GetPKName() string {
+	return s.pkName
+}
+
+// EtcdSegmentAllocator store max allocated sequence number in etcd, segments are allocated by compare and swap,
+// so proxies of the same cluster can share one sequence.
+type EtcdSegmentAllocator struct {
+	kapi    client.KeysAPI
+	root    string
+	timeout time.Duration
+}
+
+// NewEtcdSegmentAllocator constructor of EtcdSegmentAllocator, sequences are stored under root
+func NewEtcdSegmentAllocator(addr, username, password, root string) (*EtcdSegmentAllocator, error) {
+	endpoints := strings.Split(addr, ",")
+	for i, s := range endpoints {
+		if s != "" && !strings.HasPrefix(s, "http://") {
+			endpoints[i] = "http://" + s
+		}
+	}
+	c, err := client.New(client.Config{
+		Endpoints:               endpoints,
+		Transport:               client.DefaultTransport,
+		Username:                username,
+		Password:                password,
+		HeaderTimeoutPerRequest: defaultEtcdSequenceTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdSegmentAllocator{
+		kapi:    client.NewKeysAPI(c),
+		root:    root,
+		timeout: defaultEtcdSequenceTimeout,
+	}, nil
+}
+
+// Allocate implement SegmentAllocator
+func (a *EtcdSegmentAllocator) Allocate(key string, step int64) (int64, error) {
+	p := path.Join(a.root, key)
+	for i := 0; i < maxSegmentAllocateRetry; i++ {
+		start, err := a.tryAllocate(p, step)
+		if err == nil {
+			return start, nil
+		}
+		if e, ok := err.(client.Error); ok && (e.Code == client.ErrorCodeTestFailed || e.Code == client.ErrorCodeNodeExist) {
+			// allocated by other proxy concurrently, retry
+			continue
+		}
+		return 0, err
+	}
+	return 0, fmt.Errorf("too many conflicts when allocating segment of %s", p)
+}
+
+func (a *EtcdSegmentAllocator) tryAllocate(p string, step int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+
+	resp, err := a.kapi.Get(ctx, p, nil)
+	if err != nil {
+		if e, ok := err.(client.Error); !ok || e.Code != client.ErrorCodeKeyNotFound {
+			return 0, err
+		}
+		opts := &client.SetOptions{PrevExist: client.PrevNoExist}
+		if _, err := a.kapi.Set(ctx, p, strconv.FormatInt(step, 10), opts); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	max, err := strconv.ParseInt(resp.Node.Value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sequence value %s of %s", resp.Node.Value, p)
+	}
+	opts := &client.SetOptions{PrevIndex: resp.Node.ModifiedIndex}
+	if _, err := a.kapi.Set(ctx, p, strconv.FormatInt(max+step, 10), opts); err != nil {
+		return 0, err
+	}
+	return max, nil
+}
@@ -14,7 +14,10 @@
 
 package sequence
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 // Sequence is interface of global sequences with different types
 type Sequence interface {
@@ -52,3 +55,32 @@ func (s *SequenceManager) GetSequence(db, table string) (Sequence, bool) {
 	seq, ok := dbSeq[table]
 	return seq, ok
 }
+
+var (
+	proxyLock         sync.RWMutex
+	snowflakeWorkerID int64
+	segmentAllocator  SegmentAllocator
+)
+
+// Init set worker id of snowflake sequences and allocator of etcd sequences, which are shared by all namespaces
+// of the proxy. It must be called before namespaces are created, allocator is nil if proxy doesn't use etcd.
+func Init(workerID int64, allocator SegmentAllocator) {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	snowflakeWorkerID = workerID
+	segmentAllocator = allocator
+}
+
+// GetSnowflakeWorkerID return worker id of snowflake sequences
+func GetSnowflakeWorkerID() int64 {
+	proxyLock.RLock()
+	defer proxyLock.RUnlock()
+	return snowflakeWorkerID
+}
+
+// GetSegmentAllocator return allocator of etcd sequences, nil means not available
+func GetSegmentAllocator() SegmentAllocator {
+	proxyLock.RLock()
+	defer proxyLock.RUnlock()
+	return segmentAllocator
+}
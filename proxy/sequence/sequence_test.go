@@ -0,0 +1,100 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequence
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSnowflakeSequence(t *testing.T) {
+	if _, err := NewSnowflakeSequence("id", MaxSnowflakeWorkerID+1); err == nil {
+		t.Fatalf("expect error of invalid worker id")
+	}
+
+	s, err := NewSnowflakeSequence("id", 5)
+	if err != nil {
+		t.Fatalf("create snowflake sequence error: %v", err)
+	}
+	now := snowflakeEpoch + 1000
+	s.now = func() int64 { return now }
+
+	id1, _ := s.NextSeq()
+	id2, _ := s.NextSeq()
+	if id1 != 1000<<22|5<<12 || id2 != id1+1 {
+		t.Errorf("unexpected ids in the same millisecond: %d, %d", id1, id2)
+	}
+
+	// small clock backward uses last timestamp
+	now -= 5
+	id3, err := s.NextSeq()
+	if err != nil || id3 != id2+1 {
+		t.Errorf("unexpected id after clock moved backwards: %d, %v", id3, err)
+	}
+
+	now -= 100
+	if _, err := s.NextSeq(); err == nil {
+		t.Errorf("expect error when clock moved backwards too much")
+	}
+
+	now += 200
+	id4, _ := s.NextSeq()
+	if id4 != 1095<<22|5<<12 {
+		t.Errorf("sequence number is not reset in new millisecond: %d", id4)
+	}
+}
+
+type memorySegmentAllocator struct {
+	values map[string]int64
+	err    error
+}
+
+func (a *memorySegmentAllocator) Allocate(key string, step int64) (int64, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	max := a.values[key]
+	a.values[key] = max + step
+	return max, nil
+}
+
+func TestSegmentSequence(t *testing.T) {
+	allocator := &memorySegmentAllocator{values: make(map[string]int64)}
+	s1 := NewSegmentSequence(allocator, "ns/db.tbl", "id", 3)
+	s2 := NewSegmentSequence(allocator, "ns/db.tbl", "id", 3)
+
+	var ids []int64
+	for _, s := range []*SegmentSequence{s1, s1, s2, s1, s1, s2} {
+		id, err := s.NextSeq()
+		if err != nil {
+			t.Fatalf("next seq error: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	expect := []int64{1, 2, 4, 3, 7, 5}
+	for i := range expect {
+		if ids[i] != expect[i] {
+			t.Fatalf("ids not equal, expect: %v, actual: %v", expect, ids)
+		}
+	}
+
+	allocator.err = errors.New("etcd unavailable")
+	if _, err := s2.NextSeq(); err != nil {
+		t.Errorf("segment is not used up, but got error: %v", err)
+	}
+	if _, err := s2.NextSeq(); err == nil {
+		t.Errorf("expect error when allocating segment failed")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sequence
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeEpoch        int64 = 1546300800000 // 2019-01-01 00:00:00 UTC, in milliseconds
+	snowflakeWorkerBits         = 10
+	snowflakeSequenceBits       = 12
+	snowflakeSequenceMask       = 1<<snowflakeSequenceBits - 1
+
+	// MaxSnowflakeWorkerID max worker id of snowflake sequence
+	MaxSnowflakeWorkerID = 1<<snowflakeWorkerBits - 1
+
+	// 时钟回拨不超过该值时, 继续使用上次的时间戳生成序列号, 否则返回错误
+	maxSnowflakeClockBackward int64 = 10
+)
+
+// SnowflakeSequence generate ids composed of timestamp in milliseconds (41 bits), worker id (10 bits)
+// and sequence number in the same millisecond (12 bits), ids are increasing in one proxy,
+// and unique in cluster if each proxy has different worker id.
+type SnowflakeSequence struct {
+	pkName   string
+	workerID int64
+
+	lock     sync.Mutex
+	lastTime int64
+	seq      int64
+	now      func() int64
+}
+
+// NewSnowflakeSequence constructor of SnowflakeSequence
+func NewSnowflakeSequence(pkName string, workerID int64) (*SnowflakeSequence, error) {
+	if workerID < 0 || workerID > MaxSnowflakeWorkerID {
+		return nil, fmt.Errorf("invalid snowflake worker id %d, must be in [0, %d]", workerID, MaxSnowflakeWorkerID)
+	}
+	return &SnowflakeSequence{
+		pkName:   pkName,
+		workerID: workerID,
+		now: func() int64 {
+			return time.Now().UnixNano() / int64(time.Millisecond)
+		},
+	}, nil
+}
+
+// NextSeq get next sequence number
+func (s *SnowflakeSequence) NextSeq() (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	if now < s.lastTime {
+		if s.lastTime-now > maxSnowflakeClockBackward {
+			return 0, fmt.Errorf("clock moved backwards %d ms", s.lastTime-now)
+		}
+		now = s.lastTime
+	}
+
+	if now == s.lastTime {
+		s.seq = (s.seq + 1) & snowflakeSequenceMask
+		if s.seq == 0 {
+			// sequence numbers of this millisecond are used up, wait for next millisecond
+			for now <= s.lastTime {
+				time.Sleep(100 * time.Microsecond)
+				now = s.now()
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	s.lastTime = now
+
+	return (now-snowflakeEpoch)<<(snowflakeWorkerBits+snowflakeSequenceBits) | s.workerID<<snowflakeSequenceBits | s.seq, nil
+}
+
+// GetPKName return sequence column
+func (s *SnowflakeSequence) GetPKName() string {
+	return s.pkName
+}
@@ -16,6 +16,7 @@ package server
 
 import (
 	"fmt"
+	"github.com/XiaoMi/Gaea/config"
 	"github.com/XiaoMi/Gaea/logging"
 	"github.com/XiaoMi/Gaea/provider"
 	"net"
@@ -23,11 +24,15 @@ import (
 	"net/http/pprof"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/failpoint"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 )
@@ -36,6 +41,9 @@ var log = logging.GetLogger("sharding-admin")
 
 const (
 	selfDefinedInternalError = 800
+
+	healthCheckTimeout       = 3 * time.Second
+	defaultMaxReplicationLag = 10 // unit: second
 )
 
 // SQLFingerprint parser fingerprint
@@ -58,10 +66,14 @@ type AdminServer struct {
 	engine        *gin.Engine
 
 	configType          string
+	fileConfigPath      string
 	coordinatorAddr     string
 	coordinatorUsername string
 	coordinatorPassword string
 	coordinatorRoot     string
+	watchClient         config.SourceProvider // client watching namespaces in consul
+
+	applyLock sync.Mutex // prepare and commit of namespace applied by this proxy are not interleaved
 }
 
 // NewAdminServer create new admin server
@@ -85,6 +97,7 @@ func NewAdminServer(proxy *Server, cfg *models.Proxy) (*AdminServer, error) {
 	s.adminUser = cfg.AdminUser
 	s.adminPassword = cfg.AdminPassword
 	s.configType = cfg.ConfigType
+	s.fileConfigPath = cfg.FileConfigPath
 	s.coordinatorAddr = cfg.CoordinatorAddr
 	s.coordinatorUsername = cfg.UserName
 	s.coordinatorPassword = cfg.Password
@@ -97,6 +110,8 @@ func NewAdminServer(proxy *Server, cfg *models.Proxy) (*AdminServer, error) {
 	}
 	s.listener = l
 	s.registerURL()
+	s.registerQuery()
+	s.registerHealth()
 	s.registerMetric()
 	s.registerProf()
 
@@ -109,6 +124,7 @@ func NewAdminServer(proxy *Server, cfg *models.Proxy) (*AdminServer, error) {
 	if err = s.registerProxy(); err != nil {
 		return nil, err
 	}
+	s.startNamespaceWatcher()
 
 	log.Infof("[server] NewAdminServer, Api Server running, netProto: http, addr: %s", cfg.AdminAddr)
 	return s, nil
@@ -137,6 +153,9 @@ func (s *AdminServer) Run() {
 // Close close admin server
 func (s *AdminServer) Close() error {
 	close(s.exit.C)
+	if s.watchClient != nil {
+		s.watchClient.Close()
+	}
 	if err := s.unregisterProxy(); err != nil {
 		log.Fatalf("unregister proxy failed, %v", err)
 		return err
@@ -150,12 +169,32 @@ func (s *AdminServer) registerURL() {
 	adminGroup.PUT("/source/prepare/:name", s.prepareConfig)
 	adminGroup.PUT("/source/commit/:name", s.commitConfig)
 	adminGroup.PUT("/namespace/delete/:name", s.deleteNamespace)
+	adminGroup.PUT("/namespace/diff", s.diffNamespace)
+	adminGroup.PUT("/namespace/apply", s.applyNamespace)
 	adminGroup.GET("/source/fingerprint", s.configFingerprint)
 
 	adminGroup.GET("/stats/sessionsqlfingerprint/:namespace", s.getNamespaceSessionSQLFingerprint)
 	adminGroup.GET("/stats/backendsqlfingerprint/:namespace", s.getNamespaceBackendSQLFingerprint)
 	adminGroup.DELETE("/stats/sessionsqlfingerprint/:namespace", s.clearNamespaceSessionSQLFingerprint)
 	adminGroup.DELETE("/stats/backendsqlfingerprint/:namespace", s.clearNamespaceBackendSQLFingerprint)
+	adminGroup.DELETE("/stats/sqlstats/:namespace", s.clearNamespaceSQLStats)
+
+	adminGroup.GET("/failpoint", s.listFailpoints)
+	adminGroup.PUT("/failpoint/:name", s.enableFailpoint)
+	adminGroup.DELETE("/failpoint/:name", s.disableFailpoint)
+	adminGroup.DELETE("/failpoint", s.disableAllFailpoints)
+
+	adminGroup.GET("/log/level", s.getLogLevels)
+	adminGroup.PUT("/log/level", s.setLogLevel)
+	adminGroup.PUT("/log/level/:module", s.setLogLevel)
+
+	adminGroup.GET("/connections/:namespace/:user", s.getUserConnections)
+	adminGroup.PUT("/kill/:namespace/:user", s.killUser)
+
+	adminGroup.PUT("/schema/refresh/:namespace", s.refreshSchema)
+
+	adminGroup.GET("/circuit/events", s.getCircuitEvents)
+	adminGroup.GET("/circuit/state/:namespace", s.getCircuitState)
 
 	adminGroup.Use(gzip.Gzip(gzip.DefaultCompression))
 	adminGroup.Use(gin.Recovery())
@@ -164,6 +203,12 @@ func (s *AdminServer) registerURL() {
 	})
 }
 
+// registerHealth register probes, liveness probe needs no auth
+func (s *AdminServer) registerHealth() {
+	s.engine.GET("/healthz", s.healthz)
+	s.engine.GET("/readyz", gin.BasicAuth(gin.Accounts{s.adminUser: s.adminPassword}), s.readyz)
+}
+
 func (s *AdminServer) registerMetric() {
 	metricGroup := s.engine.Group("/api/metric", gin.BasicAuth(gin.Accounts{s.adminUser: s.adminPassword}))
 	for path, handler := range s.proxy.manager.GetStatisticManager().GetHandlers() {
@@ -237,10 +282,10 @@ func generateToken(protoType, addr string) (string, error) {
 }
 
 func (s *AdminServer) registerProxy() error {
-	if s.configType == provider.ConfigFile {
+	if !provider.HasCoordinator(s.configType) {
 		return nil
 	}
-	client := provider.NewClient(provider.ConfigEtcd, s.coordinatorAddr, s.coordinatorUsername, s.coordinatorPassword, s.coordinatorRoot)
+	client := provider.NewClient(provider.CoordinatorType(s.configType), s.coordinatorAddr, s.coordinatorUsername, s.coordinatorPassword, s.coordinatorRoot)
 	store := provider.NewStore(client)
 	defer store.Close()
 	if err := store.CreateProxy(s.model); err != nil {
@@ -250,10 +295,10 @@ func (s *AdminServer) registerProxy() error {
 }
 
 func (s *AdminServer) unregisterProxy() error {
-	if s.configType == provider.ConfigFile {
+	if !provider.HasCoordinator(s.configType) {
 		return nil
 	}
-	client := provider.NewClient(provider.ConfigEtcd, s.coordinatorAddr, s.coordinatorUsername, s.coordinatorPassword, s.coordinatorRoot)
+	client := provider.NewClient(provider.CoordinatorType(s.configType), s.coordinatorAddr, s.coordinatorUsername, s.coordinatorPassword, s.coordinatorRoot)
 	store := provider.NewStore(client)
 	defer store.Close()
 	if err := store.DeleteProxy(s.model.Token); err != nil {
@@ -272,7 +317,7 @@ func (s *AdminServer) prepareConfig(c *gin.Context) {
 		c.JSON(selfDefinedInternalError, "missing namespace name")
 		return
 	}
-	client := provider.NewClient(provider.ConfigEtcd, s.coordinatorAddr, s.coordinatorUsername, s.coordinatorPassword, s.coordinatorRoot)
+	client := provider.NewClient(provider.CoordinatorType(s.configType), s.coordinatorAddr, s.coordinatorUsername, s.coordinatorPassword, s.coordinatorRoot)
 	defer client.Close()
 	err := s.proxy.ReloadNamespacePrepare(name, client)
 	if err != nil {
@@ -297,6 +342,88 @@ func (s *AdminServer) commitConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, "OK")
 }
 
+// newSourceClient create client of namespace source, local files are read when config type is file
+func (s *AdminServer) newSourceClient() config.SourceProvider {
+	switch s.configType {
+	case provider.ConfigFile:
+		return provider.NewClient(provider.ConfigFile, "", "", "", s.fileConfigPath)
+	case provider.ConfigKubernetes:
+		return provider.NewClient(provider.ConfigKubernetes, s.coordinatorAddr, "", "", s.coordinatorRoot)
+	}
+	return provider.NewClient(provider.CoordinatorType(s.configType), s.coordinatorAddr, s.coordinatorUsername, s.coordinatorPassword, s.coordinatorRoot)
+}
+
+// reloadNamespace prepare and commit config of namespace in this proxy only, used by RELOAD NAMESPACE in admin db
+func (s *AdminServer) reloadNamespace(name string) error {
+	client := s.newSourceClient()
+	defer client.Close()
+	s.applyLock.Lock()
+	defer s.applyLock.Unlock()
+	if err := s.proxy.ReloadNamespacePrepare(name, client); err != nil {
+		log.Warnf("prepare source of namespace: %s failed, err: %v", name, err)
+		return err
+	}
+	return s.proxy.ReloadNamespaceCommit(name)
+}
+
+// bindNamespace read namespace config in json body, decrypt and verify it
+func (s *AdminServer) bindNamespace(c *gin.Context) (*models.Namespace, error) {
+	namespace := &models.Namespace{}
+	if err := c.BindJSON(namespace); err != nil {
+		return nil, err
+	}
+	if err := namespace.Verify(); err != nil {
+		return nil, fmt.Errorf("verify namespace error: %v", err)
+	}
+	if err := namespace.Decrypt(s.proxy.EncryptKey); err != nil {
+		return nil, fmt.Errorf("decrypt namespace error: %v", err)
+	}
+	return namespace, nil
+}
+
+func (s *AdminServer) getNamespaceDiff(namespace *models.Namespace) *NamespaceDiff {
+	var running *models.Namespace
+	if ns := s.proxy.manager.GetNamespace(namespace.Name); ns != nil {
+		running = ns.GetConfig()
+	}
+	return DiffNamespace(running, namespace)
+}
+
+// diffNamespace return difference between namespace config in json body and the running config, nothing is changed
+func (s *AdminServer) diffNamespace(c *gin.Context) {
+	namespace, err := s.bindNamespace(c)
+	if err != nil {
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, s.getNamespaceDiff(namespace))
+}
+
+// applyNamespace prepare and commit namespace config in json body at once and return the applied difference,
+// the config is only applied in this proxy and not saved to config center
+func (s *AdminServer) applyNamespace(c *gin.Context) {
+	namespace, err := s.bindNamespace(c)
+	if err != nil {
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+
+	s.applyLock.Lock()
+	defer s.applyLock.Unlock()
+	diff := s.getNamespaceDiff(namespace)
+	if err := s.proxy.manager.ReloadNamespacePrepare(namespace); err != nil {
+		log.Warnf("apply namespace: %s failed, err: %v", namespace.Name, err)
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+	if err := s.proxy.ReloadNamespaceCommit(namespace.Name); err != nil {
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+	log.Infof("apply namespace: %s, diff: %+v", namespace.Name, *diff)
+	c.JSON(http.StatusOK, diff)
+}
+
 func (s *AdminServer) deleteNamespace(c *gin.Context) {
 	name := strings.TrimSpace(c.Param("name"))
 	if name == "" {
@@ -374,3 +501,203 @@ func (s *AdminServer) clearNamespaceBackendSQLFingerprint(c *gin.Context) {
 
 	c.JSON(http.StatusOK, "OK")
 }
+
+// clearNamespaceSQLStats clear statistics of sql fingerprints shown by SHOW SQL STATS
+func (s *AdminServer) clearNamespaceSQLStats(c *gin.Context) {
+	ns := strings.TrimSpace(c.Param("namespace"))
+	namespace := s.proxy.manager.GetNamespace(ns)
+	if namespace == nil {
+		c.JSON(selfDefinedInternalError, "namespace not found")
+		return
+	}
+
+	namespace.ClearSQLStats()
+
+	c.JSON(http.StatusOK, "OK")
+}
+
+// FailpointInfo failpoint information
+type FailpointInfo struct {
+	Supported []string                  `json:"supported"`
+	Enabled   map[string]failpoint.Term `json:"enabled"`
+}
+
+func (s *AdminServer) listFailpoints(c *gin.Context) {
+	ret := &FailpointInfo{Supported: failpoint.Supported(), Enabled: failpoint.List()}
+	c.JSON(http.StatusOK, ret)
+}
+
+// enableFailpoint enable failpoint with json body, e.g. {"probability": 0.1, "delay_ms": 3000}
+func (s *AdminServer) enableFailpoint(c *gin.Context) {
+	name := strings.TrimSpace(c.Param("name"))
+	if name == "" {
+		c.JSON(selfDefinedInternalError, "missing failpoint name")
+		return
+	}
+
+	term := &failpoint.Term{}
+	if err := c.BindJSON(term); err != nil {
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+
+	if err := failpoint.Enable(name, term); err != nil {
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+	log.Warnf("[server] failpoint enabled, name: %s, probability: %v, delay_ms: %d", name, term.Probability, term.DelayMs)
+	c.JSON(http.StatusOK, "OK")
+}
+
+func (s *AdminServer) disableFailpoint(c *gin.Context) {
+	name := strings.TrimSpace(c.Param("name"))
+	if name == "" {
+		c.JSON(selfDefinedInternalError, "missing failpoint name")
+		return
+	}
+	failpoint.Disable(name)
+	log.Warnf("[server] failpoint disabled, name: %s", name)
+	c.JSON(http.StatusOK, "OK")
+}
+
+func (s *AdminServer) disableAllFailpoints(c *gin.Context) {
+	failpoint.DisableAll()
+	log.Warnf("[server] all failpoints disabled")
+	c.JSON(http.StatusOK, "OK")
+}
+
+// UserConnectionsInfo connections of user in cluster
+type UserConnectionsInfo struct {
+	Namespace      string `json:"namespace"`
+	User           string `json:"user"`
+	Connections    int64  `json:"connections"`
+	MaxConnections int64  `json:"max_connections"`
+}
+
+func (s *AdminServer) getUserConnections(c *gin.Context) {
+	namespace := strings.TrimSpace(c.Param("namespace"))
+	user := strings.TrimSpace(c.Param("user"))
+	ns := s.proxy.manager.GetNamespace(namespace)
+	if ns == nil {
+		c.JSON(selfDefinedInternalError, "namespace not found")
+		return
+	}
+
+	info := &UserConnectionsInfo{
+		Namespace:      namespace,
+		User:           user,
+		Connections:    s.proxy.manager.GetClusterState().GetConnectionCount(namespace, user),
+		MaxConnections: ns.GetUserMaxConnections(user),
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// killUser close all client connections of user in all proxies of the cluster
+func (s *AdminServer) killUser(c *gin.Context) {
+	namespace := strings.TrimSpace(c.Param("namespace"))
+	user := strings.TrimSpace(c.Param("user"))
+	if namespace == "" || user == "" {
+		c.JSON(selfDefinedInternalError, "missing namespace or user")
+		return
+	}
+
+	count, err := s.proxy.manager.GetClusterState().KillUser(namespace, user)
+	if err != nil {
+		log.Warnf("kill user failed, namespace: %s, user: %s, err: %v", namespace, user, err)
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+	log.Infof("kill user, namespace: %s, user: %s, local killed: %d", namespace, user, count)
+	c.JSON(http.StatusOK, count)
+}
+
+// refreshSchema load column definitions of logical tables of namespace from backend at once
+func (s *AdminServer) refreshSchema(c *gin.Context) {
+	ns := strings.TrimSpace(c.Param("namespace"))
+	namespace := s.proxy.manager.GetNamespace(ns)
+	if namespace == nil {
+		c.JSON(selfDefinedInternalError, "namespace not found")
+		return
+	}
+
+	if err := namespace.RefreshSchema(); err != nil {
+		log.Warnf("refresh schema failed, namespace: %s, err: %v", ns, err)
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+// healthz liveness probe, return 503 if proxy is closed
+func (s *AdminServer) healthz(c *gin.Context) {
+	if s.proxy.closed.Get() {
+		c.JSON(http.StatusServiceUnavailable, "closed")
+		return
+	}
+	c.JSON(http.StatusOK, "OK")
+}
+
+// readyz readiness probe, check connectivity of backends and replication lag of slaves in all namespaces,
+// return 503 if proxy is closed or master of any slice is unreachable
+func (s *AdminServer) readyz(c *gin.Context) {
+	maxLag := int64(defaultMaxReplicationLag)
+	if v := c.Query("max_replication_lag"); v != "" {
+		lag, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || lag < 0 {
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid max_replication_lag: %s", v))
+			return
+		}
+		maxLag = lag
+	}
+
+	h := s.proxy.manager.CheckHealth(healthCheckTimeout, maxLag)
+	if s.proxy.closed.Get() {
+		h.Ready = false
+	}
+	if !h.Ready {
+		c.JSON(http.StatusServiceUnavailable, h)
+		return
+	}
+	c.JSON(http.StatusOK, h)
+}
+
+// getCircuitEvents return recent trip and reset events of circuit breakers of backends
+func (s *AdminServer) getCircuitEvents(c *gin.Context) {
+	c.JSON(http.StatusOK, backend.GetCircuitEvents())
+}
+
+// getCircuitState return state of circuit breakers of backends in namespace
+func (s *AdminServer) getCircuitState(c *gin.Context) {
+	ns := strings.TrimSpace(c.Param("namespace"))
+	namespace := s.proxy.manager.GetNamespace(ns)
+	if namespace == nil {
+		c.JSON(selfDefinedInternalError, "namespace not found")
+		return
+	}
+	c.JSON(http.StatusOK, namespace.GetCircuitBreakerStats())
+}
+
+// LogLevelInfo level of log module
+type LogLevelInfo struct {
+	Level string `json:"level"`
+}
+
+func (s *AdminServer) getLogLevels(c *gin.Context) {
+	c.JSON(http.StatusOK, logging.GetLevels())
+}
+
+// setLogLevel set level of log module, set level of all modules if module is not specified
+func (s *AdminServer) setLogLevel(c *gin.Context) {
+	module := strings.TrimSpace(c.Param("module"))
+	info := &LogLevelInfo{}
+	if err := c.BindJSON(info); err != nil {
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+	if err := logging.SetLevel(module, info.Level); err != nil {
+		c.JSON(selfDefinedInternalError, err.Error())
+		return
+	}
+	log.Infof("set log level, module: %s, level: %s", module, info.Level)
+	c.JSON(http.StatusOK, "OK")
+}
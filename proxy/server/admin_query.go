@@ -0,0 +1,153 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/gin-gonic/gin"
+)
+
+// QueryRequest request of http query api, statement is executed in a new session of the namespace user
+// authenticated by basic auth, the session is closed after execution
+type QueryRequest struct {
+	Namespace string        `json:"namespace"` // checked if it's not empty
+	DB        string        `json:"db"`
+	SQL       string        `json:"sql"`
+	BindVars  []interface{} `json:"bind_vars"` // values of ? placeholders
+}
+
+// QueryResponse response of http query api, error of statement is returned in Error with status 200
+type QueryResponse struct {
+	Result *QueryResult `json:"result,omitempty"`
+	Error  *QueryError  `json:"error,omitempty"`
+}
+
+func (s *AdminServer) registerQuery() {
+	s.engine.POST("/query", gin.Recovery(), s.query)
+}
+
+// queryBindVars convert json values to args of LocalSession, strings are quoted and booleans are converted to 1 or 0,
+// arrays are converted to ListArg, which is expanded to comma separated values, and arrays in arrays are tuples
+func queryBindVars(vars []interface{}) ([]interface{}, error) {
+	args := make([]interface{}, len(vars))
+	for i, v := range vars {
+		arg, err := queryBindVar(v, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%v of bind var %d", err, i)
+		}
+		args[i] = arg
+	}
+	return args, nil
+}
+
+// queryBindVar convert json value to arg, depth is the nesting level of arrays
+func queryBindVar(v interface{}, depth int) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		} else if f, err := v.Float64(); err == nil {
+			return f, nil
+		}
+		return nil, fmt.Errorf("invalid number %s", v)
+	case string:
+		return []byte(v), nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case []interface{}:
+		if depth >= 2 {
+			return nil, fmt.Errorf("nested tuple")
+		}
+		if len(v) == 0 {
+			return nil, fmt.Errorf("empty list")
+		}
+		list := make(ListArg, len(v))
+		for i, item := range v {
+			arg, err := queryBindVar(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = arg
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+}
+
+// query execute statement by http
+func (s *AdminServer) query(c *gin.Context) {
+	user, password, ok := c.Request.BasicAuth()
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="gaea"`)
+		c.JSON(http.StatusUnauthorized, "authorization of namespace user is required")
+		return
+	}
+
+	req := &QueryRequest{}
+	dec := json.NewDecoder(c.Request.Body)
+	dec.UseNumber()
+	if err := dec.Decode(req); err != nil {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.SQL == "" {
+		c.JSON(http.StatusBadRequest, "sql is empty")
+		return
+	}
+	args, err := queryBindVars(req.BindVars)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	session, err := s.proxy.manager.newLocalSession(user, password, c.Request.RemoteAddr, req.DB)
+	if err != nil {
+		if e, ok := err.(*mysql.SQLError); ok && e.SQLCode() == mysql.ErrAccessDenied {
+			c.JSON(http.StatusUnauthorized, err.Error())
+			return
+		}
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	defer session.Close()
+	if req.Namespace != "" && req.Namespace != session.Namespace() {
+		c.JSON(http.StatusForbidden, fmt.Sprintf("user %s does not belong to namespace %s", user, req.Namespace))
+		return
+	}
+	// X-Forwarded-For is not trusted
+	host, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
+	if !s.proxy.manager.GetNamespace(session.Namespace()).IsClientIPAllowed(net.ParseIP(host)) {
+		c.JSON(http.StatusForbidden, "ip address access denied by gaea")
+		return
+	}
+
+	r, err := session.Execute(c.Request.Context(), req.SQL, args)
+	if err != nil {
+		c.JSON(http.StatusOK, &QueryResponse{Error: newQueryError(err)})
+		return
+	}
+	c.JSON(http.StatusOK, &QueryResponse{Result: newQueryResult(r)})
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryBindVars(t *testing.T) {
+	req := &QueryRequest{}
+	dec := json.NewDecoder(strings.NewReader(`{"sql": "select ?", "bind_vars": [1, 1.5, "a'b", true, null, 12345678901234567890]}`))
+	dec.UseNumber()
+	assert.Nil(t, dec.Decode(req))
+
+	args, err := queryBindVars(req.BindVars)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{int64(1), 1.5, []byte("a'b"), int64(1), nil, 12345678901234567890.0}, args)
+
+	stmt := &Stmt{args: args, paramCount: 6}
+	sql := "select ?, ?, ?, ?, ?, ?"
+	_, offsets, err := calcParams(sql)
+	assert.Nil(t, err)
+	assert.Equal(t, `select 1, 1.5, 'a\'b', 1, NULL, 1.2345678901234567e+19`, stmt.bindArgs(sql, offsets))
+
+	_, err = queryBindVars([]interface{}{[]interface{}{1}})
+	assert.NotNil(t, err)
+}
+
+func TestQueryListBindVars(t *testing.T) {
+	req := &QueryRequest{}
+	dec := json.NewDecoder(strings.NewReader(`{"sql": "select ?", "bind_vars": [[1, "a"], [[1, "x"], [2, "y"]]]}`))
+	dec.UseNumber()
+	assert.Nil(t, dec.Decode(req))
+
+	args, err := queryBindVars(req.BindVars)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		ListArg{int64(1), []byte("a")},
+		ListArg{ListArg{int64(1), []byte("x")}, ListArg{int64(2), []byte("y")}},
+	}, args)
+
+	for _, vars := range [][]interface{}{{[]interface{}{}}, {[]interface{}{[]interface{}{[]interface{}{nil}}}}} {
+		_, err = queryBindVars(vars)
+		assert.NotNil(t, err)
+	}
+}
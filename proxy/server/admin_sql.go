@@ -0,0 +1,343 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// adminDB is the database of admin interface over mysql protocol, only admin users can use it.
+// Statements in admin db manage the namespace of the user in this proxy.
+const adminDB = "admin"
+
+// virtual tables in admin db
+const (
+	adminTableConnections = "connections"
+	adminTableBackends    = "backends"
+	adminTableNamespaces  = "namespaces"
+	adminTableSlowQueries = "slow_queries"
+	adminTablePlanCache   = "plan_cache"
+)
+
+var adminTables = []string{adminTableBackends, adminTableConnections, adminTableNamespaces, adminTablePlanCache, adminTableSlowQueries}
+
+// reloadNamespaceRegex match RELOAD NAMESPACE statement in admin db, which can not be parsed by parser
+var reloadNamespaceRegex = regexp.MustCompile("(?i)^reload\\s+namespace\\s+`?([0-9a-zA-Z_\\-]+)`?$")
+
+// adminTable rows of virtual table in admin db
+type adminTable struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// isAdminSession check if the session uses admin db
+func (se *SessionExecutor) isAdminSession() bool {
+	return se.db == adminDB && se.GetNamespace().IsAdminUser(se.user)
+}
+
+// handleAdminQuery handle statements of admin db, handled is false if the statement is not an admin statement,
+// e.g. SET, USE and SELECT without table, and it should be handled as normal statement.
+func (se *SessionExecutor) handleAdminQuery(sql string) (r *mysql.Result, handled bool, err error) {
+	if m := reloadNamespaceRegex.FindStringSubmatch(strings.TrimSpace(sql)); len(m) == 2 {
+		return nil, true, se.handleAdminReloadNamespace(m[1])
+	}
+
+	n, err := se.Parse(sql)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	switch stmt := n.(type) {
+	case *ast.SelectStmt:
+		if stmt.From == nil {
+			return nil, false, nil
+		}
+		r, err = se.handleAdminSelect(stmt)
+		return r, true, err
+	case *ast.KillStmt:
+		return nil, true, se.handleAdminKill(stmt)
+	case *ast.ShowStmt:
+		if stmt.Tp != ast.ShowTables {
+			return nil, false, nil
+		}
+		rows := make([][]interface{}, 0, len(adminTables))
+		for _, table := range adminTables {
+			rows = append(rows, []interface{}{table})
+		}
+		r, err = createAdminResult(&adminTable{columns: []string{"Tables_in_" + adminDB}, rows: rows})
+		return r, true, err
+	default:
+		return nil, false, nil
+	}
+}
+
+// handleAdminReloadNamespace reload config of namespace of the session in this proxy
+func (se *SessionExecutor) handleAdminReloadNamespace(name string) error {
+	if name != se.namespace {
+		return fmt.Errorf("can not reload namespace %s in admin db of namespace %s", name, se.namespace)
+	}
+	if se.reloadNamespace == nil {
+		return fmt.Errorf("reload namespace is not supported")
+	}
+	return se.reloadNamespace(name)
+}
+
+// handleAdminKill handle KILL [CONNECTION | QUERY] id, only connections of the same namespace in this proxy can be killed
+func (se *SessionExecutor) handleAdminKill(stmt *ast.KillStmt) error {
+	return se.handleKill(stmt.ConnectionID, stmt.Query)
+}
+
+// handleAdminSelect select from virtual table, only WHERE with equal conditions combined by AND and LIMIT are supported
+func (se *SessionExecutor) handleAdminSelect(stmt *ast.SelectStmt) (*mysql.Result, error) {
+	if stmt.From.TableRefs.Right != nil || stmt.GroupBy != nil || stmt.Having != nil || stmt.OrderBy != nil || stmt.Distinct {
+		return nil, fmt.Errorf("only simple select of one table is supported in admin db")
+	}
+	tableSource, ok := stmt.From.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil, fmt.Errorf("only simple select of one table is supported in admin db")
+	}
+	tableName, ok := tableSource.Source.(*ast.TableName)
+	if !ok || (tableName.Schema.L != "" && tableName.Schema.L != adminDB) {
+		return nil, fmt.Errorf("only tables in admin db can be selected")
+	}
+
+	table, err := se.getAdminTable(tableName.Name.L)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make(map[int]string)
+	if stmt.Where != nil {
+		if err := getAdminFilters(stmt.Where, table.columns, filters); err != nil {
+			return nil, err
+		}
+	}
+
+	fields, err := getAdminSelectFields(stmt.Fields, table.columns)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &adminTable{}
+	for _, i := range fields {
+		ret.columns = append(ret.columns, table.columns[i])
+	}
+	for _, row := range table.rows {
+		if !matchAdminFilters(row, filters) {
+			continue
+		}
+		if stmt.Limit != nil && stmt.Limit.Count != nil {
+			if count, ok := stmt.Limit.Count.(*driver.ValueExpr); ok && uint64(len(ret.rows)) >= count.GetUint64() {
+				break
+			}
+		}
+		values := make([]interface{}, 0, len(fields))
+		for _, i := range fields {
+			values = append(values, row[i])
+		}
+		ret.rows = append(ret.rows, values)
+	}
+	return createAdminResult(ret)
+}
+
+// getAdminSelectFields return indexes of selected columns in table
+func getAdminSelectFields(fields *ast.FieldList, columns []string) ([]int, error) {
+	var indexes []int
+	for _, f := range fields.Fields {
+		if f.WildCard != nil {
+			for i := range columns {
+				indexes = append(indexes, i)
+			}
+			continue
+		}
+		c, ok := f.Expr.(*ast.ColumnNameExpr)
+		if !ok {
+			return nil, fmt.Errorf("only columns can be selected in admin db")
+		}
+		i, err := getAdminColumnIndex(columns, c.Name.Name.L)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes, nil
+}
+
+// getAdminFilters collect conditions like column = value combined by AND, key of filters is column index
+func getAdminFilters(expr ast.ExprNode, columns []string, filters map[int]string) error {
+	e, ok := expr.(*ast.BinaryOperationExpr)
+	if !ok {
+		return fmt.Errorf("only equal conditions combined by AND are supported in admin db")
+	}
+	switch e.Op {
+	case opcode.LogicAnd:
+		if err := getAdminFilters(e.L, columns, filters); err != nil {
+			return err
+		}
+		return getAdminFilters(e.R, columns, filters)
+	case opcode.EQ:
+		c, ok := e.L.(*ast.ColumnNameExpr)
+		if !ok {
+			return fmt.Errorf("left side of condition must be column in admin db")
+		}
+		v, ok := e.R.(*driver.ValueExpr)
+		if !ok {
+			return fmt.Errorf("right side of condition must be value in admin db")
+		}
+		i, err := getAdminColumnIndex(columns, c.Name.Name.L)
+		if err != nil {
+			return err
+		}
+		value, err := util.GetValueExprResult(v)
+		if err != nil {
+			return err
+		}
+		filters[i] = fmt.Sprintf("%v", value)
+		return nil
+	default:
+		return fmt.Errorf("only equal conditions combined by AND are supported in admin db")
+	}
+}
+
+func getAdminColumnIndex(columns []string, column string) (int, error) {
+	for i, c := range columns {
+		if c == column {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown column %s", column)
+}
+
+func matchAdminFilters(row []interface{}, filters map[int]string) bool {
+	for i, v := range filters {
+		if fmt.Sprintf("%v", row[i]) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// getAdminTable return rows of virtual table, rows are of the namespace of the session
+func (se *SessionExecutor) getAdminTable(name string) (*adminTable, error) {
+	ns := se.GetNamespace()
+	switch name {
+	case adminTableConnections:
+		t := &adminTable{columns: []string{"id", "namespace", "user", "host"}}
+		sessions := se.manager.GetClusterState().GetLocalSessions(se.namespace)
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].executor.connID < sessions[j].executor.connID
+		})
+		for _, cc := range sessions {
+			t.rows = append(t.rows, []interface{}{uint64(cc.executor.connID), cc.namespace, cc.executor.user, cc.executor.clientAddr})
+		}
+		return t, nil
+	case adminTableBackends:
+		t := &adminTable{columns: []string{"namespace", "slice", "role", "addr", "capacity", "active", "in_use", "available", "wait_count"}}
+		names := make([]string, 0, len(ns.slices))
+		for name := range ns.slices {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			slice := ns.slices[name]
+			addRow := func(role string, p backend.ConnectionPool) {
+				t.rows = append(t.rows, []interface{}{ns.GetName(), name, role, p.Addr(), p.Capacity(), p.Active(), p.InUse(), p.Available(), p.WaitCount()})
+			}
+			if slice.Master != nil {
+				addRow("master", slice.Master)
+			}
+			for _, p := range slice.Slave {
+				addRow("slave", p)
+			}
+			for _, p := range slice.StatisticSlave {
+				addRow("statistic_slave", p)
+			}
+		}
+		return t, nil
+	case adminTableNamespaces:
+		t := &adminTable{columns: []string{"name", "allowed_dbs", "slices", "shard_tables", "plan_cache_size"}}
+		dbs := ns.GetAllowedDBs()
+		sort.Strings(dbs)
+		shardTables := 0
+		for _, db := range dbs {
+			shardTables += len(ns.GetRouter().GetShardRules(db))
+		}
+		t.rows = append(t.rows, []interface{}{ns.GetName(), strings.Join(dbs, ","), int64(len(ns.slices)), int64(shardTables), ns.planCache.Length()})
+		return t, nil
+	case adminTableSlowQueries:
+		t := &adminTable{columns: []string{"namespace", "md5", "fingerprint"}}
+		fingerprints := ns.GetSlowSQLFingerprints()
+		keys := make([]string, 0, len(fingerprints))
+		for k := range fingerprints {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			t.rows = append(t.rows, []interface{}{ns.GetName(), k, fingerprints[k]})
+		}
+		return t, nil
+	case adminTablePlanCache:
+		t := &adminTable{columns: []string{"namespace", "db", "md5", "plans", "sample_sql"}}
+		items := ns.planCache.Items()
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].Key < items[j].Key
+		})
+		for _, item := range items {
+			idx := strings.LastIndexByte(item.Key, '|')
+			sqls := item.Value.(*planCacheEntry).getSQLs()
+			if idx < 0 || len(sqls) == 0 {
+				continue
+			}
+			t.rows = append(t.rows, []interface{}{ns.GetName(), item.Key[:idx], item.Key[idx+1:], int64(len(sqls)), sqls[0]})
+		}
+		return t, nil
+	default:
+		return nil, mysql.NewDefaultError(mysql.ErrNoSuchTable, adminDB, name)
+	}
+}
+
+func createAdminResult(t *adminTable) (*mysql.Result, error) {
+	r := new(mysql.Resultset)
+	r.FieldNames = make(map[string]int, len(t.columns))
+	for i, name := range t.columns {
+		field := &mysql.Field{}
+		field.Name = hack.Slice(name)
+		r.Fields = append(r.Fields, field)
+		r.FieldNames[name] = i
+	}
+	r.Values = t.rows
+
+	result := &mysql.Result{
+		AffectedRows: uint64(len(t.rows)),
+		Resultset:    r,
+	}
+	if err := plan.GenerateSelectResultRowData(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func prepareAdminSession(t *testing.T) *SessionExecutor {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+
+	se.manager.clusterState = NewClusterState("", nil, 0)
+
+	// only admin user can use admin db
+	assert.NotNil(t, se.handleUseDB(adminDB))
+	se.GetNamespace().userProperties[se.user].Admin = true
+	assert.Nil(t, se.handleUseDB(adminDB))
+	assert.True(t, se.isAdminSession())
+	return se
+}
+
+func TestAdminSelect(t *testing.T) {
+	se := prepareAdminSession(t)
+	ns := se.GetNamespace()
+
+	cc := &Session{namespace: se.namespace, executor: se}
+	se.connID = 10
+	se.clientAddr = "127.0.0.1:3306"
+	assert.Nil(t, se.manager.GetClusterState().AddSession(cc, 0, 0))
+	defer se.manager.GetClusterState().RemoveSession(cc)
+
+	_, err := se.getPlan(ns, "db_ks", "select * from tbl_ks where id = 1")
+	assert.Nil(t, err)
+
+	tests := []struct {
+		sql     string
+		columns []string
+		rows    [][]interface{}
+	}{
+		{
+			"select * from connections",
+			[]string{"id", "namespace", "user", "host"},
+			[][]interface{}{{uint64(10), se.namespace, se.user, "127.0.0.1:3306"}},
+		},
+		{
+			"select user, host from admin.connections where id = 10 and user = 'test_executor'",
+			[]string{"user", "host"},
+			[][]interface{}{{se.user, "127.0.0.1:3306"}},
+		},
+		{
+			"select id from connections where id = 11",
+			[]string{"id"},
+			nil,
+		},
+		{
+			"select name, allowed_dbs, plan_cache_size from namespaces",
+			[]string{"name", "allowed_dbs", "plan_cache_size"},
+			[][]interface{}{{se.namespace, "db_ks,db_mycat", int64(1)}},
+		},
+		{
+			"select db, plans, sample_sql from plan_cache limit 1",
+			[]string{"db", "plans", "sample_sql"},
+			[][]interface{}{{"db_ks", int64(1), "select * from tbl_ks where id = 1"}},
+		},
+	}
+	for _, test := range tests {
+		r, handled, err := se.handleAdminQuery(test.sql)
+		assert.True(t, handled, test.sql)
+		assert.Nil(t, err, test.sql)
+		var columns []string
+		for _, f := range r.Fields {
+			columns = append(columns, string(f.Name))
+		}
+		assert.Equal(t, test.columns, columns, test.sql)
+		assert.Equal(t, test.rows, r.Values, test.sql)
+	}
+
+	r, handled, err := se.handleAdminQuery("show tables")
+	assert.True(t, handled)
+	assert.Nil(t, err)
+	assert.Equal(t, len(adminTables), len(r.Values))
+
+	// statements not in admin db are handled as normal statements
+	for _, sql := range []string{"select 1", "set autocommit = 1", "show databases"} {
+		_, handled, _ = se.handleAdminQuery(sql)
+		assert.False(t, handled, sql)
+	}
+
+	for _, sql := range []string{"select * from tbl_ks", "select * from connections order by id", "select * from connections where id > 1"} {
+		_, handled, err = se.handleAdminQuery(sql)
+		assert.True(t, handled, sql)
+		assert.NotNil(t, err, sql)
+	}
+}
+
+func TestAdminKillAndReload(t *testing.T) {
+	se := prepareAdminSession(t)
+
+	cc := &Session{namespace: se.namespace, executor: se}
+	se.connID = 10
+	assert.Nil(t, se.manager.GetClusterState().AddSession(cc, 0, 0))
+	defer se.manager.GetClusterState().RemoveSession(cc)
+
+	_, handled, err := se.handleAdminQuery("kill query 10")
+	assert.True(t, handled)
+	assert.Nil(t, err)
+	_, _, err = se.handleAdminQuery("kill 11")
+	assert.NotNil(t, err)
+
+	var reloaded []string
+	se.reloadNamespace = func(name string) error {
+		reloaded = append(reloaded, name)
+		return nil
+	}
+	_, handled, err = se.handleAdminQuery("RELOAD NAMESPACE " + se.namespace)
+	assert.True(t, handled)
+	assert.Nil(t, err)
+	_, _, err = se.handleAdminQuery("reload namespace other_namespace")
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{se.namespace}, reloaded)
+}
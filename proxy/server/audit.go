@@ -0,0 +1,139 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+
+	"github.com/XiaoMi/Gaea/audit"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// createAuditLogger create audit logger with sink in proxy config, return nil if audit log is disabled
+func createAuditLogger(cfg *models.Proxy) (*audit.Logger, error) {
+	if cfg.AuditSink == "" {
+		return nil, nil
+	}
+	sink, err := audit.NewSink(cfg.AuditSink, cfg.AuditSinkParams)
+	if err != nil {
+		return nil, err
+	}
+	return audit.NewLogger(sink, cfg.AuditBufferSize), nil
+}
+
+// RecordAuditEvent write audit event if audit log of the namespace is enabled
+func (m *Manager) RecordAuditEvent(e *audit.Event) {
+	if m.auditLogger == nil {
+		return
+	}
+	ns := m.GetNamespace(e.Namespace)
+	if ns == nil || !ns.auditLog {
+		return
+	}
+	m.auditLogger.Log(e)
+}
+
+// isAuditStmt return true if statement of the type is written to audit log
+func isAuditStmt(stmtType parser.StatementType) bool {
+	switch stmtType {
+	case parser.StmtInsert, parser.StmtReplace, parser.StmtUpdate, parser.StmtDelete, parser.StmtDDL, parser.StmtLoadData, parser.StmtCall:
+		return true
+	default:
+		return false
+	}
+}
+
+// recordConnectionAudit record connect or disconnect event of client connection
+func (se *SessionExecutor) recordConnectionAudit(eventType string) {
+	se.manager.RecordAuditEvent(&audit.Event{
+		Type:       eventType,
+		Namespace:  se.namespace,
+		User:       se.user,
+		ClientAddr: se.clientAddr,
+		ConnID:     se.connID,
+	})
+}
+
+// recordQueryAudit record DML and DDL statement with tables in the statement and shards it's routed to
+func (se *SessionExecutor) recordQueryAudit(reqCtx *util.RequestContext, sql string, startTime time.Time, err error) {
+	stmtType, _ := reqCtx.Get(util.StmtType).(parser.StatementType)
+	// avoid parsing tables of statements not written
+	if !isAuditStmt(stmtType) || se.manager.auditLogger == nil || !se.GetNamespace().auditLog {
+		return
+	}
+	e := &audit.Event{
+		Time:       startTime,
+		Type:       audit.EventQuery,
+		Namespace:  se.namespace,
+		User:       se.user,
+		ClientAddr: se.clientAddr,
+		ConnID:     se.connID,
+		DB:         se.db,
+		TraceID:    util.GetTraceID(reqCtx),
+		StmtType:   stmtType.String(),
+		SQL:        sql,
+		Tables:     getAuditTables(sql),
+		Shards:     getShards(reqCtx),
+		Rows:       getRowCountFromContext(reqCtx),
+		CostMs:     time.Since(startTime).Nanoseconds() / int64(time.Millisecond),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	se.manager.RecordAuditEvent(e)
+}
+
+// auditTableCollector collect sorted and distinct names of tables in statement
+type auditTableCollector struct {
+	tables map[string]bool
+}
+
+// Enter implement ast.Visitor
+func (c *auditTableCollector) Enter(n ast.Node) (node ast.Node, skipChildren bool) {
+	if t, ok := n.(*ast.TableName); ok {
+		name := t.Name.O
+		if t.Schema.O != "" {
+			name = t.Schema.O + "." + name
+		}
+		c.tables[name] = true
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (c *auditTableCollector) Leave(n ast.Node) (node ast.Node, ok bool) {
+	return n, true
+}
+
+// getAuditTables return tables in sql, return nil if sql can not be parsed
+func getAuditTables(sql string) []string {
+	stmt, err := parser.ParseSQL(sql)
+	if err != nil {
+		return nil
+	}
+	c := &auditTableCollector{tables: make(map[string]bool)}
+	stmt.Accept(c)
+	tables := make([]string, 0, len(c.tables))
+	for t := range c.tables {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	return tables
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/audit"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+type memoryAuditSink struct {
+	events []*audit.Event
+}
+
+func (s *memoryAuditSink) Write(e *audit.Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *memoryAuditSink) Close() error {
+	return nil
+}
+
+func TestGetAuditTables(t *testing.T) {
+	tests := []struct {
+		sql    string
+		tables []string
+	}{
+		{"insert into tbl_ks (id) values (1)", []string{"tbl_ks"}},
+		{"update db_ks.tbl_ks a join tbl_b b on a.id = b.id set a.c = b.c", []string{"db_ks.tbl_ks", "tbl_b"}},
+		{"delete from tbl_ks where id in (select id from tbl_ks where c = 1)", []string{"tbl_ks"}},
+		{"alter table tbl_ks add column d int", []string{"tbl_ks"}},
+		{"not a sql", nil},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.tables, getAuditTables(test.sql), test.sql)
+	}
+}
+
+func TestRecordQueryAudit(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	sink := &memoryAuditSink{}
+	se.manager.auditLogger = audit.NewLogger(sink, 10)
+	se.connID = 10
+	se.clientAddr = "127.0.0.1:3306"
+
+	newReqCtx := func(stmtType parser.StatementType) *util.RequestContext {
+		reqCtx := util.NewRequestContext()
+		reqCtx.Set(util.StmtType, stmtType)
+		reqCtx.Set(util.RowCount, int64(2))
+		addShards(reqCtx, map[string]map[string][]string{"slice-1": {"db_ks": nil}, "slice-0": {"db_ks": nil}})
+		return reqCtx
+	}
+
+	// audit log of namespace is disabled
+	se.recordQueryAudit(newReqCtx(parser.StmtDelete), "delete from tbl_ks", time.Now(), nil)
+
+	se.GetNamespace().auditLog = true
+	se.recordQueryAudit(newReqCtx(parser.StmtSelect), "select * from tbl_ks", time.Now(), nil)
+	se.recordQueryAudit(newReqCtx(parser.StmtDelete), "delete from tbl_ks", time.Now(), errors.New("failed"))
+	se.recordConnectionAudit(audit.EventDisconnect)
+	assert.Nil(t, se.manager.auditLogger.Close())
+
+	assert.Equal(t, 2, len(sink.events))
+	e := sink.events[0]
+	assert.Equal(t, audit.EventQuery, e.Type)
+	assert.Equal(t, se.namespace, e.Namespace)
+	assert.Equal(t, se.user, e.User)
+	assert.Equal(t, "127.0.0.1:3306", e.ClientAddr)
+	assert.Equal(t, uint32(10), e.ConnID)
+	assert.Equal(t, parser.StmtDelete.String(), e.StmtType)
+	assert.Equal(t, []string{"tbl_ks"}, e.Tables)
+	assert.Equal(t, []string{"slice-0:db_ks", "slice-1:db_ks"}, e.Shards)
+	assert.Equal(t, int64(2), e.Rows)
+	assert.Equal(t, "failed", e.Error)
+	assert.Equal(t, audit.EventDisconnect, sink.events[1].Type)
+}
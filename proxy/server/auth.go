@@ -5,18 +5,96 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
-	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
-	"github.com/XiaoMi/Gaea/mysql"
+	"io/ioutil"
 	"sync"
+
+	"github.com/XiaoMi/Gaea/mysql"
 )
 
+// ErrAccessDenied means password of user is wrong
 var ErrAccessDenied = errors.New("access denied")
-var tlsConfig tls.Config
 
-var ShaPasswordCache = &sync.Map{}
+const (
+	// client of caching_sha2_password requests RSA public key with this byte
+	cachingSha2RequestPublicKey byte = 0x02
+
+	cachingSha2RSAKeyBits = 2048
+)
+
+// RSA key pair used by caching_sha2_password full authentication on non-TLS connection
+var (
+	cachingSha2RSAKeyLock   sync.RWMutex
+	cachingSha2RSAKey       *rsa.PrivateKey
+	cachingSha2PublicKeyPEM []byte
+)
+
+// InitCachingSha2RSAKey load RSA private key of caching_sha2_password from PEM file,
+// if path is empty, a new key pair is generated like mysql does.
+func InitCachingSha2RSAKey(privateKeyPath string) error {
+	var key *rsa.PrivateKey
+	var err error
+	if privateKeyPath == "" {
+		key, err = rsa.GenerateKey(rand.Reader, cachingSha2RSAKeyBits)
+		if err != nil {
+			return fmt.Errorf("generate RSA key error: %v", err)
+		}
+	} else {
+		key, err = loadRSAPrivateKey(privateKeyPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return fmt.Errorf("marshal RSA public key error: %v", err)
+	}
+
+	cachingSha2RSAKeyLock.Lock()
+	cachingSha2RSAKey = key
+	cachingSha2PublicKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})
+	cachingSha2RSAKeyLock.Unlock()
+	return nil
+}
+
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read RSA private key file error: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid RSA private key file: %s", path)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key in %s is not RSA key", path)
+		}
+		return rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %s in %s", block.Type, path)
+	}
+}
+
+func getCachingSha2RSAKey() (*rsa.PrivateKey, []byte) {
+	cachingSha2RSAKeyLock.RLock()
+	defer cachingSha2RSAKeyLock.RUnlock()
+	return cachingSha2RSAKey, cachingSha2PublicKeyPEM
+}
 
 func (c *Session) auth(authInfo HandshakeResponseInfo, password string) error {
 	//尝试交换
@@ -24,8 +102,11 @@ func (c *Session) auth(authInfo HandshakeResponseInfo, password string) error {
 		if err := c.c.WriteAuthSwitchRequest(mysql.AUTH_CACHING_SHA2_PASSWORD); err != nil {
 			return err
 		}
-		authInfo.AuthPlugin = mysql.AUTH_CACHING_SHA2_PASSWORD
-		return c.handleAuthSwitchResponse(authInfo, password)
+		authData, err := c.readAuthSwitchRequestResponse()
+		if err != nil {
+			return err
+		}
+		return c.authCachingSha2Password(authData, password)
 	}
 
 	clientAuthData := authInfo.AuthResponse
@@ -34,22 +115,9 @@ func (c *Session) auth(authInfo HandshakeResponseInfo, password string) error {
 		return c.compareNativePasswordAuthData(clientAuthData, password)
 
 	case mysql.AUTH_CACHING_SHA2_PASSWORD:
-		if err := c.compareCacheSha2PasswordAuthData(clientAuthData, password); err != nil {
-			return err
-		}
-		if c.cachingSha2FullAuth {
-			return c.handleAuthSwitchResponse(authInfo, password)
-		}
-		return nil
+		return c.authCachingSha2Password(clientAuthData, password)
 
 	case mysql.AUTH_SHA256_PASSWORD:
-		//cont, err := c.handlePublicKeyRetrieval(clientAuthData)
-		//if err != nil {
-		//	return err
-		//}
-		//if !cont {
-		//	return nil
-		//}
 		return c.compareSha256PasswordAuthData(clientAuthData, password)
 
 	default:
@@ -57,26 +125,6 @@ func (c *Session) auth(authInfo HandshakeResponseInfo, password string) error {
 	}
 }
 
-func scrambleValidation(cached, nonce, scramble []byte) bool {
-	// SHA256(SHA256(SHA256(STORED_PASSWORD)), NONCE)
-	crypt := sha256.New()
-	crypt.Write(cached)
-	crypt.Write(nonce)
-	message2 := crypt.Sum(nil)
-	// SHA256(PASSWORD)
-	if len(message2) != len(scramble) {
-		return false
-	}
-	for i := range message2 {
-		message2[i] ^= scramble[i]
-	}
-	// SHA256(SHA256(PASSWORD)
-	crypt.Reset()
-	crypt.Write(message2)
-	m := crypt.Sum(nil)
-	return bytes.Equal(m, cached)
-}
-
 func (c *Session) compareNativePasswordAuthData(clientAuthData []byte, password string) error {
 	if bytes.Equal(mysql.CalcPassword(c.c.salt, []byte(password)), clientAuthData) {
 		return nil
@@ -85,52 +133,15 @@ func (c *Session) compareNativePasswordAuthData(clientAuthData []byte, password
 }
 
 func (c *Session) compareSha256PasswordAuthData(clientAuthData []byte, password string) error {
-	/*
-		// Empty passwords are not hashed, but sent as empty string
-		if len(clientAuthData) == 0 {
-			if password == "" {
-				return nil
-			}
-			return ErrAccessDenied
-		}
-		//tlsConn, isTls := c.Conn.(*tls.Conn);
-		isTls := false
-		if isTls {
-			if !tlsConn.ConnectionState().HandshakeComplete {
-				return errors.New("incomplete TSL handshake")
-			}
-			// connection is SSL/TLS, client should send plain password
-			// deal with the trailing \NUL added for plain text password received
-			if l := len(clientAuthData); l != 0 && clientAuthData[l-1] == 0x00 {
-				clientAuthData = clientAuthData[:l-1]
-			}
-			if bytes.Equal(clientAuthData, []byte(password)) {
-				return nil
-			}
-			return ErrAccessDenied
-		} else {
-			// client should send encrypted password
-			// decrypt
-			dbytes, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, (c.serverConf.tlsConfig.Certificates[0].PrivateKey).(*rsa.PrivateKey), clientAuthData, nil)
-			if err != nil {
-				return err
-			}
-			plain := make([]byte, len(password)+1)
-			copy(plain, password)
-			for i := range plain {
-				j := i % len(c.salt)
-				plain[i] ^= c.salt[j]
-			}
-			if bytes.Equal(plain, dbytes) {
-				return nil
-			}
-			return ErrAccessDenied
-		}
-	*/
 	return fmt.Errorf("Sha256Password unsupported")
 }
 
-func (c *Session) compareCacheSha2PasswordAuthData(clientAuthData []byte, password string) error {
+// authCachingSha2Password authenticate with caching_sha2_password,
+// see: https://dev.mysql.com/doc/dev/mysql-server/latest/page_caching_sha2_authentication_exchanges.html
+// proxy has the plain password of users, so the scramble can always be checked without cache.
+// If the scramble does not match, full authentication is performed like a cache miss in mysql,
+// the client sends plain password over TLS, or password encrypted by the RSA public key of proxy.
+func (c *Session) authCachingSha2Password(clientAuthData []byte, password string) error {
 	// Empty passwords are not hashed, but sent as empty string
 	if len(clientAuthData) == 0 {
 		if password == "" {
@@ -138,150 +149,85 @@ func (c *Session) compareCacheSha2PasswordAuthData(clientAuthData []byte, passwo
 		}
 		return ErrAccessDenied
 	}
-	// the caching of 'caching_sha2_password' in MySQL, see: https://dev.mysql.com/worklog/task/?id=9591
+
 	if bytes.Equal(mysql.CalcCachingSha2Password(c.c.salt, password), clientAuthData) {
 		// 'fast' auth: write "More data" packet (first byte == 0x01) with the second byte = 0x03
 		return c.c.WriteAuthMoreDataFastAuth()
 	}
-	return ErrAccessDenied
 
-	//return c.fastShaCacheAuth(clientAuthData, handshakeInfo)
-}
-
-//https://dev.mysql.com/doc/dev/mysql-server/latest/page_caching_sha2_authentication_exchanges.html
-func (c *Session) cachingSha2AuthenticationExchange(clientAuthData []byte, handshakeInfo HandshakeResponseInfo) error {
-	// other type of credential provider, we use the cache
-	cached, ok := ShaPasswordCache.Load(fmt.Sprintf("%s@%s", handshakeInfo.User, c.c.LocalAddr()))
-	if ok {
-		// Scramble validation
-		if scrambleValidation(cached.([]byte), handshakeInfo.Salt, clientAuthData) {
-			// 'fast' auth: write "More data" packet (first byte == 0x01) with the second byte = 0x03
-			return c.c.WriteAuthMoreDataFastAuth()
-		}
-		return ErrAccessDenied
-	}
-	// cache miss, do full auth
+	// 'full' auth: write "More data" packet (first byte == 0x01) with the second byte = 0x04
 	if err := c.c.WriteAuthMoreDataFullAuth(); err != nil {
 		return err
 	}
-	c.cachingSha2FullAuth = true
-	return nil
-}
-
-/************resonse handler***********/
-
-func (c *Session) readAuthSwitchRequestResponse() ([]byte, error) {
-	data, err := c.c.ReadPacket()
-	if err != nil {
-		return nil, err
-	}
-	if len(data) == 1 && data[0] == 0x00 {
-		// \NUL
-		return make([]byte, 0), nil
-	}
-	return data, nil
-}
-
-func (c *Session) handleAuthSwitchResponse(info HandshakeResponseInfo, password string) error {
 	authData, err := c.readAuthSwitchRequestResponse()
 	if err != nil {
 		return err
 	}
+	return c.handleCachingSha2PasswordFullAuth(authData, password)
+}
 
-	switch info.AuthPlugin {
-	case mysql.AUTH_NATIVE_PASSWORD:
-		if !bytes.Equal(mysql.CalcPassword(c.c.salt, []byte(password)), authData) {
-			return ErrAccessDenied
-		}
-		return nil
-
-	case mysql.AUTH_CACHING_SHA2_PASSWORD:
-		if !c.cachingSha2FullAuth {
-			// Switched auth method but no MoreData packet send yet
-			if err := c.compareCacheSha2PasswordAuthData(authData, password); err != nil {
-				return err
-			} else {
-				if c.cachingSha2FullAuth {
-					return c.handleAuthSwitchResponse(info, password)
-				}
-				return nil
-			}
+func (c *Session) handleCachingSha2PasswordFullAuth(authData []byte, password string) error {
+	// connection is TLS, client sends plain password terminated by \NUL
+	if _, ok := c.rawConn.(*tls.Conn); ok {
+		if l := len(authData); l != 0 && authData[l-1] == 0x00 {
+			authData = authData[:l-1]
 		}
-		// AuthMoreData packet already sent, do full auth
-		if err := c.handleCachingSha2PasswordFullAuth(authData, password); err != nil {
-			return err
+		if bytes.Equal(authData, []byte(password)) {
+			return nil
 		}
-		c.writeCachingSha2Cache(info.User, password)
-		return nil
-
-	case mysql.AUTH_SHA256_PASSWORD:
-		//cont, err := c.handlePublicKeyRetrieval(authData)
-		//if err != nil {
-		//	return err
-		//}
-		//if !cont {
-		//	return nil
-		//}
-		//if err := c.acquirePassword(); err != nil {
-		//	return err
-		//}
-		return c.compareSha256PasswordAuthData(authData, password)
-
-	default:
-		return fmt.Errorf("unknown authentication plugin name '%s'", info.AuthPlugin)
+		return ErrAccessDenied
 	}
-}
 
-func (c *Session) handleCachingSha2PasswordFullAuth(authData []byte, password string) error {
+	key, publicKey := getCachingSha2RSAKey()
+	if key == nil {
+		return fmt.Errorf("RSA key of caching_sha2_password is not initialized")
+	}
 
-	if len(authData) == 1 && authData[0] == 0x02 {
-		// send the public key
-		if err := c.c.WriteAuthMoreDataFullAuth(); err != nil {
+	if len(authData) == 1 && authData[0] == cachingSha2RequestPublicKey {
+		// send the public key, and read the encrypted password
+		if err := c.c.WriteAuthMoreDataPublicKey(publicKey); err != nil {
 			return err
 		}
-		// read the encrypted password
 		var err error
 		if authData, err = c.readAuthSwitchRequestResponse(); err != nil {
 			return err
 		}
 	}
-	// the encrypted password
-	// decrypt
-	dbytes, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, (tlsConfig.Certificates[0].PrivateKey).(*rsa.PrivateKey), authData, nil)
+
+	if checkCachingSha2EncryptedPassword(key, c.c.salt, authData, password) {
+		return nil
+	}
+	return ErrAccessDenied
+}
+
+// checkCachingSha2EncryptedPassword check password encrypted by RSA public key,
+// the plain text is XOR(password + \NUL, salt)
+func checkCachingSha2EncryptedPassword(key *rsa.PrivateKey, salt, authData []byte, password string) bool {
+	if len(salt) == 0 {
+		return false
+	}
+	dbytes, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, authData, nil)
 	if err != nil {
-		return err
+		return false
 	}
 	plain := make([]byte, len(password)+1)
 	copy(plain, password)
 	for i := range plain {
-		j := i % len(c.c.salt)
-		plain[i] ^= c.c.salt[j]
+		plain[i] ^= salt[i%len(salt)]
 	}
-	if bytes.Equal(plain, dbytes) {
-		return nil
-	}
-	return ErrAccessDenied
+	return bytes.Equal(plain, dbytes)
 }
 
-func (c *Session) writeCachingSha2Cache(user string, password string) {
-	// write cache
-	if password == "" {
-		return
-	}
-	m2 := generateScrambleData(password)
-	// caching_sha2_password will maintain an in-memory hash of `user`@`host` => SHA256(SHA256(PASSWORD))
-
-	ShaPasswordCache.Store(fmt.Sprintf("%s@%s", user, c.c.LocalAddr()), m2)
-}
+/************resonse handler***********/
 
-func generateScrambleData(password string) []byte {
-	// SHA256(PASSWORD)
-	crypt := sha256.New()
-	crypt.Write([]byte(password))
-	m1 := crypt.Sum(nil)
-	// SHA256(SHA256(PASSWORD))
-	crypt.Reset()
-	crypt.Write(m1)
-	m2 := crypt.Sum(nil)
-	return m2
+func (c *Session) readAuthSwitchRequestResponse() ([]byte, error) {
+	data, err := c.c.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 1 && data[0] == 0x00 {
+		// \NUL
+		return make([]byte, 0), nil
+	}
+	return data, nil
 }
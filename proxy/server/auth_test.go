@@ -0,0 +1,92 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func encryptCachingSha2Password(t *testing.T, publicKeyPEM []byte, salt []byte, password string) []byte {
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("invalid public key: %s", publicKeyPEM)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse public key error: %v", err)
+	}
+	plain := make([]byte, len(password)+1)
+	copy(plain, password)
+	for i := range plain {
+		plain[i] ^= salt[i%len(salt)]
+	}
+	enc, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub.(*rsa.PublicKey), plain, nil)
+	if err != nil {
+		t.Fatalf("encrypt password error: %v", err)
+	}
+	return enc
+}
+
+func TestCachingSha2EncryptedPassword(t *testing.T) {
+	if err := InitCachingSha2RSAKey(""); err != nil {
+		t.Fatalf("init RSA key error: %v", err)
+	}
+	key, publicKey := getCachingSha2RSAKey()
+	salt := []byte("abcdefghijklmnopqrst")
+
+	authData := encryptCachingSha2Password(t, publicKey, salt, "gaea_password")
+	if !checkCachingSha2EncryptedPassword(key, salt, authData, "gaea_password") {
+		t.Errorf("check encrypted password should succeed")
+	}
+	if checkCachingSha2EncryptedPassword(key, salt, authData, "wrong_password") {
+		t.Errorf("check wrong password should fail")
+	}
+	if checkCachingSha2EncryptedPassword(key, []byte("tsrqponmlkjihgfedcba"), authData, "gaea_password") {
+		t.Errorf("check password with wrong salt should fail")
+	}
+}
+
+func TestInitCachingSha2RSAKeyFromFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("generate RSA key error: %v", err)
+	}
+	f, err := ioutil.TempFile("", "gaea_rsa_key")
+	if err != nil {
+		t.Fatalf("create temp file error: %v", err)
+	}
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	f.Close()
+
+	if err := InitCachingSha2RSAKey(f.Name()); err != nil {
+		t.Fatalf("init RSA key from file error: %v", err)
+	}
+	loaded, _ := getCachingSha2RSAKey()
+	if loaded.N.Cmp(key.N) != 0 {
+		t.Errorf("loaded RSA key not match")
+	}
+
+	if err := InitCachingSha2RSAKey(f.Name() + ".not_exist"); err == nil {
+		t.Errorf("init RSA key from not exist file should fail")
+	}
+}
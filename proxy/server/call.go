@@ -0,0 +1,123 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// CALL of stored procedure is executed in a single shard, which is the default slice or specified by routing hint.
+// The statement is sent as it is, all resultsets of the procedure and the final OK are returned to client.
+
+// callRoute is the shard which CALL is executed in
+type callRoute struct {
+	slice string
+	phyDB string
+}
+
+// handleCall execute CALL in the shard specified by routing hint, or the default slice if there is no hint
+func (se *SessionExecutor) handleCall(reqCtx *util.RequestContext, sql string) (*mysql.Result, error) {
+	if se.db == "" {
+		return nil, mysql.NewDefaultError(mysql.ErrNoDB)
+	}
+	ns := se.GetNamespace()
+	if ns.GetTenantIsolation() != nil {
+		return nil, mysql.NewError(mysql.ErrNotSupportedYet, "CALL is not supported by gaea when tenant isolation is enabled")
+	}
+
+	route, err := getCallRoute(ns, se.db, sql)
+	if err != nil {
+		return nil, mysql.NewError(mysql.ErrUnknown, err.Error())
+	}
+	r, err := se.executeSQLInPhyDB(reqCtx, route.slice, route.phyDB, sql)
+	if err != nil {
+		return nil, err
+	}
+
+	modifyResultStatus(r, se)
+	return r, nil
+}
+
+// getCallRoute return the shard of CALL, route_to hint specifies a slice or physical database, and shard_key hint
+// specifies the shard by value of sharding column of tables in db, all tables with the column must be in the same shard.
+func getCallRoute(ns *Namespace, db, sql string) (callRoute, error) {
+	defaultPhyDB, err := ns.GetDefaultPhyDB(db)
+	if err != nil {
+		return callRoute{}, err
+	}
+	hint, err := parser.ExtractRouteHint(sql)
+	if err != nil {
+		return callRoute{}, err
+	}
+	if hint == nil {
+		return callRoute{slice: backend.DefaultSlice, phyDB: defaultPhyDB}, nil
+	}
+
+	switch hint.Type {
+	case parser.RouteHintRouteTo:
+		if ns.GetSlice(hint.Target) != nil {
+			return callRoute{slice: hint.Target, phyDB: defaultPhyDB}, nil
+		}
+	case parser.RouteHintShardKey:
+	default:
+		return callRoute{}, fmt.Errorf("CALL can only be routed to one shard by route_to or shard_key hint")
+	}
+
+	routes := make(map[callRoute]struct{})
+	for _, rule := range ns.GetRouter().GetShardRules(db) {
+		indexes := rule.GetSubTableIndexes()
+		if hint.Type == parser.RouteHintShardKey {
+			if rule.GetType() == router.GlobalTableRuleType || rule.GetShardingColumn() != hint.ShardColumn {
+				continue
+			}
+			var value interface{} = hint.ShardValue
+			if v, err := strconv.ParseInt(hint.ShardValue, 10, 64); err == nil {
+				value = v
+			}
+			idx, err := rule.FindTableIndex(value)
+			if err != nil {
+				return callRoute{}, fmt.Errorf("find table index of shard_key hint error: %v", err)
+			}
+			indexes = []int{idx}
+		}
+		for _, idx := range indexes {
+			phyDB, err := rule.GetDatabaseNameByTableIndex(idx)
+			if err != nil {
+				return callRoute{}, err
+			}
+			if hint.Type == parser.RouteHintRouteTo && phyDB != hint.Target {
+				continue
+			}
+			routes[callRoute{slice: rule.GetSlice(rule.GetSliceIndexFromTableIndex(idx)), phyDB: phyDB}] = struct{}{}
+		}
+	}
+
+	switch len(routes) {
+	case 0:
+		return callRoute{}, fmt.Errorf("shard of %s hint not found in db %s", hint.Type, db)
+	case 1:
+		for route := range routes {
+			return route, nil
+		}
+	}
+	return callRoute{}, fmt.Errorf("%s hint matches more than one shard in db %s", hint.Type, db)
+}
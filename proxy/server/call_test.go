@@ -0,0 +1,54 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCallRoute(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+
+	tests := []struct {
+		sql    string
+		expect callRoute
+	}{
+		{"call p(1)", callRoute{slice: "slice-0", phyDB: "db_ks"}},
+		{"/*+ route_to(slice-1) */ call p(1)", callRoute{slice: "slice-1", phyDB: "db_ks"}},
+		{"/*+ shard_key(id=3) */ call p(3)", callRoute{slice: "slice-1", phyDB: "db_ks"}},
+		{"/*+ shard_key(id='1') */ call p(1)", callRoute{slice: "slice-0", phyDB: "db_ks"}},
+	}
+	for _, test := range tests {
+		route, err := getCallRoute(ns, "db_ks", test.sql)
+		assert.Nil(t, err, test.sql)
+		assert.Equal(t, test.expect, route, test.sql)
+	}
+
+	for _, sql := range []string{
+		"/*+ full_scan */ call p()",
+		"/*+ route_to(db_ks) */ call p()",
+		"/*+ route_to(db_other) */ call p()",
+		"/*+ shard_key(name=1) */ call p()",
+	} {
+		_, err := getCallRoute(ns, "db_ks", sql)
+		assert.NotNil(t, err, sql)
+	}
+}
@@ -22,7 +22,8 @@ import (
 	"github.com/XiaoMi/Gaea/mysql"
 )
 
-var connLogger = logging.GetLogger("client-conn")
+// write failures are logged with sampling to avoid flooding the log when clients disconnect in large numbers
+var connSampledLogger = logging.GetSampledLogger("client-conn")
 
 // ClientConn session client connection
 type ClientConn struct {
@@ -30,6 +31,9 @@ type ClientConn struct {
 
 	salt []byte
 
+	// capabilities negotiated with client
+	capability uint32
+
 	manager *Manager
 
 	namespace string // TODO: remove it when refactor is done
@@ -44,6 +48,7 @@ type HandshakeResponseInfo struct {
 	Database         string
 	AuthPlugin       string
 	ClientPluginAuth bool
+	Capability       uint32
 }
 
 // NewClientConn constructor of ClientConn
@@ -185,6 +190,58 @@ func (cc *ClientConn) writeInitialHandshakeV10() error {
 	return nil
 }
 
+// readChangeUser parse payload of COM_CHANGE_USER, the command byte is not included,
+// see: https://dev.mysql.com/doc/internals/en/com-change-user.html
+func (cc *ClientConn) readChangeUser(data []byte) (HandshakeResponseInfo, error) {
+	info := HandshakeResponseInfo{}
+	info.Salt = cc.salt
+	info.Capability = cc.capability
+	info.ClientPluginAuth = cc.capability&mysql.ClientPluginAuth > 0
+
+	var ok bool
+	pos := 0
+	info.User, pos, ok = mysql.ReadNullString(data, pos)
+	if !ok {
+		return info, fmt.Errorf("readChangeUser: can't read username")
+	}
+
+	// auth response of COM_CHANGE_USER is never length encoded
+	if cc.capability&mysql.ClientSecureConnection != 0 {
+		var authLen byte
+		authLen, pos, ok = mysql.ReadByte(data, pos)
+		if !ok || pos+int(authLen) > len(data) {
+			return info, fmt.Errorf("readChangeUser: can't read auth response")
+		}
+		info.AuthResponse = data[pos : pos+int(authLen)]
+		pos += int(authLen)
+	} else {
+		var auth string
+		auth, pos, ok = mysql.ReadNullString(data, pos)
+		if !ok {
+			return info, fmt.Errorf("readChangeUser: can't read auth response")
+		}
+		info.AuthResponse = []byte(auth)
+	}
+
+	info.Database, pos, ok = mysql.ReadNullString(data, pos)
+	if !ok {
+		return info, fmt.Errorf("readChangeUser: can't read db")
+	}
+
+	// character set and auth plugin name are optional
+	if pos+2 <= len(data) {
+		var collationID uint16
+		collationID, pos, _ = mysql.ReadUint16(data, pos)
+		info.CollationID = mysql.CollationID(collationID)
+	}
+	if info.ClientPluginAuth && pos < len(data) && bytes.IndexByte(data[pos:], 0x00) >= 0 {
+		info.AuthPlugin, _ = readPluginName(data, pos, cc.capability)
+	} else {
+		info.AuthPlugin = mysql.AUTH_NATIVE_PASSWORD
+	}
+	return info, nil
+}
+
 func readAuthData(data []byte, pos int, capability uint32) ([]byte, int, bool) {
 	// length encoded data
 	var auth []byte
@@ -275,6 +332,7 @@ func (cc *ClientConn) readHandshakeResponse() (HandshakeResponseInfo, error) {
 		return info, fmt.Errorf("readHandshakeResponse: can't read username")
 	}
 	info.User = user
+	info.Capability = capability
 	info.ClientPluginAuth = capability&mysql.ClientPluginAuth > 0
 	info.AuthResponse, pos, ok = readAuthData(data, pos, capability)
 
@@ -293,14 +351,29 @@ func (cc *ClientConn) readHandshakeResponse() (HandshakeResponseInfo, error) {
 }
 
 func (cc *ClientConn) writeOK(status uint16) error {
-	err := cc.WriteOKPacket(0, 0, status, 0)
+	return cc.writeOKWithSessionState(0, 0, status, nil)
+}
+
+// writeOKWithSessionState write OK packet with session state information if session state has changed
+func (cc *ClientConn) writeOKWithSessionState(affectedRows, insertID uint64, status uint16, sessionState []byte) error {
+	var err error
+	if len(sessionState) == 0 {
+		err = cc.WriteOKPacket(affectedRows, insertID, status, 0)
+	} else {
+		err = cc.WriteOKPacketWithSessionState(affectedRows, insertID, status, 0, sessionState)
+	}
 	if err != nil {
-		connLogger.Warnf("write ok packet failed, %v", err)
+		connSampledLogger.Warnw("write ok packet failed",
+			logging.FieldConnID, cc.GetConnectionID(), logging.FieldNamespace, cc.namespace, "err", err)
 		return err
 	}
 	return nil
 }
 
+func (cc *ClientConn) isSessionTrackSupported() bool {
+	return cc.capability&mysql.ClientSessionTrack != 0
+}
+
 func (cc *ClientConn) writeMoreDataFlag(value byte) error {
 	data := cc.StartEphemeralPacket(2)
 	pos := 0
@@ -317,6 +390,14 @@ func (cc *ClientConn) WriteAuthMoreDataFullAuth() error {
 	return cc.writeMoreDataFlag(mysql.CacheSha2FullAuth)
 }
 
+// WriteAuthMoreDataPublicKey send RSA public key of caching_sha2_password full authentication
+func (cc *ClientConn) WriteAuthMoreDataPublicKey(publicKey []byte) error {
+	data := cc.StartEphemeralPacket(1 + len(publicKey))
+	pos := mysql.WriteByte(data, 0, mysql.MoreDataHeader)
+	copy(data[pos:], publicKey)
+	return cc.WriteEphemeralPacket()
+}
+
 func (cc *ClientConn) WriteAuthSwitchRequest(authMethod string) error {
 	l := 1 + len(authMethod) + 1 + len(cc.salt) + 1
 	data := cc.StartEphemeralPacket(l)
@@ -328,17 +409,48 @@ func (cc *ClientConn) WriteAuthSwitchRequest(authMethod string) error {
 	return cc.WriteEphemeralPacket()
 }
 
-func (cc *ClientConn) writeOKResult(status uint16, r *mysql.Result) error {
+func (cc *ClientConn) writeOKResult(status uint16, r *mysql.Result, metadata byte, sessionState []byte) error {
+	if r.Next != nil {
+		return cc.writeMultiResults(status, r, metadata, sessionState)
+	}
 	if r.Resultset == nil {
-		return cc.WriteOKPacket(r.AffectedRows, r.InsertID, status, 0)
+		return cc.writeOKWithSessionState(r.AffectedRows, r.InsertID, status, sessionState)
+	}
+	return cc.writeResultset(status, r.Resultset, metadata)
+}
+
+// writeMultiResults write results of CALL in order, SERVER_MORE_RESULTS_EXISTS is set in status of all results except the last one
+func (cc *ClientConn) writeMultiResults(status uint16, r *mysql.Result, metadata byte, sessionState []byte) error {
+	if !cc.isMultiResultsSupported() {
+		return cc.writeErrorPacket(mysql.NewError(mysql.ErrSpBadselect, "PROCEDURE can't return a result set in the given context"))
 	}
-	return cc.writeResultset(status, r.Resultset)
+	for ; r.Next != nil; r = r.Next {
+		var err error
+		if r.Resultset == nil {
+			err = cc.WriteOKPacket(r.AffectedRows, r.InsertID, status|mysql.ServerMoreResultsExists, 0)
+		} else {
+			err = cc.writeResultset(status|mysql.ServerMoreResultsExists, r.Resultset, metadata)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return cc.writeOKResult(status, r, metadata, sessionState)
+}
+
+func (cc *ClientConn) isMultiResultsSupported() bool {
+	return cc.capability&mysql.ClientMultiResults != 0
+}
+
+func (cc *ClientConn) isOptionalResultsetMetadata() bool {
+	return cc.capability&mysql.ClientOptionalResultsetMetadata != 0
 }
 
 func (cc *ClientConn) writeEOFPacket(status uint16) error {
 	err := cc.WriteEOFPacket(status, 0)
 	if err != nil {
-		connLogger.Warnf("write eof packet failed, %v", err)
+		connSampledLogger.Warnw("write eof packet failed",
+			logging.FieldConnID, cc.GetConnectionID(), logging.FieldNamespace, cc.namespace, "err", err)
 		return err
 	}
 	return nil
@@ -347,17 +459,43 @@ func (cc *ClientConn) writeEOFPacket(status uint16) error {
 func (cc *ClientConn) writeErrorPacket(err error) error {
 	e := cc.WriteErrorPacketFromError(err)
 	if e != nil {
-		connLogger.Warnf("write error packet failed, %v", err)
+		connSampledLogger.Warnw("write error packet failed",
+			logging.FieldConnID, cc.GetConnectionID(), logging.FieldNamespace, cc.namespace, "packet", err, "err", e)
 		return e
 	}
 	return nil
 }
 
-func (cc *ClientConn) writeColumnCount(count uint64) error {
+func (cc *ClientConn) isLocalInfileSupported() bool {
+	return cc.capability&mysql.ClientLocalFiles != 0
+}
+
+// requestLocalInfile send LOCAL INFILE request of LOAD DATA LOCAL INFILE, client sends content of the file after it.
+// The packet of COM_QUERY is still held when executing the statement, so ephemeral packet can't be used.
+func (cc *ClientConn) requestLocalInfile(filename string) error {
+	data := make([]byte, 0, 1+len(filename))
+	data = append(data, mysql.LocalInFileHeader)
+	data = append(data, filename...)
+	return cc.WritePacket(data)
+}
+
+// readLocalInfilePacket read a packet of file content, empty packet means end of the file
+func (cc *ClientConn) readLocalInfilePacket() ([]byte, error) {
+	return cc.ReadPacket()
+}
+
+func (cc *ClientConn) writeColumnCount(count uint64, metadata byte) error {
 	length := mysql.LenEncIntSize(count)
+	if cc.isOptionalResultsetMetadata() {
+		length++
+	}
 	data := cc.StartEphemeralPacket(length)
 	cc.manager.GetStatisticManager().AddWriteFlowCount(cc.namespace, length)
-	mysql.WriteLenEncInt(data, 0, count)
+	pos := mysql.WriteLenEncInt(data, 0, count)
+	// metadata_follows
+	if cc.isOptionalResultsetMetadata() {
+		mysql.WriteByte(data, pos, metadata)
+	}
 	return cc.WriteEphemeralPacket()
 }
 
@@ -371,19 +509,11 @@ func (cc *ClientConn) writeRow(row []byte) error {
 }
 
 // https://dev.mysql.com/doc/internals/en/com-query-response.html#packet-ProtocolText::Resultset
-func (cc *ClientConn) writeResultset(status uint16, r *mysql.Resultset) error {
+func (cc *ClientConn) writeResultset(status uint16, r *mysql.Resultset, metadata byte) error {
 	var err error
 	cc.StartWriterBuffering()
 
-	// write column count
-	columnCount := uint64(len(r.Fields))
-	err = cc.writeColumnCount(columnCount)
-	if err != nil {
-		return err
-	}
-
-	// write columns
-	err = cc.writeFieldList(status, r.Fields)
+	err = cc.writeResultsetHeader(status, r.Fields, metadata)
 	if err != nil {
 		return err
 	}
@@ -410,6 +540,22 @@ func (cc *ClientConn) writeResultset(status uint16, r *mysql.Resultset) error {
 	return nil
 }
 
+// writeResultsetHeader write column count and column definitions of resultset
+func (cc *ClientConn) writeResultsetHeader(status uint16, fields []*mysql.Field, metadata byte) error {
+	// write column count
+	columnCount := uint64(len(fields))
+	err := cc.writeColumnCount(columnCount, metadata)
+	if err != nil {
+		return err
+	}
+
+	// write columns, column definitions are skipped if client has negotiated optional metadata and doesn't need it
+	if cc.isOptionalResultsetMetadata() && metadata == mysql.ResultsetMetadataNone {
+		return cc.writeEOFPacket(status)
+	}
+	return cc.writeFieldList(status, fields)
+}
+
 func (cc *ClientConn) writeFieldList(status uint16, fs []*mysql.Field) error {
 	var err error
 	for _, f := range fs {
@@ -497,6 +643,9 @@ func (cc *ClientConn) writePrepareResponse(status uint16, s *Stmt) error {
 		2 + // number of params
 		1 + // filler
 		2 // number of warnings
+	if cc.isOptionalResultsetMetadata() {
+		length++ // metadata_follows
+	}
 	data := cc.StartEphemeralPacket(length)
 	pos := 0
 	// status ok
@@ -511,6 +660,10 @@ func (cc *ClientConn) writePrepareResponse(status uint16, s *Stmt) error {
 	pos = mysql.WriteByte(data, pos, 0)
 	// number of warnings
 	pos = mysql.WriteUint16(data, pos, 0)
+	// metadata_follows, metadata of prepared statement is always sent
+	if cc.isOptionalResultsetMetadata() {
+		pos = mysql.WriteByte(data, pos, mysql.ResultsetMetadataFull)
+	}
 	if pos != length {
 		return fmt.Errorf("internal error packet row: got %v bytes but expected %v", pos, length)
 	}
@@ -528,12 +681,18 @@ func (cc *ClientConn) writePrepareResponse(status uint16, s *Stmt) error {
 			}
 		}
 		err = cc.writeEOFPacket(status)
-		return err
+		if err != nil {
+			return err
+		}
 	}
 
 	if s.columnCount > 0 {
 		for i := 0; i < s.columnCount; i++ {
-			err = cc.writeColumnDefinition(c)
+			column := c
+			if i < len(s.columns) {
+				column = s.columns[i]
+			}
+			err = cc.writeColumnDefinition(column)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,66 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+func TestReadChangeUser(t *testing.T) {
+	cc := &ClientConn{
+		capability: DefaultCapability,
+		salt:       []byte("12345678901234567890"),
+	}
+	auth := []byte("abcdefghijklmnopqrst")
+
+	var data []byte
+	data = append(data, "gaea_user"...)
+	data = append(data, 0x00)
+	data = append(data, byte(len(auth)))
+	data = append(data, auth...)
+	data = append(data, "gaea_db"...)
+	data = append(data, 0x00)
+	data = append(data, 45, 0) // utf8mb4_general_ci
+	data = append(data, mysql.AUTH_NATIVE_PASSWORD...)
+	data = append(data, 0x00)
+
+	info, err := cc.readChangeUser(data)
+	if err != nil {
+		t.Fatalf("read change user error: %v", err)
+	}
+	if info.User != "gaea_user" || info.Database != "gaea_db" || !bytes.Equal(info.AuthResponse, auth) {
+		t.Errorf("read change user error, info: %+v", info)
+	}
+	if info.CollationID != 45 || info.AuthPlugin != mysql.AUTH_NATIVE_PASSWORD || !info.ClientPluginAuth {
+		t.Errorf("read change user error, info: %+v", info)
+	}
+
+	// character set and plugin name are optional
+	info, err = cc.readChangeUser(data[:len(data)-len(mysql.AUTH_NATIVE_PASSWORD)-3])
+	if err != nil {
+		t.Fatalf("read change user error: %v", err)
+	}
+	if info.CollationID != 0 || info.AuthPlugin != mysql.AUTH_NATIVE_PASSWORD {
+		t.Errorf("read change user error, info: %+v", info)
+	}
+
+	// auth response is truncated
+	if _, err := cc.readChangeUser(data[:len("gaea_user")+5]); err == nil {
+		t.Errorf("read truncated change user should fail")
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/provider"
+)
+
+const (
+	defaultClusterStateSyncInterval = 3 * time.Second
+	// state of proxy expires after several sync intervals, so the connections of crashed proxy will not be counted
+	clusterStateTTLFactor = 3
+)
+
+// ClusterState shares connection counts and kill requests between proxies of the same cluster through coordinator.
+// If the source type is file, ClusterState works in standalone mode and only local state is used.
+type ClusterState struct {
+	sync.RWMutex
+
+	token    string
+	store    *provider.Store // nil means standalone
+	interval time.Duration
+
+	sessions          map[string]map[string]map[*Session]struct{} // namespace -> user -> sessions of this proxy
+	remoteConnections map[string]map[string]int64                 // namespace -> user -> connections of other proxies
+	handledKills      map[string]int64                            // kill request id -> create time
+
+	closeC chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewClusterState create ClusterState, store is nil in standalone mode
+func NewClusterState(token string, store *provider.Store, interval time.Duration) *ClusterState {
+	if interval <= 0 {
+		interval = defaultClusterStateSyncInterval
+	}
+	return &ClusterState{
+		token:             token,
+		store:             store,
+		interval:          interval,
+		sessions:          make(map[string]map[string]map[*Session]struct{}),
+		remoteConnections: make(map[string]map[string]int64),
+		handledKills:      make(map[string]int64),
+		closeC:            make(chan struct{}),
+	}
+}
+
+func createClusterState(cfg *models.Proxy) (*ClusterState, error) {
+	if !provider.HasCoordinator(cfg.ConfigType) {
+		return NewClusterState("", nil, defaultClusterStateSyncInterval), nil
+	}
+
+	token, err := generateToken(cfg.ProtoType, cfg.AdminAddr)
+	if err != nil {
+		return nil, err
+	}
+	client := provider.NewClient(provider.CoordinatorType(cfg.ConfigType), cfg.CoordinatorAddr, cfg.UserName, cfg.Password, cfg.CoordinatorRoot)
+	return NewClusterState(token, provider.NewStore(client), defaultClusterStateSyncInterval), nil
+}
+
+// Start start background task of syncing state with other proxies
+func (cs *ClusterState) Start() {
+	if cs.store == nil {
+		return
+	}
+	cs.wg.Add(1)
+	go func() {
+		defer cs.wg.Done()
+		ticker := time.NewTicker(cs.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cs.sync()
+			case <-cs.closeC:
+				return
+			}
+		}
+	}()
+}
+
+// Close stop syncing and remove state of this proxy from coordinator
+func (cs *ClusterState) Close() {
+	if cs.store == nil {
+		return
+	}
+	close(cs.closeC)
+	cs.wg.Wait()
+	if err := cs.store.DeleteProxyState(cs.token); err != nil {
+		log.Warnf("delete proxy state failed, token: %s, err: %v", cs.token, err)
+	}
+	cs.store.Close()
+}
+
+func (cs *ClusterState) ttl() time.Duration {
+	return cs.interval * clusterStateTTLFactor
+}
+
+// AddSession register session of user, return error if connections of the namespace in cluster exceed
+// nsMaxConnections, or connections of the user exceed userMaxConnections. Max connections <= 0 means no limit.
+func (cs *ClusterState) AddSession(cc *Session, nsMaxConnections, userMaxConnections int64) error {
+	namespace, user := cc.namespace, cc.executor.user
+
+	cs.Lock()
+	defer cs.Unlock()
+
+	users, ok := cs.sessions[namespace]
+	if !ok {
+		users = make(map[string]map[*Session]struct{})
+		cs.sessions[namespace] = users
+	}
+	sessions, ok := users[user]
+	if !ok {
+		sessions = make(map[*Session]struct{})
+		users[user] = sessions
+	}
+
+	if nsMaxConnections > 0 && cs.namespaceConnections(namespace) >= nsMaxConnections {
+		return mysql.NewDefaultError(mysql.ErrConCount)
+	}
+	if userMaxConnections > 0 {
+		total := int64(len(sessions)) + cs.remoteConnections[namespace][user]
+		if total >= userMaxConnections {
+			return mysql.NewDefaultError(mysql.ErrTooManyUserConnections, user)
+		}
+	}
+
+	sessions[cc] = struct{}{}
+	return nil
+}
+
+// RemoveSession unregister session
+func (cs *ClusterState) RemoveSession(cc *Session) {
+	namespace, user := cc.namespace, cc.executor.user
+
+	cs.Lock()
+	defer cs.Unlock()
+
+	sessions, ok := cs.sessions[namespace][user]
+	if !ok {
+		return
+	}
+	delete(sessions, cc)
+	if len(sessions) == 0 {
+		delete(cs.sessions[namespace], user)
+	}
+	if len(cs.sessions[namespace]) == 0 {
+		delete(cs.sessions, namespace)
+	}
+}
+
+// namespaceConnections return connections of all users of namespace in cluster, must be called with lock held
+func (cs *ClusterState) namespaceConnections(namespace string) int64 {
+	var total int64
+	for _, sessions := range cs.sessions[namespace] {
+		total += int64(len(sessions))
+	}
+	for _, count := range cs.remoteConnections[namespace] {
+		total += count
+	}
+	return total
+}
+
+// GetConnectionCount return connections of user in cluster, including this proxy
+func (cs *ClusterState) GetConnectionCount(namespace, user string) int64 {
+	cs.RLock()
+	defer cs.RUnlock()
+	return int64(len(cs.sessions[namespace][user])) + cs.remoteConnections[namespace][user]
+}
+
+// KillUser close all client connections of user in cluster, return count of connections closed in this proxy
+func (cs *ClusterState) KillUser(namespace, user string) (int, error) {
+	count := cs.killLocalSessions(namespace, user)
+	if cs.store == nil {
+		return count, nil
+	}
+
+	now := time.Now()
+	req := &models.KillRequest{
+		ID:         fmt.Sprintf("%s-%d", cs.token, now.UnixNano()),
+		Namespace:  namespace,
+		User:       user,
+		Token:      cs.token,
+		CreateTime: now.Unix(),
+	}
+	cs.Lock()
+	cs.handledKills[req.ID] = req.CreateTime
+	cs.Unlock()
+
+	if err := cs.store.CreateKillRequest(req, cs.ttl()); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// GetLocalSessions return sessions of namespace in this proxy
+func (cs *ClusterState) GetLocalSessions(namespace string) []*Session {
+	cs.RLock()
+	defer cs.RUnlock()
+	var sessions []*Session
+	for _, userSessions := range cs.sessions[namespace] {
+		for cc := range userSessions {
+			sessions = append(sessions, cc)
+		}
+	}
+	return sessions
+}
+
+// getAllLocalSessions return sessions of all namespaces in this proxy
+func (cs *ClusterState) getAllLocalSessions() []*Session {
+	cs.RLock()
+	defer cs.RUnlock()
+	var sessions []*Session
+	for _, users := range cs.sessions {
+		for _, userSessions := range users {
+			for cc := range userSessions {
+				sessions = append(sessions, cc)
+			}
+		}
+	}
+	return sessions
+}
+
+func (cs *ClusterState) killLocalSessions(namespace, user string) int {
+	cs.RLock()
+	sessions := make([]*Session, 0, len(cs.sessions[namespace][user]))
+	for cc := range cs.sessions[namespace][user] {
+		sessions = append(sessions, cc)
+	}
+	cs.RUnlock()
+
+	// session is removed from cluster state when it's goroutine exits
+	for _, cc := range sessions {
+		cc.Kill()
+	}
+	return len(sessions)
+}
+
+func (cs *ClusterState) localConnections() map[string]map[string]int64 {
+	cs.RLock()
+	defer cs.RUnlock()
+	ret := make(map[string]map[string]int64, len(cs.sessions))
+	for namespace, users := range cs.sessions {
+		ret[namespace] = make(map[string]int64, len(users))
+		for user, sessions := range users {
+			ret[namespace][user] = int64(len(sessions))
+		}
+	}
+	return ret
+}
+
+func (cs *ClusterState) sync() {
+	state := &models.ProxyState{
+		Token:       cs.token,
+		UpdateTime:  time.Now().Unix(),
+		Connections: cs.localConnections(),
+	}
+	if err := cs.store.UpdateProxyState(state, cs.ttl()); err != nil {
+		log.Warnf("update proxy state failed, token: %s, err: %v", cs.token, err)
+	}
+
+	if states, err := cs.store.ListProxyStates(); err != nil {
+		log.Warnf("list proxy states failed, err: %v", err)
+	} else {
+		cs.updateRemoteConnections(states)
+	}
+
+	if requests, err := cs.store.ListKillRequests(); err != nil {
+		log.Warnf("list kill requests failed, err: %v", err)
+	} else {
+		cs.handleKillRequests(requests)
+	}
+}
+
+func (cs *ClusterState) updateRemoteConnections(states []*models.ProxyState) {
+	remote := make(map[string]map[string]int64)
+	for _, state := range states {
+		if state.Token == cs.token {
+			continue
+		}
+		for namespace, users := range state.Connections {
+			if _, ok := remote[namespace]; !ok {
+				remote[namespace] = make(map[string]int64)
+			}
+			for user, count := range users {
+				remote[namespace][user] += count
+			}
+		}
+	}
+
+	cs.Lock()
+	cs.remoteConnections = remote
+	cs.Unlock()
+}
+
+func (cs *ClusterState) handleKillRequests(requests []*models.KillRequest) {
+	for _, req := range requests {
+		cs.Lock()
+		_, handled := cs.handledKills[req.ID]
+		cs.handledKills[req.ID] = req.CreateTime
+		cs.Unlock()
+
+		if handled {
+			continue
+		}
+		count := cs.killLocalSessions(req.Namespace, req.User)
+		log.Infof("handle kill request, id: %s, namespace: %s, user: %s, killed: %d", req.ID, req.Namespace, req.User, count)
+	}
+
+	// kill requests have been expired in coordinator, no need to remember them
+	expireTime := time.Now().Add(-2 * cs.ttl()).Unix()
+	cs.Lock()
+	for id, createTime := range cs.handledKills {
+		if createTime < expireTime {
+			delete(cs.handledKills, id)
+		}
+	}
+	cs.Unlock()
+}
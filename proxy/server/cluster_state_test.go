@@ -0,0 +1,78 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func newTestClusterSession(namespace, user string) *Session {
+	return &Session{namespace: namespace, executor: &SessionExecutor{user: user}}
+}
+
+func TestClusterStateMaxConnections(t *testing.T) {
+	cs := NewClusterState("127.0.0.1:13307", nil, 0)
+
+	s1 := newTestClusterSession("ns", "u1")
+	s2 := newTestClusterSession("ns", "u1")
+	if err := cs.AddSession(s1, 0, 2); err != nil {
+		t.Fatalf("add session error: %v", err)
+	}
+
+	// one connection of u1 in other proxy
+	cs.updateRemoteConnections([]*models.ProxyState{
+		{Token: "127.0.0.1:13307", Connections: map[string]map[string]int64{"ns": {"u1": 10}}},
+		{Token: "127.0.0.2:13307", Connections: map[string]map[string]int64{"ns": {"u1": 1, "u2": 3}}},
+	})
+	if c := cs.GetConnectionCount("ns", "u1"); c != 2 {
+		t.Errorf("connection count of u1 error, expect: 2, got: %d", c)
+	}
+	if err := cs.AddSession(s2, 0, 2); err == nil {
+		t.Errorf("add session should fail when exceed max connections")
+	}
+	// 1 local and 4 remote connections of ns
+	if err := cs.AddSession(s2, 5, 0); err == nil {
+		t.Errorf("add session should fail when exceed max connections of namespace")
+	}
+	if err := cs.AddSession(s2, 6, 0); err != nil {
+		t.Errorf("add session without limit of user error: %v", err)
+	}
+
+	cs.RemoveSession(s1)
+	cs.RemoveSession(s2)
+	if c := cs.GetConnectionCount("ns", "u1"); c != 1 {
+		t.Errorf("connection count of u1 error, expect: 1, got: %d", c)
+	}
+	if len(cs.localConnections()) != 0 {
+		t.Errorf("local connections should be empty, got: %v", cs.localConnections())
+	}
+}
+
+func TestClusterStateHandleKillRequests(t *testing.T) {
+	cs := NewClusterState("127.0.0.1:13307", nil, 0)
+
+	requests := []*models.KillRequest{{ID: "k1", Namespace: "ns", User: "u1"}}
+	cs.handleKillRequests(requests)
+	if _, ok := cs.handledKills["k1"]; ok {
+		t.Errorf("expired kill request should be removed")
+	}
+
+	count, err := cs.KillUser("ns", "u1")
+	if err != nil || count != 0 {
+		t.Errorf("kill user in standalone mode error, count: %d, err: %v", count, err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+const (
+	ghostCommand = "gh-ost"
+	ptOscCommand = "pt-online-schema-change"
+
+	maxOnlineSchemaChangeOutput = 1024 // bytes of tool output kept in error message
+)
+
+// runCommand run the online schema change tool, replaced in test
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// ddlJob per shard status of a DDL of sharding table
+type ddlJob struct {
+	key     string
+	done    map[string]bool // key: DDLShard.String()
+	running bool
+}
+
+// ddlJobManager keeps DDL which failed in some shards, when the same DDL is executed again,
+// only the failed shards are retried. Jobs are kept in memory and lost after namespace reloaded.
+type ddlJobManager struct {
+	lock sync.Mutex
+	jobs map[string]*ddlJob
+}
+
+func newDDLJobManager() *ddlJobManager {
+	return &ddlJobManager{jobs: make(map[string]*ddlJob)}
+}
+
+func ddlJobKey(p *plan.DDLPlan) string {
+	return p.GetDB() + "." + p.GetTable() + ": " + strings.TrimSpace(p.GetSQL())
+}
+
+// start return the job of the DDL, a new job is created if the DDL has not been executed,
+// the same DDL can not be executed concurrently.
+func (m *ddlJobManager) start(p *plan.DDLPlan) (*ddlJob, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	key := ddlJobKey(p)
+	job, ok := m.jobs[key]
+	if !ok {
+		job = &ddlJob{key: key, done: make(map[string]bool)}
+		m.jobs[key] = job
+	}
+	if job.running {
+		return nil, fmt.Errorf("the same ddl is running in another session")
+	}
+	job.running = true
+	return job, nil
+}
+
+// finish release the job, the job is removed if all shards are done
+func (m *ddlJobManager) finish(job *ddlJob, shardCount int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	job.running = false
+	if len(job.done) == shardCount {
+		delete(m.jobs, job.key)
+	}
+}
+
+func (se *SessionExecutor) getDDLStrategy() string {
+	if se.ddlStrategy != "" {
+		return se.ddlStrategy
+	}
+	return se.GetNamespace().GetDDLStrategy()
+}
+
+// executeDDL execute DDL of sharding table in all shards, shards succeeded in previous execution
+// of the same DDL are skipped, failed shards are returned in error and can be retried.
+func (se *SessionExecutor) executeDDL(reqCtx *util.RequestContext, p *plan.DDLPlan) (*mysql.Result, error) {
+	if se.isInTransaction() {
+		return nil, fmt.Errorf("ddl of sharding table is not allowed in transaction")
+	}
+
+	jobs := se.GetNamespace().ddlJobs
+	job, err := jobs.start(p)
+	if err != nil {
+		return nil, err
+	}
+	shards := p.GetShards()
+	defer jobs.finish(job, len(shards))
+
+	strategy := se.getDDLStrategy()
+	var failed []string
+	for _, shard := range shards {
+		if job.done[shard.String()] {
+			continue
+		}
+		if err := se.executeDDLShard(reqCtx, p, shard, strategy); err != nil {
+			exeLogger.Warnf("execute ddl in shard %s error, namespace: %s, sql: %s, err: %v", shard, se.namespace, shard.SQL, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", shard, err))
+			continue
+		}
+		job.done[shard.String()] = true
+	}
+
+	if len(failed) != 0 {
+		return nil, fmt.Errorf("execute ddl failed in %d of %d shards, execute the same ddl again to retry failed shards: %s",
+			len(failed), len(shards), strings.Join(failed, "; "))
+	}
+	// cached plans may use the dropped or altered table
+	se.GetNamespace().ClearPlanCache()
+	se.GetNamespace().schemaTracker.triggerRefresh()
+	return &mysql.Result{}, nil
+}
+
+// executeDDLShard execute ALTER TABLE with online schema change tool if the strategy is set, others are executed directly
+func (se *SessionExecutor) executeDDLShard(reqCtx *util.RequestContext, p *plan.DDLPlan, shard *plan.DDLShard, strategy string) error {
+	if !p.IsAlter() || strategy == models.DDLStrategyDirect {
+		return p.ExecuteShard(reqCtx, se, shard)
+	}
+
+	slice := se.GetNamespace().GetSlice(shard.Slice)
+	if slice == nil {
+		return fmt.Errorf("slice %s not found", shard.Slice)
+	}
+	name, args, err := onlineSchemaChangeCommand(strategy, &slice.Cfg, shard, p.GetAlterSpec())
+	if err != nil {
+		return err
+	}
+	output, err := runCommand(name, args...)
+	if err != nil {
+		if len(output) > maxOnlineSchemaChangeOutput {
+			output = output[len(output)-maxOnlineSchemaChangeOutput:]
+		}
+		return fmt.Errorf("%s error: %v, output: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	exeLogger.Infof("%s succeeded in shard %s, namespace: %s, alter: %s", name, shard, se.namespace, p.GetAlterSpec())
+	return nil
+}
+
+// onlineSchemaChangeCommand return the command line of gh-ost or pt-online-schema-change altering the shard in slice master
+func onlineSchemaChangeCommand(strategy string, cfg *models.Slice, shard *plan.DDLShard, alterSpec string) (string, []string, error) {
+	host, port, err := net.SplitHostPort(cfg.Master)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid master address of slice %s: %v", cfg.Name, err)
+	}
+
+	switch strategy {
+	case models.DDLStrategyGhost:
+		return ghostCommand, []string{
+			"--host=" + host,
+			"--port=" + port,
+			"--user=" + cfg.UserName,
+			"--password=" + cfg.Password,
+			"--database=" + shard.DB,
+			"--table=" + shard.Table,
+			"--alter=" + alterSpec,
+			"--allow-on-master",
+			"--execute",
+		}, nil
+	case models.DDLStrategyPtOsc:
+		dsn := fmt.Sprintf("h=%s,P=%s,u=%s,p=%s,D=%s,t=%s", host, port, cfg.UserName, cfg.Password, shard.DB, shard.Table)
+		return ptOscCommand, []string{"--alter", alterSpec, "--execute", dsn}, nil
+	default:
+		return "", nil, fmt.Errorf("invalid ddl strategy: %s", strategy)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+func TestSetDDLStrategy(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	assert.Equal(t, models.DDLStrategyDirect, se.getDDLStrategy())
+
+	tests := []struct {
+		sql    string
+		expect string
+		valid  bool
+	}{
+		{"set ddl_strategy = 'gh-ost'", models.DDLStrategyGhost, true},
+		{"set ddl_strategy = 'PT-OSC'", models.DDLStrategyPtOsc, true},
+		{"set ddl_strategy = DEFAULT", models.DDLStrategyDirect, true},
+		{"set ddl_strategy = 'online'", models.DDLStrategyDirect, false},
+	}
+	for _, test := range tests {
+		s, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt := s.(*ast.SetStmt)
+		err = se.handleSetVariable(stmt.Variables[0])
+		assert.Equal(t, test.valid, err == nil, test.sql)
+		assert.Equal(t, test.expect, se.getDDLStrategy(), test.sql)
+	}
+}
+
+func TestOnlineSchemaChangeCommand(t *testing.T) {
+	cfg := &models.Slice{Name: "slice-0", UserName: "root", Password: "pwd", Master: "127.0.0.1:3306"}
+	shard := &plan.DDLShard{Slice: "slice-0", DB: "db_ks", Table: "tbl_ks_0001"}
+
+	name, args, err := onlineSchemaChangeCommand(models.DDLStrategyGhost, cfg, shard, "ADD COLUMN `c` INT")
+	assert.Nil(t, err)
+	assert.Equal(t, ghostCommand, name)
+	assert.Equal(t, []string{"--host=127.0.0.1", "--port=3306", "--user=root", "--password=pwd", "--database=db_ks",
+		"--table=tbl_ks_0001", "--alter=ADD COLUMN `c` INT", "--allow-on-master", "--execute"}, args)
+
+	name, args, err = onlineSchemaChangeCommand(models.DDLStrategyPtOsc, cfg, shard, "ADD COLUMN `c` INT")
+	assert.Nil(t, err)
+	assert.Equal(t, ptOscCommand, name)
+	assert.Equal(t, []string{"--alter", "ADD COLUMN `c` INT", "--execute", "h=127.0.0.1,P=3306,u=root,p=pwd,D=db_ks,t=tbl_ks_0001"}, args)
+
+	cfg.Master = "127.0.0.1"
+	_, _, err = onlineSchemaChangeCommand(models.DDLStrategyGhost, cfg, shard, "ADD COLUMN `c` INT")
+	assert.NotNil(t, err)
+}
+
+func TestExecuteDDLRetryFailedShards(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	se.ddlStrategy = models.DDLStrategyGhost
+
+	sql := "alter table tbl_ks add column c int"
+	p, err := se.getPlan(se.GetNamespace(), se.db, sql)
+	if err != nil {
+		t.Fatal("get plan error:", err)
+	}
+	dp := p.(*plan.DDLPlan)
+	assert.Equal(t, 4, len(dp.GetShards()))
+
+	defer func(f func(string, ...string) ([]byte, error)) {
+		runCommand = f
+	}(runCommand)
+
+	// tbl_ks_0002 fails in the first execution
+	var executed []string
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		table := args[5]
+		executed = append(executed, table)
+		if table == "--table=tbl_ks_0002" && len(executed) <= 4 {
+			return []byte("lock wait timeout"), fmt.Errorf("exit status 1")
+		}
+		return nil, nil
+	}
+
+	_, err = se.executeDDL(util.NewRequestContext(), dp)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "slice-1.db_ks.tbl_ks_0002")
+	assert.Equal(t, 4, len(executed))
+
+	// only the failed shard is executed again, then the job is removed
+	_, err = se.executeDDL(util.NewRequestContext(), dp)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--table=tbl_ks_0000", "--table=tbl_ks_0001", "--table=tbl_ks_0002", "--table=tbl_ks_0003", "--table=tbl_ks_0002"}, executed)
+	assert.Equal(t, 0, len(se.GetNamespace().ddlJobs.jobs))
+}
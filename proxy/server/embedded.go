@@ -0,0 +1,162 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/XiaoMi/Gaea/logging"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// client address of sessions in process
+const localClientAddr = "127.0.0.1:0"
+
+// newLocalSessionExecutor create executor of session not from mysql connection, db is selected if it's not empty
+func newLocalSessionExecutor(manager *Manager, user, namespace, clientAddr, db string) (*SessionExecutor, error) {
+	se := newSessionExecutor(manager)
+	se.user = user
+	se.namespace = namespace
+	se.clientAddr = clientAddr
+	se.connID = atomic.AddUint32(&baseConnID, 1)
+	se.SetClientCollation(mysql.DefaultCollationID, mysql.DefaultCharset)
+	if db != "" {
+		if err := se.handleUseDB(db); err != nil {
+			return nil, err
+		}
+	}
+	return se, nil
+}
+
+// killQueryOnDone kill query of session if ctx is done before the returned stop function is called
+func killQueryOnDone(ctx context.Context, se *SessionExecutor) (stop func()) {
+	doneC := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			se.killQuery()
+		case <-doneC:
+		}
+	}()
+	return func() { close(doneC) }
+}
+
+// executeQueryContext execute sql in session, statements running in backend are killed if ctx is done
+func executeQueryContext(ctx context.Context, se *SessionExecutor, sql string) Response {
+	defer killQueryOnDone(ctx, se)()
+	return se.ExecuteCommand(mysql.ComQuery, []byte(sql))
+}
+
+// executeBatchContext execute batch of sqls in session, statements running in backend are killed if ctx is done
+func executeBatchContext(ctx context.Context, se *SessionExecutor, sqls []string) []Response {
+	defer killQueryOnDone(ctx, se)()
+	return se.ExecuteBatch(sqls)
+}
+
+// bindQueryArgs replace placeholders of sql with args like prepared statements, ListArg is expanded to
+// comma separated values, sql is returned as is without args
+func bindQueryArgs(sql string, args []interface{}) (string, error) {
+	if len(args) == 0 {
+		return sql, nil
+	}
+	paramCount, offsets, err := calcParams(sql)
+	if err != nil {
+		return "", err
+	}
+	if paramCount != len(args) {
+		return "", mysql.NewDefaultError(mysql.ErrWrongArguments, "execute")
+	}
+	if err := checkListArgs(args); err != nil {
+		return "", err
+	}
+	stmt := &Stmt{sql: sql, args: args, paramCount: paramCount, offsets: offsets}
+	return stmt.bindArgs(sql, offsets), nil
+}
+
+// closeLocalSessionExecutor rollback transaction of executor and release its backend connections
+func closeLocalSessionExecutor(se *SessionExecutor) {
+	if err := se.rollback(); err != nil {
+		logging.DefaultLogger.Warnf("executor rollback error when local session close: %v", err)
+	}
+	se.unpinNamespace(true)
+}
+
+// LocalSession execute queries of a namespace user in process without mysql protocol, through the same
+// plan and execution pipeline as sessions of proxy. It's used to embed the sharding layer in go applications,
+// and must not be used concurrently.
+type LocalSession struct {
+	se *SessionExecutor
+}
+
+// NewLocalSession create session of user, db is selected if it's not empty
+func (m *Manager) NewLocalSession(user, password, db string) (*LocalSession, error) {
+	return m.newLocalSession(user, password, localClientAddr, db)
+}
+
+func (m *Manager) newLocalSession(user, password, clientAddr, db string) (*LocalSession, error) {
+	namespace := ""
+	if m.CheckUser(user) {
+		namespace = m.GetNamespaceByUser(user, password)
+	}
+	if namespace == "" || m.GetNamespace(namespace) == nil {
+		return nil, mysql.NewDefaultError(mysql.ErrAccessDenied, user, "localhost", "YES")
+	}
+	se, err := newLocalSessionExecutor(m, user, namespace, clientAddr, db)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalSession{se: se}, nil
+}
+
+// Namespace return namespace of session
+func (s *LocalSession) Namespace() string {
+	return s.se.namespace
+}
+
+// DB return current db of session
+func (s *LocalSession) DB() string {
+	return s.se.db
+}
+
+// InTransaction return true if session is in transaction
+func (s *LocalSession) InTransaction() bool {
+	return s.se.isInTransaction() || s.se.hasTransactionConns()
+}
+
+// Execute execute sql with args bound to placeholders like prepared statements, args must be nil, []byte,
+// numbers or ListArg of them, []byte is quoted as string and ListArg is expanded to comma separated values. Statements running in backend are killed if ctx is done.
+func (s *LocalSession) Execute(ctx context.Context, sql string, args []interface{}) (*mysql.Result, error) {
+	sql, err := bindQueryArgs(sql, args)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := executeQueryContext(ctx, s.se, sql)
+	switch rs.RespType {
+	case RespError:
+		return nil, rs.Data.(error)
+	case RespResult:
+		if r, ok := rs.Data.(*mysql.Result); ok && r != nil {
+			return r, nil
+		}
+	}
+	return &mysql.Result{Status: rs.Status}, nil
+}
+
+// Close rollback transaction of session and release its backend connections
+func (s *LocalSession) Close() {
+	closeLocalSessionExecutor(s.se)
+}
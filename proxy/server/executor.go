@@ -15,6 +15,7 @@
 package server
 
 import (
+	"encoding/binary"
 	"fmt"
 	"github.com/XiaoMi/Gaea/logging"
 	parser2 "github.com/XiaoMi/Gaea/parser"
@@ -22,6 +23,8 @@ import (
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/format"
 	_ "github.com/pingcap/tidb/types/parser_driver"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,10 +33,13 @@ import (
 
 	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/core/errors"
+	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/mysql"
 	"github.com/XiaoMi/Gaea/proxy/plan"
 	"github.com/XiaoMi/Gaea/util"
 	"github.com/XiaoMi/Gaea/util/hack"
+	"github.com/XiaoMi/Gaea/util/sync2"
+	"github.com/XiaoMi/Gaea/util/tracing"
 )
 
 var exeLogger = logging.GetLogger("executor")
@@ -43,6 +49,9 @@ const (
 	masterComment = "/*master*/"
 	// general query log variable
 	gaeaGeneralLogVariable = "gaea_general_log"
+	gaeaTenantIDVariable   = "tenant_id"
+	// max prepared statements of one connection, same as default max_prepared_stmt_count of mysql
+	maxPreparedStmtCount = 16382
 )
 
 // SessionExecutor is bound to a session, so requests are serializable
@@ -53,20 +62,76 @@ type SessionExecutor struct {
 	user       string
 	db         string
 	clientAddr string
+	connID     uint32
 
 	status       uint16
 	lastInsertID uint64
+	rowCount     int64  // ROW_COUNT() of last statement
+	foundRows    uint64 // FOUND_ROWS() of last statement
 
 	collation        mysql.CollationID
 	charset          string
+	clientCollation  mysql.CollationID // collation sent by client in handshake or COM_CHANGE_USER, restored by COM_RESET_CONNECTION
+	clientCharset    string
+	converter        *mysql.CharsetConverter // converter between session charset and backend_charset of namespace
 	sessionVariables *mysql.SessionVariables
+	userVariables    map[string]interface{} // user-defined variables, key is lower case name without @
 
 	txConns map[string]backend.PooledConnect
 	txLock  sync.Mutex
+	xid     string // xid of current XA transaction, empty if not in XA transaction
+
+	gtids    map[string]string // GTID of the last transaction committed by session in each slice, key: slice name
+	gtidLock sync.Mutex
+
+	// namespace used by current command or transaction, so that they are executed with the same config
+	// when the namespace is reloaded, value type: *Namespace
+	pinnedNamespace atomic.Value
+
+	transactionMode string // session transaction_mode, empty means namespace default
+	ddlStrategy     string // session ddl_strategy, empty means namespace default
+	tenantID        string // session tenant_id, only used if the user has no fixed tenant id
+	txReadOnly      bool   // transactions of session are read only, set by SET SESSION TRANSACTION READ ONLY
+	nextTxIsolation string // isolation level of the next transaction, set by SET TRANSACTION ISOLATION LEVEL
+	nextTxReadOnly  bool   // the next transaction is read only, set by START TRANSACTION READ ONLY or SET TRANSACTION READ ONLY
 
 	stmtID uint32
 	stmts  map[uint32]*Stmt //prepare相关,client端到proxy的stmt
 
+	maxExecutionTime int64 // session max_execution_time, millisecond, 0 means no limit
+	waitTimeout      int64 // session wait_timeout, second, 0 means idle timeout of namespace or proxy
+
+	resultsetMetadata byte // session resultset_metadata, only take effect if client supports optional resultset metadata
+
+	// 会话状态跟踪, 在OK包中向支持CLIENT_SESSION_TRACK的客户端报告会话状态的变化
+	trackSchema          bool   // session_track_schema
+	trackStateChange     bool   // session_track_state_change
+	trackSystemVariables string // session_track_system_variables, 逗号分隔的变量名, *表示所有变量
+	trackTransactionInfo string // session_track_transaction_info, OFF, STATE或CHARACTERISTICS
+	txRead               bool   // 当前事务中执行过读语句
+	txWritten            bool   // 当前事务中执行过写语句
+
+	resultCacheTables map[string]bool // 当前事务中写过的表, 提交后再次使缓存的结果集失效, 空字符串表示所有表
+
+	// 客户端在语句执行期间断开连接或语句被KILL QUERY时, 取消执行并KILL后端正在执行的语句
+	clientClosed sync2.AtomicBool
+	queryKilled  sync2.AtomicBool // reset before executing each command
+	runningLock  sync.Mutex
+	runningConns map[backend.PooledConnect]struct{}
+
+	// 跨分片查询的结果可以边读取边写给客户端, 只用于COM_QUERY
+	streamWriter resultsetStreamWriter
+	streamable   bool // current command can write resultset to client directly
+	streamed     bool // resultset of current command has been written to client
+
+	// 读取LOAD DATA LOCAL INFILE的文件内容, 只用于COM_QUERY
+	infileReader localInfileReader
+
+	shadow bool // session of shadowReplayer, its queries are not replayed again
+
+	// reload config of namespace in this proxy, used by RELOAD NAMESPACE in admin db, nil if admin server is not started
+	reloadNamespace func(name string) error
+
 	parser *parser.Parser
 }
 
@@ -75,6 +140,8 @@ type Response struct {
 	RespType int
 	Status   uint16
 	Data     interface{}
+
+	SessionState []byte // session state information of OK packet, only for clients with CLIENT_SESSION_TRACK
 }
 
 const (
@@ -156,20 +223,68 @@ func CreateNoopResponse() Response {
 func newSessionExecutor(manager *Manager) *SessionExecutor {
 
 	return &SessionExecutor{
-		sessionVariables: mysql.NewSessionVariables(),
-		txConns:          make(map[string]backend.PooledConnect),
-		stmts:            make(map[uint32]*Stmt),
-		parser:           parser.New(),
-		status:           initClientConnStatus,
-		manager:          manager,
+		sessionVariables:  mysql.NewSessionVariables(),
+		userVariables:     make(map[string]interface{}),
+		txConns:           make(map[string]backend.PooledConnect),
+		gtids:             make(map[string]string),
+		stmts:             make(map[uint32]*Stmt),
+		parser:            parser.New(),
+		status:            initClientConnStatus,
+		manager:           manager,
+		resultsetMetadata: mysql.ResultsetMetadataFull,
+		runningConns:      make(map[backend.PooledConnect]struct{}),
+
+		trackSchema:          true,
+		trackSystemVariables: defaultSessionTrackSystemVariables,
+		trackTransactionInfo: sessionTrackTransactionInfoOff,
 	}
 }
 
+// GetResultsetMetadata return value of session variable resultset_metadata
+func (se *SessionExecutor) GetResultsetMetadata() byte {
+	return se.resultsetMetadata
+}
+
 // GetNamespace return namespace in session
 func (se *SessionExecutor) GetNamespace() *Namespace {
+	if ns, _ := se.pinnedNamespace.Load().(*Namespace); ns != nil {
+		return ns
+	}
 	return se.manager.GetNamespace(se.namespace)
 }
 
+// pinNamespace pin the current namespace before executing command, the old namespace is not closed
+// until the session releases it after reloading
+func (se *SessionExecutor) pinNamespace() {
+	if ns, _ := se.pinnedNamespace.Load().(*Namespace); ns != nil {
+		return
+	}
+	ns := se.manager.GetNamespace(se.namespace)
+	if ns == nil {
+		return
+	}
+	ns.acquire()
+	se.pinnedNamespace.Store(ns)
+}
+
+// unpinNamespace release the pinned namespace after executing command, the namespace is kept if transaction
+// has started in backend, unless force is true. The next command will use the latest namespace.
+func (se *SessionExecutor) unpinNamespace(force bool) {
+	if !force && se.hasTransactionConns() {
+		return
+	}
+	if ns, _ := se.pinnedNamespace.Load().(*Namespace); ns != nil {
+		se.pinnedNamespace.Store((*Namespace)(nil))
+		ns.release()
+	}
+}
+
+func (se *SessionExecutor) hasTransactionConns() bool {
+	se.txLock.Lock()
+	defer se.txLock.Unlock()
+	return len(se.txConns) > 0
+}
+
 // GetVariables return variables in session
 func (se *SessionExecutor) GetVariables() *mysql.SessionVariables {
 	return se.sessionVariables
@@ -219,6 +334,47 @@ func (se *SessionExecutor) SetLastInsertID(id uint64) {
 	se.lastInsertID = id
 }
 
+// GetRowCount return affected rows of last statement, -1 if it returns resultset or error
+func (se *SessionExecutor) GetRowCount() int64 {
+	return se.rowCount
+}
+
+// GetFoundRows return rows of last resultset
+func (se *SessionExecutor) GetFoundRows() uint64 {
+	return se.foundRows
+}
+
+// GetConnectionID return connection id of the session
+func (se *SessionExecutor) GetConnectionID() uint32 {
+	return se.connID
+}
+
+// RecordDualWriteMismatch implement plan.DualWriteRecorder
+func (se *SessionExecutor) RecordDualWriteMismatch(db, table, reason string, err error) {
+	exeLogger.Warnf("dual write mismatch, namespace: %s, table: %s.%s, reason: %s, err: %v", se.namespace, db, table, reason, err)
+	se.manager.GetStatisticManager().RecordDualWriteMismatch(se.namespace, db+"."+table, reason)
+}
+
+// recordRowCount store ROW_COUNT() and FOUND_ROWS() of the statement, streamed resultset is written
+// to client directly, its rows are counted during streaming.
+func (se *SessionExecutor) recordRowCount(reqCtx *util.RequestContext, r *mysql.Result, err error) {
+	switch {
+	case err != nil:
+		se.rowCount = -1
+	case se.streamed || (r != nil && r.Resultset != nil && len(r.Fields) != 0):
+		se.rowCount = -1
+		se.foundRows = uint64(getRowCountFromContext(reqCtx))
+		// total rows counted for SQL_CALC_FOUND_ROWS
+		if n, ok := reqCtx.Get(util.FoundRows).(uint64); ok {
+			se.foundRows = n
+		}
+	case r != nil:
+		se.rowCount = int64(r.AffectedRows)
+	default:
+		se.rowCount = 0
+	}
+}
+
 // GetStatus return session status
 func (se *SessionExecutor) GetStatus() uint16 {
 	return se.status
@@ -234,6 +390,14 @@ func (se *SessionExecutor) SetCollationID(id mysql.CollationID) {
 	se.collation = id
 }
 
+// SetClientCollation store collation sent by client, the session charset is reset to it by COM_RESET_CONNECTION
+func (se *SessionExecutor) SetClientCollation(id mysql.CollationID, charset string) {
+	se.collation = id
+	se.charset = charset
+	se.clientCollation = id
+	se.clientCharset = charset
+}
+
 // SetNamespaceDefaultCollationID store default collation id
 func (se *SessionExecutor) SetNamespaceDefaultCollationID() {
 	se.collation = se.manager.GetNamespace(se.namespace).GetDefaultCollationID()
@@ -271,6 +435,10 @@ func (se *SessionExecutor) GetDatabase() string {
 
 // ExecuteCommand execute command
 func (se *SessionExecutor) ExecuteCommand(cmd byte, data []byte) Response {
+	se.queryKilled.Set(false)
+	se.pinNamespace()
+	defer se.unpinNamespace(false)
+	se.enableGTIDTracking()
 	switch cmd {
 	case mysql.ComQuit:
 		se.handleRollback()
@@ -278,12 +446,25 @@ func (se *SessionExecutor) ExecuteCommand(cmd byte, data []byte) Response {
 		// either a connection close or a OK_Packet, OK_Packet will cause client RST sometimes, but doesn't affect parser execute
 		return CreateNoopResponse()
 	case mysql.ComQuery: // data type: string[EOF]
-		sql := string(data)
+		sql := se.decodeClientSQL(data)
 		// handle phase
+		se.streamable = true
 		r, err := se.handleQuery(sql)
+		se.streamable = false
+		if se.streamed {
+			// resultset has been written, error packet is still allowed if the resultset is not finished
+			se.streamed = false
+			if err != nil {
+				return CreateErrorResponse(se.status, err)
+			}
+			return CreateNoopResponse()
+		}
 		if err != nil {
 			return CreateErrorResponse(se.status, err)
 		}
+		if r, err = se.convertResult(r); err != nil {
+			return CreateErrorResponse(se.status, err)
+		}
 		return CreateResultResponse(se.status, r)
 	case mysql.ComPing:
 		return CreateOKResponse(se.status)
@@ -302,7 +483,7 @@ func (se *SessionExecutor) ExecuteCommand(cmd byte, data []byte) Response {
 		}
 		return CreateFieldListResponse(se.status, fs)
 	case mysql.ComStmtPrepare:
-		sql := string(data)
+		sql := se.decodeClientSQL(data)
 		stmt, err := se.handleStmtPrepare(sql)
 		if err != nil {
 			return CreateErrorResponse(se.status, err)
@@ -335,6 +516,17 @@ func (se *SessionExecutor) ExecuteCommand(cmd byte, data []byte) Response {
 		return CreateOKResponse(se.status)
 	case mysql.ComSetOption:
 		return CreateEOFResponse(se.status)
+	case mysql.ComResetConnection:
+		se.handleResetConnection()
+		return CreateOKResponse(se.status)
+	case mysql.ComProcessKill:
+		if len(data) < 4 {
+			return CreateErrorResponse(se.status, mysql.NewDefaultError(mysql.ErrMalformedPacket))
+		}
+		if err := se.handleKill(uint64(binary.LittleEndian.Uint32(data)), false); err != nil {
+			return CreateErrorResponse(se.status, err)
+		}
+		return CreateOKResponse(se.status)
 	default:
 		msg := fmt.Sprintf("command %d not supported now", cmd)
 		exeLogger.Warnf("dispatch command failed, error: %s", msg)
@@ -342,11 +534,11 @@ func (se *SessionExecutor) ExecuteCommand(cmd byte, data []byte) Response {
 	}
 }
 
-func (se *SessionExecutor) getBackendConns(sqls map[string]map[string][]string, fromSlave bool) (pcs map[string]backend.PooledConnect, err error) {
+func (se *SessionExecutor) getBackendConns(sqls map[string]map[string][]string, fromSlave, readOnly bool) (pcs map[string]backend.PooledConnect, err error) {
 	pcs = make(map[string]backend.PooledConnect)
 	for sliceName := range sqls {
 		var pc backend.PooledConnect
-		pc, err = se.getBackendConn(sliceName, fromSlave)
+		pc, err = se.getBackendConn(sliceName, fromSlave, readOnly)
 		if err != nil {
 			return
 		}
@@ -355,10 +547,19 @@ func (se *SessionExecutor) getBackendConns(sqls map[string]map[string][]string,
 	return
 }
 
-func (se *SessionExecutor) getBackendConn(sliceName string, fromSlave bool) (pc backend.PooledConnect, err error) {
+// getBackendConn get connection of slice, read-only statement may be read from slave if master is isolated by circuit breaker
+func (se *SessionExecutor) getBackendConn(sliceName string, fromSlave, readOnly bool) (pc backend.PooledConnect, err error) {
 	if !se.isInTransaction() {
 		slice := se.GetNamespace().GetSlice(sliceName)
-		return slice.GetConn(fromSlave, se.GetNamespace().GetUserProperty(se.user))
+		if readOnly {
+			pc, err = slice.GetReadConn(fromSlave, se.GetNamespace().GetUserProperty(se.user))
+		} else {
+			pc, err = slice.GetConn(fromSlave, se.GetNamespace().GetUserProperty(se.user))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return se.waitForGTIDs(slice, pc)
 	}
 	return se.getTransactionConn(sliceName)
 }
@@ -371,12 +572,50 @@ func (se *SessionExecutor) getTransactionConn(sliceName string) (pc backend.Pool
 	pc, ok = se.txConns[sliceName]
 
 	if !ok {
-		slice := se.GetNamespace().GetSlice(sliceName) // returns nil only when the conf is error (fatal) so panic is correct
-		if pc, err = slice.GetMasterConn(); err != nil {
+		mode := se.getTransactionMode()
+		if mode == models.TransactionModeSingle && len(se.txConns) > 0 {
+			err = fmt.Errorf("transaction_mode is single, transaction can not span slices, slice: %s", sliceName)
 			return
 		}
 
-		if !se.isAutoCommit() {
+		// read-only transaction of read write splitting user is executed in slaves
+		readOnly := se.isReadOnlyTransaction()
+		ns := se.GetNamespace()
+		slice := ns.GetSlice(sliceName) // returns nil only when the conf is error (fatal) so panic is correct
+		if readOnly && ns.IsRWSplit(se.user) {
+			if pc, err = slice.GetReadConn(true, ns.GetUserProperty(se.user)); err == nil {
+				pc, err = se.waitForGTIDs(slice, pc)
+			}
+		} else {
+			pc, err = slice.GetMasterConn()
+		}
+		if err != nil {
+			return
+		}
+
+		// session_track_gtids can't be set in transaction
+		if ns.isSessionConsistency() {
+			if err = initBackendConn(pc, "", se.backendCharset(), se.backendCollationID(), se.sessionVariables); err != nil {
+				pc.Close()
+				pc.Recycle()
+				return
+			}
+		}
+
+		if err = se.setTransactionCharacteristics(pc, readOnly); err != nil {
+			pc.Close()
+			pc.Recycle()
+			return
+		}
+
+		// read-only transaction has nothing to commit atomically, XA is not needed
+		if mode == models.TransactionModeTwoPC && !readOnly {
+			if err = se.startXABranch(pc); err != nil {
+				pc.Close()
+				pc.Recycle()
+				return
+			}
+		} else if !se.isAutoCommit() {
 			if err = pc.SetAutoCommit(0); err != nil {
 				pc.Close()
 				pc.Recycle()
@@ -396,18 +635,125 @@ func (se *SessionExecutor) getTransactionConn(sliceName string) (pc backend.Pool
 	return
 }
 
-func (se *SessionExecutor) executeInSlice(reqCtx *util.RequestContext, pc backend.PooledConnect, sql string) ([]*mysql.Result, error) {
+func (se *SessionExecutor) executeInSlice(reqCtx *util.RequestContext, slice string, pc backend.PooledConnect, sql string) ([]*mysql.Result, error) {
 	startTime := time.Now()
-	r, err := pc.Execute(sql)
-	se.manager.RecordBackendSQLMetrics(reqCtx, se.namespace, sql, pc.GetAddr(), startTime, err)
+	span := startShardSpan(reqCtx, slice, pc.GetAddr(), sql)
+	r, err := se.executeInConn(reqCtx, pc, attachShardSpan(reqCtx, span, sql))
+	tracing.EndSpan(span, err)
+	se.manager.RecordBackendSQLMetrics(reqCtx, se.namespace, slice, sql, pc.GetAddr(), startTime, err)
 
 	if err != nil {
 		return nil, err
 	}
+	se.trackGTIDs(slice, pc)
 
 	return []*mysql.Result{r}, err
 }
 
+// executeInConn execute sql in backend connection, if client has disconnected,
+// the execution is cancelled and ErrQueryInterrupted is returned.
+func (se *SessionExecutor) executeInConn(reqCtx *util.RequestContext, pc backend.PooledConnect, sql string) (*mysql.Result, error) {
+	se.addRunningConn(pc)
+	defer se.removeRunningConn(pc)
+	if se.isCancelled() {
+		return nil, mysql.NewDefaultError(mysql.ErrQueryInterrupted)
+	}
+
+	r, err := executeWithDeadline(reqCtx, pc, sql)
+	if err != nil && se.isCancelled() {
+		return nil, mysql.NewDefaultError(mysql.ErrQueryInterrupted)
+	}
+	return r, err
+}
+
+func (se *SessionExecutor) addRunningConn(pc backend.PooledConnect) {
+	se.runningLock.Lock()
+	se.runningConns[pc] = struct{}{}
+	se.runningLock.Unlock()
+}
+
+func (se *SessionExecutor) removeRunningConn(pc backend.PooledConnect) {
+	se.runningLock.Lock()
+	delete(se.runningConns, pc)
+	se.runningLock.Unlock()
+}
+
+func (se *SessionExecutor) isClientClosed() bool {
+	return se.clientClosed.Get()
+}
+
+// isCancelled return true if execution of current command is cancelled by client disconnection or KILL QUERY
+func (se *SessionExecutor) isCancelled() bool {
+	return se.clientClosed.Get() || se.queryKilled.Get()
+}
+
+// cancelExecution cancel execution because client has gone away, statements running in backend will be killed
+func (se *SessionExecutor) cancelExecution() {
+	se.clientClosed.Set(true)
+	se.killRunningQueries()
+}
+
+// killQuery cancel execution of current command by KILL QUERY, statements running in backend will be killed
+func (se *SessionExecutor) killQuery() {
+	se.queryKilled.Set(true)
+	se.killRunningQueries()
+}
+
+// killRunningQueries kill statements running in backend connections of the session
+func (se *SessionExecutor) killRunningQueries() {
+	se.runningLock.Lock()
+	pcs := make([]backend.PooledConnect, 0, len(se.runningConns))
+	for pc := range se.runningConns {
+		pcs = append(pcs, pc)
+	}
+	se.runningLock.Unlock()
+
+	for _, pc := range pcs {
+		if err := pc.KillQuery(); err != nil {
+			exeLogger.Warnf("kill query of backend connection failed, addr: %s, connection id: %d, error: %v",
+				pc.GetAddr(), pc.GetConnectionID(), err)
+		}
+	}
+}
+
+// executeWithDeadline execute sql in backend connection, if the deadline in request context is exceeded,
+// the running statement will be killed in backend mysql and ErrQueryTimeout is returned.
+func executeWithDeadline(reqCtx *util.RequestContext, pc backend.PooledConnect, sql string) (*mysql.Result, error) {
+	return runWithDeadline(reqCtx, pc, func() (*mysql.Result, error) {
+		return executeInBackend(reqCtx, pc, sql)
+	})
+}
+
+// runWithDeadline run execute in backend connection, the running statement will be killed if the deadline is exceeded
+func runWithDeadline(reqCtx *util.RequestContext, pc backend.PooledConnect, execute func() (*mysql.Result, error)) (*mysql.Result, error) {
+	deadline, ok := reqCtx.Get(util.Deadline).(time.Time)
+	if !ok {
+		return execute()
+	}
+
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return nil, mysql.NewDefaultError(mysql.ErrQueryTimeout)
+	}
+
+	killed := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		defer close(killed)
+		if err := pc.KillQuery(); err != nil {
+			exeLogger.Warnf("kill query of backend connection failed, addr: %s, connection id: %d, error: %v",
+				pc.GetAddr(), pc.GetConnectionID(), err)
+		}
+	})
+
+	r, err := execute()
+	if !timer.Stop() {
+		// 等待KILL执行完成, 避免误杀该连接上后续执行的语句
+		<-killed
+		return nil, mysql.NewDefaultError(mysql.ErrQueryTimeout)
+	}
+	return r, err
+}
+
 func (se *SessionExecutor) recycleBackendConn(pc backend.PooledConnect, rollback bool) {
 	if pc == nil {
 		return
@@ -489,34 +835,67 @@ func (se *SessionExecutor) executeInMultiSlices(reqCtx *util.RequestContext, pcs
 		}
 	}
 
-	rs := make([]interface{}, resultCount)
+	rs := make([]*mysql.Result, resultCount)
+	errs := make(map[string]error, len(pcs))
+	var errLock sync.Mutex
 
-	f := func(reqCtx *util.RequestContext, rs []interface{}, i int, execSqls map[string][]string, pc backend.PooledConnect) {
-		for db, sqls := range execSqls {
-			err := initBackendConn(pc, db, se.GetCharset(), se.GetCollationID(), se.GetVariables())
-			if err != nil {
-				rs[i] = err
-				break
-			}
-			for _, v := range sqls {
-				startTime := time.Now()
-				r, err := pc.Execute(v)
-				se.manager.RecordBackendSQLMetrics(reqCtx, se.namespace, v, pc.GetAddr(), startTime, err)
-				if err != nil {
-					rs[i] = err
-				} else {
+	// limit the number of slices executed concurrently
+	var sem chan struct{}
+	if p := se.GetNamespace().getMaxParallelism(); p > 0 && p < len(pcs) {
+		sem = make(chan struct{}, p)
+	}
+	// statements not executed yet are cancelled once any slice fails
+	var cancelled sync2.AtomicBool
+
+	f := func(reqCtx *util.RequestContext, rs []*mysql.Result, i int, slice string, execSqls map[string][]string, pc backend.PooledConnect) {
+		defer wg.Done()
+		if sem != nil {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+		}
+
+		err := func() error {
+			for db, sqls := range execSqls {
+				if cancelled.Get() {
+					return errors.ErrExecutionCancelled
+				}
+				if err := initBackendConn(pc, db, se.backendCharset(), se.backendCollationID(), se.GetVariables()); err != nil {
+					return err
+				}
+				for _, v := range sqls {
+					if cancelled.Get() {
+						return errors.ErrExecutionCancelled
+					}
+					startTime := time.Now()
+					span := startShardSpan(reqCtx, slice, pc.GetAddr(), v)
+					r, err := se.executeInConn(reqCtx, pc, attachShardSpan(reqCtx, span, v))
+					tracing.EndSpan(span, err)
+					se.manager.RecordBackendSQLMetrics(reqCtx, se.namespace, slice, v, pc.GetAddr(), startTime, err)
+					if err != nil {
+						return err
+					}
+					se.trackGTIDs(slice, pc)
 					rs[i] = r
+					i++
 				}
-				i++
 			}
+			return nil
+		}()
+
+		if err != nil {
+			if err != errors.ErrExecutionCancelled {
+				cancelled.Set(true)
+			}
+			errLock.Lock()
+			errs[slice] = err
+			errLock.Unlock()
 		}
-		wg.Done()
 	}
 
 	offset := 0
 	for sliceName, pc := range pcs {
 		s := sqls[sliceName] //map[string][]string
-		go f(reqCtx, rs, offset, s, pc)
+		go f(reqCtx, rs, offset, sliceName, s, pc)
 		for _, sqlDB := range sqls[sliceName] {
 			offset += len(sqlDB)
 		}
@@ -524,19 +903,52 @@ func (se *SessionExecutor) executeInMultiSlices(reqCtx *util.RequestContext, pcs
 
 	wg.Wait()
 
-	var err error
-	r := make([]*mysql.Result, resultCount)
-	for i, v := range rs {
-		if e, ok := v.(error); ok {
-			err = e
-			break
+	if err := mergeSliceErrors(errs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// mergeSliceErrors merge errors of slices into one error returned to client.
+// Cancelled executions are ignored, error code of the first mysql error is used if there are multiple errors.
+func mergeSliceErrors(sliceErrs map[string]error) error {
+	sliceNames := make([]string, 0, len(sliceErrs))
+	for sliceName, err := range sliceErrs {
+		if err != errors.ErrExecutionCancelled {
+			sliceNames = append(sliceNames, sliceName)
 		}
-		if rs[i] != nil {
-			r[i] = rs[i].(*mysql.Result)
+	}
+	if len(sliceNames) == 0 {
+		// should not happen, cancellation is always caused by an error
+		for _, err := range sliceErrs {
+			return err
 		}
+		return nil
+	}
+	if len(sliceNames) == 1 {
+		return sliceErrs[sliceNames[0]]
 	}
 
-	return r, err
+	sort.Strings(sliceNames)
+	var sqlErr *mysql.SQLError
+	msgs := make([]string, 0, len(sliceNames))
+	for _, sliceName := range sliceNames {
+		err := sliceErrs[sliceName]
+		if e, ok := err.(*mysql.SQLError); ok {
+			if sqlErr == nil {
+				sqlErr = e
+			}
+			msgs = append(msgs, fmt.Sprintf("%s: %s", sliceName, e.Message))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", sliceName, err))
+		}
+	}
+
+	msg := fmt.Sprintf("execute in %d slices failed, %s", len(sliceNames), strings.Join(msgs, "; "))
+	if sqlErr == nil {
+		return mysql.NewError(mysql.ErrUnknown, msg)
+	}
+	return &mysql.SQLError{Code: sqlErr.Code, State: sqlErr.State, Message: msg}
 }
 
 const variableRestoreFlag = format.RestoreKeyWordLowercase | format.RestoreNameLowercase
@@ -575,13 +987,26 @@ func canExecuteFromSlave(c *SessionExecutor, sql string) bool {
 	return fromSlave
 }
 
-// 如果是只读用户, 且SQL是INSERT, UPDATE, DELETE, 则拒绝执行, 返回true
+// 如果是只读用户或只读namespace, 且SQL是INSERT, REPLACE, UPDATE, DELETE, 则拒绝执行, 返回true
 func isSQLNotAllowedByUser(c *SessionExecutor, stmtType parser2.StatementType) bool {
 	if c.GetNamespace().IsAllowWrite(c.user) {
 		return false
 	}
 
-	return stmtType == parser2.StmtDelete || stmtType == parser2.StmtInsert || stmtType == parser2.StmtUpdate
+	return isWriteStmt(stmtType)
+}
+
+func isWriteStmt(stmtType parser2.StatementType) bool {
+	return stmtType == parser2.StmtDelete || stmtType == parser2.StmtInsert || stmtType == parser2.StmtReplace || stmtType == parser2.StmtUpdate ||
+		stmtType == parser2.StmtLoadData || stmtType == parser2.StmtCall
+}
+
+var setNextTransactionRegexp = regexp.MustCompile(`(?i)^set\s+transaction\s`)
+
+// isSetNextTransaction check if the statement is SET TRANSACTION without SESSION, which only takes effect on the next transaction
+func isSetNextTransaction(sql string) bool {
+	query, _ := parser2.SplitMarginComments(sql)
+	return setNextTransactionRegexp.MatchString(query)
 }
 
 func modifyResultStatus(r *mysql.Result, cc *SessionExecutor) {
@@ -642,16 +1067,46 @@ func getFromSlave(reqCtx *util.RequestContext) bool {
 	return false
 }
 
+// isReadOnlyRequest return true if the statement of request is SELECT
+func isReadOnlyRequest(reqCtx *util.RequestContext) bool {
+	stmtType, _ := reqCtx.Get(util.StmtType).(parser2.StatementType)
+	return stmtType == parser2.StmtSelect
+}
+
 func (se *SessionExecutor) isInTransaction() bool {
 	return se.status&mysql.ServerStatusInTrans > 0 ||
 		!se.isAutoCommit()
 }
 
+// getTenantID return tenant id of user, or session tenant_id if the user has no fixed tenant id
+func (se *SessionExecutor) getTenantID() string {
+	if id := se.GetNamespace().GetUserTenantID(se.user); id != "" {
+		return id
+	}
+	return se.tenantID
+}
+
+// getTransactionMode return transaction mode of session, namespace default is used if not set
+func (se *SessionExecutor) getTransactionMode() string {
+	if se.transactionMode != "" {
+		return se.transactionMode
+	}
+	return se.GetNamespace().GetTransactionMode()
+}
+
 func (se *SessionExecutor) isAutoCommit() bool {
 	return se.status&mysql.ServerStatusAutocommit > 0
 }
 
-func (se *SessionExecutor) handleBegin() error {
+func (se *SessionExecutor) handleBegin(readOnly bool) error {
+	// BEGIN commits the current transaction implicitly, XA transaction must be committed by proxy,
+	// and characteristics of the new transaction can only be set before it's started in backend connections
+	if se.xid != "" || (len(se.txConns) > 0 && (readOnly || se.getTransactionIsolation() != "" || se.txReadOnly)) {
+		if err := se.commit(); err != nil {
+			return err
+		}
+	}
+
 	se.txLock.Lock()
 	defer se.txLock.Unlock()
 
@@ -660,10 +1115,74 @@ func (se *SessionExecutor) handleBegin() error {
 			return err
 		}
 	}
+	if readOnly {
+		se.nextTxReadOnly = true
+	}
 	se.status |= mysql.ServerStatusInTrans
 	return nil
 }
 
+// isReadOnlyTransaction check if the current or next transaction is read only
+func (se *SessionExecutor) isReadOnlyTransaction() bool {
+	return se.txReadOnly || se.nextTxReadOnly
+}
+
+// getTransactionIsolation return isolation level of the current or next transaction, empty means default of backend
+func (se *SessionExecutor) getTransactionIsolation() string {
+	if se.nextTxIsolation != "" {
+		return se.nextTxIsolation
+	}
+	for _, name := range []string{mysql.TransactionIsolation, mysql.TxIsolation} {
+		if v, ok := se.sessionVariables.Get(name); ok {
+			return v.(*mysql.Variable).Get().(string)
+		}
+	}
+	return ""
+}
+
+// setTransactionCharacteristics set isolation level and access mode before the transaction is started in the backend
+// connection, so that all slices joining the transaction have the same characteristics.
+func (se *SessionExecutor) setTransactionCharacteristics(pc backend.PooledConnect, readOnly bool) error {
+	var characteristics []string
+	if level := se.getTransactionIsolation(); level != "" {
+		characteristics = append(characteristics, "ISOLATION LEVEL "+strings.ToUpper(strings.Replace(level, "-", " ", -1)))
+	}
+	if readOnly {
+		characteristics = append(characteristics, "READ ONLY")
+	}
+	if len(characteristics) == 0 {
+		return nil
+	}
+	_, err := pc.Execute("SET TRANSACTION " + strings.Join(characteristics, ", "))
+	return err
+}
+
+// setNextTransaction handle SET TRANSACTION without SESSION, which only takes effect on the next transaction
+func (se *SessionExecutor) setNextTransaction(v *ast.VariableAssignment) error {
+	if se.isInTransaction() {
+		return mysql.NewDefaultError(mysql.ErrCantChangeTxCharacteristics)
+	}
+	value := getVariableExprResult(v.Value)
+	switch strings.ToLower(v.Name) {
+	case "tx_isolation_one_shot":
+		if !mysql.IsValidIsolationLevel(value) {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, "transaction_isolation", value)
+		}
+		se.nextTxIsolation = value
+	case "tx_read_only":
+		se.nextTxReadOnly = value == "1"
+	}
+	return nil
+}
+
+// resetNextTransaction clear characteristics and tracked statements of the transaction after it ends, txLock must be held
+func (se *SessionExecutor) resetNextTransaction() {
+	se.nextTxIsolation = ""
+	se.nextTxReadOnly = false
+	se.txRead = false
+	se.txWritten = false
+}
+
 func (se *SessionExecutor) handleCommit() (err error) {
 	if err := se.commit(); err != nil {
 		return err
@@ -682,15 +1201,28 @@ func (se *SessionExecutor) commit() (err error) {
 	defer se.txLock.Unlock()
 
 	se.status &= ^mysql.ServerStatusInTrans
+	se.resetNextTransaction()
 
-	for _, pc := range se.txConns {
+	if se.xid != "" {
+		err = se.commitXA()
+		se.xid = ""
+		se.recycleTransactionConns()
+		se.invalidateTransactionResultCache()
+		return
+	}
+
+	// slices are committed one by one, slices committed before a failure are not rolled back
+	for sliceName, pc := range se.txConns {
 		if e := pc.Commit(); e != nil {
+			exeLogger.Warnf("commit transaction error, namespace: %s, slice: %s, err: %v", se.namespace, sliceName, e)
 			err = e
 		}
+		se.trackGTIDs(sliceName, pc)
 		pc.Recycle()
 	}
 
 	se.txConns = make(map[string]backend.PooledConnect)
+	se.invalidateTransactionResultCache()
 	return
 }
 
@@ -699,6 +1231,15 @@ func (se *SessionExecutor) rollback() (err error) {
 	defer se.txLock.Unlock()
 
 	se.status &= ^mysql.ServerStatusInTrans
+	se.resetNextTransaction()
+	se.resultCacheTables = nil
+
+	if se.xid != "" {
+		err = se.rollbackXA()
+		se.xid = ""
+		se.recycleTransactionConns()
+		return
+	}
 
 	for _, pc := range se.txConns {
 		if e := pc.Rollback(); e != nil {
@@ -711,6 +1252,44 @@ func (se *SessionExecutor) rollback() (err error) {
 	return
 }
 
+func (se *SessionExecutor) recycleTransactionConns() {
+	for sliceName, pc := range se.txConns {
+		se.trackGTIDs(sliceName, pc)
+		pc.Recycle()
+	}
+	se.txConns = make(map[string]backend.PooledConnect)
+}
+
+// resetSession rollback transaction and reset session state, used by COM_CHANGE_USER and COM_RESET_CONNECTION
+func (se *SessionExecutor) resetSession() error {
+	err := se.rollback()
+	// namespace may be changed by COM_CHANGE_USER
+	se.unpinNamespace(true)
+
+	se.status = initClientConnStatus
+	se.lastInsertID = 0
+	se.rowCount = 0
+	se.foundRows = 0
+	se.sessionVariables = mysql.NewSessionVariables()
+	se.userVariables = make(map[string]interface{})
+	se.gtidLock.Lock()
+	se.gtids = make(map[string]string)
+	se.gtidLock.Unlock()
+	se.stmts = make(map[uint32]*Stmt)
+	se.maxExecutionTime = 0
+	se.waitTimeout = 0
+	se.transactionMode = ""
+	se.ddlStrategy = ""
+	se.tenantID = ""
+	se.txReadOnly = false
+	se.resultsetMetadata = mysql.ResultsetMetadataFull
+	se.trackSchema = true
+	se.trackStateChange = false
+	se.trackSystemVariables = defaultSessionTrackSystemVariables
+	se.trackTransactionInfo = sessionTrackTransactionInfoOff
+	return err
+}
+
 func changeToEmptyResult(raw *mysql.Result) (*mysql.Result, error) {
 	r := new(mysql.Resultset)
 
@@ -737,13 +1316,17 @@ func (se *SessionExecutor) executeSQLNoData(reqCtx *util.RequestContext, slice,
 
 // ExecuteSQL execute parser
 func (se *SessionExecutor) ExecuteSQL(reqCtx *util.RequestContext, slice, db, sql string) (*mysql.Result, error) {
-	pc, err := se.getBackendConn(slice, getFromSlave(reqCtx))
-	defer se.recycleBackendConn(pc, false)
+	phyDB, err := se.GetNamespace().GetDefaultPhyDB(db)
 	if err != nil {
 		return nil, err
 	}
+	return se.executeSQLInPhyDB(reqCtx, slice, phyDB, sql)
+}
 
-	phyDB, err := se.GetNamespace().GetDefaultPhyDB(db)
+// executeSQLInPhyDB execute sql in the physical database of slice
+func (se *SessionExecutor) executeSQLInPhyDB(reqCtx *util.RequestContext, slice, phyDB, sql string) (*mysql.Result, error) {
+	pc, err := se.getBackendConn(slice, getFromSlave(reqCtx), isReadOnlyRequest(reqCtx))
+	defer se.recycleBackendConn(pc, false)
 	if err != nil {
 		return nil, err
 	}
@@ -752,12 +1335,15 @@ func (se *SessionExecutor) ExecuteSQL(reqCtx *util.RequestContext, slice, db, sq
 		phyDB = "mysql"
 	}
 
-	if err = initBackendConn(pc, phyDB, se.charset, se.collation, se.sessionVariables); err != nil {
+	if err = initBackendConn(pc, phyDB, se.backendCharset(), se.backendCollationID(), se.sessionVariables); err != nil {
 		return nil, err
 	}
 
+	addShardCount(reqCtx, 1)
+	addShards(reqCtx, map[string]map[string][]string{slice: {phyDB: nil}})
+
 	// execute.parser may be rewritten in getShowExecDB
-	rs, err := se.executeInSlice(reqCtx, pc, sql)
+	rs, err := se.executeInSlice(reqCtx, slice, pc, sql)
 	if err != nil {
 		return nil, err
 	}
@@ -776,12 +1362,24 @@ func (se *SessionExecutor) ExecuteSQLs(reqCtx *util.RequestContext, sqls map[str
 		return nil, fmt.Errorf("no parser to execute")
 	}
 
-	pcs, err := se.getBackendConns(sqls, getFromSlave(reqCtx))
+	shardCount := 0
+	for _, dbSQLs := range sqls {
+		for _, tableSQLs := range dbSQLs {
+			shardCount += len(tableSQLs)
+		}
+	}
+	if err := se.checkScatterRateLimit(shardCount); err != nil {
+		return nil, err
+	}
+
+	pcs, err := se.getBackendConns(sqls, getFromSlave(reqCtx), isReadOnlyRequest(reqCtx))
 	defer se.recycleBackendConns(pcs, false)
 	if err != nil {
 		exeLogger.Warnf("getShardConns failed: %v", err)
 		return nil, err
 	}
+	addShardCount(reqCtx, shardCount)
+	addShards(reqCtx, sqls)
 
 	rs, err := se.executeInMultiSlices(reqCtx, pcs, sqls)
 	if err != nil {
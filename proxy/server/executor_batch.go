@@ -0,0 +1,243 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// A batch is executed in order like statements sent one by one, except that consecutive INSERT, UPDATE and DELETE
+// of sharding tables are pipelined: sqls of these statements are grouped by slice, and sent in one backend
+// connection of each slice without waiting for results of the previous ones. Other statements are executed
+// alone between the pipelines. Error of a statement doesn't stop the following statements.
+
+// pipelineSize is the max count of sqls sent in one round trip of backend connection
+const pipelineSize = 64
+
+// pipelinedStatement write statement executed in pipeline with other statements
+type pipelinedStatement struct {
+	sql     string
+	plan    plan.Plan
+	sqls    map[string]map[string][]string
+	results []*mysql.Result
+	err     error
+}
+
+// pipelinedPlan is the plan of pipelined statement, it returns the result merged from the pipeline when executed,
+// so the statement still passes through middlewares of namespace.
+type pipelinedPlan struct {
+	plan.Plan
+	result *mysql.Result
+	err    error
+}
+
+// ExecuteIn implement plan.Plan
+func (p *pipelinedPlan) ExecuteIn(reqCtx *util.RequestContext, se plan.Executor) (*mysql.Result, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.result.InsertID != 0 && !plan.IsAutoIncrementSpecified(p.Plan) {
+		se.SetLastInsertID(p.result.InsertID)
+	}
+	return p.result, nil
+}
+
+// ExecuteBatch execute statements in order and return their responses, write statements are pipelined
+func (se *SessionExecutor) ExecuteBatch(sqls []string) []Response {
+	se.queryKilled.Set(false)
+	se.pinNamespace()
+	defer se.unpinNamespace(false)
+	se.enableGTIDTracking()
+
+	resps := make([]Response, len(sqls))
+	var pending []*pipelinedStatement
+	var indexes []int
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		se.executePipeline(pending)
+		for i, ps := range pending {
+			p := &pipelinedPlan{Plan: ps.plan, err: ps.err}
+			if p.err == nil {
+				p.result, p.err = plan.MergePipelineResults(ps.plan, ps.results)
+			}
+			resps[indexes[i]] = se.createBatchResponse(se.handleQueryWithPlan(ps.sql, p))
+		}
+		pending, indexes = nil, nil
+	}
+
+	for i, sql := range sqls {
+		ps, p := se.preparePipeline(sql)
+		if ps != nil {
+			pending = append(pending, ps)
+			indexes = append(indexes, i)
+			continue
+		}
+		flush()
+		resps[i] = se.createBatchResponse(se.handleQueryWithPlan(sql, p))
+	}
+	flush()
+	return resps
+}
+
+func (se *SessionExecutor) createBatchResponse(r *mysql.Result, err error) Response {
+	if err != nil {
+		return CreateErrorResponse(se.status, err)
+	}
+	return CreateResultResponse(se.status, r)
+}
+
+// preparePipeline return statement if it can be pipelined, otherwise the statement is executed alone with the
+// returned plan, which is nil if the plan is not built. Statements rejected by checks are never pipelined,
+// they are rejected again in normal execution.
+func (se *SessionExecutor) preparePipeline(sql string) (*pipelinedStatement, plan.Plan) {
+	sql = strings.TrimRight(sql, ";")
+	stmtType := parser.PreviewSql(sql)
+	if stmtType != parser.StmtInsert && stmtType != parser.StmtReplace && stmtType != parser.StmtUpdate && stmtType != parser.StmtDelete {
+		return nil, nil
+	}
+	if se.isAdminSession() || isSQLNotAllowedByUser(se, stmtType) || (se.isInTransaction() && se.isReadOnlyTransaction()) {
+		return nil, nil
+	}
+	ns := se.GetNamespace()
+	if !ns.IsSQLAllowed(util.NewRequestContext(), sql) {
+		return nil, nil
+	}
+
+	p, err := se.getPlan(ns, se.db, sql)
+	if err != nil {
+		return nil, nil
+	}
+	if plan.IsFullScatter(p) || plan.IsBroadcastWrite(p) {
+		return nil, p
+	}
+	sqls, ok := plan.GetPipelineSQLs(p)
+	if !ok {
+		return nil, p
+	}
+
+	shardCount := 0
+	for _, dbSQLs := range sqls {
+		for _, tableSQLs := range dbSQLs {
+			shardCount += len(tableSQLs)
+		}
+	}
+	// rate limit of pipelined statement is not checked again by middleware
+	if se.checkStmtRateLimit(stmtType) != nil || se.checkScatterRateLimit(shardCount) != nil {
+		return nil, p
+	}
+	return &pipelinedStatement{sql: sql, plan: p, sqls: sqls}, nil
+}
+
+// pipelineSQL sql of pipelined statement executed in a shard
+type pipelineSQL struct {
+	stmt *pipelinedStatement
+	db   string
+	sql  string
+}
+
+// executePipeline execute sqls of statements, sqls of a slice are sent in one connection in the order of statements,
+// at most pipelineSize sqls of the same db in a round trip. Slices are executed concurrently.
+func (se *SessionExecutor) executePipeline(stmts []*pipelinedStatement) {
+	slices := make(map[string][]pipelineSQL)
+	for _, ps := range stmts {
+		for slice, dbSQLs := range ps.sqls {
+			for db, sqls := range dbSQLs {
+				for _, sql := range sqls {
+					slices[slice] = append(slices[slice], pipelineSQL{stmt: ps, db: db, sql: sql})
+				}
+			}
+		}
+	}
+
+	reqCtx := util.NewRequestContext()
+	reqCtx.Set(util.ConnectionID, se.connID)
+	var lock sync.Mutex
+	record := func(ps *pipelinedStatement, r *mysql.Result, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			if ps.err == nil {
+				ps.err = err
+			}
+			return
+		}
+		ps.results = append(ps.results, r)
+	}
+
+	var wg sync.WaitGroup
+	for slice, psqls := range slices {
+		wg.Add(1)
+		go func(slice string, psqls []pipelineSQL) {
+			defer wg.Done()
+			fail := func(from int, err error) {
+				for _, s := range psqls[from:] {
+					record(s.stmt, nil, err)
+				}
+			}
+
+			pc, err := se.getBackendConn(slice, false, false)
+			defer se.recycleBackendConn(pc, false)
+			if err != nil {
+				fail(0, err)
+				return
+			}
+			se.addRunningConn(pc)
+			defer se.removeRunningConn(pc)
+
+			for start := 0; start < len(psqls); {
+				if se.isCancelled() {
+					fail(start, mysql.NewDefaultError(mysql.ErrQueryInterrupted))
+					return
+				}
+				db := psqls[start].db
+				end := start + 1
+				for end < len(psqls) && end-start < pipelineSize && psqls[end].db == db {
+					end++
+				}
+				if err := initBackendConn(pc, db, se.backendCharset(), se.backendCollationID(), se.sessionVariables); err != nil {
+					fail(start, err)
+					return
+				}
+
+				sqls := make([]string, 0, end-start)
+				for _, s := range psqls[start:end] {
+					sqls = append(sqls, s.sql)
+				}
+				startTime := time.Now()
+				rs, errs := pc.ExecutePipeline(sqls)
+				for i, s := range psqls[start:end] {
+					se.manager.RecordBackendSQLMetrics(reqCtx, se.namespace, slice, s.sql, pc.GetAddr(), startTime, errs[i])
+					record(s.stmt, rs[i], errs[i])
+				}
+				se.trackGTIDs(slice, pc)
+				if pc.IsClosed() {
+					fail(end, errs[len(errs)-1])
+					return
+				}
+				start = end
+			}
+		}(slice, psqls)
+	}
+	wg.Wait()
+}
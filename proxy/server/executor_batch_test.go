@@ -0,0 +1,85 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/backend/mocks"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockPipelineConn(pool *mocks.ConnectionPool) *mocks.PooledConnect {
+	conn := new(mocks.PooledConnect)
+	pool.On("Get", mock.Anything).Return(conn, nil).Once()
+	conn.On("UseDB", mock.Anything).Return(nil)
+	conn.On("SetCharset", "utf8", mysql.CollationID(33)).Return(false, nil)
+	conn.On("SetSessionVariables", mysql.NewSessionVariables()).Return(false, nil)
+	conn.On("GetAddr").Return("127.0.0.1:3306")
+	conn.On("IsClosed").Return(false)
+	conn.On("Recycle").Return(nil)
+	return conn
+}
+
+func TestExecutePipeline(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.manager.GetNamespace("test_executor_namespace")
+	slice0Pool := new(mocks.ConnectionPool)
+	slice1Pool := new(mocks.ConnectionPool)
+	ns.slices["slice-0"].Master = slice0Pool
+	ns.slices["slice-1"].Master = slice1Pool
+
+	dupErr := mysql.NewError(mysql.ErrDupEntry, "Duplicate entry '3' for key 'PRIMARY'")
+	slice0Conn := mockPipelineConn(slice0Pool)
+	slice0Conn.On("ExecutePipeline", []string{"insert 1 into t_0", "insert 2 into t_0"}).
+		Return([]*mysql.Result{{AffectedRows: 1}, {AffectedRows: 1, InsertID: 2}}, []error{nil, nil}).Once()
+	slice0Conn.On("ExecutePipeline", []string{"insert 3 into t_1"}).
+		Return([]*mysql.Result{nil}, []error{dupErr}).Once()
+	slice1Conn := mockPipelineConn(slice1Pool)
+	slice1Conn.On("ExecutePipeline", []string{"insert 1 into t_2"}).
+		Return([]*mysql.Result{{AffectedRows: 1}}, []error{nil}).Once()
+
+	stmts := []*pipelinedStatement{
+		{sqls: map[string]map[string][]string{
+			"slice-0": {"db_mycat_0": {"insert 1 into t_0"}},
+			"slice-1": {"db_mycat_2": {"insert 1 into t_2"}},
+		}},
+		{sqls: map[string]map[string][]string{"slice-0": {"db_mycat_0": {"insert 2 into t_0"}}}},
+		{sqls: map[string]map[string][]string{"slice-0": {"db_mycat_1": {"insert 3 into t_1"}}}},
+	}
+	se.executePipeline(stmts)
+
+	assert.Nil(t, stmts[0].err)
+	assert.Equal(t, 2, len(stmts[0].results))
+	assert.Nil(t, stmts[1].err)
+	assert.Equal(t, uint64(2), stmts[1].results[0].InsertID)
+	assert.Equal(t, dupErr, stmts[2].err)
+	slice0Conn.AssertExpectations(t)
+	slice1Conn.AssertExpectations(t)
+	slice0Conn.AssertNumberOfCalls(t, "Recycle", 1)
+
+	// result of pipeline is returned by the plan when the statement passes through middlewares
+	p := &pipelinedPlan{Plan: &plan.InsertPlan{}, result: &mysql.Result{AffectedRows: 2, InsertID: 7}}
+	r, err := p.ExecuteIn(nil, se)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), r.AffectedRows)
+	assert.Equal(t, uint64(7), se.GetLastInsertID())
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// If backend_charset of namespace is set and differs from the session charset, backend connections always use
+// backend_charset, sqls and string parameters sent by client are converted to backend_charset, and column
+// definitions and strings of results are converted back to the session charset.
+
+// backendCharset return charset of backend connections
+func (se *SessionExecutor) backendCharset() string {
+	if charset := se.GetNamespace().GetBackendCharset(); charset != "" {
+		return charset
+	}
+	return se.charset
+}
+
+// backendCollationID return collation of backend connections
+func (se *SessionExecutor) backendCollationID() mysql.CollationID {
+	if se.GetNamespace().GetBackendCharset() != "" {
+		return se.GetNamespace().GetBackendCollationID()
+	}
+	return se.collation
+}
+
+// charsetConverter return converter between session charset and backend charset, nil if no conversion is needed
+func (se *SessionExecutor) charsetConverter() *mysql.CharsetConverter {
+	backendCharset := se.GetNamespace().GetBackendCharset()
+	if backendCharset == "" || backendCharset == se.charset {
+		return nil
+	}
+	if se.converter == nil || se.converter.ClientCharset() != se.charset || se.converter.BackendCharset() != backendCharset {
+		converter, err := mysql.NewCharsetConverter(se.charset, backendCharset)
+		if err != nil {
+			exeLogger.Warnf("create charset converter failed, namespace: %s, error: %v", se.namespace, err)
+			return nil
+		}
+		se.converter = converter
+	}
+	if !se.converter.NeedConvert() {
+		return nil
+	}
+	return se.converter
+}
+
+// decodeClientSQL return sql sent by client in backend charset
+func (se *SessionExecutor) decodeClientSQL(data []byte) string {
+	if c := se.charsetConverter(); c != nil {
+		return string(c.ToBackend(data))
+	}
+	return string(data)
+}
+
+// checkSessionCharset check charset set by SET NAMES or SET character_set_* can be converted to backend charset
+func (se *SessionExecutor) checkSessionCharset(charset string) error {
+	if se.GetNamespace().GetBackendCharset() != "" && !mysql.IsConvertibleCharset(charset) {
+		return mysql.NewDefaultError(mysql.ErrUnknownCharacterSet, charset)
+	}
+	return nil
+}
+
+// convertResult return result whose resultsets are converted to session charset.
+// Results may be cached and shared by sessions, so they are copied instead of modified.
+func (se *SessionExecutor) convertResult(r *mysql.Result) (*mysql.Result, error) {
+	c := se.charsetConverter()
+	if c == nil || r == nil {
+		return r, nil
+	}
+
+	var head, tail *mysql.Result
+	for ; r != nil; r = r.Next {
+		nr := *r
+		nr.Next = nil
+		if r.Resultset != nil {
+			rs, err := c.ConvertResultset(r.Resultset, se.collation)
+			if err != nil {
+				return nil, err
+			}
+			nr.Resultset = rs
+		}
+		if head == nil {
+			head = &nr
+		} else {
+			tail.Next = &nr
+		}
+		tail = &nr
+	}
+	return head, nil
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/core/errors"
 	"github.com/XiaoMi/Gaea/logging"
+	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/mysql"
 	"github.com/XiaoMi/Gaea/parser"
 	"github.com/XiaoMi/Gaea/proxy/plan"
@@ -28,18 +29,26 @@ import (
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/format"
 	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // Parse parse parser
 func (se *SessionExecutor) Parse(sql string) (ast.StmtNode, error) {
-	return se.parser.ParseOneStmt(sql, "", "")
+	return parser.ParseOneStmt(se.parser, sql)
 }
 
 // 处理query语句
 func (se *SessionExecutor) handleQuery(sql string) (r *mysql.Result, err error) {
+	return se.handleQueryWithPlan(sql, nil)
+}
+
+// handleQueryWithPlan execute sql with the given plan, the plan is built from sql if p is nil
+func (se *SessionExecutor) handleQueryWithPlan(sql string, p plan.Plan) (r *mysql.Result, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			exeLogger.Warnf("handle query command failed, error: %v, parser: %s", e, sql)
@@ -61,60 +70,236 @@ func (se *SessionExecutor) handleQuery(sql string) (r *mysql.Result, err error)
 	sql = strings.TrimRight(sql, ";") //删除sql语句最后的分号
 
 	reqCtx := util.NewRequestContext()
-	// check black parser
-	ns := se.GetNamespace()
-	if !ns.IsSQLAllowed(reqCtx, sql) {
-		fingerprint := mysql.GetFingerprint(sql)
-		exeLogger.Warnf("catch black parser, parser: %s", sql)
-		se.manager.GetStatisticManager().RecordSQLForbidden(fingerprint, se.GetNamespace().GetName())
-		err := mysql.NewError(mysql.ErrUnknown, "parser in blacklist")
-		return nil, err
-	}
-
-	startTime := time.Now()
+	reqCtx.Set(util.ConnectionID, se.connID)
 	stmtType := parser.PreviewSql(sql)
 	reqCtx.Set(util.StmtType, stmtType)
 
-	r, err = se.doQuery(reqCtx, sql)
-	se.manager.RecordSessionSQLMetrics(reqCtx, se, sql, startTime, err)
-	return r, err
+	qc := &QueryContext{
+		se:        se,
+		reqCtx:    reqCtx,
+		sql:       sql,
+		stmtType:  stmtType,
+		plan:      p,
+		startTime: time.Now(),
+	}
+	ns := se.GetNamespace()
+	r, err = ns.queryHandler(qc)
+	if err != nil {
+		return r, err
+	}
+	// results may be cached and shared by users, they are masked after all middlewares
+	if m := ns.GetMasker(); m != nil {
+		return m.Mask(se.user, r)
+	}
+	return r, nil
+}
+
+// getMaxExecutionTime return statement timeout in millisecond, 0 means no limit.
+// MAX_EXECUTION_TIME hint and session variable only take effect on select statement,
+// the hint has the highest priority, and the namespace default timeout is used at last.
+func (se *SessionExecutor) getMaxExecutionTime(sql string, stmtType parser.StatementType) int64 {
+	if stmtType == parser.StmtSelect {
+		if t, ok := parser.ExtractMaxExecutionTime(sql); ok {
+			return t
+		}
+		if se.maxExecutionTime > 0 {
+			return se.maxExecutionTime
+		}
+	}
+	return se.GetNamespace().getMaxExecutionTime()
 }
 
-func (se *SessionExecutor) doQuery(reqCtx *util.RequestContext, sql string) (*mysql.Result, error) {
+func (se *SessionExecutor) doQuery(reqCtx *util.RequestContext, sql string, p plan.Plan) (*mysql.Result, error) {
 	stmtType := reqCtx.Get(util.StmtType).(parser.StatementType)
 
+	// admin库中的管理语句, 不生成执行计划
+	if se.isAdminSession() {
+		if r, handled, err := se.handleAdminQuery(sql); handled {
+			return r, err
+		}
+	}
+
+	// EXPLAIN SHARDING <sql> 或 /*!gosharding explain*/ <sql>, 只返回路由和改写结果, 不执行语句
+	if stmtType == parser.StmtExplain || stmtType == parser.StmtComment {
+		if explainSQL, ok := parser.ExtractExplainSharding(sql); ok {
+			return se.handleExplainSharding(reqCtx, explainSQL)
+		}
+	}
+
 	if isSQLNotAllowedByUser(se, stmtType) {
 		return nil, fmt.Errorf("write DML is now allowed by read user")
 	}
 
+	if se.isInTransaction() && se.isReadOnlyTransaction() && isWriteStmt(stmtType) {
+		return nil, mysql.NewDefaultError(mysql.ErrCantExecuteInReadOnlyTransaction)
+	}
+
 	if stmtType.CanHandleWithoutPlan() {
 		return se.handleQueryWithoutPlan(reqCtx, sql)
 	}
 
+	if stmtType == parser.StmtLoadData {
+		return se.handleLoadData(reqCtx, sql)
+	}
+
+	if stmtType == parser.StmtCall {
+		return se.handleCall(reqCtx, sql)
+	}
+
+	// DESCRIBE table is handled as SHOW COLUMNS
+	if stmtType == parser.StmtExplain {
+		if stmt, ok := se.parseDescribeStmt(sql); ok {
+			return se.handleShow(reqCtx, sql, stmt, stmt)
+		}
+	}
+
 	db := se.db
 
-	p, err := se.getPlan(se.GetNamespace(), db, sql)
-	if err != nil {
-		return nil, fmt.Errorf("get plan error, db: %s, parser: %s, err: %v", db, sql, err)
+	var err error
+	if p == nil {
+		p, err = se.getPlan(se.GetNamespace(), db, sql)
+		if err != nil {
+			return nil, fmt.Errorf("get plan error, db: %s, parser: %s, err: %v", db, sql, err)
+		}
+	}
+
+	if err := se.checkFullScatter(sql, p); err != nil {
+		return nil, err
 	}
 
 	if canExecuteFromSlave(se, sql) {
 		reqCtx.Set(util.FromSlave, 1)
 	}
 
-	r, err := p.ExecuteIn(reqCtx, se)
+	if sp, ok := p.(*plan.SelectPlan); ok && se.canStreamSelect(sp) {
+		return nil, se.executeSelectStream(reqCtx, sp)
+	}
+
+	var r *mysql.Result
+	if dp, ok := p.(*plan.DDLPlan); ok {
+		r, err = se.executeDDL(reqCtx, dp)
+	} else if plan.IsBroadcastWrite(p) && !se.isInTransaction() {
+		r, err = se.executeBroadcastWrite(reqCtx, p)
+	} else if rp, ok := p.(*plan.ShardKeyRelocatePlan); ok {
+		r, err = se.executeShardKeyRelocate(reqCtx, rp)
+	} else {
+		r, err = p.ExecuteIn(reqCtx, se)
+	}
 	if err != nil {
 		exeLogger.Warnf("execute select: %s", err.Error())
 		return nil, err
 	}
 
+	if enc := se.GetNamespace().GetEncryptor(); enc != nil {
+		if err := enc.Decrypt(r); err != nil {
+			return nil, fmt.Errorf("decrypt result error: %v", err)
+		}
+	}
+
 	modifyResultStatus(r, se)
 
 	return r, nil
 }
 
+// handleExplainSharding return shards, sqls executed in shards and merge steps of the statement without executing it
+func (se *SessionExecutor) handleExplainSharding(reqCtx *util.RequestContext, sql string) (*mysql.Result, error) {
+	n, err := se.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse parser error, parser: %s, err: %v", sql, err)
+	}
+
+	ns := se.GetNamespace()
+	if _, err := se.rewriteStmt(ns, n, se.db); err != nil {
+		return nil, err
+	}
+	p, err := plan.BuildExplainShardingPlan(n, ns.GetPhysicalDBs(), se.db, sql, ns.GetRouter(), ns.GetSequences())
+	if err != nil {
+		return nil, err
+	}
+	return p.ExecuteIn(reqCtx, se)
+}
+
+// checkFullScatter log or reject statement routed to all sub tables without condition of sharding column,
+// according to full_scatter of namespace. Statements with full_scan hint are always allowed.
+func (se *SessionExecutor) checkFullScatter(sql string, p plan.Plan) error {
+	policy := se.GetNamespace().GetFullScatter()
+	if policy == models.FullScatterAllow || !plan.IsFullScatter(p) {
+		return nil
+	}
+	se.manager.GetStatisticManager().RecordFullScatter(se.namespace, policy)
+	if policy == models.FullScatterReject {
+		exeLogger.Warnf("reject full scatter statement, namespace: %s, user: %s, sql: %s", se.namespace, se.user, sql)
+		return mysql.NewError(mysql.ErrUnknown, "statement without condition of sharding column is routed to all shards, "+
+			"add the condition or /*+ full_scan */ hint before the statement")
+	}
+	exeLogger.Warnf("full scatter statement, namespace: %s, user: %s, sql: %s", se.namespace, se.user, sql)
+	return nil
+}
+
+// executeBroadcastWrite execute DML of broadcast tables in an implicit transaction,
+// so the write is committed or rolled back in all slices together.
+func (se *SessionExecutor) executeBroadcastWrite(reqCtx *util.RequestContext, p plan.Plan) (*mysql.Result, error) {
+	// transaction_mode single forbids transaction across slices, use multi for the implicit transaction
+	mode := se.transactionMode
+	if se.getTransactionMode() == models.TransactionModeSingle {
+		se.transactionMode = models.TransactionModeMulti
+	}
+	defer func() {
+		se.transactionMode = mode
+	}()
+
+	se.status |= mysql.ServerStatusInTrans
+	r, err := p.ExecuteIn(reqCtx, se)
+	if err != nil {
+		if e := se.rollback(); e != nil {
+			exeLogger.Warnf("rollback broadcast write error, namespace: %s, err: %v", se.namespace, e)
+		}
+		return nil, err
+	}
+	if err := se.commit(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// executeShardKeyRelocate execute UPDATE which modifies sharding column by deleting rows from old shards and
+// inserting them into new shards, which must be committed atomically in XA transaction. Outside a transaction
+// it's executed in an implicit XA transaction, in a transaction the transaction mode must be twopc.
+func (se *SessionExecutor) executeShardKeyRelocate(reqCtx *util.RequestContext, p *plan.ShardKeyRelocatePlan) (*mysql.Result, error) {
+	if se.isInTransaction() {
+		if se.getTransactionMode() != models.TransactionModeTwoPC {
+			return nil, mysql.NewError(mysql.ErrUnknown, "update sharding column in transaction requires transaction_mode twopc")
+		}
+		return p.ExecuteIn(reqCtx, se)
+	}
+
+	mode := se.transactionMode
+	se.transactionMode = models.TransactionModeTwoPC
+	defer func() {
+		se.transactionMode = mode
+	}()
+
+	se.status |= mysql.ServerStatusInTrans
+	r, err := p.ExecuteIn(reqCtx, se)
+	if err != nil {
+		if e := se.rollback(); e != nil {
+			exeLogger.Warnf("rollback sharding column update error, namespace: %s, err: %v", se.namespace, e)
+		}
+		return nil, err
+	}
+	if err := se.commit(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
 // 处理逻辑较简单的SQL, 不走执行计划部分
 func (se *SessionExecutor) handleQueryWithoutPlan(reqCtx *util.RequestContext, sql string) (*mysql.Result, error) {
+	// SHOW SQL STATS 是gaea自定义语句, 无法被parser解析
+	if isShowSQLStatsStmt(sql) {
+		return createShowSQLStatsResult(se.GetNamespace().GetSQLStats())
+	}
+
 	n, err := se.Parse(sql)
 	if err != nil {
 		stmtType := reqCtx.Get(util.StmtType).(parser.StatementType)
@@ -134,24 +319,57 @@ func (se *SessionExecutor) handleQueryWithoutPlan(reqCtx *util.RequestContext, s
 	case *ast.SetStmt:
 		return se.handleSet(reqCtx, sql, stmt)
 	case *ast.BeginStmt:
-		return nil, se.handleBegin()
+		return nil, se.handleBegin(stmt.ReadOnly)
 	case *ast.CommitStmt:
 		return nil, se.handleCommit()
 	case *ast.RollbackStmt:
 		return nil, se.handleRollback()
 	case *ast.UseStmt:
 		return nil, se.handleUseDB(stmt.DBName)
+	case *ast.KillStmt:
+		return nil, se.handleKill(stmt.ConnectionID, stmt.Query)
 	default:
 		return nil, fmt.Errorf("cannot handle parser without plan, ns: %s, parser: %s", se.namespace, sql)
 	}
 }
 
+// handleKill handle KILL [CONNECTION | QUERY] id and COM_PROCESS_KILL, only connections of the same namespace in this proxy can be killed,
+// and connections of other users can only be killed by admin user.
+// Statements running in backend connections of the killed session are killed too.
+func (se *SessionExecutor) handleKill(connID uint64, query bool) error {
+	for _, cc := range se.manager.GetClusterState().GetLocalSessions(se.namespace) {
+		if uint64(cc.executor.connID) != connID {
+			continue
+		}
+		if cc.executor.user != se.user && !se.GetNamespace().IsAdminUser(se.user) {
+			return mysql.NewDefaultError(mysql.ErrKillDenied, connID)
+		}
+		if query {
+			cc.executor.killQuery()
+		} else {
+			cc.Kill()
+		}
+		return nil
+	}
+	return mysql.NewDefaultError(mysql.ErrNoSuchThread, connID)
+}
+
+// handleResetConnection handle COM_RESET_CONNECTION, reset session state like COM_CHANGE_USER without re-authentication,
+// user and current database are kept, and charset is restored to the one sent by client in handshake
+func (se *SessionExecutor) handleResetConnection() {
+	if err := se.resetSession(); err != nil {
+		exeLogger.Warnf("rollback error when reset connection, namespace: %s, connId: %d, err: %v", se.namespace, se.connID, err)
+	}
+	se.collation = se.clientCollation
+	se.charset = se.clientCharset
+}
+
 func (se *SessionExecutor) handleUseDB(dbName string) error {
 	if len(dbName) == 0 {
 		return fmt.Errorf("must have database, the length of dbName is zero")
 	}
 
-	if se.GetNamespace().IsAllowedDB(dbName) {
+	if se.GetNamespace().IsAllowedDB(dbName) || (dbName == adminDB && se.GetNamespace().IsAdminUser(se.user)) {
 		se.db = dbName
 		return nil
 	}
@@ -160,28 +378,75 @@ func (se *SessionExecutor) handleUseDB(dbName string) error {
 }
 
 func (se *SessionExecutor) getPlan(ns *Namespace, db string, sql string) (plan.Plan, error) {
+	// 读语句的plan可以复用, 跳过解析和生成plan
+	if p, ok := ns.GetCachedPlan(db, sql); ok {
+		return p, nil
+	}
+
 	n, err := se.Parse(sql)
 	if err != nil {
 		return nil, fmt.Errorf("parse parser error, parser: %s, err: %v", sql, err)
 	}
 
+	cacheable, err := se.rewriteStmt(ns, n, db)
+	if err != nil {
+		return nil, err
+	}
+
 	rt := ns.GetRouter()
 	seq := ns.GetSequences()
 	phyDBs := ns.GetPhysicalDBs()
-	p, err := plan.BuildPlan(n, phyDBs, db, sql, rt, seq)
+	var p plan.Plan
+	if u, ok := n.(*ast.UpdateStmt); ok && ns.GetShardKeyUpdate() == models.ShardKeyUpdateRelocate && plan.IsShardingColumnUpdate(u, db, rt) {
+		p, err = plan.BuildShardKeyRelocatePlan(u, phyDBs, db, sql, rt, seq)
+	} else {
+		p, err = plan.BuildPlan(n, phyDBs, db, sql, rt, seq)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("create select plan error: %v", err)
 	}
 
+	if cacheable && plan.IsCacheablePlan(p) {
+		ns.SetCachedPlan(db, sql, p)
+	}
 	return p, nil
 }
 
+// rewriteStmt replace user-defined variables with their values, inject tenant condition and encrypt values of
+// sensitive columns before the plan is built, the plan can't be cached if user-defined variables are replaced
+// or tenant condition is injected, since they depend on the session.
+func (se *SessionExecutor) rewriteStmt(ns *Namespace, n ast.StmtNode, db string) (bool, error) {
+	changed, err := se.rewriteUserVariables(n)
+	if err != nil {
+		return false, err
+	}
+	cacheable := !changed
+	if t := ns.GetTenantIsolation(); t != nil {
+		changed, err := t.Rewrite(n, db, se.getTenantID())
+		if err != nil {
+			return false, mysql.NewError(mysql.ErrUnknown, err.Error())
+		}
+		cacheable = cacheable && !changed
+	}
+	if enc := ns.GetEncryptor(); enc != nil {
+		if err := enc.Rewrite(n, db); err != nil {
+			return false, fmt.Errorf("encrypt column value error: %v", err)
+		}
+	}
+	return cacheable, nil
+}
+
 func (se *SessionExecutor) handleShow(reqCtx *util.RequestContext, sql string, stmt *ast.ShowStmt, node ast.StmtNode) (*mysql.Result, error) {
 	switch stmt.Tp {
 	case ast.ShowDatabases:
 		dbs := se.GetNamespace().GetAllowedDBs()
 		return createShowDatabaseResult(dbs)
-	case ast.ShowTables, ast.ShowColumns, ast.ShowIndex, ast.ShowTriggers, ast.ShowCreateTable:
+	case ast.ShowTables, ast.ShowTableStatus:
+		return se.handleShowTables(reqCtx, sql, stmt)
+	case ast.ShowColumns, ast.ShowIndex, ast.ShowTriggers, ast.ShowCreateTable:
+		if rule, ok := se.getShowTableRule(stmt); ok && stmt.Tp != ast.ShowTriggers {
+			return se.handleShowShardTable(reqCtx, stmt, rule)
+		}
 		exeSql := sql
 		change := false
 		phyDB, err := se.GetNamespace().GetDefaultPhyDB(se.db)
@@ -231,8 +496,17 @@ func (se *SessionExecutor) handleShow(reqCtx *util.RequestContext, sql string, s
 }
 
 func (se *SessionExecutor) handleSet(reqCtx *util.RequestContext, sql string, stmt *ast.SetStmt) (*mysql.Result, error) {
+	nextTx := isSetNextTransaction(sql)
 	for _, v := range stmt.Variables {
-		if err := se.handleSetVariable(v); err != nil {
+		var err error
+		if isUserVariable(v) {
+			err = se.setUserVariable(reqCtx, v)
+		} else if nextTx {
+			err = se.setNextTransaction(v)
+		} else {
+			err = se.handleSetVariable(v)
+		}
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -241,10 +515,11 @@ func (se *SessionExecutor) handleSet(reqCtx *util.RequestContext, sql string, st
 }
 
 func (se *SessionExecutor) handleSetVariable(v *ast.VariableAssignment) error {
+	name := strings.ToLower(v.Name)
 	if v.IsGlobal {
-		return fmt.Errorf("does not support set variable in global scope")
+		// global variables are shared by all sessions of the backend mysql, they should be changed on mysql directly
+		return mysql.NewError(mysql.ErrNotSupportedYet, fmt.Sprintf("SET GLOBAL %s is not supported by gaea, please set it on backend mysql", name))
 	}
-	name := strings.ToLower(v.Name)
 	switch name {
 	case "character_set_results", "character_set_client", "character_set_connection":
 		charset := getVariableExprResult(v.Value)
@@ -260,6 +535,9 @@ func (se *SessionExecutor) handleSetVariable(v *ast.VariableAssignment) error {
 		if !ok {
 			return mysql.NewDefaultError(mysql.ErrUnknownCharacterSet, charset)
 		}
+		if err := se.checkSessionCharset(charset); err != nil {
+			return err
+		}
 		se.charset = charset
 		se.collation = mysql.CollationIds[col]
 		return nil
@@ -304,6 +582,9 @@ func (se *SessionExecutor) handleSetVariable(v *ast.VariableAssignment) error {
 				return mysql.NewDefaultError(mysql.ErrUnknownCharacterSet, charset)
 			}
 		}
+		if err := se.checkSessionCharset(charset); err != nil {
+			return err
+		}
 
 		se.charset = charset
 		se.collation = collationID
@@ -321,17 +602,108 @@ func (se *SessionExecutor) handleSetVariable(v *ast.VariableAssignment) error {
 	case "time_zone":
 		value := getVariableExprResult(v.Value)
 		return se.setStringSessionVariable(mysql.TimeZone, value)
+	case mysql.GroupConcatMaxLen:
+		value := getVariableExprResult(v.Value)
+		if err := se.setIntSessionVariable(name, value); err != nil {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+		return nil
+	case mysql.ForeignKeyChecks, mysql.UniqueChecks:
+		value := getVariableExprResult(v.Value)
+		if value == mysql.KeywordDefault {
+			se.sessionVariables.Delete(name)
+			return nil
+		}
+		onOffValue, err := getOnOffVariable(value)
+		if err != nil {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+		return se.setIntSessionVariable(name, onOffValue)
+	case mysql.TransactionIsolation, mysql.TxIsolation:
+		value := getVariableExprResult(v.Value)
+		if err := se.setStringSessionVariable(name, value); err != nil {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+		return nil
 	case "max_allowed_packet":
 		return mysql.NewDefaultError(mysql.ErrVariableIsReadonly, "SESSION", mysql.MaxAllowedPacket, "GLOBAL")
 
+	case "wait_timeout":
+		value := getVariableExprResult(v.Value)
+		if value == mysql.KeywordDefault {
+			se.waitTimeout = 0
+			return nil
+		}
+		t, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || t < 1 || t > maxWaitTimeout {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+		se.waitTimeout = t
+		return nil
+
 		// do nothing
-	case "wait_timeout", "interactive_timeout", "net_write_timeout", "net_read_timeout":
+	case "interactive_timeout", "net_write_timeout", "net_read_timeout":
 		return nil
 	case "sql_select_limit":
 		return nil
+	case "resultset_metadata":
+		value := getVariableExprResult(v.Value)
+		switch value {
+		case mysql.KeywordDefault, "full":
+			se.resultsetMetadata = mysql.ResultsetMetadataFull
+		case "none":
+			se.resultsetMetadata = mysql.ResultsetMetadataNone
+		default:
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+		return nil
+	case "max_execution_time":
+		value := getVariableExprResult(v.Value)
+		if value == mysql.KeywordDefault {
+			se.maxExecutionTime = 0
+			return nil
+		}
+		t, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || t < 0 {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+		se.maxExecutionTime = t
+		return nil
+	case "transaction_mode":
+		value := getVariableExprResult(v.Value)
+		return se.setTransactionMode(value)
+	case "ddl_strategy":
+		value := getVariableExprResult(v.Value)
+		if value == mysql.KeywordDefault {
+			se.ddlStrategy = ""
+			return nil
+		}
+		if !models.IsValidDDLStrategy(value) {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+		se.ddlStrategy = value
+		return nil
 		// unsupported
 	case "transaction":
 		return fmt.Errorf("does not support set transaction in gaea")
+	case "tx_isolation_one_shot": // SET TRANSACTION ISOLATION LEVEL without SESSION only takes effect on the next transaction
+		return se.setNextTransaction(v)
+	case "tx_read_only", "transaction_read_only":
+		value := getVariableExprResult(v.Value)
+		if value == mysql.KeywordDefault {
+			se.txReadOnly = false
+			return nil
+		}
+		onOffValue, err := getOnOffVariable(value)
+		if err != nil {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+		se.txReadOnly = onOffValue == "1"
+		return nil
+	case "session_track_schema", "session_track_state_change", "session_track_system_variables", "session_track_transaction_info":
+		return se.setSessionTrackVariable(name, getVariableExprResult(v.Value))
+	case gaeaTenantIDVariable:
+		return se.setTenantID(v.Value)
 	case gaeaGeneralLogVariable:
 		value := getVariableExprResult(v.Value)
 		onOffValue, err := getOnOffVariable(value)
@@ -340,8 +712,83 @@ func (se *SessionExecutor) handleSetVariable(v *ast.VariableAssignment) error {
 		}
 		return se.setGeneralLogVariable(onOffValue)
 	default:
+		if v.IsSystem && se.GetNamespace().isSystemSettingsEnabled() {
+			return se.setSystemVariable(name, v.Value)
+		}
+		return nil
+	}
+}
+
+// setSystemVariable store system variable not handled by proxy in session, it's replayed on backend connections
+// before statements are executed, only constant values are supported.
+func (se *SessionExecutor) setSystemVariable(name string, v ast.ExprNode) error {
+	if getVariableExprResult(v) == mysql.KeywordDefault {
+		se.sessionVariables.Delete(name)
 		return nil
 	}
+	var result interface{}
+	switch value := v.(type) {
+	case *ast.ColumnNameExpr: // keyword value such as OFF is parsed as column name
+		if value.Name.Table.O != "" {
+			return mysql.NewDefaultError(mysql.ErrWrongTypeForVar, name)
+		}
+		result = value.Name.Name.O
+	case *driver.ValueExpr:
+		var err error
+		if value.Kind() == types.KindMysqlDecimal {
+			result, err = strconv.ParseFloat(value.GetMysqlDecimal().String(), 64)
+		} else {
+			result, err = util.GetValueExprResult(value)
+		}
+		if err != nil || result == nil {
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, getVariableExprResult(v))
+		}
+	default:
+		return mysql.NewDefaultError(mysql.ErrWrongTypeForVar, name)
+	}
+	if err := se.sessionVariables.SetSystemVariable(name, result); err != nil {
+		return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, getVariableExprResult(v))
+	}
+	return nil
+}
+
+// setTenantID set session tenant_id, users with fixed tenant id can't change it
+func (se *SessionExecutor) setTenantID(v ast.ExprNode) error {
+	if se.GetNamespace().GetUserTenantID(se.user) != "" {
+		return fmt.Errorf("tenant_id of user %s can not be changed", se.user)
+	}
+	if getVariableExprResult(v) == mysql.KeywordDefault {
+		se.tenantID = ""
+		return nil
+	}
+	value, ok := v.(*driver.ValueExpr)
+	if !ok {
+		return mysql.NewDefaultError(mysql.ErrWrongTypeForVar, gaeaTenantIDVariable)
+	}
+	id, err := util.GetValueExprResult(value)
+	if err != nil || id == nil {
+		return mysql.NewDefaultError(mysql.ErrWrongValueForVar, gaeaTenantIDVariable, getVariableExprResult(v))
+	}
+	se.tenantID = fmt.Sprintf("%v", id)
+	return nil
+}
+
+func (se *SessionExecutor) setTransactionMode(value string) error {
+	se.txLock.Lock()
+	defer se.txLock.Unlock()
+
+	if len(se.txConns) > 0 {
+		return fmt.Errorf("can not change transaction_mode in transaction")
+	}
+	if value == mysql.KeywordDefault {
+		se.transactionMode = ""
+		return nil
+	}
+	if !models.IsValidTransactionMode(value) {
+		return mysql.NewDefaultError(mysql.ErrWrongValueForVar, "transaction_mode", value)
+	}
+	se.transactionMode = value
+	return nil
 }
 
 func (se *SessionExecutor) handleSetAutoCommit(autocommit bool) (err error) {
@@ -349,14 +796,25 @@ func (se *SessionExecutor) handleSetAutoCommit(autocommit bool) (err error) {
 	defer se.txLock.Unlock()
 
 	if autocommit {
+		if len(se.txConns) > 0 || se.xid != "" {
+			se.resetNextTransaction()
+		}
 		se.status |= mysql.ServerStatusAutocommit
 		if se.status&mysql.ServerStatusInTrans > 0 {
 			se.status &= ^mysql.ServerStatusInTrans
 		}
-		for _, pc := range se.txConns {
+		// set autocommit = 1 commits current transaction implicitly
+		if se.xid != "" {
+			err = se.commitXA()
+			se.xid = ""
+			se.recycleTransactionConns()
+			return
+		}
+		for sliceName, pc := range se.txConns {
 			if e := pc.SetAutoCommit(1); e != nil {
 				err = fmt.Errorf("set autocommit error, %v", e)
 			}
+			se.trackGTIDs(sliceName, pc)
 			pc.Recycle()
 		}
 		se.txConns = make(map[string]backend.PooledConnect)
@@ -370,6 +828,10 @@ func (se *SessionExecutor) handleSetAutoCommit(autocommit bool) (err error) {
 func (se *SessionExecutor) handleStmtPrepare(sql string) (*Stmt, error) {
 	exeLogger.Debugf("namespace: %s use prepare, parser: %s", se.GetNamespace().GetName(), sql)
 
+	if len(se.stmts) >= maxPreparedStmtCount {
+		return nil, mysql.NewDefaultError(mysql.ErrMaxPreparedStmtCountReached, maxPreparedStmtCount)
+	}
+
 	stmt := new(Stmt)
 
 	sql = strings.TrimRight(sql, ";")
@@ -383,9 +845,26 @@ func (se *SessionExecutor) handleStmtPrepare(sql string) (*Stmt, error) {
 
 	stmt.paramCount = paramCount
 	stmt.offsets = offsets
-	stmt.id = se.stmtID
-	stmt.columnCount = 0
+
+	// 能被parser解析的语句, 校验占位符数量; 不能解析的语句(如部分SHOW语句)仍然按原SQL透传执行
+	if n, err := se.Parse(sql); err == nil {
+		if markerCount := countParamMarkers(n); markerCount != paramCount {
+			exeLogger.Warnf("prepare param count mismatch, namespace: %s, parser: %s, markers: %d, params: %d",
+				se.GetNamespace().GetName(), sql, markerCount, paramCount)
+			return nil, mysql.NewDefaultError(mysql.ErrWrongArguments, "stmt_prepare")
+		}
+		// 已加载表结构的单表查询, 在prepare响应中返回结果集的列定义
+		if sel, ok := n.(*ast.SelectStmt); ok {
+			stmt.columns = se.prepareResultColumns(sel)
+		}
+		// 生成plan会改写语法树, 需要在最后执行
+		se.preparePlan(stmt, n)
+	}
+
+	// statement id从1开始
 	se.stmtID++
+	stmt.id = se.stmtID
+	stmt.columnCount = len(stmt.columns)
 
 	stmt.ResetParams()
 	se.stmts[stmt.id] = stmt
@@ -412,7 +891,7 @@ func (se *SessionExecutor) handleFieldList(data []byte) ([]*mysql.Field, error)
 
 	sliceName := se.GetNamespace().GetRouter().GetRule(se.GetDatabase(), table).GetSlice(0)
 
-	pc, err := se.getBackendConn(sliceName, se.GetNamespace().IsRWSplit(se.user))
+	pc, err := se.getBackendConn(sliceName, se.GetNamespace().IsRWSplit(se.user), true)
 	if err != nil {
 		return nil, err
 	}
@@ -423,7 +902,7 @@ func (se *SessionExecutor) handleFieldList(data []byte) ([]*mysql.Field, error)
 		return nil, err
 	}
 
-	if err = initBackendConn(pc, phyDB, se.GetCharset(), se.GetCollationID(), se.GetVariables()); err != nil {
+	if err = initBackendConn(pc, phyDB, se.backendCharset(), se.backendCollationID(), se.GetVariables()); err != nil {
 		return nil, err
 	}
 
@@ -34,43 +34,85 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/proxy/schema"
 	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/hack"
+	"github.com/pingcap/parser/ast"
 )
 
 var p = &mysql.Field{Name: []byte("?")}
 var c = &mysql.Field{}
 
+// calcParams count placeholders in sql and return their offsets,
+// placeholders in quoted strings, quoted identifiers and comments are skipped.
 func calcParams(sql string) (paramCount int, offsets []int, err error) {
-	count := 0
-	quoteChar := ""
-	paramCount = 0
 	offsets = make([]int, 0)
 
-	for i, elem := range []byte(sql) {
-		if elem == '\\' {
-			continue
-		} else if elem == '"' || elem == '\'' {
-			if quoteChar == "" {
-				quoteChar = string(elem)
-			} else if quoteChar == string(elem) {
-				quoteChar = ""
+	var quoteChar byte
+	for i := 0; i < len(sql); i++ {
+		elem := sql[i]
+		if quoteChar != 0 {
+			if elem == '\\' && quoteChar != '`' {
+				i++ // skip escaped char
+			} else if elem == quoteChar {
+				quoteChar = 0
 			}
-		} else if quoteChar == "" && elem == '?' {
-			count++
-			offsets = append(offsets, i)
+			continue
 		}
 
+		switch {
+		case elem == '"' || elem == '\'' || elem == '`':
+			quoteChar = elem
+		case elem == '?':
+			offsets = append(offsets, i)
+		case elem == '#' || (elem == '-' && strings.HasPrefix(sql[i:], "-- ")):
+			// 单行注释
+			if end := strings.IndexByte(sql[i:], '\n'); end != -1 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+		case elem == '/' && strings.HasPrefix(sql[i:], "/*") && !strings.HasPrefix(sql[i:], "/*!"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 {
+				return 0, nil, fmt.Errorf("unclosed comment in sql")
+			}
+			i += end + 3
+		}
 	}
-	if quoteChar != "" {
-		err = fmt.Errorf("fatal situation")
-		return
+	if quoteChar != 0 {
+		return 0, nil, fmt.Errorf("unclosed quote %c in sql", quoteChar)
 	}
 
-	paramCount = count
+	return len(offsets), offsets, nil
+}
+
+// paramMarkerCounter count param markers in statement
+type paramMarkerCounter struct {
+	count int
+}
+
+// Enter implement ast.Visitor
+func (v *paramMarkerCounter) Enter(n ast.Node) (ast.Node, bool) {
+	if _, ok := n.(ast.ParamMarkerExpr); ok {
+		v.count++
+	}
+	return n, false
+}
 
-	return
+// Leave implement ast.Visitor
+func (v *paramMarkerCounter) Leave(n ast.Node) (ast.Node, bool) {
+	return n, true
+}
+
+func countParamMarkers(stmt ast.StmtNode) int {
+	v := &paramMarkerCounter{}
+	stmt.Accept(v)
+	return v.count
 }
 
 func escapeSQL(sql string) string {
@@ -90,9 +132,95 @@ type Stmt struct {
 	sql         string
 	args        []interface{}
 	columnCount int
+	columns     []*mysql.Field // column definitions of result, nil if unknown
 	paramCount  int
 	paramTypes  []byte
 	offsets     []int
+
+	// plan built at prepare time and reused by executions, plan of unshard tables is executed with bound values,
+	// and shardPlan of sharding table is only pruned to the sub table of bound value of sharding column
+	plan        *plan.UnshardPlan
+	shardPlan   *plan.PreparedShardPlan
+	shardParam  int              // index of arg of sharding column in shardPlan
+	planOffsets map[string][]int // key = sql of plan, value = offsets of placeholders in sql
+	planNs      *Namespace       // namespace and db of the plan, the plan is not used after they are changed
+	planDB      string
+}
+
+// prepareResultColumns return column definitions of result of prepared SELECT from the loaded schema,
+// nil is returned if any column is unknown, e.g. expressions, joins or the table is not loaded.
+func (se *SessionExecutor) prepareResultColumns(stmt *ast.SelectStmt) []*mysql.Field {
+	if stmt.Fields == nil || stmt.From == nil || stmt.From.TableRefs == nil || stmt.From.TableRefs.Right != nil {
+		return nil
+	}
+	tableSource, ok := stmt.From.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil
+	}
+	tableName, ok := tableSource.Source.(*ast.TableName)
+	if !ok {
+		return nil
+	}
+	db := tableName.Schema.O
+	if db == "" {
+		db = se.db
+	}
+	table, ok := se.GetNamespace().GetRouter().GetSchema().GetTable(db, tableName.Name.L)
+	if !ok {
+		return nil
+	}
+	alias := tableSource.AsName.O
+	if alias == "" {
+		alias = tableName.Name.O
+	}
+
+	charset := uint16(se.GetNamespace().GetDefaultCollationID())
+	var fields []*mysql.Field
+	for _, f := range stmt.Fields.Fields {
+		if f.WildCard != nil {
+			for _, column := range table.Columns {
+				fields = append(fields, columnDefinition(db, alias, tableName.Name.O, column.Name, column, charset))
+			}
+			continue
+		}
+		expr, ok := f.Expr.(*ast.ColumnNameExpr)
+		if !ok {
+			return nil
+		}
+		column, ok := table.GetColumn(expr.Name.Name.O)
+		if !ok {
+			return nil
+		}
+		name := expr.Name.Name.O
+		if f.AsName.O != "" {
+			name = f.AsName.O
+		}
+		fields = append(fields, columnDefinition(db, alias, tableName.Name.O, name, column, charset))
+	}
+	return fields
+}
+
+func columnDefinition(db, table, orgTable, name string, column *schema.Column, charset uint16) *mysql.Field {
+	f := &mysql.Field{
+		Schema:   hack.Slice(db),
+		Table:    hack.Slice(table),
+		OrgTable: hack.Slice(orgTable),
+		Name:     hack.Slice(name),
+		OrgName:  hack.Slice(column.Name),
+		Type:     column.FieldType(),
+		Charset:  charset,
+	}
+	if !column.Nullable {
+		f.Flag |= uint16(mysql.NotNullFlag)
+	}
+	if column.IsUnsigned() {
+		f.Flag |= uint16(mysql.UnsignedFlag)
+	}
+	if !column.IsString() {
+		f.Charset = 63 // binary
+		f.Flag |= uint16(mysql.BinaryFlag)
+	}
+	return f
 }
 
 // ResetParams reset args
@@ -110,24 +238,201 @@ func (s *Stmt) GetParamTypes() []byte {
 
 // GetRewriteSQL get rewrite parser
 func (s *Stmt) GetRewriteSQL() (string, error) {
-	sql := s.sql
+	return s.bindArgs(s.sql, s.offsets), nil
+}
+
+// getPreparedPlan return the plan built at prepare time with values of args bound, nil if it can not be used
+func (s *Stmt) getPreparedPlan(ns *Namespace, db string) plan.Plan {
+	if s.planNs != ns || s.planDB != db {
+		return nil
+	}
+	if s.plan != nil {
+		sql := s.plan.GetSQL()
+		return s.plan.BindSQL(s.bindArgs(sql, s.planOffsets[sql]))
+	}
+	if s.shardPlan != nil {
+		value, ok := routeValue(s.args[s.shardParam])
+		if !ok {
+			return nil
+		}
+		p, err := s.shardPlan.Bind(value, func(sql string) string {
+			return s.bindArgs(sql, s.planOffsets[sql])
+		})
+		if err != nil {
+			// the statement is planned with bound values and the error is returned in execution
+			return nil
+		}
+		return p
+	}
+	return nil
+}
+
+// routeValue return value of arg as the parser returns for its literal in sql, which routes the prepared plan
+// of sharding table. ok is false if the literal is not a string or a non-negative integer, these values are
+// routed after sql with bound values is parsed.
+func routeValue(arg interface{}) (interface{}, bool) {
+	if _, ok := arg.(ListArg); ok || arg == nil {
+		return nil, false
+	}
+	quote, s := util.ItoString(arg)
+	if quote {
+		return s, true
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil && v >= 0 {
+		return v, true
+	}
+	if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return v, true
+	}
+	return nil, false
+}
+
+// ListArg is arg expanded to comma separated values, like ::list bind variables of vitess.
+// e.g. IN (?) with ListArg{1, 2, 3} is bound as IN (1, 2, 3), and items which are ListArg are bound as tuples,
+// e.g. (a, b) IN (?) with ListArg{ListArg{1, 2}, ListArg{3, 4}} is bound as (a, b) IN ((1, 2), (3, 4)).
+// Values are bound before the statement is planned, so the IN list is routed and rewritten for each shard
+// like literal values, and each shard only receives its own values.
+type ListArg []interface{}
+
+// checkListArgs check ListArg in args is not empty and items of tuples are not lists
+func checkListArgs(args []interface{}) error {
+	for i, arg := range args {
+		list, ok := arg.(ListArg)
+		if !ok {
+			continue
+		}
+		if len(list) == 0 {
+			return fmt.Errorf("empty list of arg %d", i)
+		}
+		for _, item := range list {
+			tuple, ok := item.(ListArg)
+			if !ok {
+				continue
+			}
+			if len(tuple) == 0 {
+				return fmt.Errorf("empty tuple in list of arg %d", i)
+			}
+			for _, v := range tuple {
+				if _, ok := v.(ListArg); ok {
+					return fmt.Errorf("nested tuple in list of arg %d", i)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bindArgs replace placeholders at offsets of sql with args
+func (s *Stmt) bindArgs(sql string, offsets []int) string {
 	var tmp = ""
 	var pos = 0
 	var offset = 0
-	var quote = false
 	for i := 0; i < s.paramCount; i++ {
-		quote, tmp = util.ItoString(s.args[i])
-		tmp = escapeSQL(tmp)
-		pos = s.offsets[i]
-		if quote {
-			sql = util.Concat(sql[:pos+offset], "'", tmp, "'", sql[pos+offset+1:])
-			offset = offset + len(tmp) - 1 + 2
-		} else {
-			sql = util.Concat(sql[:pos+offset], tmp, sql[pos+offset+1:])
-			offset = offset + len(tmp) - 1
+		tmp = argLiteral(s.args[i])
+		pos = offsets[i]
+		sql = util.Concat(sql[:pos+offset], tmp, sql[pos+offset+1:])
+		offset = offset + len(tmp) - 1
+	}
+	return sql
+}
+
+// argLiteral return sql literal of arg, []byte is quoted, items of ListArg are separated by commas
+func argLiteral(arg interface{}) string {
+	if list, ok := arg.(ListArg); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			if tuple, ok := item.(ListArg); ok {
+				items[i] = "(" + argLiteral(tuple) + ")"
+			} else {
+				items[i] = argLiteral(item)
+			}
+		}
+		return strings.Join(items, ", ")
+	}
+
+	quote, tmp := util.ItoString(arg)
+	tmp = escapeSQL(tmp)
+	if quote {
+		return "'" + tmp + "'"
+	}
+	return tmp
+}
+
+// preparePlan build plan of statement at prepare time, so the executions bind values to sqls of the plan
+// without parsing. The plan is reused if the statement is not routed by values, such as statements of unshard
+// tables, or it's routed only by the placeholder of sharding column, such as SELECT * FROM t WHERE id = ?,
+// and the sub table is pruned with the bound value in each execution. Other statements are planned in each execution.
+func (se *SessionExecutor) preparePlan(s *Stmt, n ast.StmtNode) {
+	switch n.(type) {
+	case *ast.SelectStmt, *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+	default:
+		return
+	}
+
+	ns := se.GetNamespace()
+	// values bound to the plan can't be encrypted, and tenant id and user-defined variables may be changed after prepare
+	if ns.GetEncryptor() != nil || ns.GetTenantIsolation() != nil || hasUserVariable(n) {
+		return
+	}
+	p, err := plan.BuildPlan(n, ns.GetPhysicalDBs(), se.db, s.sql, ns.GetRouter(), ns.GetSequences())
+	if err != nil {
+		// the error is returned in execution
+		return
+	}
+	if up, ok := p.(*plan.UnshardPlan); ok {
+		offsets, ok := calcPlanOffsets(s, []string{up.GetSQL()})
+		if !ok {
+			return
+		}
+		s.plan = up
+		s.planOffsets = offsets
+	} else {
+		// the syntax tree has been rewritten by BuildPlan, plan sharding table again with a new one
+		n, err = se.Parse(s.sql)
+		if err != nil || !se.prepareShardPlan(s, n, ns) {
+			return
+		}
+	}
+	s.planNs = ns
+	s.planDB = se.db
+}
+
+func (se *SessionExecutor) prepareShardPlan(s *Stmt, n ast.StmtNode, ns *Namespace) bool {
+	p, err := plan.BuildPreparedShardPlan(n, ns.GetPhysicalDBs(), se.db, s.sql, ns.GetRouter(), ns.GetSequences())
+	if err != nil {
+		return false
+	}
+	param := -1
+	for i, offset := range s.offsets {
+		if offset == p.GetParamOffset() {
+			param = i
+		}
+	}
+	if param == -1 {
+		return false
+	}
+	offsets, ok := calcPlanOffsets(s, p.GetAllSQLs())
+	if !ok {
+		return false
+	}
+	s.shardPlan = p
+	s.shardParam = param
+	s.planOffsets = offsets
+	return true
+}
+
+// calcPlanOffsets return offsets of placeholders in sqls of plan, ok is false if count of placeholders
+// in any sql is different from the statement, which means placeholders are rewritten in planning.
+func calcPlanOffsets(s *Stmt, sqls []string) (map[string][]int, bool) {
+	ret := make(map[string][]int, len(sqls))
+	for _, sql := range sqls {
+		paramCount, offsets, err := calcParams(sql)
+		if err != nil || paramCount != s.paramCount {
+			return nil, false
 		}
+		ret[sql] = offsets
 	}
-	return sql, nil
+	return ret, true
 }
 
 func (se *SessionExecutor) handleStmtExecute(data []byte) (*mysql.Result, error) {
@@ -201,11 +506,14 @@ func (se *SessionExecutor) handleStmtExecute(data []byte) (*mysql.Result, error)
 
 	defer s.ResetParams()
 
-	// execute parser using ComQuery
-	r, err := se.handleQuery(executeSQL)
+	// execute parser using ComQuery, the plan built at prepare time is used if possible
+	r, err := se.handleQueryWithPlan(executeSQL, s.getPreparedPlan(se.GetNamespace(), se.db))
 	if err != nil {
 		return nil, err
 	}
+	if r, err = se.convertResult(r); err != nil {
+		return nil, err
+	}
 
 	// build binary result set
 	if r != nil && r.Resultset != nil {
@@ -337,6 +645,9 @@ func (se *SessionExecutor) bindStmtArgs(s *Stmt, nullBitmap, paramTypes, paramVa
 			}
 
 			if !isNull {
+				if c := se.charsetConverter(); c != nil && isStringParamType(tp) {
+					v = c.ToBackend(v)
+				}
 				args[i] = v
 				continue
 			} else {
@@ -350,6 +661,15 @@ func (se *SessionExecutor) bindStmtArgs(s *Stmt, nullBitmap, paramTypes, paramVa
 	return nil
 }
 
+// isStringParamType return whether parameter of type is character string, which is converted to backend charset
+func isStringParamType(tp byte) bool {
+	switch tp {
+	case mysql.TypeVarchar, mysql.TypeVarString, mysql.TypeString, mysql.TypeEnum, mysql.TypeSet:
+		return true
+	}
+	return false
+}
+
 func (se *SessionExecutor) handleStmtSendLongData(data []byte) error {
 	if len(data) < 6 {
 		return mysql.ErrMalformPacket
@@ -15,7 +15,10 @@
 package server
 
 import (
+	"reflect"
 	"testing"
+
+	"github.com/XiaoMi/Gaea/proxy/plan"
 )
 
 func Test_calcParams(t *testing.T) {
@@ -41,3 +44,161 @@ func Test_calcParams(t *testing.T) {
 		t.Logf("test calcParams failed, %v\n", err)
 	}
 }
+
+func TestCalcParamsSkipQuoteAndComment(t *testing.T) {
+	tests := []struct {
+		sql     string
+		offsets []int
+		hasErr  bool
+	}{
+		{"select * from t where a = ? and b = '?'", []int{26}, false},
+		{"select * from t where a = 'it\\'s ?' and b = ?", []int{44}, false},
+		{"select `a?` from t where b = \"?\" and c = ?", []int{41}, false},
+		{"select /* ? */ a from t where b = ? -- ?\nand c = ? # ?", []int{34, 49}, false},
+		{"select /*!40001 ? */ a", []int{16}, false},
+		{"select * from t where a = 'abc", nil, true},
+		{"select /* a from t", nil, true},
+	}
+
+	for _, test := range tests {
+		paramCount, offsets, err := calcParams(test.sql)
+		if test.hasErr {
+			if err == nil {
+				t.Errorf("calcParams should fail, sql: %s", test.sql)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("calcParams error, sql: %s, err: %v", test.sql, err)
+			continue
+		}
+		if paramCount != len(test.offsets) || !reflect.DeepEqual(offsets, test.offsets) {
+			t.Errorf("calcParams error, sql: %s, expect: %v, actual: %v", test.sql, test.offsets, offsets)
+		}
+	}
+}
+
+func TestPreparePlan(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+
+	// unshard table, the plan is reused with bound values
+	stmt, err := se.handleStmtPrepare("select * from db_mycat.tbl_unshard where id = ? and name = ?")
+	if err != nil {
+		t.Fatal("prepare error:", err)
+	}
+	if stmt.plan == nil {
+		t.Fatal("plan of unshard table should be built at prepare time")
+	}
+	stmt.args = []interface{}{int64(1), []byte("it's")}
+	p := stmt.getPreparedPlan(se.GetNamespace(), se.db)
+	if p == nil {
+		t.Fatal("prepared plan should be used")
+	}
+	expect := "SELECT * FROM `db_mycat_0`.`tbl_unshard` WHERE `id`=1 AND `name`='it\\'s'"
+	if actual := p.(*plan.UnshardPlan).GetSQL(); actual != expect {
+		t.Errorf("bind plan sql error, expect: %s, actual: %s", expect, actual)
+	}
+	if stmt.plan.GetSQL() != "SELECT * FROM `db_mycat_0`.`tbl_unshard` WHERE `id`=? AND `name`=?" {
+		t.Errorf("prepared plan should not be changed, sql: %s", stmt.plan.GetSQL())
+	}
+
+	// the plan is not used after db is changed
+	if p := stmt.getPreparedPlan(se.GetNamespace(), "db_mycat"); p != nil {
+		t.Errorf("prepared plan should not be used in another db")
+	}
+
+	// sharding table routed by placeholder of sharding column, the plan is pruned with bound value
+	stmt, err = se.handleStmtPrepare("select * from tbl_ks where name = ? and id = ?")
+	if err != nil {
+		t.Fatal("prepare error:", err)
+	}
+	if stmt.shardPlan == nil {
+		t.Fatal("plan of sharding table should be built at prepare time")
+	}
+	for _, id := range []int64{1, 2, 3} {
+		stmt.args = []interface{}{[]byte("a"), id}
+		p := stmt.getPreparedPlan(se.GetNamespace(), se.db)
+		if p == nil {
+			t.Fatal("prepared plan should be used")
+		}
+		sql, _ := stmt.GetRewriteSQL()
+		expect, err := se.getPlan(se.GetNamespace(), se.db, sql)
+		if err != nil {
+			t.Fatal("get plan error:", err)
+		}
+		actual := p.(*plan.SelectPlan).GetSQLs()
+		if !reflect.DeepEqual(actual, expect.(*plan.SelectPlan).GetSQLs()) {
+			t.Errorf("bind plan sql error, expect: %v, actual: %v", expect.(*plan.SelectPlan).GetSQLs(), actual)
+		}
+	}
+
+	// value of sharding column can't be routed without parsing, the plan is built in execution
+	stmt.args = []interface{}{[]byte("a"), nil}
+	if p := stmt.getPreparedPlan(se.GetNamespace(), se.db); p != nil {
+		t.Errorf("prepared plan should not be used with NULL value of sharding column")
+	}
+
+	// sharding table routed by other values, the plan is built in each execution
+	stmt, err = se.handleStmtPrepare("select * from tbl_ks where id in (?, ?)")
+	if err != nil {
+		t.Fatal("prepare error:", err)
+	}
+	if stmt.plan != nil || stmt.shardPlan != nil {
+		t.Errorf("plan of sharding table should not be built at prepare time")
+	}
+}
+
+func TestBindListArgs(t *testing.T) {
+	tests := []struct {
+		sql    string
+		args   []interface{}
+		expect string
+	}{
+		{"select * from t where id in (?)", []interface{}{ListArg{int64(1), int64(2)}}, "select * from t where id in (1, 2)"},
+		{"select * from t where name in (?) and c = ?", []interface{}{ListArg{[]byte("a'b"), nil}, int64(3)}, "select * from t where name in ('a\\'b', NULL) and c = 3"},
+		{"select * from t where (a, b) in (?)", []interface{}{ListArg{ListArg{int64(1), []byte("x")}, ListArg{int64(2), []byte("y")}}}, "select * from t where (a, b) in ((1, 'x'), (2, 'y'))"},
+	}
+	for _, test := range tests {
+		sql, err := bindQueryArgs(test.sql, test.args)
+		if err != nil {
+			t.Errorf("bind args error, sql: %s, err: %v", test.sql, err)
+			continue
+		}
+		if sql != test.expect {
+			t.Errorf("bind args error, expect: %s, actual: %s", test.expect, sql)
+		}
+	}
+
+	for _, args := range [][]interface{}{{ListArg{}}, {ListArg{ListArg{}}}, {ListArg{ListArg{ListArg{int64(1)}}}}} {
+		if _, err := bindQueryArgs("select * from t where id in (?)", args); err == nil {
+			t.Errorf("invalid list arg is not checked, args: %v", args)
+		}
+	}
+}
+
+func TestBindListArgsRouting(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+
+	// values of list are routed to each shard like literal values
+	sql, err := bindQueryArgs("select * from tbl_ks where id in (?)", []interface{}{ListArg{int64(1), int64(2), int64(5)}})
+	if err != nil {
+		t.Fatal("bind args error:", err)
+	}
+	p, err := se.getPlan(se.GetNamespace(), se.db, sql)
+	if err != nil {
+		t.Fatal("get plan error:", err)
+	}
+	expect := map[string]map[string][]string{
+		"slice-0": {"db_ks": {"SELECT * FROM `tbl_ks_0001` WHERE `id` IN (1,5)"}},
+		"slice-1": {"db_ks": {"SELECT * FROM `tbl_ks_0002` WHERE `id` IN (2)"}},
+	}
+	if actual := p.(*plan.SelectPlan).GetSQLs(); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("route list args error, expect: %v, actual: %v", expect, actual)
+	}
+}
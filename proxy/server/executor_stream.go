@@ -0,0 +1,505 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/tracing"
+)
+
+// rows buffered for each slice when streaming
+const streamBufferSize = 128
+
+// resultsetStreamWriter write resultset to client packet by packet, implemented by ClientConn
+type resultsetStreamWriter interface {
+	StartWriterBuffering()
+	Flush() error
+	writeResultsetHeader(status uint16, fields []*mysql.Field, metadata byte) error
+	writeRow(row []byte) error
+	writeEOFPacket(status uint16) error
+}
+
+// streamRow message sent from slice to the writer, fields is sent before rows of each statement
+type streamRow struct {
+	index  int
+	fields []*mysql.Field
+	data   mysql.RowData
+	values []interface{} // parsed values, only used for order by
+	end    bool
+	err    error
+}
+
+// executeInBackend execute sql in backend connection,
+// rows are counted in memory tracker of request context and the execution is aborted if the limit is exceeded.
+func executeInBackend(reqCtx *util.RequestContext, pc backend.PooledConnect, sql string) (*mysql.Result, error) {
+	tracker, ok := reqCtx.Get(util.QueryMemoryTracker).(*util.MemoryTracker)
+	if !ok {
+		return pc.Execute(sql)
+	}
+
+	var fields []*mysql.Field
+	var rows []mysql.RowData
+	r, err := pc.ExecuteStream(sql, func(fs []*mysql.Field) error {
+		fields = fs
+		return nil
+	}, func(row mysql.RowData) error {
+		// memory of buffered rows is held until the statement finishes
+		if !tracker.Consume(int64(len(row))) {
+			return mysql.NewErrf(mysql.ErrOutofMemory, "query memory exceeds max_query_memory: %d bytes", tracker.Limit())
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil || r.Resultset == nil {
+		return r, err
+	}
+
+	r.Fields = fields
+	for i, f := range fields {
+		r.FieldNames[string(f.Name)] = i
+	}
+	r.RowDatas = rows
+	r.Values = make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values, err := row.Parse(fields, false)
+		if err != nil {
+			return nil, err
+		}
+		r.Values = append(r.Values, values)
+	}
+	return r, nil
+}
+
+// canStreamSelect check if rows of the select plan can be written to client without merging
+func (se *SessionExecutor) canStreamSelect(p *plan.SelectPlan) bool {
+	if !se.streamable || se.streamWriter == nil || !se.GetNamespace().isStreamingSelect() || !p.IsStreamable() {
+		return false
+	}
+	// encrypted values are decrypted in result
+	if se.GetNamespace().GetEncryptor() != nil {
+		return false
+	}
+	// values are masked in result
+	if m := se.GetNamespace().GetMasker(); m != nil && m.IsEnabled(se.user) {
+		return false
+	}
+
+	sqls := p.GetSQLs()
+	count := 0
+	for _, dbSQLs := range sqls {
+		sliceCount := 0
+		for _, tableSQLs := range dbSQLs {
+			sliceCount += len(tableSQLs)
+		}
+		// rows of each slice must be one sorted stream to be merged
+		if p.HasOrderBy() && sliceCount != 1 {
+			return false
+		}
+		count += sliceCount
+	}
+	// single statement is written to client directly without merging anyway
+	return count > 1
+}
+
+// executeSelectStream execute select plan in slices and write rows to client as they arrive.
+// Rows are merged by order by columns if needed, and only limit is applied,
+// plans need aggregation or deduplication are not streamed.
+func (se *SessionExecutor) executeSelectStream(reqCtx *util.RequestContext, p *plan.SelectPlan) error {
+	sqls := p.GetSQLs()
+	shardCount := 0
+	for _, dbSQLs := range sqls {
+		for _, tableSQLs := range dbSQLs {
+			shardCount += len(tableSQLs)
+		}
+	}
+	if err := se.checkScatterRateLimit(shardCount); err != nil {
+		return err
+	}
+
+	pcs, err := se.getBackendConns(sqls, getFromSlave(reqCtx), true)
+	defer se.recycleBackendConns(pcs, false)
+	if err != nil {
+		exeLogger.Warnf("getShardConns failed: %v", err)
+		return err
+	}
+	addShardCount(reqCtx, shardCount)
+	addShards(reqCtx, sqls)
+
+	tracker, _ := reqCtx.Get(util.QueryMemoryTracker).(*util.MemoryTracker)
+	ordered := p.HasOrderBy()
+
+	// done is closed when the writer returns, remaining rows of slices are drained and discarded,
+	// so the backend connections can be reused.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	var shared chan *streamRow
+	streams := make([]chan *streamRow, 0, len(pcs))
+	if !ordered {
+		shared = make(chan *streamRow, streamBufferSize)
+	}
+	index := 0
+	for sliceName, pc := range pcs {
+		c := shared
+		if ordered {
+			c = make(chan *streamRow, streamBufferSize)
+		}
+		streams = append(streams, c)
+		wg.Add(1)
+		go se.produceStreamRows(reqCtx, index, sliceName, pc, sqls[sliceName], ordered, tracker, c, done, &wg)
+		index++
+	}
+
+	sw := &streamResultWriter{se: se, p: p, tracker: tracker}
+	if p.HasLimit() {
+		sw.offset, sw.count = p.GetLimitValue()
+	}
+	if ordered {
+		err = sw.writeOrdered(streams)
+	} else {
+		err = sw.writeUnordered(shared, len(streams))
+	}
+	if err == nil {
+		err = sw.finish()
+	}
+
+	reqCtx.Set(util.RowCount, sw.rowCount)
+	if err != nil {
+		exeLogger.Warnf("execute select stream error: %v", err)
+		if sw.headerWritten {
+			// flush rows written, then error packet is sent to client
+			se.streamWriter.Flush()
+		}
+	}
+	return err
+}
+
+// produceStreamRows execute statements of one slice and send rows to c
+func (se *SessionExecutor) produceStreamRows(reqCtx *util.RequestContext, index int, slice string, pc backend.PooledConnect,
+	execSqls map[string][]string, parse bool, tracker *util.MemoryTracker, c chan<- *streamRow, done <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	send := func(r *streamRow) bool {
+		select {
+		case c <- r:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	err := func() error {
+		for db, sqls := range execSqls {
+			if err := initBackendConn(pc, db, se.backendCharset(), se.backendCollationID(), se.GetVariables()); err != nil {
+				return err
+			}
+			for _, v := range sqls {
+				var fields []*mysql.Field
+				onFields := func(fs []*mysql.Field) error {
+					fields = fs
+					send(&streamRow{index: index, fields: fs})
+					return nil
+				}
+				onRow := func(data mysql.RowData) error {
+					if isStreamDone(done) {
+						return nil
+					}
+					r := &streamRow{index: index, data: data}
+					if parse {
+						values, err := data.Parse(fields, false)
+						if err != nil {
+							return err
+						}
+						r.values = values
+					}
+					if tracker != nil && !tracker.Consume(int64(len(data))) {
+						tracker.Release(int64(len(data)))
+						return mysql.NewErrf(mysql.ErrOutofMemory, "query memory exceeds max_query_memory: %d bytes", tracker.Limit())
+					}
+					if !send(r) && tracker != nil {
+						tracker.Release(int64(len(data)))
+					}
+					return nil
+				}
+
+				startTime := time.Now()
+				span := startShardSpan(reqCtx, slice, pc.GetAddr(), v)
+				err := se.executeStreamInConn(reqCtx, pc, attachShardSpan(reqCtx, span, v), onFields, onRow)
+				tracing.EndSpan(span, err)
+				se.manager.RecordBackendSQLMetrics(reqCtx, se.namespace, slice, v, pc.GetAddr(), startTime, err)
+				if err != nil {
+					return err
+				}
+				if isStreamDone(done) {
+					return nil
+				}
+			}
+		}
+		return nil
+	}()
+
+	send(&streamRow{index: index, end: true, err: err})
+}
+
+// executeStreamInConn execute sql in backend connection with rows handled by callbacks
+func (se *SessionExecutor) executeStreamInConn(reqCtx *util.RequestContext, pc backend.PooledConnect, sql string,
+	onFields func([]*mysql.Field) error, onRow func(mysql.RowData) error) error {
+	se.addRunningConn(pc)
+	defer se.removeRunningConn(pc)
+	if se.isCancelled() {
+		return mysql.NewDefaultError(mysql.ErrQueryInterrupted)
+	}
+
+	_, err := runWithDeadline(reqCtx, pc, func() (*mysql.Result, error) {
+		return pc.ExecuteStream(sql, onFields, onRow)
+	})
+	if err != nil && se.isCancelled() {
+		return mysql.NewDefaultError(mysql.ErrQueryInterrupted)
+	}
+	return err
+}
+
+func isStreamDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// streamResultWriter write rows received from slices to client
+type streamResultWriter struct {
+	se      *SessionExecutor
+	p       *plan.SelectPlan
+	tracker *util.MemoryTracker
+
+	fields        []*mysql.Field
+	extraStart    int
+	headerWritten bool
+	offset, count int64 // count -1 means no limit
+	rowCount      int64
+	limitReached  bool
+	sortKeys      []mysql.SortKey
+	converter     *mysql.CharsetConverter // convert rows to session charset, nil if not needed
+}
+
+// writeHeader write column definitions with the first fields received, extra columns are trimmed
+func (w *streamResultWriter) writeHeader(fields []*mysql.Field) error {
+	if w.headerWritten {
+		return nil
+	}
+	w.fields = fields
+	w.extraStart = w.p.GetExtraFieldStartIndex(len(fields))
+	if w.extraStart < 0 || w.extraStart >= len(fields) {
+		w.extraStart = -1
+	}
+	if w.p.HasOrderBy() {
+		w.sortKeys = w.p.GetOrderBySortKeys(len(fields))
+		mysql.SetSortKeyTypes(w.sortKeys, fields)
+	}
+
+	clientFields := fields
+	if w.extraStart != -1 {
+		clientFields = fields[:w.extraStart]
+	}
+	if w.converter = w.se.charsetConverter(); w.converter != nil {
+		clientFields = w.converter.ConvertFields(clientFields, w.se.collation)
+	}
+	w.se.streamWriter.StartWriterBuffering()
+	if err := w.se.streamWriter.writeResultsetHeader(w.se.status, clientFields, mysql.ResultsetMetadataFull); err != nil {
+		return err
+	}
+	w.headerWritten = true
+	w.se.streamed = true
+	return nil
+}
+
+// writeRow write one row to client after offset is skipped, memory of the row is released
+func (w *streamResultWriter) writeRow(r *streamRow) error {
+	if w.tracker != nil {
+		defer w.tracker.Release(int64(len(r.data)))
+	}
+	if w.limitReached {
+		return nil
+	}
+	if w.offset > 0 {
+		w.offset--
+		return nil
+	}
+
+	data := r.data
+	if w.extraStart != -1 {
+		var err error
+		if data, err = data.TrimText(w.extraStart); err != nil {
+			return err
+		}
+	}
+	if w.converter != nil {
+		var err error
+		if data, err = w.converter.ConvertTextRow(data, w.fields); err != nil {
+			return err
+		}
+	}
+	if err := w.se.streamWriter.writeRow(data); err != nil {
+		return err
+	}
+	w.rowCount++
+	if w.count != -1 && w.rowCount >= w.count {
+		w.limitReached = true
+	}
+	return nil
+}
+
+// writeUnordered write rows in the order they arrive, buffer is flushed when no rows are ready
+func (w *streamResultWriter) writeUnordered(c <-chan *streamRow, producers int) error {
+	for producers > 0 && !w.limitReached {
+		var r *streamRow
+		select {
+		case r = <-c:
+		default:
+			if w.headerWritten {
+				if err := w.se.streamWriter.Flush(); err != nil {
+					return err
+				}
+				w.se.streamWriter.StartWriterBuffering()
+			}
+			r = <-c
+		}
+
+		switch {
+		case r.end:
+			if r.err != nil {
+				return r.err
+			}
+			producers--
+		case r.fields != nil:
+			if err := w.writeHeader(r.fields); err != nil {
+				return err
+			}
+		default:
+			if err := w.writeRow(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeOrdered merge sorted rows of slices by order by columns
+func (w *streamResultWriter) writeOrdered(streams []chan *streamRow) error {
+	h := &streamRowHeap{w: w}
+	// next row of stream i, the stream is finished if it returns nil
+	next := func(i int) (*streamRow, error) {
+		for {
+			r := <-streams[i]
+			switch {
+			case r.end:
+				return nil, r.err
+			case r.fields != nil:
+				if err := w.writeHeader(r.fields); err != nil {
+					return nil, err
+				}
+			default:
+				return r, nil
+			}
+		}
+	}
+
+	for i := range streams {
+		r, err := next(i)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			h.rows = append(h.rows, r)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 && !w.limitReached {
+		r := h.rows[0]
+		if err := w.writeRow(r); err != nil {
+			return err
+		}
+		n, err := next(r.index)
+		if err != nil {
+			return err
+		}
+		if n != nil {
+			h.rows[0] = n
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// finish write EOF of resultset, empty resultset is written if no slice returns fields
+func (w *streamResultWriter) finish() error {
+	if !w.headerWritten {
+		// all slices failed before returning fields is handled as error, so it should not happen
+		if err := w.writeHeader(nil); err != nil {
+			return err
+		}
+	}
+	if err := w.se.streamWriter.writeEOFPacket(w.se.status); err != nil {
+		return err
+	}
+	return w.se.streamWriter.Flush()
+}
+
+type streamRowHeap struct {
+	w    *streamResultWriter
+	rows []*streamRow
+}
+
+func (h *streamRowHeap) Len() int {
+	return len(h.rows)
+}
+
+func (h *streamRowHeap) Less(i, j int) bool {
+	if v := mysql.CompareRows(h.rows[i].values, h.rows[j].values, h.w.sortKeys); v != 0 {
+		return v < 0
+	}
+	return h.rows[i].index < h.rows[j].index
+}
+
+func (h *streamRowHeap) Swap(i, j int) {
+	h.rows[i], h.rows[j] = h.rows[j], h.rows[i]
+}
+
+func (h *streamRowHeap) Push(x interface{}) {
+	h.rows = append(h.rows, x.(*streamRow))
+}
+
+func (h *streamRowHeap) Pop() interface{} {
+	n := len(h.rows)
+	r := h.rows[n-1]
+	h.rows = h.rows[:n-1]
+	return r
+}
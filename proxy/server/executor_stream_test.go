@@ -0,0 +1,71 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/backend/mocks"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockExecuteStream(conn *mocks.PooledConnect, sql string, rows ...string) {
+	fields := []*mysql.Field{{Name: []byte("name"), Type: mysql.TypeVarString}}
+	execute := func(sql string, onFields func([]*mysql.Field) error, onRow func(mysql.RowData) error) error {
+		if err := onFields(fields); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := onRow(append([]byte{byte(len(row))}, row...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	result := func(sql string, onFields func([]*mysql.Field) error, onRow func(mysql.RowData) error) *mysql.Result {
+		return &mysql.Result{Resultset: &mysql.Resultset{FieldNames: make(map[string]int)}}
+	}
+	conn.On("ExecuteStream", sql, mock.Anything, mock.Anything).Return(result, execute)
+}
+
+func TestExecuteInBackendWithMemoryTracker(t *testing.T) {
+	conn := new(mocks.PooledConnect)
+	mockExecuteStream(conn, "select name from tbl", "a", "bb", "ccc")
+
+	reqCtx := util.NewRequestContext()
+	tracker := util.NewMemoryTracker(100)
+	reqCtx.Set(util.QueryMemoryTracker, tracker)
+	r, err := executeInBackend(reqCtx, conn, "select name from tbl")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(r.Values))
+	assert.Equal(t, 0, r.FieldNames["name"])
+	assert.Equal(t, int64(9), tracker.Used())
+}
+
+func TestExecuteInBackendExceedMemoryLimit(t *testing.T) {
+	conn := new(mocks.PooledConnect)
+	mockExecuteStream(conn, "select name from tbl", "a", "bb", "ccc")
+
+	reqCtx := util.NewRequestContext()
+	reqCtx.Set(util.QueryMemoryTracker, util.NewMemoryTracker(5))
+	_, err := executeInBackend(reqCtx, conn, "select name from tbl")
+	assert.NotNil(t, err)
+	sqlErr, ok := err.(*mysql.SQLError)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(mysql.ErrOutofMemory), sqlErr.SQLCode())
+}
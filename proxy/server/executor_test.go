@@ -19,12 +19,17 @@ import (
 	"fmt"
 	"github.com/XiaoMi/Gaea/parser"
 	"github.com/pingcap/parser/ast"
+	"sync"
 	"testing"
 
 	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/backend/mocks"
+	"github.com/XiaoMi/Gaea/core/errors"
 	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/encrypt"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/proxy/tenant"
 	"github.com/XiaoMi/Gaea/util"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -62,6 +67,542 @@ func TestGetVariableExprResult(t *testing.T) {
 	}
 }
 
+func TestSetResultsetMetadata(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	assert.Equal(t, mysql.ResultsetMetadataFull, se.GetResultsetMetadata())
+
+	tests := []struct {
+		sql    string
+		expect byte
+		valid  bool
+	}{
+		{"set resultset_metadata = NONE", mysql.ResultsetMetadataNone, true},
+		{"set resultset_metadata = 'full'", mysql.ResultsetMetadataFull, true},
+		{"set resultset_metadata = none", mysql.ResultsetMetadataNone, true},
+		{"set resultset_metadata = DEFAULT", mysql.ResultsetMetadataFull, true},
+		{"set resultset_metadata = 'partial'", mysql.ResultsetMetadataFull, false},
+	}
+	for _, test := range tests {
+		s, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt := s.(*ast.SetStmt)
+		err = se.handleSetVariable(stmt.Variables[0])
+		assert.Equal(t, test.valid, err == nil, test.sql)
+		assert.Equal(t, test.expect, se.GetResultsetMetadata(), test.sql)
+	}
+}
+
+func TestSetTransactionMode(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	assert.Equal(t, models.TransactionModeMulti, se.getTransactionMode())
+
+	tests := []struct {
+		sql    string
+		expect string
+		valid  bool
+	}{
+		{"set transaction_mode = SINGLE", models.TransactionModeSingle, true},
+		{"set transaction_mode = 'twopc'", models.TransactionModeTwoPC, true},
+		{"set transaction_mode = DEFAULT", models.TransactionModeMulti, true},
+		{"set transaction_mode = 'xa'", models.TransactionModeMulti, false},
+	}
+	for _, test := range tests {
+		s, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt := s.(*ast.SetStmt)
+		err = se.handleSetVariable(stmt.Variables[0])
+		assert.Equal(t, test.valid, err == nil, test.sql)
+		assert.Equal(t, test.expect, se.getTransactionMode(), test.sql)
+	}
+
+	// transaction_mode can not be changed in transaction
+	se.txConns["slice-0"] = new(mocks.PooledConnect)
+	assert.NotNil(t, se.setTransactionMode(models.TransactionModeSingle))
+	assert.Equal(t, models.TransactionModeMulti, se.getTransactionMode())
+}
+
+func TestSetSystemVariables(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+
+	tests := []struct {
+		sql   string
+		name  string
+		value interface{} // nil means the variable is not stored in session
+		valid bool
+	}{
+		{"set group_concat_max_len = 4096", mysql.GroupConcatMaxLen, int64(4096), true},
+		{"set group_concat_max_len = 'abc'", mysql.GroupConcatMaxLen, int64(4096), false},
+		{"set foreign_key_checks = OFF", mysql.ForeignKeyChecks, int64(0), true},
+		{"set foreign_key_checks = DEFAULT", mysql.ForeignKeyChecks, nil, true},
+		{"set session transaction isolation level read committed", mysql.TxIsolation, "read-committed", true},
+		{"set transaction isolation level read committed", "tx_isolation_one_shot", nil, true},
+		{"set global sql_mode = 'ANSI'", mysql.SQLModeStr, nil, false},
+		{"set sql_auto_is_null = 1", "sql_auto_is_null", nil, true}, // ignored if system settings is disabled
+	}
+	for _, test := range tests {
+		s, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt := s.(*ast.SetStmt)
+		err = se.handleSetVariable(stmt.Variables[0])
+		assert.Equal(t, test.valid, err == nil, test.sql)
+		v, ok := se.GetVariables().Get(test.name)
+		assert.Equal(t, test.value != nil, ok, test.sql)
+		if ok {
+			assert.Equal(t, test.value, v.(*mysql.Variable).Get(), test.sql)
+		}
+	}
+
+	// system variables not handled by proxy are pushed down if system settings is enabled
+	se.GetNamespace().systemSettings = true
+	tests = []struct {
+		sql   string
+		name  string
+		value interface{}
+		valid bool
+	}{
+		{"set sql_auto_is_null = 1", "sql_auto_is_null", int64(1), true},
+		{"set @@session.long_query_time = 1.5", "long_query_time", float64(1.5), true},
+		{"set session innodb_strict_mode = 'OFF'", "innodb_strict_mode", "OFF", true},
+		{"set innodb_strict_mode = ON", "innodb_strict_mode", "ON", true},
+		{"set sql_auto_is_null = DEFAULT", "sql_auto_is_null", nil, true},
+		{"set max_heap_table_size = @@global.max_heap_table_size", "max_heap_table_size", nil, false},
+	}
+	for _, test := range tests {
+		s, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt := s.(*ast.SetStmt)
+		err = se.handleSetVariable(stmt.Variables[0])
+		assert.Equal(t, test.valid, err == nil, test.sql)
+		if test.value != nil {
+			v, ok := se.GetVariables().Get(test.name)
+			assert.True(t, ok, test.sql)
+			assert.Equal(t, test.value, v.(*mysql.Variable).Get(), test.sql)
+		}
+	}
+	_, ok := se.GetVariables().Get("sql_auto_is_null")
+	assert.False(t, ok)
+}
+
+func TestSingleTransactionModeSpanSlices(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	se.transactionMode = models.TransactionModeSingle
+
+	pc := new(mocks.PooledConnect)
+	se.txConns["slice-0"] = pc
+	conn, err := se.getTransactionConn("slice-0")
+	assert.Nil(t, err)
+	assert.Equal(t, pc, conn)
+
+	_, err = se.getTransactionConn("slice-1")
+	assert.NotNil(t, err)
+}
+
+func TestHandleKill(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	se.manager.clusterState = NewClusterState("", nil, 0)
+	se.connID = 10
+
+	other := newSessionExecutor(se.manager)
+	other.user = "other_user"
+	other.namespace = se.namespace
+	other.connID = 11
+	pc := new(mocks.PooledConnect)
+	pc.On("KillQuery").Return(nil)
+	other.addRunningConn(pc)
+	for _, executor := range []*SessionExecutor{se, other} {
+		cc := &Session{namespace: se.namespace, executor: executor}
+		assert.Nil(t, se.manager.GetClusterState().AddSession(cc, 0, 0))
+		defer se.manager.GetClusterState().RemoveSession(cc)
+	}
+
+	reqCtx := util.NewRequestContext()
+	assert.True(t, parser.PreviewSql("KILL QUERY 11").CanHandleWithoutPlan())
+
+	// connections of other users can only be killed by admin user
+	_, err = se.handleQueryWithoutPlan(reqCtx, "kill query 11")
+	sqlErr, ok := err.(*mysql.SQLError)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(mysql.ErrKillDenied), sqlErr.SQLCode())
+	assert.False(t, other.isCancelled())
+
+	se.GetNamespace().userProperties[se.user].Admin = true
+	_, err = se.handleQueryWithoutPlan(reqCtx, "KILL QUERY 11")
+	assert.Nil(t, err)
+	assert.True(t, other.isCancelled())
+	pc.AssertCalled(t, "KillQuery")
+	_, err = other.executeInConn(util.NewRequestContext(), pc, "select 1")
+	assert.Equal(t, mysql.NewDefaultError(mysql.ErrQueryInterrupted), err)
+
+	// killed flag is reset before next command
+	other.ExecuteCommand(mysql.ComPing, nil)
+	assert.False(t, other.isCancelled())
+
+	_, err = se.handleQueryWithoutPlan(reqCtx, "kill 12")
+	assert.NotNil(t, err)
+	rs := se.ExecuteCommand(mysql.ComProcessKill, []byte{12, 0, 0, 0})
+	assert.Equal(t, RespError, rs.RespType)
+}
+
+func TestPinNamespaceWhenReloading(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	old := se.GetNamespace()
+
+	se.pinNamespace()
+	assert.Equal(t, int64(1), old.sessions.Get())
+	assert.Nil(t, se.manager.ReloadNamespacePrepare(old.GetConfig()))
+	assert.Nil(t, se.manager.ReloadNamespaceCommit(se.namespace))
+	current := se.manager.GetNamespace(se.namespace)
+	assert.NotEqual(t, old, current)
+
+	// command in progress and transaction started in backend keep using the old namespace
+	assert.Equal(t, old, se.GetNamespace())
+	se.txConns["slice-0"] = new(mocks.PooledConnect)
+	se.unpinNamespace(false)
+	assert.Equal(t, old, se.GetNamespace())
+
+	delete(se.txConns, "slice-0")
+	se.unpinNamespace(false)
+	assert.Equal(t, current, se.GetNamespace())
+	assert.Equal(t, int64(0), old.sessions.Get())
+	assert.True(t, old.waitSessions(0))
+}
+
+func TestMergeSliceErrors(t *testing.T) {
+	assert.Nil(t, mergeSliceErrors(nil))
+
+	err1 := mysql.NewDefaultError(mysql.ErrNoSuchTable, "db", "tbl")
+	assert.Equal(t, err1, mergeSliceErrors(map[string]error{
+		"slice-0": err1,
+		"slice-1": errors.ErrExecutionCancelled,
+	}))
+
+	err := mergeSliceErrors(map[string]error{
+		"slice-1": err1,
+		"slice-0": fmt.Errorf("connection refused"),
+		"slice-2": errors.ErrExecutionCancelled,
+	})
+	sqlErr, ok := err.(*mysql.SQLError)
+	assert.True(t, ok)
+	assert.Equal(t, err1.Code, sqlErr.Code)
+	assert.Equal(t, "execute in 2 slices failed, slice-0: connection refused; slice-1: "+err1.Message, sqlErr.Message)
+}
+
+func TestCheckFullScatter(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+	getPlan := func(sql string) plan.Plan {
+		p, err := se.getPlan(ns, se.db, sql)
+		if err != nil {
+			t.Fatalf("get plan of %s error: %v", sql, err)
+		}
+		return p
+	}
+
+	sql := "select * from tbl_ks where name = 'a'"
+	assert.Nil(t, se.checkFullScatter(sql, getPlan(sql)))
+	ns.fullScatter = models.FullScatterWarn
+	assert.Nil(t, se.checkFullScatter(sql, getPlan(sql)))
+	ns.fullScatter = models.FullScatterReject
+	assert.NotNil(t, se.checkFullScatter(sql, getPlan(sql)))
+
+	for _, sql := range []string{"select * from tbl_ks where id = 1", "/*+ full_scan */ select * from tbl_ks where name = 'a'"} {
+		assert.Nil(t, se.checkFullScatter(sql, getPlan(sql)), sql)
+	}
+}
+
+func TestGetPlanWithEncryption(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+	ns.encryptor, err = encrypt.NewEncryptor(ns.GetName(), &models.Encryption{
+		Keys:    map[string]string{"k1": "MTIzNGFiY2Q1Njc4ZWZnKg=="},
+		Columns: []*models.EncryptColumn{{DB: "db_ks", Table: "tbl_ks", Column: "name", KeyID: "k1", Mode: models.EncryptModeDeterministic}},
+	})
+	if err != nil {
+		t.Fatal("create encryptor error:", err)
+	}
+
+	_, err = se.getPlan(ns, se.db, "select * from tbl_ks where name like 'a%'")
+	assert.NotNil(t, err)
+
+	p, err := se.getPlan(ns, se.db, "select * from tbl_ks where id = 1 and name = 'a'")
+	if assert.Nil(t, err) {
+		for _, dbSQLs := range p.(*plan.SelectPlan).GetSQLs() {
+			for _, sqls := range dbSQLs {
+				for _, sql := range sqls {
+					assert.NotContains(t, sql, "'a'")
+				}
+			}
+		}
+	}
+}
+
+func TestGetPlanWithTenant(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+	ns.tenant = tenant.NewIsolation(&models.Tenant{Tables: []string{"db_ks.tbl_ks"}})
+
+	sql := "select * from tbl_ks where id = 1"
+	_, err = se.getPlan(ns, se.db, sql)
+	assert.NotNil(t, err, "tenant id is required")
+
+	assert.Nil(t, se.handleSetVariable(&ast.VariableAssignment{Name: "tenant_id", Value: ast.NewValueExpr("Tenant_A", "", "")}))
+	assert.Equal(t, "Tenant_A", se.getTenantID())
+	p, err := se.getPlan(ns, se.db, sql)
+	if assert.Nil(t, err) {
+		for _, dbSQLs := range p.(*plan.SelectPlan).GetSQLs() {
+			for _, sqls := range dbSQLs {
+				for _, sql := range sqls {
+					assert.Contains(t, sql, "'Tenant_A'")
+				}
+			}
+		}
+	}
+	_, ok := ns.GetCachedPlan(se.db, sql)
+	assert.False(t, ok, "plan with tenant condition should not be cached")
+
+	ns.userProperties[se.user].TenantID = "42"
+	assert.NotNil(t, se.handleSetVariable(&ast.VariableAssignment{Name: "tenant_id", Value: ast.NewValueExpr("Tenant_B", "", "")}))
+	assert.Equal(t, "42", se.getTenantID())
+}
+
+func TestUserVariables(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+	set := func(sql string) error {
+		s, err := parser.ParseSQL(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = se.handleSet(util.NewRequestContext(), sql, s.(*ast.SetStmt))
+		return err
+	}
+
+	assert.Nil(t, set("set @Id = 5, @name := 'a''b', @price = 1.50, @id2 = @id"))
+	assert.Equal(t, int64(5), se.userVariables["id"])
+	assert.Equal(t, "a'b", se.userVariables["name"])
+	assert.Equal(t, int64(5), se.userVariables["id2"])
+	assert.Nil(t, set("set @id2 = null, autocommit = 1"))
+	_, ok := se.userVariables["id2"]
+	assert.False(t, ok)
+	assert.NotNil(t, set("set @x = (select id from tbl_ks limit 1)"))
+	assert.NotNil(t, set("set @x = id + 1"))
+
+	sql := "select @name, @price from tbl_ks where id = @id and name = @undefined"
+	p, err := se.getPlan(ns, se.db, sql)
+	if assert.Nil(t, err) {
+		sqls := p.(*plan.SelectPlan).GetSQLs()
+		assert.Equal(t, 1, len(sqls), "routed by value of @id")
+		for _, dbSQLs := range sqls {
+			for _, tableSQLs := range dbSQLs {
+				for _, sql := range tableSQLs {
+					assert.Contains(t, sql, "'a''b' AS `@name`,1.50 AS `@price`")
+					assert.Contains(t, sql, "`id`=5 AND `name`=NULL")
+				}
+			}
+		}
+	}
+	_, ok = ns.GetCachedPlan(se.db, sql)
+	assert.False(t, ok, "plan with user variables should not be cached")
+
+	_, err = se.getPlan(ns, se.db, "select @x := id from tbl_ks")
+	assert.NotNil(t, err, "assignment in statement is not supported")
+}
+
+func TestTransactionCharacteristics(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	set := func(sql string) error {
+		s, err := parser.ParseSQL(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = se.handleSet(util.NewRequestContext(), sql, s.(*ast.SetStmt))
+		return err
+	}
+
+	// session isolation level is used if the next transaction has no isolation level
+	assert.Nil(t, set("set session transaction isolation level repeatable read"))
+	assert.Equal(t, "repeatable-read", se.getTransactionIsolation())
+	assert.Nil(t, set("set transaction isolation level read committed"))
+	assert.Equal(t, "read-committed", se.getTransactionIsolation())
+	assert.False(t, se.isReadOnlyTransaction())
+
+	// read-only transaction of read write splitting user is executed in slave
+	slavePool := new(mocks.ConnectionPool)
+	slice := se.GetNamespace().slices["slice-0"]
+	slice.Slave = []backend.ConnectionPool{slavePool}
+	slice.SlaveWeights = []int{1}
+	slice.RoundRobinQ = []int{0}
+	slaveConn := new(mocks.PooledConnect)
+	slavePool.On("Get", context.TODO()).Return(slaveConn, nil).Once()
+	slaveConn.On("Execute", "SET TRANSACTION ISOLATION LEVEL READ COMMITTED, READ ONLY").Return(&mysql.Result{}, nil).Once()
+	slaveConn.On("Begin").Return(nil).Once()
+	slaveConn.On("Commit").Return(nil).Once()
+	slaveConn.On("Recycle").Return(nil)
+
+	assert.Nil(t, se.handleBegin(true))
+	assert.True(t, se.isReadOnlyTransaction())
+	assert.NotNil(t, set("set transaction read write"), "can not change characteristics after begin")
+	pc, err := se.getBackendConn("slice-0", false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, slaveConn, pc)
+	assert.NotNil(t, set("set transaction read write"), "can not change characteristics in transaction")
+
+	assert.Nil(t, se.handleCommit())
+	slaveConn.AssertExpectations(t)
+	assert.False(t, se.isReadOnlyTransaction())
+	assert.Equal(t, "repeatable-read", se.getTransactionIsolation())
+
+	// session access mode
+	assert.Nil(t, set("set session transaction read only"))
+	assert.True(t, se.isReadOnlyTransaction())
+	assert.Nil(t, set("set session transaction read write"))
+	assert.False(t, se.isReadOnlyTransaction())
+}
+
+func TestSessionReadConsistency(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+	ns.sessionConsistency = true
+	se.enableGTIDTracking()
+	v, ok := se.GetVariables().Get(mysql.SessionTrackGTIDsStr)
+	assert.True(t, ok)
+	assert.Equal(t, "OWN_GTID", v.(*mysql.Variable).Get())
+
+	masterPool := new(mocks.ConnectionPool)
+	slavePool := new(mocks.ConnectionPool)
+	for _, name := range []string{"slice-0", "slice-1"} {
+		ns.slices[name].Master = masterPool
+		ns.slices[name].Slave = []backend.ConnectionPool{slavePool}
+		ns.slices[name].SlaveWeights = []int{1}
+		ns.slices[name].RoundRobinQ = []int{0}
+	}
+	masterPool.On("Addr").Return("127.0.0.1:3306")
+
+	// GTID of write is tracked
+	masterConn := new(mocks.PooledConnect)
+	masterConn.On("GetAddr").Return("127.0.0.1:3306")
+	masterConn.On("TakeGTIDs").Return("3e11fa47-71ca-11e1-9e33-c80aa9429562:23").Once()
+	se.trackGTIDs("slice-0", masterConn)
+	assert.Equal(t, "3e11fa47-71ca-11e1-9e33-c80aa9429562:23", se.getGTIDs("slice-0"))
+
+	waitSQL := "SELECT WAIT_FOR_EXECUTED_GTID_SET('3e11fa47-71ca-11e1-9e33-c80aa9429562:23', 1)"
+	fields := []*mysql.Field{{Name: []byte("WAIT_FOR_EXECUTED_GTID_SET")}}
+	applied := &mysql.Result{Resultset: &mysql.Resultset{Fields: fields, Values: [][]interface{}{{int64(0)}}}}
+	timeout := &mysql.Result{Resultset: &mysql.Resultset{Fields: fields, Values: [][]interface{}{{int64(1)}}}}
+
+	// read from slave after the GTID is applied
+	slaveConn := new(mocks.PooledConnect)
+	slaveConn.On("GetAddr").Return("127.0.0.1:3307")
+	slavePool.On("Get", context.TODO()).Return(slaveConn, nil).Once()
+	slaveConn.On("Execute", waitSQL).Return(applied, nil).Once()
+	pc, err := se.getBackendConn("slice-0", true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, slaveConn, pc)
+	slaveConn.AssertCalled(t, "Execute", waitSQL)
+
+	// read from master if the GTID is not applied in time
+	slavePool.On("Get", context.TODO()).Return(slaveConn, nil).Once()
+	slaveConn.On("Execute", waitSQL).Return(timeout, nil).Once()
+	slaveConn.On("Recycle").Return(nil).Once()
+	masterPool.On("Get", context.TODO()).Return(masterConn, nil).Once()
+	pc, err = se.getBackendConn("slice-0", true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, masterConn, pc)
+	slaveConn.AssertExpectations(t)
+
+	// slices without writes of session are read from slave directly
+	slavePool.On("Get", context.TODO()).Return(slaveConn, nil).Once()
+	pc, err = se.getBackendConn("slice-1", true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, slaveConn, pc)
+	slaveConn.AssertNumberOfCalls(t, "Execute", 2)
+	slavePool.AssertExpectations(t)
+	masterPool.AssertExpectations(t)
+}
+
+func TestResetConnection(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	se.SetClientCollation(mysql.CollationID(33), "utf8")
+	se.SetCollationID(mysql.CollationID(45))
+	se.SetCharset("utf8mb4")
+	se.userVariables["a"] = int64(1)
+	se.stmts[1] = &Stmt{id: 1}
+	se.lastInsertID = 10
+	se.tenantID = "42"
+	assert.Nil(t, se.sessionVariables.Set(mysql.TransactionIsolation, "serializable"))
+
+	// transaction is rolled back
+	pc := new(mocks.PooledConnect)
+	pc.On("Rollback").Return(nil).Once()
+	pc.On("Recycle").Return(nil).Once()
+	se.txConns["slice-0"] = pc
+	se.status |= mysql.ServerStatusInTrans
+
+	rs := se.ExecuteCommand(mysql.ComResetConnection, nil)
+	assert.Equal(t, RespOK, rs.RespType)
+	pc.AssertExpectations(t)
+	assert.Equal(t, 0, len(se.txConns))
+	assert.Equal(t, uint16(0), rs.Status&mysql.ServerStatusInTrans)
+	assert.Equal(t, 0, len(se.userVariables))
+	assert.Equal(t, 0, len(se.stmts))
+	assert.Equal(t, uint64(0), se.lastInsertID)
+	assert.Equal(t, "", se.tenantID)
+	assert.Equal(t, "", se.getTransactionIsolation())
+
+	// user, database and charset of handshake are kept
+	assert.Equal(t, "test_executor", se.user)
+	assert.Equal(t, "db_ks", se.GetDatabase())
+	assert.Equal(t, mysql.CollationID(33), se.GetCollationID())
+	assert.Equal(t, "utf8", se.GetCharset())
+}
+
 func TestExecute(t *testing.T) {
 	se, err := prepareSessionExecutor()
 	if err != nil {
@@ -83,11 +624,11 @@ func TestExecute(t *testing.T) {
 	expectResult1 := &mysql.Result{}
 	expectResult2 := &mysql.Result{}
 	//slice-0
-	ctx := context.Background()
+	ctx := context.TODO()
 	slice0MasterConn := new(mocks.PooledConnect)
 	slice0MasterPool.On("Get", ctx).Return(slice0MasterConn, nil).Once()
 	slice0MasterConn.On("UseDB", "db_mycat_0").Return(nil)
-	slice0MasterConn.On("SetCharset", "utf8", mysql.CharsetsToCollationNames["utf8"]).Return(false, nil)
+	slice0MasterConn.On("SetCharset", "utf8", mysql.CollationID(33)).Return(false, nil)
 	slice0MasterConn.On("SetSessionVariables", mysql.NewSessionVariables()).Return(false, nil)
 	slice0MasterConn.On("GetAddr").Return("127.0.0.1:3306")
 	slice0MasterConn.On("Execute", "SELECT * FROM `tbl_mycat` WHERE `k`=0").Return(expectResult1, nil)
@@ -96,7 +637,7 @@ func TestExecute(t *testing.T) {
 	slice1MasterConn := new(mocks.PooledConnect)
 	slice1MasterPool.On("Get", ctx).Return(slice1MasterConn, nil).Once()
 	slice1MasterConn.On("UseDB", "db_mycat_2").Return(nil)
-	slice1MasterConn.On("SetCharset", "utf8", mysql.CharsetsToCollationNames["utf8"]).Return(false, nil)
+	slice1MasterConn.On("SetCharset", "utf8", mysql.CollationID(33)).Return(false, nil)
 	slice1MasterConn.On("SetSessionVariables", mysql.NewSessionVariables()).Return(false, nil)
 	slice1MasterConn.On("GetAddr").Return("127.0.0.1:3306")
 	slice1MasterConn.On("Execute", "SELECT * FROM `tbl_mycat` WHERE `k`=0").Return(expectResult2, nil)
@@ -143,6 +684,12 @@ func prepareSessionExecutor() (*SessionExecutor, error) {
 	return executor, nil
 }
 
+var (
+	testStatisticManagerOnce sync.Once
+	testStatisticManager     *StatisticManager
+	testStatisticManagerErr  error
+)
+
 func prepareNamespaceManager() (*Manager, error) {
 	proxyCfg := `
 ; source type, etcd/file, you can test gaea with file type, you shoud use etcd in production
@@ -266,6 +813,8 @@ encrypt_key=1234abcd5678efg*
 	if err != nil {
 		return nil, err
 	}
+	// keys of proxy config are snake case
+	cfg.NameMapper = ini.TitleUnderscore
 	if err = cfg.MapTo(proxy); err != nil {
 		return nil, err
 	}
@@ -278,13 +827,16 @@ encrypt_key=1234abcd5678efg*
 	}
 
 	m := NewManager()
-	// init statistics
-	statisticManager, err := CreateStatisticManager(proxy, m)
-	if err != nil {
-		log.Warnf("init stats manager failed, %v", err)
-		return nil, err
+	// init statistics, stats variables are registered globally so they can only be created once
+	testStatisticManagerOnce.Do(func() {
+		testStatisticManager, testStatisticManagerErr = CreateStatisticManager(proxy, m)
+	})
+	if testStatisticManagerErr != nil {
+		log.Warnf("init stats manager failed, %v", testStatisticManagerErr)
+		return nil, testStatisticManagerErr
 	}
-	m.statistics = statisticManager
+	testStatisticManager.manager = m
+	m.statistics = testStatisticManager
 
 	// init namespace
 	current, _, _ := m.switchIndex.Get()
@@ -0,0 +1,527 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/XiaoMi/Gaea/logging"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// Queries can be executed by gRPC service gaea.proxy.Query besides mysql protocol, through the same
+// plan and execution pipeline. Messages are encoded in json instead of protobuf, clients should call with
+// content subtype json, and send username and password of a namespace user in metadata.
+//
+// A call without session id is executed in a new session which is closed after the call, unless a transaction
+// is started by the call. Sessions in transaction are kept in proxy and returned with id, the session must be
+// passed to the following calls until the transaction is committed or rolled back.
+
+const (
+	// GRPCCodecName content subtype of messages of the service
+	GRPCCodecName = "json"
+
+	grpcServiceName      = "gaea.proxy.Query"
+	grpcMetadataUserName = "username"
+	grpcMetadataPassword = "password"
+)
+
+// GRPCSession session passed between calls
+type GRPCSession struct {
+	ID            string `json:"id,omitempty"` // id of session in transaction kept in proxy
+	DB            string `json:"db"`
+	InTransaction bool   `json:"in_transaction"`
+}
+
+// GRPCExecuteRequest request of Execute and StreamExecute
+type GRPCExecuteRequest struct {
+	Session *GRPCSession `json:"session"`
+	SQL     string       `json:"sql"`
+}
+
+// GRPCBatchStatement statement of ExecuteBatch
+type GRPCBatchStatement struct {
+	SQL      string        `json:"sql"`
+	BindVars []interface{} `json:"bind_vars"` // values of ? placeholders
+}
+
+// GRPCExecuteBatchRequest request of ExecuteBatch
+type GRPCExecuteBatchRequest struct {
+	Session    *GRPCSession          `json:"session"`
+	Statements []*GRPCBatchStatement `json:"statements"`
+}
+
+// GRPCSessionRequest request of Begin, Commit and Rollback
+type GRPCSessionRequest struct {
+	Session *GRPCSession `json:"session"`
+}
+
+// GRPCResponse response of all methods, session is only set in the last message of StreamExecute
+type GRPCResponse struct {
+	Session *GRPCSession `json:"session,omitempty"`
+	Result  *QueryResult `json:"result,omitempty"`
+	Error   *QueryError  `json:"error,omitempty"`
+}
+
+// GRPCBatchResponse response of ExecuteBatch, results and errors of statements are in the order of request
+type GRPCBatchResponse struct {
+	Session *GRPCSession    `json:"session,omitempty"`
+	Results []*GRPCResponse `json:"results"`
+}
+
+type grpcJSONCodec struct{}
+
+// Marshal implement encoding.Codec
+func (grpcJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implement encoding.Codec, numbers are decoded as json.Number to keep precision of bind vars
+func (grpcJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// Name implement encoding.Codec
+func (grpcJSONCodec) Name() string {
+	return GRPCCodecName
+}
+
+func init() {
+	encoding.RegisterCodec(grpcJSONCodec{})
+}
+
+// queryService is the handler type of service
+type queryService interface {
+	execute(ctx context.Context, req *GRPCExecuteRequest) (*GRPCResponse, error)
+	executeBatch(ctx context.Context, req *GRPCExecuteBatchRequest) (*GRPCBatchResponse, error)
+	streamExecute(req *GRPCExecuteRequest, stream grpc.ServerStream) error
+	begin(ctx context.Context, req *GRPCSessionRequest) (*GRPCResponse, error)
+	commit(ctx context.Context, req *GRPCSessionRequest) (*GRPCResponse, error)
+	rollback(ctx context.Context, req *GRPCSessionRequest) (*GRPCResponse, error)
+}
+
+func grpcSessionMethod(name string, call func(s queryService, ctx context.Context, req *GRPCSessionRequest) (*GRPCResponse, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := &GRPCSessionRequest{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return call(srv.(queryService), ctx, req)
+		},
+	}
+}
+
+var grpcQueryServiceDesc = grpc.ServiceDesc{
+	ServiceName: grpcServiceName,
+	HandlerType: (*queryService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Execute",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &GRPCExecuteRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				return srv.(queryService).execute(ctx, req)
+			},
+		},
+		{
+			MethodName: "ExecuteBatch",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &GRPCExecuteBatchRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				return srv.(queryService).executeBatch(ctx, req)
+			},
+		},
+		grpcSessionMethod("Begin", queryService.begin),
+		grpcSessionMethod("Commit", queryService.commit),
+		grpcSessionMethod("Rollback", queryService.rollback),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamExecute",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &GRPCExecuteRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(queryService).streamExecute(req, stream)
+			},
+		},
+	},
+	Metadata: "query",
+}
+
+// grpcSession session in transaction kept in proxy
+type grpcSession struct {
+	id         string
+	user       string
+	executor   *SessionExecutor
+	lock       sync.Mutex // calls of a session are executed one by one
+	lastActive time.Time  // protected by lock of grpcServer
+}
+
+// grpcServer serve queries over gRPC
+type grpcServer struct {
+	manager        *Manager
+	server         *grpc.Server
+	listener       net.Listener
+	sessionTimeout time.Duration // sessions in transaction idle for longer are rolled back
+
+	lock     sync.Mutex
+	sessions map[string]*grpcSession
+	closeC   chan struct{}
+}
+
+func newGRPCServer(addr string, manager *Manager, sessionTimeout time.Duration) (*grpcServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &grpcServer{
+		manager:        manager,
+		server:         grpc.NewServer(),
+		listener:       l,
+		sessionTimeout: sessionTimeout,
+		sessions:       make(map[string]*grpcSession),
+		closeC:         make(chan struct{}),
+	}
+	s.server.RegisterService(&grpcQueryServiceDesc, s)
+	return s, nil
+}
+
+func (s *grpcServer) run() {
+	go s.evictIdleSessions()
+	if err := s.server.Serve(s.listener); err != nil {
+		logging.DefaultLogger.Warnf("[server] grpc server stopped: %v", err)
+	}
+}
+
+// close stop server and rollback all sessions
+func (s *grpcServer) close() {
+	close(s.closeC)
+	s.server.Stop()
+	_ = s.listener.Close()
+	s.lock.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*grpcSession)
+	s.lock.Unlock()
+	for _, gs := range sessions {
+		gs.lock.Lock()
+		closeLocalSessionExecutor(gs.executor)
+		gs.lock.Unlock()
+	}
+}
+
+func (s *grpcServer) evictIdleSessions() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closeC:
+			return
+		case now := <-ticker.C:
+			s.closeIdleSessions(now)
+		}
+	}
+}
+
+// closeIdleSessions rollback sessions idle for longer than session timeout, return count of closed sessions
+func (s *grpcServer) closeIdleSessions(now time.Time) int {
+	var idle []*grpcSession
+	s.lock.Lock()
+	for id, gs := range s.sessions {
+		if now.Sub(gs.lastActive) > s.sessionTimeout {
+			delete(s.sessions, id)
+			idle = append(idle, gs)
+		}
+	}
+	s.lock.Unlock()
+	for _, gs := range idle {
+		logging.DefaultLogger.Infow("close idle grpc session", logging.FieldNamespace, gs.executor.namespace, "timeout", s.sessionTimeout.String())
+		gs.lock.Lock()
+		closeLocalSessionExecutor(gs.executor)
+		gs.lock.Unlock()
+	}
+	return len(idle)
+}
+
+func newGRPCSessionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// authenticate return user and namespace of credential in metadata
+func (s *grpcServer) authenticate(ctx context.Context) (string, string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	user, password := get(grpcMetadataUserName), get(grpcMetadataPassword)
+	namespace := ""
+	if s.manager.CheckUser(user) {
+		namespace = s.manager.GetNamespaceByUser(user, password)
+	}
+	if namespace == "" || s.manager.GetNamespace(namespace) == nil {
+		return "", "", status.Errorf(codes.Unauthenticated, "access denied for user %s", user)
+	}
+	return user, namespace, nil
+}
+
+// acquire return locked session of request, a new session is created if id is empty
+func (s *grpcServer) acquire(ctx context.Context, session *GRPCSession) (*grpcSession, error) {
+	user, namespace, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientAddr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		clientAddr = p.Addr.String()
+	}
+	ns := s.manager.GetNamespace(namespace)
+	if host, _, err := net.SplitHostPort(clientAddr); err == nil && !ns.IsClientIPAllowed(net.ParseIP(host)) {
+		return nil, status.Error(codes.PermissionDenied, "ip address access denied by gaea")
+	}
+	if session == nil {
+		session = &GRPCSession{}
+	}
+
+	if session.ID != "" {
+		s.lock.Lock()
+		gs, ok := s.sessions[session.ID]
+		s.lock.Unlock()
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "session %s is not found, it may be rolled back after idle timeout", session.ID)
+		}
+		if gs.user != user {
+			return nil, status.Errorf(codes.PermissionDenied, "session %s belongs to another user", session.ID)
+		}
+		gs.lock.Lock()
+		return gs, nil
+	}
+
+	se, err := newLocalSessionExecutor(s.manager, user, namespace, clientAddr, session.DB)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	gs := &grpcSession{user: user, executor: se}
+	gs.lock.Lock()
+	return gs, nil
+}
+
+// release keep session in proxy if it's in transaction, otherwise close it, and return session of response
+func (s *grpcServer) release(gs *grpcSession) *GRPCSession {
+	defer gs.lock.Unlock()
+	se := gs.executor
+	inTransaction := se.isInTransaction() || se.hasTransactionConns()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if inTransaction {
+		if gs.id == "" {
+			gs.id = newGRPCSessionID()
+			s.sessions[gs.id] = gs
+		}
+		gs.lastActive = time.Now()
+		return &GRPCSession{ID: gs.id, DB: se.db, InTransaction: true}
+	}
+	if gs.id != "" {
+		delete(s.sessions, gs.id)
+	}
+	closeLocalSessionExecutor(se)
+	return &GRPCSession{DB: se.db}
+}
+
+func (s *grpcServer) execute(ctx context.Context, req *GRPCExecuteRequest) (*GRPCResponse, error) {
+	gs, err := s.acquire(ctx, req.Session)
+	if err != nil {
+		return nil, err
+	}
+	resp := newGRPCResponse(executeQueryContext(ctx, gs.executor, req.SQL))
+	resp.Session = s.release(gs)
+	return resp, nil
+}
+
+// newGRPCResponse return response of statement without session
+func newGRPCResponse(rs Response) *GRPCResponse {
+	resp := &GRPCResponse{}
+	switch rs.RespType {
+	case RespError:
+		resp.Error = newQueryError(rs.Data.(error))
+	case RespResult:
+		resp.Result = newQueryResult(rs.Data.(*mysql.Result))
+	default:
+		resp.Result = &QueryResult{}
+	}
+	return resp
+}
+
+// executeBatch execute statements in order within one call, INSERT, UPDATE and DELETE of sharding tables are
+// pipelined in backend connections. Bind vars of all statements are checked before any statement is executed.
+func (s *grpcServer) executeBatch(ctx context.Context, req *GRPCExecuteBatchRequest) (*GRPCBatchResponse, error) {
+	sqls := make([]string, len(req.Statements))
+	for i, stmt := range req.Statements {
+		if stmt == nil || stmt.SQL == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "sql of statement %d is empty", i)
+		}
+		args, err := queryBindVars(stmt.BindVars)
+		if err == nil {
+			sqls[i], err = bindQueryArgs(stmt.SQL, args)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "statement %d: %v", i, err)
+		}
+	}
+
+	gs, err := s.acquire(ctx, req.Session)
+	if err != nil {
+		return nil, err
+	}
+	resp := &GRPCBatchResponse{Results: make([]*GRPCResponse, 0, len(sqls))}
+	for _, rs := range executeBatchContext(ctx, gs.executor, sqls) {
+		resp.Results = append(resp.Results, newGRPCResponse(rs))
+	}
+	resp.Session = s.release(gs)
+	return resp, nil
+}
+
+func (s *grpcServer) begin(ctx context.Context, req *GRPCSessionRequest) (*GRPCResponse, error) {
+	return s.execute(ctx, &GRPCExecuteRequest{Session: req.Session, SQL: "BEGIN"})
+}
+
+func (s *grpcServer) commit(ctx context.Context, req *GRPCSessionRequest) (*GRPCResponse, error) {
+	return s.execute(ctx, &GRPCExecuteRequest{Session: req.Session, SQL: "COMMIT"})
+}
+
+func (s *grpcServer) rollback(ctx context.Context, req *GRPCSessionRequest) (*GRPCResponse, error) {
+	return s.execute(ctx, &GRPCExecuteRequest{Session: req.Session, SQL: "ROLLBACK"})
+}
+
+// grpcStreamWriter send resultset written by streaming select in batches of rows
+type grpcStreamWriter struct {
+	send   func(*GRPCResponse) error
+	fields []*mysql.Field
+	rows   [][]interface{}
+}
+
+// StartWriterBuffering implement resultsetStreamWriter
+func (w *grpcStreamWriter) StartWriterBuffering() {}
+
+// Flush send buffered rows
+func (w *grpcStreamWriter) Flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+	rows := w.rows
+	w.rows = nil
+	return w.send(&GRPCResponse{Result: &QueryResult{Rows: rows}})
+}
+
+func (w *grpcStreamWriter) writeResultsetHeader(status uint16, fields []*mysql.Field, metadata byte) error {
+	w.fields = fields
+	return w.send(&GRPCResponse{Result: &QueryResult{Fields: newQueryFields(fields)}})
+}
+
+func (w *grpcStreamWriter) writeRow(row []byte) error {
+	values, err := mysql.RowData(row).ParseText(w.fields)
+	if err != nil {
+		return err
+	}
+	w.rows = append(w.rows, queryRow(values, w.fields))
+	if len(w.rows) >= streamBufferSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+func (w *grpcStreamWriter) writeEOFPacket(status uint16) error {
+	return w.Flush()
+}
+
+// sendResult send fields and rows of result in batches
+func (w *grpcStreamWriter) sendResult(r *mysql.Result) error {
+	result := newQueryResult(r)
+	rows := result.Rows
+	result.Rows = nil
+	if err := w.send(&GRPCResponse{Result: result}); err != nil {
+		return err
+	}
+	for len(rows) > 0 {
+		n := streamBufferSize
+		if n > len(rows) {
+			n = len(rows)
+		}
+		if err := w.send(&GRPCResponse{Result: &QueryResult{Rows: rows[:n]}}); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// streamExecute send fields in the first message and rows in the following messages, then session and error
+// in the last message. Rows are sent while being read from backends if streaming select is enabled in namespace.
+func (s *grpcServer) streamExecute(req *GRPCExecuteRequest, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	gs, err := s.acquire(ctx, req.Session)
+	if err != nil {
+		return err
+	}
+	w := &grpcStreamWriter{send: func(resp *GRPCResponse) error { return stream.SendMsg(resp) }}
+	se := gs.executor
+	se.streamWriter = w
+	rs := executeQueryContext(ctx, se, req.SQL)
+	se.streamWriter = nil
+
+	last := &GRPCResponse{}
+	switch rs.RespType {
+	case RespError:
+		last.Error = newQueryError(rs.Data.(error))
+	case RespResult:
+		if err = w.sendResult(rs.Data.(*mysql.Result)); err != nil {
+			se.killQuery()
+		}
+	}
+	last.Session = s.release(gs)
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(last)
+}
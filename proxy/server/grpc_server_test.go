@@ -0,0 +1,50 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGRPCStreamWriter(t *testing.T) {
+	var sent []*GRPCResponse
+	w := &grpcStreamWriter{send: func(resp *GRPCResponse) error {
+		sent = append(sent, resp)
+		return nil
+	}}
+	fields := []*mysql.Field{{Name: []byte("name"), Type: mysql.TypeVarString}}
+	assert.Nil(t, w.writeResultsetHeader(0, fields, 0))
+	for i := 0; i < streamBufferSize+1; i++ {
+		assert.Nil(t, w.writeRow(mysql.AppendLenEncStringBytes(nil, []byte("a"))))
+	}
+	assert.Nil(t, w.writeEOFPacket(0))
+	assert.Equal(t, 3, len(sent))
+	assert.Equal(t, "name", sent[0].Result.Fields[0].Name)
+	assert.Equal(t, streamBufferSize, len(sent[1].Result.Rows))
+	assert.Equal(t, [][]interface{}{{"a"}}, sent[2].Result.Rows)
+
+	sent = nil
+	rows := make([][]interface{}, streamBufferSize*2)
+	for i := range rows {
+		rows[i] = []interface{}{[]byte("b")}
+	}
+	assert.Nil(t, w.sendResult(&mysql.Result{Resultset: &mysql.Resultset{Fields: fields, Values: rows}}))
+	assert.Equal(t, 3, len(sent))
+	assert.Nil(t, sent[0].Result.Rows)
+	assert.Equal(t, streamBufferSize, len(sent[2].Result.Rows))
+}
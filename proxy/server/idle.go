@@ -0,0 +1,93 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/XiaoMi/Gaea/logging"
+)
+
+// Idle client connections are closed by the eviction loop of server like wait_timeout of mysql. Session is idle
+// while waiting for the next command, and the timeout is wait_timeout of the session if it's set, otherwise
+// client_idle_timeout of namespace, or session_timeout of proxy. Timeouts are read in each check, so changes of
+// namespace config take effect on idle sessions without reconnecting.
+
+const (
+	idleCheckInterval = time.Second
+
+	// max value of wait_timeout, the same as mysql
+	maxWaitTimeout = 31536000
+)
+
+// setActive mark session as executing command or waiting for the next command
+func (cc *Session) setActive(active bool) {
+	if active {
+		cc.idleSince.Set(0)
+	} else {
+		cc.idleSince.Set(time.Now().UnixNano())
+	}
+}
+
+// idleTimeout return timeout of waiting for the next command
+func (cc *Session) idleTimeout() time.Duration {
+	if t := cc.executor.waitTimeout; t > 0 {
+		return time.Duration(t) * time.Second
+	}
+	if ns := cc.getNamespace(); ns != nil {
+		if t := ns.getClientIdleTimeout(); t > 0 {
+			return t
+		}
+	}
+	return cc.proxy.sessionTimeout
+}
+
+// isIdleTimeout check if session has been idle for longer than idle timeout
+func (cc *Session) isIdleTimeout(now time.Time) bool {
+	idleSince := cc.idleSince.Get()
+	if idleSince == 0 {
+		return false
+	}
+	timeout := cc.idleTimeout()
+	return timeout > 0 && now.Sub(time.Unix(0, idleSince)) > timeout
+}
+
+// evictIdleSessions close idle sessions periodically until server is closed, transactions of the sessions are
+// rolled back and backend connections reserved by the sessions are released.
+func (s *Server) evictIdleSessions() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.closed.Get() {
+			return
+		}
+		s.closeIdleSessions(time.Now())
+	}
+}
+
+// closeIdleSessions close sessions which have been idle for longer than idle timeout, return count of closed sessions
+func (s *Server) closeIdleSessions(now time.Time) int {
+	count := 0
+	for _, cc := range s.manager.GetClusterState().getAllLocalSessions() {
+		if cc.IsClosed() || !cc.isIdleTimeout(now) {
+			continue
+		}
+		logging.DefaultLogger.Infow("close idle session",
+			logging.FieldConnID, cc.c.GetConnectionID(), logging.FieldNamespace, cc.namespace, "timeout", cc.idleTimeout().String())
+		cc.Close()
+		count++
+	}
+	return count
+}
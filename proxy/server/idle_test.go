@@ -0,0 +1,86 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+func TestCloseIdleSessions(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	se.manager.clusterState = NewClusterState("", nil, 0)
+	s := &Server{manager: se.manager, sessionTimeout: time.Hour}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	cc := &Session{namespace: se.namespace, executor: se, proxy: s, manager: se.manager}
+	cc.c = NewClientConn(mysql.NewConn(conn), se.manager)
+	cc.closed.Store(false)
+	assert.Nil(t, se.manager.GetClusterState().AddSession(cc, 0, 0))
+	defer se.manager.GetClusterState().RemoveSession(cc)
+
+	cc.setActive(false)
+	now := time.Now()
+	assert.Equal(t, time.Hour, cc.idleTimeout())
+	assert.Equal(t, 0, s.closeIdleSessions(now.Add(time.Minute)))
+
+	// client_idle_timeout of namespace takes precedence over session_timeout of proxy
+	se.GetNamespace().clientIdleTimeout = 30
+	assert.Equal(t, 30*time.Second, cc.idleTimeout())
+
+	// wait_timeout of session takes precedence over client_idle_timeout of namespace
+	tests := []struct {
+		sql    string
+		expect time.Duration
+		valid  bool
+	}{
+		{"set wait_timeout = 120", 120 * time.Second, true},
+		{"set wait_timeout = 0", 120 * time.Second, false},
+		{"set wait_timeout = 'abc'", 120 * time.Second, false},
+		{"set wait_timeout = DEFAULT", 30 * time.Second, true},
+		{"set session wait_timeout = 600", 600 * time.Second, true},
+	}
+	for _, test := range tests {
+		n, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = se.handleSetVariable(n.(*ast.SetStmt).Variables[0])
+		assert.Equal(t, test.valid, err == nil, test.sql)
+		assert.Equal(t, test.expect, cc.idleTimeout(), test.sql)
+	}
+
+	// session executing command is not closed
+	cc.setActive(true)
+	assert.Equal(t, 0, s.closeIdleSessions(now.Add(time.Hour)))
+	assert.False(t, cc.IsClosed())
+
+	cc.setActive(false)
+	now = time.Now()
+	assert.Equal(t, 0, s.closeIdleSessions(now.Add(time.Minute)))
+	assert.Equal(t, 1, s.closeIdleSessions(now.Add(11*time.Minute)))
+	assert.True(t, cc.IsClosed())
+}
@@ -0,0 +1,239 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// Client connections are accepted by tcp listener and optional unix socket listener. Connections from networks in
+// proxy-protocol-networks must start with PROXY protocol v1 or v2 header sent by L4 load balancer, and the source
+// address in the header is used as client address in ip rules, audit logs and sql logs.
+
+// timeout of reading PROXY protocol header
+const proxyProtocolHeaderTimeout = 5 * time.Second
+
+// max length of PROXY protocol v1 header, including CRLF
+const proxyProtocolV1MaxLength = 107
+
+var (
+	proxyProtocolV1Prefix    = []byte("PROXY ")
+	proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+	// client address of unix socket connections, which are treated as local connections in ip rules
+	unixSocketClientAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+	errInvalidProxyProtocolHeader = errors.New("invalid proxy protocol header")
+)
+
+// remoteAddrConn is client connection whose remote address is replaced by the real client address
+type remoteAddrConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+// RemoteAddr return the real client address
+func (c *remoteAddrConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// rawConn return the underlying connection of client connection
+func rawConn(c net.Conn) net.Conn {
+	if rc, ok := c.(*remoteAddrConn); ok {
+		return rc.Conn
+	}
+	return c
+}
+
+// proxyProtocolNetworks is networks of L4 load balancers which send PROXY protocol header
+type proxyProtocolNetworks struct {
+	all      bool
+	networks []util.IPInfo
+}
+
+// parseProxyProtocolNetworks parse networks separated by comma, * means all networks
+func parseProxyProtocolNetworks(s string) (*proxyProtocolNetworks, error) {
+	n := &proxyProtocolNetworks{}
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if v == "*" {
+			n.all = true
+			continue
+		}
+		info, err := util.ParseIPInfo(v)
+		if err != nil {
+			return nil, err
+		}
+		n.networks = append(n.networks, info)
+	}
+	return n, nil
+}
+
+// contains check if PROXY protocol header is required for connection from addr
+func (n *proxyProtocolNetworks) contains(addr net.Addr) bool {
+	if n == nil {
+		return false
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	if n.all {
+		return true
+	}
+	for _, info := range n.networks {
+		if info.Match(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// listenUnixSocket listen on unix socket, stale socket file left by previous process is removed
+func listenUnixSocket(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// prepareClientConn return client connection with the real client address, PROXY protocol header is read
+// if the connection is from networks of L4 load balancers
+func (s *Server) prepareClientConn(c net.Conn) (net.Conn, error) {
+	if _, ok := c.(*net.UnixConn); ok {
+		return &remoteAddrConn{Conn: c, remoteAddr: unixSocketClientAddr}, nil
+	}
+	if !s.proxyProtocolNetworks.contains(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	if err := c.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout)); err != nil {
+		return nil, err
+	}
+	addr, err := readProxyProtocolHeader(c)
+	if err != nil {
+		return nil, fmt.Errorf("read proxy protocol header from %s error: %v", c.RemoteAddr(), err)
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	// LOCAL command or unknown protocol, e.g. health check of load balancer
+	if addr == nil {
+		return c, nil
+	}
+	return &remoteAddrConn{Conn: c, remoteAddr: addr}, nil
+}
+
+// readProxyProtocolHeader read PROXY protocol v1 or v2 header, and return the source address in header.
+// nil is returned if the header doesn't contain address of tcp.
+// See: https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+func readProxyProtocolHeader(r io.Reader) (net.Addr, error) {
+	// the shortest v1 header "PROXY UNKNOWN\r\n" is longer than v2 signature, so it's safe to read 12 bytes first
+	buf := make([]byte, len(proxyProtocolV2Signature))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(buf, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(r)
+	}
+	if bytes.HasPrefix(buf, proxyProtocolV1Prefix) {
+		return readProxyProtocolV1(r, buf)
+	}
+	return nil, errInvalidProxyProtocolHeader
+}
+
+// readProxyProtocolV1 read the rest of v1 header byte by byte, to avoid reading data after the header
+func readProxyProtocolV1(r io.Reader, line []byte) (net.Addr, error) {
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxLength {
+			return nil, errInvalidProxyProtocolHeader
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+
+	// PROXY TCP4 <src ip> <dst ip> <src port> <dst port>
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidProxyProtocolHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errInvalidProxyProtocolHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyProtocolV2 read the rest of v2 header after signature
+func readProxyProtocolV2(r io.Reader) (net.Addr, error) {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	verCmd, family, length := buf[0], buf[1], binary.BigEndian.Uint16(buf[2:])
+	if verCmd>>4 != 2 {
+		return nil, errInvalidProxyProtocolHeader
+	}
+	addrs := make([]byte, length)
+	if _, err := io.ReadFull(r, addrs); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0x0F {
+	case 0x00: // LOCAL
+		return nil, nil
+	case 0x01: // PROXY
+	default:
+		return nil, errInvalidProxyProtocolHeader
+	}
+
+	// address family: 1 means AF_INET, 2 means AF_INET6, addresses of AF_UNSPEC and AF_UNIX are ignored
+	switch family >> 4 {
+	case 0x01:
+		if len(addrs) < 12 {
+			return nil, errInvalidProxyProtocolHeader
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[:4]), Port: int(binary.BigEndian.Uint16(addrs[8:]))}, nil
+	case 0x02:
+		if len(addrs) < 36 {
+			return nil, errInvalidProxyProtocolHeader
+		}
+		return &net.TCPAddr{IP: net.IP(addrs[:16]), Port: int(binary.BigEndian.Uint16(addrs[32:]))}, nil
+	}
+	return nil, nil
+}
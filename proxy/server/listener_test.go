@@ -0,0 +1,86 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	v2 := func(verCmd, family byte, addrs ...byte) []byte {
+		header := append([]byte{}, proxyProtocolV2Signature...)
+		header = append(header, verCmd, family, byte(len(addrs)>>8), byte(len(addrs)))
+		return append(header, addrs...)
+	}
+	ipv6Addrs := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x30, 0x39, 0x0C, 0xEA)
+
+	tests := []struct {
+		header []byte
+		addr   string // empty means no address in header
+		err    bool
+	}{
+		{[]byte("PROXY TCP4 192.168.1.1 10.0.0.1 56324 3306\r\n"), "192.168.1.1:56324", false},
+		{[]byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 3306\r\n"), "[2001:db8::1]:12345", false},
+		{[]byte("PROXY UNKNOWN\r\n"), "", false},
+		{[]byte("PROXY TCP4 192.168.1.1 10.0.0.1\r\n"), "", true},
+		{[]byte("PROXY TCP4 192.168.1.1 10.0.0.1 56324 3306"), "", true},
+		{v2(0x21, 0x11, 192, 168, 1, 1, 10, 0, 0, 1, 0xDC, 0x04, 0x0C, 0xEA), "192.168.1.1:56324", false},
+		{v2(0x21, 0x21, ipv6Addrs...), "[2001:db8::1]:12345", false},
+		{v2(0x20, 0x00), "", false},
+		{v2(0x21, 0x11, 192, 168, 1, 1), "", true},
+		{v2(0x11, 0x11, 192, 168, 1, 1, 10, 0, 0, 1, 0xDC, 0x04, 0x0C, 0xEA), "", true},
+		{[]byte("GET / HTTP/1.1\r\n"), "", true},
+	}
+	for _, test := range tests {
+		// data after header must not be read
+		r := bytes.NewReader(append(append([]byte{}, test.header...), "mysql"...))
+		addr, err := readProxyProtocolHeader(r)
+		if test.err {
+			assert.NotNil(t, err, "%q", test.header)
+			continue
+		}
+		assert.Nil(t, err, "%q", test.header)
+		if test.addr == "" {
+			assert.Nil(t, addr, "%q", test.header)
+		} else {
+			assert.Equal(t, test.addr, addr.String(), "%q", test.header)
+		}
+		assert.Equal(t, 5, r.Len(), "%q", test.header)
+	}
+}
+
+func TestProxyProtocolNetworks(t *testing.T) {
+	n, err := parseProxyProtocolNetworks("10.0.0.0/8, 192.168.1.1")
+	assert.Nil(t, err)
+	assert.True(t, n.contains(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 3306}))
+	assert.True(t, n.contains(&net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 3306}))
+	assert.False(t, n.contains(&net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 3306}))
+	assert.False(t, n.contains(&net.UnixAddr{Name: "/tmp/gaea.sock", Net: "unix"}))
+
+	n, err = parseProxyProtocolNetworks("*")
+	assert.Nil(t, err)
+	assert.True(t, n.contains(&net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 3306}))
+
+	n, err = parseProxyProtocolNetworks("")
+	assert.Nil(t, err)
+	assert.False(t, n.contains(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 3306}))
+
+	_, err = parseProxyProtocolNetworks("10.0.0.0/33")
+	assert.NotNil(t, err)
+}
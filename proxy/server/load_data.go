@@ -0,0 +1,442 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// LOAD DATA LOCAL INFILE: proxy requests the file from client, splits its content into rows, and inserts the rows
+// by multi-row INSERT statements, which are routed by sharding column of each row like other INSERT statements.
+
+const (
+	loadDataBatchRows = 1000    // max rows of each INSERT statement
+	loadDataBatchSize = 1 << 20 // max bytes of each INSERT statement
+)
+
+// localInfileReader request file of LOAD DATA LOCAL INFILE and read its content from client, implemented by ClientConn
+type localInfileReader interface {
+	isLocalInfileSupported() bool
+	requestLocalInfile(filename string) error
+	readLocalInfilePacket() ([]byte, error) // empty packet means end of file
+}
+
+// handleLoadData execute LOAD DATA LOCAL INFILE, rows are inserted in an implicit transaction if session is not in
+// transaction. Content of the file is always read until the end, so the protocol is not broken if insert fails.
+func (se *SessionExecutor) handleLoadData(reqCtx *util.RequestContext, sql string) (*mysql.Result, error) {
+	n, err := se.Parse(sql)
+	if err != nil {
+		return nil, fmt.Errorf("parse parser error, parser: %s, err: %v", sql, err)
+	}
+	stmt, ok := n.(*ast.LoadDataStmt)
+	if !ok {
+		return nil, fmt.Errorf("not a LOAD DATA statement: %s", sql)
+	}
+	if !stmt.IsLocal {
+		return nil, mysql.NewError(mysql.ErrNotSupportedYet, "LOAD DATA INFILE of server file is not supported by gaea, please use LOAD DATA LOCAL INFILE")
+	}
+	if se.infileReader == nil || !se.infileReader.isLocalInfileSupported() {
+		return nil, mysql.NewDefaultError(mysql.ErrNotAllowedCommand)
+	}
+	b, err := newLoadDataBatcher(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := se.infileReader.requestLocalInfile(stmt.Path); err != nil {
+		return nil, err
+	}
+
+	// INSERT statements are planned and executed like the ones sent by client
+	reqCtx.Set(util.StmtType, b.stmtType)
+	defer reqCtx.Set(util.StmtType, parser.StmtLoadData)
+
+	implicit := !se.isInTransaction()
+	if implicit {
+		// transaction_mode single forbids transaction across slices, use multi for the implicit transaction
+		mode := se.transactionMode
+		if se.getTransactionMode() == models.TransactionModeSingle {
+			se.transactionMode = models.TransactionModeMulti
+		}
+		defer func() {
+			se.transactionMode = mode
+		}()
+		se.status |= mysql.ServerStatusInTrans
+	}
+
+	r := &mysql.Result{}
+	execute := func(sql string) error {
+		ret, err := se.doQuery(reqCtx, sql, nil)
+		if err == nil && ret != nil {
+			r.AffectedRows += ret.AffectedRows
+		}
+		return err
+	}
+	for {
+		data, e := se.infileReader.readLocalInfilePacket()
+		if e != nil {
+			// connection of client is broken
+			err = e
+			break
+		}
+		eof := len(data) == 0
+		if err == nil {
+			err = b.feed(data, eof, execute)
+		}
+		if eof {
+			break
+		}
+	}
+
+	if implicit {
+		if err != nil {
+			if e := se.rollback(); e != nil {
+				exeLogger.Warnf("rollback load data error, namespace: %s, err: %v", se.namespace, e)
+			}
+		} else {
+			err = se.commit()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Status = se.status
+	return r, nil
+}
+
+// loadDataBatcher convert rows of LOAD DATA file into multi-row INSERT statements
+type loadDataBatcher struct {
+	parser   *loadDataParser
+	stmtType parser.StatementType
+	prefix   string // INSERT [IGNORE] INTO tbl (columns) VALUES
+	columns  int    // count of columns, 0 if column list is not specified
+	ignore   uint64 // count of lines ignored at the start of file
+	line     uint64
+	sql      []byte
+	rows     int
+}
+
+func newLoadDataBatcher(stmt *ast.LoadDataStmt) (*loadDataBatcher, error) {
+	if len(stmt.ColumnAssignments) != 0 {
+		return nil, mysql.NewError(mysql.ErrNotSupportedYet, "SET clause of LOAD DATA is not supported by gaea")
+	}
+	p, err := newLoadDataParser(stmt.FieldsInfo, stmt.LinesInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &loadDataBatcher{parser: p, stmtType: parser.StmtInsert, ignore: stmt.IgnoreLines}
+	s := &strings.Builder{}
+	ctx := format.NewRestoreCtx(util.EscapeRestoreFlags, s)
+	switch stmt.OnDuplicate {
+	case ast.OnDuplicateKeyHandlingReplace:
+		b.stmtType = parser.StmtReplace
+		s.WriteString("REPLACE INTO ")
+	case ast.OnDuplicateKeyHandlingIgnore:
+		s.WriteString("INSERT IGNORE INTO ")
+	default:
+		s.WriteString("INSERT INTO ")
+	}
+	if err := stmt.Table.Restore(ctx); err != nil {
+		return nil, err
+	}
+	if len(stmt.ColumnsAndUserVars) != 0 {
+		s.WriteString(" (")
+		for i, c := range stmt.ColumnsAndUserVars {
+			if c.ColumnName == nil {
+				return nil, mysql.NewError(mysql.ErrNotSupportedYet, "user variable in column list of LOAD DATA is not supported by gaea")
+			}
+			if i != 0 {
+				s.WriteString(",")
+			}
+			if err := c.ColumnName.Restore(ctx); err != nil {
+				return nil, err
+			}
+		}
+		s.WriteString(")")
+		b.columns = len(stmt.ColumnsAndUserVars)
+	}
+	s.WriteString(" VALUES ")
+	b.prefix = s.String()
+	return b, nil
+}
+
+// feed parse data of file and execute INSERT statements of the rows, eof means all data has been fed
+func (b *loadDataBatcher) feed(data []byte, eof bool, execute func(sql string) error) error {
+	b.parser.feed(data)
+	for {
+		row, ok := b.parser.next(eof)
+		if !ok {
+			break
+		}
+		b.line++
+		if b.line <= b.ignore {
+			continue
+		}
+		if b.columns > 0 && len(row) < b.columns {
+			return mysql.NewDefaultError(mysql.ErrWarnTooFewRecords, b.line-b.ignore)
+		}
+		if b.columns > 0 && len(row) > b.columns {
+			return mysql.NewDefaultError(mysql.ErrWarnTooManyRecords, b.line-b.ignore)
+		}
+		b.appendRow(row)
+		if b.rows >= loadDataBatchRows || len(b.sql) >= loadDataBatchSize {
+			if err := b.flush(execute); err != nil {
+				return err
+			}
+		}
+	}
+	if eof && b.rows > 0 {
+		return b.flush(execute)
+	}
+	return nil
+}
+
+func (b *loadDataBatcher) appendRow(row []interface{}) {
+	if b.rows == 0 {
+		b.sql = append(b.sql[:0], b.prefix...)
+	} else {
+		b.sql = append(b.sql, ',')
+	}
+	b.sql = append(b.sql, '(')
+	for i, v := range row {
+		if i != 0 {
+			b.sql = append(b.sql, ',')
+		}
+		if v == nil {
+			b.sql = append(b.sql, "NULL"...)
+		} else {
+			b.sql = appendQuotedValue(b.sql, v.([]byte))
+		}
+	}
+	b.sql = append(b.sql, ')')
+	b.rows++
+}
+
+func (b *loadDataBatcher) flush(execute func(sql string) error) error {
+	b.rows = 0
+	return execute(string(b.sql))
+}
+
+// appendQuotedValue append value as string literal, bytes are escaped one by one, so multi-byte characters are kept
+func appendQuotedValue(buf []byte, v []byte) []byte {
+	buf = append(buf, '\'')
+	for _, c := range v {
+		switch c {
+		case 0:
+			buf = append(buf, '\\', '0')
+		case '\'', '\\':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case 26:
+			buf = append(buf, '\\', 'Z')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '\'')
+}
+
+// loadDataParser split content of LOAD DATA file into rows according to FIELDS and LINES clauses
+type loadDataParser struct {
+	fieldTerm []byte
+	enclosed  byte // 0 means fields are not enclosed
+	escaped   byte // 0 means there is no escape character
+	lineStart []byte
+	lineTerm  []byte
+	buf       []byte
+}
+
+func newLoadDataParser(fields *ast.FieldsClause, lines *ast.LinesClause) (*loadDataParser, error) {
+	p := &loadDataParser{fieldTerm: []byte("\t"), escaped: '\\', lineTerm: []byte("\n")}
+	if fields != nil {
+		p.fieldTerm, p.enclosed, p.escaped = []byte(fields.Terminated), fields.Enclosed, fields.Escaped
+	}
+	if lines != nil {
+		p.lineStart, p.lineTerm = []byte(lines.Starting), []byte(lines.Terminated)
+	}
+	if len(p.fieldTerm) == 0 || len(p.lineTerm) == 0 {
+		return nil, mysql.NewError(mysql.ErrNotSupportedYet, "empty FIELDS or LINES TERMINATED BY of LOAD DATA is not supported by gaea")
+	}
+	return p, nil
+}
+
+func (p *loadDataParser) feed(data []byte) {
+	p.buf = append(p.buf, data...)
+}
+
+// next return values of the next row, NULL is returned as nil and others as []byte.
+// ok is false if more data is required to complete the row, all data left is parsed if eof is true.
+func (p *loadDataParser) next(eof bool) (row []interface{}, ok bool) {
+	if len(p.buf) == 0 {
+		return nil, false
+	}
+	pos := 0
+	if len(p.lineStart) > 0 {
+		// characters before the prefix are skipped, including lines without the prefix
+		i := bytes.Index(p.buf, p.lineStart)
+		if i < 0 {
+			if keep := len(p.lineStart) - 1; !eof && len(p.buf) > keep {
+				p.buf = p.buf[len(p.buf)-keep:]
+			} else if eof {
+				p.buf = p.buf[:0]
+			}
+			return nil, false
+		}
+		pos = i + len(p.lineStart)
+	}
+
+	for {
+		value, next, lineEnd, complete := p.parseField(pos, eof)
+		if !complete {
+			return nil, false
+		}
+		row = append(row, value)
+		pos = next
+		if lineEnd {
+			break
+		}
+	}
+	p.buf = p.buf[pos:]
+	return row, true
+}
+
+// parseField parse field starting at pos, return its value, position after the terminator,
+// whether the line is ended and whether the field is complete.
+func (p *loadDataParser) parseField(pos int, eof bool) (interface{}, int, bool, bool) {
+	data := p.buf
+	start := pos
+	enclosed := p.enclosed != 0 && pos < len(data) && data[pos] == p.enclosed
+	if enclosed {
+		pos++
+	}
+
+	var value []byte
+	for i := pos; ; {
+		if i >= len(data) {
+			if !eof {
+				return nil, 0, false, false
+			}
+			return p.fieldValue(value, data[start:i], enclosed), i, true, true
+		}
+		c := data[i]
+		if p.escaped != 0 && c == p.escaped {
+			if i+1 >= len(data) {
+				if !eof {
+					return nil, 0, false, false
+				}
+				value = append(value, c)
+				i++
+				continue
+			}
+			value = append(value, unescapeLoadDataChar(data[i+1]))
+			i += 2
+			continue
+		}
+
+		end := i
+		if enclosed {
+			if c != p.enclosed {
+				value = append(value, c)
+				i++
+				continue
+			}
+			// doubled enclosing character is the character itself
+			if i+1 < len(data) && data[i+1] == p.enclosed {
+				value = append(value, c)
+				i += 2
+				continue
+			}
+			end = i + 1
+		}
+
+		// the field is ended by field or line terminator, or the end of file after enclosing character
+		if matched, more := matchPrefix(data, end, p.fieldTerm, eof); more {
+			return nil, 0, false, false
+		} else if matched {
+			return p.fieldValue(value, data[start:i], enclosed), end + len(p.fieldTerm), false, true
+		}
+		if matched, more := matchPrefix(data, end, p.lineTerm, eof); more {
+			return nil, 0, false, false
+		} else if matched {
+			return p.fieldValue(value, data[start:i], enclosed), end + len(p.lineTerm), true, true
+		}
+		if enclosed && end >= len(data) {
+			if !eof {
+				return nil, 0, false, false
+			}
+			return p.fieldValue(value, data[start:i], enclosed), end, true, true
+		}
+		value = append(value, c)
+		i++
+	}
+}
+
+// fieldValue return nil if the field is NULL: \N, or NULL without enclosing character if ENCLOSED BY is specified
+func (p *loadDataParser) fieldValue(value []byte, raw []byte, enclosed bool) interface{} {
+	if !enclosed {
+		if p.escaped != 0 && len(raw) == 2 && raw[0] == p.escaped && raw[1] == 'N' {
+			return nil
+		}
+		if p.enclosed != 0 && string(raw) == "NULL" {
+			return nil
+		}
+	}
+	if value == nil {
+		return []byte{}
+	}
+	return value
+}
+
+// matchPrefix check if data[pos:] starts with sep, more is true if data is not enough to decide
+func matchPrefix(data []byte, pos int, sep []byte, eof bool) (matched bool, more bool) {
+	rest := data[pos:]
+	if bytes.HasPrefix(rest, sep) {
+		return true, false
+	}
+	return false, !eof && len(rest) < len(sep) && bytes.HasPrefix(sep, rest)
+}
+
+func unescapeLoadDataChar(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'b':
+		return '\b'
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 26
+	default:
+		return c
+	}
+}
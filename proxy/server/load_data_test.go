@@ -0,0 +1,120 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+func parseLoadDataStmt(t *testing.T, sql string) *ast.LoadDataStmt {
+	n, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatalf("parse sql error: %v", err)
+	}
+	return n.(*ast.LoadDataStmt)
+}
+
+func TestLoadDataParser(t *testing.T) {
+	tests := []struct {
+		sql    string
+		data   string
+		expect [][]interface{}
+	}{
+		{
+			"load data local infile 'a' into table t",
+			"1\ta\\tb\n2\t\\N\n3\tc",
+			[][]interface{}{{"1", "a\tb"}, {"2", nil}, {"3", "c"}},
+		},
+		{
+			"load data local infile 'a' into table t fields terminated by ',' optionally enclosed by '\"' lines terminated by '\\r\\n'",
+			"1,\"a,\"\"b\"\"\r\nc\",NULL\r\n2,\"NULL\",\"\"\r\n",
+			[][]interface{}{{"1", "a,\"b\"\r\nc", nil}, {"2", "NULL", ""}},
+		},
+		{
+			"load data local infile 'a' into table t fields terminated by '||' escaped by '' lines starting by '>>'",
+			"skipped\n>>1||a\\b\nxx>>2||\n",
+			[][]interface{}{{"1", "a\\b"}, {"2", ""}},
+		},
+	}
+	for _, test := range tests {
+		stmt := parseLoadDataStmt(t, test.sql)
+		// feed one byte each time, rows spanning packets must be completed by the following data
+		p, err := newLoadDataParser(stmt.FieldsInfo, stmt.LinesInfo)
+		assert.Nil(t, err)
+		var rows [][]interface{}
+		for i := 0; i <= len(test.data); i++ {
+			eof := i == len(test.data)
+			if !eof {
+				p.feed([]byte{test.data[i]})
+			}
+			for {
+				row, ok := p.next(eof)
+				if !ok {
+					break
+				}
+				for j, v := range row {
+					if v != nil {
+						row[j] = string(v.([]byte))
+					}
+				}
+				rows = append(rows, row)
+			}
+		}
+		assert.Equal(t, test.expect, rows, test.sql)
+	}
+}
+
+func TestLoadDataBatcher(t *testing.T) {
+	stmt := parseLoadDataStmt(t, "load data local infile 'a' into table db.t ignore 1 lines (id, name)")
+	b, err := newLoadDataBatcher(stmt)
+	assert.Nil(t, err)
+
+	var sqls []string
+	execute := func(sql string) error {
+		sqls = append(sqls, sql)
+		return nil
+	}
+	data := "id\tname\n"
+	for i := 0; i < loadDataBatchRows+1; i++ {
+		data += fmt.Sprintf("%d\tn'%d\n", i, i)
+	}
+	assert.Nil(t, b.feed([]byte(data), false, execute))
+	assert.Equal(t, 1, len(sqls))
+	assert.Nil(t, b.feed(nil, true, execute))
+	assert.Equal(t, 2, len(sqls))
+	assert.Equal(t, "INSERT IGNORE INTO `db`.`t` (`id`,`name`) VALUES ('1000','n\\'1000')", sqls[1])
+
+	b, _ = newLoadDataBatcher(stmt)
+	assert.NotNil(t, b.feed([]byte("id\n1\n"), true, execute), "row without enough columns")
+
+	for _, sql := range []string{
+		"load data local infile 'a' into table t (id, @name)",
+		"load data local infile 'a' into table t (id) set name = 'a'",
+		"load data local infile 'a' into table t fields terminated by ''",
+	} {
+		_, err := newLoadDataBatcher(parseLoadDataStmt(t, sql))
+		assert.NotNil(t, err, sql)
+	}
+
+	b, _ = newLoadDataBatcher(parseLoadDataStmt(t, "load data local infile 'a' replace into table t"))
+	assert.Equal(t, parser.StmtReplace, b.stmtType)
+	assert.Equal(t, "REPLACE INTO `t` VALUES ", b.prefix)
+}
@@ -29,6 +29,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/XiaoMi/Gaea/audit"
+	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/core/errors"
 	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/mysql"
@@ -135,6 +137,10 @@ type Manager struct {
 	namespaces     [2]*NamespaceManager
 	users          [2]*UserManager
 	statistics     *StatisticManager
+	clusterState   *ClusterState
+	xaCoordinator  *XACoordinator
+	auditLogger    *audit.Logger // nil if audit log is disabled
+	shadow         *shadowReplayer
 }
 
 // NewManager return empty Manager
@@ -156,6 +162,12 @@ func CreateManager(cfg *models.Proxy, namespaceConfigs map[string]*models.Namesp
 
 	current, _, _ := m.switchIndex.Get()
 
+	// global sequences are created with namespaces
+	if err := initSequence(cfg); err != nil {
+		log.Warnf("init sequence failed, %v", err)
+		return nil, err
+	}
+
 	// init namespace
 	m.namespaces[current] = CreateNamespaceManager(namespaceConfigs)
 
@@ -166,6 +178,35 @@ func CreateManager(cfg *models.Proxy, namespaceConfigs map[string]*models.Namesp
 	}
 	m.users[current] = user
 
+	// init cluster state
+	clusterState, err := createClusterState(cfg)
+	if err != nil {
+		log.Warnf("init cluster state failed, %v", err)
+		return nil, err
+	}
+	m.clusterState = clusterState
+	m.clusterState.Start()
+
+	// XA transactions left by last crash must be recovered before serving
+	xaCoordinator, err := createXACoordinator(cfg)
+	if err != nil {
+		log.Warnf("init xa coordinator failed, %v", err)
+		return nil, err
+	}
+	m.xaCoordinator = xaCoordinator
+	m.xaCoordinator.Recover(m.namespaces[current].namespaces)
+	m.xaCoordinator.startRetry(m.getMasterConn)
+
+	// init audit log
+	auditLogger, err := createAuditLogger(cfg)
+	if err != nil {
+		log.Warnf("init audit logger failed, %v", err)
+		return nil, err
+	}
+	m.auditLogger = auditLogger
+
+	m.shadow = newShadowReplayer(m)
+
 	m.startConnectPoolMetricsTask(cfg.StatsInterval)
 	return m, nil
 }
@@ -180,6 +221,41 @@ func (m *Manager) Close() {
 	}
 
 	m.statistics.Close()
+	if m.clusterState != nil {
+		m.clusterState.Close()
+	}
+	if m.xaCoordinator != nil {
+		m.xaCoordinator.Close()
+	}
+	if m.auditLogger != nil {
+		m.auditLogger.Close()
+	}
+	if m.shadow != nil {
+		m.shadow.close()
+	}
+}
+
+// GetXACoordinator return coordinator of XA transactions
+func (m *Manager) GetXACoordinator() *XACoordinator {
+	return m.xaCoordinator
+}
+
+// getMasterConn return master connection of slice in running namespace
+func (m *Manager) getMasterConn(namespace, slice string) (backend.PooledConnect, error) {
+	ns := m.GetNamespace(namespace)
+	if ns == nil {
+		return nil, fmt.Errorf("namespace %s not found", namespace)
+	}
+	s := ns.GetSlice(slice)
+	if s == nil {
+		return nil, fmt.Errorf("slice %s not found in namespace %s", slice, namespace)
+	}
+	return s.GetMasterConn()
+}
+
+// GetClusterState return cluster state
+func (m *Manager) GetClusterState() *ClusterState {
+	return m.clusterState
 }
 
 // ReloadNamespacePrepare prepare commit
@@ -194,6 +270,10 @@ func (m *Manager) ReloadNamespacePrepare(namespaceConfig *models.Namespace) erro
 		log.Warnf("prepare source of namespace: %s failed, err: %v", name, err)
 		return err
 	}
+	// column definitions are loaded before commit, so that statements are not planned without them after switching
+	if !newNamespaceManager.GetNamespace(name).schemaTracker.waitLoaded(schemaLoadTimeout) {
+		log.Warnf("load schema of namespace %s timeout when preparing, continue without it", name)
+	}
 	m.namespaces[other] = newNamespaceManager
 
 	// reload user prepare
@@ -263,6 +343,16 @@ func (m *Manager) GetNamespace(name string) *Namespace {
 	return m.namespaces[current].GetNamespace(name)
 }
 
+// GetNamespaceNames return names of all running namespaces
+func (m *Manager) GetNamespaceNames() []string {
+	current, _, _ := m.switchIndex.Get()
+	var names []string
+	for name := range m.namespaces[current].GetNamespaces() {
+		names = append(names, name)
+	}
+	return names
+}
+
 // CheckUser check if user in users
 func (m *Manager) CheckUser(user string) bool {
 	current, _, _ := m.switchIndex.Get()
@@ -292,9 +382,37 @@ func (m *Manager) ConfigFingerprint() string {
 	return m.namespaces[current].ConfigFingerprint()
 }
 
+// CheckHealth check backends of all running namespaces, proxy is ready if no master is unreachable
+func (m *Manager) CheckHealth(timeout time.Duration, maxLag int64) *models.ProxyHealth {
+	current, _, _ := m.switchIndex.Get()
+	namespaces := m.namespaces[current].GetNamespaces()
+	h := &models.ProxyHealth{
+		Ready:             true,
+		ConfigFingerprint: m.namespaces[current].ConfigFingerprint(),
+		CheckTime:         time.Now().Unix(),
+		Namespaces:        make(map[string]*models.NamespaceHealth, len(namespaces)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, namespace := range namespaces {
+		wg.Add(1)
+		go func(name string, namespace *Namespace) {
+			defer wg.Done()
+			nh := namespace.CheckHealth(timeout, maxLag)
+			mu.Lock()
+			defer mu.Unlock()
+			h.Namespaces[name] = nh
+			h.Ready = h.Ready && nh.Ready
+		}(name, namespace)
+	}
+	wg.Wait()
+	return h
+}
+
 // RecordSessionSQLMetrics record session SQL metrics, like response time, error
 func (m *Manager) RecordSessionSQLMetrics(reqCtx *util.RequestContext, se *SessionExecutor, sql string, startTime time.Time, err error) {
 	trimmedSql := strings.ReplaceAll(sql, "\n", " ")
+	traceID := util.GetTraceID(reqCtx)
 	namespace := se.namespace
 	ns := m.GetNamespace(namespace)
 	if ns == nil {
@@ -302,45 +420,54 @@ func (m *Manager) RecordSessionSQLMetrics(reqCtx *util.RequestContext, se *Sessi
 		return
 	}
 
+	fingerprint := mysql.GetFingerprint(sql)
+	hash := mysql.GetMd5(fingerprint)
+
 	var operation string
 	if stmtType, ok := reqCtx.Get(util.StmtType).(parser.StatementType); ok {
 		operation = stmtType.String()
 	} else {
-		fingerprint := mysql.GetFingerprint(sql)
 		operation = mysql.GetFingerprintOperation(fingerprint)
 	}
 
 	// record parser timing
 	m.statistics.recordSessionSQLTiming(namespace, operation, startTime)
 
+	// record latency, rows and shards of fingerprint
+	rows := getRowCountFromContext(reqCtx)
+	shards := getShardCount(reqCtx)
+	ns.RecordSQLStats(hash, fingerprint, time.Since(startTime), rows, shards, err)
+	m.statistics.recordSessionSQLFingerprintStats(namespace, hash, startTime, rows, shards)
+
 	// record slow parser
 	duration := time.Since(startTime).Nanoseconds() / int64(time.Millisecond)
 	if duration > ns.getSessionSlowSQLTime() || ns.getSessionSlowSQLTime() == 0 {
-		logging.DefaultLogger.Warnf("session slow SQL, namespace: %s, parser: %s, cost: %d ms", namespace, trimmedSql, duration)
-		fingerprint := mysql.GetFingerprint(sql)
-		hash := mysql.GetMd5(fingerprint)
+		logging.DefaultLogger.Warnw("session slow SQL",
+			logging.FieldConnID, se.connID, logging.FieldNamespace, namespace, logging.FieldUser, se.user,
+			logging.FieldTraceID, traceID, logging.FieldFingerprint, hash, logging.FieldSQL, trimmedSql, logging.FieldCost, duration)
 		ns.SetSlowSQLFingerprint(hash, fingerprint)
 		m.statistics.recordSessionSlowSQLFingerprint(namespace, hash)
 	}
 
 	// record error parser
 	if err != nil {
-		logging.DefaultLogger.Warnf("session error SQL, namespace: %s, parser: %s, cost: %d ms, err: %v", namespace, trimmedSql, duration, err)
-		fingerprint := mysql.GetFingerprint(sql)
-		hash := mysql.GetMd5(fingerprint)
+		logging.DefaultLogger.Warnw("session error SQL",
+			logging.FieldConnID, se.connID, logging.FieldNamespace, namespace, logging.FieldUser, se.user,
+			logging.FieldTraceID, traceID, logging.FieldFingerprint, hash, logging.FieldSQL, trimmedSql, logging.FieldCost, duration, "err", err)
 		ns.SetErrorSQLFingerprint(hash, fingerprint)
 		m.statistics.recordSessionErrorSQLFingerprint(namespace, operation, hash)
 	}
 
 	if OpenProcessGeneralQueryLog() && ns.openGeneralLog {
-		m.statistics.generalLogger.Infof("client: %s, namespace: %s, db: %s, user: %s, cmd: %s, parser: %s, cost: %d ms, succ: %t",
-			se.clientAddr, namespace, se.db, se.user, operation, trimmedSql, duration, err == nil)
+		m.statistics.generalLogger.Infof("client: %s, namespace: %s, db: %s, user: %s, trace_id: %s, cmd: %s, parser: %s, cost: %d ms, succ: %t",
+			se.clientAddr, namespace, se.db, se.user, traceID, operation, trimmedSql, duration, err == nil)
 	}
 }
 
 // RecordBackendSQLMetrics record backend SQL metrics, like response time, error
-func (m *Manager) RecordBackendSQLMetrics(reqCtx *util.RequestContext, namespace string, sql, backendAddr string, startTime time.Time, err error) {
+func (m *Manager) RecordBackendSQLMetrics(reqCtx *util.RequestContext, namespace, slice, sql, backendAddr string, startTime time.Time, err error) {
 	trimmedSql := strings.ReplaceAll(sql, "\n", " ")
+	traceID := util.GetTraceID(reqCtx)
 	ns := m.GetNamespace(namespace)
 	if ns == nil {
 		logging.DefaultLogger.Warnf("record backend SQL metrics error, namespace: %s, backend addr: %s, parser: %s, err: %s", namespace, backendAddr, trimmedSql, "namespace not found")
@@ -361,18 +488,24 @@ func (m *Manager) RecordBackendSQLMetrics(reqCtx *util.RequestContext, namespace
 	// record slow parser
 	duration := time.Since(startTime).Nanoseconds() / int64(time.Millisecond)
 	if m.statistics.isBackendSlowSQL(startTime) {
-		logging.DefaultLogger.Warnf("backend slow SQL, namespace: %s, addr: %s, parser: %s, cost: %d ms", namespace, backendAddr, trimmedSql, duration)
 		fingerprint := mysql.GetFingerprint(sql)
 		hash := mysql.GetMd5(fingerprint)
+		logging.DefaultLogger.Warnw("backend slow SQL",
+			logging.FieldConnID, reqCtx.Get(util.ConnectionID), logging.FieldNamespace, namespace, logging.FieldShard, slice,
+			logging.FieldBackendAddr, backendAddr, logging.FieldTraceID, traceID, logging.FieldFingerprint, hash,
+			logging.FieldSQL, trimmedSql, logging.FieldCost, duration)
 		ns.SetBackendSlowSQLFingerprint(hash, fingerprint)
 		m.statistics.recordBackendSlowSQLFingerprint(namespace, hash)
 	}
 
 	// record error parser
 	if err != nil {
-		logging.DefaultLogger.Warnf("backend error SQL, namespace: %s, addr: %s, parser: %s, cost %d ms, err: %v", namespace, backendAddr, trimmedSql, duration, err)
 		fingerprint := mysql.GetFingerprint(sql)
 		hash := mysql.GetMd5(fingerprint)
+		logging.DefaultLogger.Warnw("backend error SQL",
+			logging.FieldConnID, reqCtx.Get(util.ConnectionID), logging.FieldNamespace, namespace, logging.FieldShard, slice,
+			logging.FieldBackendAddr, backendAddr, logging.FieldTraceID, traceID, logging.FieldFingerprint, hash,
+			logging.FieldSQL, trimmedSql, logging.FieldCost, duration, "err", err)
 		ns.SetBackendErrorSQLFingerprint(hash, fingerprint)
 		m.statistics.recordBackendErrorSQLFingerprint(namespace, operation, hash)
 	}
@@ -407,18 +540,12 @@ func (m *Manager) recordBackendConnectPoolMetrics(namespace string) {
 	}
 
 	for sliceName, slice := range ns.slices {
-		m.statistics.recordConnectPoolInuseCount(namespace, sliceName, slice.Master.Addr(), slice.Master.InUse())
-		m.statistics.recordConnectPoolIdleCount(namespace, sliceName, slice.Master.Addr(), slice.Master.Available())
-		m.statistics.recordConnectPoolWaitCount(namespace, sliceName, slice.Master.Addr(), slice.Master.WaitCount())
+		m.statistics.recordConnectPool(namespace, sliceName, slice.Master)
 		for _, slave := range slice.Slave {
-			m.statistics.recordConnectPoolInuseCount(namespace, sliceName, slave.Addr(), slave.InUse())
-			m.statistics.recordConnectPoolIdleCount(namespace, sliceName, slave.Addr(), slave.Available())
-			m.statistics.recordConnectPoolWaitCount(namespace, sliceName, slave.Addr(), slave.WaitCount())
+			m.statistics.recordConnectPool(namespace, sliceName, slave)
 		}
 		for _, statisticSlave := range slice.StatisticSlave {
-			m.statistics.recordConnectPoolInuseCount(namespace, sliceName, statisticSlave.Addr(), statisticSlave.InUse())
-			m.statistics.recordConnectPoolIdleCount(namespace, sliceName, statisticSlave.Addr(), statisticSlave.Available())
-			m.statistics.recordConnectPoolWaitCount(namespace, sliceName, statisticSlave.Addr(), statisticSlave.WaitCount())
+			m.statistics.recordConnectPool(namespace, sliceName, statisticSlave)
 		}
 	}
 }
@@ -618,6 +745,7 @@ const (
 	statsLabelFlowDirection = "Flowdirection"
 	statsLabelSlice         = "Slice"
 	statsLabelIPAddr        = "IPAddr"
+	statsLabelTable         = "Table"
 )
 
 // StatisticManager statistics manager
@@ -636,6 +764,14 @@ type StatisticManager struct {
 	sqlForbidenCounts         *stats.CountersWithMultiLabels // SQL黑名单请求统计
 	flowCounts                *stats.CountersWithMultiLabels // 业务流量统计
 	sessionCounts             *stats.GaugesWithMultiLabels   // 前端会话数统计
+	sqlFingerprintTimings     *stats.MultiTimings            // SQL指纹耗时统计
+	sqlFingerprintRowCounts   *stats.CountersWithMultiLabels // SQL指纹返回或影响行数统计
+	sqlFingerprintShardCounts *stats.CountersWithMultiLabels // SQL指纹下发分片数统计
+	dualWriteMismatchCounts   *stats.CountersWithMultiLabels // 双写迁移表两次写入不一致次数统计
+	shadowQueryCounts         *stats.CountersWithMultiLabels // 影子namespace重放查询的比较结果统计
+	fullScatterCounts         *stats.CountersWithMultiLabels // 没有分片列条件, 下发到所有子表的语句数统计
+	resultCacheCounts         *stats.CountersWithMultiLabels // 结果集缓存命中和未命中的查询数统计
+	shadowSQLTimings          *stats.MultiTimings            // 重放查询在原namespace和影子namespace的耗时统计
 
 	backendSQLTimings                *stats.MultiTimings            // 后端SQL耗时统计
 	backendSQLFingerprintSlowCounts  *stats.CountersWithMultiLabels // 后端慢SQL指纹数量统计
@@ -644,6 +780,8 @@ type StatisticManager struct {
 	backendConnectPoolIdleCounts     *stats.GaugesWithMultiLabels   //后端空闲连接数统计
 	backendConnectPoolInUseCounts    *stats.GaugesWithMultiLabels   //后端正在使用连接数统计
 	backendConnectPoolWaitCounts     *stats.GaugesWithMultiLabels   //后端等待队列统计
+	backendConnectPoolActiveCounts   *stats.GaugesWithMultiLabels   //后端已建立连接数统计
+	backendConnectPoolHealthCounts   *stats.GaugesWithMultiLabels   //后端连接因超过最大存活时间或ping失败而重连的次数统计
 
 	slowSQLTime int64
 	closeChan   chan bool
@@ -714,6 +852,22 @@ func (s *StatisticManager) Init(cfg *models.Proxy) error {
 		"gaea proxy flow counts", []string{statsLabelCluster, statsLabelNamespace, statsLabelFlowDirection})
 	s.sessionCounts = stats.NewGaugesWithMultiLabels("SessionCounts",
 		"gaea proxy session counts", []string{statsLabelCluster, statsLabelNamespace})
+	s.sqlFingerprintTimings = stats.NewMultiTimings("SqlFingerprintTimings",
+		"gaea proxy parser fingerprint sqlTimings", []string{statsLabelCluster, statsLabelNamespace, statsLabelFingerprint})
+	s.sqlFingerprintRowCounts = stats.NewCountersWithMultiLabels("SqlFingerprintRowCounts",
+		"gaea proxy parser fingerprint row counts", []string{statsLabelCluster, statsLabelNamespace, statsLabelFingerprint})
+	s.sqlFingerprintShardCounts = stats.NewCountersWithMultiLabels("SqlFingerprintShardCounts",
+		"gaea proxy parser fingerprint shard counts", []string{statsLabelCluster, statsLabelNamespace, statsLabelFingerprint})
+	s.dualWriteMismatchCounts = stats.NewCountersWithMultiLabels("DualWriteMismatchCounts",
+		"gaea proxy dual write mismatch counts per reason", []string{statsLabelCluster, statsLabelNamespace, statsLabelTable, statsLabelOperation})
+	s.shadowQueryCounts = stats.NewCountersWithMultiLabels("ShadowQueryCounts",
+		"gaea proxy shadow query counts per result", []string{statsLabelCluster, statsLabelNamespace, statsLabelOperation})
+	s.fullScatterCounts = stats.NewCountersWithMultiLabels("FullScatterCounts",
+		"gaea proxy full scatter statement counts per policy", []string{statsLabelCluster, statsLabelNamespace, statsLabelOperation})
+	s.resultCacheCounts = stats.NewCountersWithMultiLabels("ResultCacheCounts",
+		"gaea proxy result cache counts per result", []string{statsLabelCluster, statsLabelNamespace, statsLabelOperation})
+	s.shadowSQLTimings = stats.NewMultiTimings("ShadowSqlTimings",
+		"gaea proxy shadow query sqlTimings in namespace and shadow namespace", []string{statsLabelCluster, statsLabelNamespace, statsLabelOperation})
 
 	s.backendSQLTimings = stats.NewMultiTimings("BackendSqlTimings",
 		"gaea proxy backend parser sqlTimings", []string{statsLabelCluster, statsLabelNamespace, statsLabelOperation})
@@ -729,6 +883,10 @@ func (s *StatisticManager) Init(cfg *models.Proxy) error {
 		"gaea proxy backend in-use connect counts", []string{statsLabelCluster, statsLabelNamespace, statsLabelSlice, statsLabelIPAddr})
 	s.backendConnectPoolWaitCounts = stats.NewGaugesWithMultiLabels("backendConnectPoolWaitCounts",
 		"gaea proxy backend wait connect counts", []string{statsLabelCluster, statsLabelNamespace, statsLabelSlice, statsLabelIPAddr})
+	s.backendConnectPoolActiveCounts = stats.NewGaugesWithMultiLabels("backendConnectPoolActiveCounts",
+		"gaea proxy backend active connect counts", []string{statsLabelCluster, statsLabelNamespace, statsLabelSlice, statsLabelIPAddr})
+	s.backendConnectPoolHealthCounts = stats.NewGaugesWithMultiLabels("backendConnectPoolHealthCounts",
+		"gaea proxy backend reconnect counts of health check", []string{statsLabelCluster, statsLabelNamespace, statsLabelSlice, statsLabelIPAddr, statsLabelOperation})
 
 	s.startClearTask()
 	return nil
@@ -769,6 +927,9 @@ func (s *StatisticManager) clearLargeCounters() {
 	s.sqlErrorCounts.ResetAll()
 	s.sqlFingerprintSlowCounts.ResetAll()
 	s.sqlFingerprintErrorCounts.ResetAll()
+	s.sqlFingerprintTimings.ResetAll()
+	s.sqlFingerprintRowCounts.ResetAll()
+	s.sqlFingerprintShardCounts.ResetAll()
 
 	s.backendSQLErrorCounts.ResetAll()
 	s.backendSQLFingerprintSlowCounts.ResetAll()
@@ -792,6 +953,13 @@ func (s *StatisticManager) recordSessionSQLTiming(namespace string, operation st
 	s.sqlTimings.Record(operationStatsKey, startTime)
 }
 
+func (s *StatisticManager) recordSessionSQLFingerprintStats(namespace string, md5 string, startTime time.Time, rows int64, shards int) {
+	fingerprintStatsKey := []string{s.clusterName, namespace, md5}
+	s.sqlFingerprintTimings.Record(fingerprintStatsKey, startTime)
+	s.sqlFingerprintRowCounts.Add(fingerprintStatsKey, rows)
+	s.sqlFingerprintShardCounts.Add(fingerprintStatsKey, int64(shards))
+}
+
 // millisecond duration
 func (s *StatisticManager) isBackendSlowSQL(startTime time.Time) bool {
 	duration := time.Since(startTime).Nanoseconds() / int64(time.Millisecond)
@@ -821,6 +989,30 @@ func (s *StatisticManager) RecordSQLForbidden(fingerprint, namespace string) {
 	s.sqlForbidenCounts.Add([]string{s.clusterName, namespace, hash}, 1)
 }
 
+// RecordDualWriteMismatch record mismatch of dual write table, table is db.table
+func (s *StatisticManager) RecordDualWriteMismatch(namespace, table, reason string) {
+	s.dualWriteMismatchCounts.Add([]string{s.clusterName, namespace, table, reason}, 1)
+}
+
+// RecordFullScatter record statement routed to all sub tables without condition of sharding column
+func (s *StatisticManager) RecordFullScatter(namespace, policy string) {
+	s.fullScatterCounts.Add([]string{s.clusterName, namespace, policy}, 1)
+}
+
+// RecordResultCache record hit or miss of result cache
+func (s *StatisticManager) RecordResultCache(namespace, result string) {
+	s.resultCacheCounts.Add([]string{s.clusterName, namespace, result}, 1)
+}
+
+// RecordShadowQuery record result of comparing query replayed in shadow namespace
+func (s *StatisticManager) RecordShadowQuery(namespace, result string) {
+	s.shadowQueryCounts.Add([]string{s.clusterName, namespace, result}, 1)
+}
+
+func (s *StatisticManager) recordShadowSQLTiming(namespace, operation string, latency time.Duration) {
+	s.shadowSQLTimings.Add([]string{s.clusterName, namespace, operation}, latency)
+}
+
 // IncrSessionCount incr session count
 func (s *StatisticManager) IncrSessionCount(namespace string) {
 	statsKey := []string{s.clusterName, namespace}
@@ -862,3 +1054,26 @@ func (s *StatisticManager) recordConnectPoolWaitCount(namespace string, slice st
 	statsKey := []string{s.clusterName, namespace, slice, addr}
 	s.backendConnectPoolWaitCounts.Set(statsKey, count)
 }
+
+// record active connect count
+func (s *StatisticManager) recordConnectPoolActiveCount(namespace string, slice string, addr string, count int64) {
+	statsKey := []string{s.clusterName, namespace, slice, addr}
+	s.backendConnectPoolActiveCounts.Set(statsKey, count)
+}
+
+// record reconnect count of health check, reason is max_lifetime or ping
+func (s *StatisticManager) recordConnectPoolHealthCount(namespace string, slice string, addr string, reason string, count int64) {
+	statsKey := []string{s.clusterName, namespace, slice, addr, reason}
+	s.backendConnectPoolHealthCounts.Set(statsKey, count)
+}
+
+// recordConnectPool record all stats of connection pool
+func (s *StatisticManager) recordConnectPool(namespace string, slice string, cp backend.ConnectionPool) {
+	addr := cp.Addr()
+	s.recordConnectPoolInuseCount(namespace, slice, addr, cp.InUse())
+	s.recordConnectPoolIdleCount(namespace, slice, addr, cp.Available())
+	s.recordConnectPoolWaitCount(namespace, slice, addr, cp.WaitCount())
+	s.recordConnectPoolActiveCount(namespace, slice, addr, cp.Active())
+	s.recordConnectPoolHealthCount(namespace, slice, addr, "max_lifetime", cp.LifetimeClosed())
+	s.recordConnectPoolHealthCount(namespace, slice, addr, "ping", cp.PingFailed())
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/tracing"
+)
+
+// Queries of COM_QUERY and COM_STMT_EXECUTE are executed by a chain of middlewares:
+//
+//	firewall -> trace -> custom middlewares -> result_cache -> execute
+//
+// firewall rejects statements in black sql and over rate limit, trace sets trace id, deadline and memory limit
+// of the query and records metrics, audit log and shadow query after it's executed, and execute builds plan,
+// rewrites and routes statements, executes them in backend and merges results. Custom middlewares compiled into
+// proxy are registered by RegisterQueryMiddleware, and enabled by query_middlewares of namespace in order.
+
+// QueryHandler execute query and return result
+type QueryHandler func(qc *QueryContext) (*mysql.Result, error)
+
+// QueryMiddleware wrap the next handler in chain, a middleware may return without calling next,
+// e.g. to reject the query
+type QueryMiddleware func(next QueryHandler) QueryHandler
+
+var (
+	middlewaresLock  sync.RWMutex
+	queryMiddlewares = make(map[string]QueryMiddleware)
+)
+
+// RegisterQueryMiddleware register middleware with name, middlewares of the same name are replaced.
+// It should be called before proxy is started, e.g. in init function of the package of middleware.
+func RegisterQueryMiddleware(name string, m QueryMiddleware) {
+	middlewaresLock.Lock()
+	defer middlewaresLock.Unlock()
+	queryMiddlewares[name] = m
+}
+
+// GetQueryMiddlewares return sorted names of registered middlewares
+func GetQueryMiddlewares() []string {
+	middlewaresLock.RLock()
+	defer middlewaresLock.RUnlock()
+	names := make([]string, 0, len(queryMiddlewares))
+	for name := range queryMiddlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// QueryContext query passed through middlewares, it's only valid during the query is executed
+type QueryContext struct {
+	se        *SessionExecutor
+	reqCtx    *util.RequestContext
+	sql       string
+	stmtType  parser.StatementType
+	plan      plan.Plan
+	startTime time.Time
+}
+
+// Namespace return namespace of session
+func (qc *QueryContext) Namespace() string {
+	return qc.se.namespace
+}
+
+// User return user of session
+func (qc *QueryContext) User() string {
+	return qc.se.user
+}
+
+// ClientAddr return address of client
+func (qc *QueryContext) ClientAddr() string {
+	return qc.se.clientAddr
+}
+
+// ConnID return connection id of session
+func (qc *QueryContext) ConnID() uint32 {
+	return qc.se.connID
+}
+
+// DB return current database of session
+func (qc *QueryContext) DB() string {
+	return qc.se.db
+}
+
+// InTransaction return true if the query is executed in transaction
+func (qc *QueryContext) InTransaction() bool {
+	return qc.se.isInTransaction()
+}
+
+// SQL return sql of query, parameters of prepared statement are bound
+func (qc *QueryContext) SQL() string {
+	return qc.sql
+}
+
+// StmtType return type of statement
+func (qc *QueryContext) StmtType() parser.StatementType {
+	return qc.stmtType
+}
+
+// StartTime return time the query is received
+func (qc *QueryContext) StartTime() time.Time {
+	return qc.startTime
+}
+
+// TraceID return trace id of query, it's empty before trace middleware
+func (qc *QueryContext) TraceID() string {
+	return util.GetTraceID(qc.reqCtx)
+}
+
+// Shards return shards the statement is routed to, it's only set after the query is executed
+func (qc *QueryContext) Shards() []string {
+	return getShards(qc.reqCtx)
+}
+
+// buildQueryHandler return chain of builtin middlewares and custom middlewares of names
+func buildQueryHandler(names []string) (QueryHandler, error) {
+	custom := make([]QueryMiddleware, 0, len(names))
+	middlewaresLock.RLock()
+	for _, name := range names {
+		m, ok := queryMiddlewares[name]
+		if !ok {
+			middlewaresLock.RUnlock()
+			return nil, fmt.Errorf("query middleware %s not found, registered: %v", name, GetQueryMiddlewares())
+		}
+		custom = append(custom, m)
+	}
+	middlewaresLock.RUnlock()
+
+	chain := []QueryMiddleware{firewallMiddleware, traceMiddleware}
+	chain = append(chain, custom...)
+	chain = append(chain, resultCacheMiddleware)
+
+	h := executeQuery
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+	return h, nil
+}
+
+// firewallMiddleware reject statements in black sql of namespace and over rate limit
+func firewallMiddleware(next QueryHandler) QueryHandler {
+	return func(qc *QueryContext) (*mysql.Result, error) {
+		se := qc.se
+		ns := se.GetNamespace()
+		if !ns.IsSQLAllowed(qc.reqCtx, qc.sql) {
+			fingerprint := mysql.GetFingerprint(qc.sql)
+			exeLogger.Warnf("catch black parser, parser: %s", qc.sql)
+			se.manager.GetStatisticManager().RecordSQLForbidden(fingerprint, ns.GetName())
+			return nil, mysql.NewError(mysql.ErrUnknown, "parser in blacklist")
+		}
+		// rate limit of pipelined statement has been checked before it's executed
+		if _, ok := qc.plan.(*pipelinedPlan); !ok {
+			if err := se.checkStmtRateLimit(qc.stmtType); err != nil {
+				return nil, err
+			}
+		}
+		return next(qc)
+	}
+}
+
+// traceMiddleware set trace id, deadline and memory limit of query, and record metrics, audit log
+// and shadow query after it's executed
+func traceMiddleware(next QueryHandler) QueryHandler {
+	return func(qc *QueryContext) (*mysql.Result, error) {
+		se, reqCtx, sql := qc.se, qc.reqCtx, qc.sql
+
+		// 优先使用客户端在注释中指定的trace_id, 其次使用采样的根span的trace_id, 否则生成新的trace_id
+		span := se.startQuerySpan(reqCtx, sql, qc.stmtType)
+		traceID := parser.ExtractTraceID(sql)
+		if traceID == "" {
+			traceID = tracing.GetTraceID(span)
+		}
+		if traceID == "" {
+			traceID = util.NewTraceID()
+		}
+		reqCtx.Set(util.TraceID, traceID)
+
+		if timeout := se.getMaxExecutionTime(sql, qc.stmtType); timeout > 0 {
+			reqCtx.Set(util.Deadline, qc.startTime.Add(time.Duration(timeout)*time.Millisecond))
+		}
+
+		if limit := se.GetNamespace().getMaxQueryMemory(); limit > 0 {
+			reqCtx.Set(util.QueryMemoryTracker, util.NewMemoryTracker(limit))
+		}
+
+		r, err := next(qc)
+		se.trackTransactionStatement(qc.stmtType, err)
+		// row count of streamed resultset is set during streaming
+		if !se.streamed {
+			reqCtx.Set(util.RowCount, getRowCount(r))
+		}
+		se.recordRowCount(reqCtx, r, err)
+		se.manager.RecordSessionSQLMetrics(reqCtx, se, sql, qc.startTime, err)
+		se.recordQueryAudit(reqCtx, sql, qc.startTime, err)
+		se.mirrorShadowQuery(reqCtx, sql, qc.startTime, r, err)
+		endQuerySpan(reqCtx, span, err)
+		return r, err
+	}
+}
+
+// resultCacheMiddleware return cached result of select and invalidate cached results of tables written
+func resultCacheMiddleware(next QueryHandler) QueryHandler {
+	return func(qc *QueryContext) (*mysql.Result, error) {
+		return qc.se.doQueryWithResultCache(qc, next)
+	}
+}
+
+// executeQuery is the last handler of chain, which builds plan and executes it
+func executeQuery(qc *QueryContext) (*mysql.Result, error) {
+	return qc.se.doQuery(qc.reqCtx, qc.sql, qc.plan)
+}
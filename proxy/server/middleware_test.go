@@ -0,0 +1,71 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+func TestQueryMiddlewares(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+
+	var recorded []string
+	RegisterQueryMiddleware("test_record", func(next QueryHandler) QueryHandler {
+		return func(qc *QueryContext) (*mysql.Result, error) {
+			assert.NotEmpty(t, qc.TraceID())
+			assert.Equal(t, "test_executor", qc.User())
+			recorded = append(recorded, "record: "+qc.SQL())
+			return next(qc)
+		}
+	})
+	RegisterQueryMiddleware("test_reject", func(next QueryHandler) QueryHandler {
+		return func(qc *QueryContext) (*mysql.Result, error) {
+			recorded = append(recorded, "reject: "+qc.SQL())
+			if isWriteStmt(qc.StmtType()) {
+				return nil, mysql.NewError(mysql.ErrUnknown, "write is rejected")
+			}
+			return &mysql.Result{Resultset: &mysql.Resultset{}}, nil
+		}
+	})
+	assert.Contains(t, GetQueryMiddlewares(), "test_record")
+
+	_, err = buildQueryHandler([]string{"test_not_exist"})
+	assert.NotNil(t, err)
+
+	ns := se.GetNamespace()
+	handler := ns.queryHandler
+	defer func() { ns.queryHandler = handler }()
+	ns.queryHandler, err = buildQueryHandler([]string{"test_record", "test_reject"})
+	assert.Nil(t, err)
+
+	_, err = se.handleQuery("delete from tbl_ks where id = 1;")
+	assert.EqualError(t, err, "ERROR 1105 (HY000): write is rejected")
+	r, err := se.handleQuery("select * from tbl_ks where id = 1")
+	assert.Nil(t, err)
+	assert.NotNil(t, r)
+	assert.Equal(t, []string{
+		"record: delete from tbl_ks where id = 1",
+		"reject: delete from tbl_ks where id = 1",
+		"record: select * from tbl_ks where id = 1",
+		"reject: select * from tbl_ks where id = 1",
+	}, recorded)
+}
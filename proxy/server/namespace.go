@@ -20,20 +20,27 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/XiaoMi/Gaea/backend"
 	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/encrypt"
+	"github.com/XiaoMi/Gaea/proxy/mask"
 	"github.com/XiaoMi/Gaea/proxy/plan"
 	"github.com/XiaoMi/Gaea/proxy/router"
 	"github.com/XiaoMi/Gaea/proxy/sequence"
+	"github.com/XiaoMi/Gaea/proxy/tenant"
 	"github.com/XiaoMi/Gaea/util"
 	"github.com/XiaoMi/Gaea/util/cache"
+	"github.com/XiaoMi/Gaea/util/sync2"
 )
 
 const (
 	namespaceDelayClose = 60
+	// max seconds to wait for sessions running on the old namespace after reloading
+	namespaceMaxDelayClose = 600
 )
 
 const (
@@ -45,9 +52,14 @@ const (
 
 // UserProperty means runtime user properties
 type UserProperty struct {
-	RWFlag        int
-	RWSplit       int
-	OtherProperty int
+	RWFlag         int
+	RWSplit        int
+	OtherProperty  int
+	MaxConnections int64
+	Admin          bool
+	TenantID       string // tenant id of multi-tenant tables, empty means session variable tenant_id is used
+
+	rateLimiters rateLimiters // queries per second of the user in this proxy
 }
 
 // Namespace is struct driected used by server
@@ -57,6 +69,18 @@ type Namespace struct {
 	defaultPhyDBs      map[string]string // logicDBName-phyDBName
 	sqls               map[string]string //key: parser fingerprint
 	slowSQLTime        int64             // session slow parser time, millisecond, default 1000
+	maxExecutionTime   int64             // default statement timeout, millisecond, 0 means no limit
+	transactionMode    string            // default transaction mode of sessions
+	ddlStrategy        string            // default ddl strategy of sharding table
+	fullScatter        string            // policy of statements routed to all sub tables without condition of sharding column
+	shardKeyUpdate     string            // policy of UPDATE which modifies value of sharding column
+	maxParallelism     int               // max number of slices executed concurrently, 0 means no limit
+	streamingSelect    bool              // stream rows of cross slice select to client
+	systemSettings     bool              // push down session system variables not handled by proxy to backend connections
+	sessionConsistency bool              // reads from slaves wait until writes of the session are applied
+	maxQueryMemory     int64             // max bytes of rows buffered by one statement, 0 means no limit
+	maxConnections     int64             // cluster-wide max connections of all users, 0 means no limit
+	clientIdleTimeout  int64             // close client connection after idle for seconds, 0 means session_timeout of proxy
 	allowips           []util.IPInfo
 	router             *router.Router
 	sequences          *sequence.SequenceManager
@@ -64,13 +88,33 @@ type Namespace struct {
 	userProperties     map[string]*UserProperty  // key: user name ,value: user's properties
 	defaultCharset     string
 	defaultCollationID mysql.CollationID
+	backendCharset     string // 后端连接的字符集, 空表示使用客户端字符集
+	backendCollationID mysql.CollationID
 	openGeneralLog     bool
+	readOnly           bool               // reject writes of all users, e.g. when routing of resharding is switched
+	auditLog           bool               // write audit events of connections and DML/DDL statements
+	rateLimiters       rateLimiters       // queries per second of all users of the namespace in this proxy
+	shadow             *models.Shadow     // replay sampled queries in shadow namespace, nil if disabled
+	encryptor          *encrypt.Encryptor // encrypt values of sensitive columns in statements and decrypt them in results, nil if disabled
+	tenant             *tenant.Isolation  // inject tenant condition into statements of multi-tenant tables, nil if disabled
+	masker             *mask.Masker       // mask sensitive values in results according to rules of users, nil if disabled
+	resultCache        *resultCache       // cache results of idempotent select in this proxy, nil if disabled
+	queryHandler       QueryHandler       // chain of builtin and custom middlewares executing queries
 
 	slowSQLCache         *cache.LRUCache
 	errorSQLCache        *cache.LRUCache
 	backendSlowSQLCache  *cache.LRUCache
 	backendErrorSQLCache *cache.LRUCache
 	planCache            *cache.LRUCache
+	sqlStatsCache        *cache.LRUCache
+
+	ddlJobs *ddlJobManager // per shard status of DDL of sharding tables, failed shards are retried by executing the DDL again
+
+	schemaTracker *schemaTracker // load column definitions of logical tables from backend
+
+	config *models.Namespace // config the namespace is built from, used to diff with new config
+
+	sessions sync2.AtomicInt64 // sessions executing command or in transaction with the namespace
 }
 
 // DumpToJSON  means easy encode json
@@ -86,11 +130,16 @@ func NewNamespace(namespaceConfig *models.Namespace) (*Namespace, error) {
 		sqls:                 make(map[string]string, 16),
 		userProperties:       make(map[string]*UserProperty, 2),
 		openGeneralLog:       namespaceConfig.OpenGeneralLog,
+		readOnly:             namespaceConfig.ReadOnly,
+		auditLog:             namespaceConfig.AuditLog,
+		shadow:               namespaceConfig.Shadow,
 		slowSQLCache:         cache.NewLRUCache(defaultSQLCacheCapacity),
 		errorSQLCache:        cache.NewLRUCache(defaultSQLCacheCapacity),
 		backendSlowSQLCache:  cache.NewLRUCache(defaultSQLCacheCapacity),
 		backendErrorSQLCache: cache.NewLRUCache(defaultSQLCacheCapacity),
 		planCache:            cache.NewLRUCache(defaultPlanCacheCapacity),
+		sqlStatsCache:        cache.NewLRUCache(defaultSQLStatsCapacity),
+		config:               namespaceConfig,
 	}
 
 	defer func() {
@@ -108,6 +157,52 @@ func NewNamespace(namespaceConfig *models.Namespace) (*Namespace, error) {
 		return nil, fmt.Errorf("parse slowSQLTime error: %v", err)
 	}
 
+	// init default statement timeout
+	namespace.maxExecutionTime, err = parseMaxExecutionTime(namespaceConfig.MaxExecutionTime)
+	if err != nil {
+		return nil, fmt.Errorf("parse maxExecutionTime error: %v", err)
+	}
+
+	namespace.maxConnections = namespaceConfig.MaxConnections
+	namespace.clientIdleTimeout, err = parseClientIdleTimeout(namespaceConfig.ClientIdleTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("parse clientIdleTimeout error: %v", err)
+	}
+
+	namespace.maxParallelism, err = parseMaxParallelism(namespaceConfig.MaxParallelism)
+	if err != nil {
+		return nil, fmt.Errorf("parse maxParallelism error: %v", err)
+	}
+
+	namespace.streamingSelect = namespaceConfig.StreamingSelect
+	namespace.systemSettings = namespaceConfig.EnableSystemSettings
+	namespace.rateLimiters = newRateLimiters(namespaceConfig.RateLimit)
+	namespace.maxQueryMemory, err = parseMaxQueryMemory(namespaceConfig.MaxQueryMemory)
+	if err != nil {
+		return nil, fmt.Errorf("parse maxQueryMemory error: %v", err)
+	}
+
+	namespace.transactionMode = namespaceConfig.TransactionMode
+	if namespace.transactionMode == "" {
+		namespace.transactionMode = models.TransactionModeMulti
+	}
+
+	namespace.ddlStrategy = namespaceConfig.DDLStrategy
+	if namespace.ddlStrategy == "" {
+		namespace.ddlStrategy = models.DDLStrategyDirect
+	}
+	namespace.ddlJobs = newDDLJobManager()
+
+	namespace.fullScatter = namespaceConfig.FullScatter
+	if namespace.fullScatter == "" {
+		namespace.fullScatter = models.FullScatterAllow
+	}
+	namespace.shardKeyUpdate = namespaceConfig.ShardKeyUpdate
+	if namespace.shardKeyUpdate == "" {
+		namespace.shardKeyUpdate = models.ShardKeyUpdateReject
+	}
+	namespace.sessionConsistency = namespaceConfig.ReadConsistency == models.ReadConsistencySession
+
 	allowDBs := make(map[string]bool, len(namespaceConfig.AllowedDBS))
 	for db, allowed := range namespaceConfig.AllowedDBS {
 		allowDBs[strings.TrimSpace(db)] = allowed
@@ -135,15 +230,27 @@ func NewNamespace(namespaceConfig *models.Namespace) (*Namespace, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse charset error: %v", err)
 	}
+	if namespaceConfig.BackendCharset != "" {
+		backendCharset := namespaceConfig.BackendCharset
+		namespace.backendCharset, namespace.backendCollationID, err = parseCharset(backendCharset, mysql.CharsetsToCollationNames[backendCharset])
+		if err != nil {
+			return nil, fmt.Errorf("parse backend charset error: %v", err)
+		}
+	}
 
 	// init user properties
 	for _, user := range namespaceConfig.Users {
-		up := &UserProperty{RWFlag: user.RWFlag, RWSplit: user.RWSplit, OtherProperty: user.OtherProperty}
+		up := &UserProperty{RWFlag: user.RWFlag, RWSplit: user.RWSplit, OtherProperty: user.OtherProperty, MaxConnections: user.MaxConnections, Admin: user.Admin, TenantID: user.TenantID}
+		up.rateLimiters = newRateLimiters(user.RateLimit)
 		namespace.userProperties[user.UserName] = up
 	}
 
 	// init backend slices
-	namespace.slices, err = parseSlices(namespaceConfig.Slices, namespace.defaultCharset, namespace.defaultCollationID)
+	slicesCharset, slicesCollationID := namespace.defaultCharset, namespace.defaultCollationID
+	if namespace.backendCharset != "" {
+		slicesCharset, slicesCollationID = namespace.backendCharset, namespace.backendCollationID
+	}
+	namespace.slices, err = parseSlices(namespaceConfig.Name, namespaceConfig.Slices, slicesCharset, slicesCollationID)
 	if err != nil {
 		return nil, fmt.Errorf("init slices of namespace: %s failed, err: %v", namespaceConfig.Name, err)
 	}
@@ -154,33 +261,124 @@ func NewNamespace(namespaceConfig *models.Namespace) (*Namespace, error) {
 		return nil, fmt.Errorf("init router of namespace: %s failed, err: %v", namespace.name, err)
 	}
 
+	// init column encryption, keys are loaded from key provider
+	if namespaceConfig.Encryption != nil {
+		namespace.encryptor, err = encrypt.NewEncryptor(namespace.name, namespaceConfig.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("init encryption of namespace: %s failed, err: %v", namespace.name, err)
+		}
+	}
+
+	if namespaceConfig.Tenant != nil {
+		namespace.tenant = tenant.NewIsolation(namespaceConfig.Tenant)
+	}
+
+	if namespaceConfig.Masking != nil {
+		namespace.masker = mask.NewMasker(namespaceConfig.Masking)
+	}
+
+	if namespaceConfig.ResultCache != nil {
+		namespace.resultCache = newResultCache(namespaceConfig.ResultCache)
+	}
+
+	namespace.queryHandler, err = buildQueryHandler(namespaceConfig.QueryMiddlewares)
+	if err != nil {
+		return nil, fmt.Errorf("init query middlewares of namespace: %s failed, err: %v", namespace.name, err)
+	}
+
 	// init global sequences source
-	// 目前只支持基于mysql的序列号
 	sequences := sequence.NewSequenceManager()
 	for _, v := range namespaceConfig.GlobalSequences {
-		globalSequenceSlice, ok := namespace.slices[v.SliceName]
-		if !ok {
-			return nil, fmt.Errorf("init global sequence error: slice not found, sequence: %v", v)
+		var seq sequence.Sequence
+		seq, err = createSequence(namespace, v)
+		if err != nil {
+			return nil, fmt.Errorf("init global sequence error: %v, sequence: %v", err, v)
 		}
-		seqName := strings.ToUpper(v.DB) + "." + strings.ToUpper(v.Table)
-		seq := sequence.NewMySQLSequence(globalSequenceSlice, seqName, v.PKName)
 		sequences.SetSequence(v.DB, v.Table, seq)
 	}
 	namespace.sequences = sequences
 
+	// init schema tracker, column definitions are loaded in background
+	schemaRefreshInterval, err := parseSchemaRefreshInterval(namespaceConfig.SchemaRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("parse schemaRefreshInterval error: %v", err)
+	}
+	namespace.schemaTracker = newSchemaTracker(namespace, namespace.router.GetSchema(), schemaRefreshInterval)
+	namespace.schemaTracker.start()
+
 	return namespace, nil
 }
 
+func createSequence(namespace *Namespace, cfg *models.GlobalSequence) (sequence.Sequence, error) {
+	switch cfg.Type {
+	case models.GlobalSequenceSnowflake:
+		return sequence.NewSnowflakeSequence(cfg.PKName, sequence.GetSnowflakeWorkerID())
+	case models.GlobalSequenceEtcd:
+		allocator := sequence.GetSegmentAllocator()
+		if allocator == nil {
+			return nil, fmt.Errorf("etcd sequence is not available when source type is not etcd")
+		}
+		key := namespace.name + "/" + cfg.DB + "." + cfg.Table
+		return sequence.NewSegmentSequence(allocator, key, cfg.PKName, cfg.Step), nil
+	default:
+		// 兼容mycat的基于mysql的序列号
+		globalSequenceSlice, ok := namespace.slices[cfg.SliceName]
+		if !ok {
+			return nil, fmt.Errorf("slice not found")
+		}
+		seqName := strings.ToUpper(cfg.DB) + "." + strings.ToUpper(cfg.Table)
+		return sequence.NewMySQLSequence(globalSequenceSlice, seqName, cfg.PKName), nil
+	}
+}
+
 // GetName return namespace of namespace
 func (n *Namespace) GetName() string {
 	return n.name
 }
 
+// GetConfig return config the namespace is built from
+func (n *Namespace) GetConfig() *models.Namespace {
+	return n.config
+}
+
 // GetSlice return slice of namespace
 func (n *Namespace) GetSlice(name string) *backend.Slice {
 	return n.slices[name]
 }
 
+// GetCircuitBreakerStats return state of circuit breakers of backends, key: slice name
+func (n *Namespace) GetCircuitBreakerStats() map[string][]*backend.CircuitBreakerStats {
+	stats := make(map[string][]*backend.CircuitBreakerStats, len(n.slices))
+	for name, slice := range n.slices {
+		if s := slice.GetCircuitBreakerStats(); len(s) > 0 {
+			stats[name] = s
+		}
+	}
+	return stats
+}
+
+// CheckHealth check backends of all slices concurrently, slaves whose replication lag exceeds maxLag seconds are degraded
+func (n *Namespace) CheckHealth(timeout time.Duration, maxLag int64) *models.NamespaceHealth {
+	h := &models.NamespaceHealth{Ready: true, Slices: make(map[string]*models.SliceHealth, len(n.slices))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, slice := range n.slices {
+		wg.Add(1)
+		go func(name string, slice *backend.Slice) {
+			defer wg.Done()
+			sh := models.NewSliceHealth(slice.CheckHealth(timeout), maxLag)
+			mu.Lock()
+			defer mu.Unlock()
+			h.Slices[name] = sh
+			if sh.Status == models.SliceDown {
+				h.Ready = false
+			}
+		}(name, slice)
+	}
+	wg.Wait()
+	return h
+}
+
 // GetRouter return router of namespace
 func (n *Namespace) GetRouter() *router.Router {
 	return n.router
@@ -207,9 +405,83 @@ func (n *Namespace) getSessionSlowSQLTime() int64 {
 	return n.slowSQLTime
 }
 
-// IsAllowWrite check if user allow to write
+func (n *Namespace) getMaxExecutionTime() int64 {
+	return n.maxExecutionTime
+}
+
+// GetMaxConnections return cluster-wide max connections of all users, 0 means no limit
+func (n *Namespace) GetMaxConnections() int64 {
+	return n.maxConnections
+}
+
+// getClientIdleTimeout return idle timeout of client connections, 0 means session_timeout of proxy
+func (n *Namespace) getClientIdleTimeout() time.Duration {
+	return time.Duration(n.clientIdleTimeout) * time.Second
+}
+
+func (n *Namespace) getMaxParallelism() int {
+	return n.maxParallelism
+}
+
+func (n *Namespace) isStreamingSelect() bool {
+	return n.streamingSelect
+}
+
+func (n *Namespace) isSystemSettingsEnabled() bool {
+	return n.systemSettings
+}
+
+func (n *Namespace) isSessionConsistency() bool {
+	return n.sessionConsistency
+}
+
+func (n *Namespace) getMaxQueryMemory() int64 {
+	return n.maxQueryMemory
+}
+
+// GetTransactionMode return default transaction mode of namespace
+func (n *Namespace) GetTransactionMode() string {
+	return n.transactionMode
+}
+
+// GetDDLStrategy return default ddl strategy of namespace
+func (n *Namespace) GetDDLStrategy() string {
+	return n.ddlStrategy
+}
+
+// GetFullScatter return policy of statements routed to all sub tables without condition of sharding column
+func (n *Namespace) GetFullScatter() string {
+	return n.fullScatter
+}
+
+// GetShardKeyUpdate return policy of UPDATE which modifies value of sharding column
+func (n *Namespace) GetShardKeyUpdate() string {
+	return n.shardKeyUpdate
+}
+
+// GetEncryptor return encryptor of sensitive columns, nil if column encryption is disabled
+func (n *Namespace) GetEncryptor() *encrypt.Encryptor {
+	return n.encryptor
+}
+
+// GetTenantIsolation return row level isolation of multi-tenant tables, nil if it's disabled
+func (n *Namespace) GetTenantIsolation() *tenant.Isolation {
+	return n.tenant
+}
+
+// GetMasker return masker of sensitive values in results, nil if masking is disabled
+func (n *Namespace) GetMasker() *mask.Masker {
+	return n.masker
+}
+
+// RefreshSchema load column definitions of logical tables from backend at once
+func (n *Namespace) RefreshSchema() error {
+	return n.schemaTracker.refresh()
+}
+
+// IsAllowWrite check if user allow to write, no user can write if namespace is read only
 func (n *Namespace) IsAllowWrite(user string) bool {
-	return n.userProperties[user].RWFlag == models.ReadWrite
+	return !n.readOnly && n.userProperties[user].RWFlag == models.ReadWrite
 }
 
 // IsRWSplit chekc if read write split
@@ -222,6 +494,28 @@ func (n *Namespace) IsStatisticUser(user string) bool {
 	return n.userProperties[user].OtherProperty == models.StatisticUser
 }
 
+// IsAdminUser check if user can use admin db
+func (n *Namespace) IsAdminUser(user string) bool {
+	up, ok := n.userProperties[user]
+	return ok && up.Admin
+}
+
+// GetUserTenantID return tenant id of user, empty if the user has no fixed tenant
+func (n *Namespace) GetUserTenantID(user string) string {
+	if up, ok := n.userProperties[user]; ok {
+		return up.TenantID
+	}
+	return ""
+}
+
+// GetUserMaxConnections return cluster-wide max connections of user, 0 means no limit
+func (n *Namespace) GetUserMaxConnections(user string) int64 {
+	if up, ok := n.userProperties[user]; ok {
+		return up.MaxConnections
+	}
+	return 0
+}
+
 // GetUserProperty return user information
 func (n *Namespace) GetUserProperty(user string) int {
 	return n.userProperties[user].OtherProperty
@@ -284,18 +578,41 @@ func (n *Namespace) GetDefaultCollationID() mysql.CollationID {
 	return n.defaultCollationID
 }
 
-// GetCachedPlan get plan in cache
+// GetBackendCharset return charset of backend connections, empty means charset of client is used
+func (n *Namespace) GetBackendCharset() string {
+	return n.backendCharset
+}
+
+// GetBackendCollationID return collation id of backend connections
+func (n *Namespace) GetBackendCollationID() mysql.CollationID {
+	return n.backendCollationID
+}
+
+// GetCachedPlan get plan of sql in cache, plans are cached by sql fingerprint
 func (n *Namespace) GetCachedPlan(db, sql string) (plan.Plan, bool) {
-	v, ok := n.planCache.Get(db + "|" + sql)
+	v, ok := n.planCache.Get(planCacheKey(db, sql))
 	if !ok {
 		return nil, false
 	}
-	return v.(plan.Plan), true
+	return v.(*planCacheEntry).get(sql)
 }
 
-// SetCachedPlan set plan in cache
+// SetCachedPlan set plan of sql in cache
 func (n *Namespace) SetCachedPlan(db, sql string, p plan.Plan) {
-	n.planCache.SetIfAbsent(db+"|"+sql, p)
+	key := planCacheKey(db, sql)
+	v, ok := n.planCache.Get(key)
+	if !ok {
+		n.planCache.SetIfAbsent(key, newPlanCacheEntry())
+		if v, ok = n.planCache.Get(key); !ok {
+			return
+		}
+	}
+	v.(*planCacheEntry).set(sql, p)
+}
+
+// ClearPlanCache remove all the cached plans
+func (n *Namespace) ClearPlanCache() {
+	n.planCache.Clear()
 }
 
 // SetSlowSQLFingerprint store slow parser fingerprint
@@ -414,12 +731,67 @@ func (n *Namespace) ClearBackendErrorSQLFingerprints() {
 	n.backendErrorSQLCache.Clear()
 }
 
+// RecordSQLStats record statistics of sql fingerprint
+func (n *Namespace) RecordSQLStats(md5, fingerprint string, duration time.Duration, rows int64, shards int, err error) {
+	v, ok := n.sqlStatsCache.Get(md5)
+	if !ok {
+		n.sqlStatsCache.SetIfAbsent(md5, NewSQLStats(fingerprint))
+		if v, ok = n.sqlStatsCache.Get(md5); !ok {
+			return
+		}
+	}
+	v.(*SQLStats).Record(duration, rows, shards, err)
+}
+
+// GetSQLStats return statistics of all sql fingerprints
+func (n *Namespace) GetSQLStats() []*SQLStats {
+	items := n.sqlStatsCache.Items()
+	ret := make([]*SQLStats, 0, len(items))
+	for _, item := range items {
+		ret = append(ret, item.Value.(*SQLStats))
+	}
+	return ret
+}
+
+// ClearSQLStats clear statistics of all sql fingerprints
+func (n *Namespace) ClearSQLStats() {
+	n.sqlStatsCache.Clear()
+}
+
+// acquire pin the namespace by session, it's not closed after reloading until released
+func (n *Namespace) acquire() {
+	n.sessions.Add(1)
+}
+
+// release unpin the namespace by session
+func (n *Namespace) release() {
+	n.sessions.Add(-1)
+}
+
+// waitSessions wait for sessions pinning the namespace to finish, return false if timeout
+func (n *Namespace) waitSessions(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for n.sessions.Get() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
+	return true
+}
+
 // Close recycle resources of namespace
 func (n *Namespace) Close(delay bool) {
 	var err error
-	// delay close time
+	if n.schemaTracker != nil {
+		n.schemaTracker.close()
+	}
+	// delay close time, and wait for running commands and transactions of sessions to finish
 	if delay {
 		time.Sleep(time.Second * namespaceDelayClose)
+		if !n.waitSessions(time.Second * namespaceMaxDelayClose) {
+			log.Warnf("close namespace %s with %d sessions still running", n.name, n.sessions.Get())
+		}
 	}
 	for k := range n.slices {
 		err = n.slices[k].Close()
@@ -432,13 +804,15 @@ func (n *Namespace) Close(delay bool) {
 	n.errorSQLCache.Clear()
 	n.backendSlowSQLCache.Clear()
 	n.backendErrorSQLCache.Clear()
+	n.planCache.Clear()
 }
 
-func parseSlice(cfg *models.Slice, charset string, collationID mysql.CollationID) (*backend.Slice, error) {
+func parseSlice(namespace string, cfg *models.Slice, charset string, collationID mysql.CollationID) (*backend.Slice, error) {
 	var err error
 	s := new(backend.Slice)
 	s.Cfg = *cfg
 	s.SetCharsetInfo(charset, collationID)
+	s.SetNamespace(namespace)
 
 	// parse master
 	err = s.ParseMaster(cfg.Master)
@@ -461,7 +835,7 @@ func parseSlice(cfg *models.Slice, charset string, collationID mysql.CollationID
 	return s, nil
 }
 
-func parseSlices(cfgSlices []*models.Slice, charset string, collationID mysql.CollationID) (map[string]*backend.Slice, error) {
+func parseSlices(namespace string, cfgSlices []*models.Slice, charset string, collationID mysql.CollationID) (map[string]*backend.Slice, error) {
 	slices := make(map[string]*backend.Slice, len(cfgSlices))
 	for _, v := range cfgSlices {
 		v.Name = strings.TrimSpace(v.Name) // modify origin slice name, trim space
@@ -469,7 +843,7 @@ func parseSlices(cfgSlices []*models.Slice, charset string, collationID mysql.Co
 			return nil, fmt.Errorf("duplicate slice [%s]", v.Name)
 		}
 
-		s, err := parseSlice(v, charset, collationID)
+		s, err := parseSlice(namespace, v, charset, collationID)
 		if err != nil {
 			return nil, err
 		}
@@ -525,6 +899,80 @@ func parseSlowSQLTime(str string) (int64, error) {
 	return t, nil
 }
 
+func parseMaxExecutionTime(str string) (int64, error) {
+	if str == "" {
+		return 0, nil
+	}
+	t, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if t < 0 {
+		return 0, fmt.Errorf("less than zero")
+	}
+
+	return t, nil
+}
+
+func parseClientIdleTimeout(str string) (int64, error) {
+	if str == "" {
+		return 0, nil
+	}
+	t, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if t < 0 {
+		return 0, fmt.Errorf("less than zero")
+	}
+	return t, nil
+}
+
+func parseMaxParallelism(str string) (int, error) {
+	if str == "" {
+		return 0, nil
+	}
+	p, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 {
+		return 0, fmt.Errorf("less than zero")
+	}
+
+	return p, nil
+}
+
+func parseSchemaRefreshInterval(str string) (time.Duration, error) {
+	if str == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("less than zero")
+	}
+
+	return time.Duration(i) * time.Second, nil
+}
+
+func parseMaxQueryMemory(str string) (int64, error) {
+	if str == "" {
+		return 0, nil
+	}
+	m, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if m < 0 {
+		return 0, fmt.Errorf("less than zero")
+	}
+
+	return m, nil
+}
+
 func parseCharset(charset, collation string) (string, mysql.CollationID, error) {
 	if charset == "" && collation == "" {
 		return mysql.DefaultCharset, mysql.DefaultCollationID, nil
@@ -0,0 +1,134 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+// NamespaceDiff difference between running config and new config of namespace,
+// slices are identified by name, shard rules by db.table and users by user name
+type NamespaceDiff struct {
+	Name    string `json:"name"`
+	Created bool   `json:"created"` // namespace not exists in proxy
+
+	AddedSlices    []string `json:"added_slices"`
+	RemovedSlices  []string `json:"removed_slices"`
+	ModifiedSlices []string `json:"modified_slices"`
+
+	AddedShardRules    []string `json:"added_shard_rules"`
+	RemovedShardRules  []string `json:"removed_shard_rules"`
+	ModifiedShardRules []string `json:"modified_shard_rules"`
+
+	AddedUsers    []string `json:"added_users"`
+	RemovedUsers  []string `json:"removed_users"`
+	ModifiedUsers []string `json:"modified_users"`
+
+	ModifiedFields []string `json:"modified_fields"` // json name of other modified fields, e.g. allowed_dbs
+}
+
+// IsEmpty return true if the new config is the same as the running one
+func (d *NamespaceDiff) IsEmpty() bool {
+	return !d.Created &&
+		len(d.AddedSlices) == 0 && len(d.RemovedSlices) == 0 && len(d.ModifiedSlices) == 0 &&
+		len(d.AddedShardRules) == 0 && len(d.RemovedShardRules) == 0 && len(d.ModifiedShardRules) == 0 &&
+		len(d.AddedUsers) == 0 && len(d.RemovedUsers) == 0 && len(d.ModifiedUsers) == 0 &&
+		len(d.ModifiedFields) == 0
+}
+
+// DiffNamespace compute difference between config of namespace, from is nil if namespace not exists in proxy.
+// both configs must be decrypted.
+func DiffNamespace(from, to *models.Namespace) *NamespaceDiff {
+	d := &NamespaceDiff{Name: to.Name}
+	if from == nil {
+		from = &models.Namespace{}
+		d.Created = true
+	}
+
+	oldSlices, newSlices := make(map[string]interface{}), make(map[string]interface{})
+	for _, s := range from.Slices {
+		oldSlices[s.Name] = s
+	}
+	for _, s := range to.Slices {
+		newSlices[s.Name] = s
+	}
+	d.AddedSlices, d.RemovedSlices, d.ModifiedSlices = diffItems(oldSlices, newSlices)
+
+	oldRules, newRules := make(map[string]interface{}), make(map[string]interface{})
+	for _, r := range from.ShardRules {
+		oldRules[r.DB+"."+r.Table] = r
+	}
+	for _, r := range to.ShardRules {
+		newRules[r.DB+"."+r.Table] = r
+	}
+	d.AddedShardRules, d.RemovedShardRules, d.ModifiedShardRules = diffItems(oldRules, newRules)
+
+	oldUsers, newUsers := make(map[string]interface{}), make(map[string]interface{})
+	for _, u := range from.Users {
+		oldUsers[u.UserName] = u
+	}
+	for _, u := range to.Users {
+		newUsers[u.UserName] = u
+	}
+	d.AddedUsers, d.RemovedUsers, d.ModifiedUsers = diffItems(oldUsers, newUsers)
+
+	if !d.Created {
+		d.ModifiedFields = diffFields(from, to)
+	}
+	return d
+}
+
+// diffItems return sorted keys of added, removed and modified items
+func diffItems(from, to map[string]interface{}) (added, removed, modified []string) {
+	for k, n := range to {
+		o, ok := from[k]
+		if !ok {
+			added = append(added, k)
+		} else if !reflect.DeepEqual(o, n) {
+			modified = append(modified, k)
+		}
+	}
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(modified)
+	return added, removed, modified
+}
+
+// diffFields return json name of modified fields except slices, shard rules and users
+func diffFields(from, to *models.Namespace) []string {
+	var fields []string
+	ov, nv := reflect.ValueOf(from).Elem(), reflect.ValueOf(to).Elem()
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		switch name {
+		case "name", "is_encrypt", "slices", "shard_rules", "users":
+			continue
+		}
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/models"
+)
+
+func TestDiffNamespace(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	running := se.GetNamespace().GetConfig()
+
+	copyConfig := func() *models.Namespace {
+		c := &models.Namespace{}
+		if err := json.Unmarshal(running.Encode(), c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// same config
+	d := DiffNamespace(running, copyConfig())
+	assert.True(t, d.IsEmpty())
+
+	c := copyConfig()
+	c.Slices[0].Capacity++
+	c.Slices = append(c.Slices, &models.Slice{Name: "slice-new"})
+	c.ShardRules = c.ShardRules[1:]
+	c.Users[0].RWFlag = 1
+	c.SlowSQLTime = "2000"
+	c.AllowedDBS["db_new"] = true
+	d = DiffNamespace(running, c)
+	assert.False(t, d.IsEmpty())
+	assert.Equal(t, []string{"slice-new"}, d.AddedSlices)
+	assert.Equal(t, []string{c.Slices[0].Name}, d.ModifiedSlices)
+	assert.Nil(t, d.RemovedSlices)
+	assert.Equal(t, []string{running.ShardRules[0].DB + "." + running.ShardRules[0].Table}, d.RemovedShardRules)
+	assert.Nil(t, d.ModifiedShardRules)
+	assert.Equal(t, []string{c.Users[0].UserName}, d.ModifiedUsers)
+	assert.Equal(t, []string{"allowed_dbs", "slow_sql_time"}, d.ModifiedFields)
+
+	// new namespace
+	d = DiffNamespace(nil, c)
+	assert.True(t, d.Created)
+	assert.Equal(t, len(c.Slices), len(d.AddedSlices))
+	assert.Nil(t, d.ModifiedFields)
+}
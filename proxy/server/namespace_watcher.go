@@ -0,0 +1,107 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/XiaoMi/Gaea/config"
+	"github.com/XiaoMi/Gaea/provider"
+)
+
+// watchableSource source provider which notifies changes of path and its children
+type watchableSource interface {
+	Watch(path string, ch chan string) error
+}
+
+// startNamespaceWatcher watch namespaces in consul, local files or kubernetes and apply changes in this proxy,
+// namespaces in etcd are pushed by gaea cc with prepare and commit.
+func (s *AdminServer) startNamespaceWatcher() {
+	if s.configType == provider.ConfigEtcd {
+		return
+	}
+	client := s.newSourceClient()
+	w, ok := client.(watchableSource)
+	if !ok {
+		client.Close()
+		return
+	}
+	s.watchClient = client
+
+	store := provider.NewStore(client)
+	ch := make(chan string, 1)
+	go func() {
+		err := w.Watch(store.NamespaceBase(), ch)
+		log.Infof("stop watching namespaces, err: %v", err)
+		close(ch)
+	}()
+	go func() {
+		for range ch {
+			s.syncNamespaces(client)
+		}
+	}()
+}
+
+// syncNamespaces reload namespaces whose config differs from the running one and delete removed namespaces
+func (s *AdminServer) syncNamespaces(client config.SourceProvider) {
+	store := provider.NewStore(client)
+	names, err := store.ListNamespace()
+	if err != nil {
+		log.Warnf("sync namespaces, list namespace failed, err: %v", err)
+		return
+	}
+
+	s.applyLock.Lock()
+	defer s.applyLock.Unlock()
+	// name of file may differ from name of namespace, so namespaces are identified by names in config
+	exists := make(map[string]bool, len(names))
+	loadFailed := false
+	for _, name := range names {
+		namespace, err := store.LoadNamespace(s.proxy.EncryptKey, name)
+		if err != nil {
+			log.Warnf("sync namespaces, load namespace %s failed, err: %v", name, err)
+			loadFailed = true
+			continue
+		}
+		exists[namespace.Name] = true
+		name = namespace.Name
+		diff := s.getNamespaceDiff(namespace)
+		if diff.IsEmpty() {
+			continue
+		}
+		if err := s.proxy.manager.ReloadNamespacePrepare(namespace); err != nil {
+			log.Warnf("sync namespaces, prepare namespace %s failed, err: %v", name, err)
+			continue
+		}
+		if err := s.proxy.ReloadNamespaceCommit(name); err != nil {
+			log.Warnf("sync namespaces, commit namespace %s failed, err: %v", name, err)
+			continue
+		}
+		log.Infof("sync namespaces, reload namespace: %s, diff: %+v", name, *diff)
+	}
+
+	// keep running namespaces if any config is broken, it may be one of them
+	if loadFailed {
+		return
+	}
+	for _, name := range s.proxy.manager.GetNamespaceNames() {
+		if exists[name] {
+			continue
+		}
+		if err := s.proxy.DeleteNamespace(name); err != nil {
+			log.Warnf("sync namespaces, delete namespace %s failed, err: %v", name, err)
+			continue
+		}
+		log.Infof("sync namespaces, delete namespace: %s", name)
+	}
+}
@@ -0,0 +1,552 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// PostgreSQL protocol 3.0, https://www.postgresql.org/docs/current/protocol-message-formats.html
+
+const (
+	pgProtocolVersion   = 196608 // 3.0
+	pgSSLRequestCode    = 80877103
+	pgGSSENCRequestCode = 80877104
+	pgCancelRequestCode = 80877102
+	pgMaxMessageSize    = 64 << 20
+)
+
+// messages sent by frontend
+const (
+	pgMsgQuery     byte = 'Q'
+	pgMsgParse     byte = 'P'
+	pgMsgBind      byte = 'B'
+	pgMsgDescribe  byte = 'D'
+	pgMsgExecute   byte = 'E'
+	pgMsgSync      byte = 'S'
+	pgMsgClose     byte = 'C'
+	pgMsgFlush     byte = 'H'
+	pgMsgTerminate byte = 'X'
+	pgMsgPassword  byte = 'p'
+)
+
+// messages sent by backend
+const (
+	pgMsgAuthentication       byte = 'R'
+	pgMsgParameterStatus      byte = 'S'
+	pgMsgBackendKeyData       byte = 'K'
+	pgMsgReadyForQuery        byte = 'Z'
+	pgMsgRowDescription       byte = 'T'
+	pgMsgDataRow              byte = 'D'
+	pgMsgCommandComplete      byte = 'C'
+	pgMsgEmptyQueryResponse   byte = 'I'
+	pgMsgErrorResponse        byte = 'E'
+	pgMsgParseComplete        byte = '1'
+	pgMsgBindComplete         byte = '2'
+	pgMsgCloseComplete        byte = '3'
+	pgMsgNoData               byte = 'n'
+	pgMsgParameterDescription byte = 't'
+	pgMsgPortalSuspended      byte = 's'
+)
+
+// transaction status in ReadyForQuery
+const (
+	pgTxIdle   byte = 'I'
+	pgTxActive byte = 'T'
+	pgTxFailed byte = 'E'
+)
+
+// oids of types in pg_type
+const (
+	pgTypeUnknown   uint32 = 0
+	pgTypeInt8      uint32 = 20
+	pgTypeInt2      uint32 = 21
+	pgTypeInt4      uint32 = 23
+	pgTypeText      uint32 = 25
+	pgTypeJSON      uint32 = 114
+	pgTypeFloat4    uint32 = 700
+	pgTypeFloat8    uint32 = 701
+	pgTypeDate      uint32 = 1082
+	pgTypeTimestamp uint32 = 1114
+	pgTypeNumeric   uint32 = 1700
+)
+
+var errPGBinaryFormat = errors.New("binary format is not supported")
+
+// pgConn read and write messages of PostgreSQL protocol
+type pgConn struct {
+	net.Conn
+	r   *bufio.Reader
+	w   *bufio.Writer
+	buf []byte // message being written
+}
+
+func newPGConn(conn net.Conn) *pgConn {
+	return &pgConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+}
+
+func (c *pgConn) readBody(length uint32) ([]byte, error) {
+	if length < 4 || length > pgMaxMessageSize {
+		return nil, fmt.Errorf("invalid message length %d", length)
+	}
+	body := make([]byte, length-4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// readStartupMessage read startup message which has no type byte
+func (c *pgConn) readStartupMessage() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return nil, err
+	}
+	return c.readBody(binary.BigEndian.Uint32(header[:]))
+}
+
+// readMessage read type and body of message
+func (c *pgConn) readMessage() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	body, err := c.readBody(binary.BigEndian.Uint32(header[1:]))
+	return header[0], body, err
+}
+
+func (c *pgConn) startMessage(typ byte) {
+	c.buf = append(c.buf[:0], typ, 0, 0, 0, 0)
+}
+
+func (c *pgConn) writeByte(b byte) {
+	c.buf = append(c.buf, b)
+}
+
+func (c *pgConn) writeInt16(n int16) {
+	c.buf = append(c.buf, byte(n>>8), byte(n))
+}
+
+func (c *pgConn) writeInt32(n int32) {
+	c.buf = append(c.buf, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func (c *pgConn) writeString(s string) {
+	c.buf = append(c.buf, s...)
+	c.buf = append(c.buf, 0)
+}
+
+func (c *pgConn) writeBytes(b []byte) {
+	c.buf = append(c.buf, b...)
+}
+
+// finishMessage set length of message and write it to buffer
+func (c *pgConn) finishMessage() error {
+	binary.BigEndian.PutUint32(c.buf[1:5], uint32(len(c.buf)-1))
+	_, err := c.w.Write(c.buf)
+	return err
+}
+
+func (c *pgConn) writeMessage(typ byte, body ...[]byte) error {
+	c.startMessage(typ)
+	for _, b := range body {
+		c.writeBytes(b)
+	}
+	return c.finishMessage()
+}
+
+func (c *pgConn) flush() error {
+	return c.w.Flush()
+}
+
+func (c *pgConn) writeAuthentication(code int32) error {
+	c.startMessage(pgMsgAuthentication)
+	c.writeInt32(code)
+	return c.finishMessage()
+}
+
+func (c *pgConn) writeParameterStatus(name, value string) error {
+	c.startMessage(pgMsgParameterStatus)
+	c.writeString(name)
+	c.writeString(value)
+	return c.finishMessage()
+}
+
+func (c *pgConn) writeBackendKeyData(pid, secret uint32) error {
+	c.startMessage(pgMsgBackendKeyData)
+	c.writeInt32(int32(pid))
+	c.writeInt32(int32(secret))
+	return c.finishMessage()
+}
+
+func (c *pgConn) writeReadyForQuery(status byte) error {
+	c.startMessage(pgMsgReadyForQuery)
+	c.writeByte(status)
+	if err := c.finishMessage(); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+func (c *pgConn) writeCommandComplete(tag string) error {
+	c.startMessage(pgMsgCommandComplete)
+	c.writeString(tag)
+	return c.finishMessage()
+}
+
+// writeError write ErrorResponse, sqlstate of mysql error is sent as it is
+func (c *pgConn) writeError(err error) error {
+	code, message := "XX000", err.Error()
+	switch e := err.(type) {
+	case *mysql.SQLError:
+		code, message = e.SQLState(), e.Message
+	case *pgError:
+		code, message = e.code, e.message
+	}
+	c.startMessage(pgMsgErrorResponse)
+	for _, field := range []struct {
+		typ   byte
+		value string
+	}{{'S', "ERROR"}, {'V', "ERROR"}, {'C', code}, {'M', message}} {
+		c.writeByte(field.typ)
+		c.writeString(field.value)
+	}
+	c.writeByte(0)
+	return c.finishMessage()
+}
+
+func (c *pgConn) writeRowDescription(fields []*mysql.Field) error {
+	c.startMessage(pgMsgRowDescription)
+	c.writeInt16(int16(len(fields)))
+	for _, f := range fields {
+		oid, size := pgType(f)
+		c.writeString(string(f.Name))
+		c.writeInt32(0) // table oid
+		c.writeInt16(0) // column number
+		c.writeInt32(int32(oid))
+		c.writeInt16(size)
+		c.writeInt32(-1) // type modifier
+		c.writeInt16(0)  // text format
+	}
+	return c.finishMessage()
+}
+
+// writeDataRow convert text row of mysql protocol to DataRow
+func (c *pgConn) writeDataRow(row mysql.RowData, columns int) error {
+	c.startMessage(pgMsgDataRow)
+	c.writeInt16(int16(columns))
+	pos := 0
+	for i := 0; i < columns; i++ {
+		v, next, isNull, ok := mysql.ReadLenEncStringAsBytes(row, pos)
+		if !ok {
+			return fmt.Errorf("invalid row data")
+		}
+		pos = next
+		if isNull {
+			c.writeInt32(-1)
+			continue
+		}
+		c.writeInt32(int32(len(v)))
+		c.writeBytes(v)
+	}
+	return c.finishMessage()
+}
+
+// pgError error with sqlstate of PostgreSQL
+type pgError struct {
+	code    string
+	message string
+}
+
+func (e *pgError) Error() string {
+	return e.message
+}
+
+func newPGError(code, format string, args ...interface{}) *pgError {
+	return &pgError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// pgReader read fields of message body
+type pgReader struct {
+	data []byte
+	err  error
+}
+
+func (r *pgReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errors.New("invalid message format")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *pgReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *pgReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *pgReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *pgReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data, 0)
+	if end == -1 {
+		r.err = errors.New("invalid message format")
+		return ""
+	}
+	s := string(r.data[:end])
+	r.data = r.data[end+1:]
+	return s
+}
+
+// readFormats read format codes, only text format is supported
+func (r *pgReader) readFormats() error {
+	n := int(r.int16())
+	for i := 0; i < n; i++ {
+		if r.int16() != 0 {
+			return errPGBinaryFormat
+		}
+	}
+	return r.err
+}
+
+// parseStartupParams parse key value pairs of startup message
+func parseStartupParams(data []byte) map[string]string {
+	params := make(map[string]string)
+	r := &pgReader{data: data}
+	for {
+		key := r.string()
+		if key == "" || r.err != nil {
+			return params
+		}
+		params[key] = r.string()
+	}
+}
+
+// pgType return oid and size of PostgreSQL type of mysql column
+func pgType(f *mysql.Field) (uint32, int16) {
+	unsigned := f.Flag&uint16(mysql.UnsignedFlag) > 0
+	switch f.Type {
+	case mysql.TypeTiny:
+		return pgTypeInt2, 2
+	case mysql.TypeShort, mysql.TypeYear:
+		if unsigned {
+			return pgTypeInt4, 4
+		}
+		return pgTypeInt2, 2
+	case mysql.TypeInt24:
+		return pgTypeInt4, 4
+	case mysql.TypeLong:
+		if unsigned {
+			return pgTypeInt8, 8
+		}
+		return pgTypeInt4, 4
+	case mysql.TypeLonglong:
+		if unsigned {
+			return pgTypeNumeric, -1
+		}
+		return pgTypeInt8, 8
+	case mysql.TypeFloat:
+		return pgTypeFloat4, 4
+	case mysql.TypeDouble:
+		return pgTypeFloat8, 8
+	case mysql.TypeDecimal, mysql.TypeNewDecimal:
+		return pgTypeNumeric, -1
+	case mysql.TypeDate, mysql.TypeNewDate:
+		return pgTypeDate, 4
+	case mysql.TypeDatetime, mysql.TypeTimestamp:
+		return pgTypeTimestamp, 8
+	case mysql.TypeJSON:
+		return pgTypeJSON, -1
+	default:
+		// time of mysql may be out of range of PostgreSQL, and binary strings are not converted to bytea
+		return pgTypeText, -1
+	}
+}
+
+func isPGNumericType(oid uint32) bool {
+	switch oid {
+	case pgTypeInt2, pgTypeInt4, pgTypeInt8, pgTypeFloat4, pgTypeFloat8, pgTypeNumeric:
+		return true
+	}
+	return false
+}
+
+// skipPGQuoted return position after the quoted string, quoted identifier or comment starting at i,
+// i is returned if there is none
+func skipPGQuoted(sql string, i int) (int, error) {
+	switch c := sql[i]; {
+	case c == '\'' || c == '"' || c == '`':
+		for j := i + 1; j < len(sql); j++ {
+			if sql[j] == '\\' && c != '`' {
+				j++
+			} else if sql[j] == c {
+				return j + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unclosed quote %c in sql", c)
+	case c == '-' && strings.HasPrefix(sql[i:], "--"):
+		if end := strings.IndexByte(sql[i:], '\n'); end != -1 {
+			return i + end + 1, nil
+		}
+		return len(sql), nil
+	case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+		end := strings.Index(sql[i+2:], "*/")
+		if end == -1 {
+			return 0, fmt.Errorf("unclosed comment in sql")
+		}
+		return i + 2 + end + 2, nil
+	}
+	return i, nil
+}
+
+// splitPGQuery split statements of simple query by semicolon, empty statements are removed
+func splitPGQuery(sql string) ([]string, error) {
+	var stmts []string
+	start := 0
+	for i := 0; i < len(sql); {
+		next, err := skipPGQuoted(sql, i)
+		if err != nil {
+			return nil, err
+		}
+		if next != i {
+			i = next
+			continue
+		}
+		if sql[i] == ';' {
+			if s := strings.TrimSpace(sql[start:i]); s != "" {
+				stmts = append(stmts, s)
+			}
+			start = i + 1
+		}
+		i++
+	}
+	if s := strings.TrimSpace(sql[start:]); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts, nil
+}
+
+// scanPGParams call fn with position, length and number of each $n placeholder
+func scanPGParams(sql string, fn func(pos, length, n int)) error {
+	for i := 0; i < len(sql); {
+		next, err := skipPGQuoted(sql, i)
+		if err != nil {
+			return err
+		}
+		if next != i {
+			i = next
+			continue
+		}
+		if sql[i] != '$' {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+			j++
+		}
+		if j > i+1 {
+			n, _ := strconv.Atoi(sql[i+1 : j])
+			fn(i, j-i, n)
+		}
+		i = j
+	}
+	return nil
+}
+
+// countPGParams return the max number of $n placeholders
+func countPGParams(sql string) (int, error) {
+	count := 0
+	err := scanPGParams(sql, func(_, _, n int) {
+		if n > count {
+			count = n
+		}
+	})
+	return count, err
+}
+
+// bindPGParams replace $n placeholders with text values of params, values of numeric types are not quoted
+func bindPGParams(sql string, params [][]byte, types []uint32) (string, error) {
+	var sb strings.Builder
+	last := 0
+	var bindErr error
+	err := scanPGParams(sql, func(pos, length, n int) {
+		if bindErr != nil {
+			return
+		}
+		if n < 1 || n > len(params) {
+			bindErr = newPGError("08P01", "bind message supplies %d parameters, but $%d is used", len(params), n)
+			return
+		}
+		sb.WriteString(sql[last:pos])
+		last = pos + length
+		v := params[n-1]
+		switch {
+		case v == nil:
+			sb.WriteString("NULL")
+		case n <= len(types) && isPGNumericType(types[n-1]) && isPGNumber(string(v)):
+			sb.Write(v)
+		default:
+			sb.WriteString("'")
+			sb.WriteString(escapeSQL(string(v)))
+			sb.WriteString("'")
+		}
+	})
+	if err != nil {
+		return "", newPGError("42601", "%v", err)
+	}
+	if bindErr != nil {
+		return "", bindErr
+	}
+	sb.WriteString(sql[last:])
+	return sb.String(), nil
+}
+
+func isPGNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil && !strings.ContainsAny(s, "xXnNiI") // reject hex, NaN and Inf
+}
@@ -0,0 +1,108 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	parser2 "github.com/XiaoMi/Gaea/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitPGQuery(t *testing.T) {
+	stmts, err := splitPGQuery("select ';' from t; -- a;b\n select `a;b` from t /* ; */;;")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"select ';' from t", "-- a;b\n select `a;b` from t /* ; */"}, stmts)
+
+	stmts, err = splitPGQuery(" ; ")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(stmts))
+
+	_, err = splitPGQuery("select 'a")
+	assert.NotNil(t, err)
+}
+
+func TestBindPGParams(t *testing.T) {
+	sql := "select * from t where id = $1 and name = $2 and note = '$1' and x = $10"
+	count, err := countPGParams(sql)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, count)
+
+	sql = "select * from t where id = $1 and name = $2 and tag in ($3, $1)"
+	params := [][]byte{[]byte("12"), []byte("a'b\\"), nil}
+	bound, err := bindPGParams(sql, params, []uint32{pgTypeInt8, pgTypeUnknown, pgTypeText})
+	assert.Nil(t, err)
+	assert.Equal(t, `select * from t where id = 12 and name = 'a\'b\\' and tag in (NULL, 12)`, bound)
+
+	// values of unknown types and values which are not numbers are quoted
+	bound, err = bindPGParams("select $1, $2", [][]byte{[]byte("12"), []byte("1 or 1=1")}, []uint32{pgTypeUnknown, pgTypeInt4})
+	assert.Nil(t, err)
+	assert.Equal(t, "select '12', '1 or 1=1'", bound)
+
+	_, err = bindPGParams("select $2", [][]byte{[]byte("1")}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestPGStatementType(t *testing.T) {
+	assert.Equal(t, parser2.StmtBegin, pgStatementType("BEGIN READ ONLY"))
+	assert.Equal(t, parser2.StmtBegin, pgStatementType("start transaction"))
+	assert.Equal(t, parser2.StmtCommit, pgStatementType("end"))
+	assert.Equal(t, parser2.StmtRollback, pgStatementType("/* c */ ROLLBACK WORK"))
+	assert.Equal(t, parser2.StmtSelect, pgStatementType("select 1"))
+	assert.Equal(t, parser2.StmtSet, pgStatementType("SET extra_float_digits = 3"))
+	assert.Equal(t, parser2.StmtUpdate, pgStatementType("update t set a = 1"))
+}
+
+func TestPGType(t *testing.T) {
+	oid, size := pgType(&mysql.Field{Type: mysql.TypeLonglong})
+	assert.Equal(t, pgTypeInt8, oid)
+	assert.Equal(t, int16(8), size)
+	oid, _ = pgType(&mysql.Field{Type: mysql.TypeLonglong, Flag: uint16(mysql.UnsignedFlag)})
+	assert.Equal(t, pgTypeNumeric, oid)
+	oid, _ = pgType(&mysql.Field{Type: mysql.TypeVarString})
+	assert.Equal(t, pgTypeText, oid)
+}
+
+func TestPGMessages(t *testing.T) {
+	var out bytes.Buffer
+	c := &pgConn{w: bufio.NewWriter(&out)}
+	assert.Nil(t, c.writeRowDescription([]*mysql.Field{{Name: []byte("id"), Type: mysql.TypeLong}}))
+	row := mysql.AppendLenEncStringBytes(nil, []byte("7"))
+	row = append(row, 0xfb) // NULL
+	assert.Nil(t, c.writeDataRow(row, 2))
+	assert.Nil(t, c.writeCommandComplete("SELECT 1"))
+	assert.Nil(t, c.flush())
+
+	expect := []byte{'T', 0, 0, 0, 27, 0, 1, 'i', 'd', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 23, 0, 4, 0xff, 0xff, 0xff, 0xff, 0, 0}
+	expect = append(expect, 'D', 0, 0, 0, 15, 0, 2, 0, 0, 0, 1, '7', 0xff, 0xff, 0xff, 0xff)
+	expect = append(expect, 'C', 0, 0, 0, 13)
+	expect = append(expect, "SELECT 1\x00"...)
+	assert.Equal(t, expect, out.Bytes())
+
+	// read messages written by client
+	in := bytes.NewBuffer([]byte{'Q', 0, 0, 0, 13})
+	in.WriteString("select 1\x00")
+	c = &pgConn{r: bufio.NewReader(in)}
+	typ, body, err := c.readMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, pgMsgQuery, typ)
+	assert.Equal(t, "select 1", (&pgReader{data: body}).string())
+
+	params := parseStartupParams([]byte("user\x00u\x00database\x00db\x00\x00"))
+	assert.Equal(t, map[string]string{"user": "u", "database": "db"}, params)
+}
@@ -0,0 +1,600 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/logging"
+	"github.com/XiaoMi/Gaea/mysql"
+	parser2 "github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util/sync2"
+)
+
+// PostgreSQL clients can query tables of namespaces read-only through the PostgreSQL protocol. Statements are
+// sent to the same routing and execution pipeline as mysql sessions without translation, so they must be
+// written in the sql dialect of mysql. Both simple and extended query flows are supported in text format.
+
+// parameters reported to client after authentication
+var pgParameterStatus = [][2]string{
+	{"server_version", "13.0 (go-sharding)"},
+	{"server_encoding", "UTF8"},
+	{"client_encoding", "UTF8"},
+	{"DateStyle", "ISO, MDY"},
+	{"integer_datetimes", "on"},
+	// backslashes in string literals are escapes in mysql
+	{"standard_conforming_strings", "off"},
+}
+
+// pgServer serve PostgreSQL protocol
+type pgServer struct {
+	manager        *Manager
+	listener       net.Listener
+	sessionTimeout time.Duration
+	closed         sync2.AtomicBool
+
+	lock     sync.Mutex
+	sessions map[uint32]*pgSession // sessions by process id, used by cancel requests
+}
+
+func newPGServer(addr string, manager *Manager, sessionTimeout time.Duration) (*pgServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &pgServer{
+		manager:        manager,
+		listener:       l,
+		sessionTimeout: sessionTimeout,
+		closed:         sync2.NewAtomicBool(false),
+		sessions:       make(map[uint32]*pgSession),
+	}, nil
+}
+
+func (s *pgServer) run() {
+	for !s.closed.Get() {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !s.closed.Get() {
+				logging.DefaultLogger.Warnf("[server] pg listener accept error: %s", err.Error())
+			}
+			continue
+		}
+		go s.onConn(conn)
+	}
+}
+
+// close stop accepting connections and close connections of all sessions
+func (s *pgServer) close() {
+	s.closed.Set(true)
+	s.listener.Close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, ps := range s.sessions {
+		ps.c.Close()
+	}
+}
+
+func (s *pgServer) onConn(conn net.Conn) {
+	c := newPGConn(conn)
+	defer func() {
+		if err := recover(); err != nil {
+			const size = 4096
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			logging.DefaultLogger.Warnf("[server] pg onConn panic error, remoteAddr: %s, stack: %s", conn.RemoteAddr().String(), string(buf))
+		}
+		conn.Close()
+	}()
+
+	ps, err := s.startup(c)
+	if err != nil {
+		logging.DefaultLogger.Warnf("[server] pg onConn error, remoteAddr: %s, error: %v", conn.RemoteAddr().String(), err)
+		if _, ok := err.(*pgError); ok {
+			c.writeError(err)
+			c.flush()
+		}
+		return
+	}
+	if ps == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.sessions[ps.se.connID] = ps
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.sessions, ps.se.connID)
+		s.lock.Unlock()
+		closeLocalSessionExecutor(ps.se)
+	}()
+	ps.run()
+}
+
+// cancel kill query of session by cancel request
+func (s *pgServer) cancel(pid, secret uint32) {
+	s.lock.Lock()
+	ps, ok := s.sessions[pid]
+	s.lock.Unlock()
+	if ok && ps.secret == secret {
+		ps.se.killQuery()
+	}
+}
+
+// startup negotiate protocol and authenticate user by cleartext password, nil session is returned for cancel requests
+func (s *pgServer) startup(c *pgConn) (*pgSession, error) {
+	c.SetDeadline(time.Now().Add(s.sessionTimeout))
+	var params map[string]string
+	for params == nil {
+		data, err := c.readStartupMessage()
+		if err != nil {
+			return nil, err
+		}
+		r := &pgReader{data: data}
+		code := r.int32()
+		switch code {
+		case pgSSLRequestCode, pgGSSENCRequestCode:
+			// encryption is not supported, client may continue without it
+			if _, err := c.Write([]byte{'N'}); err != nil {
+				return nil, err
+			}
+		case pgCancelRequestCode:
+			pid, secret := uint32(r.int32()), uint32(r.int32())
+			if r.err == nil {
+				s.cancel(pid, secret)
+			}
+			return nil, nil
+		case pgProtocolVersion:
+			params = parseStartupParams(r.data)
+		default:
+			return nil, newPGError("0A000", "unsupported frontend protocol %d.%d", code>>16, code&0xffff)
+		}
+	}
+
+	user := params["user"]
+	if err := c.writeAuthentication(3); err != nil { // cleartext password
+		return nil, err
+	}
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	typ, data, err := c.readMessage()
+	if err != nil {
+		return nil, err
+	}
+	if typ != pgMsgPassword {
+		return nil, newPGError("08P01", "expected password response, got message type %c", typ)
+	}
+	password := (&pgReader{data: data}).string()
+
+	namespace := ""
+	if s.manager.CheckUser(user) {
+		namespace = s.manager.GetNamespaceByUser(user, password)
+	}
+	ns := s.manager.GetNamespace(namespace)
+	if namespace == "" || ns == nil {
+		return nil, newPGError("28P01", "password authentication failed for user \"%s\"", user)
+	}
+	clientAddr := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(clientAddr); err == nil && !ns.IsClientIPAllowed(net.ParseIP(host)) {
+		return nil, newPGError("28000", "ip address access denied by gaea")
+	}
+	se, err := newLocalSessionExecutor(s.manager, user, namespace, clientAddr, params["database"])
+	if err != nil {
+		return nil, newPGError("3D000", "database \"%s\" does not exist", params["database"])
+	}
+
+	var b [4]byte
+	rand.Read(b[:])
+	ps := &pgSession{
+		server:     s,
+		c:          c,
+		se:         se,
+		secret:     binary.BigEndian.Uint32(b[:]),
+		txStatus:   pgTxIdle,
+		statements: make(map[string]*pgStatement),
+		portals:    make(map[string]*pgPortal),
+	}
+	if err := c.writeAuthentication(0); err != nil {
+		return nil, err
+	}
+	for _, p := range pgParameterStatus {
+		if err := c.writeParameterStatus(p[0], p[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.writeBackendKeyData(se.connID, ps.secret); err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return ps, c.writeReadyForQuery(pgTxIdle)
+}
+
+// pgStatement statement created by Parse
+type pgStatement struct {
+	sql        string
+	stmtType   parser2.StatementType
+	paramTypes []uint32
+}
+
+// pgPortal statement with params bound by Bind, the statement is executed by the first Describe or Execute
+type pgPortal struct {
+	stmt     *pgStatement
+	sql      string
+	executed bool
+	result   *mysql.Result
+	tag      string
+	pos      int // rows sent by Execute
+}
+
+// pgSession session of PostgreSQL client
+type pgSession struct {
+	server   *pgServer
+	c        *pgConn
+	se       *SessionExecutor
+	secret   uint32
+	txStatus byte
+	// error occurs in extended query, messages are discarded until Sync
+	skipToSync bool
+
+	statements map[string]*pgStatement
+	portals    map[string]*pgPortal
+}
+
+func (ps *pgSession) run() {
+	for {
+		ps.c.SetReadDeadline(time.Now().Add(ps.server.sessionTimeout))
+		typ, data, err := ps.c.readMessage()
+		if err != nil {
+			return
+		}
+		if typ == pgMsgTerminate {
+			return
+		}
+		if err := ps.dispatch(typ, data); err != nil {
+			logging.DefaultLogger.Warnw("pg session write response error",
+				logging.FieldConnID, ps.se.connID, logging.FieldNamespace, ps.se.namespace, "err", err)
+			return
+		}
+	}
+}
+
+// dispatch handle message, the returned error means connection is broken
+func (ps *pgSession) dispatch(typ byte, data []byte) error {
+	if typ == pgMsgSync {
+		ps.skipToSync = false
+		return ps.c.writeReadyForQuery(ps.txStatus)
+	}
+	if ps.skipToSync {
+		return nil
+	}
+
+	var err error
+	r := &pgReader{data: data}
+	switch typ {
+	case pgMsgQuery:
+		return ps.handleQuery(r.string())
+	case pgMsgParse:
+		err = ps.handleParse(r)
+	case pgMsgBind:
+		err = ps.handleBind(r)
+	case pgMsgDescribe:
+		err = ps.handleDescribe(r)
+	case pgMsgExecute:
+		err = ps.handleExecute(r)
+	case pgMsgClose:
+		err = ps.handleClose(r)
+	case pgMsgFlush:
+		return ps.c.flush()
+	default:
+		err = newPGError("08P01", "unsupported message type %c", typ)
+	}
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*pgError); !ok {
+		if _, ok := err.(*mysql.SQLError); !ok {
+			return err
+		}
+	}
+	ps.skipToSync = true
+	return ps.c.writeError(err)
+}
+
+// pgStatementType return type of statement, transaction statements of PostgreSQL are recognized
+func pgStatementType(sql string) parser2.StatementType {
+	trimmed := strings.ToLower(parser2.StripLeadingComments(sql))
+	if end := strings.IndexFunc(trimmed, func(r rune) bool { return r == ' ' || r == '\t' || r == '\n' || r == '\r' }); end != -1 {
+		trimmed = trimmed[:end]
+	}
+	switch trimmed {
+	case "begin", "start":
+		return parser2.StmtBegin
+	case "commit", "end":
+		return parser2.StmtCommit
+	case "rollback", "abort":
+		return parser2.StmtRollback
+	}
+	return parser2.PreviewSql(sql)
+}
+
+// execute execute statement in session, transaction statements and SET are handled in session without
+// backend transactions since only read-only statements are executed
+func (ps *pgSession) execute(sql string, stmtType parser2.StatementType) (*mysql.Result, string, error) {
+	if ps.txStatus == pgTxFailed && stmtType != parser2.StmtCommit && stmtType != parser2.StmtRollback {
+		return nil, "", newPGError("25P02", "current transaction is aborted, commands ignored until end of transaction block")
+	}
+	switch stmtType {
+	case parser2.StmtBegin:
+		ps.txStatus = pgTxActive
+		return nil, "BEGIN", nil
+	case parser2.StmtCommit:
+		tag := "COMMIT"
+		if ps.txStatus == pgTxFailed {
+			tag = "ROLLBACK"
+		}
+		ps.txStatus = pgTxIdle
+		return nil, tag, nil
+	case parser2.StmtRollback:
+		ps.txStatus = pgTxIdle
+		return nil, "ROLLBACK", nil
+	case parser2.StmtSet:
+		// session parameters of PostgreSQL clients are ignored
+		return nil, "SET", nil
+	case parser2.StmtSelect, parser2.StmtShow, parser2.StmtExplain:
+	default:
+		return nil, "", newPGError("25006", "cannot execute %s in a read-only session", stmtType.String())
+	}
+
+	rs := ps.se.ExecuteCommand(mysql.ComQuery, []byte(sql))
+	switch rs.RespType {
+	case RespError:
+		if ps.txStatus == pgTxActive {
+			ps.txStatus = pgTxFailed
+		}
+		err := rs.Data.(error)
+		if _, ok := err.(*mysql.SQLError); !ok {
+			err = newPGError("XX000", "%v", err)
+		}
+		return nil, "", err
+	case RespResult:
+		if r, ok := rs.Data.(*mysql.Result); ok && r != nil && r.Resultset != nil {
+			return r, "SELECT", nil
+		}
+	}
+	return nil, stmtType.String(), nil
+}
+
+// writeRows write rows of result from pos, at most max rows if max is positive, return the position after written rows
+func (ps *pgSession) writeRows(r *mysql.Result, pos, max int) (int, error) {
+	end := len(r.RowDatas)
+	if max > 0 && pos+max < end {
+		end = pos + max
+	}
+	for ; pos < end; pos++ {
+		if err := ps.c.writeDataRow(r.RowDatas[pos], len(r.Fields)); err != nil {
+			return pos, err
+		}
+	}
+	return pos, nil
+}
+
+// handleQuery execute statements of simple query, the rest statements are skipped after error
+func (ps *pgSession) handleQuery(sql string) error {
+	stmts, err := splitPGQuery(sql)
+	if err != nil {
+		ps.c.writeError(newPGError("42601", "%v", err))
+		return ps.c.writeReadyForQuery(ps.txStatus)
+	}
+	if len(stmts) == 0 {
+		if err := ps.c.writeMessage(pgMsgEmptyQueryResponse); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range stmts {
+		r, tag, err := ps.execute(stmt, pgStatementType(stmt))
+		if err != nil {
+			if err := ps.c.writeError(err); err != nil {
+				return err
+			}
+			break
+		}
+		if r != nil {
+			if err := ps.c.writeRowDescription(r.Fields); err != nil {
+				return err
+			}
+			if _, err := ps.writeRows(r, 0, 0); err != nil {
+				return err
+			}
+			tag = fmt.Sprintf("SELECT %d", len(r.RowDatas))
+		}
+		if err := ps.c.writeCommandComplete(tag); err != nil {
+			return err
+		}
+	}
+	return ps.c.writeReadyForQuery(ps.txStatus)
+}
+
+func (ps *pgSession) handleParse(r *pgReader) error {
+	name, sql := r.string(), r.string()
+	n := int(r.int16())
+	var paramTypes []uint32
+	for i := 0; i < n; i++ {
+		paramTypes = append(paramTypes, uint32(r.int32()))
+	}
+	if r.err != nil {
+		return newPGError("08P01", "%v", r.err)
+	}
+
+	stmts, err := splitPGQuery(sql)
+	if err != nil {
+		return newPGError("42601", "%v", err)
+	}
+	if len(stmts) > 1 {
+		return newPGError("42601", "cannot insert multiple commands into a prepared statement")
+	}
+	stmt := &pgStatement{stmtType: parser2.StmtComment}
+	if len(stmts) == 1 {
+		stmt.sql = stmts[0]
+		stmt.stmtType = pgStatementType(stmt.sql)
+		count, err := countPGParams(stmt.sql)
+		if err != nil {
+			return newPGError("42601", "%v", err)
+		}
+		for len(paramTypes) < count {
+			paramTypes = append(paramTypes, pgTypeUnknown)
+		}
+	}
+	stmt.paramTypes = paramTypes
+	ps.statements[name] = stmt
+	return ps.c.writeMessage(pgMsgParseComplete)
+}
+
+func (ps *pgSession) handleBind(r *pgReader) error {
+	portalName, stmtName := r.string(), r.string()
+	if err := r.readFormats(); err != nil {
+		return newPGError("0A000", "%v", err)
+	}
+	n := int(r.int16())
+	params := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		if size := r.int32(); size >= 0 {
+			params[i] = append([]byte{}, r.next(int(size))...)
+		}
+	}
+	if err := r.readFormats(); err != nil {
+		return newPGError("0A000", "%v", err)
+	}
+	if r.err != nil {
+		return newPGError("08P01", "%v", r.err)
+	}
+
+	stmt, ok := ps.statements[stmtName]
+	if !ok {
+		return newPGError("26000", "prepared statement \"%s\" does not exist", stmtName)
+	}
+	if n != len(stmt.paramTypes) {
+		return newPGError("08P01", "bind message supplies %d parameters, but prepared statement \"%s\" requires %d", n, stmtName, len(stmt.paramTypes))
+	}
+	sql, err := bindPGParams(stmt.sql, params, stmt.paramTypes)
+	if err != nil {
+		return err
+	}
+	ps.portals[portalName] = &pgPortal{stmt: stmt, sql: sql}
+	return ps.c.writeMessage(pgMsgBindComplete)
+}
+
+// executePortal execute statement of portal at the first time
+func (ps *pgSession) executePortal(p *pgPortal) error {
+	if p.executed || p.sql == "" {
+		return nil
+	}
+	r, tag, err := ps.execute(p.sql, p.stmt.stmtType)
+	if err != nil {
+		return err
+	}
+	p.executed, p.result, p.tag = true, r, tag
+	return nil
+}
+
+// handleDescribe describe params of statement or rows of portal, rows of statement are not described
+// since they are known only after execution
+func (ps *pgSession) handleDescribe(r *pgReader) error {
+	typ, name := r.byte(), r.string()
+	if r.err != nil {
+		return newPGError("08P01", "%v", r.err)
+	}
+	if typ == 'S' {
+		stmt, ok := ps.statements[name]
+		if !ok {
+			return newPGError("26000", "prepared statement \"%s\" does not exist", name)
+		}
+		ps.c.startMessage(pgMsgParameterDescription)
+		ps.c.writeInt16(int16(len(stmt.paramTypes)))
+		for _, oid := range stmt.paramTypes {
+			if oid == pgTypeUnknown {
+				oid = pgTypeText
+			}
+			ps.c.writeInt32(int32(oid))
+		}
+		if err := ps.c.finishMessage(); err != nil {
+			return err
+		}
+		return ps.c.writeMessage(pgMsgNoData)
+	}
+
+	p, ok := ps.portals[name]
+	if !ok {
+		return newPGError("34000", "portal \"%s\" does not exist", name)
+	}
+	if err := ps.executePortal(p); err != nil {
+		return err
+	}
+	if p.result == nil {
+		return ps.c.writeMessage(pgMsgNoData)
+	}
+	return ps.c.writeRowDescription(p.result.Fields)
+}
+
+func (ps *pgSession) handleExecute(r *pgReader) error {
+	name, max := r.string(), int(r.int32())
+	if r.err != nil {
+		return newPGError("08P01", "%v", r.err)
+	}
+	p, ok := ps.portals[name]
+	if !ok {
+		return newPGError("34000", "portal \"%s\" does not exist", name)
+	}
+	if p.sql == "" {
+		return ps.c.writeMessage(pgMsgEmptyQueryResponse)
+	}
+	if err := ps.executePortal(p); err != nil {
+		return err
+	}
+	if p.result == nil {
+		return ps.c.writeCommandComplete(p.tag)
+	}
+	pos, err := ps.writeRows(p.result, p.pos, max)
+	if err != nil {
+		return err
+	}
+	count := pos - p.pos
+	p.pos = pos
+	if pos < len(p.result.RowDatas) {
+		return ps.c.writeMessage(pgMsgPortalSuspended)
+	}
+	return ps.c.writeCommandComplete(fmt.Sprintf("SELECT %d", count))
+}
+
+func (ps *pgSession) handleClose(r *pgReader) error {
+	typ, name := r.byte(), r.string()
+	if r.err != nil {
+		return newPGError("08P01", "%v", r.err)
+	}
+	if typ == 'S' {
+		delete(ps.statements, name)
+	} else {
+		delete(ps.portals, name)
+	}
+	return ps.c.writeMessage(pgMsgCloseComplete)
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+)
+
+// max number of plans of sqls with the same fingerprint
+const maxPlansPerFingerprint = 16
+
+// planCacheEntry plans of sqls with the same fingerprint, the LRU cache of namespace is keyed by fingerprint,
+// plans are matched by the whole sql because route result and rewritten sqls depend on values in sql.
+type planCacheEntry struct {
+	sync.RWMutex
+	plans map[string]plan.Plan // key: sql
+	sqls  []string             // in insertion order, the oldest plan is removed if the entry is full
+}
+
+func newPlanCacheEntry() *planCacheEntry {
+	return &planCacheEntry{plans: make(map[string]plan.Plan)}
+}
+
+// Size implement cache.Value
+func (e *planCacheEntry) Size() int {
+	return 1
+}
+
+func (e *planCacheEntry) get(sql string) (plan.Plan, bool) {
+	e.RLock()
+	defer e.RUnlock()
+	p, ok := e.plans[sql]
+	return p, ok
+}
+
+// getSQLs return sqls of cached plans in insertion order
+func (e *planCacheEntry) getSQLs() []string {
+	e.RLock()
+	defer e.RUnlock()
+	sqls := make([]string, len(e.sqls))
+	copy(sqls, e.sqls)
+	return sqls
+}
+
+func (e *planCacheEntry) set(sql string, p plan.Plan) {
+	e.Lock()
+	defer e.Unlock()
+	if _, ok := e.plans[sql]; ok {
+		return
+	}
+	if len(e.sqls) >= maxPlansPerFingerprint {
+		delete(e.plans, e.sqls[0])
+		e.sqls = e.sqls[1:]
+	}
+	e.plans[sql] = p
+	e.sqls = append(e.sqls, sql)
+}
+
+func planCacheKey(db, sql string) string {
+	return db + "|" + mysql.GetMd5(mysql.GetFingerprint(sql))
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPlanWithCache(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+
+	sql := "select * from tbl_ks where id = 1"
+	p1, err := se.getPlan(ns, se.db, sql)
+	assert.Nil(t, err)
+	p2, err := se.getPlan(ns, se.db, sql)
+	assert.Nil(t, err)
+	assert.True(t, p1 == p2, "plan of the same sql should be reused")
+
+	// same fingerprint, different route result
+	p3, err := se.getPlan(ns, se.db, "select * from tbl_ks where id = 2")
+	assert.Nil(t, err)
+	assert.False(t, p1 == p3)
+	_, ok := ns.planCache.Get(planCacheKey(se.db, sql))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), ns.planCache.Length())
+
+	// write plan is not cached
+	insert := "insert into tbl_ks (id, c) values (1, 'a')"
+	_, err = se.getPlan(ns, se.db, insert)
+	assert.Nil(t, err)
+	_, ok = ns.GetCachedPlan(se.db, insert)
+	assert.False(t, ok)
+
+	ns.ClearPlanCache()
+	_, ok = ns.GetCachedPlan(se.db, sql)
+	assert.False(t, ok)
+}
+
+func TestPlanCacheEntryEviction(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+
+	var sqls []string
+	for i := 0; i <= maxPlansPerFingerprint; i++ {
+		sql := fmt.Sprintf("select * from tbl_ks where id = %d", i)
+		_, err := se.getPlan(ns, se.db, sql)
+		assert.Nil(t, err)
+		sqls = append(sqls, sql)
+	}
+
+	// the oldest plan is evicted
+	_, ok := ns.GetCachedPlan(se.db, sqls[0])
+	assert.False(t, ok)
+	for _, sql := range sqls[1:] {
+		_, ok := ns.GetCachedPlan(se.db, sql)
+		assert.True(t, ok, sql)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"time"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// results of statements returned by gRPC and http query api
+
+// QueryField column definition of resultset
+type QueryField struct {
+	Name  string `json:"name"`
+	Table string `json:"table"`
+	Type  uint8  `json:"type"` // mysql column type
+	Flag  uint16 `json:"flag"`
+}
+
+// QueryResult result of statement in json, rows of StreamExecute of gRPC are sent in many results
+type QueryResult struct {
+	Fields       []*QueryField   `json:"fields,omitempty"`
+	Rows         [][]interface{} `json:"rows,omitempty"`
+	RowsAffected uint64          `json:"rows_affected"`
+	InsertID     uint64          `json:"insert_id"`
+}
+
+// QueryError error of statement
+type QueryError struct {
+	Code    uint16 `json:"code"`
+	State   string `json:"state"`
+	Message string `json:"message"`
+}
+
+func newQueryError(err error) *QueryError {
+	if e, ok := err.(*mysql.SQLError); ok {
+		return &QueryError{Code: e.SQLCode(), State: e.SQLState(), Message: e.Message}
+	}
+	return &QueryError{Code: mysql.ErrUnknown, State: mysql.DefaultMySQLState, Message: err.Error()}
+}
+
+func newQueryFields(fields []*mysql.Field) []*QueryField {
+	ret := make([]*QueryField, 0, len(fields))
+	for _, f := range fields {
+		ret = append(ret, &QueryField{Name: string(f.Name), Table: string(f.Table), Type: f.Type, Flag: f.Flag})
+	}
+	return ret
+}
+
+// queryRow convert values of row to json values by column types, bytes are converted to string
+func queryRow(values []interface{}, fields []*mysql.Field) []interface{} {
+	row := make([]interface{}, len(values))
+	for i, v := range values {
+		if i < len(fields) {
+			v = queryValue(v, fields[i])
+		}
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		row[i] = v
+	}
+	return row
+}
+
+// queryValue convert value by mysql.ToNative, so DECIMAL is json number in the scale of column, BIT(1) is bool
+// and JSON is json value. Temporal values are kept in MySQL format, values which can't be converted are not changed.
+func queryValue(v interface{}, f *mysql.Field) interface{} {
+	if mysql.IsTemporalType(f.Type) {
+		return v
+	}
+	n, err := mysql.ToNative(v, f, time.UTC)
+	if err != nil {
+		return v
+	}
+	return n
+}
+
+// newQueryResult convert result of statement, only the first result of statements returning multiple results is kept
+func newQueryResult(r *mysql.Result) *QueryResult {
+	ret := &QueryResult{}
+	if r == nil {
+		return ret
+	}
+	ret.RowsAffected, ret.InsertID = r.AffectedRows, r.InsertID
+	if r.Resultset != nil {
+		ret.Fields = newQueryFields(r.Fields)
+		ret.Rows = make([][]interface{}, 0, len(r.Values))
+		for _, values := range r.Values {
+			ret.Rows = append(ret.Rows, queryRow(values, r.Fields))
+		}
+	}
+	return ret
+}
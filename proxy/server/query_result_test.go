@@ -0,0 +1,64 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewQueryResult(t *testing.T) {
+	r := &mysql.Result{
+		AffectedRows: 2,
+		Resultset: &mysql.Resultset{
+			Fields: []*mysql.Field{{Name: []byte("id"), Table: []byte("tbl"), Type: mysql.TypeLonglong}, {Name: []byte("name"), Type: mysql.TypeVarString}},
+			Values: [][]interface{}{{int64(1), []byte("a")}, {int64(2), nil}},
+		},
+	}
+	ret := newQueryResult(r)
+	assert.Equal(t, uint64(2), ret.RowsAffected)
+	assert.Equal(t, &QueryField{Name: "id", Table: "tbl", Type: mysql.TypeLonglong}, ret.Fields[0])
+	assert.Equal(t, [][]interface{}{{int64(1), "a"}, {int64(2), nil}}, ret.Rows)
+
+	data, err := json.Marshal(ret.Rows)
+	assert.Nil(t, err)
+	assert.Equal(t, `[[1,"a"],[2,null]]`, string(data))
+
+	assert.Equal(t, &QueryResult{}, newQueryResult(nil))
+}
+
+func TestQueryRowNativeTypes(t *testing.T) {
+	fields := []*mysql.Field{
+		{Type: mysql.TypeNewDecimal, Decimal: 2},
+		{Type: mysql.TypeBit, ColumnLength: 1, Charset: uint16(mysql.BinaryCollationID)},
+		{Type: mysql.TypeJSON, Charset: uint16(mysql.BinaryCollationID)},
+		{Type: mysql.TypeDatetime},
+		{Type: mysql.TypeBlob, Charset: uint16(mysql.BinaryCollationID)},
+		{Type: mysql.TypeJSON, Charset: uint16(mysql.BinaryCollationID)},
+	}
+	row := queryRow([]interface{}{1.5, []byte{1}, []byte(`{"a":[1]}`), "2020-01-02 03:04:05", []byte("b"), []byte("{")}, fields)
+	data, err := json.Marshal(row)
+	assert.Nil(t, err)
+	assert.Equal(t, `[1.50,true,{"a":[1]},"2020-01-02 03:04:05","b","{"]`, string(data))
+}
+
+func TestNewQueryError(t *testing.T) {
+	e := newQueryError(mysql.NewError(mysql.ErrNoDB, "No database selected"))
+	assert.Equal(t, uint16(mysql.ErrNoDB), e.Code)
+	assert.Equal(t, "No database selected", e.Message)
+}
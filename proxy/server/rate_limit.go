@@ -0,0 +1,91 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// kinds of rate limit
+const (
+	rateLimitRead = iota
+	rateLimitWrite
+	rateLimitScatter
+	rateLimitKinds
+)
+
+var rateLimitNames = [rateLimitKinds]string{"read_qps", "write_qps", "scatter_qps"}
+
+// rateLimiters limiters of each kind, nil means no limit
+type rateLimiters [rateLimitKinds]*util.RateLimiter
+
+func newRateLimiters(cfg *models.RateLimit) rateLimiters {
+	var limiters rateLimiters
+	if cfg == nil {
+		return limiters
+	}
+	for kind, qps := range [rateLimitKinds]int64{cfg.ReadQPS, cfg.WriteQPS, cfg.ScatterQPS} {
+		if qps > 0 {
+			limiters[kind] = util.NewRateLimiter(qps)
+		}
+	}
+	return limiters
+}
+
+// getRateLimitKind return kind of rate limit of statement, return false if the statement is not limited
+func getRateLimitKind(stmtType parser.StatementType) (int, bool) {
+	switch stmtType {
+	case parser.StmtSelect:
+		return rateLimitRead, true
+	case parser.StmtInsert, parser.StmtReplace, parser.StmtUpdate, parser.StmtDelete, parser.StmtDDL:
+		return rateLimitWrite, true
+	default:
+		return 0, false
+	}
+}
+
+// checkRateLimit take one token from limiters of user and namespace,
+// return ER_USER_LIMIT_REACHED if queries per second of the kind exceed the limit
+func (n *Namespace) checkRateLimit(user string, kind int) error {
+	if up, ok := n.userProperties[user]; ok {
+		if l := up.rateLimiters[kind]; l != nil && !l.Allow() {
+			return mysql.NewDefaultError(mysql.ErrUserLimitReached, user, rateLimitNames[kind], l.Rate())
+		}
+	}
+	if l := n.rateLimiters[kind]; l != nil && !l.Allow() {
+		return mysql.NewDefaultError(mysql.ErrUserLimitReached, user, "namespace "+rateLimitNames[kind], l.Rate())
+	}
+	return nil
+}
+
+// checkStmtRateLimit check read or write limit of statement
+func (se *SessionExecutor) checkStmtRateLimit(stmtType parser.StatementType) error {
+	kind, ok := getRateLimitKind(stmtType)
+	if !ok {
+		return nil
+	}
+	return se.GetNamespace().checkRateLimit(se.user, kind)
+}
+
+// checkScatterRateLimit check scatter limit before sqls are sent to more than one shard
+func (se *SessionExecutor) checkScatterRateLimit(shardCount int) error {
+	if shardCount <= 1 {
+		return nil
+	}
+	return se.GetNamespace().checkRateLimit(se.user, rateLimitScatter)
+}
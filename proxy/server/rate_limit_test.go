@@ -0,0 +1,65 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+func assertUserLimitReached(t *testing.T, err error) {
+	sqlErr, ok := err.(*mysql.SQLError)
+	if assert.True(t, ok, "%v", err) {
+		assert.Equal(t, uint16(mysql.ErrUserLimitReached), sqlErr.SQLCode())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	ns := se.GetNamespace()
+	ns.userProperties[se.user].rateLimiters = newRateLimiters(&models.RateLimit{ReadQPS: 2})
+	ns.rateLimiters = newRateLimiters(&models.RateLimit{WriteQPS: 1, ScatterQPS: 1})
+
+	// read limit of user
+	assert.Nil(t, se.checkStmtRateLimit(parser.StmtSelect))
+	assert.Nil(t, se.checkStmtRateLimit(parser.StmtSelect))
+	assertUserLimitReached(t, se.checkStmtRateLimit(parser.StmtSelect))
+	_, err = se.handleQuery("select * from tbl_ks where id = 1")
+	assertUserLimitReached(t, err)
+
+	// write limit of namespace
+	assert.Nil(t, se.checkStmtRateLimit(parser.StmtDelete))
+	assertUserLimitReached(t, se.checkStmtRateLimit(parser.StmtInsert))
+
+	// statements not limited
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, se.checkStmtRateLimit(parser.StmtSet))
+		assert.Nil(t, se.checkStmtRateLimit(parser.StmtBegin))
+	}
+
+	// scatter limit only applies to statements routed to more than one shard
+	assert.Nil(t, se.checkScatterRateLimit(1))
+	assert.Nil(t, se.checkScatterRateLimit(4))
+	assert.Nil(t, se.checkScatterRateLimit(1))
+	assertUserLimitReached(t, se.checkScatterRateLimit(2))
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+// Session read consistency: GTID of the last transaction committed by the session in each slice is reported by
+// master in OK packet (session_track_gtids = OWN_GTID). Before reading from slave, the session waits until the GTID
+// is applied in the slave, and reads from master if it's not applied in time.
+
+// seconds to wait for GTID of the session applied in slave
+const gtidWaitTimeout = 1
+
+// enableGTIDTracking make master report GTID of transactions committed by the session if session consistency is enabled
+func (se *SessionExecutor) enableGTIDTracking() {
+	ns := se.GetNamespace()
+	if ns == nil || !ns.isSessionConsistency() {
+		return
+	}
+	if err := se.sessionVariables.Set(mysql.SessionTrackGTIDsStr, "OWN_GTID"); err != nil {
+		exeLogger.Warnf("enable session_track_gtids error, namespace: %s, err: %v", se.namespace, err)
+	}
+}
+
+// trackGTIDs record GTID of the transaction committed in backend connection of slice
+func (se *SessionExecutor) trackGTIDs(slice string, pc backend.PooledConnect) {
+	if ns := se.GetNamespace(); pc == nil || ns == nil || !ns.isSessionConsistency() {
+		return
+	}
+	gtids := pc.TakeGTIDs()
+	if gtids == "" {
+		return
+	}
+	se.gtidLock.Lock()
+	se.gtids[slice] = gtids
+	se.gtidLock.Unlock()
+}
+
+func (se *SessionExecutor) getGTIDs(slice string) string {
+	se.gtidLock.Lock()
+	defer se.gtidLock.Unlock()
+	return se.gtids[slice]
+}
+
+// waitForGTIDs wait until writes of the session are applied in slave connection pc, if timeout or failed,
+// pc is recycled and connection of master is returned. pc of master is returned directly.
+func (se *SessionExecutor) waitForGTIDs(slice *backend.Slice, pc backend.PooledConnect) (backend.PooledConnect, error) {
+	if !se.GetNamespace().isSessionConsistency() || pc.GetAddr() == slice.Master.Addr() {
+		return pc, nil
+	}
+	gtids := se.getGTIDs(slice.GetSliceName())
+	if gtids == "" {
+		return pc, nil
+	}
+
+	sql := fmt.Sprintf("SELECT WAIT_FOR_EXECUTED_GTID_SET('%s', %d)", mysql.Escape(gtids), gtidWaitTimeout)
+	r, err := pc.Execute(sql)
+	if err == nil && r.Resultset != nil {
+		if ret, e := r.GetInt(0, 0); e == nil && ret == 0 {
+			return pc, nil
+		}
+	}
+	exeLogger.Warnf("wait for gtids in slave failed, read from master, namespace: %s, slice: %s, slave: %s, gtids: %s, err: %v",
+		se.namespace, slice.GetSliceName(), pc.GetAddr(), gtids, err)
+	if err != nil {
+		pc.Close()
+	}
+	pc.Recycle()
+	return slice.GetMasterConn()
+}
@@ -0,0 +1,294 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util/cache"
+)
+
+// Results of SELECT with result_cache hint or matching a rule of namespace are cached in proxy. Each table has a
+// version which is increased by writes to the table through the proxy, and a cached result is valid only if versions
+// of its tables are not changed since the SELECT started. Writes in transaction increase versions again on commit,
+// to drop results cached by other sessions before the transaction is committed.
+
+const (
+	resultCacheHit  = "hit"
+	resultCacheMiss = "miss"
+
+	// estimated bytes of field definition in cached result
+	resultCacheFieldSize = 128
+)
+
+// resultCache cache results of idempotent SELECT of a namespace in this proxy
+type resultCache struct {
+	entries       *cache.LRUCache
+	ttl           time.Duration
+	maxResultSize int
+	rules         map[string]time.Duration // key: md5 of sql fingerprint, value: ttl
+
+	mu          sync.Mutex
+	versions    map[string]uint64 // key: db.table in lower case
+	allVersions uint64            // increased when tables of write are unknown
+}
+
+// cachedResult result of SELECT with versions of its tables when the SELECT started
+type cachedResult struct {
+	result      *mysql.Result
+	size        int
+	expire      time.Time
+	tables      []string
+	versions    []uint64
+	allVersions uint64
+}
+
+// Size implement cache.Value
+func (c *cachedResult) Size() int {
+	return c.size
+}
+
+func newResultCache(cfg *models.ResultCache) *resultCache {
+	c := &resultCache{
+		ttl:           time.Duration(cfg.TTL) * time.Second,
+		maxResultSize: int(cfg.MaxResultSize),
+		rules:         make(map[string]time.Duration, len(cfg.Rules)),
+		versions:      make(map[string]uint64),
+	}
+	capacity := cfg.Capacity
+	if capacity == 0 {
+		capacity = models.DefaultResultCacheCapacity
+	}
+	c.entries = cache.NewLRUCache(capacity)
+	if c.ttl == 0 {
+		c.ttl = models.DefaultResultCacheTTL * time.Second
+	}
+	if c.maxResultSize == 0 {
+		c.maxResultSize = models.DefaultResultCacheMaxResultSize
+	}
+	for _, rule := range cfg.Rules {
+		ttl := c.ttl
+		if rule.TTL > 0 {
+			ttl = time.Duration(rule.TTL) * time.Second
+		}
+		c.rules[mysql.GetMd5(mysql.GetFingerprint(strings.TrimSpace(rule.SQL)))] = ttl
+	}
+	return c
+}
+
+// getTTL return ttl of caching result of sql, return false if the result should not be cached
+func (c *resultCache) getTTL(sql string) (time.Duration, bool) {
+	if ttl, ok := parser.ExtractResultCacheHint(sql); ok {
+		if ttl > 0 {
+			return time.Duration(ttl) * time.Second, true
+		}
+		return c.ttl, true
+	}
+	if len(c.rules) == 0 {
+		return 0, false
+	}
+	ttl, ok := c.rules[mysql.GetMd5(mysql.GetFingerprint(sql))]
+	return ttl, ok
+}
+
+// getVersions return current versions of tables
+func (c *resultCache) getVersions(tables []string) ([]uint64, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions := make([]uint64, len(tables))
+	for i, t := range tables {
+		versions[i] = c.versions[t]
+	}
+	return versions, c.allVersions
+}
+
+// isValid check if versions of tables are not changed since the result was read
+func (c *resultCache) isValid(entry *cachedResult) bool {
+	versions, allVersions := c.getVersions(entry.tables)
+	if allVersions != entry.allVersions {
+		return false
+	}
+	for i, v := range versions {
+		if v != entry.versions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// get return copy of cached result, nil if not found, expired or invalidated by writes
+func (c *resultCache) get(key string, now time.Time) *mysql.Result {
+	v, ok := c.entries.Get(key)
+	if !ok {
+		return nil
+	}
+	entry := v.(*cachedResult)
+	if now.After(entry.expire) || !c.isValid(entry) {
+		c.entries.Delete(key)
+		return nil
+	}
+	r := *entry.result
+	return &r
+}
+
+// set cache copy of result with versions of its tables read before executing the SELECT
+func (c *resultCache) set(key string, r *mysql.Result, ttl time.Duration, tables []string, versions []uint64, allVersions uint64) bool {
+	size := len(key) + len(r.Fields)*resultCacheFieldSize
+	for _, row := range r.RowDatas {
+		size += len(row)
+	}
+	if size > c.maxResultSize {
+		return false
+	}
+	result := *r
+	result.Status = 0
+	c.entries.Set(key, &cachedResult{
+		result:      &result,
+		size:        size,
+		expire:      time.Now().Add(ttl),
+		tables:      tables,
+		versions:    versions,
+		allVersions: allVersions,
+	})
+	return true
+}
+
+// invalidate increase versions of tables, versions of all tables are increased if tables is empty
+func (c *resultCache) invalidate(tables []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(tables) == 0 {
+		c.allVersions++
+		return
+	}
+	for _, t := range tables {
+		c.versions[t]++
+	}
+}
+
+// getResultCacheTables return tables in sql as db.table in lower case, return nil if sql can not be parsed
+func getResultCacheTables(db, sql string) []string {
+	tables := getAuditTables(sql)
+	for i, t := range tables {
+		if !strings.Contains(t, ".") {
+			t = db + "." + t
+		}
+		tables[i] = strings.ToLower(t)
+	}
+	return tables
+}
+
+// getResultCacheKey return key of result, result of the same sql may differ in users, databases, charsets and tenants
+func (se *SessionExecutor) getResultCacheKey(sql string) string {
+	return mysql.GetMd5(strings.Join([]string{se.user, se.db, se.charset, se.getTenantID(), sql}, "\x00"))
+}
+
+// doQueryWithResultCache return cached result of SELECT if it's valid, otherwise execute the statement, cache result
+// of SELECT and invalidate cached results of tables written by the statement
+func (se *SessionExecutor) doQueryWithResultCache(qc *QueryContext, next QueryHandler) (*mysql.Result, error) {
+	ns := se.GetNamespace()
+	c := ns.resultCache
+	if c == nil || se.isAdminSession() {
+		return next(qc)
+	}
+
+	sql, stmtType := qc.sql, qc.stmtType
+	if isWriteStmt(stmtType) || stmtType == parser.StmtDDL {
+		r, err := next(qc)
+		se.invalidateResultCache(c, stmtType, sql)
+		return r, err
+	}
+
+	// results in transaction may contain uncommitted writes, or be read from snapshot of the transaction
+	if stmtType != parser.StmtSelect || se.isInTransaction() {
+		return next(qc)
+	}
+	ttl, ok := c.getTTL(sql)
+	if !ok {
+		return next(qc)
+	}
+	tables := getResultCacheTables(se.db, sql)
+	if len(tables) == 0 {
+		return next(qc)
+	}
+
+	key := se.getResultCacheKey(sql)
+	if r := c.get(key, time.Now()); r != nil {
+		se.manager.GetStatisticManager().RecordResultCache(ns.GetName(), resultCacheHit)
+		modifyResultStatus(r, se)
+		return r, nil
+	}
+	se.manager.GetStatisticManager().RecordResultCache(ns.GetName(), resultCacheMiss)
+
+	versions, allVersions := c.getVersions(tables)
+	r, err := next(qc)
+	// streamed resultset has been written to client, and multiple results are not cached
+	if err != nil || se.streamed || r == nil || r.Resultset == nil || r.Next != nil {
+		return r, err
+	}
+	c.set(key, r, ttl, tables, versions, allVersions)
+	return r, nil
+}
+
+// invalidateResultCache invalidate cached results of tables written by statement, tables written in transaction
+// are recorded to be invalidated again on commit
+func (se *SessionExecutor) invalidateResultCache(c *resultCache, stmtType parser.StatementType, sql string) {
+	var tables []string
+	// tables written by stored procedure are unknown
+	if stmtType != parser.StmtCall {
+		tables = getResultCacheTables(se.db, sql)
+	}
+	c.invalidate(tables)
+
+	if !se.isInTransaction() {
+		return
+	}
+	if se.resultCacheTables == nil {
+		se.resultCacheTables = make(map[string]bool)
+	}
+	if len(tables) == 0 {
+		se.resultCacheTables[""] = true
+	}
+	for _, t := range tables {
+		se.resultCacheTables[t] = true
+	}
+}
+
+// invalidateTransactionResultCache invalidate cached results of tables written in transaction after it's finished
+func (se *SessionExecutor) invalidateTransactionResultCache() {
+	written := se.resultCacheTables
+	se.resultCacheTables = nil
+	if len(written) == 0 {
+		return
+	}
+	c := se.GetNamespace().resultCache
+	if c == nil {
+		return
+	}
+	if written[""] {
+		c.invalidate(nil)
+		return
+	}
+	tables := make([]string, 0, len(written))
+	for t := range written {
+		tables = append(tables, t)
+	}
+	c.invalidate(tables)
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+func TestResultCacheTTL(t *testing.T) {
+	c := newResultCache(&models.ResultCache{
+		TTL:   30,
+		Rules: []*models.ResultCacheRule{{SQL: "select name from tbl_user where id = 1", TTL: 300}},
+	})
+	tests := []struct {
+		sql    string
+		ttl    time.Duration
+		cached bool
+	}{
+		{"/*+ result_cache */ select * from t", 30 * time.Second, true},
+		{"/*+ RESULT_CACHE(10) */ select * from t", 10 * time.Second, true},
+		{"/*+ MAX_EXECUTION_TIME(100) result_cache() */ select * from t", 30 * time.Second, true},
+		{"select /*+ result_cache */ * from t", 0, false},
+		{"/* result_cache */ select * from t", 0, false},
+		{"select name from tbl_user where id = 12", 300 * time.Second, true},
+		{"select name from tbl_user where id in (12)", 0, false},
+		{"select * from t", 0, false},
+	}
+	for _, test := range tests {
+		ttl, ok := c.getTTL(test.sql)
+		assert.Equal(t, test.cached, ok, test.sql)
+		assert.Equal(t, test.ttl, ttl, test.sql)
+	}
+}
+
+func TestResultCacheInvalidate(t *testing.T) {
+	c := newResultCache(&models.ResultCache{})
+	r := &mysql.Result{
+		Status:    mysql.ServerStatusAutocommit,
+		Resultset: &mysql.Resultset{RowDatas: []mysql.RowData{[]byte("a")}},
+	}
+
+	tables := getResultCacheTables("db1", "select * from T1 join db2.t2 on T1.id = t2.id")
+	assert.Equal(t, []string{"db1.t1", "db2.t2"}, tables)
+
+	// not cached if written during the select
+	versions, allVersions := c.getVersions(tables)
+	c.invalidate(getResultCacheTables("db2", "update t2 set a = 1"))
+	assert.True(t, c.set("k1", r, time.Minute, tables, versions, allVersions))
+	assert.Nil(t, c.get("k1", time.Now()))
+
+	versions, allVersions = c.getVersions(tables)
+	assert.True(t, c.set("k1", r, time.Minute, tables, versions, allVersions))
+	cached := c.get("k1", time.Now())
+	assert.NotNil(t, cached)
+	assert.Equal(t, uint16(0), cached.Status)
+	assert.Equal(t, r.Resultset, cached.Resultset)
+	assert.Nil(t, c.get("k1", time.Now().Add(2*time.Minute)))
+
+	assert.True(t, c.set("k1", r, time.Minute, tables, versions, allVersions))
+	c.invalidate(getResultCacheTables("db1", "delete from t3"))
+	assert.NotNil(t, c.get("k1", time.Now()))
+	c.invalidate(getResultCacheTables("db2", "insert into db1.t1 values (1)"))
+	assert.Nil(t, c.get("k1", time.Now()))
+
+	// tables of write are unknown
+	versions, allVersions = c.getVersions(tables)
+	assert.True(t, c.set("k1", r, time.Minute, tables, versions, allVersions))
+	c.invalidate(nil)
+	assert.Nil(t, c.get("k1", time.Now()))
+
+	// result larger than max_result_size
+	c.maxResultSize = 2
+	assert.False(t, c.set("k1", r, time.Minute, tables, versions, allVersions))
+}
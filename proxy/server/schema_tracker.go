@@ -0,0 +1,249 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/logging"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/schema"
+)
+
+// max time to wait for loading schema of new namespace when reloading config
+const schemaLoadTimeout = 10 * time.Second
+
+// schemaTracker load column definitions of logical tables from backend into schema of router,
+// unshard tables are loaded from default physical db in default slice, sharding tables are loaded from the first sub table.
+type schemaTracker struct {
+	ns       *Namespace
+	schema   *schema.Schema
+	interval time.Duration // 0 means no periodic refresh
+
+	mu      sync.Mutex // only one refresh at the same time
+	notify  chan struct{}
+	closeCh chan struct{}
+	once    sync.Once
+	loaded  chan struct{} // closed after the first refresh
+
+	// execute sql in master of slice, replaced in test
+	execute func(slice, sql string) (*mysql.Result, error)
+}
+
+func newSchemaTracker(ns *Namespace, s *schema.Schema, interval time.Duration) *schemaTracker {
+	t := &schemaTracker{
+		ns:       ns,
+		schema:   s,
+		interval: interval,
+		notify:   make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+		loaded:   make(chan struct{}),
+	}
+	t.execute = t.executeInMaster
+	return t
+}
+
+// isEnabled check if column definitions are refreshed automatically
+func (t *schemaTracker) isEnabled() bool {
+	return t.interval > 0
+}
+
+// start refresh schema periodically, the first refresh is executed at once
+func (t *schemaTracker) start() {
+	if !t.isEnabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		first := true
+		for {
+			if err := t.refresh(); err != nil {
+				logging.DefaultLogger.Warnf("refresh schema of namespace %s error: %v", t.ns.GetName(), err)
+			}
+			if first {
+				close(t.loaded)
+				first = false
+			}
+			select {
+			case <-ticker.C:
+			case <-t.notify:
+			case <-t.closeCh:
+				return
+			}
+		}
+	}()
+}
+
+// triggerRefresh refresh schema in background, e.g. after DDL, the request is ignored if a refresh is pending
+func (t *schemaTracker) triggerRefresh() {
+	if !t.isEnabled() {
+		return
+	}
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// waitLoaded wait for the first refresh to finish, return false if timeout
+func (t *schemaTracker) waitLoaded(timeout time.Duration) bool {
+	if !t.isEnabled() {
+		return true
+	}
+	select {
+	case <-t.loaded:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (t *schemaTracker) close() {
+	t.once.Do(func() {
+		close(t.closeCh)
+	})
+}
+
+// refresh load column definitions of all the logical tables, tables of db are replaced only if all of them are loaded
+func (t *schemaTracker) refresh() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rt := t.ns.GetRouter()
+	phyDBs := t.ns.GetPhysicalDBs()
+
+	// physical dbs to load in each slice
+	sliceDBs := make(map[string]map[string]bool)
+	addDB := func(slice, phyDB string) {
+		if _, ok := sliceDBs[slice]; !ok {
+			sliceDBs[slice] = make(map[string]bool)
+		}
+		sliceDBs[slice][phyDB] = true
+	}
+	for db, phyDB := range phyDBs {
+		addDB(backend.DefaultSlice, phyDB)
+		for _, rule := range rt.GetShardRules(db) {
+			index := rule.GetSubTableIndexes()[0]
+			phyDB, err := rule.GetDatabaseNameByTableIndex(index)
+			if err != nil {
+				return err
+			}
+			addDB(rule.GetSlice(rule.GetSliceIndexFromTableIndex(index)), phyDB)
+		}
+	}
+
+	columns := make(map[string]map[string][]*schema.Column) // key: slice.phyDB, table
+	for slice, dbs := range sliceDBs {
+		names := make([]string, 0, len(dbs))
+		for db := range dbs {
+			names = append(names, db)
+		}
+		sort.Strings(names)
+		if err := t.loadColumns(slice, names, columns); err != nil {
+			return err
+		}
+	}
+
+	changed := false
+	for db, phyDB := range phyDBs {
+		rules := rt.GetShardRules(db)
+		subTables := make(map[string]bool)
+		for table, rule := range rules {
+			for _, index := range rule.GetSubTableIndexes() {
+				subTables[getPhysicalTableName(rule, table, index)] = true
+			}
+		}
+
+		tables := make(map[string]*schema.Table)
+		for table, cols := range columns[backend.DefaultSlice+"."+phyDB] {
+			if _, ok := rules[table]; ok || subTables[table] {
+				continue
+			}
+			tables[table] = schema.NewTable(cols)
+		}
+		for table, rule := range rules {
+			index := rule.GetSubTableIndexes()[0]
+			slice := rule.GetSlice(rule.GetSliceIndexFromTableIndex(index))
+			rulePhyDB, _ := rule.GetDatabaseNameByTableIndex(index)
+			if cols, ok := columns[slice+"."+rulePhyDB][getPhysicalTableName(rule, table, index)]; ok {
+				tables[table] = schema.NewTable(cols)
+			}
+		}
+		if t.schema.SetTables(db, tables) {
+			changed = true
+		}
+	}
+
+	// cached plans may be built with the old columns, e.g. expansion of SELECT *
+	if changed {
+		t.ns.ClearPlanCache()
+	}
+	return nil
+}
+
+// loadColumns load columns of all the tables in physical dbs of slice
+func (t *schemaTracker) loadColumns(slice string, phyDBs []string, columns map[string]map[string][]*schema.Column) error {
+	values := make([]string, 0, len(phyDBs))
+	for _, db := range phyDBs {
+		values = append(values, "'"+mysql.Escape(db)+"'")
+	}
+	sql := fmt.Sprintf("SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE, EXTRA FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA IN (%s) ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION", strings.Join(values, ","))
+	r, err := t.execute(slice, sql)
+	if err != nil {
+		return fmt.Errorf("load columns from slice %s error: %v", slice, err)
+	}
+	if r.Resultset == nil {
+		return nil
+	}
+
+	for _, row := range r.Values {
+		if len(row) < 7 {
+			return fmt.Errorf("invalid columns of information_schema.COLUMNS from slice %s", slice)
+		}
+		key := slice + "." + resultValueString(row[0])
+		table := resultValueString(row[1])
+		if _, ok := columns[key]; !ok {
+			columns[key] = make(map[string][]*schema.Column)
+		}
+		columns[key][table] = append(columns[key][table], &schema.Column{
+			Name:          resultValueString(row[2]),
+			DataType:      resultValueString(row[3]),
+			ColumnType:    resultValueString(row[4]),
+			Nullable:      strings.EqualFold(resultValueString(row[5]), "YES"),
+			AutoIncrement: strings.Contains(strings.ToLower(resultValueString(row[6])), "auto_increment"),
+		})
+	}
+	return nil
+}
+
+func (t *schemaTracker) executeInMaster(slice, sql string) (*mysql.Result, error) {
+	s := t.ns.GetSlice(slice)
+	if s == nil {
+		return nil, fmt.Errorf("slice %s not found", slice)
+	}
+	conn, err := s.GetMasterConn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Recycle()
+	return conn.Execute(sql)
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+func prepareSchemaTracker(t *testing.T) (*SessionExecutor, []string) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+
+	columns := map[string][][]interface{}{
+		"slice-0": {
+			{"db_ks", "tbl_a", "id", "int", "int(11)", "NO", "auto_increment"},
+			{"db_ks", "tbl_a", "name", "varchar", "varchar(64)", "YES", ""},
+			{"db_ks", "tbl_ks_0000", "id", "bigint", "bigint(20) unsigned", "NO", ""},
+			{"db_ks", "tbl_ks_0000", "c", "varchar", "varchar(64)", "YES", ""},
+			{"db_ks", "tbl_ks_0001", "id", "bigint", "bigint(20) unsigned", "NO", ""},
+			{"db_ks", "tbl_ks_0001", "c", "varchar", "varchar(64)", "YES", ""},
+			{"db_mycat_0", "tbl_m", "id", "int", "int(11)", "NO", ""},
+		},
+	}
+	var sqls []string
+	tracker := se.GetNamespace().schemaTracker
+	tracker.execute = func(slice, sql string) (*mysql.Result, error) {
+		sqls = append(sqls, slice+": "+sql)
+		rs, err := mysql.BuildResultset(nil, []string{"TABLE_SCHEMA", "TABLE_NAME", "COLUMN_NAME", "DATA_TYPE", "COLUMN_TYPE", "IS_NULLABLE", "EXTRA"}, columns[slice])
+		if err != nil {
+			return nil, err
+		}
+		return &mysql.Result{Resultset: rs}, nil
+	}
+	if err := se.GetNamespace().RefreshSchema(); err != nil {
+		t.Fatal("refresh schema error:", err)
+	}
+	return se, sqls
+}
+
+func TestSchemaTrackerRefresh(t *testing.T) {
+	se, sqls := prepareSchemaTracker(t)
+
+	// the first sub table of tbl_ks is in slice-0, only default slice is queried
+	assert.Equal(t, []string{"slice-0: SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE, EXTRA FROM information_schema.COLUMNS " +
+		"WHERE TABLE_SCHEMA IN ('db_ks','db_mycat_0') ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION"}, sqls)
+
+	s := se.GetNamespace().GetRouter().GetSchema()
+	table, ok := s.GetTable("db_ks", "tbl_ks")
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(table.Columns))
+	assert.True(t, table.Columns[0].IsUnsigned())
+
+	_, ok = s.GetTable("db_ks", "tbl_ks_0000")
+	assert.False(t, ok)
+	table, ok = s.GetTable("db_ks", "tbl_a")
+	assert.True(t, ok)
+	index, ok := table.AutoIncrementColumn()
+	assert.True(t, ok)
+	assert.Equal(t, "id", table.Columns[index].Name)
+	_, ok = s.GetTable("db_mycat", "tbl_m")
+	assert.True(t, ok)
+
+	// sharding value is checked with type of sharding column
+	rule, _ := se.GetNamespace().GetRouter().GetShardRule("db_ks", "tbl_ks")
+	index, err := rule.FindTableIndex([]byte("5"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, index)
+	_, err = rule.FindTableIndex("abc")
+	assert.NotNil(t, err)
+}
+
+func TestPrepareResultColumns(t *testing.T) {
+	se, _ := prepareSchemaTracker(t)
+
+	tests := []struct {
+		sql    string
+		expect []string // name of columns, nil if unknown
+	}{
+		{"select * from tbl_ks where id = ?", []string{"id", "c"}},
+		{"select id, name as n from tbl_a", []string{"id", "n"}},
+		{"select t.id from tbl_a t", []string{"id"}},
+		{"select id + 1 from tbl_a", nil},
+		{"select id from tbl_unknown", nil},
+		{"select a.id from tbl_a a join tbl_ks b on a.id = b.id", nil},
+	}
+	for _, test := range tests {
+		fields := se.prepareResultColumns(mustParseSelect(t, test.sql))
+		if test.expect == nil {
+			assert.Nil(t, fields, test.sql)
+			continue
+		}
+		var names []string
+		for _, f := range fields {
+			names = append(names, string(f.Name))
+		}
+		assert.Equal(t, test.expect, names, test.sql)
+	}
+
+	fields := se.prepareResultColumns(mustParseSelect(t, "select * from tbl_ks"))
+	assert.Equal(t, mysql.TypeLonglong, fields[0].Type)
+	assert.Equal(t, uint16(mysql.NotNullFlag|mysql.UnsignedFlag|mysql.BinaryFlag), fields[0].Flag)
+	assert.Equal(t, mysql.TypeVarString, fields[1].Type)
+	assert.Equal(t, "tbl_ks", string(fields[1].OrgTable))
+}
+
+func mustParseSelect(t *testing.T, sql string) *ast.SelectStmt {
+	n, err := parser.ParseSQL(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n.(*ast.SelectStmt)
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/provider"
+	"github.com/XiaoMi/Gaea/proxy/sequence"
+)
+
+// initSequence init proxy level config of global sequences.
+// Worker id of snowflake sequence is calculated from hostname and proxy address if not configured,
+// etcd sequences are only available when source type is etcd.
+func initSequence(cfg *models.Proxy) error {
+	workerID := cfg.SequenceWorkerID
+	if workerID == 0 {
+		hostname, _ := os.Hostname()
+		workerID = int64(crc32.ChecksumIEEE([]byte(hostname+cfg.ProxyAddr)) % (sequence.MaxSnowflakeWorkerID + 1))
+	}
+	if workerID < 0 || workerID > sequence.MaxSnowflakeWorkerID {
+		return fmt.Errorf("invalid sequence worker id %d, must be in [0, %d]", workerID, sequence.MaxSnowflakeWorkerID)
+	}
+
+	var allocator sequence.SegmentAllocator
+	if cfg.ConfigType == provider.ConfigEtcd {
+		a, err := sequence.NewEtcdSegmentAllocator(cfg.CoordinatorAddr, cfg.UserName, cfg.Password, path.Join(cfg.CoordinatorRoot, "sequence"))
+		if err != nil {
+			return fmt.Errorf("create etcd segment allocator error: %v", err)
+		}
+		allocator = a
+	}
+
+	sequence.Init(workerID, allocator)
+	return nil
+}
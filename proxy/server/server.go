@@ -24,26 +24,25 @@ import (
 	"time"
 
 	"fmt"
+	"github.com/XiaoMi/Gaea/audit"
 	"github.com/XiaoMi/Gaea/models"
 	"github.com/XiaoMi/Gaea/mysql"
-	"github.com/XiaoMi/Gaea/util"
 	"github.com/XiaoMi/Gaea/util/sync2"
 )
 
-var (
-	timeWheelUnit       = time.Second * 1
-	timeWheelBucketsNum = 3600
-)
-
 // Server means proxy that serve client request
 type Server struct {
 	closed         sync2.AtomicBool
 	listener       net.Listener
+	socketListener net.Listener // listener of unix socket, nil if not configured
 	sessionTimeout time.Duration
-	tw             *util.TimeWheel
 	adminServer    *AdminServer
+	grpcServer     *grpcServer // nil if grpc-addr is not configured
+	pgServer       *pgServer   // nil if pg-addr is not configured
 	manager        *Manager
 	EncryptKey     string
+
+	proxyProtocolNetworks *proxyProtocolNetworks // networks of L4 load balancers which send PROXY protocol header
 }
 
 // NewServer create new server
@@ -74,6 +73,18 @@ func NewServer(cfg *models.Proxy, manager *Manager) (*Server, error) {
 		return nil, err
 	}
 
+	if cfg.ProxySocket != "" {
+		s.socketListener, err = listenUnixSocket(cfg.ProxySocket)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.proxyProtocolNetworks, err = parseProxyProtocolNetworks(cfg.ProxyProtocolNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy protocol networks error: %v", err)
+	}
+
 	st := strconv.Itoa(cfg.SessionTimeout)
 	st = st + "s"
 	s.sessionTimeout, err = time.ParseDuration(st)
@@ -81,11 +92,23 @@ func NewServer(cfg *models.Proxy, manager *Manager) (*Server, error) {
 		return nil, err
 	}
 
-	s.tw, err = util.NewTimeWheel(timeWheelUnit, timeWheelBucketsNum)
-	if err != nil {
+	if cfg.GRPCAddr != "" {
+		s.grpcServer, err = newGRPCServer(cfg.GRPCAddr, manager, s.sessionTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.PGAddr != "" {
+		s.pgServer, err = newPGServer(cfg.PGAddr, manager, s.sessionTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = InitCachingSha2RSAKey(cfg.CachingSha2PasswordPrivateKey); err != nil {
 		return nil, err
 	}
-	s.tw.Start()
 
 	// create AdminServer
 	adminServer, err := NewAdminServer(s, cfg)
@@ -95,7 +118,7 @@ func NewServer(cfg *models.Proxy, manager *Manager) (*Server, error) {
 	}
 	s.adminServer = adminServer
 
-	logging.DefaultLogger.Infof("server start succ, netProtoType: %s, addr: %s", cfg.ProtoType, cfg.ProxyAddr)
+	logging.DefaultLogger.Infof("server start succ, netProtoType: %s, addr: %s, socket: %s, grpc: %s, pg: %s", cfg.ProtoType, cfg.ProxyAddr, cfg.ProxySocket, cfg.GRPCAddr, cfg.PGAddr)
 	return s, nil
 }
 
@@ -104,7 +127,14 @@ func (s *Server) Listener() net.Listener {
 	return s.listener
 }
 
-func (s *Server) onConn(c net.Conn) {
+func (s *Server) onConn(conn net.Conn) {
+	c, err := s.prepareClientConn(conn)
+	if err != nil {
+		logging.DefaultLogger.Warnf("[server] onConn error: %s", err.Error())
+		conn.Close()
+		return
+	}
+
 	cc := newSession(s, c) //新建一个conn
 	defer func() {
 		err := recover()
@@ -140,11 +170,21 @@ func (s *Server) onConn(c net.Conn) {
 		return
 	}
 
-	// added into time wheel
-	s.tw.Add(s.sessionTimeout, cc, func() {
-		cc.Close()
-		//conn.Close()
-	})
+	// check cluster-wide max connections of namespace and user
+	clusterState := s.manager.GetClusterState()
+	ns := cc.getNamespace()
+	if err := clusterState.AddSession(cc, ns.GetMaxConnections(), ns.GetUserMaxConnections(cc.executor.user)); err != nil {
+		logging.DefaultLogger.Warnf("[server] onConn error: %s", err.Error())
+		cc.c.writeErrorPacket(err)
+		return
+	}
+	defer clusterState.RemoveSession(cc)
+
+	cc.executor.recordConnectionAudit(audit.EventConnect)
+	defer cc.executor.recordConnectionAudit(audit.EventDisconnect)
+
+	// idle session is closed by eviction loop of server
+	cc.setActive(false)
 
 	cc.Run()
 }
@@ -156,8 +196,25 @@ func (s *Server) Run() error {
 
 	// start Server
 	s.closed.Set(false)
+	go s.evictIdleSessions()
+	if s.grpcServer != nil {
+		go s.grpcServer.run()
+	}
+	if s.pgServer != nil {
+		go s.pgServer.run()
+	}
+	if s.socketListener != nil {
+		go s.serve(s.socketListener)
+	}
+	s.serve(s.listener)
+
+	return nil
+}
+
+// serve accept client connections of listener until server is closed
+func (s *Server) serve(l net.Listener) {
 	for s.closed.Get() != true {
-		conn, err := s.listener.Accept()
+		conn, err := l.Accept()
 
 		if err != nil {
 			logging.DefaultLogger.Warnf("[server] listener accept error: %s", err.Error())
@@ -166,8 +223,6 @@ func (s *Server) Run() error {
 
 		go s.onConn(conn)
 	}
-
-	return nil
 }
 
 // Close close proxy server
@@ -177,6 +232,17 @@ func (s *Server) Close() error {
 	}
 
 	s.closed.Set(true)
+	if s.grpcServer != nil {
+		s.grpcServer.close()
+	}
+	if s.pgServer != nil {
+		s.pgServer.close()
+	}
+	if s.socketListener != nil {
+		if err := s.socketListener.Close(); err != nil {
+			return err
+		}
+	}
 	if s.listener != nil {
 		err := s.listener.Close()
 		if err != nil {
@@ -22,9 +22,11 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/XiaoMi/Gaea/mysql"
 	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/sync2"
 )
 
 /*
@@ -35,18 +37,26 @@ CLIENT_LONG_PASSWORD | CLIENT_LONG_FLAG | CLIENT_CONNECT_WITH_DB | CLIENT_PROTOC
 // DefaultCapability means default capability
 var DefaultCapability = mysql.ClientLongPassword | mysql.ClientLongFlag |
 	mysql.ClientConnectWithDB | mysql.ClientProtocol41 |
-	mysql.ClientTransactions | mysql.ClientSecureConnection | mysql.ClientPluginAuth | mysql.ClientPluginAuthLenencClientData
+	mysql.ClientTransactions | mysql.ClientSecureConnection | mysql.ClientPluginAuth | mysql.ClientPluginAuthLenencClientData |
+	mysql.ClientOptionalResultsetMetadata | mysql.ClientCompress | mysql.ClientLocalFiles | mysql.ClientMultiResults | mysql.ClientSessionTrack
 
 var baseConnID uint32 = 10000
 
+// write failures may be very frequent when backend or network is unstable, so log them with sampling
+var sessionSampledLogger = logging.GetSampledLogger("session")
+
 const initClientConnStatus = mysql.ServerStatusAutocommit
 
+// interval of checking if client is still alive while executing statement
+const clientAliveCheckInterval = time.Second
+
 // Session means session between client and proxy
 type Session struct {
 	sync.Mutex
 
-	c     *ClientConn
-	proxy *Server
+	c       *ClientConn
+	rawConn net.Conn
+	proxy   *Server
 
 	manager *Manager
 
@@ -56,28 +66,36 @@ type Session struct {
 
 	closed atomic.Value
 
-	cachingSha2FullAuth bool
+	idleSince sync2.AtomicInt64 // unix nano time when session starts waiting for next command, 0 means executing command
 }
 
 // create session between client<->proxy
 func newSession(s *Server, co net.Conn) *Session {
 	cc := new(Session)
-	tcpConn := co.(*net.TCPConn)
-
-	//SetNoDelay controls whether the operating system should delay packet transmission
-	// in hopes of sending fewer packets (Nagle's algorithm).
-	// The default is true (no delay),
-	// meaning that data is sent as soon as possible after a Write.
-	//I set this option false.
-	_ = tcpConn.SetNoDelay(true)
-	cc.c = NewClientConn(mysql.NewConn(tcpConn), s.manager)
+	raw := rawConn(co)
+	if tcpConn, ok := raw.(*net.TCPConn); ok {
+		//SetNoDelay controls whether the operating system should delay packet transmission
+		// in hopes of sending fewer packets (Nagle's algorithm).
+		// The default is true (no delay),
+		// meaning that data is sent as soon as possible after a Write.
+		//I set this option false.
+		_ = tcpConn.SetNoDelay(true)
+	}
+	cc.c = NewClientConn(mysql.NewConn(co), s.manager)
+	cc.rawConn = raw
 	cc.proxy = s
 	cc.manager = s.manager
 
 	cc.c.SetConnectionID(atomic.AddUint32(&baseConnID, 1))
 
 	cc.executor = newSessionExecutor(s.manager)
+	cc.executor.connID = cc.c.GetConnectionID()
 	cc.executor.clientAddr = co.RemoteAddr().String()
+	cc.executor.streamWriter = cc.c
+	cc.executor.infileReader = cc.c
+	if s.adminServer != nil {
+		cc.executor.reloadNamespace = s.adminServer.reloadNamespace
+	}
 	cc.closed.Store(false)
 	return cc
 }
@@ -162,6 +180,11 @@ func (cc *Session) Handshake() error {
 		return err
 	}
 
+	// compressed protocol takes effect after the handshake OK packet
+	if cc.c.capability&mysql.ClientCompress != 0 {
+		cc.c.EnableCompression()
+	}
+
 	return nil
 }
 
@@ -198,12 +221,14 @@ func (cc *Session) handleHandshakeResponse(info HandshakeResponseInfo) error {
 	if !ok {
 		return mysql.NewError(mysql.ErrInternal, "invalid collation")
 	}
-	cc.executor.SetCollationID(mysql.CollationID(collationID))
-	cc.executor.SetCharset(charset)
+	cc.executor.SetClientCollation(mysql.CollationID(collationID), charset)
 
 	// set database
 	cc.executor.SetDatabase(info.Database)
 
+	// capabilities supported by both client and proxy
+	cc.c.capability = info.Capability & DefaultCapability
+
 	// set namespace
 	namespace := cc.manager.GetNamespaceByUser(user, password)
 	cc.namespace = namespace
@@ -212,6 +237,70 @@ func (cc *Session) handleHandshakeResponse(info HandshakeResponseInfo) error {
 	return nil
 }
 
+// handleChangeUser handle COM_CHANGE_USER, authenticate with the new user,
+// reset session state and switch to namespace of the new user
+func (cc *Session) handleChangeUser(data []byte) error {
+	info, err := cc.c.readChangeUser(data)
+	if err != nil {
+		return err
+	}
+
+	// transactions and prepared statements belong to the old user
+	if err := cc.executor.resetSession(); err != nil {
+		logging.DefaultLogger.Warnf("executor rollback error when change user, connId: %d, err: %v", cc.c.GetConnectionID(), err)
+	}
+
+	user := info.User
+	password, found, _ := cc.GetCredential(user)
+	if !cc.manager.CheckUser(user) || !found {
+		return mysql.NewDefaultError(mysql.ErrAccessDenied, user, cc.c.RemoteAddr().String(), "Yes")
+	}
+	if err := cc.auth(info, password); err != nil {
+		return mysql.NewDefaultError(mysql.ErrAccessDenied, user, cc.c.RemoteAddr().String(), "Yes")
+	}
+
+	namespace := cc.manager.GetNamespaceByUser(user, password)
+	ns := cc.manager.GetNamespace(namespace)
+	if ns == nil {
+		return mysql.NewDefaultError(mysql.ErrAccessDenied, user, cc.c.RemoteAddr().String(), "Yes")
+	}
+	if info.Database != "" && !ns.IsAllowedDB(info.Database) && !(info.Database == adminDB && ns.IsAdminUser(user)) {
+		return mysql.NewDefaultError(mysql.ErrNoDB)
+	}
+
+	// move session to the new user in cluster state, and check max connections of the new user
+	clusterState := cc.manager.GetClusterState()
+	clusterState.RemoveSession(cc)
+	cc.manager.GetStatisticManager().DescSessionCount(cc.namespace)
+
+	cc.namespace = namespace
+	cc.executor.namespace = namespace
+	cc.executor.user = user
+	cc.c.namespace = namespace // TODO: remove it when refactor is done
+	cc.manager.GetStatisticManager().IncrSessionCount(namespace)
+
+	if !cc.IsAllowConnect() {
+		return mysql.NewError(mysql.ErrAccessDenied, "ip address access denied by gaea")
+	}
+	if err := clusterState.AddSession(cc, ns.GetMaxConnections(), ns.GetUserMaxConnections(user)); err != nil {
+		return err
+	}
+
+	if info.CollationID != 0 {
+		collationName, ok := mysql.Collations[info.CollationID]
+		if !ok {
+			return mysql.NewError(mysql.ErrInternal, "invalid collation")
+		}
+		charset, ok := mysql.CollationNameToCharset[collationName]
+		if !ok {
+			return mysql.NewError(mysql.ErrInternal, "invalid collation")
+		}
+		cc.executor.SetClientCollation(info.CollationID, charset)
+	}
+	cc.executor.SetDatabase(info.Database)
+	return nil
+}
+
 // Close close session with it's resources
 func (cc *Session) Close() {
 	if cc.IsClosed() {
@@ -221,12 +310,19 @@ func (cc *Session) Close() {
 	if err := cc.executor.rollback(); err != nil {
 		logging.DefaultLogger.Warnf("executor rollback error when Session close: %v", err)
 	}
+	cc.executor.unpinNamespace(true)
 	cc.c.Close()
 	logging.DefaultLogger.Debugf("client closed, %d", cc.c.GetConnectionID())
 
 	return
 }
 
+// Kill close the session by KILL, statements running in backend are killed and the transaction is rolled back
+func (cc *Session) Kill() {
+	cc.executor.cancelExecution()
+	cc.Close()
+}
+
 // IsClosed check if closed
 func (cc *Session) IsClosed() bool {
 	return cc.closed.Load().(bool)
@@ -244,7 +340,6 @@ func (cc *Session) Run() {
 			logging.DefaultLogger.Warnf("[server] Session Run panic error, error: %s, stack: %s", err.Error(), string(buf))
 		}
 		cc.Close()
-		cc.proxy.tw.Remove(cc)
 		cc.manager.GetStatisticManager().DescSessionCount(cc.namespace)
 	}()
 
@@ -257,16 +352,57 @@ func (cc *Session) Run() {
 			return
 		}
 
-		cc.proxy.tw.Add(cc.proxy.sessionTimeout, cc, cc.Close)
+		cc.setActive(true)
 		cc.manager.GetStatisticManager().AddReadFlowCount(cc.namespace, len(data))
 
 		cmd := data[0]
 		data = data[1:]
+
+		if cmd == mysql.ComChangeUser {
+			// authentication reads and writes packets, so the ephemeral packet must be recycled first
+			payload := make([]byte, len(data))
+			copy(payload, data)
+			cc.c.RecycleReadPacket()
+			if err = cc.handleChangeUser(payload); err != nil {
+				// the connection is not authenticated any more, close it like mysql does
+				logging.DefaultLogger.Warnf("[server] Session change user error, connId: %d, err: %v", cc.c.GetConnectionID(), err)
+				cc.c.writeErrorPacket(err)
+				cc.Close()
+				return
+			}
+			if err = cc.c.writeOK(cc.executor.GetStatus()); err != nil {
+				cc.Close()
+				return
+			}
+			continue
+		}
+
+		// session state is compared before and after the command to report changes to client
+		var state *sessionState
+		if cc.c.isSessionTrackSupported() {
+			state = cc.executor.getSessionState()
+		}
+
+		stopWatch := cc.watchClientDisconnect()
 		rs := cc.executor.ExecuteCommand(cmd, data)
+		stopWatch()
 		cc.c.RecycleReadPacket()
 
+		if state != nil {
+			rs.SessionState = cc.executor.getSessionStateChanges(state)
+		}
+
+		// client has gone away, close session to rollback transactions of shards
+		if cc.executor.isClientClosed() {
+			logging.DefaultLogger.Warnw("client disconnected while executing",
+				logging.FieldConnID, cc.c.GetConnectionID(), logging.FieldNamespace, cc.namespace)
+			cc.Close()
+			return
+		}
+
 		if err = cc.writeResponse(rs); err != nil {
-			logging.DefaultLogger.Warnf("Session write response error, connId: %d, err: %v", cc.c.GetConnectionID(), err)
+			sessionSampledLogger.Warnw("session write response error",
+				logging.FieldConnID, cc.c.GetConnectionID(), logging.FieldNamespace, cc.namespace, "err", err)
 			cc.Close()
 			return
 		}
@@ -274,6 +410,39 @@ func (cc *Session) Run() {
 		if cmd == mysql.ComQuit {
 			cc.Close()
 		}
+		cc.setActive(false)
+	}
+}
+
+// watchClientDisconnect poll the client socket while executing statement, and cancel the execution if client has gone away.
+// Polling is started after clientAliveCheckInterval, so short statements are not affected.
+// The returned function must be called after execution, it waits for the polling to stop,
+// so the socket will not be peeked concurrently with reading of next command.
+func (cc *Session) watchClientDisconnect() func() {
+	stopC := make(chan struct{})
+	doneC := make(chan struct{})
+	timer := time.AfterFunc(clientAliveCheckInterval, func() {
+		defer close(doneC)
+		ticker := time.NewTicker(clientAliveCheckInterval)
+		defer ticker.Stop()
+		for {
+			if !util.IsConnAlive(cc.rawConn) {
+				cc.executor.cancelExecution()
+				return
+			}
+			select {
+			case <-stopC:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+
+	return func() {
+		close(stopC)
+		if !timer.Stop() {
+			<-doneC
+		}
 	}
 }
 
@@ -284,9 +453,9 @@ func (cc *Session) writeResponse(r Response) error {
 	case RespResult:
 		rs := r.Data.(*mysql.Result)
 		if rs == nil {
-			return cc.c.writeOK(r.Status)
+			return cc.c.writeOKWithSessionState(0, 0, r.Status, r.SessionState)
 		}
-		return cc.c.writeOKResult(r.Status, r.Data.(*mysql.Result))
+		return cc.c.writeOKResult(r.Status, r.Data.(*mysql.Result), cc.executor.GetResultsetMetadata(), r.SessionState)
 	case RespPrepare:
 		stmt := r.Data.(*Stmt)
 		if stmt == nil {
@@ -313,7 +482,7 @@ func (cc *Session) writeResponse(r Response) error {
 		}
 		return nil
 	case RespOK:
-		return cc.c.writeOK(r.Status)
+		return cc.c.writeOKWithSessionState(0, 0, r.Status, r.SessionState)
 	case RespNoop:
 		return nil
 	default:
@@ -0,0 +1,252 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+// Session state tracking: session state is kept in proxy instead of backend connections, so changes are detected
+// by comparing the state before and after each command, and reported in OK packet to clients with CLIENT_SESSION_TRACK.
+
+const (
+	defaultSessionTrackSystemVariables = "time_zone,autocommit,character_set_client,character_set_results,character_set_connection"
+
+	sessionTrackTransactionInfoOff             = "OFF"
+	sessionTrackTransactionInfoState           = "STATE"
+	sessionTrackTransactionInfoCharacteristics = "CHARACTERISTICS"
+)
+
+// proxySystemVariables are system variables kept in session executor instead of session variables
+var proxySystemVariables = []string{
+	"autocommit", "character_set_client", "character_set_connection", "character_set_results", "collation_connection",
+	"transaction_read_only", "max_execution_time", "resultset_metadata", "session_track_schema",
+	"session_track_state_change", "session_track_system_variables", "session_track_transaction_info",
+}
+
+// sessionState is the tracked state of session
+type sessionState struct {
+	db                string
+	variables         map[string]string
+	txState           string
+	txCharacteristics string
+	others            string // user-defined variables and prepared statements, only for session_track_state_change
+}
+
+// getSessionState return the tracked state of session, only state tracked by session_track_xxx variables is collected
+func (se *SessionExecutor) getSessionState() *sessionState {
+	s := &sessionState{db: se.db, variables: make(map[string]string)}
+	for _, name := range se.getTrackedSystemVariables() {
+		if value, ok := se.getSystemVariableValue(name); ok {
+			s.variables[name] = value
+		}
+	}
+	if se.trackTransactionInfo != sessionTrackTransactionInfoOff {
+		s.txState = se.getTransactionState()
+	}
+	if se.trackTransactionInfo == sessionTrackTransactionInfoCharacteristics {
+		s.txCharacteristics = se.getTransactionCharacteristics()
+	}
+	if se.trackStateChange {
+		s.others = fmt.Sprintf("%v %d %d", se.userVariables, se.stmtID, len(se.stmts))
+	}
+	return s
+}
+
+// getSessionStateChanges return session state information of OK packet, which contains changes from the old state
+func (se *SessionExecutor) getSessionStateChanges(old *sessionState) []byte {
+	current := se.getSessionState()
+	var data []byte
+	if se.trackSchema && current.db != old.db {
+		data = mysql.AppendSessionTrackSchema(data, current.db)
+	}
+
+	names := make([]string, 0, len(current.variables))
+	for name := range current.variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := old.variables[name]; !ok || value != current.variables[name] {
+			data = mysql.AppendSessionTrackSystemVariable(data, name, current.variables[name])
+		}
+	}
+
+	if se.trackTransactionInfo != sessionTrackTransactionInfoOff && current.txState != old.txState {
+		data = mysql.AppendSessionTrackTransactionState(data, current.txState)
+	}
+	if se.trackTransactionInfo == sessionTrackTransactionInfoCharacteristics && current.txCharacteristics != old.txCharacteristics {
+		data = mysql.AppendSessionTrackTransactionCharacteristics(data, current.txCharacteristics)
+	}
+	if se.trackStateChange && (len(data) > 0 || current.others != old.others) {
+		data = mysql.AppendSessionTrackStateChange(data)
+	}
+	return data
+}
+
+// getTrackedSystemVariables return names of system variables tracked by session_track_system_variables
+func (se *SessionExecutor) getTrackedSystemVariables() []string {
+	if se.trackSystemVariables == "" {
+		return nil
+	}
+	if se.trackSystemVariables != "*" {
+		return strings.Split(se.trackSystemVariables, ",")
+	}
+	names := append([]string{}, proxySystemVariables...)
+	for name := range se.sessionVariables.GetAll() {
+		names = append(names, name)
+	}
+	return names
+}
+
+// getSystemVariableValue return value of session system variable, return false if the value is unknown in proxy
+func (se *SessionExecutor) getSystemVariableValue(name string) (string, bool) {
+	switch name {
+	case "autocommit":
+		return onOffString(se.isAutoCommit()), true
+	case "character_set_client", "character_set_connection", "character_set_results":
+		return se.charset, se.charset != ""
+	case "collation_connection":
+		collation, ok := mysql.Collations[se.collation]
+		return collation, ok
+	case "transaction_read_only", "tx_read_only":
+		return onOffString(se.txReadOnly), true
+	case "max_execution_time":
+		return strconv.FormatInt(se.maxExecutionTime, 10), true
+	case "resultset_metadata":
+		if se.resultsetMetadata == mysql.ResultsetMetadataNone {
+			return "NONE", true
+		}
+		return "FULL", true
+	case "session_track_schema":
+		return onOffString(se.trackSchema), true
+	case "session_track_state_change":
+		return onOffString(se.trackStateChange), true
+	case "session_track_system_variables":
+		return se.trackSystemVariables, true
+	case "session_track_transaction_info":
+		return se.trackTransactionInfo, true
+	}
+	v, ok := se.sessionVariables.Get(name)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%v", v.(*mysql.Variable).Get()), true
+}
+
+// getTransactionState return transaction state of session_track_transaction_info, only the transaction type,
+// transactional read and write are tracked, e.g. T_R_W___
+func (se *SessionExecutor) getTransactionState() string {
+	state := []byte("________")
+	if se.status&mysql.ServerStatusInTrans != 0 {
+		state[0] = 'T'
+	} else if !se.isAutoCommit() && (se.txRead || se.txWritten) {
+		state[0] = 'I'
+	}
+	if se.txRead {
+		state[2] = 'R'
+	}
+	if se.txWritten {
+		state[4] = 'W'
+	}
+	return string(state)
+}
+
+// getTransactionCharacteristics return statements to restart the current transaction or set up the next transaction
+// with the same characteristics, e.g. SET TRANSACTION ISOLATION LEVEL READ COMMITTED; START TRANSACTION READ ONLY;
+func (se *SessionExecutor) getTransactionCharacteristics() string {
+	var statements []string
+	if se.nextTxIsolation != "" {
+		level := strings.ToUpper(strings.Replace(se.nextTxIsolation, "-", " ", -1))
+		statements = append(statements, "SET TRANSACTION ISOLATION LEVEL "+level+";")
+	}
+	if se.status&mysql.ServerStatusInTrans != 0 {
+		if se.nextTxReadOnly {
+			statements = append(statements, "START TRANSACTION READ ONLY;")
+		} else {
+			statements = append(statements, "START TRANSACTION;")
+		}
+	} else if se.nextTxReadOnly {
+		statements = append(statements, "SET TRANSACTION READ ONLY;")
+	}
+	return strings.Join(statements, " ")
+}
+
+// trackTransactionStatement record reads and writes in transaction for session_track_transaction_info
+func (se *SessionExecutor) trackTransactionStatement(stmtType parser.StatementType, err error) {
+	if err != nil || !se.isInTransaction() {
+		return
+	}
+	if stmtType == parser.StmtSelect {
+		se.txRead = true
+	} else if isWriteStmt(stmtType) {
+		se.txWritten = true
+	}
+}
+
+// setSessionTrackVariable handle SET of session_track_xxx variables
+func (se *SessionExecutor) setSessionTrackVariable(name, value string) error {
+	switch name {
+	case "session_track_schema", "session_track_state_change":
+		on := name == "session_track_schema" // default value
+		if value != mysql.KeywordDefault {
+			onOffValue, err := getOnOffVariable(value)
+			if err != nil {
+				return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+			}
+			on = onOffValue == "1"
+		}
+		if name == "session_track_schema" {
+			se.trackSchema = on
+		} else {
+			se.trackStateChange = on
+		}
+	case "session_track_system_variables":
+		if value == mysql.KeywordDefault {
+			se.trackSystemVariables = defaultSessionTrackSystemVariables
+			return nil
+		}
+		var names []string
+		for _, n := range strings.Split(value, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				names = append(names, n)
+			}
+		}
+		se.trackSystemVariables = strings.Join(names, ",")
+	case "session_track_transaction_info":
+		switch v := strings.ToUpper(value); v {
+		case strings.ToUpper(mysql.KeywordDefault):
+			se.trackTransactionInfo = sessionTrackTransactionInfoOff
+		case sessionTrackTransactionInfoOff, sessionTrackTransactionInfoState, sessionTrackTransactionInfoCharacteristics:
+			se.trackTransactionInfo = v
+		default:
+			return mysql.NewDefaultError(mysql.ErrWrongValueForVar, name, value)
+		}
+	}
+	return nil
+}
+
+func onOffString(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/mysql"
+)
+
+func TestSessionStateChanges(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+
+	// schema and default tracked variables
+	state := se.getSessionState()
+	assert.Nil(t, se.handleUseDB("db_mycat"))
+	se.status &= ^mysql.ServerStatusAutocommit
+	expect := mysql.AppendSessionTrackSchema(nil, "db_mycat")
+	expect = mysql.AppendSessionTrackSystemVariable(expect, "autocommit", "OFF")
+	assert.Equal(t, expect, se.getSessionStateChanges(state))
+
+	state = se.getSessionState()
+	assert.Nil(t, se.getSessionStateChanges(state), "nothing changed")
+
+	// transaction info and state change
+	assert.Nil(t, se.setSessionTrackVariable("session_track_system_variables", "time_zone, sql_mode"))
+	assert.Nil(t, se.setSessionTrackVariable("session_track_transaction_info", "characteristics"))
+	assert.Nil(t, se.setSessionTrackVariable("session_track_state_change", "on"))
+	assert.Nil(t, se.setSessionTrackVariable("session_track_schema", "off"))
+	state = se.getSessionState()
+	assert.Nil(t, se.handleUseDB("db_ks"))
+	assert.Nil(t, se.setStringSessionVariable(mysql.TimeZone, "+08:00"))
+	se.nextTxIsolation = "read-committed"
+	se.status |= mysql.ServerStatusInTrans
+	se.txWritten = true
+	expect = mysql.AppendSessionTrackSystemVariable(nil, "time_zone", "+08:00")
+	expect = mysql.AppendSessionTrackTransactionState(expect, "T___W___")
+	expect = mysql.AppendSessionTrackTransactionCharacteristics(expect, "SET TRANSACTION ISOLATION LEVEL READ COMMITTED; START TRANSACTION;")
+	expect = mysql.AppendSessionTrackStateChange(expect)
+	assert.Equal(t, expect, se.getSessionStateChanges(state))
+
+	state = se.getSessionState()
+	se.userVariables["a"] = int64(1)
+	assert.Equal(t, mysql.AppendSessionTrackStateChange(nil), se.getSessionStateChanges(state))
+
+	assert.NotNil(t, se.setSessionTrackVariable("session_track_transaction_info", "all"))
+	assert.NotNil(t, se.setSessionTrackVariable("session_track_schema", "yes"))
+	assert.Nil(t, se.setSessionTrackVariable("session_track_system_variables", "default"))
+	assert.Equal(t, defaultSessionTrackSystemVariables, se.trackSystemVariables)
+}
@@ -0,0 +1,211 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+const (
+	// queries waiting to be replayed, new queries are dropped when the queue is full
+	shadowQueueSize = 1024
+	// goroutines replaying queries, each of them has its own session
+	shadowWorkers = 4
+)
+
+// results of comparing query in namespace and shadow namespace
+const (
+	shadowMatch   = "match"
+	shadowError   = "error"   // query failed in only one of the namespaces
+	shadowResult  = "result"  // row count, rows or affected rows differ
+	shadowDropped = "dropped" // replay queue is full
+)
+
+// shadowOutcome summary of query result, rows are compared by row count and checksum regardless of order
+type shadowOutcome struct {
+	err          error
+	hasRows      bool // false if query failed, not select or its rows are streamed to client
+	rows         int
+	checksum     uint64
+	affectedRows uint64
+	latency      time.Duration
+}
+
+func newShadowOutcome(r *mysql.Result, err error, latency time.Duration) *shadowOutcome {
+	o := &shadowOutcome{err: err, latency: latency}
+	if err != nil || r == nil {
+		return o
+	}
+	o.affectedRows = r.AffectedRows
+	if r.Resultset == nil {
+		return o
+	}
+	o.hasRows = true
+	o.rows = len(r.Values)
+	for _, row := range r.Values {
+		h := fnv.New64a()
+		for _, v := range row {
+			fmt.Fprintf(h, "%v\x00", v)
+		}
+		// sum of row hashes does not depend on order of rows, and duplicated rows are not cancelled out
+		o.checksum += h.Sum64()
+	}
+	return o
+}
+
+// compareShadowOutcome return shadowMatch or kind of divergence of shadow query
+func compareShadowOutcome(primary, shadow *shadowOutcome) string {
+	if (primary.err == nil) != (shadow.err == nil) {
+		return shadowError
+	}
+	if primary.err != nil {
+		return shadowMatch
+	}
+	if primary.affectedRows != shadow.affectedRows {
+		return shadowResult
+	}
+	if primary.hasRows && (primary.rows != shadow.rows || primary.checksum != shadow.checksum) {
+		return shadowResult
+	}
+	return shadowMatch
+}
+
+// shadowQuery query executed in namespace and replayed in shadow namespace with the same session settings
+type shadowQuery struct {
+	namespace string
+	shadow    string
+	user      string
+	db        string
+	charset   string
+	collation mysql.CollationID
+	sql       string
+	primary   *shadowOutcome // rows are not compared if they are streamed to client
+}
+
+// shadowReplayer replay queries in shadow namespaces asynchronously, so client responses are not affected
+type shadowReplayer struct {
+	manager *Manager
+	queue   chan *shadowQuery
+	wg      sync.WaitGroup
+}
+
+func newShadowReplayer(manager *Manager) *shadowReplayer {
+	r := &shadowReplayer{
+		manager: manager,
+		queue:   make(chan *shadowQuery, shadowQueueSize),
+	}
+	for i := 0; i < shadowWorkers; i++ {
+		r.wg.Add(1)
+		go r.run()
+	}
+	return r
+}
+
+// replay add query to queue, return false if the queue is full
+func (r *shadowReplayer) replay(q *shadowQuery) bool {
+	select {
+	case r.queue <- q:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *shadowReplayer) run() {
+	defer r.wg.Done()
+	se := newSessionExecutor(r.manager)
+	se.shadow = true
+	for q := range r.queue {
+		r.execute(se, q)
+	}
+}
+
+func (r *shadowReplayer) execute(se *SessionExecutor, q *shadowQuery) {
+	se.namespace = q.shadow
+	se.user = q.user
+	se.db = q.db
+	se.charset = q.charset
+	se.collation = q.collation
+	if se.GetNamespace() == nil {
+		exeLogger.Warnf("shadow namespace %s of %s not found", q.shadow, q.namespace)
+		return
+	}
+	se.pinNamespace()
+	defer se.unpinNamespace(true)
+
+	startTime := time.Now()
+	res, err := se.handleQuery(q.sql)
+	shadow := newShadowOutcome(res, err, time.Since(startTime))
+	result := compareShadowOutcome(q.primary, shadow)
+	if result != shadowMatch {
+		exeLogger.Warnf("shadow query diverged, namespace: %s, shadow: %s, result: %s, sql: %s, primary: %+v, shadow: %+v",
+			q.namespace, q.shadow, result, q.sql, *q.primary, *shadow)
+	}
+	s := r.manager.GetStatisticManager()
+	s.RecordShadowQuery(q.namespace, result)
+	s.recordShadowSQLTiming(q.namespace, "primary", q.primary.latency)
+	s.recordShadowSQLTiming(q.namespace, "shadow", shadow.latency)
+}
+
+func (r *shadowReplayer) close() {
+	close(r.queue)
+	r.wg.Wait()
+}
+
+// isShadowStmt return true if statement of the type is replayed in shadow namespace
+func isShadowStmt(stmtType parser.StatementType, write bool) bool {
+	switch stmtType {
+	case parser.StmtSelect:
+		return true
+	case parser.StmtInsert, parser.StmtReplace, parser.StmtUpdate, parser.StmtDelete:
+		return write
+	default:
+		return false
+	}
+}
+
+// mirrorShadowQuery replay sampled query in shadow namespace after it's executed.
+// Statements in transaction are not replayed, since the transaction can not be replayed in shadow namespace.
+func (se *SessionExecutor) mirrorShadowQuery(reqCtx *util.RequestContext, sql string, startTime time.Time, r *mysql.Result, err error) {
+	cfg := se.GetNamespace().shadow
+	if cfg == nil || se.shadow || se.manager.shadow == nil || se.isAdminSession() || se.isInTransaction() {
+		return
+	}
+	stmtType, _ := reqCtx.Get(util.StmtType).(parser.StatementType)
+	if !isShadowStmt(stmtType, cfg.Write) || rand.Intn(100) >= cfg.Percent {
+		return
+	}
+	q := &shadowQuery{
+		namespace: se.namespace,
+		shadow:    cfg.Namespace,
+		user:      se.user,
+		db:        se.db,
+		charset:   se.charset,
+		collation: se.collation,
+		sql:       sql,
+		primary:   newShadowOutcome(r, err, time.Since(startTime)),
+	}
+	if !se.manager.shadow.replay(q) {
+		se.manager.GetStatisticManager().RecordShadowQuery(se.namespace, shadowDropped)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+func newShadowTestResult(rows ...[]interface{}) *mysql.Result {
+	return &mysql.Result{Resultset: &mysql.Resultset{Values: rows}}
+}
+
+func TestCompareShadowOutcome(t *testing.T) {
+	primary := newShadowOutcome(newShadowTestResult([]interface{}{int64(1), "a"}, []interface{}{int64(2), nil}), nil, time.Millisecond)
+	// order of rows is not compared
+	shadow := newShadowOutcome(newShadowTestResult([]interface{}{int64(2), nil}, []interface{}{int64(1), "a"}), nil, time.Second)
+	assert.Equal(t, shadowMatch, compareShadowOutcome(primary, shadow))
+
+	shadow = newShadowOutcome(newShadowTestResult([]interface{}{int64(1), "a"}, []interface{}{int64(1), "a"}), nil, time.Millisecond)
+	assert.Equal(t, shadowResult, compareShadowOutcome(primary, shadow))
+	shadow = newShadowOutcome(newShadowTestResult([]interface{}{int64(1), "a"}), nil, time.Millisecond)
+	assert.Equal(t, shadowResult, compareShadowOutcome(primary, shadow))
+
+	shadow = newShadowOutcome(nil, errors.New("table not found"), time.Millisecond)
+	assert.Equal(t, shadowError, compareShadowOutcome(primary, shadow))
+	assert.Equal(t, shadowMatch, compareShadowOutcome(shadow, newShadowOutcome(nil, errors.New("syntax error"), 0)))
+
+	// rows of streamed select are not kept
+	assert.Equal(t, shadowMatch, compareShadowOutcome(newShadowOutcome(nil, nil, 0), newShadowOutcome(newShadowTestResult([]interface{}{int64(1), "a"}), nil, 0)))
+
+	write := newShadowOutcome(&mysql.Result{AffectedRows: 2}, nil, 0)
+	assert.Equal(t, shadowResult, compareShadowOutcome(write, newShadowOutcome(&mysql.Result{AffectedRows: 1}, nil, 0)))
+}
+
+func TestMirrorShadowQuery(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	se.manager.shadow = &shadowReplayer{manager: se.manager, queue: make(chan *shadowQuery, 1)}
+	newReqCtx := func(stmtType parser.StatementType) *util.RequestContext {
+		reqCtx := util.NewRequestContext()
+		reqCtx.Set(util.StmtType, stmtType)
+		return reqCtx
+	}
+
+	// disabled
+	se.mirrorShadowQuery(newReqCtx(parser.StmtSelect), "select 1", time.Now(), nil, nil)
+	assert.Equal(t, 0, len(se.manager.shadow.queue))
+
+	ns := se.GetNamespace()
+	ns.shadow = &models.Shadow{Namespace: "shadow_namespace", Percent: 100}
+	se.mirrorShadowQuery(newReqCtx(parser.StmtInsert), "insert into tbl_ks values (1)", time.Now(), nil, nil)
+	assert.Equal(t, 0, len(se.manager.shadow.queue), "writes are not replayed by default")
+	se.mirrorShadowQuery(newReqCtx(parser.StmtSelect), "select * from tbl_ks", time.Now(), newShadowTestResult(), nil)
+	if assert.Equal(t, 1, len(se.manager.shadow.queue)) {
+		q := <-se.manager.shadow.queue
+		assert.Equal(t, "shadow_namespace", q.shadow)
+		assert.Equal(t, se.user, q.user)
+		assert.Equal(t, "db_ks", q.db)
+		assert.True(t, q.primary.hasRows)
+	}
+
+	// statements in transaction are not replayed
+	se.status |= mysql.ServerStatusInTrans
+	se.mirrorShadowQuery(newReqCtx(parser.StmtSelect), "select * from tbl_ks", time.Now(), nil, nil)
+	assert.Equal(t, 0, len(se.manager.shadow.queue))
+	se.status &^= mysql.ServerStatusInTrans
+
+	// queries of shadow session are not replayed again
+	se.shadow = true
+	se.mirrorShadowQuery(newReqCtx(parser.StmtSelect), "select * from tbl_ks", time.Now(), nil, nil)
+	assert.Equal(t, 0, len(se.manager.shadow.queue))
+	se.shadow = false
+
+	ns.shadow.Percent = 0
+	se.mirrorShadowQuery(newReqCtx(parser.StmtSelect), "select * from tbl_ks", time.Now(), nil, nil)
+	assert.Equal(t, 0, len(se.manager.shadow.queue))
+}
@@ -0,0 +1,274 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/proxy/router"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/hack"
+)
+
+// parseDescribeStmt return the SHOW COLUMNS statement of DESCRIBE table, DESCRIBE table column is converted to LIKE pattern
+func (se *SessionExecutor) parseDescribeStmt(sql string) (*ast.ShowStmt, bool) {
+	n, err := se.Parse(sql)
+	if err != nil {
+		return nil, false
+	}
+	es, ok := n.(*ast.ExplainStmt)
+	if !ok {
+		return nil, false
+	}
+	stmt, ok := es.Stmt.(*ast.ShowStmt)
+	if !ok || stmt.Tp != ast.ShowColumns {
+		return nil, false
+	}
+	if stmt.Column != nil {
+		stmt.Pattern = &ast.PatternLikeExpr{Pattern: ast.NewValueExpr(stmt.Column.Name.O, "", ""), Escape: '\\'}
+		stmt.Column = nil
+	}
+	return stmt, true
+}
+
+// getPhysicalTableName return physical table name of the sub table
+func getPhysicalTableName(rule router.Rule, table string, index int) string {
+	if rule.GetType() == router.GlobalTableRuleType || router.IsMycatShardingRule(rule.GetType()) {
+		return table
+	}
+	return fmt.Sprintf("%s_%04d", table, index)
+}
+
+// getShowTableRule return shard rule of the table in SHOW CREATE TABLE, SHOW COLUMNS and SHOW INDEX
+func (se *SessionExecutor) getShowTableRule(stmt *ast.ShowStmt) (router.Rule, bool) {
+	if stmt.Table == nil {
+		return nil, false
+	}
+	db := stmt.Table.Schema.O
+	if db == "" {
+		db = stmt.DBName
+	}
+	if db == "" {
+		db = se.db
+	}
+	return se.GetNamespace().GetRouter().GetShardRule(db, stmt.Table.Name.L)
+}
+
+// handleShowShardTable execute SHOW CREATE TABLE, SHOW COLUMNS and SHOW INDEX of sharding table
+// in the first sub table, the physical table name in result is replaced with the logical name.
+func (se *SessionExecutor) handleShowShardTable(reqCtx *util.RequestContext, stmt *ast.ShowStmt, rule router.Rule) (*mysql.Result, error) {
+	index := rule.GetSubTableIndexes()[0]
+	slice := rule.GetSlice(rule.GetSliceIndexFromTableIndex(index))
+	phyDB, err := rule.GetDatabaseNameByTableIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	table := stmt.Table.Name.O
+	phyTable := getPhysicalTableName(rule, table, index)
+
+	stmt.Table.Schema = model.NewCIStr(phyDB)
+	stmt.Table.Name = model.NewCIStr(phyTable)
+	stmt.DBName = ""
+	sb := &strings.Builder{}
+	if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, sb)); err != nil {
+		return nil, err
+	}
+
+	rs, err := se.ExecuteSQLs(reqCtx, map[string]map[string][]string{slice: {phyDB: {sb.String()}}})
+	if err != nil {
+		return nil, fmt.Errorf("execute show sql error, sql: %s, err: %v", sb.String(), err)
+	}
+	r := rs[0]
+
+	if phyTable != table && r.Resultset != nil {
+		switch stmt.Tp {
+		case ast.ShowCreateTable:
+			for _, row := range r.Values {
+				row[0] = table
+				row[1] = strings.Replace(resultValueString(row[1]), "TABLE `"+phyTable+"`", "TABLE `"+table+"`", 1)
+			}
+		case ast.ShowIndex:
+			for _, row := range r.Values {
+				row[0] = table
+			}
+		}
+		if err := plan.GenerateSelectResultRowData(r); err != nil {
+			return nil, err
+		}
+	}
+	modifyResultStatus(r, se)
+	return r, nil
+}
+
+// handleShowTables execute SHOW TABLES and SHOW TABLE STATUS in default slice,
+// sub tables of sharding tables are presented as one logical table, the first sub table is used in SHOW TABLE STATUS.
+// LIKE is matched with logical table names in proxy, WHERE is executed in backend.
+func (se *SessionExecutor) handleShowTables(reqCtx *util.RequestContext, sql string, stmt *ast.ShowStmt) (*mysql.Result, error) {
+	db := stmt.DBName
+	if db == "" {
+		db = se.db
+	}
+	if db == "" {
+		return nil, mysql.NewDefaultError(mysql.ErrNoDB)
+	}
+	phyDB, err := se.GetNamespace().GetDefaultPhyDB(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := se.GetNamespace().GetRouter().GetShardRules(db)
+	pattern := stmt.Pattern
+	if len(rules) != 0 {
+		stmt.Pattern = nil
+	}
+	stmt.DBName = phyDB
+	sb := &strings.Builder{}
+	if err := stmt.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, sb)); err != nil {
+		return nil, err
+	}
+	r, err := se.ExecuteSQL(reqCtx, backend.DefaultSlice, se.db, sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("execute parser error, parser: %s, err: %v", sql, err)
+	}
+	if r.Resultset == nil {
+		modifyResultStatus(r, se)
+		return r, nil
+	}
+
+	if stmt.Tp == ast.ShowTables && len(r.Fields) != 0 {
+		name := "Tables_in_" + db
+		r.Fields[0].Name = hack.Slice(name)
+		r.FieldNames = map[string]int{name: 0}
+		for i := 1; i < len(r.Fields); i++ {
+			r.FieldNames[string(r.Fields[i].Name)] = i
+		}
+	}
+
+	if len(rules) != 0 {
+		if err := mergeShardTableRows(r, stmt, rules, pattern); err != nil {
+			return nil, err
+		}
+	}
+	if err := plan.GenerateSelectResultRowData(r); err != nil {
+		return nil, err
+	}
+	modifyResultStatus(r, se)
+	return r, nil
+}
+
+// mergeShardTableRows replace physical names of sub tables with the logical table name, and keep one row for each table.
+// For SHOW TABLES, sharding tables without sub table in default slice are added.
+func mergeShardTableRows(r *mysql.Result, stmt *ast.ShowStmt, rules map[string]router.Rule, pattern *ast.PatternLikeExpr) error {
+	logicalNames := make(map[string]string)
+	for table, rule := range rules {
+		for _, index := range rule.GetSubTableIndexes() {
+			logicalNames[getPhysicalTableName(rule, table, index)] = table
+		}
+	}
+
+	var match func(string) bool
+	if pattern != nil {
+		v, ok := pattern.Pattern.(ast.ValueExpr)
+		if !ok {
+			return fmt.Errorf("invalid pattern of show tables")
+		}
+		re, err := likePatternToRegexp(v.GetString(), pattern.Escape)
+		if err != nil {
+			return err
+		}
+		match = func(name string) bool {
+			return re.MatchString(name) != pattern.Not
+		}
+	}
+
+	seen := make(map[string]bool)
+	var values [][]interface{}
+	for _, row := range r.Values {
+		name := resultValueString(row[0])
+		if logical, ok := logicalNames[name]; ok {
+			name = logical
+		}
+		if seen[name] || (match != nil && !match(name)) {
+			continue
+		}
+		seen[name] = true
+		row[0] = name
+		values = append(values, row)
+	}
+
+	if stmt.Tp == ast.ShowTables && stmt.Where == nil {
+		for table := range rules {
+			if seen[table] || (match != nil && !match(table)) {
+				continue
+			}
+			row := []interface{}{table}
+			if len(r.Fields) > 1 {
+				row = append(row, "BASE TABLE")
+			}
+			values = append(values, row)
+		}
+	}
+
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i][0].(string) < values[j][0].(string)
+	})
+	r.Values = values
+	r.AffectedRows = uint64(len(values))
+	return nil
+}
+
+// likePatternToRegexp convert pattern of LIKE to regexp
+func likePatternToRegexp(pattern string, escape byte) (*regexp.Regexp, error) {
+	sb := &strings.Builder{}
+	sb.WriteString("(?s)^")
+	chars := []rune(pattern)
+	for i := 0; i < len(chars); i++ {
+		c := chars[i]
+		switch {
+		case c == rune(escape) && i+1 < len(chars):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(chars[i])))
+		case c == '%':
+			sb.WriteString(".*")
+		case c == '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+func resultValueString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
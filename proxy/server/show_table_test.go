@@ -0,0 +1,114 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/parser"
+)
+
+func TestMergeShardTableRows(t *testing.T) {
+	se, err := prepareSessionExecutor()
+	if err != nil {
+		t.Fatal("prepare session executer error:", err)
+	}
+	rules := se.GetNamespace().GetRouter().GetShardRules("db_ks")
+
+	newResult := func() *mysql.Result {
+		return &mysql.Result{Resultset: &mysql.Resultset{
+			Fields: []*mysql.Field{{Name: []byte("Tables_in_db_ks")}, {Name: []byte("Table_type")}},
+			Values: [][]interface{}{
+				{"tbl_a", "BASE TABLE"},
+				{"tbl_ks_0000", "BASE TABLE"},
+				{"tbl_ks_0001", "BASE TABLE"},
+				{"tbl_ks_extra", "VIEW"},
+			},
+		}}
+	}
+
+	tests := []struct {
+		sql    string
+		expect [][]interface{}
+	}{
+		{
+			sql: "show full tables",
+			expect: [][]interface{}{
+				{"tbl_a", "BASE TABLE"},
+				{"tbl_ks", "BASE TABLE"},
+				{"tbl_ks_extra", "VIEW"},
+			},
+		},
+		{
+			sql: "show full tables like 'tbl\\_ks%'",
+			expect: [][]interface{}{
+				{"tbl_ks", "BASE TABLE"},
+				{"tbl_ks_extra", "VIEW"},
+			},
+		},
+		{
+			sql: "show full tables like 'tbl_ks'",
+			expect: [][]interface{}{
+				{"tbl_ks", "BASE TABLE"},
+			},
+		},
+	}
+	for _, test := range tests {
+		s, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stmt := s.(*ast.ShowStmt)
+		r := newResult()
+		err = mergeShardTableRows(r, stmt, rules, stmt.Pattern)
+		assert.Nil(t, err, test.sql)
+		assert.Equal(t, test.expect, r.Values, test.sql)
+	}
+
+	// sharding table is added if no sub table in default slice
+	s, _ := parser.ParseSQL("show tables")
+	r := &mysql.Result{Resultset: &mysql.Resultset{
+		Fields: []*mysql.Field{{Name: []byte("Tables_in_db_ks")}},
+		Values: [][]interface{}{{"tbl_a"}},
+	}}
+	err = mergeShardTableRows(r, s.(*ast.ShowStmt), rules, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]interface{}{{"tbl_a"}, {"tbl_ks"}}, r.Values)
+}
+
+func TestLikePatternToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"tbl%", "tbl_ks", true},
+		{"tbl_", "tbl1", true},
+		{"tbl_", "tbl12", false},
+		{"tbl\\_%", "tbl_ks", true},
+		{"tbl\\_%", "tblks", false},
+		{"a.b", "axb", false},
+		{"表_", "表1", true},
+	}
+	for _, test := range tests {
+		re, err := likePatternToRegexp(test.pattern, '\\')
+		assert.Nil(t, err, test.pattern)
+		assert.Equal(t, test.match, re.MatchString(test.name), test.pattern)
+	}
+}
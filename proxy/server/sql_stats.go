@@ -0,0 +1,200 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/plan"
+	"github.com/XiaoMi/Gaea/stats"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/hack"
+	"github.com/XiaoMi/Gaea/util/sync2"
+)
+
+const (
+	defaultSQLStatsCapacity = 1024
+
+	showSQLStatsStmt = "show sql stats"
+)
+
+// latency cutoffs of sql stats, microsecond
+var sqlStatsLatencyCutoffs = []int64{
+	100, 250, 500, 1e3, 2.5e3, 5e3, 1e4, 2.5e4, 5e4, 1e5, 2.5e5, 5e5, 1e6, 2.5e6, 5e6, 1e7, 3e7,
+}
+
+var sqlStatsColumnNames = []string{
+	"fingerprint", "count", "error_count", "p50_ms", "p95_ms", "p99_ms", "avg_rows", "avg_shards", "max_shards",
+}
+
+// SQLStats statistics of one sql fingerprint
+type SQLStats struct {
+	fingerprint string
+
+	count      sync2.AtomicInt64
+	errorCount sync2.AtomicInt64
+	rowCount   sync2.AtomicInt64
+	shardCount sync2.AtomicInt64
+	maxShards  sync2.AtomicInt64
+	latency    *stats.Histogram
+}
+
+// NewSQLStats constructor of SQLStats
+func NewSQLStats(fingerprint string) *SQLStats {
+	return &SQLStats{
+		fingerprint: fingerprint,
+		latency:     stats.NewHistogram("", "", sqlStatsLatencyCutoffs),
+	}
+}
+
+// Size implement cache.Value
+func (s *SQLStats) Size() int {
+	return 1
+}
+
+// Record record one execution of the sql fingerprint
+func (s *SQLStats) Record(duration time.Duration, rows int64, shards int, err error) {
+	s.count.Add(1)
+	if err != nil {
+		s.errorCount.Add(1)
+	}
+	s.rowCount.Add(rows)
+	s.shardCount.Add(int64(shards))
+	for {
+		max := s.maxShards.Get()
+		if int64(shards) <= max || s.maxShards.CompareAndSwap(max, int64(shards)) {
+			break
+		}
+	}
+	s.latency.Add(int64(duration / time.Microsecond))
+}
+
+// Fingerprint return sql fingerprint
+func (s *SQLStats) Fingerprint() string {
+	return s.fingerprint
+}
+
+// Count return execution count
+func (s *SQLStats) Count() int64 {
+	return s.count.Get()
+}
+
+// LatencyQuantile return estimated latency of quantile q in millisecond
+func (s *SQLStats) LatencyQuantile(q float64) float64 {
+	return float64(s.latency.Quantile(q)) / 1e3
+}
+
+func (s *SQLStats) average(total int64) float64 {
+	count := s.count.Get()
+	if count == 0 {
+		return 0
+	}
+	return float64(total) / float64(count)
+}
+
+func isShowSQLStatsStmt(sql string) bool {
+	return strings.ToLower(strings.Join(strings.Fields(sql), " ")) == showSQLStatsStmt
+}
+
+func getRowCount(r *mysql.Result) int64 {
+	if r == nil {
+		return 0
+	}
+	if r.Resultset != nil && len(r.Fields) != 0 {
+		return int64(len(r.Values))
+	}
+	return int64(r.AffectedRows)
+}
+
+func getShardCount(reqCtx *util.RequestContext) int {
+	if c, ok := reqCtx.Get(util.ShardCount).(int); ok {
+		return c
+	}
+	return 0
+}
+
+func addShardCount(reqCtx *util.RequestContext, count int) {
+	reqCtx.Set(util.ShardCount, getShardCount(reqCtx)+count)
+}
+
+func getShards(reqCtx *util.RequestContext) []string {
+	if shards, ok := reqCtx.Get(util.Shards).([]string); ok {
+		return shards
+	}
+	return nil
+}
+
+// addShards record slice and db of sqls sent to backend, used in audit log
+func addShards(reqCtx *util.RequestContext, sqls map[string]map[string][]string) {
+	shards := getShards(reqCtx)
+	for slice, dbSQLs := range sqls {
+		for db := range dbSQLs {
+			shards = append(shards, slice+":"+db)
+		}
+	}
+	sort.Strings(shards)
+	reqCtx.Set(util.Shards, shards)
+}
+
+func getRowCountFromContext(reqCtx *util.RequestContext) int64 {
+	if c, ok := reqCtx.Get(util.RowCount).(int64); ok {
+		return c
+	}
+	return 0
+}
+
+// createShowSQLStatsResult create result of SHOW SQL STATS, sorted by execution count desc
+func createShowSQLStatsResult(sqlStats []*SQLStats) (*mysql.Result, error) {
+	sort.Slice(sqlStats, func(i, j int) bool {
+		return sqlStats[i].Count() > sqlStats[j].Count()
+	})
+
+	r := new(mysql.Resultset)
+	r.FieldNames = make(map[string]int, len(sqlStatsColumnNames))
+	for i, name := range sqlStatsColumnNames {
+		field := &mysql.Field{}
+		field.Name = hack.Slice(name)
+		r.Fields = append(r.Fields, field)
+		r.FieldNames[name] = i
+	}
+
+	for _, s := range sqlStats {
+		row := []interface{}{
+			s.Fingerprint(),
+			s.Count(),
+			s.errorCount.Get(),
+			s.LatencyQuantile(0.5),
+			s.LatencyQuantile(0.95),
+			s.LatencyQuantile(0.99),
+			s.average(s.rowCount.Get()),
+			s.average(s.shardCount.Get()),
+			s.maxShards.Get(),
+		}
+		r.Values = append(r.Values, row)
+	}
+
+	result := &mysql.Result{
+		AffectedRows: uint64(len(sqlStats)),
+		Resultset:    r,
+	}
+
+	if err := plan.GenerateSelectResultRowData(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
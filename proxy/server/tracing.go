@@ -0,0 +1,66 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+	"github.com/XiaoMi/Gaea/util/tracing"
+)
+
+// startQuerySpan start root span of client query and save it in request context
+func (se *SessionExecutor) startQuerySpan(reqCtx *util.RequestContext, sql string, stmtType parser.StatementType) trace.Span {
+	span := tracing.StartSpan(nil, "query "+stmtType.String(), trace.SpanKindServer,
+		attribute.String("db.system", "mysql"),
+		attribute.String("db.user", se.user),
+		attribute.String("db.name", se.db),
+		attribute.String("db.statement", sql),
+		attribute.String("gaea.namespace", se.namespace),
+		attribute.Int64("gaea.connection_id", int64(se.connID)),
+	)
+	reqCtx.Set(util.TraceSpan, span)
+	return span
+}
+
+// endQuerySpan end root span of client query with shard count and row count in request context
+func endQuerySpan(reqCtx *util.RequestContext, span trace.Span, err error) {
+	if count, ok := reqCtx.Get(util.ShardCount).(int); ok {
+		span.SetAttributes(attribute.Int("gaea.shard_count", count))
+	}
+	if count, ok := reqCtx.Get(util.RowCount).(int64); ok {
+		span.SetAttributes(attribute.Int64("gaea.row_count", count))
+	}
+	tracing.EndSpan(span, err)
+}
+
+// startShardSpan start span of sql executed in backend as child of the root span in request context
+func startShardSpan(reqCtx *util.RequestContext, slice, addr, sql string) trace.Span {
+	parent, _ := reqCtx.Get(util.TraceSpan).(trace.Span)
+	return tracing.StartSpan(parent, "shard "+slice, trace.SpanKindClient,
+		attribute.String("db.system", "mysql"),
+		attribute.String("db.statement", sql),
+		attribute.String("net.peer.name", addr),
+		attribute.String("gaea.slice", slice),
+	)
+}
+
+// attachShardSpan add trace id and span id comment before sql sent to backend,
+// so slow logs of backend can be attributed to the shard span
+func attachShardSpan(reqCtx *util.RequestContext, span trace.Span, sql string) string {
+	return util.AttachTraceSpan(reqCtx, tracing.GetSpanID(span), sql)
+}
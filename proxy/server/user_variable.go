@@ -0,0 +1,202 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/format"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/types"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// User-defined variables are stored in session instead of backend connections, since statements of a session
+// may be executed in different backend connections. References of the variables are replaced with their values
+// before statements are planned.
+
+// isUserVariable check if the assignment is SET @var = expr, SET NAMES is not a system variable either
+func isUserVariable(v *ast.VariableAssignment) bool {
+	return !v.IsSystem && v.Name != ast.SetNames
+}
+
+// setUserVariable evaluate expr and store its value in session, constant is evaluated in proxy,
+// and other expressions without table are evaluated in default slice.
+func (se *SessionExecutor) setUserVariable(reqCtx *util.RequestContext, v *ast.VariableAssignment) error {
+	name := strings.ToLower(v.Name)
+	r := &userVariableRewriter{variables: se.userVariables}
+	node, _ := v.Value.Accept(r)
+	if r.err != nil {
+		return r.err
+	}
+
+	expr := node.(ast.ExprNode)
+	var value interface{}
+	if ve, ok := expr.(*driver.ValueExpr); ok {
+		value = getUserVariableValue(ve)
+	} else {
+		var err error
+		if value, err = se.evalUserVariable(reqCtx, expr); err != nil {
+			return err
+		}
+	}
+
+	if value == nil {
+		delete(se.userVariables, name)
+	} else {
+		se.userVariables[name] = value
+	}
+	return nil
+}
+
+// evalUserVariable evaluate expression by SELECT expr in default slice
+func (se *SessionExecutor) evalUserVariable(reqCtx *util.RequestContext, expr ast.ExprNode) (interface{}, error) {
+	c := &tableRefChecker{}
+	expr.Accept(c)
+	if c.found {
+		return nil, mysql.NewError(mysql.ErrNotSupportedYet, "value of user variable with column or subquery is not supported by gaea")
+	}
+
+	sb := &strings.Builder{}
+	sb.WriteString("SELECT ")
+	if err := expr.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, sb)); err != nil {
+		return nil, err
+	}
+	r, err := se.ExecuteSQL(reqCtx, backend.DefaultSlice, se.db, sb.String())
+	if err != nil {
+		return nil, err
+	}
+	if r.Resultset == nil || len(r.Values) != 1 || len(r.Values[0]) != 1 {
+		return nil, fmt.Errorf("invalid result of user variable expression: %s", sb.String())
+	}
+	if b, ok := r.Values[0][0].([]byte); ok {
+		return string(b), nil
+	}
+	return r.Values[0][0], nil
+}
+
+// getUserVariableValue return value of constant, decimal is kept to avoid losing precision
+func getUserVariableValue(v *driver.ValueExpr) interface{} {
+	if v.Kind() == types.KindMysqlDecimal {
+		return v.GetMysqlDecimal()
+	}
+	value, err := util.GetValueExprResult(v)
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// userVariableRewriter replace references of user-defined variables with their values,
+// undefined variables are replaced with NULL as mysql does.
+type userVariableRewriter struct {
+	variables map[string]interface{}
+	changed   bool
+	err       error
+}
+
+// Enter implement ast.Visitor
+func (r *userVariableRewriter) Enter(n ast.Node) (ast.Node, bool) {
+	if r.err != nil {
+		return n, true
+	}
+	switch nn := n.(type) {
+	case *ast.SelectStmt:
+		// keep column name of SELECT @var
+		if nn.Fields == nil {
+			break
+		}
+		for _, f := range nn.Fields.Fields {
+			if v, ok := f.Expr.(*ast.VariableExpr); ok && !v.IsSystem && v.Value == nil && f.AsName.L == "" {
+				f.AsName = model.NewCIStr("@" + v.Name)
+			}
+		}
+	case *ast.VariableExpr:
+		if !nn.IsSystem && nn.Value != nil {
+			r.err = mysql.NewError(mysql.ErrNotSupportedYet, "assignment of user variable in statement is not supported by gaea, please use SET")
+			return n, true
+		}
+	}
+	return n, false
+}
+
+// Leave implement ast.Visitor
+func (r *userVariableRewriter) Leave(n ast.Node) (ast.Node, bool) {
+	if r.err != nil {
+		return n, false
+	}
+	v, ok := n.(*ast.VariableExpr)
+	if !ok || v.IsSystem {
+		return n, true
+	}
+	r.changed = true
+	return ast.NewValueExpr(r.variables[strings.ToLower(v.Name)], "", ""), true
+}
+
+// rewriteUserVariables replace references of user-defined variables in statement, return true if the statement is changed
+func (se *SessionExecutor) rewriteUserVariables(n ast.StmtNode) (bool, error) {
+	r := &userVariableRewriter{variables: se.userVariables}
+	n.Accept(r)
+	return r.changed, r.err
+}
+
+// tableRefChecker check if expression references columns or subqueries
+type tableRefChecker struct {
+	found bool
+}
+
+// Enter implement ast.Visitor
+func (c *tableRefChecker) Enter(n ast.Node) (ast.Node, bool) {
+	switch n.(type) {
+	case *ast.ColumnNameExpr, *ast.SubqueryExpr:
+		c.found = true
+	}
+	return n, c.found
+}
+
+// Leave implement ast.Visitor
+func (c *tableRefChecker) Leave(n ast.Node) (ast.Node, bool) {
+	return n, !c.found
+}
+
+// hasUserVariable check if statement references user-defined variables
+func hasUserVariable(n ast.Node) bool {
+	c := &userVariableChecker{}
+	n.Accept(c)
+	return c.found
+}
+
+type userVariableChecker struct {
+	found bool
+}
+
+// Enter implement ast.Visitor
+func (c *userVariableChecker) Enter(n ast.Node) (ast.Node, bool) {
+	if v, ok := n.(*ast.VariableExpr); ok && !v.IsSystem {
+		c.found = true
+	}
+	return n, c.found
+}
+
+// Leave implement ast.Visitor
+func (c *userVariableChecker) Leave(n ast.Node) (ast.Node, bool) {
+	return n, !c.found
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const (
+	// all branches of the XA transaction have been prepared, and it's decided to commit
+	xaStateCommit = "commit"
+	// all branches of the XA transaction have been committed
+	xaStateDone = "done"
+)
+
+// xaLogRecord one line of XA recovery log
+type xaLogRecord struct {
+	XID       string   `json:"xid"`
+	Namespace string   `json:"namespace,omitempty"`
+	Slices    []string `json:"slices,omitempty"`
+	State     string   `json:"state"`
+}
+
+// XALog append-only recovery log of XA transactions.
+// Commit decision is written and synced to disk before the second phase,
+// so transactions prepared but not committed when proxy crashes can be committed during recovery.
+type XALog struct {
+	sync.Mutex
+	path string
+	f    *os.File
+}
+
+// OpenXALog open XA recovery log, create it if not exists
+func OpenXALog(path string) (*XALog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open xa log %s error: %v", path, err)
+	}
+	return &XALog{path: path, f: f}, nil
+}
+
+func (l *XALog) append(r *xaLogRecord, sync bool) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.Lock()
+	defer l.Unlock()
+	if _, err := l.f.Write(data); err != nil {
+		return fmt.Errorf("write xa log error: %v", err)
+	}
+	if sync {
+		if err := l.f.Sync(); err != nil {
+			return fmt.Errorf("sync xa log error: %v", err)
+		}
+	}
+	return nil
+}
+
+// LogCommit record commit decision of XA transaction, must be called before XA COMMIT
+func (l *XALog) LogCommit(xid, namespace string, slices []string) error {
+	return l.append(&xaLogRecord{XID: xid, Namespace: namespace, Slices: slices, State: xaStateCommit}, true)
+}
+
+// LogDone record that all branches of XA transaction have been committed
+func (l *XALog) LogDone(xid string) error {
+	// not synced, the transaction will be committed again if the record is lost, and it's idempotent
+	return l.append(&xaLogRecord{XID: xid, State: xaStateDone}, false)
+}
+
+// Pending return XA transactions decided to commit but not done, in log order
+func (l *XALog) Pending() ([]*xaLogRecord, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*xaLogRecord
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := &xaLogRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			// the last line may be partially written when proxy crashes
+			continue
+		}
+		switch r.State {
+		case xaStateCommit:
+			records = append(records, r)
+		case xaStateDone:
+			done[r.XID] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := records[:0]
+	for _, r := range records {
+		if !done[r.XID] {
+			pending = append(pending, r)
+		}
+	}
+	return pending, nil
+}
+
+// Compact rewrite the log with only the given pending records
+func (l *XALog) Compact(pending []*xaLogRecord) error {
+	var data []byte
+	for _, r := range pending {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	tmpPath := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("write xa log error: %v", err)
+	}
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		return fmt.Errorf("rename xa log error: %v", err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open xa log %s error: %v", l.path, err)
+	}
+	l.f.Close()
+	l.f = f
+	return nil
+}
+
+// Close close the log file
+func (l *XALog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,350 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/logging"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/util/sync2"
+)
+
+const (
+	defaultXALogPath = "xa_recovery.log"
+	// interval of retrying XA COMMIT of branches failed in the second phase
+	xaRetryInterval = 5 * time.Second
+)
+
+// XACoordinator generate xid of XA transactions and recover them after proxy restarts.
+// xid is composed of a prefix identifying this proxy, so only XA transactions
+// created by this proxy are recovered.
+// Branches failed to commit in the second phase are prepared and hold row locks,
+// they are committed again in background until success.
+type XACoordinator struct {
+	prefix string
+	start  int64
+	seq    sync2.AtomicInt64
+	log    *XALog
+
+	retryLock  sync.Mutex
+	retryTasks map[string]*xaRetryTask
+	closeCh    chan struct{}
+	wg         sync.WaitGroup
+}
+
+// xaRetryTask XA transaction decided to commit, with slices whose branch is prepared but not committed
+type xaRetryTask struct {
+	xid       string
+	namespace string
+	slices    []string
+}
+
+// xaConnGetter return master connection of the slice in namespace
+type xaConnGetter func(namespace, slice string) (backend.PooledConnect, error)
+
+func createXACoordinator(cfg *models.Proxy) (*XACoordinator, error) {
+	path := cfg.XALogPath
+	if path == "" {
+		path = defaultXALogPath
+	}
+	xaLog, err := OpenXALog(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	return &XACoordinator{
+		prefix:     fmt.Sprintf("gaea-%x", md5.Sum([]byte(hostname+cfg.ProxyAddr)))[:13],
+		start:      time.Now().Unix(),
+		log:        xaLog,
+		retryTasks: make(map[string]*xaRetryTask),
+		closeCh:    make(chan struct{}),
+	}, nil
+}
+
+// NewXID return a new xid, it's unique in cluster
+func (c *XACoordinator) NewXID() string {
+	return fmt.Sprintf("%s-%d-%d", c.prefix, c.start, c.seq.Add(1))
+}
+
+func (c *XACoordinator) isOwnXID(xid string) bool {
+	return strings.HasPrefix(xid, c.prefix+"-")
+}
+
+// Close stop retrying and close recovery log, transactions not committed are recovered after restart
+func (c *XACoordinator) Close() {
+	close(c.closeCh)
+	c.wg.Wait()
+	if err := c.log.Close(); err != nil {
+		logging.DefaultLogger.Warnf("close xa log error: %v", err)
+	}
+}
+
+// Recover commit XA transactions which were decided to commit, and rollback other prepared XA transactions of this proxy.
+// It must be called before serving clients.
+func (c *XACoordinator) Recover(namespaces map[string]*Namespace) {
+	pending, err := c.log.Pending()
+	if err != nil {
+		logging.DefaultLogger.Warnf("read xa log error, skip recovery: %v", err)
+		return
+	}
+	committed := make(map[string]bool, len(pending))
+	for _, r := range pending {
+		committed[r.XID] = true
+	}
+
+	failed := make(map[string]bool)
+	// transaction_mode can be set in any session, so all namespaces are recovered
+	for _, ns := range namespaces {
+		for sliceName, slice := range ns.slices {
+			if err := c.recoverSlice(slice, committed); err != nil {
+				logging.DefaultLogger.Warnf("recover xa transactions error, namespace: %s, slice: %s, err: %v", ns.name, sliceName, err)
+				// keep all pending records, they will be recovered next time
+				for xid := range committed {
+					failed[xid] = true
+				}
+			}
+		}
+	}
+
+	remaining := make([]*xaLogRecord, 0)
+	for _, r := range pending {
+		// namespace of the transaction may be not loaded yet, keep it to recover next time
+		if _, ok := namespaces[r.Namespace]; !ok || failed[r.XID] {
+			remaining = append(remaining, r)
+		}
+	}
+	if err := c.log.Compact(remaining); err != nil {
+		logging.DefaultLogger.Warnf("compact xa log error: %v", err)
+	}
+}
+
+func (c *XACoordinator) recoverSlice(slice *backend.Slice, committed map[string]bool) error {
+	pc, err := slice.GetMasterConn()
+	if err != nil {
+		return err
+	}
+	defer pc.Recycle()
+
+	r, err := pc.Execute("XA RECOVER")
+	if err != nil {
+		return err
+	}
+	if r.Resultset == nil {
+		return nil
+	}
+
+	for i := range r.Values {
+		// columns: formatID, gtrid_length, bqual_length, data
+		xid, err := r.GetString(i, 3)
+		if err != nil {
+			return err
+		}
+		if !c.isOwnXID(xid) {
+			continue
+		}
+
+		action := "ROLLBACK"
+		if committed[xid] {
+			action = "COMMIT"
+		}
+		if _, err := pc.Execute(fmt.Sprintf("XA %s '%s'", action, xid)); err != nil {
+			return err
+		}
+		logging.DefaultLogger.Infof("recover xa transaction, xid: %s, action: %s, slice: %s", xid, action, slice.Cfg.Name)
+	}
+	return nil
+}
+
+// startRetry commit branches failed in the second phase periodically in background
+func (c *XACoordinator) startRetry(getConn xaConnGetter) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(xaRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.closeCh:
+				return
+			case <-ticker.C:
+				c.retryCommits(getConn)
+			}
+		}
+	}()
+}
+
+// addRetry add branches of the XA transaction to be committed in background
+func (c *XACoordinator) addRetry(xid, namespace string, slices []string) {
+	c.retryLock.Lock()
+	defer c.retryLock.Unlock()
+	c.retryTasks[xid] = &xaRetryTask{xid: xid, namespace: namespace, slices: slices}
+}
+
+func (c *XACoordinator) getRetryTasks() []*xaRetryTask {
+	c.retryLock.Lock()
+	defer c.retryLock.Unlock()
+	tasks := make([]*xaRetryTask, 0, len(c.retryTasks))
+	for _, t := range c.retryTasks {
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// retryCommits commit failed branches again, transaction is logged as done when all branches are committed
+func (c *XACoordinator) retryCommits(getConn xaConnGetter) {
+	for _, t := range c.getRetryTasks() {
+		var remaining []string
+		for _, sliceName := range t.slices {
+			if err := commitXABranch(getConn, t, sliceName); err != nil {
+				logging.DefaultLogger.Warnf("retry xa commit error, xid: %s, namespace: %s, slice: %s, err: %v", t.xid, t.namespace, sliceName, err)
+				remaining = append(remaining, sliceName)
+				continue
+			}
+			logging.DefaultLogger.Infof("retry xa commit success, xid: %s, namespace: %s, slice: %s", t.xid, t.namespace, sliceName)
+		}
+
+		c.retryLock.Lock()
+		if len(remaining) != 0 {
+			t.slices = remaining
+			c.retryLock.Unlock()
+			continue
+		}
+		delete(c.retryTasks, t.xid)
+		c.retryLock.Unlock()
+
+		if err := c.log.LogDone(t.xid); err != nil {
+			logging.DefaultLogger.Warnf("write xa log error, xid: %s, err: %v", t.xid, err)
+		}
+	}
+}
+
+func commitXABranch(getConn xaConnGetter, t *xaRetryTask, sliceName string) error {
+	pc, err := getConn(t.namespace, sliceName)
+	if err != nil {
+		return err
+	}
+	defer pc.Recycle()
+
+	if _, err := pc.Execute(fmt.Sprintf("XA COMMIT '%s'", t.xid)); err != nil {
+		// the branch has been committed, the response of last XA COMMIT may be lost
+		if e, ok := err.(*mysql.SQLError); ok && e.SQLCode() == mysql.ErrXaerNota {
+			return nil
+		}
+		pc.Close()
+		return err
+	}
+	return nil
+}
+
+// startXABranch start XA transaction branch on the connection, the xid is allocated for the first branch
+func (se *SessionExecutor) startXABranch(pc backend.PooledConnect) error {
+	if se.xid == "" {
+		se.xid = se.manager.GetXACoordinator().NewXID()
+	}
+	_, err := pc.Execute(fmt.Sprintf("XA START '%s'", se.xid))
+	return err
+}
+
+// commitXA commit XA transaction of all txConns, txLock must be held.
+// Transaction of only one slice is committed with one phase, otherwise two-phase commit is used,
+// the commit decision is written to recovery log between the two phases.
+func (se *SessionExecutor) commitXA() error {
+	xid := fmt.Sprintf("'%s'", se.xid)
+
+	if len(se.txConns) == 1 {
+		for _, pc := range se.txConns {
+			if _, err := pc.Execute("XA END " + xid); err != nil {
+				se.rollbackXA()
+				return err
+			}
+			if _, err := pc.Execute("XA COMMIT " + xid + " ONE PHASE"); err != nil {
+				pc.Close()
+				return err
+			}
+		}
+		return nil
+	}
+
+	// phase 1: prepare all branches
+	slices := make([]string, 0, len(se.txConns))
+	for sliceName, pc := range se.txConns {
+		if _, err := pc.Execute("XA END " + xid); err != nil {
+			se.rollbackXA()
+			return err
+		}
+		if _, err := pc.Execute("XA PREPARE " + xid); err != nil {
+			se.rollbackXA()
+			return err
+		}
+		slices = append(slices, sliceName)
+	}
+	sort.Strings(slices)
+
+	coordinator := se.manager.GetXACoordinator()
+	if err := coordinator.log.LogCommit(se.xid, se.namespace, slices); err != nil {
+		se.rollbackXA()
+		return err
+	}
+
+	// phase 2: commit all branches, failed branches will be committed in background
+	var err error
+	var failed []string
+	for sliceName, pc := range se.txConns {
+		if _, e := pc.Execute("XA COMMIT " + xid); e != nil {
+			logging.DefaultLogger.Warnf("xa commit error, xid: %s, slice: %s, err: %v", se.xid, sliceName, e)
+			pc.Close()
+			failed = append(failed, sliceName)
+			err = e
+		}
+	}
+	if err != nil {
+		sort.Strings(failed)
+		coordinator.addRetry(se.xid, se.namespace, failed)
+		return fmt.Errorf("xa transaction %s is prepared but not committed in all slices, it will be committed in background: %v", se.xid, err)
+	}
+
+	if e := coordinator.log.LogDone(se.xid); e != nil {
+		logging.DefaultLogger.Warnf("write xa log error, xid: %s, err: %v", se.xid, e)
+	}
+	return nil
+}
+
+// rollbackXA rollback XA transaction of all txConns, txLock must be held.
+// Connections failed to rollback are closed, backend mysql will rollback branches which are not prepared.
+func (se *SessionExecutor) rollbackXA() (err error) {
+	xid := fmt.Sprintf("'%s'", se.xid)
+	for _, pc := range se.txConns {
+		if pc.IsClosed() {
+			continue
+		}
+		// branch may have been ended already, ignore the error
+		_, _ = pc.Execute("XA END " + xid)
+		if _, e := pc.Execute("XA ROLLBACK " + xid); e != nil {
+			pc.Close()
+			err = e
+		}
+	}
+	return
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/XiaoMi/Gaea/backend"
+	"github.com/XiaoMi/Gaea/backend/mocks"
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestXACoordinator(t *testing.T) (*XACoordinator, func()) {
+	dir, err := ioutil.TempDir("", "gaea_xa")
+	if err != nil {
+		t.Fatalf("create temp dir error: %v", err)
+	}
+	c, err := createXACoordinator(&models.Proxy{XALogPath: filepath.Join(dir, "xa.log"), ProxyAddr: "0.0.0.0:13306"})
+	if err != nil {
+		t.Fatalf("create xa coordinator error: %v", err)
+	}
+	return c, func() {
+		c.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestXALog(t *testing.T) {
+	c, clean := newTestXACoordinator(t)
+	defer clean()
+
+	assert.Nil(t, c.log.LogCommit("xid-1", "ns", []string{"slice-0", "slice-1"}))
+	assert.Nil(t, c.log.LogCommit("xid-2", "ns", []string{"slice-0", "slice-1"}))
+	assert.Nil(t, c.log.LogDone("xid-1"))
+
+	pending, err := c.log.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, "xid-2", pending[0].XID)
+	assert.Equal(t, []string{"slice-0", "slice-1"}, pending[0].Slices)
+
+	assert.Nil(t, c.log.Compact(nil))
+	pending, err = c.log.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pending))
+
+	// log is still writable after compaction
+	assert.Nil(t, c.log.LogCommit("xid-3", "ns", []string{"slice-0"}))
+	pending, err = c.log.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+}
+
+func TestXAID(t *testing.T) {
+	c, clean := newTestXACoordinator(t)
+	defer clean()
+
+	xid1, xid2 := c.NewXID(), c.NewXID()
+	assert.NotEqual(t, xid1, xid2)
+	assert.True(t, c.isOwnXID(xid1))
+	assert.False(t, c.isOwnXID("gaea-00000000-1-1"))
+}
+
+func TestCommitXA(t *testing.T) {
+	c, clean := newTestXACoordinator(t)
+	defer clean()
+
+	m := NewManager()
+	m.xaCoordinator = c
+	se := newSessionExecutor(m)
+	se.xid = "test-xid"
+
+	conn0, conn1 := new(mocks.PooledConnect), new(mocks.PooledConnect)
+	for _, conn := range []*mocks.PooledConnect{conn0, conn1} {
+		conn.On("Execute", "XA END 'test-xid'").Return(nil, nil).Once()
+		conn.On("Execute", "XA PREPARE 'test-xid'").Return(nil, nil).Once()
+		conn.On("Execute", "XA COMMIT 'test-xid'").Return(nil, nil).Once()
+	}
+	se.txConns = map[string]backend.PooledConnect{"slice-0": conn0, "slice-1": conn1}
+
+	assert.Nil(t, se.commitXA())
+	conn0.AssertExpectations(t)
+	conn1.AssertExpectations(t)
+
+	pending, err := c.log.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pending))
+}
+
+func TestRecoverXAMissingNamespace(t *testing.T) {
+	c, clean := newTestXACoordinator(t)
+	defer clean()
+
+	assert.Nil(t, c.log.LogCommit("xid-1", "ns", []string{"slice-0"}))
+	assert.Nil(t, c.log.LogCommit("xid-2", "ns_not_loaded", []string{"slice-0"}))
+
+	pool := new(mocks.ConnectionPool)
+	conn := new(mocks.PooledConnect)
+	pool.On("Get", context.TODO()).Return(conn, nil).Once()
+	conn.On("Execute", "XA RECOVER").Return(&mysql.Result{}, nil).Once()
+	conn.On("Recycle").Return(nil).Once()
+	namespaces := map[string]*Namespace{
+		"ns": {name: "ns", slices: map[string]*backend.Slice{"slice-0": {Master: pool}}},
+	}
+
+	c.Recover(namespaces)
+	conn.AssertExpectations(t)
+
+	// record of namespace not loaded is kept
+	pending, err := c.log.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+	assert.Equal(t, "xid-2", pending[0].XID)
+}
+
+func TestCommitXAPrepareFailed(t *testing.T) {
+	c, clean := newTestXACoordinator(t)
+	defer clean()
+
+	m := NewManager()
+	m.xaCoordinator = c
+	se := newSessionExecutor(m)
+	se.xid = "test-xid"
+
+	conn0, conn1 := new(mocks.PooledConnect), new(mocks.PooledConnect)
+	conn0.On("Execute", "XA PREPARE 'test-xid'").Return(nil, errors.New("prepare failed"))
+	conn1.On("Execute", "XA PREPARE 'test-xid'").Return(nil, nil)
+	for _, conn := range []*mocks.PooledConnect{conn0, conn1} {
+		conn.On("Execute", "XA END 'test-xid'").Return(nil, nil)
+		conn.On("Execute", "XA ROLLBACK 'test-xid'").Return(nil, nil)
+		conn.On("IsClosed").Return(false)
+	}
+	se.txConns = map[string]backend.PooledConnect{"slice-0": conn0, "slice-1": conn1}
+
+	assert.NotNil(t, se.commitXA())
+	for _, conn := range []*mocks.PooledConnect{conn0, conn1} {
+		conn.AssertCalled(t, "Execute", "XA ROLLBACK 'test-xid'")
+		conn.AssertNotCalled(t, "Execute", "XA COMMIT 'test-xid'")
+	}
+
+	// commit decision is not logged
+	pending, err := c.log.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pending))
+}
+
+func TestCommitXACommitFailed(t *testing.T) {
+	c, clean := newTestXACoordinator(t)
+	defer clean()
+
+	m := NewManager()
+	m.xaCoordinator = c
+	se := newSessionExecutor(m)
+	se.xid = "test-xid"
+	se.namespace = "ns"
+
+	conn0, conn1 := new(mocks.PooledConnect), new(mocks.PooledConnect)
+	conn0.On("Execute", "XA COMMIT 'test-xid'").Return(nil, nil)
+	conn1.On("Execute", "XA COMMIT 'test-xid'").Return(nil, errors.New("connection lost"))
+	conn1.On("Close").Return()
+	for _, conn := range []*mocks.PooledConnect{conn0, conn1} {
+		conn.On("Execute", "XA END 'test-xid'").Return(nil, nil)
+		conn.On("Execute", "XA PREPARE 'test-xid'").Return(nil, nil)
+	}
+	se.txConns = map[string]backend.PooledConnect{"slice-0": conn0, "slice-1": conn1}
+
+	assert.NotNil(t, se.commitXA())
+	tasks := c.getRetryTasks()
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, "ns", tasks[0].namespace)
+	assert.Equal(t, []string{"slice-1"}, tasks[0].slices)
+
+	pending, err := c.log.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(pending))
+}
+
+func TestRetryXACommit(t *testing.T) {
+	c, clean := newTestXACoordinator(t)
+	defer clean()
+
+	assert.Nil(t, c.log.LogCommit("test-xid", "ns", []string{"slice-0", "slice-1", "slice-2"}))
+	c.addRetry("test-xid", "ns", []string{"slice-0", "slice-1", "slice-2"})
+
+	conn0, conn1, conn2 := new(mocks.PooledConnect), new(mocks.PooledConnect), new(mocks.PooledConnect)
+	conn0.On("Execute", "XA COMMIT 'test-xid'").Return(nil, nil)
+	// branch has been committed by last XA COMMIT whose response is lost
+	conn1.On("Execute", "XA COMMIT 'test-xid'").Return(nil, mysql.NewError(mysql.ErrXaerNota, "XAER_NOTA: Unknown XID"))
+	conn2.On("Execute", "XA COMMIT 'test-xid'").Return(nil, errors.New("connection lost")).Once()
+	conn2.On("Execute", "XA COMMIT 'test-xid'").Return(nil, nil).Once()
+	conn2.On("Close").Return()
+	conns := map[string]*mocks.PooledConnect{"slice-0": conn0, "slice-1": conn1, "slice-2": conn2}
+	for _, conn := range conns {
+		conn.On("Recycle").Return()
+	}
+	getConn := func(namespace, slice string) (backend.PooledConnect, error) {
+		return conns[slice], nil
+	}
+
+	c.retryCommits(getConn)
+	tasks := c.getRetryTasks()
+	assert.Equal(t, 1, len(tasks))
+	assert.Equal(t, []string{"slice-2"}, tasks[0].slices)
+
+	c.retryCommits(getConn)
+	assert.Equal(t, 0, len(c.getRetryTasks()))
+	conn0.AssertNumberOfCalls(t, "Execute", 1)
+	conn2.AssertNumberOfCalls(t, "Execute", 2)
+
+	pending, err := c.log.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(pending))
+}
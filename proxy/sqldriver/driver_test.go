@@ -0,0 +1,187 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqldriver
+
+import (
+	"database/sql/driver"
+	"io"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/Gaea/mysql"
+	"github.com/XiaoMi/Gaea/proxy/server"
+)
+
+func TestParseDSN(t *testing.T) {
+	d, err := ParseDSN("gosharding://user:p%40ss@ns/db?config=/etc/gaea.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &DSN{User: "user", Password: "p@ss", Namespace: "ns", DB: "db", ConfigFile: "/etc/gaea.ini", Loc: time.UTC}
+	if !reflect.DeepEqual(d, expect) {
+		t.Errorf("dsn: %+v", d)
+	}
+
+	d, err = ParseDSN("gosharding://user@ns?config=gaea.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.DB != "" || d.Password != "" {
+		t.Errorf("dsn: %+v", d)
+	}
+
+	d, err = ParseDSN("gosharding://user@ns?config=gaea.ini&native_types=true&loc=Asia%2FShanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.NativeTypes || d.Loc.String() != "Asia/Shanghai" {
+		t.Errorf("dsn: %+v", d)
+	}
+
+	for _, dsn := range []string{"mysql://user@ns/db?config=gaea.ini", "gosharding://user@/db?config=gaea.ini", "gosharding://user@ns/db",
+		"gosharding://user@ns/db?config=gaea.ini&native_types=yes", "gosharding://user@ns/db?config=gaea.ini&loc=Mars"} {
+		if _, err := ParseDSN(dsn); err == nil {
+			t.Errorf("invalid dsn %s is not checked", dsn)
+		}
+	}
+}
+
+func TestConvertArgs(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	values, err := convertArgs([]driver.NamedValue{
+		{Ordinal: 1, Value: nil},
+		{Ordinal: 2, Value: int64(1)},
+		{Ordinal: 3, Value: "a'b"},
+		{Ordinal: 4, Value: true},
+		{Ordinal: 5, Value: ts},
+		{Ordinal: 6, Value: uint64(1 << 63)},
+		{Ordinal: 7, Value: 90 * time.Minute},
+		{Ordinal: 8, Value: big.NewInt(-12)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []interface{}{nil, int64(1), []byte("a'b"), int64(1), []byte("2020-01-02 03:04:05.000006"),
+		uint64(1 << 63), []byte("01:30:00"), mysql.NewDecimalFromInt(-12)}
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("values: %v", values)
+	}
+
+	if _, err := convertArgs([]driver.NamedValue{{Name: "id", Ordinal: 1, Value: int64(1)}}); err == nil {
+		t.Errorf("named arg is not checked")
+	}
+}
+
+func TestConvertListArgs(t *testing.T) {
+	c := &conn{}
+	nv := &driver.NamedValue{Ordinal: 1, Value: server.ListArg{1, "a", server.ListArg{int32(2), true}}}
+	if err := c.CheckNamedValue(nv); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CheckNamedValue(&driver.NamedValue{Ordinal: 2, Value: "a"}); err != driver.ErrSkip {
+		t.Errorf("other args should be converted by database/sql, err: %v", err)
+	}
+	if err := c.CheckNamedValue(&driver.NamedValue{Ordinal: 3, Value: time.Second}); err != nil {
+		t.Errorf("duration should be converted as TIME, err: %v", err)
+	}
+
+	values, err := convertArgs([]driver.NamedValue{*nv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []interface{}{server.ListArg{int64(1), []byte("a"), server.ListArg{int64(2), int64(1)}}}
+	if !reflect.DeepEqual(values, expect) {
+		t.Errorf("values: %v", values)
+	}
+
+	if _, err := convertArgs([]driver.NamedValue{{Ordinal: 1, Value: server.ListArg{struct{}{}}}}); err == nil {
+		t.Errorf("unsupported item of list is not checked")
+	}
+}
+
+func TestRows(t *testing.T) {
+	r := &mysql.Result{Resultset: &mysql.Resultset{
+		Fields: []*mysql.Field{{Name: []byte("id")}, {Name: []byte("name")}},
+		Values: [][]interface{}{{uint64(1), []byte("a")}, {uint64(1 << 63), nil}},
+	}}
+	rs := newRows(r)
+	if !reflect.DeepEqual(rs.Columns(), []string{"id", "name"}) {
+		t.Errorf("columns: %v", rs.Columns())
+	}
+	dest := make([]driver.Value, 2)
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dest, []driver.Value{int64(1), []byte("a")}) {
+		t.Errorf("row: %v", dest)
+	}
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dest, []driver.Value{[]byte("9223372036854775808"), nil}) {
+		t.Errorf("row: %v", dest)
+	}
+	if err := rs.Next(dest); err != io.EOF {
+		t.Errorf("rows are not finished")
+	}
+
+	if len(newRows(&mysql.Result{}).Columns()) != 0 {
+		t.Errorf("columns of result without resultset")
+	}
+}
+
+func TestNativeRows(t *testing.T) {
+	r := &mysql.Result{Resultset: &mysql.Resultset{
+		Fields: []*mysql.Field{
+			{Name: []byte("d"), Type: mysql.TypeDatetime},
+			{Name: []byte("b"), Type: mysql.TypeBit, ColumnLength: 1},
+			{Name: []byte("n"), Type: mysql.TypeNewDecimal, Decimal: 2},
+			{Name: []byte("t"), Type: mysql.TypeDuration},
+		},
+		Values: [][]interface{}{
+			{"2020-01-02 03:04:05", []byte{1}, 1.5, "-01:00:00"},
+			{"0000-00-00 00:00:00", []byte{0}, nil, nil},
+		},
+	}}
+	loc := time.FixedZone("UTC+8", 8*3600)
+	rs := newRows(r)
+	rs.fields, rs.loc = r.Fields, loc
+
+	dest := make([]driver.Value, 4)
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if tm, ok := dest[0].(time.Time); !ok || !tm.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, loc)) {
+		t.Errorf("datetime: %v", dest[0])
+	}
+	if !reflect.DeepEqual(dest[1:], []driver.Value{true, []byte("1.50"), "-01:00:00"}) {
+		t.Errorf("row: %v", dest)
+	}
+	if err := rs.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dest, []driver.Value{time.Time{}, false, nil, nil}) {
+		t.Errorf("row: %v", dest)
+	}
+
+	r.Values = [][]interface{}{{"2020-13-01", nil, nil, nil}}
+	rs = newRows(r)
+	rs.fields, rs.loc = r.Fields, loc
+	if err := rs.Next(dest); err == nil {
+		t.Errorf("invalid datetime is not checked")
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tenant implements row level isolation of multi-tenant tables.
+// Condition of tenant column is injected into statements of multi-tenant tables before statements are planned,
+// and statements which could read or write rows of other tenants are rejected.
+package tenant
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/opcode"
+	driver "github.com/pingcap/tidb/types/parser_driver"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+// Isolation inject tenant condition into statements of multi-tenant tables of a namespace
+type Isolation struct {
+	column string
+	tables map[string]bool // key: db.table
+}
+
+// NewIsolation constructor of Isolation
+func NewIsolation(cfg *models.Tenant) *Isolation {
+	t := &Isolation{
+		column: strings.ToLower(cfg.GetColumn()),
+		tables: make(map[string]bool, len(cfg.Tables)),
+	}
+	for _, table := range cfg.Tables {
+		t.tables[strings.ToLower(table)] = true
+	}
+	return t
+}
+
+// GetColumn return column of tenant id
+func (t *Isolation) GetColumn() string {
+	return t.column
+}
+
+func (t *Isolation) isTenantTable(db string, name *ast.TableName) bool {
+	if name.Schema.L != "" {
+		db = name.Schema.L
+	}
+	return t.tables[db+"."+name.Name.L]
+}
+
+// rewriter inject tenant condition into each SELECT, UPDATE and DELETE of the statement, including subqueries
+type rewriter struct {
+	t        *Isolation
+	db       string
+	tenantID string
+	changed  bool
+	err      error
+}
+
+// Rewrite inject tenant condition into statement in place, return true if the statement is changed.
+// INSERT and REPLACE of multi-tenant tables are filled with tenant id, and rejected if other tenant id is written.
+// Statements of multi-tenant tables are rejected if tenantID is empty.
+func (t *Isolation) Rewrite(stmt ast.StmtNode, db, tenantID string) (bool, error) {
+	switch s := stmt.(type) {
+	case *ast.SelectStmt, *ast.UnionStmt, *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+		r := &rewriter{t: t, db: db, tenantID: tenantID}
+		stmt.Accept(r)
+		return r.changed, r.err
+	case *ast.ExplainStmt:
+		return t.Rewrite(s.Stmt, db, tenantID)
+	default:
+		// DDL and other statements can't be isolated, they are only allowed in sessions without tenant id
+		c := &tableChecker{t: t, db: db}
+		stmt.Accept(c)
+		if c.found != "" && tenantID != "" {
+			return false, fmt.Errorf("statement of multi-tenant table %s is not allowed with tenant id", c.found)
+		}
+		return false, nil
+	}
+}
+
+func (r *rewriter) checkTenant(name *ast.TableName) error {
+	if r.tenantID == "" {
+		return fmt.Errorf("tenant id is required to access multi-tenant table %s", name.Name.O)
+	}
+	return nil
+}
+
+// tenantValue return tenant id as integer if possible, so it can be used to route integer tenant column
+func (r *rewriter) tenantValue() ast.ExprNode {
+	if v, err := strconv.ParseInt(r.tenantID, 10, 64); err == nil {
+		return ast.NewValueExpr(v, "", "")
+	}
+	return ast.NewValueExpr(r.tenantID, "", "")
+}
+
+func (r *rewriter) isTenantValue(expr ast.ExprNode) bool {
+	v, ok := expr.(*driver.ValueExpr)
+	if !ok {
+		return false
+	}
+	value, err := util.GetValueExprResult(v)
+	return err == nil && value != nil && fmt.Sprintf("%v", value) == r.tenantID
+}
+
+// Enter implement ast.Visitor
+func (r *rewriter) Enter(n ast.Node) (ast.Node, bool) {
+	if r.err != nil {
+		return n, true
+	}
+	switch nn := n.(type) {
+	case *ast.SelectStmt:
+		if nn.From != nil {
+			nn.Where, r.err = r.addConditions(nn.From.TableRefs, nn.Where)
+		}
+	case *ast.UpdateStmt:
+		for _, a := range nn.List {
+			if a.Column.Name.L == r.t.column && r.hasTenantTable(nn.TableRefs.TableRefs) {
+				r.err = fmt.Errorf("column %s of multi-tenant table can not be updated", r.t.column)
+				return n, true
+			}
+		}
+		nn.Where, r.err = r.addConditions(nn.TableRefs.TableRefs, nn.Where)
+	case *ast.DeleteStmt:
+		nn.Where, r.err = r.addConditions(nn.TableRefs.TableRefs, nn.Where)
+	case *ast.InsertStmt:
+		r.err = r.rewriteInsert(nn)
+	}
+	return n, r.err != nil
+}
+
+// Leave implement ast.Visitor
+func (r *rewriter) Leave(n ast.Node) (ast.Node, bool) {
+	return n, r.err == nil
+}
+
+func (r *rewriter) hasTenantTable(join *ast.Join) bool {
+	c := &tableChecker{t: r.t, db: r.db}
+	join.Accept(c)
+	return c.found != ""
+}
+
+func (r *rewriter) rewriteInsert(stmt *ast.InsertStmt) error {
+	ts, ok := stmt.Table.TableRefs.Left.(*ast.TableSource)
+	if !ok {
+		return nil
+	}
+	name, ok := ts.Source.(*ast.TableName)
+	if !ok || !r.t.isTenantTable(r.db, name) {
+		return nil
+	}
+	if err := r.checkTenant(name); err != nil {
+		return err
+	}
+	if stmt.Select != nil {
+		return fmt.Errorf("INSERT ... SELECT is not supported for multi-tenant table %s", name.Name.O)
+	}
+	for _, a := range stmt.OnDuplicate {
+		if a.Column.Name.L == r.t.column {
+			return fmt.Errorf("column %s of multi-tenant table can not be updated", r.t.column)
+		}
+	}
+
+	r.changed = true
+	if len(stmt.Setlist) != 0 {
+		for _, a := range stmt.Setlist {
+			if a.Column.Name.L != r.t.column {
+				continue
+			}
+			if !r.isTenantValue(a.Expr) {
+				return fmt.Errorf("value of %s must be tenant id of session", r.t.column)
+			}
+			return nil
+		}
+		stmt.Setlist = append(stmt.Setlist, &ast.Assignment{
+			Column: &ast.ColumnName{Name: model.NewCIStr(r.t.column)},
+			Expr:   r.tenantValue(),
+		})
+		return nil
+	}
+
+	if len(stmt.Columns) == 0 {
+		return fmt.Errorf("column list is required to insert into multi-tenant table %s", name.Name.O)
+	}
+	for i, c := range stmt.Columns {
+		if c.Name.L != r.t.column {
+			continue
+		}
+		for _, row := range stmt.Lists {
+			if i >= len(row) || !r.isTenantValue(row[i]) {
+				return fmt.Errorf("value of %s must be tenant id of session", r.t.column)
+			}
+		}
+		return nil
+	}
+	stmt.Columns = append(stmt.Columns, &ast.ColumnName{Name: model.NewCIStr(r.t.column)})
+	for i := range stmt.Lists {
+		stmt.Lists[i] = append(stmt.Lists[i], r.tenantValue())
+	}
+	return nil
+}
+
+// addConditions add tenant conditions of tables in join to where
+func (r *rewriter) addConditions(join *ast.Join, where ast.ExprNode) (ast.ExprNode, error) {
+	if join == nil {
+		return where, nil
+	}
+	// column is not qualified in single table statement
+	single := join.Right == nil
+	conditions, err := r.joinConditions(join, single)
+	if err != nil || len(conditions) == 0 {
+		return where, err
+	}
+	r.changed = true
+	return and(where, conditions), nil
+}
+
+// joinConditions return tenant conditions of tables in join, conditions of tables in inner side of outer join
+// are added to ON clause of the join, so rows of outer table are still returned.
+func (r *rewriter) joinConditions(node ast.ResultSetNode, single bool) ([]ast.ExprNode, error) {
+	switch n := node.(type) {
+	case *ast.TableSource:
+		name, ok := n.Source.(*ast.TableName)
+		if !ok || !r.t.isTenantTable(r.db, name) {
+			return nil, nil
+		}
+		if err := r.checkTenant(name); err != nil {
+			return nil, err
+		}
+		column := &ast.ColumnName{Name: model.NewCIStr(r.t.column)}
+		if !single {
+			if n.AsName.L != "" {
+				column.Table = n.AsName
+			} else {
+				column.Schema, column.Table = name.Schema, name.Name
+			}
+		}
+		return []ast.ExprNode{&ast.BinaryOperationExpr{Op: opcode.EQ, L: &ast.ColumnNameExpr{Name: column}, R: r.tenantValue()}}, nil
+	case *ast.Join:
+		left, err := r.joinConditions(n.Left, single)
+		if err != nil {
+			return nil, err
+		}
+		var right []ast.ExprNode
+		if n.Right != nil {
+			if right, err = r.joinConditions(n.Right, single); err != nil {
+				return nil, err
+			}
+		}
+		switch n.Tp {
+		case ast.LeftJoin:
+			return left, r.addOnConditions(n, right)
+		case ast.RightJoin:
+			return right, r.addOnConditions(n, left)
+		default:
+			return append(left, right...), nil
+		}
+	default:
+		return nil, nil
+	}
+}
+
+func (r *rewriter) addOnConditions(join *ast.Join, conditions []ast.ExprNode) error {
+	if len(conditions) == 0 {
+		return nil
+	}
+	if join.On == nil {
+		return fmt.Errorf("outer join of multi-tenant table requires ON clause")
+	}
+	join.On.Expr = and(join.On.Expr, conditions)
+	return nil
+}
+
+// and return expr AND conditions, expr is enclosed in parentheses to keep its precedence
+func and(expr ast.ExprNode, conditions []ast.ExprNode) ast.ExprNode {
+	if _, ok := expr.(*ast.ParenthesesExpr); !ok && expr != nil {
+		expr = &ast.ParenthesesExpr{Expr: expr}
+	}
+	for _, c := range conditions {
+		if expr == nil {
+			expr = c
+		} else {
+			expr = &ast.BinaryOperationExpr{Op: opcode.LogicAnd, L: expr, R: c}
+		}
+	}
+	return expr
+}
+
+// tableChecker find multi-tenant table in statement
+type tableChecker struct {
+	t     *Isolation
+	db    string
+	found string // name of the first multi-tenant table found
+}
+
+// Enter implement ast.Visitor
+func (c *tableChecker) Enter(n ast.Node) (ast.Node, bool) {
+	if name, ok := n.(*ast.TableName); ok && c.t.isTenantTable(c.db, name) {
+		c.found = name.Name.O
+		return n, true
+	}
+	return n, c.found != ""
+}
+
+// Leave implement ast.Visitor
+func (c *tableChecker) Leave(n ast.Node) (ast.Node, bool) {
+	return n, c.found == ""
+}
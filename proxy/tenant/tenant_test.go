@@ -0,0 +1,139 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenant
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap/parser/format"
+
+	"github.com/XiaoMi/Gaea/models"
+	"github.com/XiaoMi/Gaea/parser"
+	"github.com/XiaoMi/Gaea/util"
+)
+
+func newTestIsolation() *Isolation {
+	return NewIsolation(&models.Tenant{Tables: []string{"db.orders", "db.users"}})
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		sql     string
+		expect  string
+		changed bool
+	}{
+		{
+			"select * from orders where id = 1 or status = 2",
+			"SELECT * FROM `orders` WHERE (`id`=1 OR `status`=2) AND `tenant_id`=42",
+			true,
+		},
+		{
+			"select * from orders",
+			"SELECT * FROM `orders` WHERE `tenant_id`=42",
+			true,
+		},
+		{
+			"select o.id from orders o join db.users on o.user_id = users.id left join users u2 on o.ref_id = u2.id where o.id = 1",
+			"SELECT `o`.`id` FROM (`orders` AS `o` JOIN `db`.`users` ON `o`.`user_id`=`users`.`id`) LEFT JOIN `users` AS `u2` ON (`o`.`ref_id`=`u2`.`id`) AND `u2`.`tenant_id`=42 " +
+				"WHERE (`o`.`id`=1) AND `o`.`tenant_id`=42 AND `db`.`users`.`tenant_id`=42",
+			true,
+		},
+		{
+			"select * from items where order_id in (select id from orders)",
+			"SELECT * FROM `items` WHERE `order_id` IN (SELECT `id` FROM `orders` WHERE `tenant_id`=42)",
+			true,
+		},
+		{
+			"update orders set status = 1 where id = 1",
+			"UPDATE `orders` SET `status`=1 WHERE (`id`=1) AND `tenant_id`=42",
+			true,
+		},
+		{
+			"delete from orders",
+			"DELETE FROM `orders` WHERE `tenant_id`=42",
+			true,
+		},
+		{
+			"insert into orders (id, status) values (1, 2), (2, 3)",
+			"INSERT INTO `orders` (`id`,`status`,`tenant_id`) VALUES (1,2,42),(2,3,42)",
+			true,
+		},
+		{
+			"insert into orders (id, tenant_id) values (1, '42')",
+			"INSERT INTO `orders` (`id`,`tenant_id`) VALUES (1,'42')",
+			true,
+		},
+		{
+			"insert into orders set id = 1",
+			"INSERT INTO `orders` SET `id`=1,`tenant_id`=42",
+			true,
+		},
+		{
+			"select * from other.orders",
+			"SELECT * FROM `other`.`orders`",
+			false,
+		},
+	}
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		changed, err := newTestIsolation().Rewrite(stmt, "db", "42")
+		if err != nil {
+			t.Errorf("rewrite %s error: %v", test.sql, err)
+			continue
+		}
+		s := &strings.Builder{}
+		_ = stmt.Restore(format.NewRestoreCtx(util.EscapeRestoreFlags, s))
+		if s.String() != test.expect || changed != test.changed {
+			t.Errorf("rewrite %s, expect %s %v, got %s %v", test.sql, test.expect, test.changed, s.String(), changed)
+		}
+	}
+}
+
+func TestRewriteError(t *testing.T) {
+	tests := []struct {
+		sql      string
+		tenantID string
+	}{
+		{"select * from orders", ""},
+		{"insert into orders (id) values (1)", ""},
+		{"insert into orders (id, tenant_id) values (1, 43)", "42"},
+		{"insert into orders set id = 1, tenant_id = 43", "42"},
+		{"insert into orders values (1, 42)", "42"},
+		{"insert into orders (id) select id from items", "42"},
+		{"insert into orders (id) values (1) on duplicate key update tenant_id = 43", "42"},
+		{"update orders set tenant_id = 43 where id = 1", "42"},
+		{"select * from items left join orders using (id)", "42"},
+		{"truncate table orders", "42"},
+	}
+	for _, test := range tests {
+		stmt, err := parser.ParseSQL(test.sql)
+		if err != nil {
+			t.Fatalf("parse sql error: %v", err)
+		}
+		if _, err := newTestIsolation().Rewrite(stmt, "db", test.tenantID); err == nil {
+			t.Errorf("rewrite %s with tenant id %s should fail", test.sql, test.tenantID)
+		}
+	}
+
+	// DDL is allowed in sessions without tenant id
+	stmt, _ := parser.ParseSQL("truncate table orders")
+	if _, err := newTestIsolation().Rewrite(stmt, "db", ""); err != nil {
+		t.Errorf("truncate table without tenant id error: %v", err)
+	}
+}
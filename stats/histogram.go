@@ -19,6 +19,7 @@ package stats
 import (
 	"bytes"
 	"fmt"
+	"math"
 
 	"github.com/XiaoMi/Gaea/util/sync2"
 )
@@ -163,6 +164,33 @@ func (h *Histogram) Buckets() []int64 {
 	return buckets
 }
 
+// Quantile returns the estimated value of quantile q (0 < q <= 1).
+// The estimation is the cutoff of the bucket which the quantile falls in,
+// the highest cutoff is returned if the quantile falls in the "inf" bucket.
+func (h *Histogram) Quantile(q float64) int64 {
+	buckets := h.Buckets()
+	var count int64
+	for _, b := range buckets {
+		count += b
+	}
+	if count == 0 || len(h.cutoffs) == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var cumulative int64
+	for i, b := range buckets {
+		cumulative += b
+		if cumulative >= rank && i < len(h.cutoffs) {
+			return h.cutoffs[i]
+		}
+	}
+	return h.cutoffs[len(h.cutoffs)-1]
+}
+
 // Help returns the help string.
 func (h *Histogram) Help() string {
 	return h.help
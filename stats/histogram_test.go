@@ -87,3 +87,24 @@ func TestHistogramHook(t *testing.T) {
 		t.Errorf("got %#v, want %#v", gotv, v)
 	}
 }
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram("", "help", []int64{1, 5})
+	if got := h.Quantile(0.5); got != 0 {
+		t.Errorf("quantile of empty histogram: got %d, want 0", got)
+	}
+	for i := 0; i < 10; i++ {
+		h.Add(int64(i))
+	}
+	for q, want := range map[float64]int64{
+		0.1:  1,
+		0.2:  1,
+		0.5:  5,
+		0.6:  5,
+		0.99: 5,
+	} {
+		if got := h.Quantile(q); got != want {
+			t.Errorf("quantile %v: got %d, want %d", q, got, want)
+		}
+	}
+}
@@ -117,6 +117,15 @@ func (t *Timings) String() string {
 	return string(data)
 }
 
+// ResetAll clears all histograms of Timings.
+func (t *Timings) ResetAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.histograms = make(map[string]*Histogram)
+	t.totalCount.Set(0)
+	t.totalTime.Set(0)
+}
+
 // Histograms returns a map pointing at the histograms.
 func (t *Timings) Histograms() (h map[string]*Histogram) {
 	t.mu.RLock()
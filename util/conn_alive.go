@@ -0,0 +1,59 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux darwin
+
+package util
+
+import (
+	"net"
+	"syscall"
+)
+
+// IsConnAlive check if the peer of connection is still alive by peeking the socket without blocking,
+// data in the socket will not be consumed. It must not be called concurrently with reading of the connection.
+func IsConnAlive(c net.Conn) bool {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return true
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return true
+	}
+
+	alive := true
+	buf := make([]byte, 1)
+	err = rc.Read(func(fd uintptr) bool {
+		n, _, e := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		switch {
+		case e == syscall.EAGAIN || e == syscall.EWOULDBLOCK:
+			// no data, connection is idle
+		case e == syscall.EINTR:
+			// interrupted, check it next time
+		case e != nil:
+			// ECONNRESET etc.
+			alive = false
+		case n == 0:
+			// EOF, peer has closed the connection
+			alive = false
+		}
+		// always return true, we don't want to wait for the socket to be readable
+		return true
+	})
+	if err != nil {
+		return false
+	}
+	return alive
+}
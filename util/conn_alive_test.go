@@ -0,0 +1,64 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux darwin
+
+package util
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsConnAlive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept error: %v", err)
+	}
+	defer server.Close()
+
+	if !IsConnAlive(server) {
+		t.Errorf("idle connection should be alive")
+	}
+
+	// pending data should not be consumed
+	if _, err := client.Write([]byte("x")); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if !IsConnAlive(server) {
+		t.Errorf("connection with pending data should be alive")
+	}
+	buf := make([]byte, 1)
+	if n, err := server.Read(buf); n != 1 || err != nil || buf[0] != 'x' {
+		t.Errorf("pending data should not be consumed, n: %d, err: %v", n, err)
+	}
+
+	client.Close()
+	time.Sleep(10 * time.Millisecond)
+	if IsConnAlive(server) {
+		t.Errorf("connection closed by peer should not be alive")
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptGCM(t *testing.T) {
+	key := []byte("1234abcd5678efg*")
+	msg := []byte("13800000000")
+
+	d1, err := EncryptGCM(key, msg, true)
+	if err != nil {
+		t.Fatalf("encrypt failed, err:%v", err)
+	}
+	d2, _ := EncryptGCM(key, msg, true)
+	if !bytes.Equal(d1, d2) {
+		t.Errorf("deterministic encryption should return the same data")
+	}
+	r1, _ := EncryptGCM(key, msg, false)
+	r2, _ := EncryptGCM(key, msg, false)
+	if bytes.Equal(r1, r2) {
+		t.Errorf("random encryption should return different data")
+	}
+
+	for _, data := range [][]byte{d1, r1} {
+		origin, err := DecryptGCM(key, data)
+		if err != nil {
+			t.Fatalf("decrypt failed, err:%v", err)
+		}
+		if !bytes.Equal(origin, msg) {
+			t.Errorf("origin not equal msg, got %s", origin)
+		}
+	}
+
+	d1[len(d1)-1] ^= 1
+	if _, err := DecryptGCM(key, d1); err == nil {
+		t.Errorf("decrypt modified data should fail")
+	}
+	if _, err := DecryptGCM(key, []byte("short")); err == nil {
+		t.Errorf("decrypt short data should fail")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package failpoint provides fault injection hooks for chaos testing.
+// All failpoints are disabled by default, and can be enabled at runtime through admin api.
+package failpoint
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// names of failpoints
+const (
+	// BackendConnectError make connecting to backend mysql fail
+	BackendConnectError = "backend-connect-error"
+	// BackendSlowResponse delay the response of backend mysql
+	BackendSlowResponse = "backend-slow-response"
+	// BackendPacketCorruption make packet read from backend mysql malformed
+	BackendPacketCorruption = "backend-packet-corruption"
+	// BackendResultDisconnect close backend connection while reading result rows
+	BackendResultDisconnect = "backend-result-disconnect"
+)
+
+var supportedFailpoints = map[string]struct{}{
+	BackendConnectError:     {},
+	BackendSlowResponse:     {},
+	BackendPacketCorruption: {},
+	BackendResultDisconnect: {},
+}
+
+// Term means the action of an enabled failpoint
+type Term struct {
+	Probability float64 `json:"probability"` // 触发概率, 取值范围(0, 1]
+	DelayMs     int64   `json:"delay_ms"`    // 仅对慢响应类型的failpoint有效
+}
+
+// Delay return delay duration of term
+func (t *Term) Delay() time.Duration {
+	return time.Duration(t.DelayMs) * time.Millisecond
+}
+
+var (
+	lock     sync.RWMutex
+	terms    = make(map[string]*Term)
+	randLock sync.Mutex
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Enable enable failpoint with the given term
+func Enable(name string, term *Term) error {
+	if _, ok := supportedFailpoints[name]; !ok {
+		return fmt.Errorf("unsupported failpoint: %s", name)
+	}
+	if term == nil {
+		return fmt.Errorf("failpoint term is nil")
+	}
+	if term.Probability <= 0 || term.Probability > 1 {
+		return fmt.Errorf("invalid probability of failpoint %s: %v", name, term.Probability)
+	}
+	if term.DelayMs < 0 {
+		return fmt.Errorf("invalid delay of failpoint %s: %v", name, term.DelayMs)
+	}
+
+	t := *term
+	lock.Lock()
+	terms[name] = &t
+	lock.Unlock()
+	return nil
+}
+
+// Disable disable failpoint
+func Disable(name string) {
+	lock.Lock()
+	delete(terms, name)
+	lock.Unlock()
+}
+
+// DisableAll disable all failpoints
+func DisableAll() {
+	lock.Lock()
+	terms = make(map[string]*Term)
+	lock.Unlock()
+}
+
+// List return copy of all enabled failpoints
+func List() map[string]Term {
+	lock.RLock()
+	defer lock.RUnlock()
+	ret := make(map[string]Term, len(terms))
+	for name, t := range terms {
+		ret[name] = *t
+	}
+	return ret
+}
+
+// Supported return names of all supported failpoints
+func Supported() []string {
+	ret := make([]string, 0, len(supportedFailpoints))
+	for name := range supportedFailpoints {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Eval check if the failpoint is triggered, return term if triggered
+func Eval(name string) (*Term, bool) {
+	lock.RLock()
+	t, ok := terms[name]
+	lock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	randLock.Lock()
+	v := random.Float64()
+	randLock.Unlock()
+	if v >= t.Probability {
+		return nil, false
+	}
+	return t, true
+}
+
+// InjectError return an error if the failpoint is triggered
+func InjectError(name string) error {
+	if _, ok := Eval(name); ok {
+		return fmt.Errorf("failpoint %s injected", name)
+	}
+	return nil
+}
+
+// InjectDelay sleep for the delay of term if the failpoint is triggered
+func InjectDelay(name string) {
+	if t, ok := Eval(name); ok {
+		time.Sleep(t.Delay())
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package failpoint
+
+import (
+	"testing"
+)
+
+func TestEnableAndEval(t *testing.T) {
+	defer DisableAll()
+
+	if _, ok := Eval(BackendConnectError); ok {
+		t.Fatalf("failpoint should be disabled by default")
+	}
+
+	if err := Enable(BackendConnectError, &Term{Probability: 1}); err != nil {
+		t.Fatalf("enable failpoint error: %v", err)
+	}
+	if err := InjectError(BackendConnectError); err == nil {
+		t.Fatalf("failpoint with probability 1 should always be triggered")
+	}
+
+	Disable(BackendConnectError)
+	if err := InjectError(BackendConnectError); err != nil {
+		t.Fatalf("disabled failpoint should not be triggered, err: %v", err)
+	}
+}
+
+func TestEnableInvalid(t *testing.T) {
+	defer DisableAll()
+
+	tests := []struct {
+		name string
+		term *Term
+	}{
+		{"not-exist", &Term{Probability: 1}},
+		{BackendSlowResponse, nil},
+		{BackendSlowResponse, &Term{Probability: 0}},
+		{BackendSlowResponse, &Term{Probability: 1.5}},
+		{BackendSlowResponse, &Term{Probability: 1, DelayMs: -1}},
+	}
+	for _, test := range tests {
+		if err := Enable(test.name, test.term); err == nil {
+			t.Errorf("enable %s with %v should fail", test.name, test.term)
+		}
+	}
+	if len(List()) != 0 {
+		t.Errorf("no failpoint should be enabled, got: %v", List())
+	}
+}
+
+func TestList(t *testing.T) {
+	defer DisableAll()
+
+	if err := Enable(BackendSlowResponse, &Term{Probability: 0.5, DelayMs: 100}); err != nil {
+		t.Fatalf("enable failpoint error: %v", err)
+	}
+	l := List()
+	term, ok := l[BackendSlowResponse]
+	if !ok || term.Probability != 0.5 || term.Delay().Milliseconds() != 100 {
+		t.Errorf("list failpoints error: %v", l)
+	}
+	if len(Supported()) != 4 {
+		t.Errorf("supported failpoints error: %v", Supported())
+	}
+}
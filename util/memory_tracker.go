@@ -0,0 +1,50 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sync/atomic"
+)
+
+// MemoryTracker track memory used by rows buffered in one statement, it's safe for concurrent use
+type MemoryTracker struct {
+	limit int64
+	used  int64
+}
+
+// NewMemoryTracker create MemoryTracker with limit in bytes
+func NewMemoryTracker(limit int64) *MemoryTracker {
+	return &MemoryTracker{limit: limit}
+}
+
+// Consume add n bytes to used memory, return false if the limit is exceeded
+func (t *MemoryTracker) Consume(n int64) bool {
+	return atomic.AddInt64(&t.used, n) <= t.limit
+}
+
+// Release subtract n bytes from used memory
+func (t *MemoryTracker) Release(n int64) {
+	atomic.AddInt64(&t.used, -n)
+}
+
+// Used return used memory in bytes
+func (t *MemoryTracker) Used() int64 {
+	return atomic.LoadInt64(&t.used)
+}
+
+// Limit return the memory limit in bytes
+func (t *MemoryTracker) Limit() int64 {
+	return t.limit
+}
@@ -0,0 +1,36 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+)
+
+func TestMemoryTracker(t *testing.T) {
+	tracker := NewMemoryTracker(100)
+	if !tracker.Consume(60) {
+		t.Fatalf("consume 60 bytes should not exceed limit")
+	}
+	if tracker.Consume(50) {
+		t.Fatalf("consume 110 bytes should exceed limit")
+	}
+	tracker.Release(50)
+	if tracker.Used() != 60 {
+		t.Errorf("used memory error, expect 60, got: %d", tracker.Used())
+	}
+	if !tracker.Consume(40) {
+		t.Errorf("consume 100 bytes should not exceed limit")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter token bucket limiter, tokens are added at rate per second and at most rate tokens are kept,
+// so bursts of one second are allowed. It's safe for concurrent use.
+type RateLimiter struct {
+	lock   sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter create RateLimiter with rate per second, the bucket is full at first
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// Rate return tokens added per second
+func (l *RateLimiter) Rate() int64 {
+	return l.rate
+}
+
+// Allow take one token, return false if no token left
+func (l *RateLimiter) Allow() bool {
+	return l.allowAt(time.Now())
+}
+
+func (l *RateLimiter) allowAt(now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+		l.last = now
+	}
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(10)
+	now := l.last
+
+	// burst of one second
+	for i := 0; i < 10; i++ {
+		if !l.allowAt(now) {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	if l.allowAt(now) {
+		t.Errorf("request should be limited when tokens are used up")
+	}
+
+	// one token is added every 100ms
+	now = now.Add(150 * time.Millisecond)
+	if !l.allowAt(now) {
+		t.Errorf("request should be allowed after token is added")
+	}
+	if l.allowAt(now) {
+		t.Errorf("request should be limited")
+	}
+
+	// tokens are not accumulated more than rate
+	now = now.Add(time.Hour)
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if l.allowAt(now) {
+			allowed++
+		}
+	}
+	if allowed != 10 {
+		t.Errorf("allowed requests should be 10, got: %d", allowed)
+	}
+}
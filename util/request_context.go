@@ -23,6 +23,24 @@ const (
 	StmtType = "stmtType" // SQL类型, 值类型为int (对应parser.Preview()得到的值)
 	// FromSlave if read from slave
 	FromSlave = "fromSlave" // 读写分离标识, 值类型为int, false = 0, true = 1
+	// ShardCount count of sharding SQLs sent to backend
+	ShardCount = "shardCount" // 下发到后端的SQL数量, 值类型为int
+	// RowCount rows returned or affected
+	RowCount = "rowCount" // 返回或影响的行数, 值类型为int64
+	// FoundRows total rows of SELECT SQL_CALC_FOUND_ROWS
+	FoundRows = "foundRows" // SQL_CALC_FOUND_ROWS计算的不带LIMIT的总行数, 值类型为uint64
+	// TraceID trace id of request
+	TraceID = "traceID" // 请求的追踪ID, 值类型为string, 会以注释形式附加到后端SQL中
+	// Shards shards the statement is routed to
+	Shards = "shards" // 语句下发到的分片, 值类型为[]string, 格式为slice:db
+	// TraceSpan root span of request
+	TraceSpan = "traceSpan" // 请求的根span, 值类型为trace.Span, 后端SQL的span作为其子span
+	// ConnectionID id of client connection
+	ConnectionID = "connectionID" // 客户端连接ID, 值类型为uint32
+	// Deadline deadline of statement execution
+	Deadline = "deadline" // 语句执行的截止时间, 值类型为time.Time, 未设置表示不限制
+	// QueryMemoryTracker memory tracker of statement execution
+	QueryMemoryTracker = "memoryTracker" // 语句缓存结果集的内存统计, 值类型为*MemoryTracker, 未设置表示不限制
 )
 
 // RequestContext means request scope context with values
@@ -0,0 +1,64 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var traceIDSeq uint64
+
+// NewTraceID generate a random trace id of 16 hex characters
+func NewTraceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// fallback to time and sequence if random source is unavailable
+		seq := atomic.AddUint64(&traceIDSeq, 1)
+		return strconv.FormatInt(time.Now().UnixNano(), 16) + strconv.FormatUint(seq, 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// GetTraceID return trace id in request context, return empty string if not set
+func GetTraceID(reqCtx *RequestContext) string {
+	if reqCtx == nil {
+		return ""
+	}
+	if id, ok := reqCtx.Get(TraceID).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// AttachTraceID add trace id comment before sql, return the origin sql if trace id not set
+func AttachTraceID(reqCtx *RequestContext, sql string) string {
+	return AttachTraceSpan(reqCtx, "", sql)
+}
+
+// AttachTraceSpan add trace id and span id of the backend sql comment before sql, span id is omitted if empty
+func AttachTraceSpan(reqCtx *RequestContext, spanID string, sql string) string {
+	id := GetTraceID(reqCtx)
+	if id == "" {
+		return sql
+	}
+	if spanID == "" {
+		return "/* trace_id=" + id + " */ " + sql
+	}
+	return "/* trace_id=" + id + " span_id=" + spanID + " */ " + sql
+}
@@ -0,0 +1,41 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import "testing"
+
+func TestNewTraceID(t *testing.T) {
+	id1 := NewTraceID()
+	id2 := NewTraceID()
+	if len(id1) != 16 || id1 == id2 {
+		t.Errorf("invalid trace id: %s, %s", id1, id2)
+	}
+}
+
+func TestAttachTraceID(t *testing.T) {
+	sql := "select * from t"
+	reqCtx := NewRequestContext()
+	if got := AttachTraceID(reqCtx, sql); got != sql {
+		t.Errorf("sql should not be changed without trace id, got: %s", got)
+	}
+
+	reqCtx.Set(TraceID, "abc")
+	if got, want := AttachTraceID(reqCtx, sql), "/* trace_id=abc */ select * from t"; got != want {
+		t.Errorf("got: %s, want: %s", got, want)
+	}
+	if got, want := AttachTraceSpan(reqCtx, "0102", sql), "/* trace_id=abc span_id=0102 */ select * from t"; got != want {
+		t.Errorf("got: %s, want: %s", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 The Gaea Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing export OpenTelemetry spans of proxy to an OTLP collector.
+// Spans are not recorded if Init is not called.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/XiaoMi/Gaea"
+	shutdownTimeout     = 5 * time.Second
+)
+
+var provider *sdktrace.TracerProvider
+
+// Init export spans to OTLP gRPC endpoint, e.g. 127.0.0.1:4317,
+// sampleRatio is ratio of root spans sampled in (0, 1], 0 means sample all, child spans follow the root span
+func Init(endpoint, serviceName string, sampleRatio float64) error {
+	if sampleRatio < 0 || sampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample ratio: %v", sampleRatio)
+	}
+	if sampleRatio == 0 {
+		sampleRatio = 1
+	}
+	exporter, err := otlptracegrpc.New(context.Background(),
+		otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("create otlp exporter error: %v", err)
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes("", attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// Close flush spans not exported yet
+func Close() error {
+	if provider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return provider.Shutdown(ctx)
+}
+
+// StartSpan start span as child of parent, parent is nil for root span
+func StartSpan(parent trace.Span, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) trace.Span {
+	ctx := context.Background()
+	if parent != nil {
+		ctx = trace.ContextWithSpan(ctx, parent)
+	}
+	_, span := otel.Tracer(instrumentationName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	return span
+}
+
+// EndSpan end span with error status if err is not nil
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// GetTraceID return hex trace id of span, return empty string if span is not sampled
+func GetTraceID(span trace.Span) string {
+	if span == nil || !span.SpanContext().IsSampled() {
+		return ""
+	}
+	return span.SpanContext().TraceID().String()
+}
+
+// GetSpanID return hex span id of span, return empty string if span is not sampled
+func GetSpanID(span trace.Span) string {
+	if span == nil || !span.SpanContext().IsSampled() {
+		return ""
+	}
+	return span.SpanContext().SpanID().String()
+}